@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/devportaldata"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/keychain"
+)
+
+// apiKeyIdentifiers returns the App Store Connect API key ID and issuer ID,
+// the inputs take precedence over the connected account's data.
+func apiKeyIdentifiers(conf Config, devPortalData devportaldata.DevPortalData) (string, string, error) {
+	keyID, issuerID := conf.APIKeyID, conf.APIIssuerID
+	if keyID == "" {
+		keyID = devPortalData.KeyID
+	}
+	if issuerID == "" {
+		issuerID = devPortalData.IssuerID
+	}
+
+	if keyID == "" {
+		return "", "", fmt.Errorf("no API key ID provided, set the API key ID input or connect an App Store Connect API key")
+	}
+	if issuerID == "" {
+		return "", "", fmt.Errorf("no API issuer ID provided, set the API issuer ID input or connect an App Store Connect API key")
+	}
+	return keyID, issuerID, nil
+}
+
+// apiKeySigner returns the Signer of the external App Store Connect API private key,
+// the key is either stored in the keychain as a generic password, or held by an external signer command.
+func apiKeySigner(conf Config) (appstoreconnect.Signer, error) {
+	if conf.APIKeySignerCommand != "" {
+		return appstoreconnect.NewCommandSigner(conf.APIKeySignerCommand), nil
+	}
+
+	password, err := keychain.FindGenericPassword(conf.APIPrivateKeyKeychainItem, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API private key from the keychain: %s", err)
+	}
+
+	privateKey, err := appstoreconnect.ParsePrivateKey([]byte(keychainPrivateKeyContent(string(password))))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API private key in the keychain item (%s): %s", conf.APIPrivateKeyKeychainItem, err)
+	}
+	return appstoreconnect.CryptoSigner{Key: privateKey}, nil
+}
+
+// keychainPrivateKeyContent returns the .p8 content of a generic password:
+// multi-line contents are printed hex encoded by the security tool, and the PEM header might be omitted when stored.
+func keychainPrivateKeyContent(password string) string {
+	if !strings.Contains(password, "PRIVATE KEY") {
+		if decoded, err := hex.DecodeString(password); err == nil {
+			password = string(decoded)
+		}
+	}
+	return devportaldata.DevPortalData{PrivateKey: strings.TrimSpace(password)}.PrivateKeyWithHeader()
+}
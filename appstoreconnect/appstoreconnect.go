@@ -6,9 +6,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bitrise-io/bitrise-add-new-project/httputil"
@@ -20,6 +24,17 @@ import (
 const (
 	baseURL    = "https://api.appstoreconnect.apple.com/"
 	apiVersion = "v1"
+
+	// enterpriseBaseURL is the base URL of the Enterprise Program API, the API keys of Apple Developer Enterprise Program accounts work with
+	enterpriseBaseURL = "https://api.enterprise.developer.apple.com/"
+
+	appStoreConnectAudience = "appstoreconnect-v1"
+	enterpriseAudience      = "apple-developer-enterprise-v1"
+
+	defaultMaxAttempts = 4
+	defaultRetryWait   = 5 * time.Second
+	// maxRetryWait caps the backoff and the wait requested by the Retry-After header
+	maxRetryWait = 2 * time.Minute
 )
 
 // HTTPClient ...
@@ -38,13 +53,34 @@ type Client struct {
 	keyID             string
 	issuerID          string
 	privateKeyContent []byte
+	// signer signs the JWT token instead of the privateKeyContent, see NewClientWithSigner
+	signer Signer
 
+	// authMu guards the API key, the token and the authentication state, the client is used by concurrent requests
+	authMu      sync.Mutex
 	token       *jwt.Token
 	signedToken string
+	// audience is the audience of the JWT tokens, it differs for the Enterprise Program API, see UseEnterpriseAPI
+	audience string
+
+	// remoteToken authenticates the client on a provisioning server, see NewRemoteClient
+	remoteToken string
+
+	// failoverKey is used once the API key gets unauthorized, see SetFailoverKey
+	failoverKey *apiKey
+	// authenticated is set once the API key is accepted, a later rejection means the key was revoked, see AuthError
+	authenticated bool
 
 	client  HTTPClient
 	BaseURL *url.URL
 
+	// maxAttempts and retryWait configure the retry of the requests failing due to a temporary API outage or rate limiting, see SetRetryPolicy
+	maxAttempts int
+	retryWait   time.Duration
+
+	// readOnly refuses the requests changing the Developer Portal, see SetReadOnly
+	readOnly bool
+
 	common       service // Reuse a single struct instead of allocating one for each service on the heap.
 	Provisioning *ProvisioningService
 }
@@ -61,8 +97,13 @@ func NewClient(httpClient HTTPClient, keyID, issuerID string, privateKey []byte)
 		issuerID:          issuerID,
 		privateKeyContent: privateKey,
 
+		audience: appStoreConnectAudience,
+
 		client:  httpClient,
 		BaseURL: baseURL,
+
+		maxAttempts: defaultMaxAttempts,
+		retryWait:   defaultRetryWait,
 	}
 	c.common.client = c
 	c.Provisioning = (*ProvisioningService)(&c.common)
@@ -70,9 +111,141 @@ func NewClient(httpClient HTTPClient, keyID, issuerID string, privateKey []byte)
 	return c
 }
 
+// NewClientWithSigner creates a new client, which signs the JWT tokens with the given Signer instead of a .p8 private key content.
+func NewClientWithSigner(httpClient HTTPClient, keyID, issuerID string, signer Signer) *Client {
+	c := NewClient(httpClient, keyID, issuerID, nil)
+	c.signer = signer
+	return c
+}
+
+// NewRemoteClient creates a new client, which sends the requests to a provisioning server instead of the App Store Connect API.
+// The provisioning server holds the API key and authorizes the requests, the token authenticates the client on the server.
+func NewRemoteClient(httpClient HTTPClient, serverURL, token string) (*Client, error) {
+	if !strings.HasSuffix(serverURL, "/") {
+		serverURL += "/"
+	}
+
+	baseURL, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provisioning server url (%s): %s", serverURL, err)
+	}
+
+	c := &Client{
+		remoteToken: token,
+
+		client:  httpClient,
+		BaseURL: baseURL,
+
+		maxAttempts: defaultMaxAttempts,
+		retryWait:   defaultRetryWait,
+	}
+	c.common.client = c
+	c.Provisioning = (*ProvisioningService)(&c.common)
+
+	return c, nil
+}
+
+// apiKey holds the credentials of a secondary App Store Connect API key
+type apiKey struct {
+	keyID             string
+	issuerID          string
+	privateKeyContent []byte
+}
+
+// SetFailoverKey sets a secondary App Store Connect API key, the client fails over to
+// once the current key gets unauthorized (401 or 403), for example because it was revoked or its role was downgraded.
+func (c *Client) SetFailoverKey(keyID, issuerID string, privateKey []byte) {
+	c.failoverKey = &apiKey{keyID: keyID, issuerID: issuerID, privateKeyContent: privateKey}
+}
+
+// SetRetryPolicy configures the retry of the requests failing due to a temporary API outage or rate limiting (see IsTransientError):
+// a request is sent at most maxAttempts times, the wait before the retries starts from retryWait and doubles after each attempt.
+func (c *Client) SetRetryPolicy(maxAttempts int, retryWait time.Duration) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	if retryWait < 0 {
+		retryWait = 0
+	}
+	c.maxAttempts = maxAttempts
+	c.retryWait = retryWait
+}
+
+// SetReadOnly makes the client refuse every request, which would change the Developer Portal (POST, PATCH, DELETE),
+// guarding the dry runs against an unplanned change.
+func (c *Client) SetReadOnly() {
+	c.readOnly = true
+}
+
+// UseEnterpriseAPI sends the requests to the Enterprise Program API instead of the App Store Connect API,
+// the API keys of Apple Developer Enterprise Program accounts are only accepted by the Enterprise Program API.
+// It has no effect on the clients of a provisioning server, the server holds the API key.
+func (c *Client) UseEnterpriseAPI() {
+	if c.remoteToken != "" {
+		return
+	}
+
+	enterpriseURL, err := url.Parse(enterpriseBaseURL)
+	if err != nil {
+		panic("invalid enterprise api base url: " + err.Error())
+	}
+	c.BaseURL = enterpriseURL
+	c.audience = enterpriseAudience
+	c.token = nil
+	c.signedToken = ""
+}
+
+// KeyID returns the ID of the API key, the client currently authorizes the requests with.
+func (c *Client) KeyID() string {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	return c.keyID
+}
+
+// failover replaces the API key with the failover key, it returns false if there is no failover key left.
+func (c *Client) failover() bool {
+	if c.failoverKey == nil || c.remoteToken != "" {
+		return false
+	}
+
+	c.keyID = c.failoverKey.keyID
+	c.issuerID = c.failoverKey.issuerID
+	c.privateKeyContent = c.failoverKey.privateKeyContent
+	c.signer = nil
+	c.token = nil
+	c.signedToken = ""
+	c.failoverKey = nil
+	c.authenticated = false
+	return true
+}
+
+// failoverFrom handles the unauthorized response of a request authorized with the API key (keyID):
+// it fails over to the failover key, unless a concurrent request already did it. It returns false and the error to return
+// if there is no key left to retry the request with.
+func (c *Client) failoverFrom(keyID string, resp *http.Response, err error) (bool, error) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.keyID != keyID {
+		return true, nil
+	}
+	if !c.failover() {
+		if c.remoteToken != "" {
+			return false, err
+		}
+		return false, c.authError(resp, err)
+	}
+	log.Warnf("API key (%s) is not authorized: %s", keyID, err)
+	log.Warnf("Failing over to the secondary API key (%s)", c.keyID)
+	return true, nil
+}
+
 // ensureSignedToken makes sure that the JWT auth token is not expired
 // and return a signed key
 func (c *Client) ensureSignedToken() (string, error) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
 	if c.token != nil {
 		claim, ok := c.token.Claims.(claims)
 		if !ok {
@@ -89,14 +262,29 @@ func (c *Client) ensureSignedToken() (string, error) {
 		}
 	}
 
-	c.token = createToken(c.keyID, c.issuerID)
+	c.token = createToken(c.keyID, c.issuerID, c.audience)
 	var err error
-	if c.signedToken, err = signToken(c.token, c.privateKeyContent); err != nil {
+	if c.signer != nil {
+		c.signedToken, err = signTokenWithSigner(c.token, c.signer)
+	} else {
+		c.signedToken, err = signToken(c.token, c.privateKeyContent)
+	}
+	if err != nil {
 		return "", err
 	}
 	return c.signedToken, nil
 }
 
+// relationshipEndpoint returns the endpoint of a relationship URL, returned by the App Store Connect or the Enterprise Program API
+func relationshipEndpoint(relationshipLink string) string {
+	for _, base := range []string{baseURL, enterpriseBaseURL} {
+		if strings.HasPrefix(relationshipLink, base+apiVersion) {
+			return strings.TrimPrefix(relationshipLink, base+apiVersion)
+		}
+	}
+	return relationshipLink
+}
+
 // NewRequest creates a new http.Request
 func (c *Client) NewRequest(method, endpoint string, body interface{}) (*http.Request, error) {
 	endpoint = apiVersion + "/" + endpoint
@@ -125,31 +313,82 @@ func (c *Client) NewRequest(method, endpoint string, body interface{}) (*http.Re
 	}
 
 	if _, ok := c.client.(*http.Client); ok {
-		signedToken, err := c.ensureSignedToken()
-		if err != nil {
-			return nil, fmt.Errorf("ensuring JWT token failed: %v", err)
+		if err := c.Authorize(req); err != nil {
+			return nil, err
 		}
-		req.Header.Set("Authorization", "Bearer "+signedToken)
 	}
 
 	return req, nil
 }
 
+// Authorize sets the Authorization header of the request.
+// Requests to the App Store Connect API are authorized by a signed JWT token,
+// requests to a provisioning server are authorized by the client's token.
+func (c *Client) Authorize(req *http.Request) error {
+	if c.remoteToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.remoteToken)
+		return nil
+	}
+
+	signedToken, err := c.ensureSignedToken()
+	if err != nil {
+		return fmt.Errorf("ensuring JWT token failed: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+signedToken)
+
+	return nil
+}
+
 func checkResponse(r *http.Response) error {
 	if r.StatusCode >= 200 && r.StatusCode <= 299 {
 		return nil
 	}
 
-	errorResponse := &ErrorResponse{Response: r}
 	data, err := ioutil.ReadAll(r.Body)
-	if err == nil && data != nil {
+	if err != nil {
+		log.Warnf("Failed to read response body: %s", err)
+	}
+
+	if isHTMLResponse(r, data) {
+		return &UnavailableError{Response: r, Body: htmlSummary(data)}
+	}
+
+	errorResponse := &ErrorResponse{Response: r}
+	if len(data) > 0 {
 		if err := json.Unmarshal(data, errorResponse); err != nil {
+			if isTransientStatusCode(r.StatusCode) {
+				return &UnavailableError{Response: r, Body: htmlSummary(data)}
+			}
 			log.Errorf("Failed to unmarshal response (%s): %s", string(data), err)
 		}
 	}
 	return errorResponse
 }
 
+// isHTMLResponse reports whether the response is a HTML page, like Apple's maintenance page, instead of a JSON document.
+func isHTMLResponse(r *http.Response, data []byte) bool {
+	if strings.Contains(strings.ToLower(r.Header.Get("Content-Type")), "text/html") {
+		return true
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("<"))
+}
+
+var htmlTitlePattern = regexp.MustCompile(`(?is)<title>(.*?)</title>`)
+
+// htmlSummary returns the title of a HTML page or the beginning of a non JSON response body.
+func htmlSummary(data []byte) string {
+	if match := htmlTitlePattern.FindSubmatch(data); match != nil {
+		return strings.TrimSpace(string(match[1]))
+	}
+
+	const maxLength = 200
+	summary := strings.TrimSpace(string(data))
+	if len(summary) > maxLength {
+		summary = summary[:maxLength] + "..."
+	}
+	return summary
+}
+
 // Debugf ...
 func (c *Client) Debugf(format string, v ...interface{}) {
 	if c.EnableDebugLogs {
@@ -157,8 +396,94 @@ func (c *Client) Debugf(format string, v ...interface{}) {
 	}
 }
 
-// Do ...
+// Do sends the request and decodes the JSON response into v.
+// Requests failing due to a temporary API outage or rate limiting (see IsTransientError) are retried with an exponential backoff,
+// honoring the Retry-After header of the response. The requests changing the Developer Portal are only retried
+// if they were rate limited or could not reach the server, as a failed attempt might have been processed.
 func (c *Client) Do(req *http.Request, v interface{}) (*http.Response, error) {
+	if c.readOnly && req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, fmt.Errorf("read-only client refused the request changing the Developer Portal: %s %s", req.Method, req.URL)
+	}
+
+	for attempt := 1; ; attempt++ {
+		keyID := c.KeyID()
+		resp, err := c.do(req, v)
+		if IsUnauthorizedError(err) {
+			failedOver, err := c.failoverFrom(keyID, resp, err)
+			if !failedOver {
+				return resp, err
+			}
+
+			if err := c.resetRequest(req); err != nil {
+				return resp, err
+			}
+			if err := c.Authorize(req); err != nil {
+				return resp, err
+			}
+			continue
+		}
+		if err == nil {
+			c.authMu.Lock()
+			c.authenticated = true
+			c.authMu.Unlock()
+			return resp, nil
+		}
+		if !isRetryable(req.Method, err) || attempt >= c.maxAttempts {
+			return resp, err
+		}
+
+		wait := c.backoff(attempt)
+		if requested := retryAfter(err, time.Now()); requested > wait {
+			wait = requested
+		}
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+		log.Warnf("%s", err)
+		log.Warnf("Retrying %s %s in %s (attempt %d/%d)", req.Method, req.URL, wait.Round(time.Millisecond), attempt+1, c.maxAttempts)
+		time.Sleep(wait)
+
+		if err := c.resetRequest(req); err != nil {
+			return resp, err
+		}
+	}
+}
+
+// jitter randomizes the retry waits, it is seeded per process, so that the concurrent builds of a team do not retry at the same time
+var jitter = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// backoff returns the wait before retrying the failed attempt: the retry wait doubled after each attempt,
+// randomized between its half and its full value.
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.retryWait
+	for i := 1; i < attempt && wait < maxRetryWait; i++ {
+		wait *= 2
+	}
+	if wait <= 0 {
+		return 0
+	}
+	jitter.Lock()
+	defer jitter.Unlock()
+	return wait/2 + time.Duration(jitter.Int63n(int64(wait/2)+1))
+}
+
+// resetRequest rewinds the body of the request, so that it can be sent again.
+func (c *Client) resetRequest(req *http.Request) error {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return fmt.Errorf("failed to reset request body: %s", err)
+	}
+	req.Body = body
+	return nil
+}
+
+func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	c.Debugf("Request:")
 	if c.EnableDebugLogs {
 		if err := httputil.PrintRequest(req); err != nil {
@@ -189,16 +514,22 @@ func (c *Client) Do(req *http.Request, v interface{}) (*http.Response, error) {
 	}
 
 	if v != nil {
-		decErr := json.NewDecoder(resp.Body).Decode(v)
-		if decErr == io.EOF {
-			decErr = nil // ignore EOF errors caused by empty response body
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return resp, err
+		}
+		if len(data) == 0 {
+			return resp, nil // ignore empty response body
+		}
+		if isHTMLResponse(resp, data) {
+			return resp, &UnavailableError{Response: resp, Body: htmlSummary(data)}
 		}
-		if decErr != nil {
-			err = decErr
+		if err := json.Unmarshal(data, v); err != nil {
+			return resp, err
 		}
 	}
 
-	return resp, err
+	return resp, nil
 }
 
 // PagingOptions ...
@@ -0,0 +1,462 @@
+package appstoreconnect
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const maintenancePage = `<!DOCTYPE html>
+<html><head><title>Apple Developer - Maintenance</title></head>
+<body>We're performing scheduled maintenance. Please check back later.</body></html>`
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := NewRemoteClient(http.DefaultClient, server.URL, "token")
+	if err != nil {
+		t.Fatalf("NewRemoteClient() error = %v", err)
+	}
+	client.retryWait = 0
+	return client
+}
+
+func TestClient_Do_retriesMaintenancePage(t *testing.T) {
+	var requests int
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, maintenancePage)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":{"id":"ABC","type":"devices"}}`)
+	})
+
+	req, err := client.NewRequest(http.MethodGet, "devices/ABC", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+
+	var resp DeviceResponse
+	if _, err := client.Do(req, &resp); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if resp.Data.ID != "ABC" {
+		t.Errorf("Do() decoded device ID = %s, want ABC", resp.Data.ID)
+	}
+	if requests != 3 {
+		t.Errorf("Do() sent %d requests, want 3", requests)
+	}
+}
+
+func TestClient_Do_unavailable(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		contentType  string
+		body         string
+		wantRequests int
+		wantErr      string
+	}{
+		{
+			name:         "HTML maintenance page",
+			status:       http.StatusServiceUnavailable,
+			contentType:  "text/html",
+			body:         maintenancePage,
+			wantRequests: defaultMaxAttempts,
+			wantErr:      "Apple Developer - Maintenance",
+		},
+		{
+			name:         "HTML page with success status",
+			status:       http.StatusOK,
+			contentType:  "text/html; charset=utf-8",
+			body:         maintenancePage,
+			wantRequests: defaultMaxAttempts,
+			wantErr:      "Apple Developer - Maintenance",
+		},
+		{
+			name:         "gateway timeout without body",
+			status:       http.StatusGatewayTimeout,
+			wantRequests: defaultMaxAttempts,
+			wantErr:      "504",
+		},
+		{
+			name:         "JSON internal server error",
+			status:       http.StatusInternalServerError,
+			contentType:  "application/json",
+			body:         `{"errors":[{"code":"UNEXPECTED_ERROR","title":"An unexpected error occurred."}]}`,
+			wantRequests: defaultMaxAttempts,
+			wantErr:      "UNEXPECTED_ERROR",
+		},
+		{
+			name:         "JSON rate limit error",
+			status:       http.StatusTooManyRequests,
+			contentType:  "application/json",
+			body:         `{"errors":[{"code":"RATE_LIMIT_EXCEEDED","title":"The request rate limit has been reached."}]}`,
+			wantRequests: defaultMaxAttempts,
+			wantErr:      "RATE_LIMIT_EXCEEDED",
+		},
+		{
+			name:         "JSON client error",
+			status:       http.StatusNotFound,
+			contentType:  "application/json",
+			body:         `{"errors":[{"code":"NOT_FOUND","title":"The specified resource does not exist"}]}`,
+			wantRequests: 1,
+			wantErr:      "NOT_FOUND",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+
+			req, err := client.NewRequest(http.MethodGet, "devices/ABC", nil)
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+
+			var resp DeviceResponse
+			_, err = client.Do(req, &resp)
+			if err == nil {
+				t.Fatalf("Do() error = nil, want %s", tt.wantErr)
+			}
+			if got := err.Error(); !strings.Contains(got, tt.wantErr) {
+				t.Errorf("Do() error = %s, want to contain %s", got, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("Do() sent %d requests, want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestClient_Do_failover(t *testing.T) {
+	primaryKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("setup: generate key: %s", err)
+	}
+	secondaryKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("setup: generate key: %s", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(secondaryKey)
+	if err != nil {
+		t.Fatalf("setup: marshal key: %s", err)
+	}
+	secondaryPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	tests := []struct {
+		name         string
+		failover     bool
+		wantRequests int
+		wantKeyID    string
+		wantErr      bool
+	}{
+		{name: "fails over to the secondary key", failover: true, wantRequests: 2, wantKeyID: "SECONDARY"},
+		{name: "fails without secondary key", failover: false, wantRequests: 1, wantKeyID: "PRIMARY", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("Content-Type", "application/json")
+
+				signedToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+				token, _, err := new(jwt.Parser).ParseUnverified(signedToken, jwt.MapClaims{})
+				if err != nil || token.Header["kid"] != "SECONDARY" {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprint(w, `{"errors":[{"code":"NOT_AUTHORIZED","title":"Authentication credentials are missing or invalid."}]}`)
+					return
+				}
+				fmt.Fprint(w, `{"data":{"id":"ABC","type":"devices"}}`)
+			}))
+			t.Cleanup(server.Close)
+
+			client := NewClientWithSigner(http.DefaultClient, "PRIMARY", "ISSUER", CryptoSigner{Key: primaryKey})
+			client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+			if tt.failover {
+				client.SetFailoverKey("SECONDARY", "ISSUER", secondaryPEM)
+			}
+
+			req, err := client.NewRequest(http.MethodGet, "devices/ABC", nil)
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+
+			var resp DeviceResponse
+			_, err = client.Do(req, &resp)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && resp.Data.ID != "ABC" {
+				t.Errorf("Do() decoded device ID = %s, want ABC", resp.Data.ID)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("Do() sent %d requests, want %d", requests, tt.wantRequests)
+			}
+			if got := client.KeyID(); got != tt.wantKeyID {
+				t.Errorf("KeyID() = %s, want %s", got, tt.wantKeyID)
+			}
+		})
+	}
+}
+
+func TestClient_Do_retriesOnlyIdempotentRequests(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		status          int
+		maintenancePage bool
+		wantRequests    int
+	}{
+		{name: "GET internal server error", method: http.MethodGet, status: http.StatusInternalServerError, wantRequests: defaultMaxAttempts},
+		{name: "POST internal server error", method: http.MethodPost, status: http.StatusInternalServerError, wantRequests: 1},
+		{name: "PATCH bad gateway", method: http.MethodPatch, status: http.StatusBadGateway, wantRequests: 1},
+		{name: "DELETE gateway timeout", method: http.MethodDelete, status: http.StatusGatewayTimeout, wantRequests: 1},
+		{name: "POST rate limited", method: http.MethodPost, status: http.StatusTooManyRequests, wantRequests: defaultMaxAttempts},
+		{name: "POST maintenance page", method: http.MethodPost, status: http.StatusServiceUnavailable, maintenancePage: true, wantRequests: 1},
+		{name: "DELETE maintenance page", method: http.MethodDelete, status: http.StatusServiceUnavailable, maintenancePage: true, wantRequests: 1},
+		{name: "GET maintenance page", method: http.MethodGet, status: http.StatusServiceUnavailable, maintenancePage: true, wantRequests: defaultMaxAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if tt.maintenancePage {
+					w.Header().Set("Content-Type", "text/html")
+					w.WriteHeader(tt.status)
+					fmt.Fprint(w, maintenancePage)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, `{"errors":[{"code":"ERROR","title":"Failed."}]}`)
+			})
+
+			req, err := client.NewRequest(tt.method, "devices", DeviceCreateRequest{})
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+			if _, err := client.Do(req, &DeviceResponse{}); err == nil {
+				t.Fatalf("Do() error = nil, want %d", tt.status)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("Do() sent %d requests, want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestClient_Do_retriesConnectError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serverURL := server.URL
+	server.Close()
+
+	var attempts int
+	client, err := NewRemoteClient(http.DefaultClient, serverURL, "token")
+	if err != nil {
+		t.Fatalf("NewRemoteClient() error = %v", err)
+	}
+	client.retryWait = 0
+	client.client = httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return http.DefaultClient.Do(req)
+	})
+
+	req, err := client.NewRequest(http.MethodPost, "devices", DeviceCreateRequest{})
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if _, err := client.Do(req, &DeviceResponse{}); err == nil {
+		t.Fatalf("Do() error = nil, want connection refused")
+	}
+	if attempts != defaultMaxAttempts {
+		t.Errorf("Do() attempted %d times, want %d", attempts, defaultMaxAttempts)
+	}
+}
+
+type httpClientFunc func(req *http.Request) (*http.Response, error)
+
+func (f httpClientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClient_SetRetryPolicy(t *testing.T) {
+	var requests int
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	client.SetRetryPolicy(2, 0)
+
+	req, err := client.NewRequest(http.MethodGet, "devices/ABC", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if _, err := client.Do(req, &DeviceResponse{}); err == nil {
+		t.Fatalf("Do() error = nil, want 502")
+	}
+	if requests != 2 {
+		t.Errorf("Do() sent %d requests, want 2", requests)
+	}
+
+	client.SetRetryPolicy(0, 0)
+	requests = 0
+	if _, err := client.Do(req, &DeviceResponse{}); err == nil {
+		t.Fatalf("Do() error = nil, want 502")
+	}
+	if requests != 1 {
+		t.Errorf("Do() sent %d requests without retries, want 1", requests)
+	}
+}
+
+func TestClient_SetReadOnly(t *testing.T) {
+	var requests []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":{"type":"devices","id":"ABC"}}`)
+	})
+	client.SetReadOnly()
+
+	if _, err := client.Provisioning.RegisterNewDevice(DeviceCreateRequest{}); err == nil {
+		t.Fatalf("RegisterNewDevice() error = nil, want the read-only client to refuse the request")
+	}
+	if err := client.Provisioning.DeleteProfile("ABC"); err == nil {
+		t.Fatalf("DeleteProfile() error = nil, want the read-only client to refuse the request")
+	}
+
+	req, err := client.NewRequest(http.MethodGet, "devices/ABC", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if _, err := client.Do(req, &DeviceResponse{}); err != nil {
+		t.Fatalf("Do() error = %v, want the read-only client to send GET requests", err)
+	}
+
+	if len(requests) != 1 || requests[0] != http.MethodGet {
+		t.Errorf("read-only client sent %v, want a single GET", requests)
+	}
+}
+
+func TestClient_backoff(t *testing.T) {
+	client := &Client{retryWait: 4 * time.Second}
+
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 1, min: 2 * time.Second, max: 4 * time.Second},
+		{attempt: 2, min: 4 * time.Second, max: 8 * time.Second},
+		{attempt: 3, min: 8 * time.Second, max: 16 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := client.backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+
+	if got := (&Client{}).backoff(3); got != 0 {
+		t.Errorf("backoff() without retry wait = %s, want 0", got)
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2021, 5, 19, 8, 0, 0, 0, time.UTC)
+	newErr := func(retryAfter string) error {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return &ErrorResponse{Response: resp}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want time.Duration
+	}{
+		{name: "seconds", err: newErr("30"), want: 30 * time.Second},
+		{name: "HTTP date", err: newErr(now.Add(time.Minute).Format(http.TimeFormat)), want: time.Minute},
+		{name: "past HTTP date", err: newErr(now.Add(-time.Minute).Format(http.TimeFormat))},
+		{name: "invalid value", err: newErr("soon")},
+		{name: "no header", err: newErr("")},
+		{name: "unavailable error", err: &UnavailableError{Response: &http.Response{Header: http.Header{"Retry-After": []string{"10"}}}}, want: 10 * time.Second},
+		{name: "other error", err: fmt.Errorf("network error")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.err, now); got != tt.want {
+				t.Errorf("retryAfter() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_UseEnterpriseAPI(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("setup: generate key: %s", err)
+	}
+	client := NewClientWithSigner(http.DefaultClient, "KEYID", "ISSUER", CryptoSigner{Key: key})
+	client.UseEnterpriseAPI()
+
+	req, err := client.NewRequest(http.MethodGet, "profiles", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if got := req.URL.String(); got != "https://api.enterprise.developer.apple.com/v1/profiles" {
+		t.Errorf("request URL = %s, want the Enterprise Program API", got)
+	}
+
+	signedToken := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	token, _, err := new(jwt.Parser).ParseUnverified(signedToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified() error = %v", err)
+	}
+	if aud := token.Claims.(jwt.MapClaims)["aud"]; aud != "apple-developer-enterprise-v1" {
+		t.Errorf("token audience = %v, want apple-developer-enterprise-v1", aud)
+	}
+}
+
+func Test_relationshipEndpoint(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{link: "https://api.appstoreconnect.apple.com/v1/bundleIds/ID/profiles", want: "/bundleIds/ID/profiles"},
+		{link: "https://api.enterprise.developer.apple.com/v1/bundleIds/ID/profiles", want: "/bundleIds/ID/profiles"},
+		{link: "bundleIds/ID/profiles", want: "bundleIds/ID/profiles"},
+	}
+	for _, tt := range tests {
+		if got := relationshipEndpoint(tt.link); got != tt.want {
+			t.Errorf("relationshipEndpoint(%s) = %s, want %s", tt.link, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package appstoreconnect
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// AuthFailure is the class of an App Store Connect API authentication failure.
+type AuthFailure string
+
+// AuthFailure ...
+const (
+	// KeyRevokedFailure means the API key was accepted earlier in the run, so it has been revoked in the meantime.
+	KeyRevokedFailure AuthFailure = "key_revoked"
+	// KeyRejectedFailure means the API key was never accepted, it was revoked or the issuer ID belongs to another team.
+	KeyRejectedFailure AuthFailure = "key_rejected"
+	// ClockSkewFailure means the clock of the machine differs from Apple's clock, so the JWT token is rejected as expired or not yet valid.
+	ClockSkewFailure AuthFailure = "clock_skew"
+	// WrongIssuerFailure means the issuer ID is not in the format of an App Store Connect issuer ID.
+	WrongIssuerFailure AuthFailure = "wrong_issuer"
+	// InvalidKeyIDFailure means the key ID is not in the format of an App Store Connect API key ID.
+	InvalidKeyIDFailure AuthFailure = "invalid_key_id"
+	// MissingRoleFailure means the API key is valid, but its role does not allow the request.
+	MissingRoleFailure AuthFailure = "missing_role"
+)
+
+// maxClockSkew is the difference between the local and Apple's clock, above which the JWT tokens are likely rejected.
+const maxClockSkew = time.Minute
+
+var (
+	keyIDPattern    = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+	issuerIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	teamIDPattern   = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+)
+
+// AuthError is returned when the App Store Connect API rejects the API key, it explains the failure in plain language.
+type AuthError struct {
+	Failure   AuthFailure
+	KeyID     string
+	IssuerID  string
+	ClockSkew time.Duration
+	Err       error
+}
+
+// Error ...
+func (e AuthError) Error() string {
+	var m string
+	switch e.Failure {
+	case KeyRevokedFailure:
+		m = fmt.Sprintf("the API key (%s) was revoked during the run: it was accepted by the App Store Connect API earlier, but it is rejected now. "+
+			"Generate a new API key on App Store Connect (Users and Access > Keys) and update the Step's API key", e.KeyID)
+	case KeyRejectedFailure:
+		m = fmt.Sprintf("the API key (%s) is rejected by the App Store Connect API: either the key was revoked, "+
+			"or the issuer ID (%s) does not belong to the team of the key. "+
+			"Check that the key is listed as active on App Store Connect (Users and Access > Keys) and copy the issuer ID shown above the keys", e.KeyID, e.IssuerID)
+	case ClockSkewFailure:
+		direction := "ahead of"
+		skew := e.ClockSkew
+		if skew < 0 {
+			direction = "behind"
+			skew = -skew
+		}
+		m = fmt.Sprintf("the clock of this machine is %s %s Apple's clock, so the App Store Connect API rejects the API key's token as expired or not yet valid. "+
+			"Synchronize the machine's clock (for example, with NTP) and retry", skew.Round(time.Second), direction)
+	case WrongIssuerFailure:
+		m = fmt.Sprintf("the issuer ID (%s) is not an App Store Connect issuer ID: the issuer ID is a UUID, like 57246542-96fe-1a63-e053-0824d011072a, "+
+			"shown above the keys on App Store Connect (Users and Access > Keys)", e.IssuerID)
+		if teamIDPattern.MatchString(e.IssuerID) {
+			m += ", the given value looks like a team or key ID"
+		}
+	case InvalidKeyIDFailure:
+		m = fmt.Sprintf("the key ID (%s) is not an App Store Connect API key ID: the key ID consists of 10 uppercase letters and digits, like 2X9R4HXF34, "+
+			"shown in the keys list on App Store Connect (Users and Access > Keys)", e.KeyID)
+	case MissingRoleFailure:
+		m = fmt.Sprintf("the API key (%s) is valid, but its role does not allow managing the provisioning resources: "+
+			"use a key with the Admin or App Manager role", e.KeyID)
+	default:
+		m = fmt.Sprintf("the API key (%s) is not authorized", e.KeyID)
+	}
+
+	if e.Err != nil {
+		m += fmt.Sprintf(": %s", e.Err)
+	}
+	return m
+}
+
+// Unwrap returns the error response of the App Store Connect API, if any.
+func (e AuthError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateAPIKeyIdentifiers checks the format of the API key's ID and issuer ID, so that typos and mixed up values
+// are reported before the App Store Connect API rejects them with a general error.
+func ValidateAPIKeyIdentifiers(keyID, issuerID string) error {
+	if !keyIDPattern.MatchString(keyID) {
+		return &AuthError{Failure: InvalidKeyIDFailure, KeyID: keyID, IssuerID: issuerID}
+	}
+	if !issuerIDPattern.MatchString(issuerID) {
+		return &AuthError{Failure: WrongIssuerFailure, KeyID: keyID, IssuerID: issuerID}
+	}
+	return nil
+}
+
+// CheckAuthentication validates the format of the API key's identifiers and verifies the authentication with a cheap request,
+// the returned AuthError explains why the API key is rejected.
+// Clients of a provisioning server are not checked, the server holds the API key.
+func (c *Client) CheckAuthentication() error {
+	if c.remoteToken != "" {
+		return nil
+	}
+
+	if err := ValidateAPIKeyIdentifiers(c.keyID, c.issuerID); err != nil {
+		return err
+	}
+
+	_, err := c.Provisioning.ListBundleIDs(&ListBundleIDsOptions{PagingOptions: PagingOptions{Limit: 1}})
+	if err != nil {
+		if _, ok := err.(*AuthError); ok {
+			return err
+		}
+		return fmt.Errorf("failed to verify the API key (%s): %s", c.keyID, err)
+	}
+	return nil
+}
+
+// authError classifies an unauthorized (401 or 403) response of the App Store Connect API by its error code,
+// the returned AuthError wraps the error response.
+func (c *Client) authError(resp *http.Response, err error) error {
+	authErr := &AuthError{KeyID: c.keyID, IssuerID: c.issuerID, Err: err}
+
+	if resp != nil && resp.StatusCode == http.StatusForbidden {
+		var respErr *ErrorResponse
+		if !errors.As(err, &respErr) || !respErr.isMissingRole() {
+			return err
+		}
+		authErr.Failure = MissingRoleFailure
+		return authErr
+	}
+
+	if skew := clockSkew(resp, time.Now()); skew > maxClockSkew || skew < -maxClockSkew {
+		authErr.Failure = ClockSkewFailure
+		authErr.ClockSkew = skew
+		return authErr
+	}
+
+	if c.authenticated {
+		authErr.Failure = KeyRevokedFailure
+	} else {
+		authErr.Failure = KeyRejectedFailure
+	}
+	return authErr
+}
+
+// clockSkew returns how much the local clock is ahead of the server's clock, based on the Date header of the response.
+func clockSkew(resp *http.Response, now time.Time) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+	return now.Sub(serverTime)
+}
@@ -0,0 +1,151 @@
+package appstoreconnect
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testIssuerID = "57246542-96fe-1a63-e053-0824d011072a"
+
+func TestValidateAPIKeyIdentifiers(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyID    string
+		issuerID string
+		want     AuthFailure
+	}{
+		{name: "valid", keyID: "2X9R4HXF34", issuerID: testIssuerID},
+		{name: "lowercase key ID", keyID: "2x9r4hxf34", issuerID: testIssuerID, want: InvalidKeyIDFailure},
+		{name: "key ID with whitespace", keyID: "2X9R4HXF34 ", issuerID: testIssuerID, want: InvalidKeyIDFailure},
+		{name: "team ID as issuer ID", keyID: "2X9R4HXF34", issuerID: "VV2J4SV8V4", want: WrongIssuerFailure},
+		{name: "truncated issuer ID", keyID: "2X9R4HXF34", issuerID: testIssuerID[:30], want: WrongIssuerFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAPIKeyIdentifiers(tt.keyID, tt.issuerID)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("ValidateAPIKeyIdentifiers() error = %v", err)
+				}
+				return
+			}
+			authErr, ok := err.(*AuthError)
+			if !ok || authErr.Failure != tt.want {
+				t.Errorf("ValidateAPIKeyIdentifiers() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_CheckAuthentication(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("setup: generate key: %s", err)
+	}
+
+	const notAuthorized = `{"errors":[{"code":"NOT_AUTHORIZED","title":"Authentication credentials are missing or invalid."}]}`
+	const forbidden = `{"errors":[{"code":"FORBIDDEN_ERROR","title":"This request is forbidden for security reasons","detail":"The API key in use does not allow this request"}]}`
+	tests := []struct {
+		name         string
+		authorizedBy int
+		status       int
+		serverTime   time.Time
+		body         string
+		want         AuthFailure
+		wantMessage  string
+	}{
+		{name: "authenticated", status: http.StatusOK},
+		{name: "rejected key", status: http.StatusUnauthorized, want: KeyRejectedFailure, wantMessage: "does not belong to the team of the key"},
+		{name: "revoked during the run", authorizedBy: 1, status: http.StatusUnauthorized, want: KeyRevokedFailure, wantMessage: "revoked during the run"},
+		{name: "clock behind", status: http.StatusUnauthorized, serverTime: time.Now().Add(time.Hour), want: ClockSkewFailure, wantMessage: "behind Apple's clock"},
+		{name: "missing role", status: http.StatusForbidden, body: forbidden, want: MissingRoleFailure, wantMessage: "Admin or App Manager role"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("Content-Type", "application/json")
+				if !tt.serverTime.IsZero() {
+					w.Header().Set("Date", tt.serverTime.UTC().Format(http.TimeFormat))
+				}
+				if requests <= tt.authorizedBy || tt.status == http.StatusOK {
+					fmt.Fprint(w, `{"data":[]}`)
+					return
+				}
+				w.WriteHeader(tt.status)
+				if tt.body != "" {
+					fmt.Fprint(w, tt.body)
+					return
+				}
+				fmt.Fprint(w, notAuthorized)
+			}))
+			t.Cleanup(server.Close)
+
+			client := NewClientWithSigner(http.DefaultClient, "2X9R4HXF34", testIssuerID, CryptoSigner{Key: key})
+			client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+			for i := 0; i < tt.authorizedBy; i++ {
+				if err := client.CheckAuthentication(); err != nil {
+					t.Fatalf("setup: CheckAuthentication() error = %v", err)
+				}
+			}
+
+			err := client.CheckAuthentication()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("CheckAuthentication() error = %v", err)
+				}
+				return
+			}
+
+			authErr, ok := err.(*AuthError)
+			if !ok || authErr.Failure != tt.want {
+				t.Fatalf("CheckAuthentication() error = %v, want %s", err, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.wantMessage) {
+				t.Errorf("CheckAuthentication() error = %v, want it to contain %s", err, tt.wantMessage)
+			}
+			if !IsUnauthorizedError(err) {
+				t.Errorf("IsUnauthorizedError() = false for %v", err)
+			}
+			var respErr *ErrorResponse
+			if !errors.As(err, &respErr) || respErr.Response.StatusCode != tt.status {
+				t.Errorf("CheckAuthentication() error = %v, want it to wrap the %d error response", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestClient_Do_forbiddenByAgreement(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("setup: generate key: %s", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors":[{"code":"FORBIDDEN.REQUIRED_AGREEMENTS_MISSING_OR_EXPIRED","title":"A required agreement is missing or has expired."}]}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClientWithSigner(http.DefaultClient, "2X9R4HXF34", testIssuerID, CryptoSigner{Key: key})
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+	_, err = client.Provisioning.ListBundleIDs(&ListBundleIDsOptions{})
+	if _, ok := err.(*ErrorResponse); !ok {
+		t.Fatalf("ListBundleIDs() error = %v, want the error response, not an API key failure", err)
+	}
+	if IsUnauthorizedError(err) {
+		t.Errorf("IsUnauthorizedError() = true for %v", err)
+	}
+}
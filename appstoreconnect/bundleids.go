@@ -2,7 +2,6 @@ package appstoreconnect
 
 import (
 	"net/http"
-	"strings"
 )
 
 // BundleIDsEndpoint ...
@@ -123,9 +122,20 @@ func (s ProvisioningService) CreateBundleID(body BundleIDCreateRequest) (*Bundle
 	return r, nil
 }
 
+// DeleteBundleID ...
+func (s ProvisioningService) DeleteBundleID(id string) error {
+	req, err := s.client.NewRequest(http.MethodDelete, BundleIDsEndpoint+"/"+id, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Do(req, nil)
+	return err
+}
+
 // BundleID ...
 func (s ProvisioningService) BundleID(relationshipLink string) (*BundleIDResponse, error) {
-	endpoint := strings.TrimPrefix(relationshipLink, baseURL+apiVersion)
+	endpoint := relationshipEndpoint(relationshipLink)
 	req, err := s.client.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, err
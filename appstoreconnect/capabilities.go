@@ -2,7 +2,6 @@ package appstoreconnect
 
 import (
 	"net/http"
-	"strings"
 )
 
 // BundleIDCapabilitiesEndpoint ...
@@ -87,6 +86,11 @@ var ServiceTypeByKey = map[string]CapabilityType{
 	"com.apple.developer.carplay-parking":        ProfileAttachedEntitlement,
 	"com.apple.developer.carplay-quick-ordering": ProfileAttachedEntitlement,
 	"com.apple.developer.exposure-notification":  ProfileAttachedEntitlement,
+	// These entitlements require Apple's approval,
+	// profiles include them once Apple granted them for the app ID.
+	"com.apple.developer.usernotifications.critical-alerts": ProfileAttachedEntitlement,
+	"com.apple.developer.calling-app":                       ProfileAttachedEntitlement,
+	"com.apple.developer.messaging-app":                     ProfileAttachedEntitlement,
 }
 
 // CapabilitySettingAllowedInstances ...
@@ -254,9 +258,20 @@ func (s ProvisioningService) UpdateCapability(id string, body BundleIDCapability
 	return r, nil
 }
 
+// DisableCapability ...
+func (s ProvisioningService) DisableCapability(id string) error {
+	req, err := s.client.NewRequest(http.MethodDelete, BundleIDCapabilitiesEndpoint+"/"+id, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Do(req, nil)
+	return err
+}
+
 // Capabilities ...
 func (s ProvisioningService) Capabilities(relationshipLink string) (*BundleIDCapabilitiesResponse, error) {
-	endpoint := strings.TrimPrefix(relationshipLink, baseURL+apiVersion)
+	endpoint := relationshipEndpoint(relationshipLink)
 	req, err := s.client.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, err
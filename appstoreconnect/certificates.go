@@ -3,7 +3,6 @@ package appstoreconnect
 import (
 	"fmt"
 	"net/http"
-	"strings"
 )
 
 // CertificatesEndpoint ...
@@ -106,7 +105,7 @@ func (s ProvisioningService) Certificates(relationshipLink string, opt *PagingOp
 		return nil, err
 	}
 
-	endpoint := strings.TrimPrefix(u, baseURL+apiVersion)
+	endpoint := relationshipEndpoint(u)
 	req, err := s.client.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, err
@@ -119,3 +118,40 @@ func (s ProvisioningService) Certificates(relationshipLink string, opt *PagingOp
 
 	return r, nil
 }
+
+// CertificateCreateRequestDataAttributes ...
+type CertificateCreateRequestDataAttributes struct {
+	CertificateType CertificateType `json:"certificateType"`
+	CsrContent      string          `json:"csrContent"`
+}
+
+// CertificateCreateRequestData ...
+type CertificateCreateRequestData struct {
+	Attributes CertificateCreateRequestDataAttributes `json:"attributes"`
+	Type       string                                 `json:"type"`
+}
+
+// CertificateCreateRequest ...
+type CertificateCreateRequest struct {
+	Data CertificateCreateRequestData `json:"data"`
+}
+
+// CertificateResponse ...
+type CertificateResponse struct {
+	Data Certificate `json:"data"`
+}
+
+// CreateCertificate ...
+func (s ProvisioningService) CreateCertificate(body CertificateCreateRequest) (*CertificateResponse, error) {
+	req, err := s.client.NewRequest(http.MethodPost, CertificatesEndpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &CertificateResponse{}
+	if _, err := s.client.Do(req, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
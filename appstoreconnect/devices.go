@@ -2,7 +2,6 @@ package appstoreconnect
 
 import (
 	"net/http"
-	"strings"
 )
 
 // DevicesEndpoint ...
@@ -27,6 +26,8 @@ const (
 	Ipod       DeviceClass = "IPOD"
 	AppleTV    DeviceClass = "APPLE_TV"
 	Mac        DeviceClass = "MAC"
+	// AppleVisionPro devices are registered with the IOS platform
+	AppleVisionPro DeviceClass = "APPLE_VISION_PRO"
 )
 
 // DevicePlatform ...
@@ -146,7 +147,7 @@ func (s ProvisioningService) Devices(relationshipLink string, opt *PagingOptions
 		return nil, err
 	}
 
-	endpoint := strings.TrimPrefix(u, baseURL+apiVersion)
+	endpoint := relationshipEndpoint(u)
 	req, err := s.client.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, err
@@ -1,8 +1,13 @@
 package appstoreconnect
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // ErrorResponseError ...
@@ -17,7 +22,7 @@ type ErrorResponseError struct {
 
 // ErrorResponse ...
 type ErrorResponse struct {
-	Response *http.Response
+	Response *http.Response       `json:"-"`
 	Errors   []ErrorResponseError `json:"errors,omitempty"`
 }
 
@@ -36,3 +41,131 @@ func (r ErrorResponse) Error() string {
 
 	return m
 }
+
+// UnavailableError is returned when the API responds with a non JSON (for example a HTML maintenance page) response
+// or with a gateway error, these errors are transient and the request can be retried.
+type UnavailableError struct {
+	Response *http.Response `json:"-"`
+	Body     string
+}
+
+// Error ...
+func (r UnavailableError) Error() string {
+	m := "App Store Connect API is temporarily unavailable"
+	if r.Response != nil {
+		if r.Response.Request != nil {
+			m += fmt.Sprintf(": %s %s", r.Response.Request.Method, r.Response.Request.URL)
+		}
+		m += fmt.Sprintf(": %d", r.Response.StatusCode)
+		if contentType := r.Response.Header.Get("Content-Type"); contentType != "" {
+			m += fmt.Sprintf(" (%s)", contentType)
+		}
+	}
+	if r.Body != "" {
+		m += ": " + r.Body
+	}
+	return m
+}
+
+// retryAfter returns the wait time requested by the Retry-After header (in seconds or as a HTTP date) of the failed request's response, if any.
+func retryAfter(err error, now time.Time) time.Duration {
+	var resp *http.Response
+	switch err := err.(type) {
+	case *UnavailableError:
+		resp = err.Response
+	case *ErrorResponse:
+		resp = err.Response
+	}
+	if resp == nil {
+		return 0
+	}
+
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// IsTransientError reports whether the request failed due to a temporary API outage or rate limiting and can be retried.
+func IsTransientError(err error) bool {
+	switch err := err.(type) {
+	case *UnavailableError:
+		return true
+	case *ErrorResponse:
+		return err.Response != nil && isTransientStatusCode(err.Response.StatusCode)
+	}
+	return false
+}
+
+// forbiddenErrorCode is the error code of the 403 responses, sent when the API key's role does not allow the request.
+// The other 403 responses (for example FORBIDDEN.REQUIRED_AGREEMENTS_MISSING_OR_EXPIRED) are not caused by the API key.
+const forbiddenErrorCode = "FORBIDDEN_ERROR"
+
+// hasCode returns true if one of the errors of the response has the given code.
+func (r ErrorResponse) hasCode(code string) bool {
+	for _, err := range r.Errors {
+		if err.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// isMissingRole returns true if the API key's role does not allow the request.
+func (r ErrorResponse) isMissingRole() bool {
+	return r.Response != nil && r.Response.StatusCode == http.StatusForbidden && r.hasCode(forbiddenErrorCode)
+}
+
+// IsUnauthorizedError reports whether the request failed, because the API key is not authorized (revoked or lacks the required role).
+func IsUnauthorizedError(err error) bool {
+	if authErr, ok := err.(*AuthError); ok {
+		return authErr.Failure == MissingRoleFailure || IsUnauthorizedError(authErr.Err)
+	}
+	respErr, ok := err.(*ErrorResponse)
+	if !ok || respErr.Response == nil {
+		return false
+	}
+	return respErr.Response.StatusCode == http.StatusUnauthorized || respErr.isMissingRole()
+}
+
+// isRetryable reports whether the request, failed with the given error, can be sent again.
+// Idempotent (GET, HEAD) requests are retried on every transient error, the requests changing the Developer Portal
+// only if the API did not process them: they were rate limited or did not reach the server.
+func isRetryable(method string, err error) bool {
+	if isConnectError(err) {
+		return true
+	}
+	if respErr, ok := err.(*ErrorResponse); ok && respErr.Response != nil && respErr.Response.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	// A maintenance page or a server error does not tell if a change was made, only idempotent requests can be sent again.
+	return isIdempotentMethod(method) && IsTransientError(err)
+}
+
+func isIdempotentMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// isConnectError reports whether the request failed, because the connection to the server could not be established.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isTransientStatusCode(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -3,9 +3,12 @@ package appstoreconnect
 import (
 	"crypto/ecdsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
@@ -13,29 +16,90 @@ import (
 
 // signToken signs the JWT token with the given .p8 private key content
 func signToken(token *jwt.Token, privateKeyContent []byte) (string, error) {
-	block, _ := pem.Decode(privateKeyContent)
-	if block == nil {
-		return "", errors.New("failed to parse private key as a PEM format")
+	privateKey, err := ParsePrivateKey(privateKeyContent)
+	if err != nil {
+		return "", err
+	}
+
+	return token.SignedString(privateKey)
+}
+
+// ParsePrivateKey parses the .p8 private key content of an App Store Connect API key.
+// The key is accepted as a PKCS#8 PEM with or without the header and footer lines, as the base64 encoded DER
+// or the base64 encoded PEM, with Windows line endings or escaped (\n) newlines, as pasted into environment variables.
+func ParsePrivateKey(privateKeyContent []byte) (*ecdsa.PrivateKey, error) {
+	der, err := privateKeyDER(string(privateKeyContent))
+	if err != nil {
+		return nil, err
 	}
-	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign JWT token, private key format is invalid: %v", err)
+		ecKey, ecErr := x509.ParseECPrivateKey(der)
+		if ecErr != nil {
+			return nil, fmt.Errorf("failed to sign JWT token, private key format is invalid: %v", err)
+		}
+		return ecKey, nil
 	}
 
 	privateKey, ok := key.(*ecdsa.PrivateKey)
 	if !ok {
-		return "", errors.New("not a private key")
+		return nil, errors.New("not a private key")
 	}
 
-	return token.SignedString(privateKey)
+	return privateKey, nil
+}
+
+// privateKeyDER returns the DER bytes of the private key content, normalizing the supported formats
+func privateKeyDER(content string) ([]byte, error) {
+	content = strings.NewReplacer("\r\n", "\n", "\r", "\n", `\r\n`, "\n", `\n`, "\n").Replace(content)
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errors.New("failed to parse private key: the key is empty")
+	}
+
+	if strings.Contains(content, "-----BEGIN") {
+		if block, _ := pem.Decode([]byte(content)); block != nil {
+			if strings.HasPrefix(string(block.Bytes), "-----BEGIN") {
+				// base64 encoded PEM file wrapped in a header and footer
+				return privateKeyDER(string(block.Bytes))
+			}
+			return block.Bytes, nil
+		}
+
+		// the header and footer are not on their own lines (the newlines were lost), decode the content between them
+		content = pemArmorPattern.ReplaceAllString(content, "")
+	}
+
+	der, err := decodeBase64(strings.Join(strings.Fields(content), ""))
+	if err != nil {
+		return nil, errors.New("failed to parse private key as a PEM format or base64 encoded DER")
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(der)), "-----BEGIN") {
+		// base64 encoded PEM file
+		return privateKeyDER(string(der))
+	}
+	return der, nil
+}
+
+var pemArmorPattern = regexp.MustCompile(`-----(BEGIN|END) [A-Z ]+-----`)
+
+func decodeBase64(s string) ([]byte, error) {
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
 }
 
 // createToken creates a jwt.Token for the Apple API
-func createToken(keyID string, issuerID string) *jwt.Token {
+func createToken(keyID string, issuerID string, audience string) *jwt.Token {
+	if audience == "" {
+		audience = appStoreConnectAudience
+	}
 	payload := claims{
 		IssuerID:   issuerID,
 		Expiration: time.Now().Add(time.Minute * 20).Unix(),
-		Audience:   "appstoreconnect-v1",
+		Audience:   audience,
 	}
 
 	// registers headers: alg = ES256 and typ = JWT
@@ -0,0 +1,157 @@
+package appstoreconnect
+
+import "fmt"
+
+const (
+	// maxPageLimit is the largest page size of the top level list endpoints (profiles, certificates, devices, bundleIds)
+	maxPageLimit = 200
+	// maxRelationshipPageLimit is the largest page size of the endpoints pointed by relationship URLs
+	maxRelationshipPageLimit = 50
+)
+
+// ListAll fetches every page of a list endpoint, by following the links.next URL of the fetched pages.
+// fetchPage fetches the page pointed by opt and returns the links of the response, opt is the paging options of the request:
+// its Limit defaults to the given limit, its Next is updated after each page.
+func ListAll(opt *PagingOptions, limit int, fetchPage func() (PagedDocumentLinks, error)) error {
+	if opt.Limit == 0 {
+		opt.Limit = limit
+	}
+
+	fetched := map[string]bool{}
+	for {
+		links, err := fetchPage()
+		if err != nil {
+			return err
+		}
+
+		if links.Next == "" {
+			return nil
+		}
+		if fetched[links.Next] {
+			return fmt.Errorf("next page (%s) was already fetched, paging does not terminate", links.Next)
+		}
+		fetched[links.Next] = true
+		opt.Next = links.Next
+	}
+}
+
+// ListAllBundleIDs returns every bundle ID matching the options, from all pages.
+func (s ProvisioningService) ListAllBundleIDs(opt *ListBundleIDsOptions) ([]BundleID, error) {
+	var o ListBundleIDsOptions
+	if opt != nil {
+		o = *opt
+	}
+
+	var bundleIDs []BundleID
+	err := ListAll(&o.PagingOptions, maxPageLimit, func() (PagedDocumentLinks, error) {
+		r, err := s.ListBundleIDs(&o)
+		if err != nil {
+			return PagedDocumentLinks{}, err
+		}
+		bundleIDs = append(bundleIDs, r.Data...)
+		return r.Links, nil
+	})
+	return bundleIDs, err
+}
+
+// ListAllCertificates returns every certificate matching the options, from all pages.
+func (s ProvisioningService) ListAllCertificates(opt *ListCertificatesOptions) ([]Certificate, error) {
+	var o ListCertificatesOptions
+	if opt != nil {
+		o = *opt
+	}
+
+	var certificates []Certificate
+	err := ListAll(&o.PagingOptions, maxPageLimit, func() (PagedDocumentLinks, error) {
+		r, err := s.ListCertificates(&o)
+		if err != nil {
+			return PagedDocumentLinks{}, err
+		}
+		certificates = append(certificates, r.Data...)
+		return r.Links, nil
+	})
+	return certificates, err
+}
+
+// ListAllDevices returns every device matching the options, from all pages.
+func (s ProvisioningService) ListAllDevices(opt *ListDevicesOptions) ([]Device, error) {
+	var o ListDevicesOptions
+	if opt != nil {
+		o = *opt
+	}
+
+	var devices []Device
+	err := ListAll(&o.PagingOptions, maxPageLimit, func() (PagedDocumentLinks, error) {
+		r, err := s.ListDevices(&o)
+		if err != nil {
+			return PagedDocumentLinks{}, err
+		}
+		devices = append(devices, r.Data...)
+		return r.Links, nil
+	})
+	return devices, err
+}
+
+// ListAllProfiles returns every provisioning profile matching the options, from all pages.
+func (s ProvisioningService) ListAllProfiles(opt *ListProfilesOptions) ([]Profile, error) {
+	var o ListProfilesOptions
+	if opt != nil {
+		o = *opt
+	}
+
+	var profiles []Profile
+	err := ListAll(&o.PagingOptions, maxPageLimit, func() (PagedDocumentLinks, error) {
+		r, err := s.ListProfiles(&o)
+		if err != nil {
+			return PagedDocumentLinks{}, err
+		}
+		profiles = append(profiles, r.Data...)
+		return r.Links, nil
+	})
+	return profiles, err
+}
+
+// AllCertificates returns every certificate pointed by a relationship URL, from all pages.
+func (s ProvisioningService) AllCertificates(relationshipLink string) ([]Certificate, error) {
+	var opt PagingOptions
+	var certificates []Certificate
+	err := ListAll(&opt, maxRelationshipPageLimit, func() (PagedDocumentLinks, error) {
+		r, err := s.Certificates(relationshipLink, &opt)
+		if err != nil {
+			return PagedDocumentLinks{}, err
+		}
+		certificates = append(certificates, r.Data...)
+		return r.Links, nil
+	})
+	return certificates, err
+}
+
+// AllDevices returns every device pointed by a relationship URL, from all pages.
+func (s ProvisioningService) AllDevices(relationshipLink string) ([]Device, error) {
+	var opt PagingOptions
+	var devices []Device
+	err := ListAll(&opt, maxRelationshipPageLimit, func() (PagedDocumentLinks, error) {
+		r, err := s.Devices(relationshipLink, &opt)
+		if err != nil {
+			return PagedDocumentLinks{}, err
+		}
+		devices = append(devices, r.Data...)
+		return r.Links, nil
+	})
+	return devices, err
+}
+
+// AllProfiles returns every provisioning profile pointed by a relationship URL, from all pages.
+func (s ProvisioningService) AllProfiles(relationshipLink string) ([]Profile, error) {
+	var opt PagingOptions
+	var profiles []Profile
+	err := ListAll(&opt, maxRelationshipPageLimit, func() (PagedDocumentLinks, error) {
+		r, err := s.Profiles(relationshipLink, &opt)
+		if err != nil {
+			return PagedDocumentLinks{}, err
+		}
+		profiles = append(profiles, r.Data...)
+		return r.Links, nil
+	})
+	return profiles, err
+}
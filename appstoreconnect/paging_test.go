@@ -0,0 +1,94 @@
+package appstoreconnect
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pagedProfilesHandler serves count profiles in pages, with the limit and cursor query parameters of the requests
+func pagedProfilesHandler(t *testing.T, count int, requests *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.RawQuery)
+
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			t.Errorf("invalid limit: %s", r.URL.Query().Get("limit"))
+		}
+		start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		end := start + limit
+		if end > count {
+			end = count
+		}
+
+		var data []string
+		for i := start; i < end; i++ {
+			data = append(data, fmt.Sprintf(`{"type":"profiles","id":"%d"}`, i))
+		}
+		next := ""
+		if end < count {
+			next = fmt.Sprintf("https://api.appstoreconnect.apple.com/v1/profiles?cursor=%d&limit=%d", end, limit)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"data":[%s],"links":{"next":"%s"}}`, strings.Join(data, ","), next)
+	}
+}
+
+func TestProvisioningService_ListAllProfiles(t *testing.T) {
+	var requests []string
+	client := newTestClient(t, pagedProfilesHandler(t, 450, &requests))
+
+	profiles, err := client.Provisioning.ListAllProfiles(&ListProfilesOptions{FilterProfileType: IOSAppDevelopment})
+	if err != nil {
+		t.Fatalf("ListAllProfiles() error = %v", err)
+	}
+	if len(profiles) != 450 {
+		t.Fatalf("ListAllProfiles() returned %d profiles, want 450", len(profiles))
+	}
+	if profiles[449].ID != "449" {
+		t.Errorf("last profile ID = %s, want 449", profiles[449].ID)
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("ListAllProfiles() sent %d requests, want 3: %v", len(requests), requests)
+	}
+	for _, query := range requests {
+		if !strings.Contains(query, "limit=200") || !strings.Contains(query, "filter%5BprofileType%5D=IOS_APP_DEVELOPMENT") {
+			t.Errorf("request query (%s) does not keep the page limit and the filters", query)
+		}
+	}
+}
+
+func TestProvisioningService_AllProfiles_relationshipLimit(t *testing.T) {
+	var requests []string
+	client := newTestClient(t, pagedProfilesHandler(t, 60, &requests))
+
+	profiles, err := client.Provisioning.AllProfiles("bundleIds/ID/profiles")
+	if err != nil {
+		t.Fatalf("AllProfiles() error = %v", err)
+	}
+	if len(profiles) != 60 {
+		t.Fatalf("AllProfiles() returned %d profiles, want 60", len(profiles))
+	}
+	if len(requests) != 2 || !strings.Contains(requests[0], "limit=50") {
+		t.Errorf("AllProfiles() requests = %v, want 2 pages of 50", requests)
+	}
+}
+
+func TestListAll_repeatedNextPage(t *testing.T) {
+	var opt PagingOptions
+	var pages int
+	err := ListAll(&opt, maxPageLimit, func() (PagedDocumentLinks, error) {
+		pages++
+		return PagedDocumentLinks{Next: "https://api.appstoreconnect.apple.com/v1/profiles?cursor=1"}, nil
+	})
+	if err == nil {
+		t.Fatalf("ListAll() error = nil, want an error for a repeated next page")
+	}
+	if pages != 2 {
+		t.Errorf("ListAll() fetched %d pages, want 2", pages)
+	}
+}
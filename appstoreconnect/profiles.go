@@ -1,8 +1,8 @@
 package appstoreconnect
 
 import (
+	"encoding/json"
 	"net/http"
-	"strings"
 
 	"github.com/bitrise-io/xcode-project/serialized"
 )
@@ -52,6 +52,10 @@ const (
 	MacAppStore       ProfileType = "MAC_APP_STORE"
 	MacAppDirect      ProfileType = "MAC_APP_DIRECT"
 
+	MacCatalystAppDevelopment ProfileType = "MAC_CATALYST_APP_DEVELOPMENT"
+	MacCatalystAppStore       ProfileType = "MAC_CATALYST_APP_STORE"
+	MacCatalystAppDirect      ProfileType = "MAC_CATALYST_APP_DIRECT"
+
 	TvOSAppDevelopment ProfileType = "TVOS_APP_DEVELOPMENT"
 	TvOSAppStore       ProfileType = "TVOS_APP_STORE"
 	TvOSAppAdHoc       ProfileType = "TVOS_APP_ADHOC"
@@ -62,15 +66,15 @@ const (
 // e.g: IOSAppDevelopment => development
 func (t ProfileType) ReadableString() string {
 	switch t {
-	case IOSAppStore, MacAppStore, TvOSAppStore:
+	case IOSAppStore, MacAppStore, TvOSAppStore, MacCatalystAppStore:
 		return "app store"
 	case IOSAppInHouse, TvOSAppInHouse:
 		return "enterprise"
 	case IOSAppAdHoc, TvOSAppAdHoc:
 		return "ad-hoc"
-	case IOSAppDevelopment, MacAppDevelopment, TvOSAppDevelopment:
+	case IOSAppDevelopment, MacAppDevelopment, TvOSAppDevelopment, MacCatalystAppDevelopment:
 		return "development"
-	case MacAppDirect:
+	case MacAppDirect, MacCatalystAppDirect:
 		return "development ID"
 	}
 	return ""
@@ -88,6 +92,33 @@ type ProfileAttributes struct {
 	ExpirationDate Time             `json:"expirationDate"`
 }
 
+// UnmarshalJSON tolerates the missing and malformed attributes, Apple occasionally returns during the propagation of a profile:
+// the malformed profile content and expiration date are left empty, instead of failing the whole response.
+func (a *ProfileAttributes) UnmarshalJSON(b []byte) error {
+	type attributes ProfileAttributes
+	var raw struct {
+		attributes
+		ProfileContent json.RawMessage `json:"profileContent"`
+		ExpirationDate json.RawMessage `json:"expirationDate"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*a = ProfileAttributes(raw.attributes)
+	if len(raw.ProfileContent) > 0 {
+		if err := json.Unmarshal(raw.ProfileContent, &a.ProfileContent); err != nil {
+			a.ProfileContent = nil
+		}
+	}
+	if len(raw.ExpirationDate) > 0 {
+		if err := json.Unmarshal(raw.ExpirationDate, &a.ExpirationDate); err != nil {
+			a.ExpirationDate = Time{}
+		}
+	}
+	return nil
+}
+
 // Profile ...
 type Profile struct {
 	Attributes ProfileAttributes `json:"attributes"`
@@ -285,7 +316,7 @@ func (s ProvisioningService) Profiles(relationshipLink string, opt *PagingOption
 		return nil, err
 	}
 
-	endpoint := strings.TrimPrefix(u, baseURL+apiVersion)
+	endpoint := relationshipEndpoint(u)
 	req, err := s.client.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, err
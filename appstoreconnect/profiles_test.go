@@ -0,0 +1,96 @@
+package appstoreconnect
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// brokenProfilesPayload is a captured profiles response, listing profiles during their propagation
+const brokenProfilesPayload = `{
+  "data": [
+    {
+      "type": "profiles",
+      "id": "PROPAGATING",
+      "attributes": {
+        "profileState": "ACTIVE",
+        "createdDate": "2021-05-19T08:07:47.000+0000",
+        "profileType": "IOS_APP_DEVELOPMENT",
+        "name": "Bitrise iOS development - (io.bitrise.app)",
+        "profileContent": null,
+        "uuid": "c5be4123-1234-4f9d-9843-0d9be985a068",
+        "platform": "IOS",
+        "expirationDate": null
+      }
+    },
+    {
+      "type": "profiles",
+      "id": "MALFORMED",
+      "attributes": {
+        "profileState": "ACTIVE",
+        "profileType": "IOS_APP_STORE",
+        "name": "Bitrise iOS app-store - (io.bitrise.app)",
+        "profileContent": "not base64!",
+        "uuid": "",
+        "platform": "IOS",
+        "expirationDate": "2022-05-19"
+      }
+    },
+    {
+      "type": "profiles",
+      "id": "NO_ATTRIBUTES",
+      "attributes": null
+    },
+    {
+      "type": "profiles",
+      "id": "COMPLETE",
+      "attributes": {
+        "profileState": "ACTIVE",
+        "profileType": "IOS_APP_ADHOC",
+        "name": "Bitrise iOS ad-hoc - (io.bitrise.app)",
+        "profileContent": "Y29udGVudA==",
+        "uuid": "a5be4123-1234-4f9d-9843-0d9be985a068",
+        "platform": "IOS",
+        "expirationDate": "2022-05-19T08:07:47.000+0000"
+      }
+    }
+  ],
+  "links": {"self": "https://api.appstoreconnect.apple.com/v1/profiles"}
+}`
+
+func TestProvisioningService_ListProfiles_brokenPayload(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(brokenProfilesPayload)); err != nil {
+			t.Errorf("failed to write response: %s", err)
+		}
+	})
+
+	resp, err := client.Provisioning.ListProfiles(&ListProfilesOptions{})
+	if err != nil {
+		t.Fatalf("ListProfiles() error = %v", err)
+	}
+	if len(resp.Data) != 4 {
+		t.Fatalf("ListProfiles() returned %d profiles, want 4", len(resp.Data))
+	}
+
+	for _, profile := range resp.Data[:3] {
+		if len(profile.Attributes.ProfileContent) != 0 {
+			t.Errorf("profile (%s) content = %s, want empty", profile.ID, profile.Attributes.ProfileContent)
+		}
+		if !time.Time(profile.Attributes.ExpirationDate).IsZero() {
+			t.Errorf("profile (%s) expiration date = %v, want zero", profile.ID, time.Time(profile.Attributes.ExpirationDate))
+		}
+	}
+	if got := resp.Data[0].Attributes.Name; got != "Bitrise iOS development - (io.bitrise.app)" {
+		t.Errorf("profile name = %s, the other attributes are parsed", got)
+	}
+
+	complete := resp.Data[3].Attributes
+	if string(complete.ProfileContent) != "content" {
+		t.Errorf("profile content = %s, want content", complete.ProfileContent)
+	}
+	if want := time.Date(2022, 5, 19, 8, 7, 47, 0, time.UTC); !time.Time(complete.ExpirationDate).Equal(want) {
+		t.Errorf("profile expiration date = %v, want %v", time.Time(complete.ExpirationDate), want)
+	}
+}
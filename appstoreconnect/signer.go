@@ -0,0 +1,117 @@
+package appstoreconnect
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"os/exec"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// es256KeySize is the size of the r and s integers of a P-256 ECDSA signature
+const es256KeySize = 32
+
+// Signer creates the ES256 (ECDSA P-256, SHA-256) signature of the JWT signing input with the API private key.
+// It allows using keys which are not available as a .p8 content, like hardware-backed keys.
+// The signature is either ASN.1 DER encoded or the raw 64 bytes long concatenation of r and s.
+type Signer interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+// CryptoSigner signs with a crypto.Signer P-256 ECDSA private key, like an *ecdsa.PrivateKey
+// or a Secure Enclave key implementing the interface.
+type CryptoSigner struct {
+	Key crypto.Signer
+}
+
+// Sign ...
+func (s CryptoSigner) Sign(message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	return s.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// CommandSigner signs by running an external command, for example: `openssl dgst -sha256 -sign key.pem`.
+// The command receives the message on its standard input and has to print the signature to its standard output.
+type CommandSigner struct {
+	Name string
+	Args []string
+}
+
+// NewCommandSigner creates a CommandSigner running the given command line by the shell.
+func NewCommandSigner(command string) CommandSigner {
+	return CommandSigner{Name: "/bin/sh", Args: []string{"-c", command}}
+}
+
+// Sign ...
+func (s CommandSigner) Sign(message []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(s.Name, s.Args...)
+	cmd.Stdin = bytes.NewReader(message)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(stderr.String()); out != "" {
+			return nil, fmt.Errorf("signer command failed: %s: %s", err, out)
+		}
+		return nil, fmt.Errorf("signer command failed: %s", err)
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("signer command did not print a signature")
+	}
+	return stdout.Bytes(), nil
+}
+
+// signTokenWithSigner signs the JWT token with the given Signer
+func signTokenWithSigner(token *jwt.Token, signer Signer) (string, error) {
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := signer.Sign([]byte(signingString))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT token: %s", err)
+	}
+
+	rawSignature, err := es256Signature(signature)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT token: %s", err)
+	}
+
+	return signingString + "." + jwt.EncodeSegment(rawSignature), nil
+}
+
+// es256Signature converts the signature to the JWS format: the concatenation of the big-endian, zero padded r and s values.
+func es256Signature(signature []byte) ([]byte, error) {
+	if len(signature) == 2*es256KeySize {
+		return signature, nil
+	}
+
+	var ecdsaSignature struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(signature, &ecdsaSignature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ECDSA signature: %s", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("invalid ECDSA signature: trailing data")
+	}
+	if ecdsaSignature.R.Sign() <= 0 || ecdsaSignature.S.Sign() <= 0 ||
+		len(ecdsaSignature.R.Bytes()) > es256KeySize || len(ecdsaSignature.S.Bytes()) > es256KeySize {
+		return nil, errors.New("invalid ECDSA signature: not a P-256 signature")
+	}
+
+	raw := make([]byte, 2*es256KeySize)
+	ecdsaSignature.R.FillBytes(raw[:es256KeySize])
+	ecdsaSignature.S.FillBytes(raw[es256KeySize:])
+	return raw, nil
+}
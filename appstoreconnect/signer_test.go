@@ -0,0 +1,145 @@
+package appstoreconnect
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestSignTokenWithSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("setup: generate key: %s", err)
+	}
+
+	signedToken, err := signTokenWithSigner(createToken("KEYID", "ISSUER", ""), CryptoSigner{Key: key})
+	if err != nil {
+		t.Fatalf("signTokenWithSigner() error = %v", err)
+	}
+
+	token, err := jwt.Parse(signedToken, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatalf("jwt.Parse() error = %v", err)
+	}
+	if !token.Valid {
+		t.Errorf("signTokenWithSigner() = %s, not a valid token", signedToken)
+	}
+	if token.Header["kid"] != "KEYID" {
+		t.Errorf("signTokenWithSigner() kid = %v, want KEYID", token.Header["kid"])
+	}
+}
+
+func TestCommandSigner_Sign(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    []byte
+		wantErr string
+	}{
+		{
+			name:    "prints signature",
+			command: "cat",
+			want:    []byte("message"),
+		},
+		{
+			name:    "fails",
+			command: "echo 'key not found' >&2; exit 1",
+			wantErr: "signer command failed: exit status 1: key not found",
+		},
+		{
+			name:    "empty output",
+			command: "true",
+			wantErr: "signer command did not print a signature",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewCommandSigner(tt.command).Sign([]byte("message"))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("CommandSigner.Sign() error = %v, wantErr %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CommandSigner.Sign() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("CommandSigner.Sign() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_es256Signature(t *testing.T) {
+	der := func(r, s *big.Int) []byte {
+		b, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+		if err != nil {
+			t.Fatalf("setup: marshal signature: %s", err)
+		}
+		return b
+	}
+
+	raw := append(bytes.Repeat([]byte{0}, 31), 1)
+	raw = append(raw, bytes.Repeat([]byte{0xff}, 32)...)
+	s := new(big.Int).SetBytes(bytes.Repeat([]byte{0xff}, 32))
+
+	tests := []struct {
+		name      string
+		signature []byte
+		want      []byte
+		wantErr   string
+	}{
+		{
+			name:      "DER encoded, zero padded",
+			signature: der(big.NewInt(1), s),
+			want:      raw,
+		},
+		{
+			name:      "raw",
+			signature: raw,
+			want:      raw,
+		},
+		{
+			name:      "not DER encoded",
+			signature: []byte("signature"),
+			wantErr:   "invalid ECDSA signature",
+		},
+		{
+			name:      "trailing data",
+			signature: append(der(big.NewInt(1), s), 0),
+			wantErr:   "invalid ECDSA signature: trailing data",
+		},
+		{
+			name:      "too large",
+			signature: der(new(big.Int).Lsh(big.NewInt(1), 256), s),
+			wantErr:   "invalid ECDSA signature: not a P-256 signature",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := es256Signature(tt.signature)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("es256Signature() error = %v, wantErr %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("es256Signature() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("es256Signature() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,8 +9,12 @@ import (
 type Time time.Time
 
 // UnmarshalJSON ...
+// Missing (null) values are left as the zero time.
 func (t *Time) UnmarshalJSON(b []byte) error {
 	timeStr := strings.Trim(string(b), `"`)
+	if timeStr == "null" || timeStr == "" {
+		return nil
+	}
 	parsed, err := time.Parse("2006-01-02T15:04:05.000-0700", timeStr)
 	if err != nil {
 		return err
@@ -18,3 +22,8 @@ func (t *Time) UnmarshalJSON(b []byte) error {
 	*t = Time(parsed)
 	return nil
 }
+
+// MarshalJSON ...
+func (t Time) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + time.Time(t).Format("2006-01-02T15:04:05.000-0700") + `"`), nil
+}
@@ -12,6 +12,8 @@ func TestTime_UnmarshalJSON(t *testing.T) {
 	}{
 		{name: "without quotation mark", b: []byte("2021-05-19T08:07:47.000+0000"), wantErr: false},
 		{name: "with quotation mark", b: []byte(`"2021-05-19T08:07:47.000+0000"`), wantErr: false},
+		{name: "null", b: []byte("null"), wantErr: false},
+		{name: "malformed", b: []byte(`"2021-05-19"`), wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -22,3 +24,20 @@ func TestTime_UnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestTime_MarshalJSON(t *testing.T) {
+	want := `"2021-05-19T08:07:47.000+0000"`
+
+	parsed := &Time{}
+	if err := parsed.UnmarshalJSON([]byte(want)); err != nil {
+		t.Fatalf("Time.UnmarshalJSON() error = %v", err)
+	}
+
+	got, err := parsed.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Time.MarshalJSON() error = %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("Time.MarshalJSON() = %s, want %s", got, want)
+	}
+}
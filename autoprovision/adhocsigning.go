@@ -0,0 +1,54 @@
+package autoprovision
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// adhocSigningSDKs are the SDKs, the ad-hoc signing settings are repeated for,
+// so that they override the SDK conditional code signing settings of the project too.
+var adhocSigningSDKs = []string{"iphoneos*", "iphonesimulator*", "appletvos*", "appletvsimulator*", "watchos*", "watchsimulator*", "xros*", "xrsimulator*", "macosx*"}
+
+// AdhocSigningXcconfig returns the xcconfig content, which configures ad-hoc code signing (CODE_SIGN_IDENTITY = -) without provisioning profiles.
+// Ad-hoc signed builds run on the simulator and in unit tests only, they can not be installed on devices nor archived for distribution.
+func AdhocSigningXcconfig() string {
+	settings := [][2]string{
+		{"CODE_SIGN_IDENTITY", "-"},
+		{"CODE_SIGN_STYLE", "Manual"},
+		{"DEVELOPMENT_TEAM", ""},
+		{"PROVISIONING_PROFILE", ""},
+		{"PROVISIONING_PROFILE_SPECIFIER", ""},
+	}
+
+	var b strings.Builder
+	b.WriteString("// Ad-hoc code signing for simulator and unit test builds, generated by the iOS Auto Provision with App Store Connect API Step\n")
+	for _, setting := range settings {
+		fmt.Fprintf(&b, "%s = %s\n", setting[0], setting[1])
+	}
+	for _, sdk := range adhocSigningSDKs {
+		fmt.Fprintf(&b, "CODE_SIGN_IDENTITY[sdk=%s] = -\n", sdk)
+		fmt.Fprintf(&b, "PROVISIONING_PROFILE_SPECIFIER[sdk=%s] =\n", sdk)
+	}
+	return b.String()
+}
+
+// WriteAdhocSigningXcconfig writes the ad-hoc signing xcconfig to the given path, or to a new file in the system temp dir if the path is empty.
+// It returns the path of the xcconfig file.
+func WriteAdhocSigningXcconfig(pth string) (string, error) {
+	if pth == "" {
+		f, err := ioutil.TempFile("", "auto-provision-adhoc-signing-*.xcconfig")
+		if err != nil {
+			return "", fmt.Errorf("failed to create ad-hoc signing xcconfig: %s", err)
+		}
+		if err := f.Close(); err != nil {
+			return "", fmt.Errorf("failed to create ad-hoc signing xcconfig: %s", err)
+		}
+		pth = f.Name()
+	}
+
+	if err := writeFileAtomic(pth, []byte(AdhocSigningXcconfig())); err != nil {
+		return "", fmt.Errorf("failed to write ad-hoc signing xcconfig (%s): %s", pth, err)
+	}
+	return pth, nil
+}
@@ -0,0 +1,33 @@
+package autoprovision
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAdhocSigningXcconfig(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "adhoc.xcconfig")
+	got, err := WriteAdhocSigningXcconfig(pth)
+	require.NoError(t, err)
+	require.Equal(t, pth, got)
+
+	content, err := ioutil.ReadFile(pth)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "\nCODE_SIGN_IDENTITY = -\n")
+	require.Contains(t, string(content), "\nCODE_SIGN_IDENTITY[sdk=iphoneos*] = -\n")
+	require.Contains(t, string(content), "\nPROVISIONING_PROFILE_SPECIFIER = \n")
+
+	got, err = WriteAdhocSigningXcconfig("")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Remove(got))
+	}()
+	require.Equal(t, ".xcconfig", filepath.Ext(got))
+	tempContent, err := ioutil.ReadFile(got)
+	require.NoError(t, err)
+	require.Equal(t, content, tempContent)
+}
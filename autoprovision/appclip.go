@@ -0,0 +1,105 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+const (
+	// onDemandInstallCapableEntitlementKey enables the On Demand Install Capable (App Clip) capability of the App Clip's app ID
+	onDemandInstallCapableEntitlementKey = "com.apple.developer.on-demand-install-capable"
+	// parentApplicationIdentifiersEntitlementKey lists the application identifiers of the apps embedding the App Clip,
+	// the Developer Portal adds it to the profiles of the App Clip from its parent app ID
+	parentApplicationIdentifiersEntitlementKey = "com.apple.developer.parent-application-identifiers"
+)
+
+// AppClipBundleIDs returns the sorted bundle IDs of the main target's App Clip targets built for archiving.
+func (p *ProjectHelper) AppClipBundleIDs() ([]string, error) {
+	var bundleIDs []string
+	for _, target := range p.dependentTargets() {
+		if p.targetKind(target) != AppClipTarget || p.NotArchivedTargetIDs[target.ID] || p.failedTargetIDs[target.ID] {
+			continue
+		}
+		bundleID, err := p.TargetBundleID(target.Name, p.Configuration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get target (%s) bundle id: %s", target.Name, err)
+		}
+		bundleIDs = append(bundleIDs, bundleID)
+	}
+	sort.Strings(bundleIDs)
+	return bundleIDs, nil
+}
+
+// AppClipEntitlements returns the entitlements of the App Clip with the On Demand Install Capable capability enabled,
+// so that its app ID is registered as an App Clip. It returns an error if the parent application identifiers of the entitlements
+// do not list the parent app (the host app embedding the App Clip), as its profiles would not include the parent app.
+func AppClipEntitlements(entitlements serialized.Object, bundleID, parentBundleID string) (serialized.Object, error) {
+	parentIdentifiers, err := entitlements.StringSlice(parentApplicationIdentifiersEntitlementKey)
+	if err != nil && !serialized.IsKeyNotFoundError(err) {
+		return nil, fmt.Errorf("invalid %s entitlement of App Clip (%s): %s", parentApplicationIdentifiersEntitlementKey, bundleID, err)
+	}
+
+	found := false
+	for _, identifier := range parentIdentifiers {
+		if applicationIdentifierBundleID(identifier) == parentBundleID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("the %s entitlement of App Clip (%s) does not list the parent app (%s): %s, add $(AppIdentifierPrefix)%s to it",
+			parentApplicationIdentifiersEntitlementKey, bundleID, parentBundleID, strings.Join(parentIdentifiers, ", "), parentBundleID)
+	}
+
+	appClipEntitlements := serialized.Object{}
+	for key, value := range entitlements {
+		appClipEntitlements[key] = value
+	}
+	if _, ok := appClipEntitlements[onDemandInstallCapableEntitlementKey]; !ok {
+		appClipEntitlements[onDemandInstallCapableEntitlementKey] = true
+	}
+	return appClipEntitlements, nil
+}
+
+// findMissingParentApplications returns the bundle IDs of the project's App Clip parent apps, which are not listed by the profile.
+// The Developer Portal adds the parent app to the profile from the App Clip's app ID, a profile without it can not sign the App Clip.
+func findMissingParentApplications(projectEnts, profileEnts serialized.Object) ([]string, error) {
+	projectIdentifiers, err := projectEnts.StringSlice(parentApplicationIdentifiersEntitlementKey)
+	if err != nil {
+		if serialized.IsKeyNotFoundError(err) {
+			return nil, nil // not an App Clip
+		}
+		return nil, err
+	}
+
+	profileIdentifiers, err := profileEnts.StringSlice(parentApplicationIdentifiersEntitlementKey)
+	if err != nil && !serialized.IsKeyNotFoundError(err) {
+		return nil, err
+	}
+	profileBundleIDs := map[string]bool{}
+	for _, identifier := range profileIdentifiers {
+		profileBundleIDs[applicationIdentifierBundleID(identifier)] = true
+	}
+
+	var missing []string
+	for _, identifier := range projectIdentifiers {
+		if bundleID := applicationIdentifierBundleID(identifier); !profileBundleIDs[bundleID] {
+			missing = append(missing, bundleID)
+		}
+	}
+	return missing, nil
+}
+
+// applicationIdentifierBundleID returns the bundle ID of an application identifier: <team ID>.<bundle ID> or $(AppIdentifierPrefix)<bundle ID>
+func applicationIdentifierBundleID(applicationIdentifier string) string {
+	if i := strings.LastIndex(applicationIdentifier, ")"); i >= 0 {
+		return applicationIdentifier[i+1:]
+	}
+	if i := strings.Index(applicationIdentifier, "."); i >= 0 {
+		return applicationIdentifier[i+1:]
+	}
+	return applicationIdentifier
+}
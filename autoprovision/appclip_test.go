@@ -0,0 +1,126 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppClipBundleIDs(t *testing.T) {
+	clip := xcodeproj.Target{ID: "CLIP", Name: "Clip", ProductType: appClipProductType, ProductReference: xcodeproj.ProductReference{Path: "Clip.app"}}
+	extension := xcodeproj.Target{ID: "EXTENSION", Name: "Extension", ProductType: "com.apple.product-type.app-extension", ProductReference: xcodeproj.ProductReference{Path: "Extension.appex"}}
+	app := xcodeproj.Target{
+		ID:               "APP",
+		Name:             "App",
+		ProductType:      "com.apple.product-type.application",
+		ProductReference: xcodeproj.ProductReference{Path: "App.app"},
+		Dependencies:     []xcodeproj.TargetDependency{{Target: extension}, {Target: clip}},
+	}
+
+	p := ProjectHelper{
+		MainTarget:    app,
+		Configuration: "Release",
+		buildSettingsCache: map[string]map[string]serialized.Object{
+			"App":       {"Release": {"PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app"}},
+			"Extension": {"Release": {"PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app.extension"}},
+			"Clip":      {"Release": {"PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app.Clip"}},
+		},
+	}
+
+	bundleIDs, err := p.AppClipBundleIDs()
+	require.NoError(t, err)
+	require.Equal(t, []string{"io.bitrise.app.Clip"}, bundleIDs)
+
+	p.NotArchivedTargetIDs = map[string]bool{"CLIP": true}
+	bundleIDs, err = p.AppClipBundleIDs()
+	require.NoError(t, err)
+	require.Empty(t, bundleIDs)
+}
+
+func TestAppClipEntitlements(t *testing.T) {
+	tests := []struct {
+		name              string
+		entitlements      serialized.Object
+		want              serialized.Object
+		wantErrorContains string
+	}{
+		{
+			name: "parent app with the app identifier prefix variable",
+			entitlements: serialized.Object{
+				"com.apple.developer.on-demand-install-capable":      true,
+				"com.apple.developer.parent-application-identifiers": []interface{}{"$(AppIdentifierPrefix)io.bitrise.app"},
+			},
+			want: serialized.Object{
+				"com.apple.developer.on-demand-install-capable":      true,
+				"com.apple.developer.parent-application-identifiers": []interface{}{"$(AppIdentifierPrefix)io.bitrise.app"},
+			},
+		},
+		{
+			name: "missing On Demand Install Capable entitlement is added",
+			entitlements: serialized.Object{
+				"com.apple.developer.parent-application-identifiers": []interface{}{"ABCDE12345.io.bitrise.app"},
+			},
+			want: serialized.Object{
+				"com.apple.developer.on-demand-install-capable":      true,
+				"com.apple.developer.parent-application-identifiers": []interface{}{"ABCDE12345.io.bitrise.app"},
+			},
+		},
+		{
+			name: "other parent app",
+			entitlements: serialized.Object{
+				"com.apple.developer.parent-application-identifiers": []interface{}{"$(AppIdentifierPrefix)io.bitrise.other"},
+			},
+			wantErrorContains: "does not list the parent app (io.bitrise.app): $(AppIdentifierPrefix)io.bitrise.other",
+		},
+		{
+			name:              "no parent app",
+			entitlements:      serialized.Object{},
+			wantErrorContains: "does not list the parent app (io.bitrise.app)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AppClipEntitlements(tt.entitlements, "io.bitrise.app.Clip", "io.bitrise.app")
+			if tt.wantErrorContains != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErrorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEntitlement_Capability_appClip(t *testing.T) {
+	capability, err := Entitlement{onDemandInstallCapableEntitlementKey: true}.Capability()
+	require.NoError(t, err)
+	require.Equal(t, appstoreconnect.OnDemandInstallCapable, capability.Attributes.CapabilityType)
+
+	capability, err = Entitlement{parentApplicationIdentifiersEntitlementKey: []interface{}{"$(AppIdentifierPrefix)io.bitrise.app"}}.Capability()
+	require.NoError(t, err)
+	require.Nil(t, capability, "the parent app of the App Clip is not a capability of its app ID")
+}
+
+func Test_findMissingParentApplications(t *testing.T) {
+	projectEnts := serialized.Object{parentApplicationIdentifiersEntitlementKey: []interface{}{"$(AppIdentifierPrefix)io.bitrise.app"}}
+
+	missing, err := findMissingParentApplications(projectEnts, serialized.Object{parentApplicationIdentifiersEntitlementKey: []interface{}{"TEAM123.io.bitrise.app"}})
+	require.NoError(t, err)
+	require.Empty(t, missing)
+
+	missing, err = findMissingParentApplications(projectEnts, serialized.Object{parentApplicationIdentifiersEntitlementKey: []interface{}{"TEAM123.io.bitrise.other"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"io.bitrise.app"}, missing)
+
+	missing, err = findMissingParentApplications(projectEnts, serialized.Object{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"io.bitrise.app"}, missing)
+
+	missing, err = findMissingParentApplications(serialized.Object{}, serialized.Object{})
+	require.NoError(t, err)
+	require.Empty(t, missing, "not an App Clip")
+}
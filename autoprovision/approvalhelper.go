@@ -0,0 +1,173 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// ApprovalStatus describes whether Apple granted an entitlement, which requires approval, for an app ID
+type ApprovalStatus string
+
+// ApprovalStatuses ...
+const (
+	ApprovalGranted    ApprovalStatus = "granted"
+	ApprovalNotGranted ApprovalStatus = "not granted"
+)
+
+// EntitlementApproval is the approval status of an entitlement for a bundle ID
+type EntitlementApproval struct {
+	BundleID    string
+	Entitlement string
+	Status      ApprovalStatus
+	Reason      string
+}
+
+func (a EntitlementApproval) String() string {
+	return fmt.Sprintf("%s: %s is %s (%s)", a.BundleID, a.Entitlement, a.Status, a.Reason)
+}
+
+// approvalCapabilityTypes maps the entitlements, which require Apple's approval, to the capability,
+// which Apple enables on the app ID once the request is granted.
+// The API does not let the step enable these capabilities, it only lists them.
+var approvalCapabilityTypes = map[string]appstoreconnect.CapabilityType{
+	"com.apple.developer.contacts.notes":                    "CONTACTS_NOTES",
+	"com.apple.developer.carplay-audio":                     "CARPLAY_AUDIO",
+	"com.apple.developer.carplay-communication":             "CARPLAY_COMMUNICATION",
+	"com.apple.developer.carplay-charging":                  "CARPLAY_CHARGING",
+	"com.apple.developer.carplay-maps":                      "CARPLAY_MAPS",
+	"com.apple.developer.carplay-parking":                   "CARPLAY_PARKING",
+	"com.apple.developer.carplay-quick-ordering":            "CARPLAY_QUICK_ORDERING",
+	"com.apple.developer.exposure-notification":             "EXPOSURE_NOTIFICATION",
+	"com.apple.developer.usernotifications.critical-alerts": "CRITICAL_ALERTS",
+	"com.apple.developer.calling-app":                       "DEFAULT_CALLING_APP",
+	"com.apple.developer.messaging-app":                     "DEFAULT_MESSAGING_APP",
+}
+
+// CheckEntitlementApprovals returns the approval status of the entitlements, which require Apple's approval (for example CarPlay),
+// for every bundle ID.
+// An entitlement is considered granted, if its capability is enabled on the app ID.
+func CheckEntitlementApprovals(client *appstoreconnect.Client, entitlementsByBundleID map[string]serialized.Object) ([]EntitlementApproval, error) {
+	var approvals []EntitlementApproval
+
+	for bundleIDIdentifier, entitlements := range entitlementsByBundleID {
+		keys := approvalRequiredEntitlementKeys(Entitlement(entitlements))
+		if len(keys) == 0 {
+			continue
+		}
+
+		bundleID, err := FindBundleID(client, bundleIDIdentifier)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find bundle ID (%s): %s", bundleIDIdentifier, err)
+		}
+
+		if bundleID == nil {
+			for _, key := range keys {
+				approvals = append(approvals, EntitlementApproval{
+					BundleID:    bundleIDIdentifier,
+					Entitlement: key,
+					Status:      ApprovalNotGranted,
+					Reason:      "app ID is not registered on Developer Portal",
+				})
+			}
+			continue
+		}
+
+		response, err := client.Provisioning.Capabilities(bundleID.Relationships.Capabilities.Links.Related)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list capabilities of bundle ID (%s): %s", bundleIDIdentifier, err)
+		}
+
+		for _, key := range keys {
+			approval := entitlementApproval(key, response.Data)
+			approval.BundleID = bundleIDIdentifier
+			approvals = append(approvals, approval)
+		}
+	}
+
+	sort.SliceStable(approvals, func(i, j int) bool {
+		if approvals[i].BundleID != approvals[j].BundleID {
+			return approvals[i].BundleID < approvals[j].BundleID
+		}
+		return approvals[i].Entitlement < approvals[j].Entitlement
+	})
+
+	return approvals, nil
+}
+
+func approvalRequiredEntitlementKeys(entitlements Entitlement) []string {
+	var keys []string
+	for key, value := range entitlements {
+		if (Entitlement{key: value}).IsProfileAttached() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func entitlementApproval(key string, capabilities []appstoreconnect.BundleIDCapability) EntitlementApproval {
+	capabilityType, ok := approvalCapabilityTypes[key]
+	if !ok {
+		return EntitlementApproval{
+			Entitlement: key,
+			Status:      ApprovalNotGranted,
+			Reason:      "the capability of the entitlement is unknown, check the app ID on Developer Portal",
+		}
+	}
+
+	for _, capability := range capabilities {
+		if capability.Attributes.CapabilityType == capabilityType {
+			return EntitlementApproval{
+				Entitlement: key,
+				Status:      ApprovalGranted,
+				Reason:      fmt.Sprintf("capability (%s) is enabled on the app ID", capabilityType),
+			}
+		}
+	}
+
+	return EntitlementApproval{
+		Entitlement: key,
+		Status:      ApprovalNotGranted,
+		Reason:      fmt.Sprintf("the app ID has no %s capability, request the entitlement from Apple", capabilityType),
+	}
+}
+
+// AllApprovalsGranted reports whether every entitlement requiring approval is granted
+func AllApprovalsGranted(approvals []EntitlementApproval) bool {
+	for _, approval := range approvals {
+		if approval.Status != ApprovalGranted {
+			return false
+		}
+	}
+	return true
+}
+
+// checkProfileApprovalEntitlements checks if the profile includes the project's entitlements, which require Apple's approval.
+func checkProfileApprovalEntitlements(profileEntitlements serialized.Object, projectEntitlements Entitlement) error {
+	for _, key := range approvalRequiredEntitlementKeys(projectEntitlements) {
+		if _, ok := profileEntitlements[key]; !ok {
+			return NonmatchingProfileError{
+				Reason: fmt.Sprintf("profile does not include the entitlement (%s), which requires Apple's approval", key),
+			}
+		}
+	}
+	return nil
+}
+
+// CheckProfileApprovalEntitlements checks if the profile includes the project's entitlements, which require Apple's approval.
+// Apple includes these entitlements in the generated profiles only if the capability is granted and enabled for the app ID.
+func CheckProfileApprovalEntitlements(prof appstoreconnect.Profile, projectEntitlements Entitlement) error {
+	if len(approvalRequiredEntitlementKeys(projectEntitlements)) == 0 {
+		return nil
+	}
+
+	profileEnts, err := parseRawProfileEntitlements(prof)
+	if err != nil {
+		return err
+	}
+
+	return checkProfileApprovalEntitlements(profileEnts, projectEntitlements)
+}
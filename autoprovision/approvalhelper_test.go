@@ -0,0 +1,128 @@
+package autoprovision
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+func Test_approvalRequiredEntitlementKeys(t *testing.T) {
+	entitlements := Entitlement{
+		"aps-environment":                                  "development",
+		"com.apple.developer.carplay-maps":                 true,
+		"com.apple.developer.carplay-audio":                true,
+		"com.apple.developer.icloud-container-identifiers": []interface{}{},
+	}
+
+	got := approvalRequiredEntitlementKeys(entitlements)
+	want := []string{"com.apple.developer.carplay-audio", "com.apple.developer.carplay-maps"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("approvalRequiredEntitlementKeys() = %v, want %v", got, want)
+	}
+}
+
+func Test_entitlementApproval(t *testing.T) {
+	capability := func(capabilityType appstoreconnect.CapabilityType) appstoreconnect.BundleIDCapability {
+		return appstoreconnect.BundleIDCapability{Attributes: appstoreconnect.BundleIDCapabilityAttributes{CapabilityType: capabilityType}}
+	}
+
+	tests := []struct {
+		name         string
+		key          string
+		capabilities []appstoreconnect.BundleIDCapability
+		wantStatus   ApprovalStatus
+		wantReason   string
+	}{
+		{
+			name:         "no capabilities",
+			key:          "com.apple.developer.carplay-maps",
+			capabilities: nil,
+			wantStatus:   ApprovalNotGranted,
+			wantReason:   "the app ID has no CARPLAY_MAPS capability, request the entitlement from Apple",
+		},
+		{
+			name:         "app ID without the capability",
+			key:          "com.apple.developer.carplay-maps",
+			capabilities: []appstoreconnect.BundleIDCapability{capability(appstoreconnect.PushNotifications), capability("CARPLAY_AUDIO")},
+			wantStatus:   ApprovalNotGranted,
+			wantReason:   "the app ID has no CARPLAY_MAPS capability, request the entitlement from Apple",
+		},
+		{
+			name:         "app ID with the capability",
+			key:          "com.apple.developer.carplay-maps",
+			capabilities: []appstoreconnect.BundleIDCapability{capability(appstoreconnect.PushNotifications), capability("CARPLAY_MAPS")},
+			wantStatus:   ApprovalGranted,
+			wantReason:   "capability (CARPLAY_MAPS) is enabled on the app ID",
+		},
+		{
+			name:         "unknown capability",
+			key:          "com.apple.developer.unknown-approval",
+			capabilities: []appstoreconnect.BundleIDCapability{capability("UNKNOWN_APPROVAL")},
+			wantStatus:   ApprovalNotGranted,
+			wantReason:   "the capability of the entitlement is unknown, check the app ID on Developer Portal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := entitlementApproval(tt.key, tt.capabilities)
+			if got.Status != tt.wantStatus {
+				t.Errorf("entitlementApproval() status = %v, want %v", got.Status, tt.wantStatus)
+			}
+			if got.Reason != tt.wantReason {
+				t.Errorf("entitlementApproval() reason = %v, want %v", got.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_approvalCapabilityTypes(t *testing.T) {
+	for key, capabilityType := range appstoreconnect.ServiceTypeByKey {
+		if capabilityType != appstoreconnect.ProfileAttachedEntitlement {
+			continue
+		}
+		if _, ok := approvalCapabilityTypes[key]; !ok {
+			t.Errorf("approvalCapabilityTypes has no capability for %s", key)
+		}
+	}
+}
+
+func Test_checkProfileApprovalEntitlements(t *testing.T) {
+	tests := []struct {
+		name                string
+		profileEntitlements serialized.Object
+		projectEntitlements Entitlement
+		wantErr             bool
+	}{
+		{
+			name:                "no entitlement requiring approval",
+			profileEntitlements: serialized.Object{},
+			projectEntitlements: Entitlement{"aps-environment": "development"},
+			wantErr:             false,
+		},
+		{
+			name:                "profile includes the granted entitlements",
+			profileEntitlements: serialized.Object{"com.apple.developer.usernotifications.critical-alerts": true, "com.apple.developer.calling-app": true},
+			projectEntitlements: Entitlement{"com.apple.developer.usernotifications.critical-alerts": true, "com.apple.developer.calling-app": true},
+			wantErr:             false,
+		},
+		{
+			name:                "profile misses an entitlement",
+			profileEntitlements: serialized.Object{"com.apple.developer.usernotifications.critical-alerts": true},
+			projectEntitlements: Entitlement{"com.apple.developer.usernotifications.critical-alerts": true, "com.apple.developer.messaging-app": true},
+			wantErr:             true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkProfileApprovalEntitlements(tt.profileEntitlements, tt.projectEntitlements)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkProfileApprovalEntitlements() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, ok := err.(NonmatchingProfileError); err != nil && !ok {
+				t.Errorf("checkProfileApprovalEntitlements() error type = %T, want NonmatchingProfileError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// AutoProvisioner ensures the code signing assets of an app on the Developer Portal: the certificates, the test devices and the profiles.
+// It is the library API of the Step, so that other Go tools can provision apps the same way:
+//
+//	client := appstoreconnect.NewClient(http.DefaultClient, keyID, issuerID, privateKey)
+//	provisioner := autoprovision.NewAutoProvisioner(client, nil, nil)
+//	certsByType, distrTypes, err := provisioner.EnsureCertificates(certs, autoprovision.IOS, autoprovision.AdHoc, "")
+//	registration, err := provisioner.EnsureDevices(appstoreconnect.IOSDevice, testDevices, distrTypes)
+//	profiles, err := provisioner.EnsureProfiles(autoprovision.ProfileRequest{Platform: autoprovision.IOS, Distribution: autoprovision.AdHoc, EntitlementsByBundleID: entitlementsByBundleID, CertIDs: certIDs, Devices: registration.Devices})
+//
+// The clients are injected: the Client can talk to the App Store Connect API or to a provisioning server (appstoreconnect.NewRemoteClient).
+type AutoProvisioner struct {
+	Client *appstoreconnect.Client
+	// Session caches the Developer Portal state of the run, it can be nil
+	Session *Session
+	// PortalChanges limits and records the Developer Portal changes, it can be nil
+	PortalChanges *PortalChanges
+	// Profiles ensures the app IDs and the profiles, its options (for example the build cache) can be set
+	Profiles *ProfileManager
+	// ProfileConcurrency is the maximum number of bundle IDs, the profiles are ensured for in parallel
+	ProfileConcurrency int
+	// VerboseLog logs the details of the certificate checks
+	VerboseLog bool
+	// IgnoreFailure is called with the device registration failures ignored in lenient mode, the failures are returned if it is nil
+	IgnoreFailure func(format string, args ...interface{})
+}
+
+// NewAutoProvisioner returns an AutoProvisioner using the client, the session and the portal changes can be nil.
+func NewAutoProvisioner(client *appstoreconnect.Client, session *Session, portalChanges *PortalChanges) *AutoProvisioner {
+	return &AutoProvisioner{
+		Client:             client,
+		Session:            session,
+		PortalChanges:      portalChanges,
+		Profiles:           NewProfileManager(client, session, portalChanges, nil),
+		ProfileConcurrency: 1,
+	}
+}
+
+// RequiredCertificateTypes returns the certificate types to sign the distribution type with, by whether they are required,
+// and the distribution types to generate profiles for: the distribution type and the development type, if it is a distribution build.
+func RequiredCertificateTypes(platform Platform, distrType DistributionType) (map[appstoreconnect.CertificateType]bool, []DistributionType, error) {
+	certType, ok := CertificateType(platform, distrType)
+	if !ok {
+		return nil, nil, fmt.Errorf("no certificate type for the %s distribution type of the %s platform", distrType, platform)
+	}
+
+	distrTypes := []DistributionType{distrType}
+	requiredCertTypes := map[appstoreconnect.CertificateType]bool{certType: true}
+	if distrType != Development {
+		developmentCertType, _ := CertificateType(platform, Development)
+		distrTypes = append(distrTypes, Development)
+		requiredCertTypes[developmentCertType] = false
+	}
+
+	if installerCertType, ok := InstallerCertificateType(platform, distrType); ok {
+		requiredCertTypes[installerCertType] = true
+	}
+	return requiredCertTypes, distrTypes, nil
+}
+
+// DistributionTypesWithCertificates returns the distribution types with a valid certificate,
+// the development type is dropped if no development certificate was provided for a distribution build.
+func DistributionTypesWithCertificates(platform Platform, distrTypes []DistributionType, certsByType map[appstoreconnect.CertificateType][]APICertificate) []DistributionType {
+	var withCertificates []DistributionType
+	for _, distrType := range distrTypes {
+		certType, _ := CertificateType(platform, distrType)
+		if _, ok := certsByType[certType]; ok {
+			withCertificates = append(withCertificates, distrType)
+		}
+	}
+	return withCertificates
+}
+
+// EnsureCertificates returns the valid certificates by their type, which are uploaded to the Developer Portal,
+// and the distribution types they can sign. A MissingCertificateError is returned, if a required certificate is not provided.
+func (a AutoProvisioner) EnsureCertificates(certs []certificateutil.CertificateInfoModel, platform Platform, distrType DistributionType, teamID string) (map[appstoreconnect.CertificateType][]APICertificate, []DistributionType, error) {
+	requiredCertTypes, distrTypes, err := RequiredCertificateTypes(platform, distrType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certsByType, err := GetValidCertificates(certs, APIClientWithSession(a.Client, a.Session), requiredCertTypes, teamID, a.VerboseLog)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certsByType, DistributionTypesWithCertificates(platform, distrTypes, certsByType), nil
+}
+
+// TestDevice is a device to register on the Developer Portal, like the test devices of the Bitrise account
+type TestDevice struct {
+	UDID       string
+	Title      string
+	DeviceType string
+}
+
+// FilterTestDevices returns the test devices valid for the device platform and the skipped devices with the reason.
+func FilterTestDevices(testDevices []TestDevice, platform appstoreconnect.DevicePlatform) ([]TestDevice, []string) {
+	var valid []TestDevice
+	var skipped []string
+	for _, testDevice := range testDevices {
+		if reason := TestDeviceSkipReason(testDevice.UDID, testDevice.DeviceType, platform); reason != "" {
+			log.Debugf("skipping the registration of the device (%s) for the %s platform: %s", testDevice.UDID, platform, reason)
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", testDevice.UDID, reason))
+			continue
+		}
+		valid = append(valid, testDevice)
+	}
+	return valid, skipped
+}
+
+// DeviceRegistration is the outcome of EnsureDevices
+type DeviceRegistration struct {
+	// Devices are the unique devices of the platform on the Developer Portal, including the registered test devices
+	Devices []appstoreconnect.Device
+	// Registered are the test devices registered by the run
+	Registered []appstoreconnect.Device
+	// Duplicates are the devices registered multiple times with equivalent UDIDs
+	Duplicates []appstoreconnect.Device
+	Summary    DeviceRegistrationSummary
+}
+
+// EnsureDevices registers the test devices, valid for the device platform and not registered yet, on the Developer Portal
+// and returns the devices of the platform. The distribution types needing the devices are recorded as the reason of the registrations.
+// A device the Developer Portal refuses to register fails the registration, unless the failure is ignored (IgnoreFailure is set).
+func (a AutoProvisioner) EnsureDevices(platform appstoreconnect.DevicePlatform, testDevices []TestDevice, distrTypes []DistributionType) (DeviceRegistration, error) {
+	var registration DeviceRegistration
+	testDevices, registration.Summary.Skipped = FilterTestDevices(testDevices, platform)
+
+	devices, err := a.Session.ListDevices(a.Client, platform)
+	if err != nil {
+		return DeviceRegistration{}, fmt.Errorf("failed to list devices: %s", err)
+	}
+
+	log.Printf("%d devices are registered on Developer Portal", len(devices))
+	for _, d := range devices {
+		log.Debugf("- %s", DeviceDescription(d, DeveloperPortalDevice))
+	}
+
+	registration.Devices, registration.Duplicates = UniqueDevices(devices)
+
+	for _, testDevice := range testDevices {
+		log.Printf("checking if the device (%s) is registered", testDevice.UDID)
+
+		if device := FindDeviceByUDID(registration.Devices, testDevice.UDID); device != nil {
+			log.Printf("device already registered (UDID: %s)", device.Attributes.UDID)
+			registration.Summary.AlreadyRegistered = append(registration.Summary.AlreadyRegistered, testDevice.UDID)
+			continue
+		}
+
+		log.Printf("registering device")
+		change := PortalChange{Action: RegisterDeviceChange, Subject: testDevice.UDID, Reason: fmt.Sprintf("test device (%s) of the Bitrise account, needed by the distribution types: %s", testDevice.Title, distrTypes)}
+		if err := a.PortalChanges.Register(change); err != nil {
+			return DeviceRegistration{}, err
+		}
+		if a.PortalChanges.IsDryRun() {
+			continue
+		}
+
+		req := appstoreconnect.DeviceCreateRequest{
+			Data: appstoreconnect.DeviceCreateRequestData{
+				Attributes: appstoreconnect.DeviceCreateRequestDataAttributes{
+					Name:     DeviceName(testDevice.Title, testDevice.DeviceType),
+					Platform: deviceRegistrationPlatform(platform),
+					UDID:     testDevice.UDID,
+				},
+				Type: "devices",
+			},
+		}
+
+		resp, err := a.Client.Provisioning.RegisterNewDevice(req)
+		a.PortalChanges.RecordOutcome(change, err)
+		if err != nil {
+			registration.Summary.Rejected = append(registration.Summary.Rejected, fmt.Sprintf("%s (%s)", testDevice.UDID, err))
+			if a.IgnoreFailure == nil {
+				return DeviceRegistration{}, fmt.Errorf("failed to register device (%s): %s", testDevice.UDID, err)
+			}
+			a.IgnoreFailure("Failed to register device (%s): %s", testDevice.UDID, err)
+			continue
+		}
+		registration.Summary.Registered = append(registration.Summary.Registered, testDevice.UDID)
+
+		registration.Devices = append(registration.Devices, resp.Data)
+		registration.Registered = append(registration.Registered, resp.Data)
+		a.Session.AddDevice(platform, resp.Data)
+	}
+	return registration, nil
+}
+
+// deviceRegistrationPlatform returns the platform the devices of the device platform are registered with
+func deviceRegistrationPlatform(devicePlatform appstoreconnect.DevicePlatform) appstoreconnect.BundleIDPlatform {
+	if devicePlatform == appstoreconnect.MacOSDevice {
+		return appstoreconnect.MacOS
+	}
+	return appstoreconnect.IOS
+}
+
+// ProfileRequest describes the profiles of a distribution type, to ensure for the bundle IDs of an app
+type ProfileRequest struct {
+	Platform     Platform
+	Distribution DistributionType
+	// EntitlementsByBundleID are the bundle IDs to ensure the profiles for, with their entitlements
+	EntitlementsByBundleID map[string]serialized.Object
+	CertIDs                []string
+	// Devices are included in the development and ad-hoc profiles, the devices of a profile are selected by its platform
+	Devices []appstoreconnect.Device
+	// WatchBundleIDs are the watchOS targets of the iOS app, their profiles include the Apple Watches too
+	WatchBundleIDs map[string]bool
+	// AppClipBundleIDs are the App Clip targets of the app, they have no Mac Catalyst variant
+	AppClipBundleIDs map[string]bool
+	// MacCatalyst ensures the Mac Catalyst profiles of the iOS targets (SUPPORTS_MACCATALYST = YES) too
+	MacCatalyst         bool
+	MinProfileDaysValid int
+}
+
+// PlanChanges calls plan with a dry run copy of the AutoProvisioner and returns the Developer Portal changes it plans.
+// The copy shares the client and the session, but makes no change on the Developer Portal,
+// so the plan can be checked (for example against the max_portal_changes limit) before the first change is made.
+func (a AutoProvisioner) PlanChanges(plan func(planner AutoProvisioner) error) ([]PortalChange, error) {
+	planner := a
+	planner.PortalChanges = a.PortalChanges.planCopy()
+	if a.Profiles != nil {
+		planner.Profiles = a.Profiles.planCopy(planner.PortalChanges)
+	}
+	if err := plan(planner); err != nil {
+		return nil, err
+	}
+	return planner.PortalChanges.Changes, nil
+}
+
+// EnsuredProfiles are the profiles of a ProfileRequest by the bundle ID
+type EnsuredProfiles struct {
+	ProfilesByBundleID map[string]appstoreconnect.Profile
+	// MacCatalystProfilesByBundleID sign the Mac Catalyst variant of the iOS targets
+	MacCatalystProfilesByBundleID map[string]appstoreconnect.Profile
+}
+
+// profileSpec is a profile of a bundle ID: its platform, type and devices
+type profileSpec struct {
+	platform    Platform
+	profileType appstoreconnect.ProfileType
+	deviceIDs   []string
+}
+
+// profileSpecs returns the profile of the bundle ID and its Mac Catalyst variant, nil if the bundle ID has no Mac Catalyst profile.
+func (r ProfileRequest) profileSpecs(bundleID string) (profileSpec, *profileSpec, error) {
+	profileType, ok := PlatformToProfileTypeByDistribution[r.Platform][r.Distribution]
+	if !ok {
+		return profileSpec{}, nil, fmt.Errorf("no %s profiles for platform: %s", r.Distribution, r.Platform)
+	}
+
+	spec := profileSpec{platform: r.Platform, profileType: profileType, deviceIDs: r.deviceIDs(r.Platform)}
+	if r.WatchBundleIDs[bundleID] {
+		spec.deviceIDs = append(spec.deviceIDs, r.deviceIDs(WatchOS)...)
+	}
+
+	// watchOS targets and App Clips have no Mac Catalyst variant
+	if !r.MacCatalyst || r.WatchBundleIDs[bundleID] || r.AppClipBundleIDs[bundleID] {
+		return spec, nil, nil
+	}
+	macCatalystProfileType, ok := MacCatalystProfileType(r.Distribution)
+	if !ok {
+		return spec, nil, nil
+	}
+	return spec, &profileSpec{platform: MacCatalyst, profileType: macCatalystProfileType, deviceIDs: r.deviceIDs(MacCatalyst)}, nil
+}
+
+// deviceIDs returns the IDs of the devices of the platform, if the distribution type's profiles include devices
+func (r ProfileRequest) deviceIDs(platform Platform) []string {
+	if r.Distribution != Development && r.Distribution != AdHoc {
+		return nil
+	}
+
+	var deviceIDs []string
+	for _, d := range r.Devices {
+		if !DeviceMatchesPlatform(d, platform) {
+			log.Debugf("dropping device %s, since device type: %s, not a(n) %s device", d.ID, d.Attributes.DeviceClass, platform)
+			continue
+		}
+		deviceIDs = append(deviceIDs, d.ID)
+	}
+	return deviceIDs
+}
+
+// SortedBundleIDs returns the bundle IDs of the request in a stable order
+func (r ProfileRequest) SortedBundleIDs() []string {
+	var bundleIDs []string
+	for bundleID := range r.EntitlementsByBundleID {
+		bundleIDs = append(bundleIDs, bundleID)
+	}
+	sort.Strings(bundleIDs)
+	return bundleIDs
+}
+
+// EnsureProfiles ensures the profiles of the request for the bundle IDs with their entitlements, and returns them by the bundle ID.
+// The profiles of the bundle IDs are independent, they are ensured in parallel,
+// the profiles of a bundle ID (the profile and its Mac Catalyst variant) one after the other.
+func (a AutoProvisioner) EnsureProfiles(request ProfileRequest) (EnsuredProfiles, error) {
+	if request.MacCatalyst {
+		if _, ok := MacCatalystProfileType(request.Distribution); !ok {
+			log.Warnf("Mac Catalyst has no %s profiles, only the iOS variant of the app is provisioned", request.Distribution)
+		}
+	}
+
+	bundleIDIdentifiers := request.SortedBundleIDs()
+	profiles := make([]*appstoreconnect.Profile, len(bundleIDIdentifiers))
+	macCatalystProfiles := make([]*appstoreconnect.Profile, len(bundleIDIdentifiers))
+	if err := ForEachConcurrently(len(bundleIDIdentifiers), a.ProfileConcurrency, func(i int) error {
+		bundleIDIdentifier := bundleIDIdentifiers[i]
+		entitlements := request.EntitlementsByBundleID[bundleIDIdentifier]
+
+		spec, macCatalystSpec, err := request.profileSpecs(bundleIDIdentifier)
+		if err != nil {
+			return err
+		}
+
+		profiles[i], err = a.Profiles.EnsureProfile(spec.profileType, bundleIDIdentifier, entitlements, request.CertIDs, spec.deviceIDs, request.MinProfileDaysValid)
+		if err != nil {
+			return fmt.Errorf("failed to ensure the profile of bundle ID (%s): %s", bundleIDIdentifier, err)
+		}
+
+		if macCatalystSpec == nil {
+			return nil
+		}
+		macCatalystProfiles[i], err = a.Profiles.EnsureProfile(macCatalystSpec.profileType, bundleIDIdentifier, entitlements, request.CertIDs, macCatalystSpec.deviceIDs, request.MinProfileDaysValid)
+		if err != nil {
+			return fmt.Errorf("Mac Catalyst: failed to ensure the profile of bundle ID (%s): %s", bundleIDIdentifier, err)
+		}
+		return nil
+	}); err != nil {
+		return EnsuredProfiles{}, err
+	}
+
+	return newEnsuredProfiles(bundleIDIdentifiers, profiles, macCatalystProfiles), nil
+}
+
+// FindOfflineProfiles selects the profiles of the request from the offline profiles (offline_assets_dir input),
+// the same profiles are selected as the ones EnsureProfiles ensures on the Developer Portal.
+func FindOfflineProfiles(offlineProfiles []OfflineProfile, request ProfileRequest, certificate certificateutil.CertificateInfoModel, now time.Time) (EnsuredProfiles, error) {
+	bundleIDIdentifiers := request.SortedBundleIDs()
+	profiles := make([]*appstoreconnect.Profile, len(bundleIDIdentifiers))
+	macCatalystProfiles := make([]*appstoreconnect.Profile, len(bundleIDIdentifiers))
+	for i, bundleIDIdentifier := range bundleIDIdentifiers {
+		entitlements := Entitlement(request.EntitlementsByBundleID[bundleIDIdentifier])
+
+		spec, macCatalystSpec, err := request.profileSpecs(bundleIDIdentifier)
+		if err != nil {
+			return EnsuredProfiles{}, err
+		}
+
+		profiles[i], err = FindOfflineProfile(offlineProfiles, spec.platform, request.Distribution, bundleIDIdentifier, entitlements, certificate, request.MinProfileDaysValid, now)
+		if err != nil {
+			return EnsuredProfiles{}, err
+		}
+
+		if macCatalystSpec == nil {
+			continue
+		}
+		macCatalystProfiles[i], err = FindOfflineProfile(offlineProfiles, macCatalystSpec.platform, request.Distribution, bundleIDIdentifier, entitlements, certificate, request.MinProfileDaysValid, now)
+		if err != nil {
+			return EnsuredProfiles{}, fmt.Errorf("Mac Catalyst: %s", err)
+		}
+	}
+
+	return newEnsuredProfiles(bundleIDIdentifiers, profiles, macCatalystProfiles), nil
+}
+
+func newEnsuredProfiles(bundleIDIdentifiers []string, profiles, macCatalystProfiles []*appstoreconnect.Profile) EnsuredProfiles {
+	ensured := EnsuredProfiles{
+		ProfilesByBundleID:            map[string]appstoreconnect.Profile{},
+		MacCatalystProfilesByBundleID: map[string]appstoreconnect.Profile{},
+	}
+	for i, bundleIDIdentifier := range bundleIDIdentifiers {
+		ensured.ProfilesByBundleID[bundleIDIdentifier] = *profiles[i]
+		if macCatalystProfiles[i] != nil {
+			ensured.MacCatalystProfilesByBundleID[bundleIDIdentifier] = *macCatalystProfiles[i]
+		}
+	}
+	return ensured
+}
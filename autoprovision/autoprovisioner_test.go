@@ -0,0 +1,219 @@
+package autoprovision
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/testutil/ascmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequiredCertificateTypes(t *testing.T) {
+	required, distrTypes, err := RequiredCertificateTypes(IOS, AdHoc)
+	require.NoError(t, err)
+	require.Equal(t, map[appstoreconnect.CertificateType]bool{appstoreconnect.IOSDistribution: true, appstoreconnect.IOSDevelopment: false}, required)
+	require.Equal(t, []DistributionType{AdHoc, Development}, distrTypes)
+
+	required, distrTypes, err = RequiredCertificateTypes(MacOS, AppStore)
+	require.NoError(t, err)
+	require.Equal(t, map[appstoreconnect.CertificateType]bool{appstoreconnect.MacDistribution: true, appstoreconnect.MacDevelopment: false, appstoreconnect.MacInstallerDistribution: true}, required)
+	require.Equal(t, []DistributionType{AppStore, Development}, distrTypes)
+
+	required, distrTypes, err = RequiredCertificateTypes(IOS, Development)
+	require.NoError(t, err)
+	require.Equal(t, map[appstoreconnect.CertificateType]bool{appstoreconnect.IOSDevelopment: true}, required)
+	require.Equal(t, []DistributionType{Development}, distrTypes)
+
+	_, _, err = RequiredCertificateTypes(MacOS, Enterprise)
+	require.Error(t, err)
+}
+
+func TestDistributionTypesWithCertificates(t *testing.T) {
+	certsByType := map[appstoreconnect.CertificateType][]APICertificate{appstoreconnect.IOSDistribution: {{ID: "DISTRIBUTION"}}}
+	require.Equal(t, []DistributionType{AppStore}, DistributionTypesWithCertificates(IOS, []DistributionType{AppStore, Development}, certsByType))
+
+	certsByType[appstoreconnect.IOSDevelopment] = []APICertificate{{ID: "DEVELOPMENT"}}
+	require.Equal(t, []DistributionType{AppStore, Development}, DistributionTypesWithCertificates(IOS, []DistributionType{AppStore, Development}, certsByType))
+}
+
+func TestAutoProvisioner_EnsureDevices(t *testing.T) {
+	const (
+		registeredUDID = "00008030-001A35E11A88802E"
+		newUDID        = "b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1"
+		rejectedUDID   = "00008101-000A2C3E0E11001E"
+		macUDID        = "A1B2C3D4-E5F6-A7B8-C9D0-E1F2A3B4C5D6"
+	)
+	server := ascmock.New(ascmock.Fixtures{Devices: []appstoreconnect.Device{
+		{ID: "REGISTERED", Attributes: appstoreconnect.DeviceAttributes{UDID: registeredUDID, Platform: appstoreconnect.IOS, Status: appstoreconnect.Enabled}},
+	}})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	testDevices := []TestDevice{
+		{UDID: strings.ToLower(registeredUDID), Title: "iPhone", DeviceType: "ios"},
+		{UDID: rejectedUDID, Title: "iPhone 12", DeviceType: "ios"},
+		{UDID: newUDID, Title: "iPad", DeviceType: "ipad"},
+		{UDID: macUDID, Title: "MacBook", DeviceType: "mac"},
+	}
+
+	var ignored []string
+	provisioner := NewAutoProvisioner(client, NewSession("account"), NewPortalChanges(0))
+	provisioner.IgnoreFailure = func(format string, args ...interface{}) {
+		ignored = append(ignored, format)
+	}
+	// the registration of the first new device is rejected, the second one succeeds
+	server.AddFault(ascmock.Fault{Method: http.MethodPost, Path: "/v1/devices", StatusCode: http.StatusConflict, Body: `{"errors":[{"code":"ENTITY_ERROR"}]}`, Times: 1})
+
+	registration, err := provisioner.EnsureDevices(appstoreconnect.IOSDevice, testDevices, []DistributionType{Development})
+	require.NoError(t, err)
+	require.Equal(t, registeredUDID, registration.Devices[0].Attributes.UDID)
+	require.Len(t, registration.Devices, 2)
+	require.Len(t, registration.Registered, 1)
+	require.Equal(t, newUDID, registration.Registered[0].Attributes.UDID)
+	require.Equal(t, []string{strings.ToLower(registeredUDID)}, registration.Summary.AlreadyRegistered)
+	require.Equal(t, []string{newUDID}, registration.Summary.Registered)
+	require.Len(t, registration.Summary.Rejected, 1)
+	require.Contains(t, registration.Summary.Rejected[0], rejectedUDID)
+	require.Len(t, registration.Summary.Skipped, 1)
+	require.Contains(t, registration.Summary.Skipped[0], macUDID)
+	require.Len(t, ignored, 1)
+	require.Len(t, provisioner.Session.DevicesByPlatform[appstoreconnect.IOSDevice], 2, "the registered device is added to the session")
+
+	provisioner.IgnoreFailure = nil
+	server.AddFault(ascmock.Fault{Method: http.MethodPost, Path: "/v1/devices", StatusCode: http.StatusConflict, Body: `{"errors":[{"code":"ENTITY_ERROR"}]}`, Times: 1})
+	_, err = provisioner.EnsureDevices(appstoreconnect.IOSDevice, testDevices, []DistributionType{Development})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), rejectedUDID)
+}
+
+func TestAutoProvisioner_EnsureDevices_DryRun(t *testing.T) {
+	server := ascmock.New(ascmock.Fixtures{})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	portalChanges := NewPortalChanges(0)
+	portalChanges.DryRun = true
+	provisioner := NewAutoProvisioner(client, nil, portalChanges)
+
+	registration, err := provisioner.EnsureDevices(appstoreconnect.IOSDevice, []TestDevice{{UDID: "00008030-001A35E11A88802E", Title: "iPhone", DeviceType: "ios"}}, []DistributionType{AdHoc})
+	require.NoError(t, err)
+	require.Empty(t, registration.Devices)
+	require.Len(t, portalChanges.Changes, 1)
+	require.Equal(t, RegisterDeviceChange, portalChanges.Changes[0].Action)
+	require.Empty(t, server.State().Devices)
+}
+
+func TestAutoProvisioner_EnsureProfiles(t *testing.T) {
+	bundleIDs := []appstoreconnect.BundleID{
+		{ID: "APP", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.testapp", Platform: string(appstoreconnect.IOS)}},
+		{ID: "EXTENSION", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.testapp.extension", Platform: string(appstoreconnect.IOS)}},
+	}
+	server := ascmock.New(ascmock.Fixtures{BundleIDs: bundleIDs, ProfileContent: signedTestProfile(t)})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	provisioner := NewAutoProvisioner(client, NewSession("account"), NewPortalChanges(0))
+	provisioner.ProfileConcurrency = 2
+
+	entitlementsByBundleID := map[string]serialized.Object{
+		"io.bitrise.testapp":           {},
+		"io.bitrise.testapp.extension": {},
+	}
+	request := ProfileRequest{Platform: IOS, Distribution: AppStore, EntitlementsByBundleID: entitlementsByBundleID}
+	ensured, err := provisioner.EnsureProfiles(request)
+	require.NoError(t, err)
+	profiles := ensured.ProfilesByBundleID
+	require.Len(t, profiles, 2)
+	require.Empty(t, ensured.MacCatalystProfilesByBundleID)
+	for bundleID, profile := range profiles {
+		require.Contains(t, profile.Attributes.Name, bundleID)
+	}
+	require.Len(t, server.State().Profiles, 2)
+
+	// the profiles are in sync, a second run reuses them
+	reused, err := provisioner.EnsureProfiles(request)
+	require.NoError(t, err)
+	require.Equal(t, profiles["io.bitrise.testapp"].ID, reused.ProfilesByBundleID["io.bitrise.testapp"].ID)
+	require.Len(t, server.State().Profiles, 2)
+}
+
+func TestProfileRequest_profileSpecs(t *testing.T) {
+	devices := []appstoreconnect.Device{
+		{ID: "IPHONE", Attributes: appstoreconnect.DeviceAttributes{Platform: appstoreconnect.IOS, DeviceClass: appstoreconnect.Iphone}},
+		{ID: "WATCH", Attributes: appstoreconnect.DeviceAttributes{Platform: appstoreconnect.IOS, DeviceClass: appstoreconnect.AppleWatch}},
+		{ID: "MAC", Attributes: appstoreconnect.DeviceAttributes{Platform: appstoreconnect.MacOS, DeviceClass: appstoreconnect.Mac}},
+	}
+	request := ProfileRequest{
+		Platform:       IOS,
+		Distribution:   Development,
+		Devices:        devices,
+		WatchBundleIDs: map[string]bool{"io.bitrise.testapp.watchkitapp": true},
+		MacCatalyst:    true,
+	}
+
+	spec, macCatalystSpec, err := request.profileSpecs("io.bitrise.testapp")
+	require.NoError(t, err)
+	require.Equal(t, appstoreconnect.IOSAppDevelopment, spec.profileType)
+	require.Equal(t, []string{"IPHONE"}, spec.deviceIDs)
+	require.NotNil(t, macCatalystSpec)
+	require.Equal(t, appstoreconnect.MacCatalystAppDevelopment, macCatalystSpec.profileType)
+	require.Equal(t, []string{"MAC"}, macCatalystSpec.deviceIDs)
+
+	spec, macCatalystSpec, err = request.profileSpecs("io.bitrise.testapp.watchkitapp")
+	require.NoError(t, err)
+	require.Equal(t, []string{"IPHONE", "WATCH"}, spec.deviceIDs)
+	require.Nil(t, macCatalystSpec, "watchOS targets have no Mac Catalyst variant")
+
+	request.Distribution = AppStore
+	spec, macCatalystSpec, err = request.profileSpecs("io.bitrise.testapp")
+	require.NoError(t, err)
+	require.Empty(t, spec.deviceIDs)
+	require.NotNil(t, macCatalystSpec)
+	require.Equal(t, appstoreconnect.MacCatalystAppStore, macCatalystSpec.profileType)
+}
+
+func TestAutoProvisioner_PlanChanges(t *testing.T) {
+	server := ascmock.New(ascmock.Fixtures{ProfileContent: signedTestProfile(t)})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	portalChanges := NewPortalChanges(2)
+	provisioner := NewAutoProvisioner(client, NewSession("account"), portalChanges)
+	provisioner.Profiles = NewProfileManager(client, nil, portalChanges, nil)
+
+	planned, err := provisioner.PlanChanges(func(planner AutoProvisioner) error {
+		registration, err := planner.EnsureDevices(appstoreconnect.IOSDevice, []TestDevice{{UDID: "00008030-001A35E11A88802E", Title: "iPhone", DeviceType: "ios"}}, []DistributionType{Development})
+		if err != nil {
+			return err
+		}
+		_, err = planner.EnsureProfiles(ProfileRequest{Platform: IOS, Distribution: Development, EntitlementsByBundleID: map[string]serialized.Object{"io.bitrise.testapp": {}}, Devices: registration.Devices})
+		return err
+	})
+	require.NoError(t, err)
+
+	var actions []PortalChangeAction
+	for _, change := range planned {
+		actions = append(actions, change.Action)
+	}
+	require.Equal(t, []PortalChangeAction{RegisterDeviceChange, CreateBundleIDChange, CreateProfileChange}, actions)
+	require.Error(t, portalChanges.CheckPlan(planned), "the plan exceeds the limit")
+
+	require.Empty(t, portalChanges.Changes, "the plan is not registered")
+	require.Empty(t, provisioner.Profiles.bundleIDByBundleIDIdentifer, "the planned app IDs do not leak")
+	state := server.State()
+	require.Empty(t, state.Devices)
+	require.Empty(t, state.BundleIDs)
+	require.Empty(t, state.Profiles)
+}
@@ -0,0 +1,243 @@
+package autoprovision
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+const buildCacheVersion = 1
+
+// DefaultBuildCacheTTL is the age of the build cache entries reused, if the cache TTL is not set
+const DefaultBuildCacheTTL = 24 * time.Hour
+
+// BuildCache persists the Developer Portal state, which rarely changes between the builds, in a cache directory
+// (cache_dir input, for example a directory cached by the Bitrise cache steps), per team:
+// the app IDs by bundle ID, the certificates by serial number, and the profiles with their .mobileprovision content
+// by profile type, bundle ID and profile key (the hash of the bundle ID, entitlements and certificates, see ProfileKey).
+// Unchanged builds reuse the entries younger than the ttl, and skip most of the App Store Connect requests.
+// Calling the methods on a nil BuildCache reuses and stores nothing.
+type BuildCache struct {
+	dir    string
+	teamID string
+	ttl    time.Duration
+}
+
+// NewBuildCache creates the build cache of the team in the cache directory.
+func NewBuildCache(dir, teamID string, ttl time.Duration) *BuildCache {
+	if ttl <= 0 {
+		ttl = DefaultBuildCacheTTL
+	}
+	return &BuildCache{dir: dir, teamID: teamID, ttl: ttl}
+}
+
+// buildCacheEntry is the content of a cache file, Value is the cached app ID, certificate or profile
+type buildCacheEntry struct {
+	Version  int             `json:"version"`
+	TeamID   string          `json:"team_id"`
+	CachedAt time.Time       `json:"cached_at"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// cachedProfile is the cached profile and the devices it was checked with, its content is cached in a .mobileprovision file
+type cachedProfile struct {
+	Profile   appstoreconnect.Profile `json:"profile"`
+	DeviceIDs []string                `json:"device_ids"`
+}
+
+func (c *BuildCache) path(kind string, parts ...string) string {
+	name := strings.Join(append([]string{kind, c.teamID}, parts...), "_")
+	return filepath.Join(c.dir, name+".json")
+}
+
+// read decodes the cache entry of the path into v, it returns false if the entry does not exist or it can not be reused.
+func (c *BuildCache) read(pth string, v interface{}, now time.Time) bool {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Failed to read build cache entry (%s): %s", pth, err)
+		}
+		return false
+	}
+
+	var entry buildCacheEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		log.Warnf("Ignoring invalid build cache entry (%s): %s", pth, err)
+		return false
+	}
+	if reason := entry.staleReason(c.teamID, c.ttl, now); reason != "" {
+		log.Debugf("Ignoring build cache entry (%s): %s", pth, reason)
+		return false
+	}
+	if err := json.Unmarshal(entry.Value, v); err != nil {
+		log.Warnf("Ignoring invalid build cache entry (%s): %s", pth, err)
+		return false
+	}
+	return true
+}
+
+func (e buildCacheEntry) staleReason(teamID string, ttl time.Duration, now time.Time) string {
+	if e.Version != buildCacheVersion {
+		return fmt.Sprintf("unsupported version (%d)", e.Version)
+	}
+	if e.TeamID != teamID {
+		return "it belongs to another team"
+	}
+	if now.Sub(e.CachedAt) > ttl {
+		return fmt.Sprintf("it is older than %s", ttl)
+	}
+	return ""
+}
+
+func (c *BuildCache) write(pth string, v interface{}, now time.Time) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to serialize build cache entry: %s", err)
+	}
+	content, err := json.Marshal(buildCacheEntry{Version: buildCacheVersion, TeamID: c.teamID, CachedAt: now, Value: value})
+	if err != nil {
+		return fmt.Errorf("failed to serialize build cache entry: %s", err)
+	}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create build cache directory: %s", err)
+	}
+	if err := writeFileAtomic(pth, content); err != nil {
+		return fmt.Errorf("failed to write build cache entry (%s): %s", pth, err)
+	}
+	return nil
+}
+
+// SeedSession adds the cached app IDs and certificates of the team to the session, so that the session reuses them
+// instead of looking them up. The entries already in the session are kept. It returns the number of added entries.
+func (c *BuildCache) SeedSession(session *Session, now time.Time) int {
+	if c == nil || session == nil {
+		return 0
+	}
+
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Failed to read build cache directory (%s): %s", c.dir, err)
+		}
+		return 0
+	}
+
+	seeded := 0
+	for _, file := range files {
+		pth := filepath.Join(c.dir, file.Name())
+		switch {
+		case strings.HasPrefix(file.Name(), "bundleid_"+c.teamID+"_"):
+			var bundleID appstoreconnect.BundleID
+			if c.read(pth, &bundleID, now) {
+				if _, ok := session.BundleIDsByIdentifier[bundleID.Attributes.Identifier]; !ok {
+					session.BundleIDsByIdentifier[bundleID.Attributes.Identifier] = bundleID
+					seeded++
+				}
+			}
+		case strings.HasPrefix(file.Name(), "certificate_"+c.teamID+"_"):
+			var certificate appstoreconnect.Certificate
+			if c.read(pth, &certificate, now) {
+				key := strings.TrimSuffix(strings.TrimPrefix(file.Name(), "certificate_"+c.teamID+"_"), ".json")
+				if _, ok := session.CertificatesBySerial[key]; !ok {
+					session.CertificatesBySerial[key] = certificate
+					seeded++
+				}
+			}
+		}
+	}
+	return seeded
+}
+
+// StoreSession caches the app IDs and certificates of the session.
+func (c *BuildCache) StoreSession(session *Session, now time.Time) error {
+	if c == nil || session == nil {
+		return nil
+	}
+
+	for identifier, bundleID := range session.BundleIDsByIdentifier {
+		if err := c.write(c.path("bundleid", identifier), bundleID, now); err != nil {
+			return err
+		}
+	}
+	for serial, certificate := range session.CertificatesBySerial {
+		if err := c.write(c.path("certificate", serial), certificate, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *BuildCache) profilePath(profileType appstoreconnect.ProfileType, bundleID, profileKey string) string {
+	return c.path("profile", string(profileType), bundleID, profileKey)
+}
+
+// Profile returns the cached profile of the profile type, bundle ID and profile key, with its content.
+// It returns nil if there is no cached profile, or it was generated for other devices.
+func (c *BuildCache) Profile(profileType appstoreconnect.ProfileType, bundleID, profileKey string, deviceIDs []string, now time.Time) *appstoreconnect.Profile {
+	if c == nil {
+		return nil
+	}
+
+	pth := c.profilePath(profileType, bundleID, profileKey)
+	var cached cachedProfile
+	if !c.read(pth, &cached, now) {
+		return nil
+	}
+	if !sameIDs(cached.DeviceIDs, deviceIDs) {
+		log.Debugf("Ignoring build cache entry (%s): the devices changed", pth)
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(strings.TrimSuffix(pth, ".json") + ".mobileprovision")
+	if err != nil {
+		log.Debugf("Ignoring build cache entry (%s): %s", pth, err)
+		return nil
+	}
+	cached.Profile.Attributes.ProfileContent = content
+	return &cached.Profile
+}
+
+// StoreProfile caches the profile of the profile type, bundle ID and profile key, generated for the devices.
+// The profile content is stored in a .mobileprovision file next to the entry.
+func (c *BuildCache) StoreProfile(profileType appstoreconnect.ProfileType, bundleID, profileKey string, deviceIDs []string, profile appstoreconnect.Profile, now time.Time) error {
+	if c == nil {
+		return nil
+	}
+
+	pth := c.profilePath(profileType, bundleID, profileKey)
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create build cache directory: %s", err)
+	}
+	if err := writeFileAtomic(strings.TrimSuffix(pth, ".json")+".mobileprovision", profile.Attributes.ProfileContent); err != nil {
+		return fmt.Errorf("failed to write build cache entry (%s): %s", pth, err)
+	}
+
+	profile.Attributes.ProfileContent = nil
+	return c.write(pth, cachedProfile{Profile: profile, DeviceIDs: deviceIDs}, now)
+}
+
+// sameIDs reports whether the two lists hold the same IDs, independent of their order
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
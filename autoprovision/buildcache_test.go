@@ -0,0 +1,52 @@
+package autoprovision
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCache_session(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	session := NewSession("key-id")
+	session.BundleIDsByIdentifier["io.bitrise.app"] = appstoreconnect.BundleID{ID: "APP", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.app"}}
+	session.CertificatesBySerial["1a2b"] = appstoreconnect.Certificate{ID: "CERT"}
+	require.NoError(t, NewBuildCache(dir, "TEAM", time.Hour).StoreSession(session, now))
+
+	seeded := NewSession("key-id")
+	require.Equal(t, 2, NewBuildCache(dir, "TEAM", time.Hour).SeedSession(seeded, now.Add(30*time.Minute)))
+	require.Equal(t, "APP", seeded.BundleIDsByIdentifier["io.bitrise.app"].ID)
+	require.Equal(t, "CERT", seeded.CertificatesBySerial["1a2b"].ID)
+
+	require.Equal(t, 0, NewBuildCache(dir, "OTHER", time.Hour).SeedSession(NewSession("key-id"), now), "entries of another team")
+	require.Equal(t, 0, NewBuildCache(dir, "TEAM", time.Hour).SeedSession(NewSession("key-id"), now.Add(2*time.Hour)), "expired entries")
+	require.Equal(t, 0, NewBuildCache(t.TempDir(), "TEAM", time.Hour).SeedSession(NewSession("key-id"), now), "empty cache")
+
+	var nilCache *BuildCache
+	require.Equal(t, 0, nilCache.SeedSession(NewSession("key-id"), now))
+	require.NoError(t, nilCache.StoreSession(session, now))
+}
+
+func TestBuildCache_profile(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewBuildCache(t.TempDir(), "TEAM", 0)
+	profile := appstoreconnect.Profile{
+		ID:         "PROFILE",
+		Attributes: appstoreconnect.ProfileAttributes{Name: "Bitrise iOS development - (io.bitrise.app) [0a1b2c3d]", ProfileContent: []byte("content")},
+	}
+	require.NoError(t, cache.StoreProfile(appstoreconnect.IOSAppDevelopment, "io.bitrise.app", "0a1b2c3d", []string{"D1", "D2"}, profile, now))
+
+	cached := cache.Profile(appstoreconnect.IOSAppDevelopment, "io.bitrise.app", "0a1b2c3d", []string{"D2", "D1"}, now.Add(time.Hour))
+	require.NotNil(t, cached)
+	require.Equal(t, "PROFILE", cached.ID)
+	require.Equal(t, []byte("content"), cached.Attributes.ProfileContent)
+
+	require.Nil(t, cache.Profile(appstoreconnect.IOSAppDevelopment, "io.bitrise.app", "0a1b2c3d", []string{"D1"}, now), "devices changed")
+	require.Nil(t, cache.Profile(appstoreconnect.IOSAppDevelopment, "io.bitrise.app", "ffffffff", []string{"D1", "D2"}, now), "entitlements or certificates changed")
+	require.Nil(t, cache.Profile(appstoreconnect.IOSAppStore, "io.bitrise.app", "0a1b2c3d", []string{"D1", "D2"}, now), "other profile type")
+	require.Nil(t, cache.Profile(appstoreconnect.IOSAppDevelopment, "io.bitrise.app", "0a1b2c3d", []string{"D1", "D2"}, now.Add(DefaultBuildCacheTTL+time.Hour)), "expired entry")
+}
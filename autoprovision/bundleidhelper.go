@@ -9,26 +9,11 @@ import (
 
 // FindBundleID ...
 func FindBundleID(client *appstoreconnect.Client, bundleIDIdentifier string) (*appstoreconnect.BundleID, error) {
-	var nextPageURL string
-	var bundleIDs []appstoreconnect.BundleID
-	for {
-		response, err := client.Provisioning.ListBundleIDs(&appstoreconnect.ListBundleIDsOptions{
-			PagingOptions: appstoreconnect.PagingOptions{
-				Limit: 20,
-				Next:  nextPageURL,
-			},
-			FilterIdentifier: bundleIDIdentifier,
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		bundleIDs = append(bundleIDs, response.Data...)
-
-		nextPageURL = response.Links.Next
-		if nextPageURL == "" {
-			break
-		}
+	bundleIDs, err := client.Provisioning.ListAllBundleIDs(&appstoreconnect.ListBundleIDsOptions{
+		FilterIdentifier: bundleIDIdentifier,
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	if len(bundleIDs) == 0 {
@@ -45,6 +30,39 @@ func FindBundleID(client *appstoreconnect.Client, bundleIDIdentifier string) (*a
 	return nil, nil
 }
 
+// bundleIDFilterBatchSize is the maximum number of identifiers, looked up by a single filter[identifier] request
+const bundleIDFilterBatchSize = 10
+
+// FindBundleIDs looks up the app IDs of the bundle IDs in batches, with comma separated filter[identifier] values,
+// instead of a request per bundle ID. The returned map does not contain the bundle IDs without an app ID.
+func FindBundleIDs(client *appstoreconnect.Client, bundleIDIdentifiers []string) (map[string]appstoreconnect.BundleID, error) {
+	bundleIDByIdentifier := map[string]appstoreconnect.BundleID{}
+	for start := 0; start < len(bundleIDIdentifiers); start += bundleIDFilterBatchSize {
+		end := start + bundleIDFilterBatchSize
+		if end > len(bundleIDIdentifiers) {
+			end = len(bundleIDIdentifiers)
+		}
+		batch := bundleIDIdentifiers[start:end]
+
+		bundleIDs, err := client.Provisioning.ListAllBundleIDs(&appstoreconnect.ListBundleIDsOptions{
+			FilterIdentifier: strings.Join(batch, ","),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The FilterIdentifier works as a Like command, only the exact matches are kept.
+		for _, bundleID := range bundleIDs {
+			for _, identifier := range batch {
+				if bundleID.Attributes.Identifier == identifier {
+					bundleIDByIdentifier[identifier] = bundleID
+				}
+			}
+		}
+	}
+	return bundleIDByIdentifier, nil
+}
+
 func checkBundleIDEntitlements(bundleIDEntitlements []appstoreconnect.BundleIDCapability, projectEntitlements Entitlement) error {
 	for k, v := range projectEntitlements {
 		ent := Entitlement{k: v}
@@ -86,6 +104,49 @@ func CheckBundleIDEntitlements(client *appstoreconnect.Client, bundleID appstore
 	return checkBundleIDEntitlements(response.Data, projectEntitlements)
 }
 
+// alwaysOnCapabilities are enabled on every app ID and can not be disabled
+var alwaysOnCapabilities = map[appstoreconnect.CapabilityType]bool{
+	appstoreconnect.InAppPurchase: true,
+	appstoreconnect.GameCenter:    true,
+}
+
+// StaleCapabilities returns the capabilities of the app ID, which are not required by the project entitlements anymore.
+// The always-on capabilities and the capability types without a known entitlement mapping are never stale,
+// as the project can not tell whether those are needed.
+func StaleCapabilities(capabilities []appstoreconnect.BundleIDCapability, projectEntitlements Entitlement) []appstoreconnect.BundleIDCapability {
+	known := map[appstoreconnect.CapabilityType]bool{}
+	for _, capabilityType := range appstoreconnect.ServiceTypeByKey {
+		known[capabilityType] = true
+	}
+
+	required := map[appstoreconnect.CapabilityType]bool{}
+	for key, value := range projectEntitlements {
+		if ent := (Entitlement{key: value}); ent.AppearsOnDeveloperPortal() {
+			required[appstoreconnect.ServiceTypeByKey[key]] = true
+		}
+	}
+
+	var stale []appstoreconnect.BundleIDCapability
+	for _, capability := range capabilities {
+		capabilityType := capability.Attributes.CapabilityType
+		if !known[capabilityType] || alwaysOnCapabilities[capabilityType] || required[capabilityType] {
+			continue
+		}
+		stale = append(stale, capability)
+	}
+	return stale
+}
+
+// FindStaleCapabilities returns the capabilities of the app ID, which are not required by the project entitlements anymore.
+func FindStaleCapabilities(client *appstoreconnect.Client, bundleID appstoreconnect.BundleID, projectEntitlements Entitlement) ([]appstoreconnect.BundleIDCapability, error) {
+	response, err := client.Provisioning.Capabilities(bundleID.Relationships.Capabilities.Links.Related)
+	if err != nil {
+		return nil, err
+	}
+
+	return StaleCapabilities(response.Data, projectEntitlements), nil
+}
+
 // SyncBundleID ...
 func SyncBundleID(client *appstoreconnect.Client, bundleIDID string, entitlements Entitlement) error {
 	for key, value := range entitlements {
@@ -129,8 +190,8 @@ func appIDName(bundleID string) string {
 	return "Bitrise " + r.Replace(bundleID)
 }
 
-// CreateBundleID ...
-func CreateBundleID(client *appstoreconnect.Client, bundleIDIdentifier string) (*appstoreconnect.BundleID, error) {
+// CreateBundleID registers an app ID for the bundle ID, with the platform returned by BundleIDPlatform.
+func CreateBundleID(client *appstoreconnect.Client, bundleIDIdentifier string, platform Platform) (*appstoreconnect.BundleID, error) {
 	appIDName := appIDName(bundleIDIdentifier)
 
 	r, err := client.Provisioning.CreateBundleID(
@@ -139,13 +200,16 @@ func CreateBundleID(client *appstoreconnect.Client, bundleIDIdentifier string) (
 				Attributes: appstoreconnect.BundleIDCreateRequestDataAttributes{
 					Identifier: bundleIDIdentifier,
 					Name:       appIDName,
-					Platform:   appstoreconnect.IOS,
+					Platform:   BundleIDPlatform(platform),
 				},
 				Type: "bundleIds",
 			},
 		},
 	)
 	if err != nil {
+		if IsPersonalTeamLimitError(err) {
+			return nil, fmt.Errorf("failed to register AppID for bundleID (%s): %s\n%s", bundleIDIdentifier, err, PersonalTeamHint)
+		}
 		return nil, fmt.Errorf("failed to register AppID for bundleID (%s): %s", bundleIDIdentifier, err)
 	}
 
@@ -0,0 +1,199 @@
+package autoprovision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// BundleIDMigration renames the bundle ID of a target
+type BundleIDMigration struct {
+	// Target is optional, it is only used in the migration checklist
+	Target string
+	Old    string
+	New    string
+}
+
+func (m BundleIDMigration) String() string {
+	if m.Target != "" {
+		return fmt.Sprintf("%s: %s -> %s", m.Target, m.Old, m.New)
+	}
+	return fmt.Sprintf("%s -> %s", m.Old, m.New)
+}
+
+// ParseBundleIDMigrations parses the newline separated migrations, with layout: [<target>:] <old bundle ID>=<new bundle ID>
+func ParseBundleIDMigrations(s string) ([]BundleIDMigration, error) {
+	var migrations []BundleIDMigration
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var migration BundleIDMigration
+		if i := strings.Index(line, ":"); i != -1 {
+			migration.Target = strings.TrimSpace(line[:i])
+			line = line[i+1:]
+		}
+
+		split := strings.Split(line, "=")
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid bundle ID migration (%s), expected: [<target>:] <old bundle ID>=<new bundle ID>", line)
+		}
+		migration.Old, migration.New = strings.TrimSpace(split[0]), strings.TrimSpace(split[1])
+		if migration.Old == "" || migration.New == "" || migration.Old == migration.New {
+			return nil, fmt.Errorf("invalid bundle ID migration (%s), the old and new bundle IDs have to be different", line)
+		}
+
+		migrations = append(migrations, migration)
+	}
+
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no bundle ID migrations provided")
+	}
+	return migrations, nil
+}
+
+// BundleIDMigrationResult ...
+type BundleIDMigrationResult struct {
+	Migration BundleIDMigration
+	BundleID  appstoreconnect.BundleID
+	// Capabilities are the capabilities of the old app ID, enabled on the new one
+	Capabilities []appstoreconnect.CapabilityType
+	// Checklist lists the steps the App Store Connect API can not do
+	Checklist []string
+}
+
+// migrationChecklistItems are the manual steps of the capabilities, whose assignments are not available via the API
+var migrationChecklistItems = map[appstoreconnect.CapabilityType]string{
+	appstoreconnect.AppGroups:         "assign the app groups of %s to %s",
+	appstoreconnect.ICloud:            "assign the iCloud containers of %s to %s",
+	appstoreconnect.ApplePay:          "assign the Apple Pay merchant IDs of %s to %s",
+	appstoreconnect.PushNotifications: "make sure the push notification server of %s sends to %s (APNs certificates are app ID specific, APNs keys are not)",
+	appstoreconnect.Wallet:            "assign the pass type IDs of %s to %s",
+}
+
+// migrationChecklist returns the manual steps of the migration
+func migrationChecklist(migration BundleIDMigration, capabilities []appstoreconnect.CapabilityType) []string {
+	var checklist []string
+	for _, capability := range capabilities {
+		if item, ok := migrationChecklistItems[capability]; ok {
+			checklist = append(checklist, fmt.Sprintf(item, migration.Old, migration.New))
+		}
+	}
+
+	target := "the targets"
+	if migration.Target != "" {
+		target = fmt.Sprintf("the target (%s)", migration.Target)
+	}
+	return append(checklist,
+		fmt.Sprintf("set PRODUCT_BUNDLE_IDENTIFIER of %s to %s in the project", target, migration.New),
+		fmt.Sprintf("create a new App Store Connect app record for %s, the bundle ID of an existing app can not be changed", migration.New),
+		fmt.Sprintf("delete the app ID %s on the Developer Portal, once it is not used anymore", migration.Old),
+	)
+}
+
+// MigrateBundleID creates the app ID of the new bundle ID (if it does not exist yet),
+// and enables the capabilities of the old app ID on it, with the same settings.
+func MigrateBundleID(client *appstoreconnect.Client, migration BundleIDMigration, portalChanges *PortalChanges) (BundleIDMigrationResult, error) {
+	result := BundleIDMigrationResult{Migration: migration}
+
+	oldBundleID, err := FindBundleID(client, migration.Old)
+	if err != nil {
+		return result, fmt.Errorf("failed to find app ID (%s): %s", migration.Old, err)
+	}
+	if oldBundleID == nil {
+		return result, fmt.Errorf("app ID (%s) not found", migration.Old)
+	}
+
+	oldCapabilities, err := client.Provisioning.Capabilities(oldBundleID.Relationships.Capabilities.Links.Related)
+	if err != nil {
+		return result, fmt.Errorf("failed to list capabilities of app ID (%s): %s", migration.Old, err)
+	}
+
+	newBundleID, err := FindBundleID(client, migration.New)
+	if err != nil {
+		return result, fmt.Errorf("failed to find app ID (%s): %s", migration.New, err)
+	}
+	if newBundleID == nil {
+		change := PortalChange{Action: CreateBundleIDChange, Subject: migration.New, BundleID: migration.New, Reason: "bundle ID migration: " + migration.String()}
+		if err := portalChanges.Register(change); err != nil {
+			return result, err
+		}
+		platform := IOS
+		if appstoreconnect.BundleIDPlatform(oldBundleID.Attributes.Platform) == appstoreconnect.MacOS {
+			platform = MacOS
+		}
+		newBundleID, err = CreateBundleID(client, migration.New, platform)
+		portalChanges.RecordOutcome(change, err)
+		if err != nil {
+			return result, err
+		}
+	}
+	result.BundleID = *newBundleID
+
+	newCapabilities, err := client.Provisioning.Capabilities(newBundleID.Relationships.Capabilities.Links.Related)
+	if err != nil {
+		return result, fmt.Errorf("failed to list capabilities of app ID (%s): %s", migration.New, err)
+	}
+	enabled := map[appstoreconnect.CapabilityType]bool{}
+	for _, capability := range newCapabilities.Data {
+		enabled[capability.Attributes.CapabilityType] = true
+	}
+
+	for _, capability := range oldCapabilities.Data {
+		capabilityType := capability.Attributes.CapabilityType
+		result.Capabilities = append(result.Capabilities, capabilityType)
+		if enabled[capabilityType] {
+			continue
+		}
+
+		change := PortalChange{Action: UpdateBundleIDCapabilitiesChange, Subject: migration.New, BundleID: migration.New, Reason: fmt.Sprintf("bundle ID migration: %s capability of %s", capabilityType, migration.Old)}
+		if err := portalChanges.Register(change); err != nil {
+			return result, err
+		}
+
+		body := appstoreconnect.BundleIDCapabilityCreateRequest{
+			Data: appstoreconnect.BundleIDCapabilityCreateRequestData{
+				Attributes: appstoreconnect.BundleIDCapabilityCreateRequestDataAttributes{
+					CapabilityType: capabilityType,
+					Settings:       capability.Attributes.Settings,
+				},
+				Relationships: appstoreconnect.BundleIDCapabilityCreateRequestDataRelationships{
+					BundleID: appstoreconnect.BundleIDCapabilityCreateRequestDataRelationshipsBundleID{
+						Data: appstoreconnect.BundleIDCapabilityCreateRequestDataRelationshipsBundleIDData{
+							ID:   newBundleID.ID,
+							Type: "bundleIds",
+						},
+					},
+				},
+				Type: "bundleIdCapabilities",
+			},
+		}
+		_, err := client.Provisioning.EnableCapability(body)
+		portalChanges.RecordOutcome(change, err)
+		if err != nil {
+			return result, fmt.Errorf("failed to enable capability (%s) of app ID (%s): %s", capabilityType, migration.New, err)
+		}
+	}
+
+	result.Checklist = migrationChecklist(migration, result.Capabilities)
+	return result, nil
+}
+
+// CertificateIDs returns the IDs of the certificates with the given type
+func CertificateIDs(client *appstoreconnect.Client, certificateType appstoreconnect.CertificateType) ([]string, error) {
+	certificates, err := client.Provisioning.ListAllCertificates(&appstoreconnect.ListCertificatesOptions{
+		FilterCertificateType: certificateType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, certificate := range certificates {
+		ids = append(ids, certificate.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,58 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBundleIDMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []BundleIDMigration
+		wantErr bool
+	}{
+		{
+			name: "without target",
+			s:    "com.old.app=com.new.app",
+			want: []BundleIDMigration{{Old: "com.old.app", New: "com.new.app"}},
+		},
+		{
+			name: "with targets and blank lines",
+			s:    "MyApp: com.old.app = com.new.app\n\n  MyExtension:com.old.app.ext=com.new.app.ext  \n",
+			want: []BundleIDMigration{
+				{Target: "MyApp", Old: "com.old.app", New: "com.new.app"},
+				{Target: "MyExtension", Old: "com.old.app.ext", New: "com.new.app.ext"},
+			},
+		},
+		{name: "missing new bundle ID", s: "com.old.app", wantErr: true},
+		{name: "empty new bundle ID", s: "com.old.app=", wantErr: true},
+		{name: "same bundle IDs", s: "com.app=com.app", wantErr: true},
+		{name: "empty", s: "\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBundleIDMigrations(tt.s)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_migrationChecklist(t *testing.T) {
+	migration := BundleIDMigration{Target: "MyApp", Old: "com.old.app", New: "com.new.app"}
+
+	checklist := migrationChecklist(migration, []appstoreconnect.CapabilityType{appstoreconnect.AppGroups, appstoreconnect.InAppPurchase})
+	require.Equal(t, []string{
+		"assign the app groups of com.old.app to com.new.app",
+		"set PRODUCT_BUNDLE_IDENTIFIER of the target (MyApp) to com.new.app in the project",
+		"create a new App Store Connect app record for com.new.app, the bundle ID of an existing app can not be changed",
+		"delete the app ID com.old.app on the Developer Portal, once it is not used anymore",
+	}, checklist)
+}
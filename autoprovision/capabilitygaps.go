@@ -0,0 +1,91 @@
+package autoprovision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// entitlementsWithoutCapability are the entitlement keys, which never need a capability on the Developer Portal
+var entitlementsWithoutCapability = map[string]bool{
+	"application-identifier":              true,
+	"com.apple.developer.team-identifier": true,
+	"keychain-access-groups":              true,
+	"get-task-allow":                      true,
+	"beta-reports-active":                 true,
+}
+
+// UnmappedEntitlementKeys returns the sorted, unique entitlement keys of the project without a known capability mapping:
+// these are neither synced to the app ID nor checked in the profiles.
+// The keys never needing a capability (like keychain-access-groups or the macOS com.apple.security.* keys) are not listed.
+func UnmappedEntitlementKeys(entitlementsByBundleID map[string]serialized.Object) []string {
+	unique := map[string]bool{}
+	for _, entitlements := range entitlementsByBundleID {
+		for key := range entitlements {
+			if isUnmappedEntitlementKey(key) {
+				unique[key] = true
+			}
+		}
+	}
+
+	var keys []string
+	for key := range unique {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isUnmappedEntitlementKey reports whether the entitlement key has no known capability mapping, but may need a capability.
+func isUnmappedEntitlementKey(key string) bool {
+	if _, ok := appstoreconnect.ServiceTypeByKey[key]; ok {
+		return false
+	}
+	return !entitlementsWithoutCapability[key] && !strings.HasPrefix(key, "com.apple.security.")
+}
+
+// CapabilityGapReport is the anonymized report of the entitlement keys without a capability mapping,
+// it includes no bundle ID, team or project information.
+type CapabilityGapReport struct {
+	UnmappedEntitlements []string `json:"unmapped_entitlements"`
+}
+
+// SendCapabilityGapReport POSTs the report of the unmapped entitlement keys as JSON to the URL.
+func SendCapabilityGapReport(url string, keys []string) error {
+	body, err := json.Marshal(CapabilityGapReport{UnmappedEntitlements: keys})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create capability gap report request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("capability gap report request failed: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close capability gap report response body: %s", err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("capability gap report endpoint responded with status code %d: %s", resp.StatusCode, strings.TrimSpace(string(content)))
+	}
+	return nil
+}
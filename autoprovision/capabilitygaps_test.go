@@ -0,0 +1,52 @@
+package autoprovision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmappedEntitlementKeys(t *testing.T) {
+	entitlementsByBundleID := map[string]serialized.Object{
+		"io.bitrise.app": {
+			"aps-environment":                                      "production",
+			"keychain-access-groups":                               []interface{}{"$(AppIdentifierPrefix)io.bitrise.app"},
+			"com.apple.developer.weatherkit":                       true,
+			"com.apple.developer.usernotifications.time-sensitive": true,
+		},
+		"io.bitrise.app.widget": {
+			"com.apple.security.application-groups": []interface{}{"group.io.bitrise"},
+			"com.apple.security.app-sandbox":        true,
+			"com.apple.developer.weatherkit":        true,
+		},
+	}
+
+	require.Equal(t, []string{
+		"com.apple.developer.usernotifications.time-sensitive",
+		"com.apple.developer.weatherkit",
+	}, UnmappedEntitlementKeys(entitlementsByBundleID))
+	require.Empty(t, UnmappedEntitlementKeys(map[string]serialized.Object{"io.bitrise.app": {"get-task-allow": true}}))
+}
+
+func TestSendCapabilityGapReport(t *testing.T) {
+	var report CapabilityGapReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	require.NoError(t, SendCapabilityGapReport(server.URL, []string{"com.apple.developer.weatherkit"}))
+	require.Equal(t, []string{"com.apple.developer.weatherkit"}, report.UnmappedEntitlements)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	require.Error(t, SendCapabilityGapReport(failing.URL, []string{"com.apple.developer.weatherkit"}))
+}
@@ -0,0 +1,139 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// CapabilityState is the state of an app ID capability after the run
+type CapabilityState string
+
+// CapabilityStates ...
+const (
+	// CapabilitySkipped capabilities were not synced, for example the Step did not manage the profiles
+	CapabilitySkipped CapabilityState = "skipped"
+	// CapabilityEnabled capabilities were already enabled on the app ID
+	CapabilityEnabled CapabilityState = "enabled"
+	// CapabilityUpdated capabilities were enabled or updated on the app ID by the Step
+	CapabilityUpdated CapabilityState = "updated"
+	// CapabilityDisabled capabilities were disabled on the app ID by the Step, as the project does not use them (reconcile_capabilities input)
+	CapabilityDisabled CapabilityState = "disabled"
+	// CapabilityUnsupported entitlements have no known capability mapping, they are not synced
+	CapabilityUnsupported CapabilityState = "unsupported"
+)
+
+// capabilityStateRanks order the states set for the whole app ID, a later sync of the same app ID does not downgrade its state
+var capabilityStateRanks = map[CapabilityState]int{
+	CapabilitySkipped: 0,
+	CapabilityEnabled: 1,
+	CapabilityUpdated: 2,
+}
+
+// CapabilityMatrix records the state of the capabilities of the project's app IDs, synced by the Step.
+// The zero value is not usable, create it with NewCapabilityMatrix. Methods of a nil matrix are no-ops.
+// It is safe for concurrent use.
+type CapabilityMatrix struct {
+	mu               sync.Mutex
+	statesByBundleID map[string]map[string]CapabilityState // bundle ID/capability/state
+}
+
+// NewCapabilityMatrix returns a matrix of the capabilities of the entitlements, each of them skipped until its app ID is synced.
+// The entitlements without a known capability mapping are listed as unsupported, by their entitlement key.
+func NewCapabilityMatrix(entitlementsByBundleID map[string]serialized.Object) *CapabilityMatrix {
+	m := &CapabilityMatrix{statesByBundleID: map[string]map[string]CapabilityState{}}
+	for bundleID, entitlements := range entitlementsByBundleID {
+		states := map[string]CapabilityState{}
+		for key := range entitlements {
+			if isUnmappedEntitlementKey(key) {
+				states[key] = CapabilityUnsupported
+				continue
+			}
+
+			capabilityType, ok := appstoreconnect.ServiceTypeByKey[key]
+			if !ok || capabilityType == appstoreconnect.Ignored || capabilityType == appstoreconnect.ProfileAttachedEntitlement || capabilityType == appstoreconnect.ParentApplicationIdentifiers {
+				continue
+			}
+			states[string(capabilityType)] = CapabilitySkipped
+		}
+		m.statesByBundleID[bundleID] = states
+	}
+	return m
+}
+
+// SetBundleIDState sets the state of every supported capability of the app ID, if the state is newer than the current one:
+// an app ID updated for one distribution type stays updated, if it is in sync for the next one.
+func (m *CapabilityMatrix) SetBundleIDState(bundleID string, state CapabilityState) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for capability, current := range m.statesByBundleID[bundleID] {
+		currentRank, ok := capabilityStateRanks[current]
+		if ok && capabilityStateRanks[state] > currentRank {
+			m.statesByBundleID[bundleID][capability] = state
+		}
+	}
+}
+
+// SetCapabilityState sets the state of a capability of the app ID, including the capabilities not used by the project.
+func (m *CapabilityMatrix) SetCapabilityState(bundleID string, capabilityType appstoreconnect.CapabilityType, state CapabilityState) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.statesByBundleID[bundleID]; !ok {
+		m.statesByBundleID[bundleID] = map[string]CapabilityState{}
+	}
+	m.statesByBundleID[bundleID][string(capabilityType)] = state
+}
+
+// Markdown returns the matrix as a markdown table, sorted by bundle ID and capability.
+func (m *CapabilityMatrix) Markdown() string {
+	var b strings.Builder
+	b.WriteString("| Bundle ID | Capability | State |\n")
+	b.WriteString("| --- | --- | --- |\n")
+	if m == nil {
+		return b.String()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var bundleIDs []string
+	for bundleID := range m.statesByBundleID {
+		bundleIDs = append(bundleIDs, bundleID)
+	}
+	sort.Strings(bundleIDs)
+
+	for _, bundleID := range bundleIDs {
+		states := m.statesByBundleID[bundleID]
+
+		var capabilities []string
+		for capability := range states {
+			capabilities = append(capabilities, capability)
+		}
+		sort.Strings(capabilities)
+
+		for _, capability := range capabilities {
+			b.WriteString(fmt.Sprintf("| %s | %s | %s |\n", bundleID, capability, states[capability]))
+		}
+	}
+	return b.String()
+}
+
+// WriteCapabilityMatrix writes the markdown table of the capability matrix to the path.
+func WriteCapabilityMatrix(pth string, m *CapabilityMatrix) error {
+	content := "# Capability matrix\n\n" + m.Markdown()
+	if err := writeFileAtomic(pth, []byte(content)); err != nil {
+		return fmt.Errorf("failed to write capability matrix (%s): %s", pth, err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package autoprovision
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilityMatrix(t *testing.T) {
+	m := NewCapabilityMatrix(map[string]serialized.Object{
+		"io.bitrise.app": {
+			"aps-environment":                   "production",
+			"com.apple.developer.siri":          true,
+			"com.apple.developer.unknown-thing": true,
+			"keychain-access-groups":            []interface{}{"$(AppIdentifierPrefix)io.bitrise.app"},
+		},
+		"io.bitrise.app.widget": {
+			"aps-environment": "production",
+		},
+		"io.bitrise.app.offline": {
+			"com.apple.developer.siri": true,
+		},
+	})
+
+	m.SetBundleIDState("io.bitrise.app", CapabilityUpdated)
+	m.SetBundleIDState("io.bitrise.app", CapabilityEnabled)
+	m.SetBundleIDState("io.bitrise.app.widget", CapabilityEnabled)
+	m.SetCapabilityState("io.bitrise.app.widget", appstoreconnect.ICloud, CapabilityDisabled)
+
+	want := `| Bundle ID | Capability | State |
+| --- | --- | --- |
+| io.bitrise.app | PUSH_NOTIFICATIONS | updated |
+| io.bitrise.app | SIRIKIT | updated |
+| io.bitrise.app | com.apple.developer.unknown-thing | unsupported |
+| io.bitrise.app.offline | SIRIKIT | skipped |
+| io.bitrise.app.widget | ICLOUD | disabled |
+| io.bitrise.app.widget | PUSH_NOTIFICATIONS | enabled |
+`
+	require.Equal(t, want, m.Markdown())
+
+	pth := filepath.Join(t.TempDir(), "capability_matrix.md")
+	require.NoError(t, WriteCapabilityMatrix(pth, m))
+	content, err := ioutil.ReadFile(pth)
+	require.NoError(t, err)
+	require.Equal(t, "# Capability matrix\n\n"+want, string(content))
+}
+
+func TestCapabilityMatrix_nil(t *testing.T) {
+	var m *CapabilityMatrix
+	m.SetBundleIDState("io.bitrise.app", CapabilityUpdated)
+	m.SetCapabilityState("io.bitrise.app", appstoreconnect.ICloud, CapabilityDisabled)
+	require.Equal(t, "| Bundle ID | Capability | State |\n| --- | --- | --- |\n", m.Markdown())
+}
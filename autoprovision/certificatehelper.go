@@ -4,6 +4,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"math/big"
+	"sort"
 	"strings"
 
 	"github.com/bitrise-io/go-utils/log"
@@ -30,6 +31,31 @@ var CertificateTypeByDistribution = map[DistributionType]appstoreconnect.Certifi
 	Enterprise:  appstoreconnect.IOSDistribution,
 }
 
+// MacCertificateTypeByDistribution ...
+var MacCertificateTypeByDistribution = map[DistributionType]appstoreconnect.CertificateType{
+	Development: appstoreconnect.MacDevelopment,
+	AppStore:    appstoreconnect.MacDistribution,
+}
+
+// CertificateType returns the type of the certificate signing the app for the given platform and distribution type.
+func CertificateType(platform Platform, distribution DistributionType) (appstoreconnect.CertificateType, bool) {
+	certificateTypes := CertificateTypeByDistribution
+	if spec, ok := platformSpecs[platform]; ok {
+		certificateTypes = spec.CertificateTypes
+	}
+	certificateType, ok := certificateTypes[distribution]
+	return certificateType, ok
+}
+
+// InstallerCertificateType returns the type of the certificate signing the installer package (productbuild),
+// it returns false if the distribution does not need an installer package.
+func InstallerCertificateType(platform Platform, distribution DistributionType) (appstoreconnect.CertificateType, bool) {
+	if platform == MacOS && distribution == AppStore {
+		return appstoreconnect.MacInstallerDistribution, true
+	}
+	return "", false
+}
+
 // APICertificate is certificate present on Apple App Store Connect API, could match a local certificate
 type APICertificate struct {
 	Certificate certificateutil.CertificateInfoModel
@@ -76,26 +102,13 @@ func queryAllIOSCertificates(client *appstoreconnect.Client) (map[appstoreconnec
 }
 
 func queryCertificatesByType(client *appstoreconnect.Client, certificateType appstoreconnect.CertificateType) ([]APICertificate, error) {
-	nextPageURL := ""
-	var certificates []appstoreconnect.Certificate
-	for {
-		response, err := client.Provisioning.ListCertificates(&appstoreconnect.ListCertificatesOptions{
-			PagingOptions: appstoreconnect.PagingOptions{
-				Limit: 20,
-				Next:  nextPageURL,
-			},
-			FilterCertificateType: certificateType,
-		})
-		if err != nil {
-			return nil, err
-		}
-		certificates = append(certificates, response.Data...)
-
-		nextPageURL = response.Links.Next
-		if nextPageURL == "" {
-			return parseCertificatesResponse(certificates)
-		}
+	certificates, err := client.Provisioning.ListAllCertificates(&appstoreconnect.ListCertificatesOptions{
+		FilterCertificateType: certificateType,
+	})
+	if err != nil {
+		return nil, err
 	}
+	return parseCertificatesResponse(certificates)
 }
 
 func queryCertificateBySerial(client *appstoreconnect.Client, serial *big.Int) (APICertificate, error) {
@@ -156,7 +169,14 @@ func (e MissingCertificateError) Error() string {
 
 // GetValidCertificates ...
 func GetValidCertificates(localCertificates []certificateutil.CertificateInfoModel, client CertificateSource, requiredCertificateTypes map[appstoreconnect.CertificateType]bool, teamID string, isDebugLog bool) (map[appstoreconnect.CertificateType][]APICertificate, error) {
-	typeToLocalCerts, err := GetValidLocalCertificates(localCertificates, teamID)
+	var additionalTypes []appstoreconnect.CertificateType
+	for certificateType := range requiredCertificateTypes {
+		if certificateType != appstoreconnect.IOSDevelopment && certificateType != appstoreconnect.IOSDistribution {
+			additionalTypes = append(additionalTypes, certificateType)
+		}
+	}
+
+	typeToLocalCerts, err := GetValidLocalCertificates(localCertificates, teamID, additionalTypes...)
 	if err != nil {
 		return nil, err
 	}
@@ -203,7 +223,8 @@ func GetValidCertificates(localCertificates []certificateutil.CertificateInfoMod
 }
 
 // GetValidLocalCertificates returns validated and deduplicated local certificates
-func GetValidLocalCertificates(certificates []certificateutil.CertificateInfoModel, teamID string) (map[appstoreconnect.CertificateType][]certificateutil.CertificateInfoModel, error) {
+// of the iOS development and distribution types and the additional types.
+func GetValidLocalCertificates(certificates []certificateutil.CertificateInfoModel, teamID string, additionalTypes ...appstoreconnect.CertificateType) (map[appstoreconnect.CertificateType][]certificateutil.CertificateInfoModel, error) {
 	preFilteredCerts := certificateutil.FilterValidCertificateInfos(certificates)
 
 	if len(preFilteredCerts.InvalidCertificates) != 0 {
@@ -216,7 +237,7 @@ func GetValidLocalCertificates(certificates []certificateutil.CertificateInfoMod
 	log.Debugf("Valid and deduplicated certificates:\n%s", CertsToString(preFilteredCerts.ValidCertificates))
 
 	localCertificates := map[appstoreconnect.CertificateType][]certificateutil.CertificateInfoModel{}
-	for _, certType := range []appstoreconnect.CertificateType{appstoreconnect.IOSDevelopment, appstoreconnect.IOSDistribution} {
+	for _, certType := range append([]appstoreconnect.CertificateType{appstoreconnect.IOSDevelopment, appstoreconnect.IOSDistribution}, additionalTypes...) {
 		localCertificates[certType] = filterCertificates(preFilteredCerts.ValidCertificates, certType, teamID)
 	}
 
@@ -262,14 +283,88 @@ func LogAllAPICertificates(client CertificateSource, localCertificates map[appst
 	return nil
 }
 
+// CertificateSelection describes how to choose from multiple valid certificates of the same type
+type CertificateSelection string
+
+// CertificateSelections ...
+const (
+	NewestCertificate CertificateSelection = "newest"
+	OldestCertificate CertificateSelection = "oldest"
+)
+
+// SelectCertificate picks a certificate from the given valid certificates.
+// The selection is either newest (latest expiry), oldest (earliest expiry) or a certificate serial number (decimal or hexadecimal).
+// Certificates with an available private key are preferred, as only those can be used for code signing.
+// It returns the selected certificate and the reasoning behind the selection.
+func SelectCertificate(certificates []APICertificate, selection CertificateSelection) (APICertificate, string, error) {
+	if len(certificates) == 0 {
+		return APICertificate{}, "", fmt.Errorf("no certificate to select from")
+	}
+
+	if selection == "" {
+		selection = NewestCertificate
+	}
+
+	var withKey []APICertificate
+	for _, cert := range certificates {
+		if cert.Certificate.PrivateKey != nil {
+			withKey = append(withKey, cert)
+		}
+	}
+
+	var keyReason string
+	candidates := certificates
+	if len(withKey) > 0 && len(withKey) < len(certificates) {
+		candidates = withKey
+		keyReason = ", private key available"
+	}
+
+	switch selection {
+	case NewestCertificate, OldestCertificate:
+		sorted := make([]APICertificate, len(candidates))
+		copy(sorted, candidates)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Certificate.EndDate.After(sorted[j].Certificate.EndDate)
+		})
+
+		if selection == NewestCertificate {
+			selected := sorted[0]
+			return selected, fmt.Sprintf("latest expiry (%s)%s", selected.Certificate.EndDate, keyReason), nil
+		}
+
+		selected := sorted[len(sorted)-1]
+		return selected, fmt.Sprintf("earliest expiry (%s)%s", selected.Certificate.EndDate, keyReason), nil
+	}
+
+	for _, cert := range certificates {
+		if certificateSerialMatches(cert.Certificate, string(selection)) {
+			return cert, fmt.Sprintf("serial number matches (%s)", selection), nil
+		}
+	}
+
+	return APICertificate{}, "", fmt.Errorf("no valid certificate found with serial number: %s", selection)
+}
+
+func certificateSerialMatches(cert certificateutil.CertificateInfoModel, serial string) bool {
+	serial = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(serial)), "0x")
+	if serial == "" {
+		return false
+	}
+
+	if cert.Serial == serial {
+		return true
+	}
+
+	serialNumber, ok := new(big.Int).SetString(cert.Serial, 10)
+	return ok && serialNumber.Text(16) == serial
+}
+
 // filterCertificates returns the certificates matching to the given common name, developer team ID, and distribution type.
 func filterCertificates(certificates []certificateutil.CertificateInfoModel, certificateType appstoreconnect.CertificateType, teamID string) []certificateutil.CertificateInfoModel {
 	// filter by distribution type
 	var filteredCertificates []certificateutil.CertificateInfoModel
 	for _, certificate := range certificates {
-		if certificateType == appstoreconnect.IOSDistribution && isDistributionCertificate(certificate) {
-			filteredCertificates = append(filteredCertificates, certificate)
-		} else if certificateType == appstoreconnect.IOSDevelopment && !isDistributionCertificate(certificate) {
+		if certificateMatchesType(certificate, certificateType) {
 			filteredCertificates = append(filteredCertificates, certificate)
 		}
 	}
@@ -311,3 +406,35 @@ func isDistributionCertificate(cert certificateutil.CertificateInfoModel) bool {
 	return strings.HasPrefix(strings.ToLower(cert.CommonName), strings.ToLower("iPhone Distribution")) ||
 		strings.HasPrefix(strings.ToLower(cert.CommonName), strings.ToLower("Apple Distribution"))
 }
+
+func isMacDistributionCertificate(cert certificateutil.CertificateInfoModel) bool {
+	return strings.HasPrefix(strings.ToLower(cert.CommonName), strings.ToLower("3rd Party Mac Developer Application")) ||
+		strings.HasPrefix(strings.ToLower(cert.CommonName), strings.ToLower("Apple Distribution"))
+}
+
+func isMacDevelopmentCertificate(cert certificateutil.CertificateInfoModel) bool {
+	return strings.HasPrefix(strings.ToLower(cert.CommonName), strings.ToLower("Mac Developer")) ||
+		strings.HasPrefix(strings.ToLower(cert.CommonName), strings.ToLower("Apple Development"))
+}
+
+func isInstallerCertificate(cert certificateutil.CertificateInfoModel) bool {
+	return strings.HasPrefix(strings.ToLower(cert.CommonName), strings.ToLower("3rd Party Mac Developer Installer")) ||
+		strings.HasPrefix(strings.ToLower(cert.CommonName), strings.ToLower("Mac Installer Distribution"))
+}
+
+// certificateMatchesType reports whether the certificate is of the given type, based on its common name.
+func certificateMatchesType(cert certificateutil.CertificateInfoModel, certificateType appstoreconnect.CertificateType) bool {
+	switch certificateType {
+	case appstoreconnect.IOSDistribution:
+		return isDistributionCertificate(cert)
+	case appstoreconnect.IOSDevelopment:
+		return !isDistributionCertificate(cert)
+	case appstoreconnect.MacDistribution:
+		return isMacDistributionCertificate(cert)
+	case appstoreconnect.MacDevelopment:
+		return isMacDevelopmentCertificate(cert)
+	case appstoreconnect.MacInstallerDistribution:
+		return isInstallerCertificate(cert)
+	}
+	return false
+}
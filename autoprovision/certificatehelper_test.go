@@ -281,3 +281,159 @@ func TestGetValidCertificates(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectCertificate(t *testing.T) {
+	newCert := func(serial int64, expiry time.Time, hasKey bool) APICertificate {
+		var privateKey interface{}
+		if hasKey {
+			privateKey = "key"
+		}
+		return APICertificate{
+			Certificate: certificateutil.CertificateInfoModel{
+				CommonName: fmt.Sprintf("Apple Distribution: test %d", serial),
+				EndDate:    expiry,
+				Serial:     big.NewInt(serial).String(),
+				PrivateKey: privateKey,
+			},
+			ID: fmt.Sprintf("cert%d", serial),
+		}
+	}
+
+	now := time.Now()
+	older := newCert(26, now.AddDate(0, 6, 0), true)
+	newer := newCert(27, now.AddDate(1, 0, 0), true)
+	newestWithoutKey := newCert(28, now.AddDate(2, 0, 0), false)
+
+	tests := []struct {
+		name         string
+		certificates []APICertificate
+		selection    CertificateSelection
+		wantID       string
+		wantErr      bool
+	}{
+		{
+			name:         "defaults to newest",
+			certificates: []APICertificate{older, newer},
+			selection:    "",
+			wantID:       "cert27",
+		},
+		{
+			name:         "newest",
+			certificates: []APICertificate{older, newer},
+			selection:    NewestCertificate,
+			wantID:       "cert27",
+		},
+		{
+			name:         "oldest",
+			certificates: []APICertificate{newer, older},
+			selection:    OldestCertificate,
+			wantID:       "cert26",
+		},
+		{
+			name:         "newest prefers certificates with private key",
+			certificates: []APICertificate{older, newestWithoutKey, newer},
+			selection:    NewestCertificate,
+			wantID:       "cert27",
+		},
+		{
+			name:         "decimal serial",
+			certificates: []APICertificate{older, newer},
+			selection:    "26",
+			wantID:       "cert26",
+		},
+		{
+			name:         "hexadecimal serial",
+			certificates: []APICertificate{older, newer},
+			selection:    "0x1B",
+			wantID:       "cert27",
+		},
+		{
+			name:         "unknown serial",
+			certificates: []APICertificate{older, newer},
+			selection:    "99",
+			wantErr:      true,
+		},
+		{
+			name:         "no certificates",
+			certificates: nil,
+			selection:    NewestCertificate,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason, err := SelectCertificate(tt.certificates, tt.selection)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.ID != tt.wantID {
+				t.Errorf("SelectCertificate() = %v, want %v", got.ID, tt.wantID)
+			}
+			if reason == "" {
+				t.Errorf("SelectCertificate() returned empty reason")
+			}
+		})
+	}
+}
+
+func Test_certificateMatchesType(t *testing.T) {
+	tests := []struct {
+		commonName      string
+		certificateType appstoreconnect.CertificateType
+		want            bool
+	}{
+		{"iPhone Developer: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.IOSDevelopment, true},
+		{"iPhone Distribution: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.IOSDistribution, true},
+		{"iPhone Distribution: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.IOSDevelopment, false},
+		{"Mac Developer: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.MacDevelopment, true},
+		{"Apple Development: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.MacDevelopment, true},
+		{"3rd Party Mac Developer Application: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.MacDistribution, true},
+		{"Apple Distribution: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.MacDistribution, true},
+		{"3rd Party Mac Developer Application: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.MacInstallerDistribution, false},
+		{"3rd Party Mac Developer Installer: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.MacInstallerDistribution, true},
+		{"Mac Installer Distribution: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.MacInstallerDistribution, true},
+		{"3rd Party Mac Developer Installer: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.MacDistribution, false},
+		{"Developer ID Application: Bitrise Bot (VV2J4SV8V4)", appstoreconnect.DeveloperIDApplication, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.certificateType, tt.commonName), func(t *testing.T) {
+			cert := certificateutil.CertificateInfoModel{CommonName: tt.commonName}
+			if got := certificateMatchesType(cert, tt.certificateType); got != tt.want {
+				t.Errorf("certificateMatchesType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateType(t *testing.T) {
+	tests := []struct {
+		platform      Platform
+		distribution  DistributionType
+		want          appstoreconnect.CertificateType
+		wantOk        bool
+		wantInstaller bool
+	}{
+		{IOS, AppStore, appstoreconnect.IOSDistribution, true, false},
+		{TVOS, Development, appstoreconnect.IOSDevelopment, true, false},
+		{WatchOS, AdHoc, appstoreconnect.IOSDistribution, true, false},
+		{MacOS, Development, appstoreconnect.MacDevelopment, true, false},
+		{MacOS, AppStore, appstoreconnect.MacDistribution, true, true},
+		{MacOS, AdHoc, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.platform, tt.distribution), func(t *testing.T) {
+			got, ok := CertificateType(tt.platform, tt.distribution)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("CertificateType() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+
+			installer, ok := InstallerCertificateType(tt.platform, tt.distribution)
+			if ok != tt.wantInstaller || (ok && installer != appstoreconnect.MacInstallerDistribution) {
+				t.Errorf("InstallerCertificateType() = %v, %v, want installer: %v", installer, ok, tt.wantInstaller)
+			}
+		})
+	}
+}
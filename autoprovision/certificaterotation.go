@@ -0,0 +1,171 @@
+package autoprovision
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// rotationDrillCommonName is the default common name of the certificate signing request of the rotation drill certificates,
+// the Developer Portal replaces it with the certificate type and the team name.
+const rotationDrillCommonName = "Bitrise certificate rotation drill"
+
+// CertificateKeyType is the type of the private key generated for a new certificate
+type CertificateKeyType string
+
+// CertificateKeyTypes ...
+const (
+	RSA2048Key CertificateKeyType = "rsa-2048"
+	ECP256Key  CertificateKeyType = "ec-p256"
+)
+
+// oidEmailAddress is the PKCS #9 emailAddress attribute, Keychain Access puts the email address in the CSR subject with it
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// CertificateRequestOptions are the subject fields and the key type of a certificate signing request
+type CertificateRequestOptions struct {
+	CommonName   string
+	EmailAddress string
+	Organization string
+	KeyType      CertificateKeyType
+}
+
+// NewCertificateRequest generates a new private key of the key type (RSA 2048 by default)
+// and returns it with the PEM encoded certificate signing request for it.
+func NewCertificateRequest(opts CertificateRequestOptions) ([]byte, crypto.Signer, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch opts.KeyType {
+	case RSA2048Key, "":
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ECP256Key:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type: %s", opts.KeyType)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate private key: %s", err)
+	}
+
+	subject := pkix.Name{CommonName: opts.CommonName}
+	if subject.CommonName == "" {
+		subject.CommonName = rotationDrillCommonName
+	}
+	if opts.Organization != "" {
+		subject.Organization = []string{opts.Organization}
+	}
+	if opts.EmailAddress != "" {
+		subject.ExtraNames = append(subject.ExtraNames, pkix.AttributeTypeAndValue{Type: oidEmailAddress, Value: opts.EmailAddress})
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: subject}, privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate signing request: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), privateKey, nil
+}
+
+// CreateCertificate creates a certificate of the given type on the Developer Portal for the PEM encoded certificate signing request.
+// The private key of the request is included in the returned certificate.
+func CreateCertificate(client *appstoreconnect.Client, certificateType appstoreconnect.CertificateType, csr []byte, privateKey crypto.Signer) (APICertificate, error) {
+	r, err := client.Provisioning.CreateCertificate(appstoreconnect.CertificateCreateRequest{
+		Data: appstoreconnect.CertificateCreateRequestData{
+			Attributes: appstoreconnect.CertificateCreateRequestDataAttributes{
+				CertificateType: certificateType,
+				CsrContent:      string(csr),
+			},
+			Type: "certificates",
+		},
+	})
+	if err != nil {
+		if isForbiddenError(err) {
+			return APICertificate{}, fmt.Errorf("failed to create %s certificate, the role of the API key does not allow it: "+
+				"creating certificates requires an API key with the Admin role, App Manager keys are rejected: %s", certificateType, err)
+		}
+		return APICertificate{}, fmt.Errorf("failed to create %s certificate (the team might have reached its certificate limit): %s", certificateType, err)
+	}
+
+	certificate, err := x509.ParseCertificate(r.Data.Attributes.CertificateContent)
+	if err != nil {
+		return APICertificate{}, fmt.Errorf("failed to parse the created certificate: %s", err)
+	}
+
+	return APICertificate{
+		Certificate: certificateutil.NewCertificateInfo(*certificate, privateKey),
+		ID:          r.Data.ID,
+	}, nil
+}
+
+// isForbiddenError returns true if the API rejected the request with 403, the role of the API key does not allow it.
+func isForbiddenError(err error) bool {
+	var respErr *appstoreconnect.ErrorResponse
+	return errors.As(err, &respErr) && respErr.Response != nil && respErr.Response.StatusCode == http.StatusForbidden
+}
+
+// ExportCertificate writes the certificate with its private key to a passphrase protected p12 file,
+// so that the private key generated for the rotation drill certificate is not lost with the build machine.
+func ExportCertificate(certificate APICertificate, pth, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("no passphrase provided to protect the exported private key")
+	}
+
+	p12, err := certificate.Certificate.EncodeToP12(passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pth), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(pth, p12, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %s", pth, err)
+	}
+	return nil
+}
+
+// RotationRollbackPlan describes how to roll back a certificate rotation drill
+type RotationRollbackPlan struct {
+	CertificateType      appstoreconnect.CertificateType
+	NewCertificate       APICertificate
+	PreviousCertificates []APICertificate
+	// P12Path is the passphrase protected p12 export of the new certificate and its private key
+	P12Path string
+	// CSRPath is the certificate signing request submitted for the new certificate, empty if it is not saved
+	CSRPath string
+	// Profiles are the names of the profiles ensured with both the new and the previous certificates
+	Profiles []string
+}
+
+// String returns the steps of the rollback
+func (p RotationRollbackPlan) String() string {
+	var previous []string
+	for _, certificate := range p.PreviousCertificates {
+		previous = append(previous, fmt.Sprintf("%s (serial: %s)", certificate.Certificate.CommonName, certificate.Certificate.Serial))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Certificate rotation drill: created %s (ID: %s, serial: %s), previous: %s\n",
+		p.NewCertificate.Certificate.CommonName, p.NewCertificate.ID, p.NewCertificate.Certificate.Serial, strings.Join(previous, ", "))
+	fmt.Fprintf(&b, "The profiles include both the new and the previous certificates: %s\n", strings.Join(p.Profiles, ", "))
+	b.WriteString("To roll back:\n")
+	fmt.Fprintf(&b, "1. Revoke the new certificate (ID: %s) on the Developer Portal.\n", p.NewCertificate.ID)
+	b.WriteString("2. Run the Step without rotation_drill, it regenerates the profiles with the previous certificates.\n")
+	fmt.Fprintf(&b, "To complete the rotation instead, upload the new certificate with its private key (%s, protected with the rotation_drill_p12_passphrase input) in place of the previous one.", p.P12Path)
+	return b.String()
+}
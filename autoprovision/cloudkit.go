@@ -0,0 +1,120 @@
+package autoprovision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+const (
+	iCloudContainerEnvironmentEntitlementKey  = "com.apple.developer.icloud-container-environment"
+	iCloudDevelopmentContainersEntitlementKey = "com.apple.developer.icloud-container-development-container-identifiers"
+	cloudKitEnvironmentMismatchWarningFormat  = "%s: the project sets the CloudKit environment (%s), but the %s distribution uses the %s environment"
+	developmentOnlyContainerWarningFormat     = "%s: the container (%s) is only available in the Development CloudKit environment of the profile, the %s distribution uses the Production environment, deploy the container's schema to production in the CloudKit Console"
+)
+
+// CloudKitEnvironment is the environment of the iCloud containers, an app uses
+type CloudKitEnvironment string
+
+// CloudKitEnvironment ...
+const (
+	DevelopmentCloudKitEnvironment CloudKitEnvironment = "Development"
+	ProductionCloudKitEnvironment  CloudKitEnvironment = "Production"
+)
+
+// CloudKitEnvironmentOf returns the CloudKit environment, the apps of the distribution type use:
+// the development builds use the Development, the exported (ad-hoc, app-store, enterprise) builds the Production environment.
+func CloudKitEnvironmentOf(distribution DistributionType) CloudKitEnvironment {
+	if distribution == Development {
+		return DevelopmentCloudKitEnvironment
+	}
+	return ProductionCloudKitEnvironment
+}
+
+// profileTypeCloudKitEnvironment returns the CloudKit environment of the profile type
+func profileTypeCloudKitEnvironment(profileType appstoreconnect.ProfileType) CloudKitEnvironment {
+	if strings.HasSuffix(string(profileType), "_DEVELOPMENT") {
+		return DevelopmentCloudKitEnvironment
+	}
+	return ProductionCloudKitEnvironment
+}
+
+// environmentContainers returns the profile entitlements with the containers available in the CloudKit environment:
+// the development profiles also list the containers of the Development environment separately.
+func environmentContainers(profileEnts serialized.Object, environment CloudKitEnvironment) serialized.Object {
+	if environment != DevelopmentCloudKitEnvironment {
+		return profileEnts
+	}
+
+	developmentContainers, err := profileEnts.StringSlice(iCloudDevelopmentContainersEntitlementKey)
+	if err != nil || len(developmentContainers) == 0 {
+		return profileEnts
+	}
+	containers, _ := profileEnts.StringSlice(iCloudIdentifiersEntitlementKey)
+
+	merged := serialized.Object{}
+	for key, value := range profileEnts {
+		merged[key] = value
+	}
+	var list []interface{}
+	for _, container := range append(containers, developmentContainers...) {
+		list = append(list, container)
+	}
+	merged[iCloudIdentifiersEntitlementKey] = list
+	return merged
+}
+
+// CloudKitEnvironmentWarnings returns the warnings about the CloudKit environment of the distribution type:
+// the project entitlements setting another environment explicitly, and, for the Production environment,
+// the containers only available in the Development environment of the profile.
+func CloudKitEnvironmentWarnings(distribution DistributionType, bundleID string, projectEntitlements Entitlement, profile appstoreconnect.Profile) ([]string, error) {
+	containers, err := projectEntitlements.ICloudContainers()
+	if err != nil {
+		return nil, err
+	}
+	environment := CloudKitEnvironmentOf(distribution)
+
+	var warnings []string
+	projectEnts := NormalizeEntitlements(serialized.Object(projectEntitlements))
+	// the environment is a single string in the entitlements file, but older projects list it in an array
+	projectEnvironments, err := projectEnts.StringSlice(iCloudContainerEnvironmentEntitlementKey)
+	if err != nil {
+		if projectEnvironment, err := projectEnts.String(iCloudContainerEnvironmentEntitlementKey); err == nil {
+			projectEnvironments = []string{projectEnvironment}
+		}
+	}
+	for _, projectEnvironment := range projectEnvironments {
+		if !strings.EqualFold(projectEnvironment, string(environment)) {
+			warnings = append(warnings, fmt.Sprintf(cloudKitEnvironmentMismatchWarningFormat, bundleID, projectEnvironment, distribution, environment))
+		}
+	}
+
+	if environment != ProductionCloudKitEnvironment || len(containers) == 0 {
+		return warnings, nil
+	}
+
+	profileEnts, err := parseRawProfileEntitlements(profile)
+	if err != nil {
+		return nil, err
+	}
+	profileEnts = NormalizeEntitlements(profileEnts)
+	productionContainers, _ := profileEnts.StringSlice(iCloudIdentifiersEntitlementKey)
+	developmentContainers, _ := profileEnts.StringSlice(iCloudDevelopmentContainersEntitlementKey)
+	for _, container := range containers {
+		if !containsString(productionContainers, container) && containsString(developmentContainers, container) {
+			warnings = append(warnings, fmt.Sprintf(developmentOnlyContainerWarningFormat, bundleID, container, distribution))
+		}
+	}
+	return warnings, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudKitEnvironmentOf(t *testing.T) {
+	require.Equal(t, DevelopmentCloudKitEnvironment, CloudKitEnvironmentOf(Development))
+	for _, distribution := range []DistributionType{AdHoc, AppStore, Enterprise} {
+		require.Equal(t, ProductionCloudKitEnvironment, CloudKitEnvironmentOf(distribution))
+	}
+
+	require.Equal(t, DevelopmentCloudKitEnvironment, profileTypeCloudKitEnvironment(appstoreconnect.IOSAppDevelopment))
+	require.Equal(t, ProductionCloudKitEnvironment, profileTypeCloudKitEnvironment(appstoreconnect.IOSAppStore))
+}
+
+func Test_findMissingContainers_environment(t *testing.T) {
+	projectEnts := serialized.Object{
+		iCloudIdentifiersEntitlementKey: []interface{}{"iCloud.io.bitrise.app", "iCloud.io.bitrise.dev"},
+	}
+	profileEnts := serialized.Object{
+		iCloudIdentifiersEntitlementKey:           []interface{}{"iCloud.io.bitrise.app"},
+		iCloudDevelopmentContainersEntitlementKey: []interface{}{"iCloud.io.bitrise.dev"},
+	}
+
+	missing, err := findMissingContainers(projectEnts, environmentContainers(profileEnts, DevelopmentCloudKitEnvironment))
+	require.NoError(t, err)
+	require.Empty(t, missing)
+
+	missing, err = findMissingContainers(projectEnts, environmentContainers(profileEnts, ProductionCloudKitEnvironment))
+	require.NoError(t, err)
+	require.Equal(t, []string{"iCloud.io.bitrise.dev"}, missing)
+
+	_, ok := profileEnts[iCloudIdentifiersEntitlementKey].([]interface{})
+	require.True(t, ok)
+	require.Len(t, profileEnts[iCloudIdentifiersEntitlementKey], 1, "the profile entitlements should not be modified")
+}
+
+func TestCloudKitEnvironmentWarnings_projectEnvironment(t *testing.T) {
+	entitlements := Entitlement{iCloudContainerEnvironmentEntitlementKey: "Development"}
+
+	warnings, err := CloudKitEnvironmentWarnings(Development, "io.bitrise.app", entitlements, appstoreconnect.Profile{})
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	warnings, err = CloudKitEnvironmentWarnings(AppStore, "io.bitrise.app", entitlements, appstoreconnect.Profile{})
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], "app-store distribution uses the Production environment")
+}
@@ -0,0 +1,45 @@
+package autoprovision
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/go-xcode/plistutil"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// DEREntitlementsMinXcodeMajorVersion is the first Xcode version, which signs the apps with DER encoded entitlements
+// and requires them to be present in the provisioning profiles.
+// Profiles generated before the DER encoded entitlements were introduced contain plain entitlements only, and break the build.
+const DEREntitlementsMinXcodeMajorVersion = 13
+
+// derEncodedProfileKey is the profile key holding the DER encoded profile, including the entitlements
+const derEncodedProfileKey = "DER-Encoded-Profile"
+
+// RequiresDEREntitlements returns true if the Xcode version requires profiles with DER encoded entitlements
+func RequiresDEREntitlements(xcodeMajorVersion int64) bool {
+	return xcodeMajorVersion >= DEREntitlementsMinXcodeMajorVersion
+}
+
+// CheckProfileDEREntitlements returns a NonmatchingProfileError if the profile does not contain the DER encoded entitlements.
+func CheckProfileDEREntitlements(profile appstoreconnect.Profile) error {
+	decoded, err := decodeProfileContent(profile.Attributes.ProfileContent)
+	if err != nil {
+		return err
+	}
+
+	if !hasDEREntitlements(decoded.Data) {
+		return NonmatchingProfileError{
+			Reason: fmt.Sprintf("profile has no DER encoded entitlements, required by Xcode %d and newer", DEREntitlementsMinXcodeMajorVersion),
+		}
+	}
+	return nil
+}
+
+func hasDEREntitlements(profileData plistutil.PlistData) bool {
+	value, ok := profileData[derEncodedProfileKey]
+	if !ok {
+		return false
+	}
+	der, ok := value.([]byte)
+	return ok && len(der) > 0
+}
@@ -0,0 +1,39 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-xcode/plistutil"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequiresDEREntitlements(t *testing.T) {
+	require.False(t, RequiresDEREntitlements(12))
+	require.True(t, RequiresDEREntitlements(13))
+	require.True(t, RequiresDEREntitlements(15))
+}
+
+func Test_hasDEREntitlements(t *testing.T) {
+	tests := []struct {
+		name        string
+		profileData plistutil.PlistData
+		want        bool
+	}{
+		{name: "DER encoded profile", profileData: plistutil.PlistData{"DER-Encoded-Profile": []byte{0x30, 0x82}}, want: true},
+		{name: "empty DER encoded profile", profileData: plistutil.PlistData{"DER-Encoded-Profile": []byte{}}, want: false},
+		{name: "plain entitlements only", profileData: plistutil.PlistData{"Entitlements": map[string]interface{}{"get-task-allow": true}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, hasDEREntitlements(tt.profileData))
+		})
+	}
+}
+
+func TestCheckProfileDEREntitlements_invalidContent(t *testing.T) {
+	err := CheckProfileDEREntitlements(appstoreconnect.Profile{Attributes: appstoreconnect.ProfileAttributes{ProfileContent: []byte("not a profile")}})
+	require.Error(t, err)
+	_, ok := err.(NonmatchingProfileError)
+	require.False(t, ok, "invalid content is not a nonmatching profile")
+}
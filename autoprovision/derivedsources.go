@@ -0,0 +1,89 @@
+package autoprovision
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+// derivedSourcesDirName is the directory of BUILT_PRODUCTS_DIR, the build tool plugins of the Swift packages generate their outputs into
+const derivedSourcesDirName = "DerivedSources"
+
+// derivedSourcesReferencePattern matches the not expanded $(BUILT_PRODUCTS_DIR)/DerivedSources/ and ${BUILT_PRODUCTS_DIR}/DerivedSources/ path prefixes
+var derivedSourcesReferencePattern = regexp.MustCompile(`^\$[({]BUILT_PRODUCTS_DIR[)}]/` + derivedSourcesDirName + `/`)
+
+// GeneratedFileError is returned if a build setting of a target points to a file generated by a build plugin,
+// which does not exist before building the project.
+type GeneratedFileError struct {
+	Target  string
+	Setting string
+	Path    string
+	// RelativePath is the path of the file relative to ${BUILT_PRODUCTS_DIR}/DerivedSources
+	RelativePath string
+	// SuppliedPath is the expected location of the file in the derived sources directory input, if set
+	SuppliedPath string
+}
+
+func (e GeneratedFileError) Error() string {
+	msg := fmt.Sprintf("target (%s) %s (%s) points to a file generated into ${BUILT_PRODUCTS_DIR}/%s by a build plugin, which does not exist before the build", e.Target, e.Setting, e.Path, derivedSourcesDirName)
+	if e.SuppliedPath != "" {
+		return msg + fmt.Sprintf(", and its expected content is not found in the derived sources directory either: %s", e.SuppliedPath)
+	}
+	return msg + fmt.Sprintf(", supply its expected content in the derived sources directory input, as: <derived_sources_dir>/%s", e.RelativePath)
+}
+
+// derivedSourcesRelativePath returns the path of the file relative to ${BUILT_PRODUCTS_DIR}/DerivedSources,
+// if the (expanded or not expanded) path points into it.
+func derivedSourcesRelativePath(pth string, settings serialized.Object) (string, bool) {
+	if loc := derivedSourcesReferencePattern.FindStringIndex(pth); loc != nil {
+		return pth[loc[1]:], true
+	}
+
+	builtProductsDir, err := settings.String("BUILT_PRODUCTS_DIR")
+	if err != nil || builtProductsDir == "" {
+		return "", false
+	}
+
+	derivedSourcesDir := filepath.Join(builtProductsDir, derivedSourcesDirName) + string(filepath.Separator)
+	if !strings.HasPrefix(filepath.Clean(pth), derivedSourcesDir) {
+		return "", false
+	}
+	return strings.TrimPrefix(filepath.Clean(pth), derivedSourcesDir), true
+}
+
+// generatedFilePath returns the path to read the file of the target's build setting from,
+// if the setting points to a file generated into ${BUILT_PRODUCTS_DIR}/DerivedSources.
+// The file supplied in the DerivedSourcesDir is preferred, the generated file is only read if it already exists (for example from a previous build).
+// The returned bool is false if the setting does not point to a generated file.
+func (p *ProjectHelper) generatedFilePath(target, setting, pth string, settings serialized.Object) (string, bool, error) {
+	relativePath, ok := derivedSourcesRelativePath(pth, settings)
+	if !ok {
+		return "", false, nil
+	}
+
+	if p.DerivedSourcesDir != "" {
+		suppliedPath := filepath.Join(p.DerivedSourcesDir, relativePath)
+		if exists, err := pathutil.IsPathExists(suppliedPath); err != nil {
+			return "", false, err
+		} else if !exists {
+			return "", false, GeneratedFileError{Target: target, Setting: setting, Path: pth, RelativePath: relativePath, SuppliedPath: suppliedPath}
+		}
+
+		log.Debugf("Target (%s) %s (%s) is generated by a build plugin, using the supplied file: %s", target, setting, pth, suppliedPath)
+		return suppliedPath, true, nil
+	}
+
+	if !derivedSourcesReferencePattern.MatchString(pth) {
+		if exists, err := pathutil.IsPathExists(pth); err == nil && exists {
+			log.Warnf("Target (%s) %s (%s) is generated by a build plugin, using the output of a previous build", target, setting, pth)
+			return pth, true, nil
+		}
+	}
+
+	return "", false, GeneratedFileError{Target: target, Setting: setting, Path: pth, RelativePath: relativePath}
+}
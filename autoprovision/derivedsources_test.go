@@ -0,0 +1,85 @@
+package autoprovision
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_derivedSourcesRelativePath(t *testing.T) {
+	settings := serialized.Object{"BUILT_PRODUCTS_DIR": "/DerivedData/App/Build/Products/Release-iphoneos"}
+
+	tests := []struct {
+		name   string
+		pth    string
+		want   string
+		wantOk bool
+	}{
+		{name: "parentheses reference", pth: "$(BUILT_PRODUCTS_DIR)/DerivedSources/Plugin/App.entitlements", want: "Plugin/App.entitlements", wantOk: true},
+		{name: "braces reference", pth: "${BUILT_PRODUCTS_DIR}/DerivedSources/Info.plist", want: "Info.plist", wantOk: true},
+		{name: "expanded path", pth: "/DerivedData/App/Build/Products/Release-iphoneos/DerivedSources/Plugin/App.entitlements", want: "Plugin/App.entitlements", wantOk: true},
+		{name: "project file", pth: "App/App.entitlements", wantOk: false},
+		{name: "other built products file", pth: "/DerivedData/App/Build/Products/Release-iphoneos/App.entitlements", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := derivedSourcesRelativePath(tt.pth, settings)
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGeneratedEntitlementsPath(t *testing.T) {
+	newProjectHelper := func(derivedSourcesDir string) ProjectHelper {
+		return ProjectHelper{
+			XcProj:            xcodeproj.XcodeProj{Path: "/project/App.xcodeproj"},
+			DerivedSourcesDir: derivedSourcesDir,
+			buildSettingsCache: map[string]map[string]serialized.Object{
+				"App": {"Release": {
+					"BUILT_PRODUCTS_DIR":     "/DerivedData/App/Build/Products/Release-iphoneos",
+					"CODE_SIGN_ENTITLEMENTS": "/DerivedData/App/Build/Products/Release-iphoneos/DerivedSources/Plugin/App.entitlements",
+				}},
+			},
+		}
+	}
+
+	t.Run("without supplied content", func(t *testing.T) {
+		p := newProjectHelper("")
+
+		_, err := p.targetEntitlementsPath("App", "Release")
+		require.Error(t, err)
+		require.IsType(t, GeneratedFileError{}, err)
+		require.Contains(t, err.Error(), "<derived_sources_dir>/Plugin/App.entitlements")
+	})
+
+	t.Run("with supplied content", func(t *testing.T) {
+		dir := t.TempDir()
+		supplied := filepath.Join(dir, "Plugin", "App.entitlements")
+		require.NoError(t, os.MkdirAll(filepath.Dir(supplied), 0700))
+		require.NoError(t, ioutil.WriteFile(supplied, []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>aps-environment</key><string>development</string></dict></plist>`), 0600))
+		p := newProjectHelper(dir)
+
+		pth, err := p.targetEntitlementsPath("App", "Release")
+		require.NoError(t, err)
+		require.Equal(t, supplied, pth)
+
+		entitlements, err := p.targetEntitlements("App", "Release", "io.bitrise.app")
+		require.NoError(t, err)
+		require.Equal(t, "development", entitlements["aps-environment"])
+	})
+
+	t.Run("supplied content missing", func(t *testing.T) {
+		p := newProjectHelper(t.TempDir())
+
+		_, err := p.targetEntitlementsPath("App", "Release")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not found in the derived sources directory")
+	})
+}
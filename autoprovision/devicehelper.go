@@ -1,30 +1,216 @@
 package autoprovision
 
-import "github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// DeviceSource describes where a device used for provisioning comes from
+type DeviceSource string
+
+// DeviceSources ...
+const (
+	DeveloperPortalDevice DeviceSource = "Developer Portal"
+	BitriseTestDevice     DeviceSource = "Bitrise test device list"
+)
 
 // ListDevices returns the registered devices on the Apple Developer portal
 func ListDevices(client *appstoreconnect.Client, udid string, platform appstoreconnect.DevicePlatform) ([]appstoreconnect.Device, error) {
-	var nextPageURL string
-	var devices []appstoreconnect.Device
-	for {
-		response, err := client.Provisioning.ListDevices(&appstoreconnect.ListDevicesOptions{
-			PagingOptions: appstoreconnect.PagingOptions{
-				Limit: 20,
-				Next:  nextPageURL,
-			},
-			FilterUDID:     udid,
-			FilterPlatform: platform,
-			FilterStatus:   appstoreconnect.Enabled,
+	return client.Provisioning.ListAllDevices(&appstoreconnect.ListDevicesOptions{
+		FilterUDID:     udid,
+		FilterPlatform: platform,
+		FilterStatus:   appstoreconnect.Enabled,
+	})
+}
+
+// NormalizeUDID returns the comparable form of the UDID: the Developer Portal matches the UDIDs case insensitively,
+// with or without the hyphen of the newer (00008030-001A...) format, all of these variants identify the same device.
+func NormalizeUDID(udid string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(udid), "-", "", -1))
+}
+
+// FindDeviceByUDID returns the device registered with an equivalent UDID, or nil if none of the devices match
+func FindDeviceByUDID(devices []appstoreconnect.Device, udid string) *appstoreconnect.Device {
+	normalized := NormalizeUDID(udid)
+	for i := range devices {
+		if NormalizeUDID(devices[i].Attributes.UDID) == normalized {
+			return &devices[i]
+		}
+	}
+	return nil
+}
+
+// UniqueDevices drops the devices registered with an equivalent UDID of a preceding device of the same platform,
+// so that a physical device registered twice (in different UDID formats) is included in the profiles only once.
+// The dropped devices are returned as duplicates.
+func UniqueDevices(devices []appstoreconnect.Device) (unique []appstoreconnect.Device, duplicates []appstoreconnect.Device) {
+	seen := map[string]bool{}
+	for _, device := range devices {
+		key := string(device.Attributes.Platform) + "/" + NormalizeUDID(device.Attributes.UDID)
+		if seen[key] {
+			duplicates = append(duplicates, device)
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, device)
+	}
+	return unique, duplicates
+}
+
+// DeviceName generates the name of a Bitrise test device to register, with layout: Bitrise test device - <title> (<device type>)
+func DeviceName(title, deviceType string) string {
+	name := "Bitrise test device"
+	if title = strings.TrimSpace(title); title != "" {
+		name += " - " + title
+	}
+	if deviceType = strings.TrimSpace(deviceType); deviceType != "" {
+		name += fmt.Sprintf(" (%s)", deviceType)
+	}
+	return name
+}
+
+// DeviceDescription returns a readable summary of the device's attributes, including its class, model and source
+func DeviceDescription(device appstoreconnect.Device, source DeviceSource) string {
+	model := device.Attributes.Model
+	if model == "" {
+		model = "unknown model"
+	}
+
+	return fmt.Sprintf("%s, %s, %s, UDID (%s), ID (%s), source: %s", device.Attributes.Name, device.Attributes.DeviceClass, model, device.Attributes.UDID, device.ID, source)
+}
+
+// DevicesByPlatform groups the devices by their platform, devices are ordered by their class and name within a group
+func DevicesByPlatform(devices []appstoreconnect.Device) map[appstoreconnect.BundleIDPlatform][]appstoreconnect.Device {
+	devicesByPlatform := map[appstoreconnect.BundleIDPlatform][]appstoreconnect.Device{}
+	for _, device := range devices {
+		devicesByPlatform[device.Attributes.Platform] = append(devicesByPlatform[device.Attributes.Platform], device)
+	}
+
+	for _, platformDevices := range devicesByPlatform {
+		sort.SliceStable(platformDevices, func(i, j int) bool {
+			if platformDevices[i].Attributes.DeviceClass != platformDevices[j].Attributes.DeviceClass {
+				return platformDevices[i].Attributes.DeviceClass < platformDevices[j].Attributes.DeviceClass
+			}
+			return platformDevices[i].Attributes.Name < platformDevices[j].Attributes.Name
 		})
-		if err != nil {
-			return nil, err
+	}
+
+	return devicesByPlatform
+}
+
+// UDIDFormat is the format of a device identifier, telling which platforms the device can belong to
+type UDIDFormat string
+
+// UDIDFormats ...
+const (
+	// LegacyUDID is the 40 character hexadecimal UDID of the iOS, tvOS and watchOS devices before the A12 chip
+	LegacyUDID UDIDFormat = "legacy UDID"
+	// ProvisioningUDID is the 8-16 character hexadecimal UDID (like 00008030-001A35E11A88802E) of the newer iOS devices and the Apple silicon Macs
+	ProvisioningUDID UDIDFormat = "provisioning UDID"
+	// HardwareUUID is the 8-4-4-4-12 character hexadecimal Hardware UUID the Intel Macs are registered with
+	HardwareUUID UDIDFormat = "hardware UUID"
+	// UnknownUDIDFormat is any other identifier, the Developer Portal rejects it
+	UnknownUDIDFormat UDIDFormat = "unknown UDID format"
+)
+
+var (
+	legacyUDIDPattern       = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+	provisioningUDIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{16}$`)
+	hardwareUUIDPattern     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// DetectUDIDFormat returns the format of the device identifier
+func DetectUDIDFormat(udid string) UDIDFormat {
+	udid = strings.TrimSpace(udid)
+	switch {
+	case legacyUDIDPattern.MatchString(udid):
+		return LegacyUDID
+	case provisioningUDIDPattern.MatchString(udid):
+		return ProvisioningUDID
+	case hardwareUUIDPattern.MatchString(udid):
+		return HardwareUUID
+	default:
+		return UnknownUDIDFormat
+	}
+}
+
+// testDeviceTypePlatform returns the device platform of the Bitrise test device type (like ios, watchos or macos),
+// or an empty platform if the type is unknown.
+func testDeviceTypePlatform(deviceType string) appstoreconnect.DevicePlatform {
+	deviceType = strings.ToLower(strings.TrimSpace(deviceType))
+	switch {
+	case deviceType == "":
+		return ""
+	case strings.Contains(deviceType, "mac"), strings.Contains(deviceType, "osx"):
+		return appstoreconnect.MacOSDevice
+	case strings.Contains(deviceType, "ios"), strings.Contains(deviceType, "iphone"), strings.Contains(deviceType, "ipad"), strings.Contains(deviceType, "ipod"),
+		strings.Contains(deviceType, "watch"), strings.Contains(deviceType, "tv"), strings.Contains(deviceType, "vision"):
+		return appstoreconnect.IOSDevice
+	default:
+		return ""
+	}
+}
+
+// TestDeviceSkipReason returns why the Bitrise test device can not be registered for the device platform,
+// or an empty reason if it can. The device type of the test device decides the platform if it is known, its UDID format otherwise:
+// the iOS devices are registered by their legacy or provisioning UDID, the Macs by their provisioning UDID (Apple silicon) or Hardware UUID (Intel).
+// A provisioning UDID of an unknown device type is registered as an iOS device only, as most of the test devices are iOS devices.
+func TestDeviceSkipReason(udid, deviceType string, platform appstoreconnect.DevicePlatform) string {
+	format := DetectUDIDFormat(udid)
+	if format == UnknownUDIDFormat {
+		return "invalid UDID, neither a device UDID nor a Mac Hardware UUID"
+	}
+
+	typePlatform := testDeviceTypePlatform(deviceType)
+	if typePlatform != "" && typePlatform != platform {
+		return fmt.Sprintf("%s device (device type: %s), not a %s device", typePlatform, deviceType, platform)
+	}
+
+	if platform == appstoreconnect.MacOSDevice {
+		if format == LegacyUDID {
+			return "the legacy UDID identifies an iOS device, not a Mac"
 		}
+		if format == ProvisioningUDID && typePlatform == "" {
+			return "the provisioning UDID of an unknown device type is registered as an iOS device"
+		}
+		return ""
+	}
+
+	if format == HardwareUUID {
+		return "the Hardware UUID identifies a Mac, not an iOS device"
+	}
+	return ""
+}
 
-		devices = append(devices, response.Data...)
+// DeviceRegistrationSummary collects the outcome of the Bitrise test device registrations
+type DeviceRegistrationSummary struct {
+	Registered        []string
+	AlreadyRegistered []string
+	// Rejected are the devices the Developer Portal refused to register, with the error
+	Rejected []string
+	// Skipped are the devices invalid for the platform, with the reason
+	Skipped []string
+}
 
-		nextPageURL = response.Links.Next
-		if nextPageURL == "" {
-			return devices, nil
+// Lines returns the readable summary of the registrations, a line per outcome with devices
+func (s DeviceRegistrationSummary) Lines() []string {
+	var lines []string
+	for _, group := range []struct {
+		title   string
+		devices []string
+	}{
+		{"newly registered", s.Registered},
+		{"already registered", s.AlreadyRegistered},
+		{"rejected by Apple", s.Rejected},
+		{"skipped", s.Skipped},
+	} {
+		if len(group.devices) > 0 {
+			lines = append(lines, fmt.Sprintf("%s (%d): %s", group.title, len(group.devices), strings.Join(group.devices, ", ")))
 		}
 	}
+	return lines
 }
@@ -0,0 +1,187 @@
+package autoprovision
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+func TestDeviceName(t *testing.T) {
+	tests := []struct {
+		name       string
+		title      string
+		deviceType string
+		want       string
+	}{
+		{name: "no title and type", want: "Bitrise test device"},
+		{name: "title", title: "John's iPhone", want: "Bitrise test device - John's iPhone"},
+		{name: "title and type", title: "John's iPhone", deviceType: "ios", want: "Bitrise test device - John's iPhone (ios)"},
+		{name: "whitespace only", title: " ", deviceType: " ", want: "Bitrise test device"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DeviceName(tt.title, tt.deviceType); got != tt.want {
+				t.Errorf("DeviceName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDevicesByPlatform(t *testing.T) {
+	newDevice := func(id string, platform appstoreconnect.BundleIDPlatform, class appstoreconnect.DeviceClass, name string) appstoreconnect.Device {
+		return appstoreconnect.Device{
+			ID: id,
+			Attributes: appstoreconnect.DeviceAttributes{
+				Name:        name,
+				Platform:    platform,
+				DeviceClass: class,
+			},
+		}
+	}
+
+	iphoneB := newDevice("1", appstoreconnect.IOS, appstoreconnect.Iphone, "B")
+	ipad := newDevice("2", appstoreconnect.IOS, appstoreconnect.Ipad, "A")
+	iphoneA := newDevice("3", appstoreconnect.IOS, appstoreconnect.Iphone, "A")
+	mac := newDevice("4", appstoreconnect.MacOS, appstoreconnect.Mac, "Mac")
+
+	got := DevicesByPlatform([]appstoreconnect.Device{iphoneB, ipad, iphoneA, mac})
+	want := map[appstoreconnect.BundleIDPlatform][]appstoreconnect.Device{
+		appstoreconnect.IOS:   {ipad, iphoneA, iphoneB},
+		appstoreconnect.MacOS: {mac},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DevicesByPlatform() = %v, want %v", got, want)
+	}
+}
+
+func TestFindDeviceByUDID(t *testing.T) {
+	devices := []appstoreconnect.Device{
+		{ID: "1", Attributes: appstoreconnect.DeviceAttributes{UDID: "00008030-001A35E11A88802E"}},
+		{ID: "2", Attributes: appstoreconnect.DeviceAttributes{UDID: "b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1"}},
+	}
+
+	tests := []struct {
+		name   string
+		udid   string
+		wantID string
+	}{
+		{name: "exact match", udid: "00008030-001A35E11A88802E", wantID: "1"},
+		{name: "without hyphen", udid: "00008030001A35E11A88802E", wantID: "1"},
+		{name: "lowercase", udid: "00008030-001a35e11a88802e", wantID: "1"},
+		{name: "uppercase legacy UDID", udid: " B2C3D4E5F6A7B8C9D0E1F2A3B4C5D6E7F8A9B0C1 ", wantID: "2"},
+		{name: "not registered", udid: "00008030-001A35E11A88802F"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FindDeviceByUDID(devices, tt.udid)
+			if tt.wantID == "" {
+				if got != nil {
+					t.Errorf("FindDeviceByUDID() = %v, want nil", got.ID)
+				}
+				return
+			}
+			if got == nil || got.ID != tt.wantID {
+				t.Errorf("FindDeviceByUDID() = %v, want device %s", got, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestUniqueDevices(t *testing.T) {
+	newDevice := func(id, udid string, platform appstoreconnect.BundleIDPlatform) appstoreconnect.Device {
+		return appstoreconnect.Device{ID: id, Attributes: appstoreconnect.DeviceAttributes{UDID: udid, Platform: platform}}
+	}
+	devices := []appstoreconnect.Device{
+		newDevice("1", "00008030-001A35E11A88802E", appstoreconnect.IOS),
+		newDevice("2", "00008030001a35e11a88802e", appstoreconnect.IOS),
+		newDevice("3", "00008030-001A35E11A88802E", appstoreconnect.MacOS),
+		newDevice("4", "b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1", appstoreconnect.IOS),
+	}
+
+	unique, duplicates := UniqueDevices(devices)
+	if want := []appstoreconnect.Device{devices[0], devices[2], devices[3]}; !reflect.DeepEqual(unique, want) {
+		t.Errorf("UniqueDevices() unique = %v, want %v", unique, want)
+	}
+	if want := []appstoreconnect.Device{devices[1]}; !reflect.DeepEqual(duplicates, want) {
+		t.Errorf("UniqueDevices() duplicates = %v, want %v", duplicates, want)
+	}
+}
+
+func TestDetectUDIDFormat(t *testing.T) {
+	tests := []struct {
+		udid string
+		want UDIDFormat
+	}{
+		{udid: "0123456789abcdef0123456789ABCDEF01234567", want: LegacyUDID},
+		{udid: "00008030-001A35E11A88802E", want: ProvisioningUDID},
+		{udid: "00008030001a35e11a88802e", want: ProvisioningUDID},
+		{udid: " 00008103-000A1B2C3D4E5F60 ", want: ProvisioningUDID},
+		{udid: "5A1B2C3D-4E5F-6071-8293-A4B5C6D7E8F9", want: HardwareUUID},
+		{udid: "", want: UnknownUDIDFormat},
+		{udid: "0123456789abcdef0123456789abcdef0123456", want: UnknownUDIDFormat},
+		{udid: "00008030-001A35E11A88802G", want: UnknownUDIDFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.udid, func(t *testing.T) {
+			if got := DetectUDIDFormat(tt.udid); got != tt.want {
+				t.Errorf("DetectUDIDFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTestDeviceSkipReason(t *testing.T) {
+	const (
+		legacyUDID       = "0123456789abcdef0123456789abcdef01234567"
+		provisioningUDID = "00008030-001A35E11A88802E"
+		hardwareUUID     = "5A1B2C3D-4E5F-6071-8293-A4B5C6D7E8F9"
+	)
+	tests := []struct {
+		name       string
+		udid       string
+		deviceType string
+		platform   appstoreconnect.DevicePlatform
+		wantSkip   bool
+	}{
+		{name: "iPhone legacy UDID", udid: legacyUDID, deviceType: "ios", platform: appstoreconnect.IOSDevice},
+		{name: "iPhone provisioning UDID", udid: provisioningUDID, deviceType: "ios", platform: appstoreconnect.IOSDevice},
+		{name: "Apple TV", udid: legacyUDID, deviceType: "tvOS", platform: appstoreconnect.IOSDevice},
+		{name: "unknown type provisioning UDID for iOS", udid: provisioningUDID, platform: appstoreconnect.IOSDevice},
+		{name: "invalid UDID", udid: "not-a-udid", deviceType: "ios", platform: appstoreconnect.IOSDevice, wantSkip: true},
+		{name: "Intel Mac for iOS", udid: hardwareUUID, platform: appstoreconnect.IOSDevice, wantSkip: true},
+		{name: "Mac type for iOS", udid: provisioningUDID, deviceType: "macOS", platform: appstoreconnect.IOSDevice, wantSkip: true},
+		{name: "Intel Mac", udid: hardwareUUID, platform: appstoreconnect.MacOSDevice},
+		{name: "Apple silicon Mac", udid: provisioningUDID, deviceType: "macos", platform: appstoreconnect.MacOSDevice},
+		{name: "iPhone for macOS", udid: provisioningUDID, deviceType: "ios", platform: appstoreconnect.MacOSDevice, wantSkip: true},
+		{name: "legacy UDID for macOS", udid: legacyUDID, platform: appstoreconnect.MacOSDevice, wantSkip: true},
+		{name: "unknown type provisioning UDID for macOS", udid: provisioningUDID, platform: appstoreconnect.MacOSDevice, wantSkip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := TestDeviceSkipReason(tt.udid, tt.deviceType, tt.platform)
+			if (reason != "") != tt.wantSkip {
+				t.Errorf("TestDeviceSkipReason() = %q, want skip: %v", reason, tt.wantSkip)
+			}
+		})
+	}
+}
+
+func TestDeviceRegistrationSummary_Lines(t *testing.T) {
+	summary := DeviceRegistrationSummary{
+		Registered:        []string{"udid-1", "udid-2"},
+		AlreadyRegistered: []string{"udid-3"},
+		Skipped:           []string{"udid-4 (invalid UDID)"},
+	}
+	want := []string{
+		"newly registered (2): udid-1, udid-2",
+		"already registered (1): udid-3",
+		"skipped (1): udid-4 (invalid UDID)",
+	}
+	if got := summary.Lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Lines() = %v, want %v", got, want)
+	}
+	if got := (DeviceRegistrationSummary{}).Lines(); got != nil {
+		t.Errorf("Lines() = %v, want nil", got)
+	}
+}
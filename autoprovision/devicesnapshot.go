@@ -0,0 +1,115 @@
+package autoprovision
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+const deviceSnapshotVersion = 1
+
+// DeviceSnapshot holds the devices registered on the Developer Portal of a team, after the test devices were registered.
+// While the snapshot is not expired and the test devices did not change, the runs reuse it
+// instead of listing the devices, which spares a lot of API calls on accounts with thousands of devices.
+type DeviceSnapshot struct {
+	Version  int                            `json:"version"`
+	TeamID   string                         `json:"team_id"`
+	Platform appstoreconnect.DevicePlatform `json:"platform"`
+	// TestDevicesHash identifies the test devices, which were registered when the snapshot was taken, see TestDevicesHash
+	TestDevicesHash string                   `json:"test_devices_hash"`
+	CreatedAt       time.Time                `json:"created_at"`
+	Devices         []appstoreconnect.Device `json:"devices"`
+}
+
+// DeviceSnapshotPath returns the path of the team's device snapshot in the snapshot directory.
+func DeviceSnapshotPath(dir, teamID string, platform appstoreconnect.DevicePlatform) string {
+	return filepath.Join(dir, fmt.Sprintf("devices_%s_%s.json", teamID, strings.ToLower(string(platform))))
+}
+
+// TestDevicesHash returns a hash of the test device UDIDs, independent of their order.
+func TestDevicesHash(udids []string) string {
+	sorted := append([]string{}, udids...)
+	for i, udid := range sorted {
+		sorted[i] = NormalizeUDID(udid)
+	}
+	sort.Strings(sorted)
+
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadDeviceSnapshot reads the device snapshot of the team.
+// It returns nil if the snapshot does not exist or it can not be reused:
+// it belongs to another team or platform, the test devices changed or it is older than the ttl.
+func ReadDeviceSnapshot(pth, teamID string, platform appstoreconnect.DevicePlatform, testDevicesHash string, ttl time.Duration, now time.Time) (*DeviceSnapshot, error) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read device snapshot (%s): %s", pth, err)
+	}
+
+	var snapshot DeviceSnapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		log.Warnf("Ignoring invalid device snapshot (%s): %s", pth, err)
+		return nil, nil
+	}
+
+	if reason := snapshot.staleReason(teamID, platform, testDevicesHash, ttl, now); reason != "" {
+		log.Printf("Ignoring device snapshot (%s): %s", pth, reason)
+		return nil, nil
+	}
+	return &snapshot, nil
+}
+
+func (s DeviceSnapshot) staleReason(teamID string, platform appstoreconnect.DevicePlatform, testDevicesHash string, ttl time.Duration, now time.Time) string {
+	if s.Version != deviceSnapshotVersion {
+		return fmt.Sprintf("unsupported version (%d)", s.Version)
+	}
+	if s.TeamID != teamID || s.Platform != platform {
+		return "it belongs to another team or platform"
+	}
+	if s.TestDevicesHash != testDevicesHash {
+		return "the test devices changed"
+	}
+	if now.Sub(s.CreatedAt) > ttl {
+		return fmt.Sprintf("it is older than %s", ttl)
+	}
+	return ""
+}
+
+// WriteDeviceSnapshot saves the devices of the team as a snapshot to the given path.
+func WriteDeviceSnapshot(pth, teamID string, platform appstoreconnect.DevicePlatform, testDevicesHash string, devices []appstoreconnect.Device, now time.Time) error {
+	snapshot := DeviceSnapshot{
+		Version:         deviceSnapshotVersion,
+		TeamID:          teamID,
+		Platform:        platform,
+		TestDevicesHash: testDevicesHash,
+		CreatedAt:       now,
+		Devices:         devices,
+	}
+
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to serialize device snapshot: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pth), 0700); err != nil {
+		return fmt.Errorf("failed to create device snapshot directory: %s", err)
+	}
+	if err := writeFileAtomic(pth, content); err != nil {
+		return fmt.Errorf("failed to write device snapshot (%s): %s", pth, err)
+	}
+	return nil
+}
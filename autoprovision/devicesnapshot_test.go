@@ -0,0 +1,59 @@
+package autoprovision
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestDevicesHash(t *testing.T) {
+	require.Equal(t, TestDevicesHash([]string{"a", "B"}), TestDevicesHash([]string{"b", " a"}), "order and case independent")
+	require.Equal(t, TestDevicesHash([]string{"00008030-001A35E11A88802E"}), TestDevicesHash([]string{"00008030001a35e11a88802e"}), "UDID format independent")
+	require.NotEqual(t, TestDevicesHash([]string{"a"}), TestDevicesHash([]string{"a", "b"}))
+}
+
+func TestReadDeviceSnapshot(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	devices := []appstoreconnect.Device{{ID: "1", Attributes: appstoreconnect.DeviceAttributes{UDID: "udid"}}}
+	hash := TestDevicesHash([]string{"udid"})
+
+	dir, err := ioutil.TempDir("", "devicesnapshot")
+	require.NoError(t, err)
+	pth := DeviceSnapshotPath(filepath.Join(dir, "snapshots"), "TEAM", appstoreconnect.IOSDevice)
+	require.NoError(t, WriteDeviceSnapshot(pth, "TEAM", appstoreconnect.IOSDevice, hash, devices, now))
+
+	invalidPth := filepath.Join(dir, "invalid.json")
+	require.NoError(t, ioutil.WriteFile(invalidPth, []byte("{"), 0600))
+
+	tests := []struct {
+		name        string
+		pth         string
+		teamID      string
+		hash        string
+		now         time.Time
+		wantDevices bool
+	}{
+		{name: "missing snapshot", pth: filepath.Join(dir, "missing.json"), teamID: "TEAM", hash: hash, now: now},
+		{name: "invalid snapshot", pth: invalidPth, teamID: "TEAM", hash: hash, now: now},
+		{name: "snapshot of another team", pth: pth, teamID: "OTHER", hash: hash, now: now},
+		{name: "test devices changed", pth: pth, teamID: "TEAM", hash: TestDevicesHash([]string{"udid", "new-udid"}), now: now},
+		{name: "expired snapshot", pth: pth, teamID: "TEAM", hash: hash, now: now.Add(25 * time.Hour)},
+		{name: "reused snapshot", pth: pth, teamID: "TEAM", hash: hash, now: now.Add(time.Hour), wantDevices: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadDeviceSnapshot(tt.pth, tt.teamID, appstoreconnect.IOSDevice, tt.hash, 24*time.Hour, tt.now)
+			require.NoError(t, err)
+			if !tt.wantDevices {
+				require.Nil(t, got)
+				return
+			}
+			require.NotNil(t, got)
+			require.Equal(t, devices, got.Devices)
+		})
+	}
+}
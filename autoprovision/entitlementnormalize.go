@@ -0,0 +1,77 @@
+package autoprovision
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+// listEntitlementKeys are the entitlements holding a list of values,
+// which might be written as a single string in the project or in the profile.
+var listEntitlementKeys = map[string]bool{
+	"com.apple.developer.icloud-container-identifiers":                       true,
+	"com.apple.developer.icloud-container-development-container-identifiers": true,
+	"com.apple.developer.icloud-services":                                    true,
+	"com.apple.developer.ubiquity-container-identifiers":                     true,
+	"com.apple.developer.associated-domains":                                 true,
+	"com.apple.developer.in-app-payments":                                    true,
+	"com.apple.developer.pass-type-identifiers":                              true,
+	"com.apple.developer.healthkit.access":                                   true,
+	"com.apple.developer.nfc.readersession.formats":                          true,
+	"com.apple.security.application-groups":                                  true,
+	"keychain-access-groups":                                                 true,
+}
+
+// normalizeEntitlementValue returns the canonical representation of an entitlement value,
+// so that equivalent values compare equal:
+// - integers 0 and 1 and the strings YES, NO, true and false become booleans
+// - a single string of a list entitlement becomes a one element list
+// - string lists become []interface{} lists, as parsed from plists
+func normalizeEntitlementValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if listEntitlementKeys[key] {
+			return []interface{}{v}
+		}
+		switch strings.ToLower(v) {
+		case "yes", "true":
+			return true
+		case "no", "false":
+			return false
+		}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		switch reflect.ValueOf(v).Convert(reflect.TypeOf(int64(0))).Int() {
+		case 0:
+			return false
+		case 1:
+			return true
+		}
+	case []string:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			list = append(list, item)
+		}
+		return list
+	}
+	return value
+}
+
+// NormalizeEntitlements returns a copy of the entitlements with their values in a canonical representation,
+// see normalizeEntitlementValue.
+func NormalizeEntitlements(entitlements serialized.Object) serialized.Object {
+	if entitlements == nil {
+		return nil
+	}
+
+	normalized := serialized.Object{}
+	for key, value := range entitlements {
+		normalized[key] = normalizeEntitlementValue(key, value)
+	}
+	return normalized
+}
+
+// entitlementValuesEqual reports whether the values of the entitlement are equivalent
+func entitlementValuesEqual(key string, a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeEntitlementValue(key, a), normalizeEntitlementValue(key, b))
+}
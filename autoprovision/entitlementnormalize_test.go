@@ -0,0 +1,73 @@
+package autoprovision
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+func Test_normalizeEntitlementValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		want  interface{}
+	}{
+		{name: "bool", key: "get-task-allow", value: true, want: true},
+		{name: "integer 1", key: "get-task-allow", value: uint64(1), want: true},
+		{name: "integer 0", key: "get-task-allow", value: 0, want: false},
+		{name: "other integer", key: "com.apple.developer.example", value: 2, want: 2},
+		{name: "YES string", key: "com.apple.developer.siri", value: "YES", want: true},
+		{name: "false string", key: "com.apple.developer.siri", value: "false", want: false},
+		{name: "string", key: "aps-environment", value: "development", want: "development"},
+		{name: "single string of a list entitlement", key: "com.apple.developer.icloud-container-identifiers", value: "iCloud.io.bitrise.app", want: []interface{}{"iCloud.io.bitrise.app"}},
+		{name: "string list", key: "com.apple.security.application-groups", value: []string{"group.io.bitrise.app"}, want: []interface{}{"group.io.bitrise.app"}},
+		{name: "list", key: "com.apple.security.application-groups", value: []interface{}{"group.io.bitrise.app"}, want: []interface{}{"group.io.bitrise.app"}},
+		{name: "dictionary", key: "com.apple.developer.example", value: map[string]interface{}{"key": "value"}, want: map[string]interface{}{"key": "value"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeEntitlementValue(tt.key, tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeEntitlementValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_entitlementValuesEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		a    interface{}
+		b    interface{}
+		want bool
+	}{
+		{name: "bool and integer", key: "get-task-allow", a: true, b: uint64(1), want: true},
+		{name: "bool and YES", key: "com.apple.developer.siri", a: true, b: "YES", want: true},
+		{name: "different bools", key: "get-task-allow", a: true, b: 0, want: false},
+		{name: "single string and one element list", key: "com.apple.developer.icloud-services", a: "CloudKit", b: []interface{}{"CloudKit"}, want: true},
+		{name: "single string and longer list", key: "com.apple.developer.icloud-services", a: "CloudKit", b: []interface{}{"CloudKit", "CloudDocuments"}, want: false},
+		{name: "different strings", key: "aps-environment", a: "development", b: "production", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entitlementValuesEqual(tt.key, tt.a, tt.b); got != tt.want {
+				t.Errorf("entitlementValuesEqual() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findMissingContainers_normalized(t *testing.T) {
+	projectEnts := NormalizeEntitlements(serialized.Object{"com.apple.developer.icloud-container-identifiers": "iCloud.io.bitrise.app"})
+	profileEnts := NormalizeEntitlements(serialized.Object{"com.apple.developer.icloud-container-identifiers": []interface{}{"iCloud.io.bitrise.app"}})
+
+	missing, err := findMissingContainers(projectEnts, profileEnts)
+	if err != nil {
+		t.Fatalf("findMissingContainers() unexpected error = %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("findMissingContainers() = %v, want no missing containers", missing)
+	}
+}
@@ -2,7 +2,6 @@ package autoprovision
 
 import (
 	"errors"
-	"fmt"
 
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/sliceutil"
@@ -106,7 +105,7 @@ func (e Entitlement) AppearsOnDeveloperPortal() bool {
 	entKey := serialized.Object(e).Keys()[0]
 
 	capType, ok := appstoreconnect.ServiceTypeByKey[entKey]
-	return ok && capType != appstoreconnect.Ignored && capType != appstoreconnect.ProfileAttachedEntitlement
+	return ok && capType != appstoreconnect.Ignored && capType != appstoreconnect.ProfileAttachedEntitlement && capType != appstoreconnect.ParentApplicationIdentifiers
 }
 
 // Equal ...
@@ -146,7 +145,7 @@ func (e Entitlement) iCloudServices() (iCloudDocuments, iCloudKit, keyValueStora
 	}
 	keyValueStorage = v != ""
 
-	iCloudServices, err := serialized.Object(e).StringSlice("com.apple.developer.icloud-services")
+	iCloudServices, err := NormalizeEntitlements(serialized.Object(e)).StringSlice("com.apple.developer.icloud-services")
 	if err != nil && !serialized.IsKeyNotFoundError(err) {
 		return false, false, false, err
 	}
@@ -169,7 +168,7 @@ func (e Entitlement) ICloudContainers() ([]string, error) {
 		return nil, nil
 	}
 
-	containers, err := serialized.Object(e).StringSlice(iCloudIdentifiersEntitlementKey)
+	containers, err := NormalizeEntitlements(serialized.Object(e)).StringSlice(iCloudIdentifiersEntitlementKey)
 	if err != nil && !serialized.IsKeyNotFoundError(err) {
 		return nil, err
 	}
@@ -190,12 +189,6 @@ func (e Entitlement) Capability() (*appstoreconnect.BundleIDCapability, error) {
 		appstoreconnect.SignInWithApple: "Sign In with Apple",
 	}
 
-	// List of capabilities that the API does not support and prevent autoprovisioning
-	capabilitiesError := map[appstoreconnect.CapabilityType]string{
-		appstoreconnect.OnDemandInstallCapable:       "On Demand Install Capable (App Clips)",
-		appstoreconnect.ParentApplicationIdentifiers: "Parent Bundle ID",
-	}
-
 	entKey := serialized.Object(e).Keys()[0]
 
 	capType, ok := appstoreconnect.ServiceTypeByKey[entKey]
@@ -203,10 +196,34 @@ func (e Entitlement) Capability() (*appstoreconnect.BundleIDCapability, error) {
 		return nil, errors.New("unknown entitlement key: " + entKey)
 	}
 
-	if capType == appstoreconnect.Ignored {
+	if capType == appstoreconnect.Ignored || capType == appstoreconnect.ProfileAttachedEntitlement {
+		return nil, nil
+	}
+
+	// The Developer Portal derives the parent app of the App Clip from its app ID, it is checked in the profiles (see checkProfileEntitlements)
+	if capType == appstoreconnect.ParentApplicationIdentifiers {
 		return nil, nil
 	}
 
+	capSetts, err := capabilitySettings(capType, entKey, serialized.Object(e))
+	if err != nil {
+		return nil, err
+	}
+
+	if capName, contains := capabilitiesWarn[capType]; contains {
+		log.Warnf("This will enable the \"%s\" capability but details will have to be configured manually using the Apple Developer Portal", capName)
+	}
+
+	return &appstoreconnect.BundleIDCapability{
+		Attributes: appstoreconnect.BundleIDCapabilityAttributes{
+			CapabilityType: capType,
+			Settings:       capSetts,
+		},
+	}, nil
+}
+
+// capabilitySettings returns the settings of the capability type, required by the entitlement
+func capabilitySettings(capType appstoreconnect.CapabilityType, entKey string, entitlements serialized.Object) ([]appstoreconnect.CapabilitySetting, error) {
 	capSetts := []appstoreconnect.CapabilitySetting{}
 	if capType == appstoreconnect.ICloud {
 		capSett := appstoreconnect.CapabilitySetting{
@@ -219,7 +236,7 @@ func (e Entitlement) Capability() (*appstoreconnect.BundleIDCapability, error) {
 		}
 		capSetts = append(capSetts, capSett)
 	} else if capType == appstoreconnect.DataProtection {
-		entVal, err := serialized.Object(e).String(entKey)
+		entVal, err := entitlements.String(entKey)
 		if err != nil {
 			return nil, errors.New("no entitlements value for key: " + entKey)
 		}
@@ -249,19 +266,5 @@ func (e Entitlement) Capability() (*appstoreconnect.BundleIDCapability, error) {
 		}
 		capSetts = append(capSetts, capSett)
 	}
-
-	if capName, contains := capabilitiesWarn[capType]; contains {
-		log.Warnf("This will enable the \"%s\" capability but details will have to be configured manually using the Apple Developer Portal", capName)
-	}
-
-	if capName, contains := capabilitiesError[capType]; contains {
-		return nil, fmt.Errorf("step does not support creating an application identifier using the \"%s\" capability, please add your Application Identifier manually using the Apple Developer Portal", capName)
-	}
-
-	return &appstoreconnect.BundleIDCapability{
-		Attributes: appstoreconnect.BundleIDCapabilityAttributes{
-			CapabilityType: capType,
-			Settings:       capSetts,
-		},
-	}, nil
+	return capSetts, nil
 }
@@ -146,6 +146,17 @@ func TestCanGenerateProfileWithEntitlements(t *testing.T) {
 			wantEntitlement: "com.apple.developer.contacts.notes",
 			wantBundleID:    "com.bundleid2",
 		},
+		{
+			name: "contains entitlement requiring approval",
+			entitlementsByBundleID: map[string]serialized.Object{
+				"com.bundleid": map[string]interface{}{
+					"com.apple.developer.usernotifications.critical-alerts": true,
+				},
+			},
+			wantOk:          false,
+			wantEntitlement: "com.apple.developer.usernotifications.critical-alerts",
+			wantBundleID:    "com.bundleid",
+		},
 		{
 			name: "all entitlements supported",
 			entitlementsByBundleID: map[string]serialized.Object{
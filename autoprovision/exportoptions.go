@@ -0,0 +1,121 @@
+package autoprovision
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"howett.net/plist"
+)
+
+// ExportCodeSignSettings holds the code signing values of an export options plist, managed by the Step
+type ExportCodeSignSettings struct {
+	Distribution       DistributionType
+	TeamID             string
+	SigningCertificate string
+	// ProfilesByBundleID maps the bundle IDs to the provisioning profile names
+	ProfilesByBundleID map[string]string
+	// EnableBitcode is the ENABLE_BITCODE build setting of the main target, nil if it is not set
+	EnableBitcode *bool
+}
+
+// ExportBuildSettings are the build settings of the main target, which affect the export
+type ExportBuildSettings struct {
+	// EnableBitcode is nil if the ENABLE_BITCODE build setting is not set
+	EnableBitcode *bool
+	// CodeSignKeychain is the keychain set by the --keychain flag of OTHER_CODE_SIGN_FLAGS
+	CodeSignKeychain string
+}
+
+// NewExportBuildSettings reads the export related build settings
+func NewExportBuildSettings(settings serialized.Object) ExportBuildSettings {
+	var exportSettings ExportBuildSettings
+
+	if enableBitcode, err := settings.String("ENABLE_BITCODE"); err == nil && enableBitcode != "" {
+		enabled := strings.EqualFold(enableBitcode, "YES")
+		exportSettings.EnableBitcode = &enabled
+	}
+
+	if flags, err := settings.String("OTHER_CODE_SIGN_FLAGS"); err == nil {
+		fields := strings.Fields(flags)
+		for i, field := range fields {
+			if field == "--keychain" && i+1 < len(fields) {
+				exportSettings.CodeSignKeychain = strings.Trim(fields[i+1], `"'`)
+			} else if strings.HasPrefix(field, "--keychain=") {
+				exportSettings.CodeSignKeychain = strings.Trim(strings.TrimPrefix(field, "--keychain="), `"'`)
+			}
+		}
+	}
+
+	return exportSettings
+}
+
+// ExportBuildSettings returns the export related build settings of the main target
+func (p *ProjectHelper) ExportBuildSettings(config string) (ExportBuildSettings, error) {
+	settings, err := p.targetBuildSettings(p.MainTarget.Name, config)
+	if err != nil {
+		return ExportBuildSettings{}, fmt.Errorf("failed to fetch target (%s) settings: %s", p.MainTarget.Name, err)
+	}
+	return NewExportBuildSettings(settings), nil
+}
+
+// MergeExportOptions writes the code signing settings into the export options plist at the given path.
+// If the plist already exists, its other keys (like manageAppVersionAndBuildNumber or thinning) are preserved
+// and the provisioningProfiles entries of other bundle IDs are kept.
+func MergeExportOptions(pth string, settings ExportCodeSignSettings) error {
+	exportOptions := map[string]interface{}{}
+
+	content, err := ioutil.ReadFile(pth)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read export options (%s): %s", pth, err)
+	}
+	if len(content) > 0 {
+		if _, err := plist.Unmarshal(content, &exportOptions); err != nil {
+			return fmt.Errorf("failed to parse export options (%s): %s", pth, err)
+		}
+	}
+
+	mergeExportOptions(exportOptions, settings)
+
+	content, err = plist.MarshalIndent(exportOptions, plist.XMLFormat, "\t")
+	if err != nil {
+		return fmt.Errorf("failed to serialize export options: %s", err)
+	}
+
+	if err := ioutil.WriteFile(pth, content, 0600); err != nil {
+		return fmt.Errorf("failed to write export options (%s): %s", pth, err)
+	}
+	return nil
+}
+
+func mergeExportOptions(exportOptions map[string]interface{}, settings ExportCodeSignSettings) {
+	exportOptions["method"] = string(settings.Distribution)
+	exportOptions["signingStyle"] = "manual"
+	if settings.TeamID != "" {
+		exportOptions["teamID"] = settings.TeamID
+	}
+	if settings.SigningCertificate != "" {
+		exportOptions["signingCertificate"] = settings.SigningCertificate
+	}
+	if settings.EnableBitcode != nil {
+		// App Store exports upload the bitcode, the other exports recompile it
+		if settings.Distribution == AppStore {
+			exportOptions["uploadBitcode"] = *settings.EnableBitcode
+			delete(exportOptions, "compileBitcode")
+		} else {
+			exportOptions["compileBitcode"] = *settings.EnableBitcode
+			delete(exportOptions, "uploadBitcode")
+		}
+	}
+
+	profiles, ok := exportOptions["provisioningProfiles"].(map[string]interface{})
+	if !ok {
+		profiles = map[string]interface{}{}
+	}
+	for bundleID, profile := range settings.ProfilesByBundleID {
+		profiles[bundleID] = profile
+	}
+	exportOptions["provisioningProfiles"] = profiles
+}
@@ -0,0 +1,178 @@
+package autoprovision
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+)
+
+const existingExportOptions = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>manageAppVersionAndBuildNumber</key>
+	<false/>
+	<key>method</key>
+	<string>development</string>
+	<key>provisioningProfiles</key>
+	<dict>
+		<key>io.bitrise.app</key>
+		<string>Old profile</string>
+		<key>io.bitrise.app.watch</key>
+		<string>Watch profile</string>
+	</dict>
+	<key>thinning</key>
+	<string>&lt;thin-for-all-variants&gt;</string>
+</dict>
+</plist>
+`
+
+func TestMergeExportOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export-options")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+
+	settings := ExportCodeSignSettings{
+		Distribution:       AppStore,
+		TeamID:             "72SA8V3WYL",
+		SigningCertificate: "iPhone Distribution: Bitrise Bot (72SA8V3WYL)",
+		ProfilesByBundleID: map[string]string{"io.bitrise.app": "Bitrise iOS app-store - (io.bitrise.app)"},
+	}
+
+	tests := []struct {
+		name     string
+		existing string
+		want     map[string]interface{}
+	}{
+		{
+			name:     "merges into existing plist",
+			existing: existingExportOptions,
+			want: map[string]interface{}{
+				"manageAppVersionAndBuildNumber": false,
+				"method":                         "app-store",
+				"signingStyle":                   "manual",
+				"teamID":                         "72SA8V3WYL",
+				"signingCertificate":             "iPhone Distribution: Bitrise Bot (72SA8V3WYL)",
+				"thinning":                       "<thin-for-all-variants>",
+				"provisioningProfiles": map[string]interface{}{
+					"io.bitrise.app":       "Bitrise iOS app-store - (io.bitrise.app)",
+					"io.bitrise.app.watch": "Watch profile",
+				},
+			},
+		},
+		{
+			name: "creates missing plist",
+			want: map[string]interface{}{
+				"method":             "app-store",
+				"signingStyle":       "manual",
+				"teamID":             "72SA8V3WYL",
+				"signingCertificate": "iPhone Distribution: Bitrise Bot (72SA8V3WYL)",
+				"provisioningProfiles": map[string]interface{}{
+					"io.bitrise.app": "Bitrise iOS app-store - (io.bitrise.app)",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(dir, tt.name+".plist")
+			if tt.existing != "" {
+				require.NoError(t, ioutil.WriteFile(pth, []byte(tt.existing), 0600))
+			}
+
+			require.NoError(t, MergeExportOptions(pth, settings))
+
+			content, err := ioutil.ReadFile(pth)
+			require.NoError(t, err)
+
+			got := map[string]interface{}{}
+			_, err = plist.Unmarshal(content, &got)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_mergeExportOptions_bitcode(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name          string
+		distribution  DistributionType
+		enableBitcode *bool
+		existing      map[string]interface{}
+		want          map[string]interface{}
+	}{
+		{
+			name:         "keeps the existing bitcode options if ENABLE_BITCODE is not set",
+			distribution: AdHoc,
+			existing:     map[string]interface{}{"compileBitcode": true},
+			want:         map[string]interface{}{"compileBitcode": true},
+		},
+		{
+			name:          "disables recompiling bitcode",
+			distribution:  AdHoc,
+			enableBitcode: &disabled,
+			existing:      map[string]interface{}{"compileBitcode": true},
+			want:          map[string]interface{}{"compileBitcode": false},
+		},
+		{
+			name:          "disables uploading bitcode for app-store",
+			distribution:  AppStore,
+			enableBitcode: &disabled,
+			existing:      map[string]interface{}{"compileBitcode": true},
+			want:          map[string]interface{}{"uploadBitcode": false},
+		},
+		{
+			name:          "enables recompiling bitcode",
+			distribution:  Development,
+			enableBitcode: &enabled,
+			existing:      map[string]interface{}{},
+			want:          map[string]interface{}{"compileBitcode": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mergeExportOptions(tt.existing, ExportCodeSignSettings{Distribution: tt.distribution, EnableBitcode: tt.enableBitcode})
+
+			for _, key := range []string{"method", "signingStyle", "provisioningProfiles"} {
+				delete(tt.existing, key)
+			}
+			require.Equal(t, tt.want, tt.existing)
+		})
+	}
+}
+
+func TestNewExportBuildSettings(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name     string
+		settings serialized.Object
+		want     ExportBuildSettings
+	}{
+		{name: "no export build settings", settings: serialized.Object{}},
+		{name: "bitcode enabled", settings: serialized.Object{"ENABLE_BITCODE": "YES"}, want: ExportBuildSettings{EnableBitcode: &enabled}},
+		{name: "bitcode disabled", settings: serialized.Object{"ENABLE_BITCODE": "NO"}, want: ExportBuildSettings{EnableBitcode: &disabled}},
+		{
+			name:     "codesign keychain",
+			settings: serialized.Object{"OTHER_CODE_SIGN_FLAGS": `--deep --keychain "/Users/vagrant/Library/Keychains/signing.keychain-db"`},
+			want:     ExportBuildSettings{CodeSignKeychain: "/Users/vagrant/Library/Keychains/signing.keychain-db"},
+		},
+		{
+			name:     "codesign keychain with equal sign",
+			settings: serialized.Object{"OTHER_CODE_SIGN_FLAGS": "--keychain=signing.keychain"},
+			want:     ExportBuildSettings{CodeSignKeychain: "signing.keychain"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, NewExportBuildSettings(tt.settings))
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+)
+
+// FrameworkTargets returns the dynamic framework targets, the main target (or its dependencies) depends on.
+// Embedded frameworks do not need provisioning profiles, but they are code signed when built.
+func (p *ProjectHelper) FrameworkTargets() []xcodeproj.Target {
+	var frameworks []xcodeproj.Target
+	seen := map[string]bool{}
+	for _, target := range p.MainTarget.DependentTargets() {
+		if ClassifyTarget(target, nil) != FrameworkTarget || seen[target.ID] {
+			continue
+		}
+		seen[target.ID] = true
+		frameworks = append(frameworks, target)
+	}
+	return frameworks
+}
+
+// FrameworkSigningAdjustment is an explicit code signing build setting of a framework target, which fails the archive
+// with the Bitrise managed code signing: a pinned provisioning profile (frameworks do not support profiles)
+// or a code signing identity not matching the installed certificate.
+type FrameworkSigningAdjustment struct {
+	Target        string
+	Configuration string
+	Key           string
+	Value         string
+	// NewValue is the adjusted value, empty if the setting is removed
+	NewValue string
+}
+
+func (a FrameworkSigningAdjustment) String() string {
+	if a.NewValue == "" {
+		return fmt.Sprintf("framework target (%s), configuration (%s): %s = %s, removed", a.Target, a.Configuration, a.Key, a.Value)
+	}
+	return fmt.Sprintf("framework target (%s), configuration (%s): %s = %s, changed to %s", a.Target, a.Configuration, a.Key, a.Value, a.NewValue)
+}
+
+// frameworkSigningAdjustments returns the adjustments of the framework target's explicit code signing build settings
+// in the given configuration, needed to sign it with the identity.
+func frameworkSigningAdjustments(target xcodeproj.Target, configuration, identity string) []FrameworkSigningAdjustment {
+	var adjustments []FrameworkSigningAdjustment
+	for _, buildConfiguration := range target.BuildConfigurationList.BuildConfigurations {
+		if buildConfiguration.Name != configuration {
+			continue
+		}
+
+		for _, key := range buildConfiguration.BuildSettings.Keys() {
+			value, err := buildConfiguration.BuildSettings.String(key)
+			if err != nil || value == "" {
+				continue
+			}
+
+			adjustment := FrameworkSigningAdjustment{
+				Target:        target.Name,
+				Configuration: buildConfiguration.Name,
+				Key:           key,
+				Value:         value,
+			}
+
+			if isPinnedProfileSettingKey(key) {
+				adjustments = append(adjustments, adjustment)
+			} else if settingKeyWithoutConditions(key) == "CODE_SIGN_IDENTITY" && !codesignIdentitesMatch(value, identity) {
+				adjustment.NewValue = identity
+				adjustments = append(adjustments, adjustment)
+			}
+		}
+	}
+
+	sort.SliceStable(adjustments, func(i, j int) bool { return adjustments[i].Key < adjustments[j].Key })
+	return adjustments
+}
+
+// AdjustFrameworkSigning updates the framework target's explicit code signing build settings in the given configuration,
+// so that it can be signed with the identity: pinned provisioning profiles are removed and mismatching identities are replaced.
+// It returns the adjustments made.
+func AdjustFrameworkSigning(target xcodeproj.Target, configuration, identity string) []FrameworkSigningAdjustment {
+	adjustments := frameworkSigningAdjustments(target, configuration, identity)
+
+	for _, buildConfiguration := range target.BuildConfigurationList.BuildConfigurations {
+		if buildConfiguration.Name != configuration {
+			continue
+		}
+
+		for _, adjustment := range adjustments {
+			if adjustment.NewValue == "" {
+				delete(buildConfiguration.BuildSettings, adjustment.Key)
+			} else {
+				buildConfiguration.BuildSettings[adjustment.Key] = adjustment.NewValue
+			}
+		}
+	}
+
+	return adjustments
+}
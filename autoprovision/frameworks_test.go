@@ -0,0 +1,76 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectHelper_FrameworkTargets(t *testing.T) {
+	framework := xcodeproj.Target{ID: "1", Name: "Framework", ProductType: frameworkProductType}
+	staticFramework := xcodeproj.Target{ID: "2", Name: "StaticFramework", ProductType: "com.apple.product-type.framework.static"}
+	extension := xcodeproj.Target{
+		ID:           "3",
+		Name:         "Extension",
+		ProductType:  "com.apple.product-type.app-extension",
+		Dependencies: []xcodeproj.TargetDependency{{Target: framework}},
+	}
+	app := xcodeproj.Target{
+		ID:          "4",
+		Name:        "App",
+		ProductType: "com.apple.product-type.application",
+		Dependencies: []xcodeproj.TargetDependency{
+			{Target: framework},
+			{Target: staticFramework},
+			{Target: extension},
+		},
+	}
+
+	p := ProjectHelper{MainTarget: app}
+	got := p.FrameworkTargets()
+
+	require.Equal(t, 1, len(got))
+	require.Equal(t, "Framework", got[0].Name)
+}
+
+func TestAdjustFrameworkSigning(t *testing.T) {
+	releaseSettings := serialized.Object{
+		"CODE_SIGN_IDENTITY":                            "iPhone Distribution: Other Company (ABCD)",
+		"CODE_SIGN_IDENTITY[sdk=iphoneos*]":             "iPhone Developer",
+		"CODE_SIGN_IDENTITY[sdk=macosx*]":               "",
+		"PROVISIONING_PROFILE_SPECIFIER[sdk=iphoneos*]": "Framework Profile",
+		"PRODUCT_BUNDLE_IDENTIFIER":                     "io.bitrise.framework",
+	}
+	debugSettings := serialized.Object{
+		"PROVISIONING_PROFILE": "c5be4123-1234-4f9d-9843-0d9be985a068",
+	}
+	framework := xcodeproj.Target{
+		Name:        "Framework",
+		ProductType: frameworkProductType,
+		BuildConfigurationList: xcodeproj.ConfigurationList{BuildConfigurations: []xcodeproj.BuildConfiguration{
+			{Name: "Debug", BuildSettings: debugSettings},
+			{Name: "Release", BuildSettings: releaseSettings},
+		}},
+	}
+
+	const identity = "iPhone Developer: Bitrise Bot (ABCD)"
+	got := AdjustFrameworkSigning(framework, "Release", identity)
+
+	want := []FrameworkSigningAdjustment{
+		{Target: "Framework", Configuration: "Release", Key: "CODE_SIGN_IDENTITY", Value: "iPhone Distribution: Other Company (ABCD)", NewValue: identity},
+		{Target: "Framework", Configuration: "Release", Key: "PROVISIONING_PROFILE_SPECIFIER[sdk=iphoneos*]", Value: "Framework Profile"},
+	}
+	require.Equal(t, want, got)
+
+	require.Equal(t, serialized.Object{
+		"CODE_SIGN_IDENTITY":                identity,
+		"CODE_SIGN_IDENTITY[sdk=iphoneos*]": "iPhone Developer",
+		"CODE_SIGN_IDENTITY[sdk=macosx*]":   "",
+		"PRODUCT_BUNDLE_IDENTIFIER":         "io.bitrise.framework",
+	}, releaseSettings)
+	require.Equal(t, serialized.Object{"PROVISIONING_PROFILE": "c5be4123-1234-4f9d-9843-0d9be985a068"}, debugSettings, "other configurations are not changed")
+
+	require.Equal(t, 0, len(AdjustFrameworkSigning(framework, "Release", identity)), "adjusted settings need no further adjustments")
+}
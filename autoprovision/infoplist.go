@@ -0,0 +1,115 @@
+package autoprovision
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+var (
+	preprocessorDirectiveRegexp = regexp.MustCompile(`^\s*#\s*(\w+)\s*(.*)$`)
+	macroDefinitionRegexp       = regexp.MustCompile(`^([A-Za-z_]\w*)(?:\s+(.*))?$`)
+	macroIdentifierRegexp       = regexp.MustCompile(`[A-Za-z_]\w*`)
+)
+
+// maxMacroExpansionDepth limits the expansion of macros referencing other macros.
+const maxMacroExpansionDepth = 8
+
+// infoPlistPreprocessingEnabled returns true if the Info.plist is run through the C preprocessor before the build (INFOPLIST_PREPROCESS).
+func infoPlistPreprocessingEnabled(settings serialized.Object) bool {
+	preprocess, err := settings.String("INFOPLIST_PREPROCESS")
+	return err == nil && preprocess == "YES"
+}
+
+// infoPlistMacros collects the macros available to the Info.plist preprocessor:
+// the #define directives of the INFOPLIST_PREFIX_HEADER file and the INFOPLIST_PREPROCESSOR_DEFINITIONS build setting.
+// The definitions build setting takes precedence over the prefix header.
+func infoPlistMacros(settings serialized.Object, projectDir string) (map[string]string, error) {
+	macros := map[string]string{}
+
+	if prefixHeader, err := settings.String("INFOPLIST_PREFIX_HEADER"); err == nil && prefixHeader != "" {
+		if !path.IsAbs(prefixHeader) {
+			prefixHeader = path.Join(projectDir, prefixHeader)
+		}
+
+		content, err := ioutil.ReadFile(prefixHeader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Info.plist prefix header (%s): %s", prefixHeader, err)
+		}
+		preprocessInfoPlist(content, macros)
+	}
+
+	if definitions, err := settings.String("INFOPLIST_PREPROCESSOR_DEFINITIONS"); err == nil {
+		for _, definition := range strings.Fields(definitions) {
+			split := strings.SplitN(definition, "=", 2)
+			if len(split) == 1 {
+				macros[split[0]] = "1"
+			} else {
+				macros[split[0]] = strings.Trim(split[1], `"`)
+			}
+		}
+	}
+
+	return macros, nil
+}
+
+// preprocessInfoPlist resolves the common preprocessor patterns of an Info.plist:
+// directive lines (#include, #define, #ifdef...) are dropped, #define-d object-like macros are added to the given macros,
+// and the macros are substituted in the remaining lines.
+// Conditional directives are not evaluated, every branch is kept.
+func preprocessInfoPlist(content []byte, macros map[string]string) []byte {
+	var out bytes.Buffer
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if match := preprocessorDirectiveRegexp.FindStringSubmatch(line); match != nil {
+			if match[1] == "define" {
+				if definition := macroDefinitionRegexp.FindStringSubmatch(match[2]); definition != nil {
+					macros[definition[1]] = stripLineComment(definition[2])
+				}
+			}
+			continue
+		}
+
+		out.WriteString(expandMacros(line, macros, 0))
+		out.WriteString("\n")
+	}
+
+	return out.Bytes()
+}
+
+func expandMacros(s string, macros map[string]string, depth int) string {
+	if depth >= maxMacroExpansionDepth {
+		return s
+	}
+	return macroIdentifierRegexp.ReplaceAllStringFunc(s, func(identifier string) string {
+		value, ok := macros[identifier]
+		if !ok {
+			return identifier
+		}
+		return expandMacros(value, macros, depth+1)
+	})
+}
+
+func stripLineComment(s string) string {
+	if i := strings.Index(s, "//"); i != -1 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func (p *ProjectHelper) preprocessInfoPlist(content []byte, settings serialized.Object) ([]byte, error) {
+	macros, err := infoPlistMacros(settings, path.Dir(p.XcProj.Path))
+	if err != nil {
+		return nil, err
+	}
+	return preprocessInfoPlist(content, macros), nil
+}
@@ -0,0 +1,130 @@
+package autoprovision
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+)
+
+const preprocessedInfoPlist = `#include "Config.h"
+#define BUNDLE_SUFFIX .beta // the suffix of the beta app
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleIdentifier</key>
+	<string>BUNDLE_PREFIX.app</string>
+	<key>CFBundleVersion</key>
+	<string>BUILD_NUMBER</string>
+#ifdef DEBUG
+	<key>Debug</key>
+	<true/>
+#endif
+</dict>
+</plist>
+`
+
+func Test_preprocessInfoPlist(t *testing.T) {
+	tests := []struct {
+		name   string
+		macros map[string]string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "resolves macros",
+			macros: map[string]string{"BUNDLE_PREFIX": "io.bitrise", "BUILD_NUMBER": "42"},
+			want: map[string]interface{}{
+				"CFBundleIdentifier": "io.bitrise.app",
+				"CFBundleVersion":    "42",
+				"Debug":              true,
+			},
+		},
+		{
+			name:   "resolves nested macros",
+			macros: map[string]string{"BUNDLE_PREFIX": "COMPANY_PREFIX.ios", "COMPANY_PREFIX": "io.bitrise", "BUILD_NUMBER": "42"},
+			want: map[string]interface{}{
+				"CFBundleIdentifier": "io.bitrise.ios.app",
+				"CFBundleVersion":    "42",
+				"Debug":              true,
+			},
+		},
+		{
+			name:   "keeps undefined macros",
+			macros: map[string]string{},
+			want: map[string]interface{}{
+				"CFBundleIdentifier": "BUNDLE_PREFIX.app",
+				"CFBundleVersion":    "BUILD_NUMBER",
+				"Debug":              true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := preprocessInfoPlist([]byte(preprocessedInfoPlist), tt.macros)
+
+			got := map[string]interface{}{}
+			_, err := plist.Unmarshal(content, &got)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, ".beta", tt.macros["BUNDLE_SUFFIX"])
+		})
+	}
+}
+
+func Test_infoPlistMacros(t *testing.T) {
+	dir, err := ioutil.TempDir("", "info-plist")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+
+	header := "#define BUNDLE_PREFIX io.bitrise\n#define BUILD_NUMBER 1\n#define STRINGIFY(x) #x\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Config.h"), []byte(header), 0600))
+
+	tests := []struct {
+		name     string
+		settings serialized.Object
+		want     map[string]string
+		wantErr  bool
+	}{
+		{
+			name: "prefix header and definitions, function-like macros are skipped",
+			settings: serialized.Object{
+				"INFOPLIST_PREFIX_HEADER":            "Config.h",
+				"INFOPLIST_PREPROCESSOR_DEFINITIONS": `BUILD_NUMBER=42 DEBUG APP_NAME="Bitrise"`,
+			},
+			want: map[string]string{
+				"BUNDLE_PREFIX": "io.bitrise",
+				"BUILD_NUMBER":  "42",
+				"DEBUG":         "1",
+				"APP_NAME":      "Bitrise",
+			},
+		},
+		{
+			name:     "no macros",
+			settings: serialized.Object{},
+			want:     map[string]string{},
+		},
+		{
+			name:     "missing prefix header",
+			settings: serialized.Object{"INFOPLIST_PREFIX_HEADER": "Missing.h"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := infoPlistMacros(tt.settings, dir)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
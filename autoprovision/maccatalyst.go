@@ -0,0 +1,31 @@
+package autoprovision
+
+import (
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// macCatalystSDKCondition is the build setting condition of the Mac Catalyst variant of the iOS targets
+const macCatalystSDKCondition = "[sdk=macosx*]"
+
+// MacCatalystProfileType returns the type of the profile signing the Mac Catalyst variant of the app,
+// it returns false if the distribution has no Mac Catalyst profile (ad-hoc and enterprise).
+func MacCatalystProfileType(distribution DistributionType) (appstoreconnect.ProfileType, bool) {
+	profileType, ok := PlatformToProfileTypeByDistribution[MacCatalyst][distribution]
+	return profileType, ok
+}
+
+// ForceMacCatalystProfile sets the Mac Catalyst profile of the target in the given configuration,
+// with macosx SDK conditional build settings, next to the iOS profile set by ForceCodeSign.
+// ForceCodeSign overrides every SDK conditional profile setting, so it needs to be called after ForceCodeSign.
+// The project needs to be saved to persist the changes.
+func ForceMacCatalystProfile(target xcodeproj.Target, configuration, profileUUID string) {
+	for _, buildConfiguration := range target.BuildConfigurationList.BuildConfigurations {
+		if buildConfiguration.Name != configuration {
+			continue
+		}
+
+		buildConfiguration.BuildSettings["PROVISIONING_PROFILE"+macCatalystSDKCondition] = profileUUID
+		buildConfiguration.BuildSettings["PROVISIONING_PROFILE_SPECIFIER"+macCatalystSDKCondition] = ""
+	}
+}
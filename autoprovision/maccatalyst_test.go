@@ -0,0 +1,35 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForceMacCatalystProfile(t *testing.T) {
+	releaseSettings := serialized.Object{
+		"PROVISIONING_PROFILE":                        "ios-profile-uuid",
+		"PROVISIONING_PROFILE_SPECIFIER":              "",
+		"PROVISIONING_PROFILE_SPECIFIER[sdk=macosx*]": "ios-profile-uuid",
+	}
+	debugSettings := serialized.Object{}
+	target := xcodeproj.Target{
+		Name: "App",
+		BuildConfigurationList: xcodeproj.ConfigurationList{BuildConfigurations: []xcodeproj.BuildConfiguration{
+			{Name: "Debug", BuildSettings: debugSettings},
+			{Name: "Release", BuildSettings: releaseSettings},
+		}},
+	}
+
+	ForceMacCatalystProfile(target, "Release", "mac-catalyst-profile-uuid")
+
+	require.Equal(t, serialized.Object{
+		"PROVISIONING_PROFILE":                        "ios-profile-uuid",
+		"PROVISIONING_PROFILE_SPECIFIER":              "",
+		"PROVISIONING_PROFILE[sdk=macosx*]":           "mac-catalyst-profile-uuid",
+		"PROVISIONING_PROFILE_SPECIFIER[sdk=macosx*]": "",
+	}, releaseSettings)
+	require.Equal(t, serialized.Object{}, debugSettings, "other configurations are not changed")
+}
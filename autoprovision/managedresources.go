@@ -0,0 +1,66 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ManagedResource is a kind of signing resource the Step manages
+type ManagedResource string
+
+// ManagedResource ...
+const (
+	// ManageDevices registers the Bitrise test devices on the Developer Portal
+	ManageDevices ManagedResource = "devices"
+	// ManageProfiles ensures the app IDs and the provisioning profiles, installs the profiles and applies them on the project
+	ManageProfiles ManagedResource = "profiles"
+	// ManageCertificates installs the certificates into the keychain
+	ManageCertificates ManagedResource = "certificates"
+)
+
+// AllManagedResources ...
+var AllManagedResources = []ManagedResource{ManageDevices, ManageProfiles, ManageCertificates}
+
+// ManagedResources is the scope of the Step, the resources not in the scope are owned by another system and left untouched
+type ManagedResources map[ManagedResource]bool
+
+// ParseManagedResources parses the resource kinds, empty or all means every resource kind.
+func ParseManagedResources(resources []string) (ManagedResources, error) {
+	managed := ManagedResources{}
+	for _, resource := range resources {
+		if resource == "all" {
+			for _, resource := range AllManagedResources {
+				managed[resource] = true
+			}
+			continue
+		}
+
+		known := false
+		for _, knownResource := range AllManagedResources {
+			if ManagedResource(resource) == knownResource {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown resource (%s), available: all, %s, %s, %s", resource, ManageDevices, ManageProfiles, ManageCertificates)
+		}
+		managed[ManagedResource(resource)] = true
+	}
+
+	if len(managed) == 0 {
+		return ParseManagedResources([]string{"all"})
+	}
+	return managed, nil
+}
+
+// String ...
+func (r ManagedResources) String() string {
+	var resources []string
+	for resource := range r {
+		resources = append(resources, string(resource))
+	}
+	sort.Strings(resources)
+	return strings.Join(resources, ", ")
+}
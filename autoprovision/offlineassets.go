@@ -0,0 +1,251 @@
+package autoprovision
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/bitrise-io/go-xcode/profileutil"
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// OfflineProfile is a provisioning profile of the offline assets directory
+type OfflineProfile struct {
+	Path    string
+	Content []byte
+	Info    profileutil.ProvisioningProfileInfoModel
+	// Platforms are the values of the profile's Platform key, like iOS, tvOS or OSX
+	Platforms []string
+}
+
+// ReadOfflineProfiles reads the provisioning profiles (.mobileprovision and .provisionprofile files) of the offline assets directory.
+func ReadOfflineProfiles(dir string) ([]OfflineProfile, error) {
+	var profiles []OfflineProfile
+	for _, pattern := range []string{"*.mobileprovision", "*.provisionprofile"} {
+		pths, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pth := range pths {
+			content, err := ioutil.ReadFile(pth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read profile (%s): %s", pth, err)
+			}
+
+			profile, err := newOfflineProfile(pth, content)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse profile (%s): %s", pth, err)
+			}
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
+
+func newOfflineProfile(pth string, content []byte) (OfflineProfile, error) {
+	decoded, err := decodeProfileContent(content)
+	if err != nil {
+		return OfflineProfile{}, err
+	}
+	platforms, _ := decoded.Data.GetStringArray("Platform")
+
+	return OfflineProfile{
+		Path:      pth,
+		Content:   content,
+		Info:      decoded.Info,
+		Platforms: platforms,
+	}, nil
+}
+
+// ReadOfflineCertificates reads the certificates of the .p12 files of the offline assets directory,
+// each file is opened with the first matching passphrase, or without passphrase if none is given.
+func ReadOfflineCertificates(dir string, passphrases []string) ([]certificateutil.CertificateInfoModel, error) {
+	if len(passphrases) == 0 {
+		passphrases = []string{""}
+	}
+
+	pths, err := filepath.Glob(filepath.Join(dir, "*.p12"))
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []certificateutil.CertificateInfoModel
+	for _, pth := range pths {
+		content, err := ioutil.ReadFile(pth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate (%s): %s", pth, err)
+		}
+
+		var infos []certificateutil.CertificateInfoModel
+		for _, passphrase := range passphrases {
+			if infos, err = certificateutil.CertificatesFromPKCS12Content(content, passphrase); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open certificate (%s) with any of the passphrases: %s", pth, err)
+		}
+
+		log.Debugf("Codesign identities included in %s:\n%s", pth, CertsToString(infos))
+		certificates = append(certificates, infos...)
+	}
+	return certificates, nil
+}
+
+// GetValidOfflineCertificates returns the valid certificates by type, like GetValidCertificates,
+// without matching them to the Developer Portal certificates: the returned certificates have no ID.
+func GetValidOfflineCertificates(certificates []certificateutil.CertificateInfoModel, requiredCertificateTypes map[appstoreconnect.CertificateType]bool, teamID string) (map[appstoreconnect.CertificateType][]APICertificate, error) {
+	var additionalTypes []appstoreconnect.CertificateType
+	for certificateType := range requiredCertificateTypes {
+		if certificateType != appstoreconnect.IOSDevelopment && certificateType != appstoreconnect.IOSDistribution {
+			additionalTypes = append(additionalTypes, certificateType)
+		}
+	}
+
+	typeToLocalCerts, err := GetValidLocalCertificates(certificates, teamID, additionalTypes...)
+	if err != nil {
+		return nil, err
+	}
+
+	for certificateType, required := range requiredCertificateTypes {
+		if required && len(typeToLocalCerts[certificateType]) == 0 {
+			return map[appstoreconnect.CertificateType][]APICertificate{}, MissingCertificateError{certificateType, teamID}
+		}
+	}
+
+	validCertificates := map[appstoreconnect.CertificateType][]APICertificate{}
+	for certificateType, localCertificates := range typeToLocalCerts {
+		for _, certificate := range localCertificates {
+			validCertificates[certificateType] = append(validCertificates[certificateType], APICertificate{Certificate: certificate})
+		}
+	}
+	return validCertificates, nil
+}
+
+// FindOfflineProfile returns the profile of the offline assets matching the bundle ID, the distribution type, the project's entitlements
+// and including the certificate. An explicit bundle ID profile is preferred to a wildcard one, then the latest expiring one.
+// The devices of the profile are not checked, as the registered devices are not known without the Developer Portal.
+func FindOfflineProfile(profiles []OfflineProfile, platform Platform, distribution DistributionType, bundleID string, entitlements Entitlement, certificate certificateutil.CertificateInfoModel, minProfileDaysValid int, now time.Time) (*appstoreconnect.Profile, error) {
+	profileType, ok := PlatformToProfileTypeByDistribution[platform][distribution]
+	if !ok {
+		return nil, fmt.Errorf("no %s profile type for platform: %s", distribution, platform)
+	}
+
+	var matching []OfflineProfile
+	for _, profile := range profiles {
+		if reason := offlineProfileMismatch(profile, platform, distribution, bundleID, entitlements, certificate, minProfileDaysValid, now); reason != "" {
+			log.Debugf("Profile (%s) does not match %s %s: %s", profile.Info.Name, distribution, bundleID, reason)
+			continue
+		}
+		matching = append(matching, profile)
+	}
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("no %s profile found for bundle ID (%s) in the offline assets", distribution, bundleID)
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		iExplicit, jExplicit := matching[i].Info.BundleID == bundleID, matching[j].Info.BundleID == bundleID
+		if iExplicit != jExplicit {
+			return iExplicit
+		}
+		return matching[i].Info.ExpirationDate.After(matching[j].Info.ExpirationDate)
+	})
+
+	profile := matching[0]
+	log.Printf("Using offline profile (%s) for %s: %s", profile.Info.Name, bundleID, profile.Path)
+
+	return &appstoreconnect.Profile{
+		Attributes: appstoreconnect.ProfileAttributes{
+			Name:           profile.Info.Name,
+			Platform:       BundleIDPlatform(platform),
+			ProfileContent: profile.Content,
+			UUID:           profile.Info.UUID,
+			CreatedDate:    profile.Info.CreationDate.Format(time.RFC3339),
+			ProfileState:   appstoreconnect.Active,
+			ProfileType:    profileType,
+			ExpirationDate: appstoreconnect.Time(profile.Info.ExpirationDate),
+		},
+	}, nil
+}
+
+// offlineProfileMismatch returns the reason, the profile can not be used, an empty string if it matches.
+func offlineProfileMismatch(profile OfflineProfile, platform Platform, distribution DistributionType, bundleID string, entitlements Entitlement, certificate certificateutil.CertificateInfoModel, minProfileDaysValid int, now time.Time) string {
+	profilePlatform := platformSpecs[platform].ProfilePlatformKey
+	platformMatches := false
+	for _, p := range profile.Platforms {
+		if strings.EqualFold(p, profilePlatform) {
+			platformMatches = true
+			break
+		}
+	}
+	if !platformMatches {
+		return fmt.Sprintf("platforms (%s) do not include %s", strings.Join(profile.Platforms, ", "), platform)
+	}
+
+	if string(profile.Info.ExportType) != string(distribution) {
+		return fmt.Sprintf("distribution type is %s", profile.Info.ExportType)
+	}
+
+	if !bundleIDMatches(profile.Info.BundleID, bundleID) {
+		return fmt.Sprintf("bundle ID is %s", profile.Info.BundleID)
+	}
+
+	if profile.Info.TeamID != certificate.TeamID {
+		return fmt.Sprintf("team (%s) differs from the certificate's team (%s)", profile.Info.TeamID, certificate.TeamID)
+	}
+
+	relativeExpiryTime := now
+	if minProfileDaysValid > 0 {
+		relativeExpiryTime = relativeExpiryTime.Add(time.Duration(minProfileDaysValid) * 24 * time.Hour)
+	}
+	if profile.Info.ExpirationDate.Before(relativeExpiryTime) {
+		return fmt.Sprintf("profile expired, or will expire in less then %d day(s)", minProfileDaysValid)
+	}
+
+	certificateIncluded := false
+	for _, c := range profile.Info.DeveloperCertificates {
+		if c.Serial == certificate.Serial {
+			certificateIncluded = true
+			break
+		}
+	}
+	if !certificateIncluded {
+		return fmt.Sprintf("certificate (%s) is not included", certificate.CommonName)
+	}
+
+	profileEntitlements := NormalizeEntitlements(serialized.Object(profile.Info.Entitlements))
+	for key, value := range entitlements {
+		entitlement := Entitlement{key: value}
+		if !entitlement.AppearsOnDeveloperPortal() && !entitlement.IsProfileAttached() {
+			continue
+		}
+		if _, ok := profileEntitlements[key]; !ok {
+			return fmt.Sprintf("entitlement (%s) is missing", key)
+		}
+	}
+
+	missingContainers, err := findMissingContainers(NormalizeEntitlements(serialized.Object(entitlements)), environmentContainers(profileEntitlements, CloudKitEnvironmentOf(distribution)))
+	if err != nil {
+		return fmt.Sprintf("failed to check containers: %s", err)
+	}
+	if len(missingContainers) > 0 {
+		return fmt.Sprintf("containers are missing: %v", missingContainers)
+	}
+
+	return ""
+}
+
+// bundleIDMatches reports whether the profile's bundle ID, optionally a wildcard one (like * or io.bitrise.*), matches the bundle ID.
+func bundleIDMatches(profileBundleID, bundleID string) bool {
+	if strings.HasSuffix(profileBundleID, "*") {
+		return strings.HasPrefix(bundleID, strings.TrimSuffix(profileBundleID, "*"))
+	}
+	return profileBundleID == bundleID
+}
@@ -0,0 +1,187 @@
+package autoprovision
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/bitrise-io/go-xcode/exportoptions"
+	"github.com/bitrise-io/go-xcode/profileutil"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/fullsailor/pkcs7"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_bundleIDMatches(t *testing.T) {
+	require.True(t, bundleIDMatches("io.bitrise.app", "io.bitrise.app"))
+	require.True(t, bundleIDMatches("io.bitrise.*", "io.bitrise.app"))
+	require.True(t, bundleIDMatches("*", "io.bitrise.app"))
+	require.False(t, bundleIDMatches("io.bitrise.app", "io.bitrise.app.widget"))
+	require.False(t, bundleIDMatches("io.other.*", "io.bitrise.app"))
+}
+
+func TestFindOfflineProfile(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	certificate := certificateutil.CertificateInfoModel{CommonName: "Apple Distribution: Bitrise", TeamID: "TEAM123", Serial: "1"}
+
+	offlineProfile := func(name, bundleID string, exportType exportoptions.Method, expiry time.Time, modify func(*OfflineProfile)) OfflineProfile {
+		profile := OfflineProfile{
+			Path: name + ".mobileprovision",
+			Info: profileutil.ProvisioningProfileInfoModel{
+				Name:                  name,
+				UUID:                  name + "-uuid",
+				TeamID:                "TEAM123",
+				BundleID:              bundleID,
+				ExportType:            exportType,
+				ExpirationDate:        expiry,
+				DeveloperCertificates: []certificateutil.CertificateInfoModel{certificate},
+				Entitlements:          map[string]interface{}{"aps-environment": "production"},
+			},
+			Platforms: []string{"iOS"},
+		}
+		if modify != nil {
+			modify(&profile)
+		}
+		return profile
+	}
+	nextYear := now.AddDate(1, 0, 0)
+
+	tests := []struct {
+		name        string
+		profiles    []OfflineProfile
+		wantProfile string
+		wantErr     bool
+	}{
+		{
+			name: "explicit bundle ID is preferred",
+			profiles: []OfflineProfile{
+				offlineProfile("wildcard", "io.bitrise.*", exportoptions.MethodAppStore, nextYear.AddDate(1, 0, 0), nil),
+				offlineProfile("explicit", "io.bitrise.app", exportoptions.MethodAppStore, nextYear, nil),
+			},
+			wantProfile: "explicit",
+		},
+		{
+			name: "latest expiring profile is preferred",
+			profiles: []OfflineProfile{
+				offlineProfile("older", "io.bitrise.app", exportoptions.MethodAppStore, nextYear, nil),
+				offlineProfile("newer", "io.bitrise.app", exportoptions.MethodAppStore, nextYear.AddDate(0, 1, 0), nil),
+			},
+			wantProfile: "newer",
+		},
+		{
+			name: "nonmatching profiles are skipped",
+			profiles: []OfflineProfile{
+				offlineProfile("development", "io.bitrise.app", exportoptions.MethodDevelopment, nextYear, nil),
+				offlineProfile("expiring", "io.bitrise.app", exportoptions.MethodAppStore, now.AddDate(0, 0, 10), nil),
+				offlineProfile("tvos", "io.bitrise.app", exportoptions.MethodAppStore, nextYear, func(p *OfflineProfile) { p.Platforms = []string{"tvOS"} }),
+				offlineProfile("other team", "io.bitrise.app", exportoptions.MethodAppStore, nextYear, func(p *OfflineProfile) { p.Info.TeamID = "OTHER" }),
+				offlineProfile("other certificate", "io.bitrise.app", exportoptions.MethodAppStore, nextYear, func(p *OfflineProfile) {
+					p.Info.DeveloperCertificates = []certificateutil.CertificateInfoModel{{Serial: "2"}}
+				}),
+				offlineProfile("no push", "io.bitrise.app", exportoptions.MethodAppStore, nextYear, func(p *OfflineProfile) { p.Info.Entitlements = nil }),
+				offlineProfile("matching", "io.bitrise.app", exportoptions.MethodAppStore, nextYear, nil),
+			},
+			wantProfile: "matching",
+		},
+		{
+			name: "no matching profile",
+			profiles: []OfflineProfile{
+				offlineProfile("other bundle ID", "io.bitrise.other", exportoptions.MethodAppStore, nextYear, nil),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindOfflineProfile(tt.profiles, IOS, AppStore, "io.bitrise.app", Entitlement{"aps-environment": "production"}, certificate, 30, now)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantProfile, got.Attributes.Name)
+			require.Equal(t, tt.wantProfile+"-uuid", got.Attributes.UUID)
+			require.Equal(t, appstoreconnect.IOSAppStore, got.Attributes.ProfileType)
+		})
+	}
+}
+
+func TestReadOfflineAssets(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization:       []string{"Bitrise"},
+			OrganizationalUnit: []string{"TEAM123"},
+			CommonName:         "Apple Distribution: Bitrise",
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().AddDate(1, 0, 0),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}
+	certData, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certData)
+	require.NoError(t, err)
+	p12, err := certificateutil.NewCertificateInfo(*cert, key).EncodeToP12("passphrase")
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "distribution.p12"), p12, 0600))
+
+	certificates, err := ReadOfflineCertificates(dir, []string{"wrong", "passphrase"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(certificates))
+	require.Equal(t, "TEAM123", certificates[0].TeamID)
+
+	_, err = ReadOfflineCertificates(dir, []string{"wrong"})
+	require.Error(t, err)
+
+	profileContent := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Name</key>
+	<string>Bitrise app-store - (io.bitrise.app)</string>
+	<key>UUID</key>
+	<string>PROFILE-UUID</string>
+	<key>TeamIdentifier</key>
+	<array><string>TEAM123</string></array>
+	<key>Platform</key>
+	<array><string>iOS</string></array>
+	<key>ExpirationDate</key>
+	<date>%s</date>
+	<key>Entitlements</key>
+	<dict>
+		<key>application-identifier</key>
+		<string>TEAM123.io.bitrise.app</string>
+		<key>com.apple.developer.team-identifier</key>
+		<string>TEAM123</string>
+	</dict>
+</dict>
+</plist>`, time.Now().AddDate(1, 0, 0).UTC().Format(time.RFC3339))
+	signedData, err := pkcs7.NewSignedData([]byte(profileContent))
+	require.NoError(t, err)
+	require.NoError(t, signedData.AddSigner(cert, key, pkcs7.SignerInfoConfig{}))
+	signedProfile, err := signedData.Finish()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "app.mobileprovision"), signedProfile, 0600))
+
+	profiles, err := ReadOfflineProfiles(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(profiles))
+	require.Equal(t, "PROFILE-UUID", profiles[0].Info.UUID)
+	require.Equal(t, "io.bitrise.app", profiles[0].Info.BundleID)
+	require.Equal(t, exportoptions.MethodAppStore, profiles[0].Info.ExportType)
+	require.Equal(t, []string{"iOS"}, profiles[0].Platforms)
+	require.Equal(t, signedProfile, profiles[0].Content)
+}
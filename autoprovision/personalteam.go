@@ -0,0 +1,41 @@
+package autoprovision
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// PersonalTeamProfileValidity is the validity of the profiles generated for personal (free) development teams
+const PersonalTeamProfileValidity = 7 * 24 * time.Hour
+
+// PersonalTeamHint describes the limitations of the personal (free) development teams
+const PersonalTeamHint = "Personal (free) Apple developer accounts can only sign development builds (use the development distribution type), " +
+	"their profiles are valid for 7 days and they can register at most 10 app IDs in 7 days."
+
+// IsPersonalTeamProfile returns true if the profile is valid for at most 7 days,
+// which is the validity of the profiles generated for personal (free) development teams.
+func IsPersonalTeamProfile(profile appstoreconnect.Profile) bool {
+	created, err := time.Parse(time.RFC3339, profile.Attributes.CreatedDate)
+	if err != nil {
+		return false
+	}
+	expiration := time.Time(profile.Attributes.ExpirationDate)
+	if expiration.IsZero() {
+		return false
+	}
+	// some slack for the different creation and expiration timestamp precisions
+	return expiration.Sub(created) <= PersonalTeamProfileValidity+time.Hour
+}
+
+// IsPersonalTeamLimitError returns true if the error might be caused by the personal team limits,
+// like reaching the maximum number of app IDs.
+func IsPersonalTeamLimitError(err error) bool {
+	var respErr *appstoreconnect.ErrorResponse
+	if !errors.As(err, &respErr) || respErr.Response == nil {
+		return false
+	}
+	return respErr.Response.StatusCode == http.StatusForbidden || respErr.Response.StatusCode == http.StatusConflict
+}
@@ -0,0 +1,49 @@
+package autoprovision
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPersonalTeamProfile(t *testing.T) {
+	created := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	profile := func(createdDate string, expiration time.Time) appstoreconnect.Profile {
+		return appstoreconnect.Profile{Attributes: appstoreconnect.ProfileAttributes{
+			CreatedDate:    createdDate,
+			ExpirationDate: appstoreconnect.Time(expiration),
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		profile appstoreconnect.Profile
+		want    bool
+	}{
+		{name: "7 days validity", profile: profile(created.Format(time.RFC3339), created.Add(PersonalTeamProfileValidity)), want: true},
+		{name: "a year validity", profile: profile(created.Format(time.RFC3339), created.Add(365*24*time.Hour)), want: false},
+		{name: "unknown creation date", profile: profile("", created.Add(PersonalTeamProfileValidity)), want: false},
+		{name: "unknown expiration date", profile: profile(created.Format(time.RFC3339), time.Time{}), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, IsPersonalTeamProfile(tt.profile))
+		})
+	}
+}
+
+func TestIsPersonalTeamLimitError(t *testing.T) {
+	responseErr := func(statusCode int) error {
+		return &appstoreconnect.ErrorResponse{Response: &http.Response{StatusCode: statusCode}}
+	}
+
+	require.True(t, IsPersonalTeamLimitError(responseErr(http.StatusForbidden)))
+	require.True(t, IsPersonalTeamLimitError(responseErr(http.StatusConflict)))
+	require.False(t, IsPersonalTeamLimitError(responseErr(http.StatusNotFound)))
+	require.False(t, IsPersonalTeamLimitError(errors.New("network error")))
+	require.True(t, IsPersonalTeamLimitError(&appstoreconnect.AuthError{Failure: appstoreconnect.MissingRoleFailure, Err: responseErr(http.StatusForbidden)}), "the error response is unwrapped")
+}
@@ -1,6 +1,10 @@
 package autoprovision
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
 )
 
@@ -9,49 +13,265 @@ type Platform string
 
 // Const
 const (
-	IOS   Platform = "iOS"
-	TVOS  Platform = "tvOS"
-	MacOS Platform = "macOS"
+	IOS      Platform = "iOS"
+	TVOS     Platform = "tvOS"
+	MacOS    Platform = "macOS"
+	WatchOS  Platform = "watchOS"
+	VisionOS Platform = "visionOS"
+	// DriverKit is the platform of the driver extensions (SDKROOT = driverkit), they are provisioned as macOS apps
+	DriverKit Platform = "DriverKit"
+	// MacCatalyst is the Mac variant of an iOS app (SUPPORTS_MACCATALYST = YES), it is provisioned next to the iOS platform
+	MacCatalyst Platform = "Mac Catalyst"
 )
 
-// ProfileTypeToPlatform ...
-var ProfileTypeToPlatform = map[appstoreconnect.ProfileType]Platform{
-	appstoreconnect.IOSAppDevelopment: IOS,
-	appstoreconnect.IOSAppStore:       IOS,
-	appstoreconnect.IOSAppAdHoc:       IOS,
-	appstoreconnect.IOSAppInHouse:     IOS,
+// platformSpec describes how the apps of a platform are provisioned
+type platformSpec struct {
+	// ProfileTypesOf is the platform, the profile types of are used by the platform, if it has no profile types of its own
+	ProfileTypesOf Platform
+	// BundleIDPlatform is the platform of the app IDs created for the platform
+	BundleIDPlatform appstoreconnect.BundleIDPlatform
+	// DevicePlatform is the platform of the devices, the development and ad-hoc profiles include
+	DevicePlatform appstoreconnect.DevicePlatform
+	// DeviceClasses are the classes of the devices, the development and ad-hoc profiles include
+	DeviceClasses []appstoreconnect.DeviceClass
+	// CertificateTypes are the types of the certificates signing the apps by distribution type
+	CertificateTypes map[DistributionType]appstoreconnect.CertificateType
+	// ProfilePlatformKey is the value of the Platform key of the profiles
+	ProfilePlatformKey string
+	// Detected is true if the platform is detected by the PLATFORM_DISPLAY_NAME of the main target,
+	// Mac Catalyst is only provisioned next to the iOS platform
+	Detected bool
+}
 
-	appstoreconnect.TvOSAppDevelopment: TVOS,
-	appstoreconnect.TvOSAppStore:       TVOS,
-	appstoreconnect.TvOSAppAdHoc:       TVOS,
-	appstoreconnect.TvOSAppInHouse:     TVOS,
+// platformSpecs is the table of the supported platforms
+var platformSpecs = map[Platform]platformSpec{
+	IOS: {
+		BundleIDPlatform:   appstoreconnect.IOS,
+		DevicePlatform:     appstoreconnect.IOSDevice,
+		DeviceClasses:      []appstoreconnect.DeviceClass{appstoreconnect.Iphone, appstoreconnect.Ipad, appstoreconnect.Ipod},
+		CertificateTypes:   CertificateTypeByDistribution,
+		ProfilePlatformKey: "iOS",
+		Detected:           true,
+	},
+	TVOS: {
+		BundleIDPlatform:   appstoreconnect.IOS,
+		DevicePlatform:     appstoreconnect.IOSDevice,
+		DeviceClasses:      []appstoreconnect.DeviceClass{appstoreconnect.AppleTV},
+		CertificateTypes:   CertificateTypeByDistribution,
+		ProfilePlatformKey: "tvOS",
+		Detected:           true,
+	},
+	MacOS: {
+		BundleIDPlatform:   appstoreconnect.MacOS,
+		DevicePlatform:     appstoreconnect.MacOSDevice,
+		DeviceClasses:      []appstoreconnect.DeviceClass{appstoreconnect.Mac},
+		CertificateTypes:   MacCertificateTypeByDistribution,
+		ProfilePlatformKey: "OSX",
+		Detected:           true,
+	},
+	// Independent watchOS apps (without an iOS companion app) use iOS profiles
+	WatchOS: {
+		ProfileTypesOf:     IOS,
+		BundleIDPlatform:   appstoreconnect.IOS,
+		DevicePlatform:     appstoreconnect.IOSDevice,
+		DeviceClasses:      []appstoreconnect.DeviceClass{appstoreconnect.AppleWatch},
+		CertificateTypes:   CertificateTypeByDistribution,
+		ProfilePlatformKey: "iOS",
+		Detected:           true,
+	},
+	// visionOS apps use iOS profiles too
+	VisionOS: {
+		ProfileTypesOf:     IOS,
+		BundleIDPlatform:   appstoreconnect.IOS,
+		DevicePlatform:     appstoreconnect.IOSDevice,
+		DeviceClasses:      []appstoreconnect.DeviceClass{appstoreconnect.AppleVisionPro},
+		CertificateTypes:   CertificateTypeByDistribution,
+		ProfilePlatformKey: "iOS",
+		Detected:           true,
+	},
+	// Driver extensions use macOS app IDs and profiles
+	DriverKit: {
+		ProfileTypesOf:     MacOS,
+		BundleIDPlatform:   appstoreconnect.MacOS,
+		DevicePlatform:     appstoreconnect.MacOSDevice,
+		DeviceClasses:      []appstoreconnect.DeviceClass{appstoreconnect.Mac},
+		CertificateTypes:   MacCertificateTypeByDistribution,
+		ProfilePlatformKey: "OSX",
+		Detected:           true,
+	},
+	// Mac Catalyst apps use the iOS app IDs and certificates, but run on the Macs, registered with the MAC_OS platform
+	MacCatalyst: {
+		BundleIDPlatform:   appstoreconnect.IOS,
+		DevicePlatform:     appstoreconnect.MacOSDevice,
+		DeviceClasses:      []appstoreconnect.DeviceClass{appstoreconnect.Mac},
+		CertificateTypes:   CertificateTypeByDistribution,
+		ProfilePlatformKey: "OSX",
+	},
 }
 
-// ProfileTypeToDistribution ...
-var ProfileTypeToDistribution = map[appstoreconnect.ProfileType]DistributionType{
-	appstoreconnect.IOSAppDevelopment: Development,
-	appstoreconnect.IOSAppStore:       AppStore,
-	appstoreconnect.IOSAppAdHoc:       AdHoc,
-	appstoreconnect.IOSAppInHouse:     Enterprise,
+// profileTypeSpec describes the platform and the distribution type, a profile type provisions
+type profileTypeSpec struct {
+	ProfileType  appstoreconnect.ProfileType
+	Platform     Platform
+	Distribution DistributionType
+}
+
+// profileTypeSpecs is the table of the supported profile types, the profile type maps are derived from it.
+// Supporting a new profile type only needs a new row, a new platform a new row in platformSpecs too.
+var profileTypeSpecs = []profileTypeSpec{
+	{appstoreconnect.IOSAppDevelopment, IOS, Development},
+	{appstoreconnect.IOSAppStore, IOS, AppStore},
+	{appstoreconnect.IOSAppAdHoc, IOS, AdHoc},
+	{appstoreconnect.IOSAppInHouse, IOS, Enterprise},
+
+	{appstoreconnect.TvOSAppDevelopment, TVOS, Development},
+	{appstoreconnect.TvOSAppStore, TVOS, AppStore},
+	{appstoreconnect.TvOSAppAdHoc, TVOS, AdHoc},
+	{appstoreconnect.TvOSAppInHouse, TVOS, Enterprise},
 
-	appstoreconnect.TvOSAppDevelopment: Development,
-	appstoreconnect.TvOSAppStore:       AppStore,
-	appstoreconnect.TvOSAppAdHoc:       AdHoc,
-	appstoreconnect.TvOSAppInHouse:     Enterprise,
+	{appstoreconnect.MacAppDevelopment, MacOS, Development},
+	{appstoreconnect.MacAppStore, MacOS, AppStore},
+
+	// The Mac variant of iOS apps has no ad-hoc nor enterprise profiles
+	{appstoreconnect.MacCatalystAppDevelopment, MacCatalyst, Development},
+	{appstoreconnect.MacCatalystAppStore, MacCatalyst, AppStore},
 }
 
+// ProfileTypeToPlatform ...
+var ProfileTypeToPlatform = func() map[appstoreconnect.ProfileType]Platform {
+	m := map[appstoreconnect.ProfileType]Platform{}
+	for _, spec := range profileTypeSpecs {
+		m[spec.ProfileType] = spec.Platform
+	}
+	return m
+}()
+
+// ProfileTypeToDistribution ...
+var ProfileTypeToDistribution = func() map[appstoreconnect.ProfileType]DistributionType {
+	m := map[appstoreconnect.ProfileType]DistributionType{}
+	for _, spec := range profileTypeSpecs {
+		m[spec.ProfileType] = spec.Distribution
+	}
+	return m
+}()
+
 // PlatformToProfileTypeByDistribution ...
-var PlatformToProfileTypeByDistribution = map[Platform]map[DistributionType]appstoreconnect.ProfileType{
-	IOS: map[DistributionType]appstoreconnect.ProfileType{
-		Development: appstoreconnect.IOSAppDevelopment,
-		AppStore:    appstoreconnect.IOSAppStore,
-		AdHoc:       appstoreconnect.IOSAppAdHoc,
-		Enterprise:  appstoreconnect.IOSAppInHouse,
-	},
-	TVOS: map[DistributionType]appstoreconnect.ProfileType{
-		Development: appstoreconnect.TvOSAppDevelopment,
-		AppStore:    appstoreconnect.TvOSAppStore,
-		AdHoc:       appstoreconnect.TvOSAppAdHoc,
-		Enterprise:  appstoreconnect.TvOSAppInHouse,
-	},
+var PlatformToProfileTypeByDistribution = func() map[Platform]map[DistributionType]appstoreconnect.ProfileType {
+	m := map[Platform]map[DistributionType]appstoreconnect.ProfileType{}
+	for platform, platformSpec := range platformSpecs {
+		profileTypesOf := platform
+		if platformSpec.ProfileTypesOf != "" {
+			profileTypesOf = platformSpec.ProfileTypesOf
+		}
+
+		m[platform] = map[DistributionType]appstoreconnect.ProfileType{}
+		for _, spec := range profileTypeSpecs {
+			if spec.Platform == profileTypesOf {
+				m[platform][spec.Distribution] = spec.ProfileType
+			}
+		}
+	}
+	return m
+}()
+
+// DistributionTypes returns the supported distribution types
+func DistributionTypes() []DistributionType {
+	return []DistributionType{Development, AppStore, AdHoc, Enterprise}
+}
+
+// ValidateDistributionType returns an error if the distribution type has no profile type
+func ValidateDistributionType(distribution DistributionType) error {
+	for _, spec := range profileTypeSpecs {
+		if spec.Distribution == distribution {
+			return nil
+		}
+	}
+
+	var available []string
+	for _, d := range DistributionTypes() {
+		available = append(available, string(d))
+	}
+	return fmt.Errorf("invalid distribution type (%s), available: %s", distribution, strings.Join(available, ", "))
+}
+
+// DistributionTypesTable returns the profile types of the distribution types by platform, a line per distribution type and platform:
+// <distribution type>	<platform>	<profile type>
+func DistributionTypesTable() string {
+	var platforms []string
+	for platform := range platformSpecs {
+		platforms = append(platforms, string(platform))
+	}
+	sort.Strings(platforms)
+
+	var b strings.Builder
+	for _, distribution := range DistributionTypes() {
+		for _, platform := range platforms {
+			if profileType, ok := PlatformToProfileTypeByDistribution[Platform(platform)][distribution]; ok {
+				b.WriteString(fmt.Sprintf("%s\t%s\t%s\n", distribution, platform, profileType))
+			}
+		}
+	}
+	return b.String()
+}
+
+// DetectedPlatform returns the platform of the PLATFORM_DISPLAY_NAME build setting
+func DetectedPlatform(platformDisplayName string) (Platform, error) {
+	if platformDisplayName == "xrOS" {
+		// visionOS was called xrOS before Xcode 15.2
+		platformDisplayName = string(VisionOS)
+	}
+
+	if spec, ok := platformSpecs[Platform(platformDisplayName)]; ok && spec.Detected {
+		return Platform(platformDisplayName), nil
+	}
+
+	var supported []string
+	for platform, spec := range platformSpecs {
+		if spec.Detected {
+			supported = append(supported, string(platform))
+		}
+	}
+	sort.Strings(supported)
+	return "", fmt.Errorf("not supported platform. Platform (PLATFORM_DISPLAY_NAME) = %s, supported: %s", platformDisplayName, strings.Join(supported, ", "))
+}
+
+// DeviceMatchesPlatform returns true if the device can run the apps of the platform.
+func DeviceMatchesPlatform(device appstoreconnect.Device, platform Platform) bool {
+	for _, class := range platformSpecs[platform].DeviceClasses {
+		if device.Attributes.DeviceClass == class {
+			return true
+		}
+	}
+	return false
+}
+
+// DevicePlatform returns the platform of the devices, the development and ad-hoc profiles of the platform include.
+func DevicePlatform(platform Platform) appstoreconnect.DevicePlatform {
+	if spec, ok := platformSpecs[platform]; ok {
+		return spec.DevicePlatform
+	}
+	return appstoreconnect.IOSDevice
+}
+
+// BundleIDPlatform returns the platform of the app IDs created for the platform.
+// iOS app IDs are shared by the iOS, tvOS, watchOS and visionOS apps and the Mac Catalyst variant of the iOS apps,
+// so the same bundle ID can ship on all of them.
+func BundleIDPlatform(platform Platform) appstoreconnect.BundleIDPlatform {
+	if spec, ok := platformSpecs[platform]; ok {
+		return spec.BundleIDPlatform
+	}
+	return appstoreconnect.IOS
+}
+
+// BundleIDSupportsPlatform returns true if the profiles of the platform can be generated for the app ID:
+// universal app IDs support every platform, iOS and macOS app IDs the platforms with the same app ID platform.
+func BundleIDSupportsPlatform(bundleID appstoreconnect.BundleID, platform Platform) bool {
+	switch appstoreconnect.BundleIDPlatform(bundleID.Attributes.Platform) {
+	case appstoreconnect.Universal, "":
+		return true
+	case appstoreconnect.MacOS:
+		return BundleIDPlatform(platform) == appstoreconnect.MacOS
+	default:
+		return BundleIDPlatform(platform) != appstoreconnect.MacOS
+	}
 }
@@ -0,0 +1,195 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceMatchesPlatform(t *testing.T) {
+	device := func(class appstoreconnect.DeviceClass) appstoreconnect.Device {
+		return appstoreconnect.Device{Attributes: appstoreconnect.DeviceAttributes{DeviceClass: class}}
+	}
+
+	tests := []struct {
+		name     string
+		device   appstoreconnect.Device
+		platform Platform
+		want     bool
+	}{
+		{name: "iPhone for iOS", device: device(appstoreconnect.Iphone), platform: IOS, want: true},
+		{name: "Apple Watch for iOS", device: device(appstoreconnect.AppleWatch), platform: IOS, want: false},
+		{name: "Apple Watch for watchOS", device: device(appstoreconnect.AppleWatch), platform: WatchOS, want: true},
+		{name: "iPhone for watchOS", device: device(appstoreconnect.Iphone), platform: WatchOS, want: false},
+		{name: "Apple TV for tvOS", device: device(appstoreconnect.AppleTV), platform: TVOS, want: true},
+		{name: "Mac for macOS", device: device(appstoreconnect.Mac), platform: MacOS, want: true},
+		{name: "Apple Vision Pro for visionOS", device: device(appstoreconnect.AppleVisionPro), platform: VisionOS, want: true},
+		{name: "iPad for visionOS", device: device(appstoreconnect.Ipad), platform: VisionOS, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, DeviceMatchesPlatform(tt.device, tt.platform))
+		})
+	}
+}
+
+func TestPlatform_watchOS(t *testing.T) {
+	p := ProjectHelper{
+		MainTarget: xcodeproj.Target{Name: "WatchApp"},
+		buildSettingsCache: map[string]map[string]serialized.Object{
+			"WatchApp": {"Release": {"PLATFORM_DISPLAY_NAME": "watchOS"}},
+		},
+	}
+
+	platform, err := p.Platform("Release")
+	require.NoError(t, err)
+	require.Equal(t, WatchOS, platform)
+	require.Equal(t, appstoreconnect.IOSAppStore, PlatformToProfileTypeByDistribution[platform][AppStore], "watchOS apps use iOS profiles")
+}
+
+func TestPlatform_visionOS(t *testing.T) {
+	for _, displayName := range []string{"visionOS", "xrOS"} {
+		t.Run(displayName, func(t *testing.T) {
+			p := ProjectHelper{
+				MainTarget: xcodeproj.Target{Name: "VisionApp"},
+				buildSettingsCache: map[string]map[string]serialized.Object{
+					"VisionApp": {"Release": {"PLATFORM_DISPLAY_NAME": displayName}},
+				},
+			}
+
+			platform, err := p.Platform("Release")
+			require.NoError(t, err)
+			require.Equal(t, VisionOS, platform)
+			require.Equal(t, appstoreconnect.IOSAppStore, PlatformToProfileTypeByDistribution[platform][AppStore], "visionOS apps use iOS profiles")
+			require.Equal(t, appstoreconnect.IOS, BundleIDPlatform(platform))
+		})
+	}
+}
+
+func TestMacCatalystProfiles(t *testing.T) {
+	mac := appstoreconnect.Device{Attributes: appstoreconnect.DeviceAttributes{DeviceClass: appstoreconnect.Mac}}
+	iPhone := appstoreconnect.Device{Attributes: appstoreconnect.DeviceAttributes{DeviceClass: appstoreconnect.Iphone}}
+
+	require.True(t, DeviceMatchesPlatform(mac, MacCatalyst))
+	require.False(t, DeviceMatchesPlatform(iPhone, MacCatalyst))
+	require.False(t, DeviceMatchesPlatform(mac, IOS), "the Macs are included in the Mac Catalyst profiles only")
+
+	profileType, ok := MacCatalystProfileType(Development)
+	require.True(t, ok)
+	require.Equal(t, appstoreconnect.MacCatalystAppDevelopment, profileType)
+	_, ok = MacCatalystProfileType(AdHoc)
+	require.False(t, ok)
+
+	name, err := ProfileName(appstoreconnect.MacCatalystAppStore, "io.bitrise.app")
+	require.NoError(t, err)
+	require.Equal(t, "Bitrise Mac Catalyst app-store - (io.bitrise.app)", name)
+
+	require.Equal(t, appstoreconnect.IOS, BundleIDPlatform(MacCatalyst), "Mac Catalyst apps use iOS app IDs")
+}
+
+func TestSupportsMacCatalyst(t *testing.T) {
+	p := ProjectHelper{
+		MainTarget: xcodeproj.Target{Name: "App"},
+		buildSettingsCache: map[string]map[string]serialized.Object{
+			"App": {
+				"Debug":   {"SUPPORTS_MACCATALYST": "YES"},
+				"Release": {},
+			},
+		},
+	}
+
+	supported, err := p.SupportsMacCatalyst("Debug")
+	require.NoError(t, err)
+	require.True(t, supported)
+
+	supported, err = p.SupportsMacCatalyst("Release")
+	require.NoError(t, err)
+	require.False(t, supported)
+}
+
+func TestBundleIDSupportsPlatform(t *testing.T) {
+	bundleID := func(platform appstoreconnect.BundleIDPlatform) appstoreconnect.BundleID {
+		return appstoreconnect.BundleID{Attributes: appstoreconnect.BundleIDAttributes{Platform: string(platform)}}
+	}
+
+	tests := []struct {
+		name     string
+		bundleID appstoreconnect.BundleID
+		platform Platform
+		want     bool
+	}{
+		{name: "iOS app ID for iOS", bundleID: bundleID(appstoreconnect.IOS), platform: IOS, want: true},
+		{name: "iOS app ID for tvOS", bundleID: bundleID(appstoreconnect.IOS), platform: TVOS, want: true},
+		{name: "iOS app ID for macOS", bundleID: bundleID(appstoreconnect.IOS), platform: MacOS, want: false},
+		{name: "macOS app ID for tvOS", bundleID: bundleID(appstoreconnect.MacOS), platform: TVOS, want: false},
+		{name: "universal app ID for macOS", bundleID: bundleID(appstoreconnect.Universal), platform: MacOS, want: true},
+		{name: "universal app ID for tvOS", bundleID: bundleID(appstoreconnect.Universal), platform: TVOS, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, BundleIDSupportsPlatform(tt.bundleID, tt.platform))
+		})
+	}
+}
+
+func TestProfileTypeSpecs(t *testing.T) {
+	seen := map[string]bool{}
+	for _, spec := range profileTypeSpecs {
+		_, ok := platformSpecs[spec.Platform]
+		require.True(t, ok, "profile type (%s) platform (%s) has no platform spec", spec.ProfileType, spec.Platform)
+		require.NoError(t, ValidateDistributionType(spec.Distribution))
+
+		key := string(spec.Platform) + "/" + string(spec.Distribution)
+		require.False(t, seen[key], "multiple profile types for %s", key)
+		seen[key] = true
+	}
+
+	for platform, spec := range platformSpecs {
+		if spec.ProfileTypesOf != "" {
+			require.Empty(t, platformSpecs[spec.ProfileTypesOf].ProfileTypesOf, "platform (%s) profile types are resolved in a single step", platform)
+		}
+		require.NotEmpty(t, PlatformToProfileTypeByDistribution[platform][Development], "platform (%s) has no development profile type", platform)
+	}
+}
+
+func TestPlatform_DriverKit(t *testing.T) {
+	p := ProjectHelper{
+		MainTarget: xcodeproj.Target{Name: "Driver"},
+		buildSettingsCache: map[string]map[string]serialized.Object{
+			"Driver": {"Release": {"PLATFORM_DISPLAY_NAME": "DriverKit"}},
+		},
+	}
+
+	platform, err := p.Platform("Release")
+	require.NoError(t, err)
+	require.Equal(t, DriverKit, platform)
+	require.Equal(t, appstoreconnect.MacAppStore, PlatformToProfileTypeByDistribution[platform][AppStore], "driver extensions use macOS profiles")
+	require.Equal(t, appstoreconnect.MacOS, BundleIDPlatform(platform))
+	require.Equal(t, appstoreconnect.MacOSDevice, DevicePlatform(platform))
+
+	certificateType, ok := CertificateType(platform, Development)
+	require.True(t, ok)
+	require.Equal(t, appstoreconnect.MacDevelopment, certificateType)
+}
+
+func TestDetectedPlatform_notSupported(t *testing.T) {
+	_, err := DetectedPlatform("Mac Catalyst")
+	require.EqualError(t, err, "not supported platform. Platform (PLATFORM_DISPLAY_NAME) = Mac Catalyst, supported: DriverKit, iOS, macOS, tvOS, visionOS, watchOS")
+}
+
+func TestValidateDistributionType(t *testing.T) {
+	for _, distribution := range DistributionTypes() {
+		require.NoError(t, ValidateDistributionType(distribution))
+	}
+	require.EqualError(t, ValidateDistributionType("developer-id"), "invalid distribution type (developer-id), available: development, app-store, ad-hoc, enterprise")
+}
+
+func TestDistributionTypesTable(t *testing.T) {
+	table := DistributionTypesTable()
+	require.Contains(t, table, "development\tMac Catalyst\tMAC_CATALYST_APP_DEVELOPMENT\n")
+	require.Contains(t, table, "enterprise\tvisionOS\tIOS_APP_INHOUSE\n")
+	require.NotContains(t, table, "ad-hoc\tmacOS")
+}
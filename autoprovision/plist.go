@@ -0,0 +1,99 @@
+package autoprovision
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"howett.net/plist"
+)
+
+var xmlEncodingDeclarationRegexp = regexp.MustCompile(`^(\s*<\?xml[^>]*?encoding\s*=\s*)["']([^"']*)["']`)
+
+// readPlist reads a dictionary property list file, see unmarshalPlist.
+func readPlist(pth string) (serialized.Object, error) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := unmarshalPlist(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse property list (%s): %s", pth, err)
+	}
+	return object, nil
+}
+
+// unmarshalPlist parses a dictionary property list.
+// The format (XML, binary or OpenStep) is detected by the plist library,
+// XML property lists in UTF-16 or declaring a non UTF-8 encoding (like Xcode generated ISO-8859-1 files) are converted to UTF-8 first.
+func unmarshalPlist(content []byte) (serialized.Object, error) {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, errors.New("empty property list")
+	}
+
+	var object serialized.Object
+	if _, err := plist.Unmarshal(normalizePlistEncoding(content), &object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// normalizePlistEncoding converts an XML property list to UTF-8 and updates its encoding declaration accordingly.
+// Binary and OpenStep property lists are returned unchanged.
+func normalizePlistEncoding(content []byte) []byte {
+	switch {
+	case bytes.HasPrefix(content, []byte{0xFE, 0xFF}):
+		content = decodeUTF16(content[2:], binary.BigEndian)
+	case bytes.HasPrefix(content, []byte{0xFF, 0xFE}):
+		content = decodeUTF16(content[2:], binary.LittleEndian)
+	case bytes.HasPrefix(content, []byte{0x00, '<'}):
+		content = decodeUTF16(content, binary.BigEndian)
+	case bytes.HasPrefix(content, []byte{'<', 0x00}):
+		content = decodeUTF16(content, binary.LittleEndian)
+	}
+
+	match := xmlEncodingDeclarationRegexp.FindSubmatchIndex(content)
+	if match == nil {
+		return content
+	}
+
+	encoding := strings.ToUpper(string(content[match[4]:match[5]]))
+	if encoding == "UTF-8" {
+		return content
+	}
+
+	if (encoding == "ISO-8859-1" || encoding == "LATIN1") && !utf8.Valid(content) {
+		content = decodeLatin1(content)
+		// the declaration is ASCII, so its position is not changed by the conversion
+	}
+
+	var normalized []byte
+	normalized = append(normalized, content[:match[3]]...)
+	normalized = append(normalized, `"UTF-8"`...)
+	normalized = append(normalized, content[match[1]:]...)
+	return normalized
+}
+
+func decodeUTF16(content []byte, order binary.ByteOrder) []byte {
+	units := make([]uint16, 0, len(content)/2)
+	for i := 0; i+1 < len(content); i += 2 {
+		units = append(units, order.Uint16(content[i:]))
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+func decodeLatin1(content []byte) []byte {
+	runes := make([]rune, 0, len(content))
+	for _, b := range content {
+		runes = append(runes, rune(b))
+	}
+	return []byte(string(runes))
+}
@@ -0,0 +1,120 @@
+package autoprovision
+
+import (
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+)
+
+const xmlEntitlements = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>aps-environment</key>
+	<string>development</string>
+	<key>com.apple.security.app-sandbox</key>
+	<true/>
+</dict>
+</plist>
+`
+
+func encodeUTF16(s string, order binary.ByteOrder, bom []byte) []byte {
+	content := append([]byte{}, bom...)
+	for _, unit := range utf16.Encode([]rune(s)) {
+		var b [2]byte
+		order.PutUint16(b[:], unit)
+		content = append(content, b[:]...)
+	}
+	return content
+}
+
+func Test_unmarshalPlist(t *testing.T) {
+	want := serialized.Object{
+		"aps-environment":                "development",
+		"com.apple.security.app-sandbox": true,
+	}
+
+	binaryEntitlements, err := plist.Marshal(want, plist.BinaryFormat)
+	require.NoError(t, err)
+
+	utf16Entitlements := `<?xml version="1.0" encoding="UTF-16"?>` + xmlEntitlements[len(`<?xml version="1.0" encoding="UTF-8"?>`):]
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    serialized.Object
+		wantErr bool
+	}{
+		{
+			name:    "XML",
+			content: []byte(xmlEntitlements),
+			want:    want,
+		},
+		{
+			name:    "binary",
+			content: binaryEntitlements,
+			want:    want,
+		},
+		{
+			name:    "UTF-8 byte order mark",
+			content: append([]byte{0xEF, 0xBB, 0xBF}, xmlEntitlements...),
+			want:    want,
+		},
+		{
+			name:    "UTF-16 big endian",
+			content: encodeUTF16(utf16Entitlements, binary.BigEndian, []byte{0xFE, 0xFF}),
+			want:    want,
+		},
+		{
+			name:    "UTF-16 little endian without byte order mark",
+			content: encodeUTF16(utf16Entitlements, binary.LittleEndian, nil),
+			want:    want,
+		},
+		{
+			name: "ISO-8859-1",
+			content: []byte("<?xml version='1.0' encoding='ISO-8859-1'?>\n" +
+				"<plist version=\"1.0\"><dict><key>com.apple.developer.icloud-container-identifiers</key><array><string>iCloud.io.bitrise.caf\xe9</string></array></dict></plist>"),
+			want: serialized.Object{"com.apple.developer.icloud-container-identifiers": []interface{}{"iCloud.io.bitrise.café"}},
+		},
+		{
+			name:    "legacy DOCTYPE without XML declaration",
+			content: []byte(`<!DOCTYPE plist SYSTEM "file://localhost/System/Library/DTDs/PropertyList.dtd"><plist version="0.9"><dict><key>aps-environment</key><string>development</string></dict></plist>`),
+			want:    serialized.Object{"aps-environment": "development"},
+		},
+		{
+			name:    "empty",
+			content: []byte(" \n"),
+			wantErr: true,
+		},
+		{
+			name:    "truncated XML",
+			content: []byte(xmlEntitlements[:len(xmlEntitlements)/2]),
+			wantErr: true,
+		},
+		{
+			name:    "not a dictionary",
+			content: []byte(`<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><array><string>development</string></array></plist>`),
+			wantErr: true,
+		},
+		{
+			name:    "truncated binary",
+			content: binaryEntitlements[:len(binaryEntitlements)-8],
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unmarshalPlist(tt.content)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
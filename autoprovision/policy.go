@@ -0,0 +1,215 @@
+package autoprovision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// PortalChangePolicy decides whether the Developer Portal changes of a run are allowed,
+// enabling organizations to enforce rules like "only bundle IDs under com.corp.* are allowed".
+type PortalChangePolicy interface {
+	// Check returns a PolicyViolationError if the changes are rejected,
+	// changes are the planned changes of the run, checked once before the first change is made.
+	Check(changes []PortalChange) error
+}
+
+// PolicyViolationError is returned when a policy rejects the Developer Portal changes
+type PolicyViolationError struct {
+	Policy  string
+	Changes []PortalChange
+	Reasons []string
+}
+
+func (e PolicyViolationError) Error() string {
+	s := fmt.Sprintf("%s rejected the %d Developer Portal change(s) of the run", e.Policy, len(e.Changes))
+	if len(e.Reasons) > 0 {
+		s += ": " + strings.Join(e.Reasons, ", ")
+	}
+	return s
+}
+
+// PolicyInput is the document the policies evaluate
+type PolicyInput struct {
+	Changes []PortalChange `json:"changes"`
+}
+
+func newPolicyInput(changes []PortalChange) PolicyInput {
+	if changes == nil {
+		changes = []PortalChange{}
+	}
+	return PolicyInput{Changes: changes}
+}
+
+// Policies checks the changes against every policy
+type Policies []PortalChangePolicy
+
+// Check ...
+func (p Policies) Check(changes []PortalChange) error {
+	for _, policy := range p {
+		if err := policy.Check(changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WebhookPolicy posts the PolicyInput to the URL, the changes are allowed if the endpoint responds with:
+// `{"allowed": true}`, otherwise they are rejected with the optional `reasons` of the response.
+// Any other response (like a non 2xx status code) fails the check, so that changes are not made unchecked.
+type WebhookPolicy struct {
+	URL   string
+	Token string
+
+	client *http.Client
+}
+
+// NewWebhookPolicy ...
+func NewWebhookPolicy(url, token string) WebhookPolicy {
+	return WebhookPolicy{
+		URL:    url,
+		Token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type webhookPolicyResponse struct {
+	Allowed *bool    `json:"allowed"`
+	Reasons []string `json:"reasons"`
+}
+
+// Check ...
+func (p WebhookPolicy) Check(changes []PortalChange) error {
+	body, err := json.Marshal(newPolicyInput(changes))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create policy webhook request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("policy webhook request failed: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close policy webhook response body: %s", err)
+		}
+	}()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read policy webhook response: %s", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("policy webhook responded with status code %d: %s", resp.StatusCode, strings.TrimSpace(string(content)))
+	}
+
+	var decision webhookPolicyResponse
+	if err := json.Unmarshal(content, &decision); err != nil || decision.Allowed == nil {
+		return fmt.Errorf("invalid policy webhook response, expected {\"allowed\": true|false, \"reasons\": [...]}: %s", strings.TrimSpace(string(content)))
+	}
+	if !*decision.Allowed {
+		return PolicyViolationError{Policy: "policy webhook", Changes: changes, Reasons: decision.Reasons}
+	}
+	return nil
+}
+
+// OPAPolicy evaluates a local Rego policy file by the Open Policy Agent CLI (opa), which needs to be installed.
+// The PolicyInput is the input document, the changes are rejected if the `data.autoprovision.deny` set
+// has any message, for example:
+//
+//	package autoprovision
+//
+//	deny[msg] {
+//		change := input.changes[_]
+//		change.action == "create_bundle_id"
+//		not startswith(change.subject, "com.corp.")
+//		msg := sprintf("bundle ID %s is not under com.corp.", [change.subject])
+//	}
+type OPAPolicy struct {
+	Path string
+	// Query is the evaluated rule, which has to be a set (or array) of messages
+	Query string
+}
+
+// OPADenyQuery is the default query of the OPAPolicy
+const OPADenyQuery = "data.autoprovision.deny"
+
+// NewOPAPolicy ...
+func NewOPAPolicy(pth string) OPAPolicy {
+	return OPAPolicy{Path: pth, Query: OPADenyQuery}
+}
+
+// Check ...
+func (p OPAPolicy) Check(changes []PortalChange) error {
+	input, err := json.Marshal(newPolicyInput(changes))
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("opa", "eval", "--format", "json", "--data", p.Path, "--stdin-input", p.Query)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to evaluate policy (%s): %s: %s", p.Path, err, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+
+	reasons, err := parseOPADenyResult(stdout.Bytes(), p.Query)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate policy (%s): %s", p.Path, err)
+	}
+	if len(reasons) > 0 {
+		return PolicyViolationError{Policy: fmt.Sprintf("policy (%s)", p.Path), Changes: changes, Reasons: reasons}
+	}
+	return nil
+}
+
+// parseOPADenyResult returns the deny messages of the `opa eval --format json` output.
+// An undefined result (the policy does not define the query, for example its package is mistyped) is an error,
+// the changes are not allowed by a misconfigured policy.
+func parseOPADenyResult(output []byte, query string) ([]string, error) {
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value interface{} `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("invalid opa output: %s", err)
+	}
+	if len(result.Result) == 0 {
+		return nil, fmt.Errorf("policy query %s is undefined", query)
+	}
+
+	var reasons []string
+	for _, r := range result.Result {
+		for _, expression := range r.Expressions {
+			values, ok := expression.Value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("policy result is not a set of messages: %v", expression.Value)
+			}
+			for _, value := range values {
+				reasons = append(reasons, fmt.Sprintf("%v", value))
+			}
+		}
+	}
+	return reasons, nil
+}
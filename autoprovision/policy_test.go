@@ -0,0 +1,124 @@
+package autoprovision
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPolicy_Check(t *testing.T) {
+	changes := []PortalChange{
+		{Action: RegisterDeviceChange, Subject: "udid"},
+		{Action: CreateBundleIDChange, Subject: "io.bitrise.app", BundleID: "io.bitrise.app"},
+	}
+
+	tests := []struct {
+		name       string
+		statusCode int
+		response   string
+		wantErr    string
+	}{
+		{
+			name:       "allowed",
+			statusCode: http.StatusOK,
+			response:   `{"allowed": true}`,
+		},
+		{
+			name:       "rejected",
+			statusCode: http.StatusOK,
+			response:   `{"allowed": false, "reasons": ["only com.corp.* bundle IDs are allowed"]}`,
+			wantErr:    "policy webhook rejected the 2 Developer Portal change(s) of the run: only com.corp.* bundle IDs are allowed",
+		},
+		{
+			name:       "server error",
+			statusCode: http.StatusInternalServerError,
+			response:   "internal error",
+			wantErr:    "policy webhook responded with status code 500: internal error",
+		},
+		{
+			name:       "missing decision",
+			statusCode: http.StatusOK,
+			response:   `{}`,
+			wantErr:    `invalid policy webhook response, expected {"allowed": true|false, "reasons": [...]}: {}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+				var input PolicyInput
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+				require.Equal(t, PolicyInput{Changes: changes}, input)
+
+				w.WriteHeader(tt.statusCode)
+				fmt.Fprint(w, tt.response)
+			}))
+			defer server.Close()
+
+			err := NewWebhookPolicy(server.URL, "token").Check(changes)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_parseOPADenyResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "denied",
+			output: `{"result":[{"expressions":[{"value":["bundle ID io.bitrise.app is not under com.corp."],"text":"data.autoprovision.deny"}]}]}`,
+			want:   []string{"bundle ID io.bitrise.app is not under com.corp."},
+		},
+		{
+			name:   "empty deny set",
+			output: `{"result":[{"expressions":[{"value":[],"text":"data.autoprovision.deny"}]}]}`,
+			want:   nil,
+		},
+		{
+			name:    "undefined",
+			output:  `{}`,
+			wantErr: true,
+		},
+		{
+			name:    "not a set",
+			output:  `{"result":[{"expressions":[{"value":true,"text":"data.autoprovision.deny"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid output",
+			output:  `error`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOPADenyResult([]byte(tt.output), OPADenyQuery)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_parseOPADenyResult_undefinedQuery(t *testing.T) {
+	// opa eval outputs an empty object if the policy's package does not define the query
+	_, err := parseOPADenyResult([]byte(`{}`), OPADenyQuery)
+	require.EqualError(t, err, "policy query data.autoprovision.deny is undefined")
+}
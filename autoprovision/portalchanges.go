@@ -0,0 +1,342 @@
+package autoprovision
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// PortalChangeAction is the kind of a change on the Developer Portal
+type PortalChangeAction string
+
+// PortalChangeActions ...
+const (
+	RegisterDeviceChange             PortalChangeAction = "register_device"
+	CreateBundleIDChange             PortalChangeAction = "create_bundle_id"
+	UpdateBundleIDCapabilitiesChange PortalChangeAction = "update_bundle_id_capabilities"
+	DisableBundleIDCapabilityChange  PortalChangeAction = "disable_bundle_id_capability"
+	CreateProfileChange              PortalChangeAction = "create_profile"
+	DeleteProfileChange              PortalChangeAction = "delete_profile"
+	DeleteExpiredProfileChange       PortalChangeAction = "delete_expired_profile"
+	CreateCertificateChange          PortalChangeAction = "create_certificate"
+)
+
+var portalChangeActionDescriptions = map[PortalChangeAction]string{
+	RegisterDeviceChange:             "register device",
+	CreateBundleIDChange:             "create app ID",
+	UpdateBundleIDCapabilitiesChange: "update capabilities of app ID",
+	DisableBundleIDCapabilityChange:  "disable capability of app ID",
+	CreateProfileChange:              "create profile",
+	DeleteProfileChange:              "delete profile",
+	DeleteExpiredProfileChange:       "delete expired profile",
+	CreateCertificateChange:          "create certificate",
+}
+
+// portalChangeKinds groups the actions in the plan of a dry run
+var portalChangeKinds = map[PortalChangeAction]string{
+	RegisterDeviceChange:             "create",
+	CreateBundleIDChange:             "create",
+	UpdateBundleIDCapabilitiesChange: "update",
+	DisableBundleIDCapabilityChange:  "update",
+	CreateProfileChange:              "create",
+	DeleteProfileChange:              "delete",
+	DeleteExpiredProfileChange:       "delete",
+	CreateCertificateChange:          "create",
+}
+
+// PortalChange is a change on the Developer Portal
+type PortalChange struct {
+	Action PortalChangeAction `json:"action"`
+	// Subject is the device UDID, the bundle ID identifier or the profile name
+	Subject string `json:"subject"`
+	// BundleID is the bundle ID identifier the app ID and profile changes belong to
+	BundleID string `json:"bundle_id,omitempty"`
+	// Reason is why the change is needed: the entitlement, input or Developer Portal state triggering it
+	Reason string `json:"reason,omitempty"`
+}
+
+// String ...
+func (c PortalChange) String() string {
+	description, ok := portalChangeActionDescriptions[c.Action]
+	if !ok {
+		description = string(c.Action)
+	}
+	return fmt.Sprintf("%s: %s", description, c.Subject)
+}
+
+// PortalChangeLimitError is returned when a change on the Developer Portal would exceed the maximum number of changes per run
+type PortalChangeLimitError struct {
+	Max     int
+	Applied []PortalChange
+	Refused PortalChange
+	// Planned are the changes of the run planned before the first change, if the plan exceeds the limit
+	Planned []PortalChange
+}
+
+func (e PortalChangeLimitError) Error() string {
+	var s strings.Builder
+	if len(e.Planned) > 0 {
+		s.WriteString(fmt.Sprintf("%d planned Developer Portal change(s) would exceed the limit of %d change(s) per run, no change is made:", len(e.Planned), e.Max))
+		for _, change := range e.Planned {
+			s.WriteString(fmt.Sprintf("\n- %s", change))
+		}
+		return s.String()
+	}
+
+	s.WriteString(fmt.Sprintf("Developer Portal changes would exceed the limit of %d change(s) per run:\n", e.Max))
+	for _, change := range e.Applied {
+		s.WriteString(fmt.Sprintf("- %s (applied)\n", change))
+	}
+	s.WriteString(fmt.Sprintf("- %s (refused)", e.Refused))
+	return s.String()
+}
+
+// PortalChanges tracks the changes made on the Developer Portal during a run
+// and enforces a maximum number of changes and the change policy, protecting against misconfigurations.
+type PortalChanges struct {
+	// Max is the maximum number of changes allowed per run, 0 means unlimited
+	Max     int
+	Changes []PortalChange
+	// Policy is checked once on the planned changes (CheckPlan), nil allows all changes
+	Policy PortalChangePolicy
+	// Actor returns who makes the changes (the API key ID the client authorizes the requests with), it is recorded in the audit log.
+	// It is called when the outcome of a change is recorded, the API key changes after a failover to the secondary key.
+	Actor func() string
+	Audit []AuditEntry
+	// DryRun only plans the changes (dry_run input): the registered changes are not made, nor recorded in the audit log
+	DryRun bool
+
+	// planned are the changes the policy allowed by CheckPlan
+	planned map[portalChangeKey]bool
+	// mu serializes the changes registered by the profiles ensured in parallel
+	mu sync.Mutex
+}
+
+// portalChangeKey identifies a change of the plan, the reason of a change is not compared
+type portalChangeKey struct {
+	Action   PortalChangeAction
+	Subject  string
+	BundleID string
+}
+
+func newPortalChangeKey(change PortalChange) portalChangeKey {
+	return portalChangeKey{Action: change.Action, Subject: change.Subject, BundleID: change.BundleID}
+}
+
+// AuditOutcome is the outcome of a change made on the Developer Portal
+type AuditOutcome string
+
+// AuditOutcomes ...
+const (
+	ChangeSucceeded AuditOutcome = "succeeded"
+	ChangeFailed    AuditOutcome = "failed"
+)
+
+// AuditEntry records a change made on the Developer Portal and its outcome, for auditing the CI access to the Apple account
+type AuditEntry struct {
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	PortalChange
+	Outcome AuditOutcome `json:"outcome"`
+	// Error is the failure of the change
+	Error string `json:"error,omitempty"`
+}
+
+// NewPortalChanges ...
+func NewPortalChanges(max int) *PortalChanges {
+	return &PortalChanges{Max: max}
+}
+
+// Register records a change, which is about to be made on the Developer Portal.
+// It returns a PortalChangeLimitError if the change would exceed the maximum number of changes,
+// and a PolicyViolationError if the policy rejects a change missing from the checked plan.
+// Registering on a nil PortalChanges is a no-op.
+func (c *PortalChanges) Register(change PortalChange) error {
+	if c == nil {
+		return nil
+	}
+
+	if err := c.checkUnplannedChange(change); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Max > 0 && len(c.Changes) >= c.Max {
+		return PortalChangeLimitError{
+			Max:     c.Max,
+			Applied: append([]PortalChange{}, c.Changes...),
+			Refused: change,
+		}
+	}
+
+	c.Changes = append(c.Changes, change)
+
+	if c.DryRun {
+		if b, err := json.Marshal(change); err == nil {
+			log.Printf("plan: %s", b)
+		}
+	}
+	return nil
+}
+
+// RecordOutcome records a registered change in the audit log after it was made, err is the failure of the change.
+// Recording on a nil PortalChanges or in a dry run is a no-op.
+func (c *PortalChanges) RecordOutcome(change PortalChange, err error) {
+	if c == nil || c.DryRun {
+		return
+	}
+
+	entry := AuditEntry{Time: time.Now().UTC(), PortalChange: change, Outcome: ChangeSucceeded}
+	if c.Actor != nil {
+		entry.Actor = c.Actor()
+	}
+	if err != nil {
+		entry.Outcome = ChangeFailed
+		entry.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	c.Audit = append(c.Audit, entry)
+	c.mu.Unlock()
+
+	if b, err := json.Marshal(entry); err == nil {
+		log.Printf("audit: %s", b)
+	}
+}
+
+// checkUnplannedChange checks a change missing from the plan allowed by CheckPlan against the policy,
+// together with the changes made before. The plan is an estimate: for example the profiles of the devices registered
+// in the run are planned without the devices. The policy is evaluated without holding the lock of the changes.
+func (c *PortalChanges) checkUnplannedChange(change PortalChange) error {
+	if c.Policy == nil || c.DryRun {
+		return nil
+	}
+
+	c.mu.Lock()
+	planned := c.planned[newPortalChangeKey(change)]
+	changes := append(append([]PortalChange{}, c.Changes...), change)
+	c.mu.Unlock()
+
+	if planned {
+		return nil
+	}
+	return c.Policy.Check(changes)
+}
+
+// NeedsPlan reports whether the changes of the run have to be planned before the first change is made,
+// so that the run fails before making any change, instead of stopping halfway at the limit or at a rejected change.
+func (c *PortalChanges) NeedsPlan() bool {
+	return c != nil && !c.DryRun && (c.Max > 0 || c.Policy != nil)
+}
+
+// CheckPlan returns a PortalChangeLimitError if the planned changes exceed the maximum number of changes,
+// and a PolicyViolationError if the policy rejects them. The policy is evaluated once, on the full plan.
+// The limit is still enforced by Register, the Developer Portal might change between the plan and the changes.
+func (c *PortalChanges) CheckPlan(planned []PortalChange) error {
+	if c == nil {
+		return nil
+	}
+	if c.Max > 0 && len(planned) > c.Max {
+		return PortalChangeLimitError{Max: c.Max, Planned: append([]PortalChange{}, planned...)}
+	}
+	if c.Policy == nil {
+		return nil
+	}
+
+	if err := c.Policy.Check(append([]PortalChange{}, planned...)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.planned = map[portalChangeKey]bool{}
+	for _, change := range planned {
+		c.planned[newPortalChangeKey(change)] = true
+	}
+	return nil
+}
+
+// planCopy returns the dry run copy of the changes planning the changes of the run,
+// the limit and the policy are checked on the full plan (CheckPlan) instead of the planned changes one by one.
+func (c *PortalChanges) planCopy() *PortalChanges {
+	planned := &PortalChanges{DryRun: true}
+	if c != nil {
+		planned.Changes = append(planned.Changes, c.Changes...)
+	}
+	return planned
+}
+
+// IsDryRun reports whether the registered changes are only planned, the caller must not make them.
+// A nil PortalChanges is not a dry run.
+func (c *PortalChanges) IsDryRun() bool {
+	return c != nil && c.DryRun
+}
+
+// AuditEntries returns a copy of the audit log of the changes made.
+// A nil PortalChanges (offline run) has no audit log.
+func (c *PortalChanges) AuditEntries() []AuditEntry {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]AuditEntry{}, c.Audit...)
+}
+
+// Plan returns the registered changes grouped by kind (create, update, delete), in the order of registration,
+// a line per change with its bundle ID and reason.
+func (c *PortalChanges) Plan() string {
+	if c == nil || len(c.Changes) == 0 {
+		return "no changes"
+	}
+
+	var s strings.Builder
+	for _, kind := range []string{"create", "update", "delete"} {
+		var lines []string
+		for _, change := range c.Changes {
+			if portalChangeKinds[change.Action] != kind {
+				continue
+			}
+			line := "- " + change.String()
+			if change.BundleID != "" && change.BundleID != change.Subject {
+				line += fmt.Sprintf(" (bundle ID: %s)", change.BundleID)
+			}
+			if change.Reason != "" {
+				line += ", reason: " + change.Reason
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		if s.Len() > 0 {
+			s.WriteString("\n")
+		}
+		s.WriteString(kind + ":\n" + strings.Join(lines, "\n"))
+	}
+	return s.String()
+}
+
+// WriteAuditLog writes the audit entries of the run as a JSON array to the given path.
+func (c *PortalChanges) WriteAuditLog(pth string) error {
+	entries := []AuditEntry{}
+	if c != nil {
+		entries = append(entries, c.Audit...)
+	}
+
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize audit log: %s", err)
+	}
+	if err := writeFileAtomic(pth, content); err != nil {
+		return fmt.Errorf("failed to write audit log (%s): %s", pth, err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package autoprovision
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type rejectingPolicy struct {
+	action PortalChangeAction
+	// checks counts the evaluations of the policy
+	checks *int
+}
+
+func (p rejectingPolicy) Check(changes []PortalChange) error {
+	if p.checks != nil {
+		*p.checks++
+	}
+	for _, change := range changes {
+		if change.Action == p.action {
+			return PolicyViolationError{Policy: "test policy", Changes: changes, Reasons: []string{"not allowed"}}
+		}
+	}
+	return nil
+}
+
+func TestPortalChanges_Register(t *testing.T) {
+	createBundleID := PortalChange{Action: CreateBundleIDChange, Subject: "io.bitrise.app", BundleID: "io.bitrise.app"}
+	createProfile := PortalChange{Action: CreateProfileChange, Subject: "Bitrise iOS development - (io.bitrise.app)", BundleID: "io.bitrise.app"}
+	registerDevice := PortalChange{Action: RegisterDeviceChange, Subject: "udid"}
+
+	tests := []struct {
+		name        string
+		max         int
+		policy      PortalChangePolicy
+		changes     []PortalChange
+		wantChanges []PortalChange
+		wantErr     string
+	}{
+		{
+			name:        "unlimited",
+			max:         0,
+			changes:     []PortalChange{createBundleID, createProfile, registerDevice},
+			wantChanges: []PortalChange{createBundleID, createProfile, registerDevice},
+		},
+		{
+			name:        "within limit",
+			max:         2,
+			changes:     []PortalChange{createBundleID, createProfile},
+			wantChanges: []PortalChange{createBundleID, createProfile},
+		},
+		{
+			name:        "exceeds limit",
+			max:         1,
+			changes:     []PortalChange{createBundleID, createProfile},
+			wantChanges: []PortalChange{createBundleID},
+			wantErr:     "Developer Portal changes would exceed the limit of 1 change(s) per run:\n- create app ID: io.bitrise.app (applied)\n- create profile: Bitrise iOS development - (io.bitrise.app) (refused)",
+		},
+		{
+			name:        "rejected by policy",
+			policy:      rejectingPolicy{action: CreateProfileChange},
+			changes:     []PortalChange{createBundleID, createProfile},
+			wantChanges: []PortalChange{createBundleID},
+			wantErr:     "test policy rejected the 2 Developer Portal change(s) of the run: not allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewPortalChanges(tt.max)
+			c.Policy = tt.policy
+
+			var err error
+			for _, change := range tt.changes {
+				if err = c.Register(change); err != nil {
+					break
+				}
+			}
+
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Register() unexpected error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("Register() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(c.Changes, tt.wantChanges) {
+				t.Errorf("Changes = %v, want %v", c.Changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func TestPolicies_Check(t *testing.T) {
+	policies := Policies{rejectingPolicy{action: RegisterDeviceChange}, rejectingPolicy{action: DeleteProfileChange}}
+
+	if err := policies.Check([]PortalChange{{Action: CreateProfileChange}}); err != nil {
+		t.Errorf("Check() unexpected error = %v", err)
+	}
+
+	err := policies.Check([]PortalChange{{Action: CreateProfileChange}, {Action: DeleteProfileChange}})
+	var violation PolicyViolationError
+	if !errors.As(err, &violation) {
+		t.Errorf("Check() error = %v, want PolicyViolationError", err)
+	}
+}
+
+func TestPortalChanges_WriteAuditLog(t *testing.T) {
+	c := NewPortalChanges(1)
+	actor := "key-id"
+	c.Actor = func() string { return actor }
+
+	createBundleID := PortalChange{Action: CreateBundleIDChange, Subject: "io.bitrise.app", BundleID: "io.bitrise.app", Reason: "no app ID found"}
+	if err := c.Register(createBundleID); err != nil {
+		t.Fatalf("Register() unexpected error = %v", err)
+	}
+	if entries := c.AuditEntries(); len(entries) != 0 {
+		t.Fatalf("AuditEntries() = %v, want no entry before the change is made", entries)
+	}
+	// the secondary key makes the change after a failover
+	actor = "secondary-key-id"
+	c.RecordOutcome(createBundleID, errors.New("timeout"))
+	if err := c.Register(PortalChange{Action: CreateProfileChange, Subject: "Bitrise iOS development - (io.bitrise.app)"}); err == nil {
+		t.Fatalf("Register() expected limit error")
+	}
+
+	pth := filepath.Join(t.TempDir(), "audit.json")
+	if err := c.WriteAuditLog(pth); err != nil {
+		t.Fatalf("WriteAuditLog() unexpected error = %v", err)
+	}
+
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		t.Fatalf("invalid audit log: %s", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("audit log = %s, want only the applied change", content)
+	}
+	want := map[string]interface{}{"actor": "secondary-key-id", "action": "create_bundle_id", "subject": "io.bitrise.app", "bundle_id": "io.bitrise.app", "reason": "no app ID found", "outcome": "failed", "error": "timeout"}
+	for key, value := range want {
+		if entries[0][key] != value {
+			t.Errorf("audit entry %s = %v, want %v", key, entries[0][key], value)
+		}
+	}
+	if _, ok := entries[0]["time"]; !ok {
+		t.Errorf("audit entry has no time: %s", content)
+	}
+}
+
+func TestPortalChanges_DryRun(t *testing.T) {
+	c := NewPortalChanges(0)
+	c.DryRun = true
+
+	changes := []PortalChange{
+		{Action: DeleteProfileChange, Subject: "Bitrise iOS development - (io.bitrise.app)", BundleID: "io.bitrise.app", Reason: "profile expired"},
+		{Action: CreateBundleIDChange, Subject: "io.bitrise.app", BundleID: "io.bitrise.app", Reason: "no app ID found"},
+		{Action: UpdateBundleIDCapabilitiesChange, Subject: "io.bitrise.app", BundleID: "io.bitrise.app"},
+		{Action: RegisterDeviceChange, Subject: "udid"},
+	}
+	for _, change := range changes {
+		if err := c.Register(change); err != nil {
+			t.Fatalf("Register() unexpected error = %v", err)
+		}
+	}
+
+	if !c.IsDryRun() {
+		t.Errorf("IsDryRun() = false, want true")
+	}
+	if len(c.Audit) != 0 {
+		t.Errorf("Audit = %v, want no entries for the planned changes", c.Audit)
+	}
+
+	want := `create:
+- create app ID: io.bitrise.app, reason: no app ID found
+- register device: udid
+update:
+- update capabilities of app ID: io.bitrise.app
+delete:
+- delete profile: Bitrise iOS development - (io.bitrise.app) (bundle ID: io.bitrise.app), reason: profile expired`
+	if got := c.Plan(); got != want {
+		t.Errorf("Plan() = %s, want %s", got, want)
+	}
+
+	var nilChanges *PortalChanges
+	if nilChanges.IsDryRun() {
+		t.Errorf("IsDryRun() of nil PortalChanges = true, want false")
+	}
+	if got := nilChanges.Plan(); got != "no changes" {
+		t.Errorf("Plan() of nil PortalChanges = %s, want no changes", got)
+	}
+	if got := nilChanges.AuditEntries(); got != nil {
+		t.Errorf("AuditEntries() of nil PortalChanges = %v, want nil", got)
+	}
+}
+
+func TestPortalChanges_CheckPlan(t *testing.T) {
+	planned := []PortalChange{
+		{Action: CreateBundleIDChange, Subject: "io.bitrise.app", BundleID: "io.bitrise.app"},
+		{Action: RegisterDeviceChange, Subject: "udid"},
+	}
+
+	c := NewPortalChanges(2)
+	if !c.NeedsPlan() {
+		t.Errorf("NeedsPlan() = false, want true with a limit")
+	}
+	if err := c.CheckPlan(planned); err != nil {
+		t.Errorf("CheckPlan() unexpected error = %v", err)
+	}
+
+	c = NewPortalChanges(1)
+	err := c.CheckPlan(planned)
+	want := `2 planned Developer Portal change(s) would exceed the limit of 1 change(s) per run, no change is made:
+- create app ID: io.bitrise.app
+- register device: udid`
+	if err == nil || err.Error() != want {
+		t.Errorf("CheckPlan() error = %v, want %s", err, want)
+	}
+	if len(c.Changes) != 0 {
+		t.Errorf("Changes = %v, want no changes registered by the plan check", c.Changes)
+	}
+
+	if NewPortalChanges(0).NeedsPlan() {
+		t.Errorf("NeedsPlan() = true, want false without a limit")
+	}
+	c.DryRun = true
+	if c.NeedsPlan() {
+		t.Errorf("NeedsPlan() = true, want false in a dry run")
+	}
+}
+
+func TestPortalChanges_CheckPlan_policy(t *testing.T) {
+	createBundleID := PortalChange{Action: CreateBundleIDChange, Subject: "io.bitrise.app", BundleID: "io.bitrise.app"}
+	createProfile := PortalChange{Action: CreateProfileChange, Subject: "Bitrise iOS development - (io.bitrise.app)", BundleID: "io.bitrise.app"}
+	deleteProfile := PortalChange{Action: DeleteProfileChange, Subject: "Bitrise iOS development - (io.bitrise.app)", BundleID: "io.bitrise.app"}
+
+	checks := 0
+	c := NewPortalChanges(0)
+	c.Policy = rejectingPolicy{action: DeleteProfileChange, checks: &checks}
+	if !c.NeedsPlan() {
+		t.Errorf("NeedsPlan() = false, want true with a policy")
+	}
+
+	err := c.CheckPlan([]PortalChange{createBundleID, deleteProfile})
+	var violation PolicyViolationError
+	if !errors.As(err, &violation) || len(violation.Changes) != 2 {
+		t.Fatalf("CheckPlan() error = %v, want PolicyViolationError of the plan", err)
+	}
+
+	checks = 0
+	if err := c.CheckPlan([]PortalChange{createBundleID, createProfile}); err != nil {
+		t.Fatalf("CheckPlan() unexpected error = %v", err)
+	}
+	for _, change := range []PortalChange{createBundleID, createProfile} {
+		change.Reason = "the reason is not compared"
+		if err := c.Register(change); err != nil {
+			t.Fatalf("Register() unexpected error = %v", err)
+		}
+	}
+	if checks != 1 {
+		t.Errorf("policy checked %d times, want once for the plan", checks)
+	}
+
+	// a change missing from the plan is checked on its own
+	if err := c.Register(deleteProfile); !errors.As(err, &violation) {
+		t.Errorf("Register() error = %v, want PolicyViolationError of the unplanned change", err)
+	}
+	if checks != 2 {
+		t.Errorf("policy checked %d times, want twice", checks)
+	}
+}
@@ -0,0 +1,94 @@
+package autoprovision
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// RunReport is the JSON report of a run, the post-run hooks receive: the provisioned certificates and profiles,
+// the Developer Portal changes made and the exported outputs.
+type RunReport struct {
+	SigningHistoryRecord
+	DistributionType DistributionType  `json:"distribution_type"`
+	PortalChanges    []AuditEntry      `json:"portal_changes"`
+	Outputs          map[string]string `json:"outputs"`
+}
+
+// WriteRunReport writes the report as JSON to the path, the lists are in a stable order and empty instead of null.
+func WriteRunReport(pth string, report RunReport) error {
+	report.SigningHistoryRecord = report.SigningHistoryRecord.sorted()
+	if report.PortalChanges == nil {
+		report.PortalChanges = []AuditEntry{}
+	}
+	if report.Outputs == nil {
+		report.Outputs = map[string]string{}
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize run report: %s", err)
+	}
+	if err := writeFileAtomic(pth, content); err != nil {
+		return fmt.Errorf("failed to write run report (%s): %s", pth, err)
+	}
+	return nil
+}
+
+// ParsePostRunHooks returns the hook commands of the post_run_hooks input, a command per line,
+// the empty lines and the lines starting with # are skipped.
+func ParsePostRunHooks(hooks string) []string {
+	var commands []string
+	for _, line := range strings.Split(hooks, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	return commands
+}
+
+// PostRunHookError is returned when a post-run hook fails, the hooks after it do not run
+type PostRunHookError struct {
+	Index   int
+	Command string
+	Err     error
+}
+
+func (e PostRunHookError) Error() string {
+	return fmt.Sprintf("post-run hook #%d (%s) failed: %s", e.Index+1, e.Command, e.Err)
+}
+
+// RunPostRunHooks runs the hook commands by the shell, one after the other, with the given environment variables
+// added to the environment of the Step. The hooks form a chain: a failing hook stops the chain and its error is returned.
+func RunPostRunHooks(commands []string, envs map[string]string) error {
+	var keys []string
+	for key := range envs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	environ := os.Environ()
+	for _, key := range keys {
+		environ = append(environ, key+"="+envs[key])
+	}
+
+	for i, command := range commands {
+		log.Printf("$ %s", command)
+
+		cmd := exec.Command("/bin/sh", "-c", command)
+		cmd.Env = environ
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return PostRunHookError{Index: i, Command: command, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package autoprovision
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePostRunHooks(t *testing.T) {
+	hooks := "\n./sync-profiles-to-mdm.sh\n  # notify the team\n  curl -X POST https://example.com/hook  \n\n"
+	require.Equal(t, []string{"./sync-profiles-to-mdm.sh", "curl -X POST https://example.com/hook"}, ParsePostRunHooks(hooks))
+	require.Nil(t, ParsePostRunHooks(""))
+}
+
+func TestRunPostRunHooks(t *testing.T) {
+	dir := t.TempDir()
+	reportPath := filepath.Join(dir, "report.json")
+	require.NoError(t, WriteRunReport(reportPath, RunReport{
+		SigningHistoryRecord: SigningHistoryRecord{TeamID: "TEAM123"},
+		DistributionType:     AppStore,
+	}))
+
+	content, err := ioutil.ReadFile(reportPath)
+	require.NoError(t, err)
+	var report map[string]interface{}
+	require.NoError(t, json.Unmarshal(content, &report))
+	require.Equal(t, "TEAM123", report["team_id"])
+	require.Equal(t, "app-store", report["distribution_type"])
+	require.Equal(t, []interface{}{}, report["portal_changes"])
+
+	outPath := filepath.Join(dir, "out.txt")
+	envs := map[string]string{"REPORT_PATH": reportPath, "OUT_PATH": outPath}
+	require.NoError(t, RunPostRunHooks([]string{
+		`grep -q TEAM123 "$REPORT_PATH" && echo first > "$OUT_PATH"`,
+		`echo second >> "$OUT_PATH"`,
+	}, envs))
+	out, err := ioutil.ReadFile(outPath)
+	require.NoError(t, err)
+	require.Equal(t, "first\nsecond\n", string(out))
+
+	err = RunPostRunHooks([]string{`echo first > "$OUT_PATH"`, "exit 3", `echo third >> "$OUT_PATH"`}, envs)
+	require.Error(t, err)
+	hookErr, ok := err.(PostRunHookError)
+	require.True(t, ok)
+	require.Equal(t, 1, hookErr.Index)
+	out, err = ioutil.ReadFile(outPath)
+	require.NoError(t, err)
+	require.Equal(t, "first\n", string(out), "the hooks after the failing one do not run")
+}
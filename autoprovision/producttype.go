@@ -0,0 +1,185 @@
+package autoprovision
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+)
+
+// TargetKind is the class of a target by its product type (PRODUCT_TYPE), the provisioning decisions are based on it
+type TargetKind string
+
+// TargetKinds ...
+const (
+	AppTarget          TargetKind = "app"
+	AppExtensionTarget TargetKind = "app-extension"
+	WatchAppTarget     TargetKind = "watch-app"
+	AppClipTarget      TargetKind = "app-clip"
+	UnitTestTarget     TargetKind = "unit-test"
+	UITestTarget       TargetKind = "ui-test"
+	FrameworkTarget    TargetKind = "framework"
+	OtherTarget        TargetKind = "other"
+)
+
+const (
+	// appClipProductType is the product type of the App Clip targets, embedded in the host app
+	appClipProductType = "com.apple.product-type.application.on-demand-install-capable"
+	// frameworkProductType is the product type of the dynamic frameworks, signed without a provisioning profile
+	frameworkProductType = "com.apple.product-type.framework"
+)
+
+// productTypeKinds classifies the known product types
+var productTypeKinds = map[string]TargetKind{
+	"com.apple.product-type.application":                     AppTarget,
+	"com.apple.product-type.application.messages":            AppTarget,
+	"com.apple.product-type.application.watchapp2-container": AppTarget,
+	"com.apple.product-type.application.watchapp":            WatchAppTarget,
+	"com.apple.product-type.application.watchapp2":           WatchAppTarget,
+	appClipProductType:                                           AppClipTarget,
+	"com.apple.product-type.app-extension":                       AppExtensionTarget,
+	"com.apple.product-type.app-extension.messages":              AppExtensionTarget,
+	"com.apple.product-type.app-extension.messages-sticker-pack": AppExtensionTarget,
+	"com.apple.product-type.watchkit2-extension":                 AppExtensionTarget,
+	"com.apple.product-type.tv-app-extension":                    AppExtensionTarget,
+	"com.apple.product-type.extensionkit-extension":              AppExtensionTarget,
+	"com.apple.product-type.bundle.unit-test":                    UnitTestTarget,
+	"com.apple.product-type.bundle.ui-testing":                   UITestTarget,
+	frameworkProductType:                                         FrameworkTarget,
+}
+
+// excludableTargetKinds are the target kinds, which can be excluded from the provisioning (exclude_target_types input),
+// the main target is always provisioned
+var excludableTargetKinds = []TargetKind{AppExtensionTarget, WatchAppTarget, AppClipTarget}
+
+// NeedsProfile reports whether the targets of the kind are signed with a provisioning profile, so they need an app ID and a profile
+func (k TargetKind) NeedsProfile() bool {
+	switch k {
+	case AppTarget, AppExtensionTarget, WatchAppTarget, AppClipTarget:
+		return true
+	default:
+		return false
+	}
+}
+
+// ProductTypeKind returns the kind of the product type. The unknown product types (like the ones newer than the Step)
+// are classified by their prefix: the app extension and application product types are numerous, the rest is OtherTarget.
+// Other *-extension product types are not all app extensions (kernel-extension is a kext), ClassifyTarget checks their .appex product.
+func ProductTypeKind(productType string) TargetKind {
+	if kind, ok := productTypeKinds[productType]; ok {
+		return kind
+	}
+	switch {
+	case strings.HasPrefix(productType, "com.apple.product-type.app-extension"):
+		return AppExtensionTarget
+	case strings.HasPrefix(productType, "com.apple.product-type.application"):
+		return AppTarget
+	default:
+		return OtherTarget
+	}
+}
+
+// ClassifyTarget returns the kind of the target by its product type. A target without a product type of a known kind
+// is classified by its product (.app or .appex), an app bundle with APPLICATION_EXTENSION_API_ONLY = YES in its build settings
+// is an app extension (packaged by a custom build system), as the apps are not restricted to the extension safe API.
+// The settings can be nil.
+func ClassifyTarget(target xcodeproj.Target, settings serialized.Object) TargetKind {
+	if kind := ProductTypeKind(target.ProductType); kind != OtherTarget {
+		return kind
+	}
+
+	switch filepath.Ext(target.ProductReference.Path) {
+	case ".appex":
+		return AppExtensionTarget
+	case ".app":
+		if extensionAPIOnly, err := settings.String("APPLICATION_EXTENSION_API_ONLY"); err == nil && strings.EqualFold(extensionAPIOnly, "YES") {
+			return AppExtensionTarget
+		}
+		return AppTarget
+	default:
+		return OtherTarget
+	}
+}
+
+// targetKind returns the kind of the target, the build settings are read only if the product type does not decide it
+func (p *ProjectHelper) targetKind(target xcodeproj.Target) TargetKind {
+	if ProductTypeKind(target.ProductType) != OtherTarget || filepath.Ext(target.ProductReference.Path) != ".app" {
+		return ClassifyTarget(target, nil)
+	}
+	settings, err := p.targetBuildSettings(target.Name, p.Configuration)
+	if err != nil {
+		settings = nil
+	}
+	return ClassifyTarget(target, settings)
+}
+
+// dependentTargets returns the dependent targets of the main target signed with a provisioning profile, without duplicates.
+// The dependencies of the targets not signed with a profile (like the frameworks) are not followed,
+// neither are the dependencies of the excluded target kinds (for example the extension of an excluded watch app).
+func (p *ProjectHelper) dependentTargets() []xcodeproj.Target {
+	var targets []xcodeproj.Target
+	seen := map[string]bool{targetKey(p.MainTarget): true}
+
+	var visit func(target xcodeproj.Target)
+	visit = func(target xcodeproj.Target) {
+		for _, dependency := range target.Dependencies {
+			dependent := dependency.Target
+			if seen[targetKey(dependent)] {
+				continue
+			}
+			seen[targetKey(dependent)] = true
+
+			kind := p.targetKind(dependent)
+			if !kind.NeedsProfile() || p.ExcludedTargetKinds[kind] {
+				continue
+			}
+			targets = append(targets, dependent)
+			visit(dependent)
+		}
+	}
+	visit(p.MainTarget)
+
+	return targets
+}
+
+// targetKey identifies the target in the dependency graph
+func targetKey(target xcodeproj.Target) string {
+	if target.ID != "" {
+		return target.ID
+	}
+	return target.Name
+}
+
+// ParseTargetKinds parses the comma separated target kinds of the exclude_target_types input,
+// only the kinds of the dependent targets signed with a profile can be excluded.
+func ParseTargetKinds(list string) (map[TargetKind]bool, error) {
+	kinds := map[TargetKind]bool{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		kind := TargetKind(item)
+		valid := false
+		for _, excludable := range excludableTargetKinds {
+			if kind == excludable {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			var names []string
+			for _, excludable := range excludableTargetKinds {
+				names = append(names, string(excludable))
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("invalid target type: %s, available: %s", item, strings.Join(names, ", "))
+		}
+		kinds[kind] = true
+	}
+	return kinds, nil
+}
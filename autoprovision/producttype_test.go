@@ -0,0 +1,103 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProductTypeKind(t *testing.T) {
+	tests := map[string]TargetKind{
+		"com.apple.product-type.application":                           AppTarget,
+		"com.apple.product-type.application.watchapp2":                 WatchAppTarget,
+		"com.apple.product-type.application.on-demand-install-capable": AppClipTarget,
+		"com.apple.product-type.app-extension":                         AppExtensionTarget,
+		"com.apple.product-type.watchkit2-extension":                   AppExtensionTarget,
+		"com.apple.product-type.app-extension.intents-service":         AppExtensionTarget,
+		"com.apple.product-type.bundle.unit-test":                      UnitTestTarget,
+		"com.apple.product-type.bundle.ui-testing":                     UITestTarget,
+		"com.apple.product-type.framework":                             FrameworkTarget,
+		"com.apple.product-type.framework.static":                      OtherTarget,
+		"com.apple.product-type.kernel-extension":                      OtherTarget,
+	}
+	for productType, want := range tests {
+		require.Equal(t, want, ProductTypeKind(productType), productType)
+	}
+	require.Equal(t, OtherTarget, ProductTypeKind(""))
+
+	require.True(t, AppClipTarget.NeedsProfile())
+	require.False(t, FrameworkTarget.NeedsProfile())
+	require.False(t, UnitTestTarget.NeedsProfile())
+}
+
+func TestClassifyTarget(t *testing.T) {
+	extensionAPIOnly := serialized.Object{"APPLICATION_EXTENSION_API_ONLY": "YES"}
+
+	require.Equal(t, AppExtensionTarget, ClassifyTarget(xcodeproj.Target{ProductType: "com.apple.product-type.app-extension"}, nil))
+	require.Equal(t, AppTarget, ClassifyTarget(xcodeproj.Target{ProductType: "com.apple.product-type.application"}, extensionAPIOnly), "the product type decides")
+	require.Equal(t, AppExtensionTarget, ClassifyTarget(xcodeproj.Target{ProductReference: xcodeproj.ProductReference{Path: "Widget.appex"}}, nil))
+	require.Equal(t, AppTarget, ClassifyTarget(xcodeproj.Target{ProductReference: xcodeproj.ProductReference{Path: "App.app"}}, nil))
+	require.Equal(t, AppExtensionTarget, ClassifyTarget(xcodeproj.Target{ProductReference: xcodeproj.ProductReference{Path: "Widget.app"}}, extensionAPIOnly))
+	require.Equal(t, OtherTarget, ClassifyTarget(xcodeproj.Target{ProductReference: xcodeproj.ProductReference{Path: "libStatic.a"}}, nil))
+	require.Equal(t, OtherTarget, ClassifyTarget(xcodeproj.Target{ProductType: "com.apple.product-type.kernel-extension", ProductReference: xcodeproj.ProductReference{Path: "Driver.kext"}}, nil))
+	require.Equal(t, AppExtensionTarget, ClassifyTarget(xcodeproj.Target{ProductType: "com.apple.product-type.future-extension", ProductReference: xcodeproj.ProductReference{Path: "Future.appex"}}, nil))
+}
+
+func TestProjectHelper_dependentTargets(t *testing.T) {
+	// the framework's dependency is not followed, even if it is an app extension
+	frameworkExtension := xcodeproj.Target{ID: "FRAMEWORK_EXTENSION", Name: "FrameworkExtension", ProductType: "com.apple.product-type.app-extension"}
+	framework := xcodeproj.Target{ID: "FRAMEWORK", Name: "Framework", ProductType: "com.apple.product-type.framework", Dependencies: []xcodeproj.TargetDependency{{Target: frameworkExtension}}}
+	watchExtension := xcodeproj.Target{ID: "WATCH_EXTENSION", Name: "WatchExtension", ProductType: "com.apple.product-type.watchkit2-extension"}
+	watchApp := xcodeproj.Target{ID: "WATCH", Name: "Watch", ProductType: "com.apple.product-type.application.watchapp2", Dependencies: []xcodeproj.TargetDependency{{Target: watchExtension}}}
+	// built by a custom build system, classified by its build settings
+	customExtension := xcodeproj.Target{ID: "CUSTOM", Name: "Custom", ProductReference: xcodeproj.ProductReference{Path: "Custom.app"}}
+	extension := xcodeproj.Target{ID: "EXTENSION", Name: "Extension", ProductType: "com.apple.product-type.app-extension", Dependencies: []xcodeproj.TargetDependency{{Target: framework}}}
+	tests := xcodeproj.Target{ID: "TESTS", Name: "Tests", ProductType: "com.apple.product-type.bundle.unit-test"}
+
+	newProjectHelper := func() ProjectHelper {
+		return ProjectHelper{
+			MainTarget: xcodeproj.Target{
+				ID:          "APP",
+				Name:        "App",
+				ProductType: "com.apple.product-type.application",
+				Dependencies: []xcodeproj.TargetDependency{
+					{Target: framework}, {Target: extension}, {Target: extension}, {Target: watchApp}, {Target: customExtension}, {Target: tests},
+				},
+			},
+			Configuration: "Release",
+			buildSettingsCache: map[string]map[string]serialized.Object{
+				"Custom": {"Release": {"APPLICATION_EXTENSION_API_ONLY": "YES"}},
+			},
+		}
+	}
+	names := func(targets []xcodeproj.Target) []string {
+		var names []string
+		for _, target := range targets {
+			names = append(names, target.Name)
+		}
+		return names
+	}
+
+	p := newProjectHelper()
+	require.Equal(t, []string{"Extension", "Watch", "WatchExtension", "Custom"}, names(p.dependentTargets()))
+	require.Equal(t, AppExtensionTarget, p.targetKind(customExtension))
+
+	p = newProjectHelper()
+	p.ExcludedTargetKinds = map[TargetKind]bool{WatchAppTarget: true}
+	require.Equal(t, []string{"Extension", "Custom"}, names(p.dependentTargets()), "the watch app's extension is excluded too")
+}
+
+func TestParseTargetKinds(t *testing.T) {
+	kinds, err := ParseTargetKinds("")
+	require.NoError(t, err)
+	require.Empty(t, kinds)
+
+	kinds, err = ParseTargetKinds(" watch-app, app-clip ,")
+	require.NoError(t, err)
+	require.Equal(t, map[TargetKind]bool{WatchAppTarget: true, AppClipTarget: true}, kinds)
+
+	_, err = ParseTargetKinds("framework")
+	require.EqualError(t, err, "invalid target type: framework, available: app-clip, app-extension, watch-app")
+}
@@ -0,0 +1,119 @@
+package autoprovision
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/bitrise-io/go-xcode/plistutil"
+	"github.com/bitrise-io/go-xcode/profileutil"
+)
+
+// profileCacheSize is the number of decoded profiles kept in memory,
+// enough for the profiles of the targets of every distribution type in a run.
+const profileCacheSize = 64
+
+// decodedProfile is the payload of a provisioning profile, decoded from its CMS (pkcs7) signed content
+type decodedProfile struct {
+	Info profileutil.ProvisioningProfileInfoModel
+	Data plistutil.PlistData
+}
+
+// ProfileCacheStats counts the lookups of the decoded profile cache
+type ProfileCacheStats struct {
+	Hits   int
+	Misses int
+}
+
+// String ...
+func (s ProfileCacheStats) String() string {
+	return fmt.Sprintf("%d decoded, %d reused", s.Misses, s.Hits)
+}
+
+// profileCache is an LRU cache of the decoded profiles, keyed by the hash of the profile content,
+// so that the profiles shared by many targets are decoded once across the reuse verification, validation and reporting.
+type profileCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[[sha256.Size]byte]*list.Element
+	order    *list.List
+	stats    ProfileCacheStats
+}
+
+type profileCacheEntry struct {
+	key     [sha256.Size]byte
+	profile decodedProfile
+}
+
+func newProfileCache(capacity int) *profileCache {
+	return &profileCache{
+		capacity: capacity,
+		entries:  map[[sha256.Size]byte]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+var defaultProfileCache = newProfileCache(profileCacheSize)
+
+// GetProfileCacheStats returns the lookup counts of the decoded profile cache
+func GetProfileCacheStats() ProfileCacheStats {
+	defaultProfileCache.mu.Lock()
+	defer defaultProfileCache.mu.Unlock()
+	return defaultProfileCache.stats
+}
+
+// decodeProfileContent decodes the profile content, the decoded profile is reused for the same content.
+func decodeProfileContent(content []byte) (decodedProfile, error) {
+	return defaultProfileCache.decode(content)
+}
+
+func (c *profileCache) decode(content []byte) (decodedProfile, error) {
+	key := sha256.Sum256(content)
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return element.Value.(*profileCacheEntry).profile, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	profile, err := decodeProfile(content)
+	if err != nil {
+		return decodedProfile{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(&profileCacheEntry{key: key, profile: profile})
+		if c.order.Len() > c.capacity {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*profileCacheEntry).key)
+		}
+	}
+	return profile, nil
+}
+
+func decodeProfile(content []byte) (decodedProfile, error) {
+	pkcs, err := profileutil.ProvisioningProfileFromContent(content)
+	if err != nil {
+		return decodedProfile{}, fmt.Errorf("failed to parse pkcs7 from profile content: %s", err)
+	}
+
+	info, err := profileutil.NewProvisioningProfileInfo(*pkcs)
+	if err != nil {
+		return decodedProfile{}, fmt.Errorf("failed to parse profile info from pkcs7 content: %s", err)
+	}
+
+	data, err := plistutil.NewPlistDataFromContent(string(pkcs.Content))
+	if err != nil {
+		return decodedProfile{}, fmt.Errorf("failed to parse profile content: %s", err)
+	}
+
+	return decodedProfile{Info: info, Data: data}, nil
+}
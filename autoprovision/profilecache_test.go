@@ -0,0 +1,88 @@
+package autoprovision
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/stretchr/testify/require"
+)
+
+func signedTestProfiles(t *testing.T, uuids ...string) [][]byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Apple iPhone OS Provisioning Profile Signing"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+	}
+	certData, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certData)
+	require.NoError(t, err)
+
+	var profiles [][]byte
+	for _, uuid := range uuids {
+		content := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Name</key>
+	<string>Bitrise app-store - (io.bitrise.app)</string>
+	<key>UUID</key>
+	<string>%s</string>
+	<key>TeamIdentifier</key>
+	<array><string>TEAM123</string></array>
+	<key>Entitlements</key>
+	<dict>
+		<key>application-identifier</key>
+		<string>TEAM123.io.bitrise.app</string>
+	</dict>
+</dict>
+</plist>`, uuid)
+		signedData, err := pkcs7.NewSignedData([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, signedData.AddSigner(cert, key, pkcs7.SignerInfoConfig{}))
+		signedProfile, err := signedData.Finish()
+		require.NoError(t, err)
+		profiles = append(profiles, signedProfile)
+	}
+	return profiles
+}
+
+func Test_profileCache_decode(t *testing.T) {
+	profiles := signedTestProfiles(t, "UUID-1", "UUID-2", "UUID-3")
+	cache := newProfileCache(2)
+
+	for _, content := range [][]byte{profiles[0], profiles[0], profiles[1], profiles[0]} {
+		_, err := cache.decode(content)
+		require.NoError(t, err)
+	}
+	require.Equal(t, ProfileCacheStats{Hits: 2, Misses: 2}, cache.stats)
+
+	// UUID-2 is the least recently used profile, it is evicted
+	decoded, err := cache.decode(profiles[2])
+	require.NoError(t, err)
+	require.Equal(t, "UUID-3", decoded.Info.UUID)
+	require.Equal(t, 2, cache.order.Len())
+
+	decoded, err = cache.decode(profiles[0])
+	require.NoError(t, err)
+	require.Equal(t, "UUID-1", decoded.Info.UUID)
+	decoded, err = cache.decode(profiles[1])
+	require.NoError(t, err)
+	require.Equal(t, "UUID-2", decoded.Info.UUID)
+	require.Equal(t, ProfileCacheStats{Hits: 3, Misses: 4}, cache.stats)
+
+	_, err = cache.decode([]byte("not a profile"))
+	require.Error(t, err)
+	_, err = cache.decode([]byte("not a profile"))
+	require.Error(t, err)
+	require.Equal(t, ProfileCacheStats{Hits: 3, Misses: 6}, cache.stats)
+}
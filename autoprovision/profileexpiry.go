@@ -0,0 +1,42 @@
+package autoprovision
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+const profileExpiryOutputKeyPrefix = "BITRISE_PROFILE_EXPIRY_"
+
+// ProfileExpiryOutputKey returns the output key of the bundle ID's profile expiry: the uppercase bundle ID,
+// with the characters other than letters and digits replaced by underscores, for example BITRISE_PROFILE_EXPIRY_IO_BITRISE_APP.
+func ProfileExpiryOutputKey(bundleID string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(bundleID))
+	return profileExpiryOutputKeyPrefix + sanitized
+}
+
+// ProfileExpiryOutputs returns the ISO 8601 (UTC) expiry date of the profiles by their output keys,
+// for the scheduled workflows deciding whether to run a renewal.
+// If the sanitized bundle IDs collide (like io.bitrise.app-a and io.bitrise.app_a), the earliest expiry is used.
+func ProfileExpiryOutputs(profilesByBundleID map[string]appstoreconnect.Profile) map[string]string {
+	expiryByKey := map[string]time.Time{}
+	for bundleID, profile := range profilesByBundleID {
+		key := ProfileExpiryOutputKey(bundleID)
+		expiry := time.Time(profile.Attributes.ExpirationDate).UTC()
+		if existing, ok := expiryByKey[key]; !ok || expiry.Before(existing) {
+			expiryByKey[key] = expiry
+		}
+	}
+
+	outputs := map[string]string{}
+	for key, expiry := range expiryByKey {
+		outputs[key] = expiry.Format(time.RFC3339)
+	}
+	return outputs
+}
@@ -0,0 +1,31 @@
+package autoprovision
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileExpiryOutputKey(t *testing.T) {
+	require.Equal(t, "BITRISE_PROFILE_EXPIRY_IO_BITRISE_APP", ProfileExpiryOutputKey("io.bitrise.app"))
+	require.Equal(t, "BITRISE_PROFILE_EXPIRY_IO_BITRISE_MY_APP_WIDGET2", ProfileExpiryOutputKey("io.bitrise.my-app.Widget2"))
+}
+
+func TestProfileExpiryOutputs(t *testing.T) {
+	profile := func(expiry time.Time) appstoreconnect.Profile {
+		return appstoreconnect.Profile{Attributes: appstoreconnect.ProfileAttributes{ExpirationDate: appstoreconnect.Time(expiry)}}
+	}
+	cet := time.FixedZone("CET", 60*60)
+
+	outputs := ProfileExpiryOutputs(map[string]appstoreconnect.Profile{
+		"io.bitrise.app":   profile(time.Date(2027, 3, 1, 13, 0, 0, 0, cet)),
+		"io.bitrise.app-a": profile(time.Date(2027, 5, 1, 0, 0, 0, 0, time.UTC)),
+		"io.bitrise.app_a": profile(time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC)),
+	})
+	require.Equal(t, map[string]string{
+		"BITRISE_PROFILE_EXPIRY_IO_BITRISE_APP":   "2027-03-01T12:00:00Z",
+		"BITRISE_PROFILE_EXPIRY_IO_BITRISE_APP_A": "2027-04-01T00:00:00Z",
+	}, outputs)
+}
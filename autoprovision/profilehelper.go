@@ -6,10 +6,11 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bitrise-io/go-utils/pathutil"
-	"github.com/bitrise-io/go-xcode/profileutil"
 	"github.com/bitrise-io/xcode-project/serialized"
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
 )
@@ -39,25 +40,48 @@ func ProfileName(profileType appstoreconnect.ProfileType, bundleID string) (stri
 	return fmt.Sprintf("Bitrise %s %s - (%s)", platform, distribution, bundleID), nil
 }
 
-// FindProfile ...
+// ProfileNameCollisionPolicy is how the name of a Bitrise managed profile, taken by a profile of a different app ID, is freed up
+type ProfileNameCollisionPolicy string
+
+// ProfileNameCollisionPolicies ...
+const (
+	FailOnProfileNameCollision   ProfileNameCollisionPolicy = "fail"
+	DeleteCollidingProfile       ProfileNameCollisionPolicy = "delete"
+	RenameProfileOnNameCollision ProfileNameCollisionPolicy = "rename"
+)
+
+// RenamedProfileName returns the name of the Bitrise managed profile, used if its name is taken by a profile of a different app ID.
+// The Developer Portal profiles can not be renamed, so the new profile gets the alternative name instead.
+func RenamedProfileName(name string) string {
+	return name + " (2)"
+}
+
+// ProfileBundleID returns the app ID the profile belongs to
+func ProfileBundleID(client *appstoreconnect.Client, prof appstoreconnect.Profile) (*appstoreconnect.BundleID, error) {
+	r, err := client.Provisioning.BundleID(prof.Relationships.BundleID.Links.Related)
+	if err != nil {
+		return nil, err
+	}
+	return &r.Data, nil
+}
+
+// FindProfile returns the profile with the exact name and type, or nil if there is none.
+// The name filter is not an exact match, the profile without the profile key would match the names with the profile keys too.
 func FindProfile(client *appstoreconnect.Client, name string, profileType appstoreconnect.ProfileType, bundleIDIdentifier string) (*appstoreconnect.Profile, error) {
-	opt := &appstoreconnect.ListProfilesOptions{
-		PagingOptions: appstoreconnect.PagingOptions{
-			Limit: 1,
-		},
+	profiles, err := client.Provisioning.ListAllProfiles(&appstoreconnect.ListProfilesOptions{
 		FilterProfileType: profileType,
 		FilterName:        name,
-	}
-
-	r, err := client.Provisioning.ListProfiles(opt)
+	})
 	if err != nil {
 		return nil, err
 	}
-	if len(r.Data) == 0 {
-		return nil, nil
-	}
 
-	return &r.Data[0], nil
+	for i := range profiles {
+		if profiles[i].Attributes.Name == name {
+			return &profiles[i], nil
+		}
+	}
+	return nil, nil
 }
 
 func wrapInProfileError(err error) error {
@@ -78,9 +102,15 @@ func checkProfileEntitlements(client *appstoreconnect.Client, prof appstoreconne
 		return err
 	}
 
-	projectEnts := serialized.Object(projectEntitlements)
+	if err := checkProfileApprovalEntitlements(profileEnts, projectEntitlements); err != nil {
+		return err
+	}
+
+	profileEnts = NormalizeEntitlements(profileEnts)
+	projectEnts := NormalizeEntitlements(serialized.Object(projectEntitlements))
 
-	missingContainers, err := findMissingContainers(projectEnts, profileEnts)
+	environment := profileTypeCloudKitEnvironment(prof.Attributes.ProfileType)
+	missingContainers, err := findMissingContainers(projectEnts, environmentContainers(profileEnts, environment))
 	if err != nil {
 		return fmt.Errorf("failed to check missing containers: %s", err)
 	}
@@ -90,6 +120,16 @@ func checkProfileEntitlements(client *appstoreconnect.Client, prof appstoreconne
 		}
 	}
 
+	missingParentApps, err := findMissingParentApplications(projectEnts, profileEnts)
+	if err != nil {
+		return fmt.Errorf("failed to check missing parent apps: %s", err)
+	}
+	if len(missingParentApps) > 0 {
+		return NonmatchingProfileError{
+			Reason: fmt.Sprintf("App Clip's parent apps are missing from the provisioning profile: %v", missingParentApps),
+		}
+	}
+
 	bundleIDresp, err := client.Provisioning.BundleID(prof.Relationships.BundleID.Links.Related)
 	if err != nil {
 		return err
@@ -99,16 +139,11 @@ func checkProfileEntitlements(client *appstoreconnect.Client, prof appstoreconne
 }
 
 func parseRawProfileEntitlements(prof appstoreconnect.Profile) (serialized.Object, error) {
-	pkcs, err := profileutil.ProvisioningProfileFromContent(prof.Attributes.ProfileContent)
+	profile, err := decodeProfileContent(prof.Attributes.ProfileContent)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse pkcs7 from profile content: %s", err)
-	}
-
-	profile, err := profileutil.NewProvisioningProfileInfo(*pkcs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse profile info from pkcs7 content: %s", err)
+		return nil, err
 	}
-	return serialized.Object(profile.Entitlements), nil
+	return serialized.Object(profile.Info.Entitlements), nil
 }
 
 func findMissingContainers(projectEnts, profileEnts serialized.Object) ([]string, error) {
@@ -150,26 +185,9 @@ func findMissingContainers(projectEnts, profileEnts serialized.Object) ([]string
 }
 
 func checkProfileCertificates(client *appstoreconnect.Client, prof appstoreconnect.Profile, certificateIDs []string) error {
-	var nextPageURL string
-	var certificates []appstoreconnect.Certificate
-	for {
-		response, err := client.Provisioning.Certificates(
-			prof.Relationships.Certificates.Links.Related,
-			&appstoreconnect.PagingOptions{
-				Limit: 20,
-				Next:  nextPageURL,
-			},
-		)
-		if err != nil {
-			return wrapInProfileError(err)
-		}
-
-		certificates = append(certificates, response.Data...)
-
-		nextPageURL = response.Links.Next
-		if nextPageURL == "" {
-			break
-		}
+	certificates, err := client.Provisioning.AllCertificates(prof.Relationships.Certificates.Links.Related)
+	if err != nil {
+		return wrapInProfileError(err)
 	}
 
 	ids := map[string]bool{}
@@ -187,28 +205,14 @@ func checkProfileCertificates(client *appstoreconnect.Client, prof appstoreconne
 }
 
 func checkProfileDevices(client *appstoreconnect.Client, prof appstoreconnect.Profile, deviceIDs []string) error {
-	var nextPageURL string
-	ids := map[string]bool{}
-	for {
-		response, err := client.Provisioning.Devices(
-			prof.Relationships.Devices.Links.Related,
-			&appstoreconnect.PagingOptions{
-				Limit: 20,
-				Next:  nextPageURL,
-			},
-		)
-		if err != nil {
-			return wrapInProfileError(err)
-		}
-
-		for _, dev := range response.Data {
-			ids[dev.ID] = true
-		}
+	devices, err := client.Provisioning.AllDevices(prof.Relationships.Devices.Links.Related)
+	if err != nil {
+		return wrapInProfileError(err)
+	}
 
-		nextPageURL = response.Links.Next
-		if nextPageURL == "" {
-			break
-		}
+	ids := map[string]bool{}
+	for _, dev := range devices {
+		ids[dev.ID] = true
 	}
 
 	for _, id := range deviceIDs {
@@ -222,7 +226,8 @@ func checkProfileDevices(client *appstoreconnect.Client, prof appstoreconnect.Pr
 	return nil
 }
 
-func isProfileExpired(prof appstoreconnect.Profile, minProfileDaysValid int) bool {
+// IsProfileExpired reports whether the profile expires within the minimum number of days it has to be valid for
+func IsProfileExpired(prof appstoreconnect.Profile, minProfileDaysValid int) bool {
 	relativeExpiryTime := time.Now()
 	if minProfileDaysValid > 0 {
 		relativeExpiryTime = relativeExpiryTime.Add(time.Duration(minProfileDaysValid) * 24 * time.Hour)
@@ -230,9 +235,29 @@ func isProfileExpired(prof appstoreconnect.Profile, minProfileDaysValid int) boo
 	return time.Time(prof.Attributes.ExpirationDate).Before(relativeExpiryTime)
 }
 
+// IncompleteProfileReason returns why the profile can not be reused, if Apple returned it with missing attributes,
+// for example during the propagation of a new profile. It returns an empty string for complete profiles.
+func IncompleteProfileReason(prof appstoreconnect.Profile) string {
+	var missing []string
+	if prof.Attributes.UUID == "" {
+		missing = append(missing, "UUID")
+	}
+	if len(prof.Attributes.ProfileContent) == 0 {
+		missing = append(missing, "profile content")
+	}
+	if time.Time(prof.Attributes.ExpirationDate).IsZero() {
+		missing = append(missing, "expiration date")
+	}
+
+	if len(missing) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("missing or malformed %s", strings.Join(missing, ", "))
+}
+
 // CheckProfile ...
 func CheckProfile(client *appstoreconnect.Client, prof appstoreconnect.Profile, entitlements Entitlement, deviceIDs, certificateIDs []string, minProfileDaysValid int) error {
-	if isProfileExpired(prof, minProfileDaysValid) {
+	if IsProfileExpired(prof, minProfileDaysValid) {
 		return NonmatchingProfileError{
 			Reason: fmt.Sprintf("profile expired, or will expire in less then %d day(s)", minProfileDaysValid),
 		}
@@ -296,8 +321,18 @@ func WriteProfile(profile appstoreconnect.Profile) error {
 		}
 	}
 
+	platform := profile.Attributes.Platform
+	if platform == appstoreconnect.Universal {
+		// profiles of universal app IDs are iOS or macOS profiles by their type
+		platform = BundleIDPlatform(ProfileTypeToPlatform[profile.Attributes.ProfileType])
+	}
+	if ProfileTypeToPlatform[profile.Attributes.ProfileType] == MacCatalyst {
+		// Mac Catalyst profiles are macOS profiles of iOS app IDs
+		platform = appstoreconnect.MacOS
+	}
+
 	var ext string
-	switch profile.Attributes.Platform {
+	switch platform {
 	case appstoreconnect.IOS:
 		ext = ".mobileprovision"
 	case appstoreconnect.MacOS:
@@ -307,8 +342,102 @@ func WriteProfile(profile appstoreconnect.Profile) error {
 	}
 
 	name := path.Join(profilesDir, profile.Attributes.UUID+ext)
-	if err := ioutil.WriteFile(name, profile.Attributes.ProfileContent, 0600); err != nil {
+	if err := writeFileAtomic(name, profile.Attributes.ProfileContent); err != nil {
 		return fmt.Errorf("failed to write profile to file: %s", err)
 	}
 	return nil
 }
+
+// writeFileAtomic writes the content to a temporary file next to the destination and renames it,
+// so Xcode never sees a partially written profile.
+func writeFileAtomic(name string, content []byte) error {
+	tmpFile, err := ioutil.TempFile(path.Dir(name), "."+path.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmpFile.Write(content); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if err := os.Rename(tmpFile.Name(), name); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	return nil
+}
+
+// validateProfileContent checks if the profile content is a provisioning profile with the expected UUID.
+func validateProfileContent(profile appstoreconnect.Profile) error {
+	if len(profile.Attributes.ProfileContent) == 0 {
+		return fmt.Errorf("profile (%s) content is empty", profile.Attributes.Name)
+	}
+
+	decoded, err := decodeProfileContent(profile.Attributes.ProfileContent)
+	if err != nil {
+		return fmt.Errorf("profile (%s): %s", profile.Attributes.Name, err)
+	}
+
+	if info := decoded.Info; info.UUID != profile.Attributes.UUID {
+		return fmt.Errorf("profile (%s) content UUID (%s) does not match the profile UUID (%s)", profile.Attributes.Name, info.UUID, profile.Attributes.UUID)
+	}
+	return nil
+}
+
+// InstallProfiles decodes and validates the profiles' content, then installs them with WriteProfile.
+// Both steps run concurrently on at most maxConcurrency goroutines, no profile is installed if any of them is invalid.
+func InstallProfiles(profiles []appstoreconnect.Profile, maxConcurrency int) error {
+	return installProfiles(profiles, maxConcurrency, validateProfileContent, WriteProfile)
+}
+
+func installProfiles(profiles []appstoreconnect.Profile, maxConcurrency int, validate, install func(appstoreconnect.Profile) error) error {
+	if err := ForEachConcurrently(len(profiles), maxConcurrency, func(i int) error {
+		return validate(profiles[i])
+	}); err != nil {
+		return err
+	}
+
+	return ForEachConcurrently(len(profiles), maxConcurrency, func(i int) error {
+		return install(profiles[i])
+	})
+}
+
+// ForEachConcurrently calls fn with the indexes [0, count) on at most maxConcurrency goroutines
+// and returns the error of the lowest index, if any.
+func ForEachConcurrently(count, maxConcurrency int, fn func(i int) error) error {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+
+	errs := make([]error, count)
+	semaphore := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,11 @@
 package autoprovision
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -191,9 +196,112 @@ func Test_checkProfileExpiry(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := isProfileExpired(tt.prof, tt.minProfileDaysValid); got != tt.want {
+			if got := IsProfileExpired(tt.prof, tt.minProfileDaysValid); got != tt.want {
 				t.Errorf("checkProfileExpiry() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestIncompleteProfileReason(t *testing.T) {
+	complete := appstoreconnect.ProfileAttributes{
+		UUID:           "c5be4123-1234-4f9d-9843-0d9be985a068",
+		ProfileContent: []byte("content"),
+		ExpirationDate: appstoreconnect.Time(time.Now().Add(24 * time.Hour)),
+	}
+	require.Equal(t, "", IncompleteProfileReason(appstoreconnect.Profile{Attributes: complete}))
+
+	propagating := complete
+	propagating.ProfileContent = nil
+	propagating.ExpirationDate = appstoreconnect.Time{}
+	require.Equal(t, "missing or malformed profile content, expiration date", IncompleteProfileReason(appstoreconnect.Profile{Attributes: propagating}))
+
+	require.Equal(t, "missing or malformed UUID, profile content, expiration date", IncompleteProfileReason(appstoreconnect.Profile{}))
+}
+
+func Test_ForEachConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	var running, maxRunning int
+	called := make([]bool, 10)
+
+	err := ForEachConcurrently(len(called), 3, func(i int) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		called[i] = true
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		if i == 7 || i == 4 {
+			return fmt.Errorf("error %d", i)
+		}
+		return nil
+	})
+
+	require.EqualError(t, err, "error 4")
+	require.True(t, maxRunning <= 3, "at most 3 calls run concurrently, got %d", maxRunning)
+	for i, c := range called {
+		require.True(t, c, "index %d is called", i)
+	}
+}
+
+func Test_installProfiles(t *testing.T) {
+	profiles := []appstoreconnect.Profile{
+		{Attributes: appstoreconnect.ProfileAttributes{Name: "valid 1"}},
+		{Attributes: appstoreconnect.ProfileAttributes{Name: "invalid"}},
+		{Attributes: appstoreconnect.ProfileAttributes{Name: "valid 2"}},
+	}
+
+	var mu sync.Mutex
+	var installed []string
+	validate := func(profile appstoreconnect.Profile) error {
+		if profile.Attributes.Name == "invalid" {
+			return fmt.Errorf("invalid profile")
+		}
+		return nil
+	}
+	install := func(profile appstoreconnect.Profile) error {
+		mu.Lock()
+		defer mu.Unlock()
+		installed = append(installed, profile.Attributes.Name)
+		return nil
+	}
+
+	require.EqualError(t, installProfiles(profiles, 2, validate, install), "invalid profile")
+	require.Empty(t, installed, "no profile is installed if any of them is invalid")
+
+	require.NoError(t, installProfiles([]appstoreconnect.Profile{profiles[0], profiles[2]}, 2, validate, install))
+	require.ElementsMatch(t, []string{"valid 1", "valid 2"}, installed)
+}
+
+func Test_validateProfileContent(t *testing.T) {
+	require.Error(t, validateProfileContent(appstoreconnect.Profile{}), "empty content")
+	require.Error(t, validateProfileContent(appstoreconnect.Profile{Attributes: appstoreconnect.ProfileAttributes{ProfileContent: []byte("not a profile")}}), "invalid content")
+}
+
+func Test_writeFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+
+	name := filepath.Join(dir, "uuid.mobileprovision")
+	require.NoError(t, ioutil.WriteFile(name, []byte("old"), 0600))
+	require.NoError(t, writeFileAtomic(name, []byte("new")))
+
+	content, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(content))
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries), "no temporary file is left behind")
+}
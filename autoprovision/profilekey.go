@@ -0,0 +1,39 @@
+package autoprovision
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+// profileKeyLength is the number of hex digits of the profile key, kept short as it is part of the profile name
+const profileKeyLength = 8
+
+// ProfileKey returns the idempotency key of a Bitrise managed profile: a short hash of the bundle ID, the entitlements
+// and the certificates the profile is generated with. The same inputs give the same key, so a retried build adopts the profile
+// created by the previous attempt, while builds with different entitlements (for example of different branches) use different profiles.
+// The devices are not part of the key, a device change regenerates the profile.
+func ProfileKey(bundleID string, entitlements serialized.Object, certificateIDs []string) (string, error) {
+	// json.Marshal sorts the map keys, so the encoding is deterministic
+	entitlementsJSON, err := json.Marshal(entitlements)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode entitlements: %s", err)
+	}
+
+	sortedCertificateIDs := append([]string{}, certificateIDs...)
+	sort.Strings(sortedCertificateIDs)
+
+	content := strings.Join([]string{bundleID, string(entitlementsJSON), strings.Join(sortedCertificateIDs, ",")}, "\n")
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:profileKeyLength], nil
+}
+
+// KeyedProfileName returns the name of the Bitrise managed profile with the profile key, with layout: <profile name> [<key>]
+func KeyedProfileName(name, key string) string {
+	return fmt.Sprintf("%s [%s]", name, key)
+}
@@ -0,0 +1,38 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileKey(t *testing.T) {
+	entitlements := serialized.Object{"aps-environment": "development", "com.apple.security.application-groups": []interface{}{"group.io.bitrise.app"}}
+
+	key, err := ProfileKey("io.bitrise.app", entitlements, []string{"CERT1", "CERT2"})
+	require.NoError(t, err)
+	require.Equal(t, profileKeyLength, len(key))
+
+	sameKey, err := ProfileKey("io.bitrise.app", serialized.Object{"com.apple.security.application-groups": []interface{}{"group.io.bitrise.app"}, "aps-environment": "development"}, []string{"CERT2", "CERT1"})
+	require.NoError(t, err)
+	require.Equal(t, key, sameKey, "the key does not depend on the order of the entitlements and the certificates")
+
+	for _, other := range []struct {
+		bundleID       string
+		entitlements   serialized.Object
+		certificateIDs []string
+	}{
+		{"io.bitrise.app.widget", entitlements, []string{"CERT1", "CERT2"}},
+		{"io.bitrise.app", serialized.Object{"aps-environment": "production"}, []string{"CERT1", "CERT2"}},
+		{"io.bitrise.app", entitlements, []string{"CERT1"}},
+	} {
+		otherKey, err := ProfileKey(other.bundleID, other.entitlements, other.certificateIDs)
+		require.NoError(t, err)
+		require.NotEqual(t, key, otherKey)
+	}
+}
+
+func TestKeyedProfileName(t *testing.T) {
+	require.Equal(t, "Bitrise iOS development - (io.bitrise.app) [0a1b2c3d]", KeyedProfileName("Bitrise iOS development - (io.bitrise.app)", "0a1b2c3d"))
+}
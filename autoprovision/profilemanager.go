@@ -0,0 +1,732 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// ProfileManager ensures the app IDs and the Bitrise managed profiles of the bundle IDs on the Developer Portal.
+// Its methods are safe to call concurrently, the profiles of the bundle IDs are ensured in parallel.
+type ProfileManager struct {
+	client                      *appstoreconnect.Client
+	bundleIDByBundleIDIdentifer map[string]*appstoreconnect.BundleID
+	containersByBundleID        map[string][]string
+	// mu guards the app ID and iCloud container maps, the profiles of the bundle IDs are ensured in parallel
+	mu            *sync.Mutex
+	portalChanges *PortalChanges
+	session       *Session
+
+	// ProfileQuotaLimit is the profile limit of an app ID, the quota is not checked if it is 0
+	ProfileQuotaLimit int
+	// ProfileCleanup deletes the expired and invalid Bitrise managed profiles of an app ID approaching the profile limit
+	ProfileCleanup bool
+	// ProfileNameCollision is the policy of the Bitrise managed profile names taken by the profiles of a different app ID
+	ProfileNameCollision ProfileNameCollisionPolicy
+	// RequireDEREntitlements regenerates the profiles without DER encoded entitlements, required by the installed Xcode
+	RequireDEREntitlements bool
+	// CapabilityMatrix records the state of the synced capabilities, it can be nil
+	CapabilityMatrix *CapabilityMatrix
+	// BuildCache reuses the profiles of the previous builds, it can be nil
+	BuildCache *BuildCache
+	// IgnoreFailure is called with the failures ignored in lenient mode, the failures are returned if it is nil
+	IgnoreFailure func(format string, args ...interface{})
+}
+
+// NewProfileManager returns a ProfileManager using the client. The app IDs found earlier (by the identifier) are reused,
+// the session and the portal changes can be nil: the app IDs are not cached and the Developer Portal changes are not limited.
+func NewProfileManager(client *appstoreconnect.Client, session *Session, portalChanges *PortalChanges, bundleIDs map[string]*appstoreconnect.BundleID) *ProfileManager {
+	bundleIDByBundleIDIdentifer := map[string]*appstoreconnect.BundleID{}
+	for identifier, bundleID := range bundleIDs {
+		bundleIDByBundleIDIdentifer[identifier] = bundleID
+	}
+	return &ProfileManager{
+		client:                      client,
+		bundleIDByBundleIDIdentifer: bundleIDByBundleIDIdentifer,
+		containersByBundleID:        map[string][]string{},
+		mu:                          &sync.Mutex{},
+		portalChanges:               portalChanges,
+		session:                     session,
+	}
+}
+
+// planCopy returns a copy of the manager registering the changes to the planned changes,
+// the app IDs planned for creation do not leak into the manager making the changes.
+func (m ProfileManager) planCopy(portalChanges *PortalChanges) *ProfileManager {
+	planner := NewProfileManager(m.client, m.session, portalChanges, m.bundleIDByBundleIDIdentifer)
+	planner.ProfileQuotaLimit = m.ProfileQuotaLimit
+	planner.ProfileCleanup = m.ProfileCleanup
+	planner.ProfileNameCollision = m.ProfileNameCollision
+	planner.RequireDEREntitlements = m.RequireDEREntitlements
+	planner.BuildCache = m.BuildCache
+	planner.IgnoreFailure = m.IgnoreFailure
+	return planner
+}
+
+// UnassignedContainers returns the iCloud containers, which could not be assigned to the created app IDs, by the bundle ID
+func (m ProfileManager) UnassignedContainers() map[string][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	containersByBundleID := map[string][]string{}
+	for bundleID, containers := range m.containersByBundleID {
+		containersByBundleID[bundleID] = containers
+	}
+	return containersByBundleID
+}
+
+// EnsureBundleID returns the app ID of the bundle ID with the capabilities of the entitlements enabled,
+// it registers the app ID for the platform if it does not exist yet.
+func (m ProfileManager) EnsureBundleID(bundleIDIdentifier string, entitlements serialized.Object, platform Platform) (*appstoreconnect.BundleID, error) {
+	fmt.Println()
+	log.Infof("  Searching for app ID for bundle ID: %s", bundleIDIdentifier)
+
+	bundleID, ok := m.knownBundleID(bundleIDIdentifier)
+	if !ok {
+		var err error
+		bundleID, err = m.session.FindBundleID(m.client, bundleIDIdentifier)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find bundle ID: %s", err)
+		}
+	}
+
+	if bundleID != nil {
+		log.Printf("  app ID found: %s", bundleID.Attributes.Name)
+
+		m.setKnownBundleID(bundleIDIdentifier, bundleID)
+
+		// The identifier of an existing app ID can not be registered again with another platform
+		if !BundleIDSupportsPlatform(*bundleID, platform) {
+			return nil, fmt.Errorf("app ID (%s) is registered for the %s platform, %s profiles can not be generated for it: enable the %s platform of the app ID on the Developer Portal (making it a universal app ID)", bundleIDIdentifier, bundleID.Attributes.Platform, platform, platform)
+		}
+
+		// Check if BundleID is sync with the project
+		err := CheckBundleIDEntitlements(m.client, *bundleID, Entitlement(entitlements))
+		if err != nil {
+			if mErr, ok := err.(NonmatchingProfileError); ok {
+				log.Warnf("  app ID capabilities invalid: %s", mErr.Reason)
+				log.Warnf("  app ID capabilities are not in sync with the project capabilities, synchronizing...")
+				change := PortalChange{Action: UpdateBundleIDCapabilitiesChange, Subject: bundleIDIdentifier, BundleID: bundleIDIdentifier, Reason: "project entitlements: " + mErr.Reason}
+				if err := m.portalChanges.Register(change); err != nil {
+					return nil, err
+				}
+				if !m.portalChanges.IsDryRun() {
+					err := m.syncBundleID(*bundleID, Entitlement(entitlements))
+					m.portalChanges.RecordOutcome(change, err)
+					if err != nil {
+						return nil, fmt.Errorf("failed to update bundle ID capabilities: %s", err)
+					}
+					m.CapabilityMatrix.SetBundleIDState(bundleIDIdentifier, CapabilityUpdated)
+				}
+			} else {
+				return nil, fmt.Errorf("failed to validate bundle ID: %s", err)
+			}
+		} else {
+			log.Printf("  app ID capabilities are in sync with the project capabilities")
+			m.CapabilityMatrix.SetBundleIDState(bundleIDIdentifier, CapabilityEnabled)
+		}
+
+		return bundleID, nil
+	}
+
+	// Create BundleID
+	log.Warnf("  app ID not found, generating...")
+
+	// The API can not set the parent app of a new App Clip app ID
+	if _, isAppClip := entitlements[onDemandInstallCapableEntitlementKey]; isAppClip {
+		return nil, fmt.Errorf("step does not support creating an application identifier using the \"On Demand Install Capable (App Clips)\" capability, please add your Application Identifier (%s) manually using the Apple Developer Portal", bundleIDIdentifier)
+	}
+
+	capabilities := Entitlement(entitlements)
+
+	change := PortalChange{Action: CreateBundleIDChange, Subject: bundleIDIdentifier, BundleID: bundleIDIdentifier, Reason: "no app ID found for the project target's bundle ID"}
+	if err := m.portalChanges.Register(change); err != nil {
+		return nil, err
+	}
+	if m.portalChanges.IsDryRun() {
+		// the planned app ID has no ID, the profiles planned for it are not checked against the Developer Portal
+		bundleID := &appstoreconnect.BundleID{Attributes: appstoreconnect.BundleIDAttributes{Identifier: bundleIDIdentifier, Platform: string(BundleIDPlatform(platform))}}
+		m.setKnownBundleID(bundleIDIdentifier, bundleID)
+		return bundleID, nil
+	}
+
+	bundleID, err := CreateBundleID(m.client, bundleIDIdentifier, platform)
+	m.portalChanges.RecordOutcome(change, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle ID: %s", err)
+	}
+	m.session.AddBundleID(*bundleID)
+
+	containers, err := capabilities.ICloudContainers()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get list of iCloud containers: %s", err)
+	}
+
+	if len(containers) > 0 {
+		m.mu.Lock()
+		m.containersByBundleID[bundleIDIdentifier] = containers
+		m.mu.Unlock()
+		log.Errorf("  app ID created but couldn't add iCloud containers: %v", containers)
+	}
+
+	if err := m.syncBundleID(*bundleID, capabilities); err != nil {
+		return nil, fmt.Errorf("failed to update bundle ID capabilities: %s", err)
+	}
+	m.CapabilityMatrix.SetBundleIDState(bundleIDIdentifier, CapabilityUpdated)
+
+	m.setKnownBundleID(bundleIDIdentifier, bundleID)
+
+	return bundleID, nil
+}
+
+// knownBundleID returns the app ID of the bundle ID, found or created earlier in the run
+func (m ProfileManager) knownBundleID(bundleIDIdentifier string) (*appstoreconnect.BundleID, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bundleID, ok := m.bundleIDByBundleIDIdentifer[bundleIDIdentifier]
+	return bundleID, ok
+}
+
+// setKnownBundleID records the app ID of the bundle ID, found or created in the run
+func (m ProfileManager) setKnownBundleID(bundleIDIdentifier string, bundleID *appstoreconnect.BundleID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bundleIDByBundleIDIdentifer[bundleIDIdentifier] = bundleID
+}
+
+// DisableStaleCapabilities disables the capabilities of the existing app IDs of the bundle IDs, which are not required by their entitlements
+// (reconcile_capabilities input). The app IDs are reconciled independently of their profiles, even if the profiles are in sync.
+// The entitlements have to be the union of the entitlements of every configuration built with the app IDs,
+// so that a capability used by any of the configurations is kept.
+func (m ProfileManager) DisableStaleCapabilities(entitlementsByBundleID map[string]serialized.Object) error {
+	var bundleIDIdentifiers []string
+	for bundleIDIdentifier := range entitlementsByBundleID {
+		bundleIDIdentifiers = append(bundleIDIdentifiers, bundleIDIdentifier)
+	}
+	sort.Strings(bundleIDIdentifiers)
+
+	for _, bundleIDIdentifier := range bundleIDIdentifiers {
+		bundleID, ok := m.knownBundleID(bundleIDIdentifier)
+		if !ok {
+			var err error
+			if bundleID, err = m.session.FindBundleID(m.client, bundleIDIdentifier); err != nil {
+				return fmt.Errorf("failed to find bundle ID: %s", err)
+			}
+		}
+		// the app ID does not exist (or it is only planned in a dry run), it has no capabilities to disable
+		if bundleID == nil || bundleID.ID == "" {
+			continue
+		}
+
+		fmt.Println()
+		log.Infof("  Reconciling the capabilities of the app ID: %s", bundleIDIdentifier)
+		if err := m.disableStaleCapabilities(*bundleID, Entitlement(entitlementsByBundleID[bundleIDIdentifier])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disableStaleCapabilities disables the capabilities of the app ID, which are not required by the project entitlements anymore,
+// so that the app IDs do not accumulate capabilities over the CI runs.
+func (m ProfileManager) disableStaleCapabilities(bundleID appstoreconnect.BundleID, entitlements Entitlement) error {
+	stale, err := FindStaleCapabilities(m.client, bundleID, entitlements)
+	if err != nil {
+		return fmt.Errorf("failed to find the stale capabilities of the app ID (%s): %s", bundleID.Attributes.Identifier, err)
+	}
+
+	for _, capability := range stale {
+		capabilityType := capability.Attributes.CapabilityType
+		log.Warnf("  app ID capability (%s) is not required by the project capabilities, disabling...", capabilityType)
+		change := PortalChange{Action: DisableBundleIDCapabilityChange, Subject: bundleID.Attributes.Identifier, BundleID: bundleID.Attributes.Identifier, Reason: fmt.Sprintf("capability (%s) is not required by the project entitlements (reconcile_capabilities input)", capabilityType)}
+		if err := m.portalChanges.Register(change); err != nil {
+			return err
+		}
+		if m.portalChanges.IsDryRun() {
+			continue
+		}
+		err := m.client.Provisioning.DisableCapability(capability.ID)
+		m.portalChanges.RecordOutcome(change, err)
+		if err != nil {
+			return fmt.Errorf("failed to disable the capability (%s) of the app ID (%s): %s", capabilityType, bundleID.Attributes.Identifier, err)
+		}
+		m.CapabilityMatrix.SetCapabilityState(bundleID.Attributes.Identifier, capabilityType, CapabilityDisabled)
+	}
+	return nil
+}
+
+// syncBundleID enables the capabilities of the entitlements on the app ID.
+// In lenient mode (IgnoreFailure is set), a failed capability update is ignored, if the capabilities still exist on the app ID
+// (for example the settings could not be patched, but the capability is enabled).
+func (m ProfileManager) syncBundleID(bundleID appstoreconnect.BundleID, entitlements Entitlement) error {
+	err := SyncBundleID(m.client, bundleID.ID, entitlements)
+	if err == nil || m.IgnoreFailure == nil {
+		return err
+	}
+
+	if checkErr := CheckBundleIDEntitlements(m.client, bundleID, entitlements); checkErr != nil {
+		return err
+	}
+	m.IgnoreFailure("  failed to update the capabilities of the app ID (%s), but the capabilities are enabled: %s", bundleID.Attributes.Identifier, err)
+	return nil
+}
+
+// EnsureProfile returns the Bitrise managed profile of the bundle ID in sync with the entitlements, certificates and devices,
+// valid for at least minProfileDaysValid days. The profile is (re)generated on the Developer Portal if needed.
+func (m ProfileManager) EnsureProfile(profileType appstoreconnect.ProfileType, bundleIDIdentifier string, entitlements serialized.Object, certIDs, deviceIDs []string, minProfileDaysValid int) (*appstoreconnect.Profile, error) {
+	fmt.Println()
+	log.Infof("  Checking bundle id: %s", bundleIDIdentifier)
+	log.Printf("  capabilities: %s", entitlements)
+
+	key, err := ProfileKey(bundleIDIdentifier, entitlements, certIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create profile key: %s", err)
+	}
+
+	if profile := m.cachedProfile(profileType, bundleIDIdentifier, key, deviceIDs, minProfileDaysValid); profile != nil {
+		log.Donef("  profile of the build cache is in sync with the project requirements: %s", profile.Attributes.Name)
+		return profile, nil
+	}
+
+	profile, err := m.ensureProfile(profileType, bundleIDIdentifier, key, entitlements, certIDs, deviceIDs, minProfileDaysValid)
+	if err == nil && !m.portalChanges.IsDryRun() {
+		if err := m.BuildCache.StoreProfile(profileType, bundleIDIdentifier, key, deviceIDs, *profile, time.Now()); err != nil {
+			log.Warnf("  Failed to cache the profile: %s", err)
+		}
+	}
+	return profile, err
+}
+
+// cachedProfile returns the profile of the build cache, if it is still active on the Developer Portal.
+// A single lookup by the profile name replaces the lookups of the app ID, capabilities, certificates and devices of the profile,
+// the profile key and the devices of the cached profile match the project.
+func (m ProfileManager) cachedProfile(profileType appstoreconnect.ProfileType, bundleIDIdentifier, key string, deviceIDs []string, minProfileDaysValid int) *appstoreconnect.Profile {
+	cached := m.BuildCache.Profile(profileType, bundleIDIdentifier, key, deviceIDs, time.Now())
+	if cached == nil {
+		return nil
+	}
+
+	profile, err := FindProfile(m.client, cached.Attributes.Name, profileType, bundleIDIdentifier)
+	if err != nil {
+		log.Warnf("  Failed to check the profile of the build cache: %s", err)
+		return nil
+	}
+	if profile == nil || profile.ID != cached.ID || profile.Attributes.ProfileState != appstoreconnect.Active {
+		log.Printf("  profile of the build cache (%s) is not active on the Developer Portal anymore", cached.Attributes.Name)
+		return nil
+	}
+	if IsProfileExpired(*cached, minProfileDaysValid) {
+		log.Printf("  profile of the build cache (%s) expires in less than %d day(s)", cached.Attributes.Name, minProfileDaysValid)
+		return nil
+	}
+	return cached
+}
+
+// ensureProfile returns the Bitrise managed profile in sync with the project, it (re)generates the profile if needed.
+func (m ProfileManager) ensureProfile(profileType appstoreconnect.ProfileType, bundleIDIdentifier, key string, entitlements serialized.Object, certIDs, deviceIDs []string, minProfileDaysValid int) (*appstoreconnect.Profile, error) {
+	// Search for Bitrise managed Profile
+	legacyName, err := ProfileName(profileType, bundleIDIdentifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create profile name: %s", err)
+	}
+
+	profile, name, err := m.findManagedProfile(KeyedProfileName(legacyName, key), legacyName, profileType, bundleIDIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == nil {
+		log.Warnf("  profile does not exist, generating...")
+	} else {
+		log.Printf("  Bitrise managed profile found: %s", profile.Attributes.Name)
+
+		if IsPersonalTeamProfile(*profile) && minProfileDaysValid >= 7 {
+			log.Warnf("  profiles of personal (free) teams are valid for 7 days only, ignoring the minimum profile validity (%d days)", minProfileDaysValid)
+			minProfileDaysValid = 0
+		}
+
+		deleteReason := fmt.Sprintf("profile state is %s", profile.Attributes.ProfileState)
+		if reason := IncompleteProfileReason(*profile); reason != "" {
+			log.Warnf("  the profile is incomplete (%s), regenerating ...", reason)
+			deleteReason = "profile is incomplete: " + reason
+		} else if profile.Attributes.ProfileState == appstoreconnect.Active {
+			// Check if Bitrise managed Profile is sync with the project
+			err := CheckProfile(m.client, *profile, Entitlement(entitlements), deviceIDs, certIDs, minProfileDaysValid)
+			if err == nil && m.RequireDEREntitlements {
+				err = CheckProfileDEREntitlements(*profile)
+			}
+			if err != nil {
+				if mErr, ok := err.(NonmatchingProfileError); ok {
+					log.Warnf("  the profile is not in sync with the project requirements (%s), regenerating ...", mErr.Reason)
+					deleteReason = "profile is not in sync with the project requirements: " + mErr.Reason
+				} else {
+					return nil, fmt.Errorf("failed to check if profile is valid: %s", err)
+				}
+			} else { // Profile matches
+				log.Donef("  profile is in sync with the project requirements")
+				return profile, nil
+			}
+		}
+
+		if profile.Attributes.ProfileState == appstoreconnect.Invalid {
+			// If the profile's bundle id gets modified, the profile turns in Invalid state.
+			log.Warnf("  the profile state is invalid, regenerating ...")
+		}
+
+		change := PortalChange{Action: DeleteProfileChange, Subject: profile.Attributes.Name, BundleID: bundleIDIdentifier, Reason: deleteReason}
+		if err := m.portalChanges.Register(change); err != nil {
+			return nil, err
+		}
+
+		if !m.portalChanges.IsDryRun() {
+			err := DeleteProfile(m.client, profile.ID)
+			m.portalChanges.RecordOutcome(change, err)
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete profile: %s", err)
+			}
+		}
+	}
+
+	// Search for BundleID
+	bundleID, err := m.EnsureBundleID(bundleIDIdentifier, entitlements, ProfileTypeToPlatform[profileType])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.checkProfileQuota(*bundleID); err != nil {
+		return nil, err
+	}
+
+	// Create Bitrise managed Profile
+	fmt.Println()
+	log.Infof("  Creating profile for bundle id: %s", bundleID.Attributes.Name)
+
+	change := PortalChange{Action: CreateProfileChange, Subject: name, BundleID: bundleIDIdentifier, Reason: fmt.Sprintf("no valid %s profile found for the project target's bundle ID", profileType.ReadableString())}
+	if err := m.portalChanges.Register(change); err != nil {
+		return nil, err
+	}
+	if m.portalChanges.IsDryRun() {
+		planned := &appstoreconnect.Profile{Attributes: appstoreconnect.ProfileAttributes{Name: name, ProfileType: profileType, ProfileState: appstoreconnect.Active}}
+		return planned, m.deleteStaleKeyedProfiles(*bundleID, legacyName, name, profileType)
+	}
+
+	profile, adopted, err := m.createProfile(name, profileType, bundleID, entitlements, certIDs, deviceIDs, minProfileDaysValid)
+	m.portalChanges.RecordOutcome(change, err)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.deleteStaleKeyedProfiles(*bundleID, legacyName, name, profileType); err != nil {
+		return nil, err
+	}
+	if adopted {
+		log.Donef("  profile created by a previous attempt adopted: %s", profile.Attributes.Name)
+		return profile, checkApprovalEntitlements(*profile, entitlements)
+	}
+
+	log.Donef("  profile created: %s", profile.Attributes.Name)
+	warnPersonalTeamProfile(*profile)
+	m.warnMissingDEREntitlements(*profile)
+
+	return profile, checkApprovalEntitlements(*profile, entitlements)
+}
+
+// deleteStaleKeyedProfiles deletes the Bitrise managed profiles of the app ID with the same type, named with a different profile key
+// (<legacy name> [<key>]) than the created profile. Those profiles were generated for different entitlements or certificates,
+// they would be orphaned, since the profile of the current key is looked up by its name.
+func (m ProfileManager) deleteStaleKeyedProfiles(bundleID appstoreconnect.BundleID, legacyName, name string, profileType appstoreconnect.ProfileType) error {
+	// the app ID planned by a dry run has no profiles
+	if bundleID.ID == "" {
+		return nil
+	}
+
+	profiles, err := m.client.Provisioning.AllProfiles(bundleID.Relationships.Profiles.Links.Related)
+	if err != nil {
+		log.Warnf("  Failed to list the profiles of bundle ID (%s), the profiles of the earlier profile keys are not deleted: %s", bundleID.Attributes.Identifier, err)
+		return nil
+	}
+
+	prefix := legacyName + " ["
+	for _, profile := range profiles {
+		if profile.Attributes.ProfileType != profileType || profile.Attributes.Name == name || !strings.HasPrefix(profile.Attributes.Name, prefix) {
+			continue
+		}
+
+		log.Warnf("  profile of an earlier profile key found: %s, deleting it ...", profile.Attributes.Name)
+		change := PortalChange{Action: DeleteProfileChange, Subject: profile.Attributes.Name, BundleID: bundleID.Attributes.Identifier, Reason: fmt.Sprintf("profile of an earlier profile key, replaced by %s", name)}
+		if err := m.portalChanges.Register(change); err != nil {
+			return err
+		}
+		if m.portalChanges.IsDryRun() {
+			continue
+		}
+		err := DeleteProfile(m.client, profile.ID)
+		m.portalChanges.RecordOutcome(change, err)
+		if err != nil {
+			return fmt.Errorf("failed to delete profile: %s", err)
+		}
+	}
+	return nil
+}
+
+// createProfile creates the profile, it returns true if the profile was created by a previous attempt and adopted instead.
+func (m ProfileManager) createProfile(name string, profileType appstoreconnect.ProfileType, bundleID *appstoreconnect.BundleID, entitlements serialized.Object, certIDs []string, deviceIDs []string, minProfileDaysValid int) (*appstoreconnect.Profile, bool, error) {
+	profile, err := CreateProfile(m.client, name, profileType, *bundleID, certIDs, deviceIDs)
+	if err == nil {
+		return profile, false, nil
+	}
+	if !isMultipleProfileErr(err) {
+		return nil, false, fmt.Errorf("failed to create profile: %s", err)
+	}
+
+	// Expired profiles are not listed via profiles endpoint,
+	// so we can not catch if the profile already exist but expired, before we attempt to create one with the managed profile name.
+	// As a workaround we use the BundleID profiles relationship url to find and delete the expired profile.
+	adopted, err := m.adoptCreatedProfile(bundleID, name, entitlements, certIDs, deviceIDs, minProfileDaysValid)
+	if err != nil {
+		return nil, false, err
+	}
+	if adopted != nil {
+		return adopted, true, nil
+	}
+
+	log.Warnf("  Profile already exists, but expired, cleaning up...")
+	if err := m.deleteExpiredProfile(bundleID, name); err != nil {
+		return nil, false, fmt.Errorf("expired profile cleanup failed: %s", err)
+	}
+
+	profile, err = CreateProfile(m.client, name, profileType, *bundleID, certIDs, deviceIDs)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create profile: %s", err)
+	}
+	return profile, false, nil
+}
+
+// findManagedProfile returns the Bitrise managed profile of the bundle ID (nil if there is none) and the name of the profile to generate,
+// if it needs to be (re)generated. The profile named with the profile key is preferred, the profile named without the key
+// (generated by the earlier versions of the Step) is adopted while it is in sync with the project.
+func (m ProfileManager) findManagedProfile(name, legacyName string, profileType appstoreconnect.ProfileType, bundleIDIdentifier string) (*appstoreconnect.Profile, string, error) {
+	profile, err := FindProfile(m.client, name, profileType, bundleIDIdentifier)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find profile: %s", err)
+	}
+	if profile != nil {
+		return m.resolveProfileNameCollision(*profile, name, profileType, bundleIDIdentifier)
+	}
+
+	legacyProfile, err := FindProfile(m.client, legacyName, profileType, bundleIDIdentifier)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find profile: %s", err)
+	}
+	if legacyProfile == nil {
+		return nil, name, nil
+	}
+
+	// The profile key includes the bundle ID, a profile of a different app ID with the name without the key does not collide
+	bundleID, err := ProfileBundleID(m.client, *legacyProfile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get the app ID of profile (%s): %s", legacyName, err)
+	}
+	if bundleID.Attributes.Identifier != bundleIDIdentifier {
+		return nil, name, nil
+	}
+
+	log.Printf("  profile without profile key found: %s, it is regenerated as %s once it is not in sync with the project", legacyProfile.Attributes.Name, name)
+	return legacyProfile, name, nil
+}
+
+// adoptCreatedProfile returns the active profile with the given name, if it is in sync with the project.
+// Such a profile was created by a previous attempt (a retried build or a timed out creation request),
+// but it is not listed by the profiles endpoint yet, so the creation of the same profile fails.
+func (m ProfileManager) adoptCreatedProfile(bundleID *appstoreconnect.BundleID, name string, entitlements serialized.Object, certIDs, deviceIDs []string, minProfileDaysValid int) (*appstoreconnect.Profile, error) {
+	profiles, err := m.client.Provisioning.AllProfiles(bundleID.Relationships.Profiles.Links.Related)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles of bundle ID (%s): %s", bundleID.Attributes.Identifier, err)
+	}
+
+	for i := range profiles {
+		profile := profiles[i]
+		if profile.Attributes.Name != name || profile.Attributes.ProfileState != appstoreconnect.Active || IncompleteProfileReason(profile) != "" {
+			continue
+		}
+
+		err := CheckProfile(m.client, profile, Entitlement(entitlements), deviceIDs, certIDs, minProfileDaysValid)
+		if _, ok := err.(NonmatchingProfileError); ok {
+			log.Debugf("  profile (%s) is not in sync with the project: %s", profile.Attributes.Name, err)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to check if profile is valid: %s", err)
+		}
+		return &profile, nil
+	}
+	return nil, nil
+}
+
+// resolveProfileNameCollision checks if the Bitrise managed profile belongs to the app ID of the bundle ID.
+// If it belongs to a different app ID (for example after a bundle ID refactor), the profile name is freed up by the profile name collision policy:
+// the colliding profile is deleted, or the profile with the renamed name is used instead.
+// It returns the profile to check (nil if it needs to be generated) and the name of the Bitrise managed profile.
+func (m ProfileManager) resolveProfileNameCollision(profile appstoreconnect.Profile, name string, profileType appstoreconnect.ProfileType, bundleIDIdentifier string) (*appstoreconnect.Profile, string, error) {
+	bundleID, err := ProfileBundleID(m.client, profile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get the app ID of profile (%s): %s", name, err)
+	}
+	if bundleID.Attributes.Identifier == bundleIDIdentifier {
+		return &profile, name, nil
+	}
+
+	reason := fmt.Sprintf("profile name is taken by a profile of a different app ID (%s)", bundleID.Attributes.Identifier)
+	switch m.ProfileNameCollision {
+	case DeleteCollidingProfile:
+		log.Warnf("  %s, deleting it ...", reason)
+		change := PortalChange{Action: DeleteProfileChange, Subject: name, BundleID: bundleID.Attributes.Identifier, Reason: reason}
+		if err := m.portalChanges.Register(change); err != nil {
+			return nil, "", err
+		}
+		if m.portalChanges.IsDryRun() {
+			return nil, name, nil
+		}
+		err := DeleteProfile(m.client, profile.ID)
+		m.portalChanges.RecordOutcome(change, err)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to delete profile: %s", err)
+		}
+		return nil, name, nil
+	case RenameProfileOnNameCollision:
+		renamed := RenamedProfileName(name)
+		log.Warnf("  %s, using profile name: %s", reason, renamed)
+
+		renamedProfile, err := FindProfile(m.client, renamed, profileType, bundleIDIdentifier)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to find profile: %s", err)
+		}
+		if renamedProfile == nil {
+			return nil, renamed, nil
+		}
+
+		renamedBundleID, err := ProfileBundleID(m.client, *renamedProfile)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get the app ID of profile (%s): %s", renamed, err)
+		}
+		if renamedBundleID.Attributes.Identifier != bundleIDIdentifier {
+			return nil, "", fmt.Errorf("profile names (%s) and (%s) are taken by profiles of different app IDs (%s, %s)", name, renamed, bundleID.Attributes.Identifier, renamedBundleID.Attributes.Identifier)
+		}
+		return renamedProfile, renamed, nil
+	default:
+		return nil, "", fmt.Errorf("%s: %s, delete the profile or set the profile_name_collision input to delete or rename", name, reason)
+	}
+}
+
+// warnMissingDEREntitlements warns if the generated profile has no DER encoded entitlements, while the installed Xcode requires them
+func (m ProfileManager) warnMissingDEREntitlements(profile appstoreconnect.Profile) {
+	if !m.RequireDEREntitlements {
+		return
+	}
+	if err := CheckProfileDEREntitlements(profile); err != nil {
+		log.Warnf("  %s, the build might fail", err)
+	}
+}
+
+// warnPersonalTeamProfile warns about the limitations, if the profile is generated for a personal (free) team
+func warnPersonalTeamProfile(profile appstoreconnect.Profile) {
+	if IsPersonalTeamProfile(profile) {
+		log.Warnf("  the profile is valid for 7 days only, the team seems to be a personal (free) team")
+		log.Warnf("  %s", PersonalTeamHint)
+	}
+}
+
+// checkProfileQuota warns if the bundle ID's profile count is near the Developer Portal limit,
+// and deletes the expired and invalid Bitrise managed profiles if the cleanup is enabled.
+func (m ProfileManager) checkProfileQuota(bundleID appstoreconnect.BundleID) error {
+	// the app ID planned by a dry run has no profiles
+	if m.ProfileQuotaLimit <= 0 || bundleID.ID == "" {
+		return nil
+	}
+
+	quota, err := FetchProfileQuota(m.client, bundleID, m.ProfileQuotaLimit)
+	if err != nil {
+		log.Warnf("  Failed to check profile quota: %s", err)
+		return nil
+	}
+	if !quota.NearLimit() {
+		log.Debugf("  %s", quota)
+		return nil
+	}
+
+	log.Warnf("  Approaching the profile limit: %s", quota)
+
+	candidates := quota.CleanupCandidates(time.Now())
+	if len(candidates) == 0 {
+		log.Warnf("  No expired or invalid Bitrise managed profiles to delete, remove unused profiles on the Developer Portal")
+		return nil
+	}
+	if !m.ProfileCleanup {
+		log.Warnf("  %d expired or invalid Bitrise managed profile(s) can be deleted, set the Profile cleanup (profile_cleanup) input to yes to delete them", len(candidates))
+		return nil
+	}
+
+	for _, profile := range candidates {
+		change := PortalChange{Action: DeleteExpiredProfileChange, Subject: profile.Attributes.Name, BundleID: bundleID.Attributes.Identifier, Reason: "profile cleanup (profile_cleanup input): " + quota.String()}
+		if err := m.portalChanges.Register(change); err != nil {
+			return err
+		}
+		if m.portalChanges.IsDryRun() {
+			continue
+		}
+		err := DeleteProfile(m.client, profile.ID)
+		m.portalChanges.RecordOutcome(change, err)
+		if err != nil {
+			return fmt.Errorf("failed to delete profile: %s", err)
+		}
+		log.Printf("  deleted profile: %s (%s)", profile.Attributes.Name, profile.ID)
+	}
+	if m.portalChanges.IsDryRun() {
+		return nil
+	}
+	log.Donef("  %d profile(s) deleted", len(candidates))
+
+	return nil
+}
+
+func checkApprovalEntitlements(profile appstoreconnect.Profile, entitlements serialized.Object) error {
+	if err := CheckProfileApprovalEntitlements(profile, Entitlement(entitlements)); err != nil {
+		return fmt.Errorf("%s\nMake sure the granted capability is enabled for the app ID on Apple Developer Portal", err)
+	}
+	return nil
+}
+
+func (m ProfileManager) deleteExpiredProfile(bundleID *appstoreconnect.BundleID, profileName string) error {
+	profiles, err := m.client.Provisioning.AllProfiles(bundleID.Relationships.Profiles.Links.Related)
+	if err != nil {
+		return err
+	}
+
+	var profile *appstoreconnect.Profile
+	for i := range profiles {
+		if profiles[i].Attributes.Name == profileName {
+			profile = &profiles[i]
+			break
+		}
+	}
+
+	if profile == nil {
+		return fmt.Errorf("failed to find profile: %s", profileName)
+	}
+
+	change := PortalChange{Action: DeleteExpiredProfileChange, Subject: profileName, BundleID: bundleID.Attributes.Identifier, Reason: "an expired profile blocks creating the profile with the same name"}
+	if err := m.portalChanges.Register(change); err != nil {
+		return err
+	}
+
+	err = m.client.Provisioning.DeleteProfile(profile.ID)
+	m.portalChanges.RecordOutcome(change, err)
+	return err
+}
+
+func isMultipleProfileErr(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "multiple profiles found with the name")
+}
@@ -0,0 +1,684 @@
+package autoprovision
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/testutil/ascmock"
+	"github.com/fullsailor/pkcs7"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockClient struct {
+	mock.Mock
+	postProfileSuccess bool
+}
+
+func (c *MockClient) Do(req *http.Request) (*http.Response, error) {
+	fmt.Printf("do called: %#v - %#v\n", req.Method, req.URL.Path)
+
+	switch {
+	case req.URL.Path == "/v1/profiles" && req.Method == "GET":
+		return c.GetProfiles(req)
+	case req.URL.Path == "/v1/profiles" && req.Method == "POST":
+		// First profile create request fails by 'Multiple profiles found' error
+		if !c.postProfileSuccess {
+			c.postProfileSuccess = true
+			return c.PostProfilesFailed(req)
+		}
+		// After deleting the expired profile, creating a new one succeed
+		return c.PostProfilesSuccess(req)
+	case req.URL.Path == "/v1//bundleID/capabilities" && req.Method == "GET":
+		return c.GetBundleIDCapabilities(req)
+	case req.URL.Path == "/v1//bundleID/profiles" && req.Method == "GET":
+		return c.GetBundleIDProfiles(req)
+	case req.URL.Path == "/v1/profiles/1" && req.Method == "DELETE":
+		return c.DeleteProfiles(req)
+	}
+
+	return nil, fmt.Errorf("invalid endpoint called: %s, method: %s", req.URL.Path, req.Method)
+}
+
+func (c *MockClient) GetProfiles(req *http.Request) (*http.Response, error) {
+	args := c.Called(req)
+	return args.Get(0).(*http.Response), args.Error(1)
+}
+
+func (c *MockClient) PostProfilesFailed(req *http.Request) (*http.Response, error) {
+	args := c.Called(req)
+	return args.Get(0).(*http.Response), args.Error(1)
+}
+
+func (c *MockClient) GetBundleIDCapabilities(req *http.Request) (*http.Response, error) {
+	args := c.Called(req)
+	return args.Get(0).(*http.Response), args.Error(1)
+}
+
+func (c *MockClient) GetBundleIDProfiles(req *http.Request) (*http.Response, error) {
+	args := c.Called(req)
+	return args.Get(0).(*http.Response), args.Error(1)
+}
+
+func (c *MockClient) DeleteProfiles(req *http.Request) (*http.Response, error) {
+	args := c.Called(req)
+	return args.Get(0).(*http.Response), args.Error(1)
+}
+
+func (c *MockClient) PostProfilesSuccess(req *http.Request) (*http.Response, error) {
+	args := c.Called(req)
+	return args.Get(0).(*http.Response), args.Error(1)
+}
+
+func newResponse(t *testing.T, status int, body map[string]interface{}) *http.Response {
+	resp := http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(nil),
+	}
+
+	if body != nil {
+		var buff bytes.Buffer
+		require.NoError(t, json.NewEncoder(&buff).Encode(body))
+		resp.Body = ioutil.NopCloser(&buff)
+		resp.ContentLength = int64(buff.Len())
+	}
+
+	return &resp
+}
+
+func TestEnsureProfile_ExpiredProfile(t *testing.T) {
+	// Arrange
+	key, err := ProfileKey("io.bitrise.testapp", serialized.Object(map[string]interface{}{}), []string{})
+	require.NoError(t, err)
+	name := KeyedProfileName("Bitrise iOS development - (io.bitrise.testapp)", key)
+
+	mockClient := &MockClient{}
+
+	mockClient.
+		On("GetProfiles", mock.AnythingOfType("*http.Request")).
+		Return(newResponse(t, http.StatusOK, map[string]interface{}{}), nil)
+
+	mockClient.
+		On("PostProfilesFailed", mock.AnythingOfType("*http.Request")).
+		Return(newResponse(t, http.StatusConflict,
+			map[string]interface{}{
+				"errors": []interface{}{map[string]interface{}{"detail": "ENTITY_ERROR: There is a problem with the request entity: Multiple profiles found with the name '" + name + "'.  Please remove the duplicate profiles and try again."}},
+			}), nil)
+
+	mockClient.
+		On("GetBundleIDCapabilities", mock.AnythingOfType("*http.Request")).
+		Return(newResponse(t, http.StatusOK, map[string]interface{}{}), nil)
+
+	// the profiles of the bundle ID are listed twice: looking for a profile created by a previous attempt, then for the expired profile
+	for i := 0; i < 2; i++ {
+		mockClient.
+			On("GetBundleIDProfiles", mock.AnythingOfType("*http.Request")).
+			Return(newResponse(t, http.StatusOK,
+				map[string]interface{}{
+					"data": []interface{}{
+						map[string]interface{}{
+							"attributes": map[string]interface{}{"name": name},
+							"id":         "1",
+						},
+					}},
+			), nil).
+			Once()
+	}
+
+	mockClient.
+		On("DeleteProfiles", mock.AnythingOfType("*http.Request")).
+		Return(newResponse(t, http.StatusOK, map[string]interface{}{}), nil)
+
+	mockClient.
+		On("PostProfilesSuccess", mock.AnythingOfType("*http.Request")).
+		Return(newResponse(t, http.StatusOK, map[string]interface{}{}), nil)
+
+	client := appstoreconnect.NewClient(mockClient, "keyID", "issueID", []byte("privateKey"))
+	manager := ProfileManager{
+		client: client,
+		mu:     &sync.Mutex{},
+		// cache io.bitrise.testapp bundle ID, so that no need to mock bundle ID GET requests
+		bundleIDByBundleIDIdentifer: map[string]*appstoreconnect.BundleID{"io.bitrise.testapp": &appstoreconnect.BundleID{
+			Relationships: appstoreconnect.BundleIDRelationships{
+				Profiles: appstoreconnect.RelationshipsLinks{
+					Links: appstoreconnect.Links{
+						Related: "https://api.appstoreconnect.apple.com/v1/bundleID/profiles",
+					},
+				},
+				Capabilities: appstoreconnect.RelationshipsLinks{
+					Links: appstoreconnect.Links{
+						Related: "https://api.appstoreconnect.apple.com/v1/bundleID/capabilities",
+					},
+				},
+			},
+		}},
+		containersByBundleID: nil}
+
+	// Act
+	profile, err := manager.EnsureProfile(
+		appstoreconnect.IOSAppDevelopment,
+		"io.bitrise.testapp",
+		serialized.Object(map[string]interface{}{}),
+		[]string{},
+		[]string{},
+		0,
+	)
+
+	// Assert
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	mockClient.AssertExpectations(t)
+}
+func TestProfileManager_resolveProfileNameCollision(t *testing.T) {
+	const name = "Bitrise iOS development - (io.bitrise.testapp)"
+	newProfile := func(id, name, bundleIDID string) ascmock.Profile {
+		return ascmock.Profile{
+			Profile: appstoreconnect.Profile{
+				ID:         id,
+				Attributes: appstoreconnect.ProfileAttributes{Name: name, ProfileType: appstoreconnect.IOSAppDevelopment, ProfileState: appstoreconnect.Active},
+			},
+			BundleIDID: bundleIDID,
+		}
+	}
+	bundleIDs := []appstoreconnect.BundleID{
+		{ID: "OLD", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.old", Platform: string(appstoreconnect.IOS)}},
+		{ID: "APP", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.testapp", Platform: string(appstoreconnect.IOS)}},
+	}
+
+	tests := []struct {
+		name         string
+		policy       ProfileNameCollisionPolicy
+		profiles     []ascmock.Profile
+		wantProfile  string
+		wantName     string
+		wantProfiles int
+		wantErr      bool
+	}{
+		{
+			name:         "profile of the app ID",
+			policy:       FailOnProfileNameCollision,
+			profiles:     []ascmock.Profile{newProfile("1", name, "APP")},
+			wantProfile:  "1",
+			wantName:     name,
+			wantProfiles: 1,
+		},
+		{
+			name:         "colliding profile is deleted",
+			policy:       DeleteCollidingProfile,
+			profiles:     []ascmock.Profile{newProfile("1", name, "OLD")},
+			wantName:     name,
+			wantProfiles: 0,
+		},
+		{
+			name:         "renamed profile is generated",
+			policy:       RenameProfileOnNameCollision,
+			profiles:     []ascmock.Profile{newProfile("1", name, "OLD")},
+			wantName:     name + " (2)",
+			wantProfiles: 1,
+		},
+		{
+			name:         "renamed profile is used",
+			policy:       RenameProfileOnNameCollision,
+			profiles:     []ascmock.Profile{newProfile("1", name, "OLD"), newProfile("2", name+" (2)", "APP")},
+			wantProfile:  "2",
+			wantName:     name + " (2)",
+			wantProfiles: 2,
+		},
+		{
+			name:         "renamed profile collides too",
+			policy:       RenameProfileOnNameCollision,
+			profiles:     []ascmock.Profile{newProfile("1", name, "OLD"), newProfile("2", name+" (2)", "OLD")},
+			wantErr:      true,
+			wantProfiles: 2,
+		},
+		{
+			name:         "collision fails",
+			policy:       FailOnProfileNameCollision,
+			profiles:     []ascmock.Profile{newProfile("1", name, "OLD")},
+			wantErr:      true,
+			wantProfiles: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := ascmock.New(ascmock.Fixtures{BundleIDs: bundleIDs, Profiles: tt.profiles})
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+			require.NoError(t, err)
+
+			manager := ProfileManager{client: client, portalChanges: NewPortalChanges(0), ProfileNameCollision: tt.policy}
+			profile, err := FindProfile(client, name, appstoreconnect.IOSAppDevelopment, "io.bitrise.testapp")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+
+			got, gotName, err := manager.resolveProfileNameCollision(*profile, name, appstoreconnect.IOSAppDevelopment, "io.bitrise.testapp")
+			require.Equal(t, tt.wantProfiles, len(server.State().Profiles))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantName, gotName)
+			if tt.wantProfile == "" {
+				require.Nil(t, got)
+			} else {
+				require.Equal(t, tt.wantProfile, got.ID)
+			}
+		})
+	}
+}
+
+func TestEnsureProfile_IncompleteProfile(t *testing.T) {
+	const name = "Bitrise iOS development - (io.bitrise.testapp)"
+	bundleIDs := []appstoreconnect.BundleID{
+		{ID: "APP", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.testapp", Platform: string(appstoreconnect.IOS)}},
+	}
+	// Apple lists the profiles without content during their propagation
+	incomplete := ascmock.Profile{
+		Profile: appstoreconnect.Profile{
+			ID: "INCOMPLETE",
+			Attributes: appstoreconnect.ProfileAttributes{
+				Name:           name,
+				ProfileType:    appstoreconnect.IOSAppDevelopment,
+				ProfileState:   appstoreconnect.Active,
+				UUID:           "c5be4123-1234-4f9d-9843-0d9be985a068",
+				ExpirationDate: appstoreconnect.Time(time.Now().Add(24 * time.Hour)),
+			},
+		},
+		BundleIDID: "APP",
+	}
+
+	server := ascmock.New(ascmock.Fixtures{BundleIDs: bundleIDs, Profiles: []ascmock.Profile{incomplete}, ProfileContent: []byte("content")})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	manager := ProfileManager{
+		client:                      client,
+		mu:                          &sync.Mutex{},
+		bundleIDByBundleIDIdentifer: map[string]*appstoreconnect.BundleID{},
+		portalChanges:               NewPortalChanges(0),
+	}
+	profile, err := manager.EnsureProfile(appstoreconnect.IOSAppDevelopment, "io.bitrise.testapp", serialized.Object{}, nil, nil, 0)
+	require.NoError(t, err)
+	require.NotEqual(t, "INCOMPLETE", profile.ID, "the incomplete profile is regenerated")
+
+	profiles := server.State().Profiles
+	require.Equal(t, 1, len(profiles))
+	require.Equal(t, profile.ID, profiles[0].ID)
+}
+
+// signedTestProfile returns a signed provisioning profile without entitlements
+func signedTestProfile(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Apple iPhone OS Provisioning Profile Signing"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+	}
+	certData, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certData)
+	require.NoError(t, err)
+
+	signedData, err := pkcs7.NewSignedData([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Entitlements</key>
+	<dict/>
+</dict>
+</plist>`))
+	require.NoError(t, err)
+	require.NoError(t, signedData.AddSigner(cert, key, pkcs7.SignerInfoConfig{}))
+	profile, err := signedData.Finish()
+	require.NoError(t, err)
+	return profile
+}
+
+func TestEnsureProfile_ProfileOfPreviousAttempt(t *testing.T) {
+	key, err := ProfileKey("io.bitrise.testapp", serialized.Object{}, nil)
+	require.NoError(t, err)
+	name := KeyedProfileName("Bitrise iOS development - (io.bitrise.testapp)", key)
+
+	bundleIDs := []appstoreconnect.BundleID{
+		{ID: "APP", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.testapp", Platform: string(appstoreconnect.IOS)}},
+	}
+	// the profile created by a timed out attempt of a retried build
+	created := ascmock.Profile{
+		Profile: appstoreconnect.Profile{
+			ID: "CREATED",
+			Attributes: appstoreconnect.ProfileAttributes{
+				Name:           name,
+				ProfileType:    appstoreconnect.IOSAppDevelopment,
+				ProfileState:   appstoreconnect.Active,
+				ProfileContent: signedTestProfile(t),
+				UUID:           "c5be4123-1234-4f9d-9843-0d9be985a068",
+				ExpirationDate: appstoreconnect.Time(time.Now().Add(365 * 24 * time.Hour)),
+			},
+		},
+		BundleIDID: "APP",
+	}
+
+	server := ascmock.New(ascmock.Fixtures{BundleIDs: bundleIDs, Profiles: []ascmock.Profile{created}, ProfileContent: []byte("content")})
+	// the profiles endpoint does not list the profile yet, so creating it fails with a name collision
+	server.AddFault(ascmock.Fault{Method: http.MethodGet, Path: "/v1/profiles", StatusCode: http.StatusOK, ContentType: "application/json", Body: `{"data":[]}`, Times: 2})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	manager := ProfileManager{
+		client:                      client,
+		mu:                          &sync.Mutex{},
+		bundleIDByBundleIDIdentifer: map[string]*appstoreconnect.BundleID{},
+		portalChanges:               NewPortalChanges(0),
+	}
+	profile, err := manager.EnsureProfile(appstoreconnect.IOSAppDevelopment, "io.bitrise.testapp", serialized.Object{}, nil, nil, 0)
+	require.NoError(t, err)
+	require.Equal(t, "CREATED", profile.ID)
+	require.Equal(t, 1, len(server.State().Profiles))
+}
+
+func TestEnsureProfile_DryRun(t *testing.T) {
+	server := ascmock.New(ascmock.Fixtures{})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+	client.SetReadOnly()
+
+	portalChanges := NewPortalChanges(0)
+	portalChanges.DryRun = true
+	manager := ProfileManager{
+		client:                      client,
+		bundleIDByBundleIDIdentifer: map[string]*appstoreconnect.BundleID{},
+		containersByBundleID:        map[string][]string{},
+		mu:                          &sync.Mutex{},
+		portalChanges:               portalChanges,
+		ProfileQuotaLimit:           100,
+	}
+	profile, err := manager.EnsureProfile(appstoreconnect.IOSAppDevelopment, "io.bitrise.testapp", serialized.Object{}, nil, nil, 0)
+	require.NoError(t, err)
+	require.Equal(t, "", profile.ID, "the planned profile is not created")
+
+	var actions []PortalChangeAction
+	for _, change := range portalChanges.Changes {
+		actions = append(actions, change.Action)
+	}
+	require.Equal(t, []PortalChangeAction{CreateBundleIDChange, CreateProfileChange}, actions)
+
+	for _, request := range server.Requests() {
+		require.True(t, strings.HasPrefix(request, http.MethodGet+" "), "dry run sent a request changing the Developer Portal: %s", request)
+	}
+	require.Equal(t, 0, len(server.State().BundleIDs))
+}
+
+func TestProfileManager_EnsureBundleID_newAppClip(t *testing.T) {
+	server := ascmock.New(ascmock.Fixtures{})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	manager := ProfileManager{
+		client:                      client,
+		bundleIDByBundleIDIdentifer: map[string]*appstoreconnect.BundleID{},
+		containersByBundleID:        map[string][]string{},
+		mu:                          &sync.Mutex{},
+		portalChanges:               NewPortalChanges(0),
+	}
+	entitlements, err := AppClipEntitlements(serialized.Object{parentApplicationIdentifiersEntitlementKey: []interface{}{"$(AppIdentifierPrefix)io.bitrise.app"}}, "io.bitrise.app.Clip", "io.bitrise.app")
+	require.NoError(t, err)
+
+	_, err = manager.EnsureBundleID("io.bitrise.app.Clip", entitlements, IOS)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "manually using the Apple Developer Portal")
+	require.Equal(t, 0, len(server.State().BundleIDs), "the App Clip's app ID is not created without its parent app")
+}
+
+func TestEnsureProfile_BuildCache(t *testing.T) {
+	key, err := ProfileKey("io.bitrise.testapp", serialized.Object{}, nil)
+	require.NoError(t, err)
+	name := KeyedProfileName("Bitrise iOS development - (io.bitrise.testapp)", key)
+	bundleIDs := []appstoreconnect.BundleID{
+		{ID: "APP", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.testapp", Platform: string(appstoreconnect.IOS)}},
+	}
+	cached := appstoreconnect.Profile{
+		ID: "CACHED",
+		Attributes: appstoreconnect.ProfileAttributes{
+			Name:           name,
+			ProfileType:    appstoreconnect.IOSAppDevelopment,
+			ProfileState:   appstoreconnect.Active,
+			ProfileContent: []byte("content"),
+			ExpirationDate: appstoreconnect.Time(time.Now().Add(365 * 24 * time.Hour)),
+		},
+	}
+
+	tests := []struct {
+		name        string
+		profiles    []ascmock.Profile
+		wantProfile string
+		wantCreated bool
+	}{
+		{
+			name:        "cached profile is active",
+			profiles:    []ascmock.Profile{{Profile: cached, BundleIDID: "APP"}},
+			wantProfile: "CACHED",
+		},
+		{
+			name:        "cached profile was deleted",
+			wantCreated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buildCache := NewBuildCache(t.TempDir(), "TEAM", 0)
+			require.NoError(t, buildCache.StoreProfile(appstoreconnect.IOSAppDevelopment, "io.bitrise.testapp", key, nil, cached, time.Now()))
+
+			server := ascmock.New(ascmock.Fixtures{BundleIDs: bundleIDs, Profiles: tt.profiles, ProfileContent: []byte("content")})
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+			require.NoError(t, err)
+
+			manager := ProfileManager{
+				client:                      client,
+				bundleIDByBundleIDIdentifer: map[string]*appstoreconnect.BundleID{},
+				containersByBundleID:        map[string][]string{},
+				mu:                          &sync.Mutex{},
+				portalChanges:               NewPortalChanges(0),
+				BuildCache:                  buildCache,
+			}
+			profile, err := manager.EnsureProfile(appstoreconnect.IOSAppDevelopment, "io.bitrise.testapp", serialized.Object{}, nil, nil, 0)
+			require.NoError(t, err)
+
+			if !tt.wantCreated {
+				require.Equal(t, tt.wantProfile, profile.ID)
+				require.Equal(t, []string{"GET /v1/profiles"}, server.Requests(), "the cached profile is checked with a single request")
+				return
+			}
+
+			require.NotEqual(t, "CACHED", profile.ID)
+			require.Equal(t, profile.ID, buildCache.Profile(appstoreconnect.IOSAppDevelopment, "io.bitrise.testapp", key, nil, time.Now()).ID, "the generated profile is cached")
+		})
+	}
+}
+
+func TestEnsureProfile_Concurrent(t *testing.T) {
+	var bundleIDs []appstoreconnect.BundleID
+	var identifiers []string
+	for i := 0; i < 6; i++ {
+		identifier := fmt.Sprintf("io.bitrise.testapp.extension%d", i)
+		identifiers = append(identifiers, identifier)
+		bundleIDs = append(bundleIDs, appstoreconnect.BundleID{ID: fmt.Sprintf("APP%d", i), Attributes: appstoreconnect.BundleIDAttributes{Identifier: identifier, Platform: string(appstoreconnect.IOS)}})
+	}
+
+	server := ascmock.New(ascmock.Fixtures{BundleIDs: bundleIDs, ProfileContent: []byte("content")})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	manager := ProfileManager{
+		client:                      client,
+		bundleIDByBundleIDIdentifer: map[string]*appstoreconnect.BundleID{},
+		containersByBundleID:        map[string][]string{},
+		mu:                          &sync.Mutex{},
+		portalChanges:               NewPortalChanges(0),
+		session:                     NewSession("account"),
+		CapabilityMatrix:            NewCapabilityMatrix(map[string]serialized.Object{}),
+	}
+
+	profiles := make([]*appstoreconnect.Profile, len(identifiers))
+	require.NoError(t, ForEachConcurrently(len(identifiers), 4, func(i int) error {
+		var err error
+		profiles[i], err = manager.EnsureProfile(appstoreconnect.IOSAppDevelopment, identifiers[i], serialized.Object{}, nil, nil, 0)
+		return err
+	}))
+
+	for i, profile := range profiles {
+		require.Contains(t, profile.Attributes.Name, identifiers[i])
+	}
+	require.Len(t, manager.portalChanges.Changes, len(identifiers), "a profile is created per bundle ID")
+	require.Len(t, manager.session.BundleIDsByIdentifier, len(identifiers))
+}
+
+func TestProfileManager_DisableStaleCapabilities(t *testing.T) {
+	capability := func(capabilityType appstoreconnect.CapabilityType) appstoreconnect.BundleIDCapability {
+		return appstoreconnect.BundleIDCapability{ID: "APP_" + string(capabilityType), Attributes: appstoreconnect.BundleIDCapabilityAttributes{CapabilityType: capabilityType}}
+	}
+	server := ascmock.New(ascmock.Fixtures{
+		BundleIDs: []appstoreconnect.BundleID{
+			{ID: "APP", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.testapp", Platform: string(appstoreconnect.IOS)}},
+		},
+		Capabilities: map[string][]appstoreconnect.BundleIDCapability{
+			"APP": {capability(appstoreconnect.PushNotifications), capability(appstoreconnect.AppGroups)},
+		},
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	portalChanges := NewPortalChanges(0)
+	manager := NewProfileManager(client, nil, portalChanges, nil)
+
+	// the push notifications are used by an other configuration, the missing app ID is skipped
+	err = manager.DisableStaleCapabilities(map[string]serialized.Object{
+		"io.bitrise.testapp":         {"aps-environment": "development"},
+		"io.bitrise.testapp.missing": {},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, portalChanges.Changes, 1)
+	require.Equal(t, DisableBundleIDCapabilityChange, portalChanges.Changes[0].Action)
+	require.Equal(t, []appstoreconnect.BundleIDCapability{capability(appstoreconnect.PushNotifications)}, server.State().Capabilities["APP"])
+}
+
+func TestEnsureProfile_deletesStaleKeyedProfiles(t *testing.T) {
+	const legacyName = "Bitrise iOS development - (io.bitrise.testapp)"
+	newProfile := func(id, name string, profileType appstoreconnect.ProfileType) ascmock.Profile {
+		return ascmock.Profile{
+			Profile: appstoreconnect.Profile{
+				ID:         id,
+				Attributes: appstoreconnect.ProfileAttributes{Name: name, ProfileType: profileType, ProfileState: appstoreconnect.Active},
+			},
+			BundleIDID: "APP",
+		}
+	}
+	server := ascmock.New(ascmock.Fixtures{
+		BundleIDs: []appstoreconnect.BundleID{
+			{ID: "APP", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.testapp", Platform: string(appstoreconnect.IOS)}},
+		},
+		Profiles: []ascmock.Profile{
+			newProfile("STALE", legacyName+" [0123abcd]", appstoreconnect.IOSAppDevelopment),
+			newProfile("AD_HOC", "Bitrise iOS ad-hoc - (io.bitrise.testapp) [0123abcd]", appstoreconnect.IOSAppAdHoc),
+			newProfile("MANUAL", "io.bitrise.testapp development", appstoreconnect.IOSAppDevelopment),
+		},
+		ProfileContent: signedTestProfile(t),
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	portalChanges := NewPortalChanges(0)
+	manager := NewProfileManager(client, nil, portalChanges, nil)
+	profile, err := manager.EnsureProfile(appstoreconnect.IOSAppDevelopment, "io.bitrise.testapp", serialized.Object{}, nil, nil, 0)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(profile.Attributes.Name, legacyName+" ["))
+
+	var names []string
+	for _, p := range server.State().Profiles {
+		names = append(names, p.Attributes.Name)
+	}
+	require.ElementsMatch(t, []string{"Bitrise iOS ad-hoc - (io.bitrise.testapp) [0123abcd]", "io.bitrise.testapp development", profile.Attributes.Name}, names)
+	require.Equal(t, DeleteProfileChange, portalChanges.Changes[len(portalChanges.Changes)-1].Action)
+}
+
+func TestEnsureProfile_sharedBundleIDMultiplatformProject(t *testing.T) {
+	// the iOS and the tvOS app of the project ship with the same bundle ID
+	proj, err := xcodeproj.Open(filepath.Join("testdata", "multiplatform", "Shared.xcodeproj"))
+	require.NoError(t, err)
+
+	platformBySDK := map[string]Platform{"iphoneos": IOS, "appletvos": TVOS}
+	platformByBundleID := map[string][]Platform{}
+	for _, target := range proj.Proj.Targets {
+		settings, err := projectFileBuildSettings(proj.Proj, target.Name, "Release", nil)
+		require.NoError(t, err)
+		bundleID, err := settings.String("PRODUCT_BUNDLE_IDENTIFIER")
+		require.NoError(t, err)
+		sdk, err := settings.String("SDKROOT")
+		require.NoError(t, err)
+		platformByBundleID[bundleID] = append(platformByBundleID[bundleID], platformBySDK[sdk])
+	}
+	require.Equal(t, map[string][]Platform{"io.bitrise.shared": {IOS, TVOS}}, platformByBundleID)
+
+	server := ascmock.New(ascmock.Fixtures{ProfileContent: signedTestProfile(t)})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	manager := NewProfileManager(client, nil, NewPortalChanges(0), nil)
+	for _, platform := range platformByBundleID["io.bitrise.shared"] {
+		profileType := PlatformToProfileTypeByDistribution[platform][AppStore]
+		profile, err := manager.EnsureProfile(profileType, "io.bitrise.shared", serialized.Object{}, nil, nil, 0)
+		require.NoError(t, err, platform)
+		require.Equal(t, profileType, profile.Attributes.ProfileType)
+	}
+
+	state := server.State()
+	require.Equal(t, 1, len(state.BundleIDs), "a single app ID is ensured for every platform")
+	require.Equal(t, "io.bitrise.shared", state.BundleIDs[0].Attributes.Identifier)
+	require.Equal(t, string(appstoreconnect.IOS), state.BundleIDs[0].Attributes.Platform)
+
+	var profileTypes []appstoreconnect.ProfileType
+	for _, profile := range state.Profiles {
+		require.Equal(t, state.BundleIDs[0].ID, profile.BundleIDID)
+		profileTypes = append(profileTypes, profile.Attributes.ProfileType)
+	}
+	require.ElementsMatch(t, []appstoreconnect.ProfileType{appstoreconnect.IOSAppStore, appstoreconnect.TvOSAppStore}, profileTypes)
+}
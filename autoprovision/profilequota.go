@@ -0,0 +1,68 @@
+package autoprovision
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// profileQuotaWarningRatio is the ratio of the limit, the quota is considered to be near the limit from
+const profileQuotaWarningRatio = 0.8
+
+// ProfileQuota holds the profiles of a bundle ID, counting against the Developer Portal profile limit.
+// Apple does not document the exact limit, profile creation suddenly starts to fail once it is reached.
+type ProfileQuota struct {
+	BundleID string
+	Limit    int
+	// Profiles includes the expired profiles, as they count against the limit too
+	Profiles []appstoreconnect.Profile
+}
+
+// FetchProfileQuota lists every profile of the bundle ID, including the expired ones, which are not listed by the profiles endpoint.
+func FetchProfileQuota(client *appstoreconnect.Client, bundleID appstoreconnect.BundleID, limit int) (ProfileQuota, error) {
+	quota := ProfileQuota{BundleID: bundleID.Attributes.Identifier, Limit: limit}
+
+	profiles, err := client.Provisioning.AllProfiles(bundleID.Relationships.Profiles.Links.Related)
+	if err != nil {
+		return ProfileQuota{}, fmt.Errorf("failed to list profiles of bundle ID (%s): %s", quota.BundleID, err)
+	}
+	quota.Profiles = profiles
+
+	return quota, nil
+}
+
+// NearLimit returns true if the number of profiles reached the warning ratio of the limit.
+// It is always false if the limit is not set.
+func (q ProfileQuota) NearLimit() bool {
+	if q.Limit <= 0 {
+		return false
+	}
+	return float64(len(q.Profiles)) >= float64(q.Limit)*profileQuotaWarningRatio
+}
+
+// CleanupCandidates returns the Bitrise managed profiles, which can be deleted to free up the quota:
+// the expired and the invalid ones.
+func (q ProfileQuota) CleanupCandidates(now time.Time) []appstoreconnect.Profile {
+	var profiles []appstoreconnect.Profile
+	for _, profile := range q.Profiles {
+		if !isBitriseManagedProfile(profile) {
+			continue
+		}
+		if profile.Attributes.ProfileState == appstoreconnect.Invalid || time.Time(profile.Attributes.ExpirationDate).Before(now) {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// String ...
+func (q ProfileQuota) String() string {
+	return fmt.Sprintf("bundle ID (%s) has %d profile(s), the limit is about %d", q.BundleID, len(q.Profiles), q.Limit)
+}
+
+// isBitriseManagedProfile returns true if the profile was generated by the Step, see ProfileName.
+func isBitriseManagedProfile(profile appstoreconnect.Profile) bool {
+	return strings.HasPrefix(profile.Attributes.Name, "Bitrise ")
+}
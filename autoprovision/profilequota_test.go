@@ -0,0 +1,53 @@
+package autoprovision
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileQuota_NearLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		profiles int
+		want     bool
+	}{
+		{name: "no limit", limit: 0, profiles: 100, want: false},
+		{name: "below the warning ratio", limit: 10, profiles: 7, want: false},
+		{name: "at the warning ratio", limit: 10, profiles: 8, want: true},
+		{name: "over the limit", limit: 10, profiles: 11, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := ProfileQuota{Limit: tt.limit, Profiles: make([]appstoreconnect.Profile, tt.profiles)}
+			require.Equal(t, tt.want, q.NearLimit())
+		})
+	}
+}
+
+func TestProfileQuota_CleanupCandidates(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	profile := func(id, name string, state appstoreconnect.ProfileState, expiration time.Time) appstoreconnect.Profile {
+		return appstoreconnect.Profile{ID: id, Attributes: appstoreconnect.ProfileAttributes{
+			Name:           name,
+			ProfileState:   state,
+			ExpirationDate: appstoreconnect.Time(expiration),
+		}}
+	}
+
+	q := ProfileQuota{Profiles: []appstoreconnect.Profile{
+		profile("1", "Bitrise iOS development - (io.bitrise.app)", appstoreconnect.Active, now.Add(24*time.Hour)),
+		profile("2", "Bitrise iOS app-store - (io.bitrise.app)", appstoreconnect.Active, now.Add(-24*time.Hour)),
+		profile("3", "Bitrise iOS ad-hoc - (io.bitrise.app)", appstoreconnect.Invalid, now.Add(24*time.Hour)),
+		profile("4", "Manual profile", appstoreconnect.Invalid, now.Add(-24*time.Hour)),
+	}}
+
+	var ids []string
+	for _, p := range q.CleanupCandidates(now) {
+		ids = append(ids, p.ID)
+	}
+	require.Equal(t, []string{"2", "3"}, ids)
+}
@@ -0,0 +1,119 @@
+package autoprovision
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// maxProjectSearchDepth is the directory depth, the projects and workspaces are searched to
+const maxProjectSearchDepth = 4
+
+// skippedProjectSearchDirs are the dependency and build directories, which never hold the project of the app
+var skippedProjectSearchDirs = map[string]bool{
+	"Pods":         true,
+	"Carthage":     true,
+	"node_modules": true,
+	"DerivedData":  true,
+	"build":        true,
+}
+
+// FindProjectPath returns the path if it is a project (.xcodeproj) or workspace (.xcworkspace),
+// otherwise it searches the directory for them, so that the Step keeps working after a project rename or folder restructure.
+// Among the found ones it prefers the ones containing the scheme, the workspaces over the projects, then the shallower paths.
+func FindProjectPath(pth, schemeName string) (string, error) {
+	if isProjectOrWorkspace(pth) {
+		return pth, nil
+	}
+
+	candidates, err := findProjectsAndWorkspaces(pth)
+	if err != nil {
+		return "", fmt.Errorf("failed to search projects and workspaces in %s: %s", pth, err)
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no project (.xcodeproj) or workspace (.xcworkspace) found in %s", pth)
+	}
+
+	var withScheme []string
+	for _, candidate := range candidates {
+		schemes, err := ListSchemes(candidate)
+		if err != nil {
+			log.Debugf("Failed to list the schemes of %s: %s", candidate, err)
+			continue
+		}
+		if _, err := matchSchemeName(schemeName, schemes); err == nil {
+			withScheme = append(withScheme, candidate)
+		}
+	}
+
+	selected := withScheme
+	if len(selected) == 0 {
+		if len(candidates) > 1 {
+			return "", fmt.Errorf("none of the projects and workspaces found in %s contains the scheme (%s): %s", pth, schemeName, strings.Join(candidates, ", "))
+		}
+		selected = candidates
+	}
+
+	sortProjectCandidates(selected)
+	log.Printf("Project path (%s) is a directory, using %s", pth, selected[0])
+	if len(selected) > 1 {
+		log.Warnf("Multiple projects or workspaces contain the scheme (%s): %s", schemeName, strings.Join(selected, ", "))
+		log.Warnf("Set the project path input to the one to use")
+	}
+	return selected[0], nil
+}
+
+func isProjectOrWorkspace(pth string) bool {
+	ext := filepath.Ext(pth)
+	return ext == ".xcodeproj" || ext == ".xcworkspace"
+}
+
+// findProjectsAndWorkspaces returns the projects and workspaces in the directory, except the ones embedded in a project.
+func findProjectsAndWorkspaces(dir string) ([]string, error) {
+	var found []string
+	err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || pth == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, pth)
+		if err != nil {
+			return err
+		}
+		if strings.Count(rel, string(filepath.Separator)) >= maxProjectSearchDepth {
+			return filepath.SkipDir
+		}
+
+		if isProjectOrWorkspace(pth) {
+			found = append(found, pth)
+			return filepath.SkipDir
+		}
+		if strings.HasPrefix(info.Name(), ".") || skippedProjectSearchDirs[info.Name()] {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return found, err
+}
+
+// sortProjectCandidates sorts the workspaces before the projects, then the shallower paths first
+func sortProjectCandidates(candidates []string) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iWorkspace, jWorkspace := filepath.Ext(candidates[i]) == ".xcworkspace", filepath.Ext(candidates[j]) == ".xcworkspace"
+		if iWorkspace != jWorkspace {
+			return iWorkspace
+		}
+		iDepth, jDepth := strings.Count(candidates[i], string(filepath.Separator)), strings.Count(candidates[j], string(filepath.Separator))
+		if iDepth != jDepth {
+			return iDepth < jDepth
+		}
+		return candidates[i] < candidates[j]
+	})
+}
@@ -0,0 +1,50 @@
+package autoprovision
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindProjectPath(t *testing.T) {
+	got, err := FindProjectPath("testdata", "App")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("testdata", "xcode16", "App.xcodeproj"), got)
+
+	got, err = FindProjectPath(filepath.Join("testdata", "xcode16", "App.xcodeproj"), "Missing")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("testdata", "xcode16", "App.xcodeproj"), got, "project paths are used as is")
+
+	_, err = FindProjectPath(t.TempDir(), "App")
+	require.Error(t, err)
+}
+
+func Test_findProjectsAndWorkspaces(t *testing.T) {
+	dir := t.TempDir()
+	for _, pth := range []string{
+		"App.xcworkspace",
+		"App.xcodeproj/project.xcworkspace",
+		"Pods/Pods.xcodeproj",
+		".build/checkouts/Dependency/Dependency.xcodeproj",
+		"Modules/Feature/Feature.xcodeproj",
+		"a/b/c/d/Deep.xcodeproj",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, pth), 0700))
+	}
+
+	got, err := findProjectsAndWorkspaces(dir)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		filepath.Join(dir, "App.xcworkspace"),
+		filepath.Join(dir, "App.xcodeproj"),
+		filepath.Join(dir, "Modules/Feature/Feature.xcodeproj"),
+	}, got)
+}
+
+func Test_sortProjectCandidates(t *testing.T) {
+	candidates := []string{"Modules/Feature/Feature.xcodeproj", "App.xcodeproj", "Modules/App.xcworkspace", "App.xcworkspace"}
+	sortProjectCandidates(candidates)
+	require.Equal(t, []string{"App.xcworkspace", "Modules/App.xcworkspace", "App.xcodeproj", "Modules/Feature/Feature.xcodeproj"}, candidates)
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/log"
@@ -15,6 +18,7 @@ import (
 	"github.com/bitrise-io/xcode-project/serialized"
 	"github.com/bitrise-io/xcode-project/xcodeproj"
 	"github.com/bitrise-io/xcode-project/xcscheme"
+	"github.com/bitrise-io/xcode-project/xcworkspace"
 	"howett.net/plist"
 )
 
@@ -24,14 +28,32 @@ type ProjectHelper struct {
 	Targets       []xcodeproj.Target
 	XcProj        xcodeproj.XcodeProj
 	Configuration string
-
-	buildSettingsCache map[string]map[string]serialized.Object // target/config/buildSettings(serialized.Object)
+	// NotArchivedTargetIDs are the targets excluded from the scheme's archive build action (buildForArchiving = NO)
+	NotArchivedTargetIDs map[string]bool
+	// XcodebuildTimeout is the timeout of the xcodebuild commands, 0 means no timeout
+	XcodebuildTimeout time.Duration
+	// FallbackConfigurationByTarget are the configurations of the targets not defining the Configuration, see TargetConfiguration
+	FallbackConfigurationByTarget map[string]string
+	// DerivedSourcesDir supplies the expected content of the files generated into ${BUILT_PRODUCTS_DIR}/DerivedSources by build plugins
+	DerivedSourcesDir string
+	// XcconfigPath is the xcconfig passed to xcodebuild with the -xcconfig flag at build time, its settings override the project's build settings
+	XcconfigPath string
+	// ExcludedTargetKinds are the kinds of the dependent targets (and their dependencies), which are not provisioned
+	ExcludedTargetKinds map[TargetKind]bool
+	// SkipUnresolvableTargets skips the dependent targets whose build settings, bundle ID or entitlements can not be resolved,
+	// instead of failing, see TargetFailures
+	SkipUnresolvableTargets bool
+
+	buildSettingsCache    map[string]map[string]serialized.Object // target/config/buildSettings(serialized.Object)
+	xcconfigSettingsCache serialized.Object
+	failedTargetIDs       map[string]bool
+	targetFailures        []TargetFailure
 }
 
 // NewProjectHelper checks the provided project or workspace and generate a ProjectHelper with the provided scheme and configuration
 // Previously in the ruby version the initialize method did the same
 // It returns a new ProjectHelper pointer and a configuration to use.
-func NewProjectHelper(projOrWSPath, schemeName, configurationName string) (*ProjectHelper, string, error) {
+func NewProjectHelper(projOrWSPath, schemeName, configurationName string, configurationFallback bool, xcodebuildTimeout time.Duration) (*ProjectHelper, string, error) {
 	// Maybe we should do this checks during the input parsing
 	if exits, err := pathutil.IsPathExists(projOrWSPath); err != nil {
 		return nil, "", err
@@ -39,6 +61,24 @@ func NewProjectHelper(projOrWSPath, schemeName, configurationName string) (*Proj
 		return nil, "", fmt.Errorf("provided path does not exists: %s", projOrWSPath)
 	}
 
+	projOrWSPath, err := FindProjectPath(projOrWSPath, schemeName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if schemes, err := ListSchemes(projOrWSPath); err != nil {
+		log.Debugf("Failed to list the schemes of %s: %s", projOrWSPath, err)
+	} else if len(schemes) > 0 {
+		matchingScheme, err := matchSchemeName(schemeName, schemes)
+		if err != nil {
+			return nil, "", err
+		}
+		if matchingScheme != schemeName {
+			log.Warnf("Scheme (%s) not found, using scheme (%s), which differs only in case, diacritics or whitespace", schemeName, matchingScheme)
+			schemeName = matchingScheme
+		}
+	}
+
 	// Get the project of the provided .xcodeproj or .xcworkspace
 	xcproj, err := findBuiltProject(projOrWSPath, schemeName, configurationName)
 	if err != nil {
@@ -61,43 +101,271 @@ func NewProjectHelper(projOrWSPath, schemeName, configurationName string) (*Proj
 	}
 
 	// Configuration
-	conf, err := configuration(configurationName, *scheme, xcproj)
+	conf, fallbackByTarget, err := configuration(configurationName, *scheme, xcproj, configurationFallback)
 	if err != nil {
 		return nil, "", err
 	}
 	return &ProjectHelper{
-			MainTarget:    mainTarget,
-			Targets:       xcproj.Proj.Targets,
-			XcProj:        xcproj,
-			Configuration: conf,
+			MainTarget:                    mainTarget,
+			Targets:                       xcproj.Proj.Targets,
+			XcProj:                        xcproj,
+			Configuration:                 conf,
+			NotArchivedTargetIDs:          notArchivedTargetIDs(*scheme),
+			XcodebuildTimeout:             xcodebuildTimeout,
+			FallbackConfigurationByTarget: fallbackByTarget,
 		}, conf,
 		nil
 }
 
+// notArchivedTargetIDs returns the targets of the scheme's build action entries, which are not built for archiving
+func notArchivedTargetIDs(scheme xcscheme.Scheme) map[string]bool {
+	ids := map[string]bool{}
+	for _, entry := range scheme.BuildAction.BuildActionEntries {
+		if strings.EqualFold(entry.BuildForArchiving, "NO") {
+			ids[entry.BuildableReference.BlueprintIdentifier] = true
+		}
+	}
+	return ids
+}
+
+// mainAndDependentTargets returns the main target and its dependent targets signed with a provisioning profile,
+// except the ones excluded from the scheme's archive build action, as they never make it into the archive,
+// and the ones skipped, as they can not be resolved.
+func (p *ProjectHelper) mainAndDependentTargets() []xcodeproj.Target {
+	targets := []xcodeproj.Target{p.MainTarget}
+	for _, target := range p.dependentTargets() {
+		if p.NotArchivedTargetIDs[target.ID] {
+			log.Warnf("Skipping target (%s), not built for archiving by the scheme (buildForArchiving = NO)", target.Name)
+			continue
+		}
+		if p.failedTargetIDs[target.ID] {
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// skipCodeSigningReason returns why a target does not need to be code signed for device with the given build settings,
+// it returns an empty string if the target needs to be code signed.
+func skipCodeSigningReason(settings serialized.Object) string {
+	codeSigningAllowed, err := settings.String("CODE_SIGNING_ALLOWED")
+	if err == nil && strings.EqualFold(codeSigningAllowed, "NO") {
+		return "CODE_SIGNING_ALLOWED = NO"
+	}
+
+	sdkRoot, err := settings.String("SDKROOT")
+	if err == nil && strings.Contains(strings.ToLower(sdkRoot), "simulator") {
+		return fmt.Sprintf("simulator only SDKROOT = %s", sdkRoot)
+	}
+
+	return ""
+}
+
+// simulatorOnlyPlatformsReason returns why a target is built for simulators only with the given build settings:
+// its SUPPORTED_PLATFORMS exclude every device SDK, like simulator only helper bundles.
+// It returns an empty string if the target supports a device SDK or the setting is missing.
+func simulatorOnlyPlatformsReason(settings serialized.Object) string {
+	supportedPlatforms, err := settings.String("SUPPORTED_PLATFORMS")
+	if err != nil {
+		return ""
+	}
+
+	platforms := strings.Fields(supportedPlatforms)
+	if len(platforms) == 0 {
+		return ""
+	}
+	for _, platform := range platforms {
+		if !strings.HasSuffix(strings.ToLower(platform), "simulator") {
+			return ""
+		}
+	}
+	return fmt.Sprintf("simulator only SUPPORTED_PLATFORMS = %s", supportedPlatforms)
+}
+
+// ArchivableTargets returns the main target and its dependent executable product targets,
+// which need to be code signed for device with the project helper's configuration.
+// Targets with CODE_SIGNING_ALLOWED = NO, a simulator only SDKROOT or simulator only SUPPORTED_PLATFORMS are skipped,
+// as they never need a provisioning profile, so are the targets not built for archiving by the scheme.
+func (p *ProjectHelper) ArchivableTargets() ([]xcodeproj.Target, error) {
+	var targets []xcodeproj.Target
+	for _, target := range p.mainAndDependentTargets() {
+		settings, err := p.targetBuildSettings(target.Name, p.Configuration)
+		if err != nil {
+			if p.skipUnresolvableTarget(target, fmt.Errorf("failed to fetch target settings: %s", err)) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to fetch target (%s) settings: %s", target.Name, err)
+		}
+
+		if reason := simulatorOnlyPlatformsReason(settings); reason != "" {
+			log.Debugf("Skipping target (%s) in configuration (%s), no device SDK is supported: %s", target.Name, p.Configuration, reason)
+			continue
+		}
+		if reason := skipCodeSigningReason(settings); reason != "" {
+			log.Warnf("Skipping target (%s) in configuration (%s), not code signed for device: %s", target.Name, p.Configuration, reason)
+			continue
+		}
+
+		targets = append(targets, target)
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("none of the targets are code signed for device in configuration (%s)", p.Configuration)
+	}
+
+	return targets, nil
+}
+
 // ArchivableTargetBundleIDToEntitlements ...
+// The entitlements of targets sharing a bundle ID are merged, it fails if their entitlements conflict,
+// as a profile generated for the bundle ID would be wrong for one of the targets.
 func (p *ProjectHelper) ArchivableTargetBundleIDToEntitlements() (map[string]serialized.Object, error) {
-	targets := append([]xcodeproj.Target{p.MainTarget}, p.MainTarget.DependentExecutableProductTargets(false)...)
+	targets, err := p.ArchivableTargets()
+	if err != nil {
+		return nil, err
+	}
 
 	entitlementsByBundleID := map[string]serialized.Object{}
+	locationByBundleID := map[string]string{}
 
 	for _, target := range targets {
 		bundleID, err := p.TargetBundleID(target.Name, p.Configuration)
 		if err != nil {
+			if p.skipUnresolvableTarget(target, fmt.Errorf("failed to get bundle id: %s", err)) {
+				continue
+			}
 			return nil, fmt.Errorf("failed to get target (%s) bundle id: %s", target.Name, err)
 		}
 
 		entitlements, err := p.targetEntitlements(target.Name, p.Configuration, bundleID)
 		if err != nil && !serialized.IsKeyNotFoundError(err) {
+			if p.skipUnresolvableTarget(target, fmt.Errorf("failed to get entitlements: %s", err)) {
+				continue
+			}
 			return nil, fmt.Errorf("failed to get target (%s) bundle id: %s", target.Name, err)
 		}
 
+		location := p.targetLocation(target.Name)
+		if existing, ok := entitlementsByBundleID[bundleID]; ok {
+			merged, conflicts := mergeEntitlements(existing, entitlements)
+			if len(conflicts) > 0 {
+				return nil, fmt.Errorf("bundle ID (%s) is declared with different entitlements by %s and by %s:\n- %s", bundleID, locationByBundleID[bundleID], location, strings.Join(conflicts, "\n- "))
+			}
+
+			log.Warnf("Target (%s) shares the bundle ID (%s) with another target, merging their entitlements", target.Name, bundleID)
+			entitlements = merged
+		} else {
+			locationByBundleID[bundleID] = location
+		}
+
 		entitlementsByBundleID[bundleID] = entitlements
 	}
 
 	return entitlementsByBundleID, nil
 }
 
-// Platform get the platform (PLATFORM_DISPLAY_NAME) - iOS, tvOS, macOS
+// BundleIDEntitlementsOfAllConfigurations returns the entitlements of the bundle IDs merged with the entitlements
+// of every build configuration of the archivable targets with the same bundle ID, like the Debug configuration of a Release build.
+// The app ID capabilities used by any of the configurations are not stale (reconcile_capabilities input).
+func (p *ProjectHelper) BundleIDEntitlementsOfAllConfigurations(entitlementsByBundleID map[string]serialized.Object) (map[string]serialized.Object, error) {
+	targets, err := p.ArchivableTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]serialized.Object{}
+	for bundleID, entitlements := range entitlementsByBundleID {
+		merged[bundleID] = entitlements
+	}
+
+	for _, target := range targets {
+		for _, buildConfiguration := range target.BuildConfigurationList.BuildConfigurations {
+			bundleID, err := p.TargetBundleID(target.Name, buildConfiguration.Name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get target (%s) bundle id of configuration (%s): %s", target.Name, buildConfiguration.Name, err)
+			}
+			existing, ok := merged[bundleID]
+			if !ok {
+				continue
+			}
+
+			entitlements, err := p.targetEntitlements(target.Name, buildConfiguration.Name, bundleID)
+			if err != nil && !serialized.IsKeyNotFoundError(err) {
+				return nil, fmt.Errorf("failed to get target (%s) entitlements of configuration (%s): %s", target.Name, buildConfiguration.Name, err)
+			}
+
+			// the conflicting values of the configurations enable the same capabilities
+			merged[bundleID], _ = mergeEntitlements(existing, entitlements)
+		}
+	}
+	return merged, nil
+}
+
+// targetLocation describes where the target and its entitlements are declared, for the error messages
+func (p *ProjectHelper) targetLocation(name string) string {
+	location := fmt.Sprintf("target (%s) of %s", name, p.XcProj.Path)
+	if entitlementsPath, err := p.targetEntitlementsPath(name, p.Configuration); err == nil && entitlementsPath != "" {
+		location += fmt.Sprintf(" (entitlements: %s)", entitlementsPath)
+	}
+	return location
+}
+
+// mergeEntitlements merges the entitlements of targets sharing the same bundle ID.
+// List values are unioned, for conflicting scalar values the first one is kept and the conflict is returned.
+func mergeEntitlements(first, second serialized.Object) (serialized.Object, []string) {
+	merged := serialized.Object{}
+	for key, value := range first {
+		merged[key] = value
+	}
+
+	var conflicts []string
+	for _, key := range sortedKeys(second) {
+		value := second[key]
+
+		existing, ok := merged[key]
+		if !ok {
+			merged[key] = value
+			continue
+		}
+
+		existingList, existingIsList := normalizeEntitlementValue(key, existing).([]interface{})
+		list, isList := normalizeEntitlementValue(key, value).([]interface{})
+		if existingIsList && isList {
+			union := append([]interface{}{}, existingList...)
+			for _, item := range list {
+				if !containsValue(union, item) {
+					union = append(union, item)
+				}
+			}
+			merged[key] = union
+			continue
+		}
+
+		if !entitlementValuesEqual(key, existing, value) {
+			conflicts = append(conflicts, fmt.Sprintf("conflicting values for entitlement %s: %v and %v", key, existing, value))
+		}
+	}
+
+	return merged, conflicts
+}
+
+func sortedKeys(obj serialized.Object) []string {
+	keys := obj.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Platform get the platform (PLATFORM_DISPLAY_NAME) - iOS, tvOS, macOS, watchOS, visionOS (xrOS before Xcode 15.2), DriverKit
 func (p *ProjectHelper) Platform(configurationName string) (Platform, error) {
 	settings, err := p.targetBuildSettings(p.MainTarget.Name, configurationName)
 	if err != nil {
@@ -109,59 +377,180 @@ func (p *ProjectHelper) Platform(configurationName string) (Platform, error) {
 		return "", fmt.Errorf("no PLATFORM_DISPLAY_NAME config found for (%s) target", p.MainTarget.Name)
 	}
 
-	if platformDisplayName != string(IOS) && platformDisplayName != string(MacOS) && platformDisplayName != string(TVOS) {
-		return "", fmt.Errorf("not supported platform. Platform (PLATFORM_DISPLAY_NAME) = %s, supported: %s, %s", platformDisplayName, IOS, TVOS)
+	return DetectedPlatform(platformDisplayName)
+}
+
+// WatchBundleIDs returns the sorted bundle IDs of the watchOS targets (WatchKit apps and extensions) of an iOS main target,
+// built for archiving. Their development and ad-hoc profiles need the Apple Watch devices.
+func (p *ProjectHelper) WatchBundleIDs() ([]string, error) {
+	var bundleIDs []string
+	for _, target := range p.dependentTargets() {
+		if p.NotArchivedTargetIDs[target.ID] || p.failedTargetIDs[target.ID] {
+			continue
+		}
+
+		settings, err := p.targetBuildSettings(target.Name, p.Configuration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch target (%s) settings: %s", target.Name, err)
+		}
+		if !isWatchOSTarget(settings) {
+			continue
+		}
+
+		bundleID, err := p.TargetBundleID(target.Name, p.Configuration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get target (%s) bundle id: %s", target.Name, err)
+		}
+		bundleIDs = append(bundleIDs, bundleID)
+	}
+	sort.Strings(bundleIDs)
+	return bundleIDs, nil
+}
+
+// isWatchOSTarget reports whether the build settings are of a watchOS target:
+// PLATFORM_DISPLAY_NAME = watchOS, or the watchOS SDKROOT if the display name is missing (like in the project file settings).
+func isWatchOSTarget(settings serialized.Object) bool {
+	if platformDisplayName, err := settings.String("PLATFORM_DISPLAY_NAME"); err == nil {
+		return platformDisplayName == string(WatchOS)
 	}
-	return Platform(platformDisplayName), nil
+	sdkRoot, err := settings.String("SDKROOT")
+	return err == nil && strings.HasPrefix(strings.ToLower(sdkRoot), "watchos")
 }
 
-// ProjectTeamID returns the development team's ID
-// If there is mutlitple development team in the project (different team for targets) it will return an error
-// It returns the development team's ID
-func (p *ProjectHelper) ProjectTeamID(config string) (string, error) {
-	var teamID string
+// SupportsMacCatalyst reports whether the main target builds a Mac Catalyst variant (SUPPORTS_MACCATALYST = YES)
+func (p *ProjectHelper) SupportsMacCatalyst(configurationName string) (bool, error) {
+	settings, err := p.targetBuildSettings(p.MainTarget.Name, configurationName)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch project (%s) build settings: %s", p.XcProj.Path, err)
+	}
+
+	supportsMacCatalyst, err := settings.String("SUPPORTS_MACCATALYST")
+	if err != nil {
+		if serialized.IsKeyNotFoundError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return strings.EqualFold(supportsMacCatalyst, "YES"), nil
+}
+
+// TargetTeam is the development team of a target
+type TargetTeam struct {
+	Target string
+	TeamID string
+	// Source is where the team ID is set: the DEVELOPMENT_TEAM build setting or the DevelopmentTeam target attribute,
+	// empty if the target has no team
+	Source string
+}
+
+// TargetTeams returns the development team of the main target and its dependent targets signed with a provisioning profile.
+// The other targets of the project (like test targets or other apps) are not part of the archive, their team does not matter.
+func (p *ProjectHelper) TargetTeams(config string) ([]TargetTeam, error) {
+	var teams []TargetTeam
+
+	for _, target := range p.mainAndDependentTargets() {
+		team := TargetTeam{Target: target.Name}
 
-	for _, target := range p.Targets {
 		currentTeamID, err := p.targetTeamID(target.Name, config)
 		if err != nil {
-			log.Debugf("%", err)
+			log.Debugf("%s", err)
 		} else {
 			log.Debugf("Target (%s) build settings/DEVELOPMENT_TEAM Team ID: %s", target.Name, currentTeamID)
 		}
 
-		if currentTeamID == "" {
+		if currentTeamID != "" {
+			team.TeamID = currentTeamID
+			team.Source = "DEVELOPMENT_TEAM"
+		} else {
 			targetAttributes, err := p.XcProj.Proj.Attributes.TargetAttributes.Object(target.ID)
 			if err != nil {
-				return "", fmt.Errorf("failed to parse target (%s) attributes: %s", target.ID, err)
+				return nil, fmt.Errorf("failed to parse target (%s) attributes: %s", target.ID, err)
 			}
 
 			targetAttributesTeamID, err := targetAttributes.String("DevelopmentTeam")
 			if err != nil && !serialized.IsKeyNotFoundError(err) {
-				return "", fmt.Errorf("failed to parse development team for target (%s): %s", target.ID, err)
+				return nil, fmt.Errorf("failed to parse development team for target (%s): %s", target.ID, err)
 			}
 
 			log.Debugf("Target (%s) DevelopmentTeam attribute: %s", target.Name, targetAttributesTeamID)
 
 			if targetAttributesTeamID == "" {
 				log.Debugf("Target (%s): No Team ID found.", target.Name)
-				continue
+			} else {
+				team.TeamID = targetAttributesTeamID
+				team.Source = "DevelopmentTeam"
 			}
+		}
+
+		teams = append(teams, team)
+	}
+
+	return teams, nil
+}
 
-			currentTeamID = targetAttributesTeamID
+// TeamMismatchError is returned when the targets use different development teams
+type TeamMismatchError struct {
+	Teams []TargetTeam
+}
+
+func (e TeamMismatchError) Error() string {
+	return fmt.Sprintf("targets use different development teams:\n%s"+
+		"This causes build issue like: `Embedded binary is not signed with the same certificate as the parent app. Verify the embedded binary target's code sign settings match the parent app's.`", TargetTeamsTable(e.Teams))
+}
+
+// TargetTeamsTable renders the target -> team table
+func TargetTeamsTable(teams []TargetTeam) string {
+	width := len("target")
+	for _, team := range teams {
+		if len(team.Target) > width {
+			width = len(team.Target)
 		}
+	}
 
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %-*s  %s\n", width, "target", "team")
+	for _, team := range teams {
+		teamID := team.TeamID
 		if teamID == "" {
-			teamID = currentTeamID
-			continue
+			teamID = "-"
+		} else {
+			teamID += " (" + team.Source + ")"
 		}
+		fmt.Fprintf(&b, "  %-*s  %s\n", width, team.Target, teamID)
+	}
+	return b.String()
+}
 
-		if teamID != currentTeamID {
-			log.Warnf("Target (%s) Team ID (%s) does not match to the already registered team ID: %s\nThis causes build issue like: `Embedded binary is not signed with the same certificate as the parent app. Verify the embedded binary target's code sign settings match the parent app's.`", target.Name, currentTeamID, teamID)
-			teamID = ""
-			break
+// CommonTeamID returns the development team's ID, shared by the targets, targets without a team are ignored.
+// It returns a TeamMismatchError if the targets use different teams.
+func CommonTeamID(teams []TargetTeam) (string, error) {
+	var teamID string
+	for _, team := range teams {
+		if team.TeamID == "" {
+			continue
+		}
+		if teamID == "" {
+			teamID = team.TeamID
+		} else if teamID != team.TeamID {
+			return "", TeamMismatchError{Teams: teams}
 		}
 	}
+	return teamID, nil
+}
 
+// ProjectTeamID returns the development team's ID
+// If there is mutlitple development team in the project (different team for targets) it returns an empty ID
+func (p *ProjectHelper) ProjectTeamID(config string) (string, error) {
+	teams, err := p.TargetTeams(config)
+	if err != nil {
+		return "", err
+	}
+
+	teamID, err := CommonTeamID(teams)
+	if err != nil {
+		log.Warnf("%s", err)
+		return "", nil
+	}
 	return teamID, nil
 }
 
@@ -180,6 +569,7 @@ func (p *ProjectHelper) targetTeamID(targatName, config string) (string, error)
 }
 
 func (p *ProjectHelper) targetBuildSettings(name, conf string) (serialized.Object, error) {
+	conf = p.TargetConfiguration(name, conf)
 	targetCache, ok := p.buildSettingsCache[name]
 	if ok {
 		confCache, ok := targetCache[conf]
@@ -188,11 +578,27 @@ func (p *ProjectHelper) targetBuildSettings(name, conf string) (serialized.Objec
 		}
 	}
 
-	settings, err := p.XcProj.TargetBuildSettings(name, conf)
+	overrides, err := p.xcconfigSettings()
 	if err != nil {
 		return nil, err
 	}
 
+	settings, err := showProjectBuildSettings(p.XcodebuildTimeout, p.XcProj.Path, name, conf, p.XcconfigPath)
+	if err != nil {
+		if !isFutureProjectFormatError(err) {
+			return nil, err
+		}
+
+		log.Warnf("The installed Xcode can not open the project (objectVersion %d), using the build settings of the project file for target (%s) in configuration (%s)", p.XcProj.Format, name, conf)
+		if settings, err = projectFileBuildSettings(p.XcProj.Proj, name, conf, overrides); err != nil {
+			return nil, err
+		}
+	} else if fileSettings, err := projectFileBuildSettings(p.XcProj.Proj, name, conf, overrides); err != nil {
+		log.Debugf("Failed to read the build settings of target (%s) from the project file: %s", name, err)
+	} else if added := addMissingBuildSettings(settings, fileSettings); len(added) > 0 {
+		log.Debugf("Build settings of target (%s) inherited from the project file: %s", name, strings.Join(added, ", "))
+	}
+
 	if targetCache == nil {
 		targetCache = map[string]serialized.Object{}
 	}
@@ -206,10 +612,192 @@ func (p *ProjectHelper) targetBuildSettings(name, conf string) (serialized.Objec
 	return settings, nil
 }
 
+// isFutureProjectFormatError reports whether xcodebuild failed, because the project file format is newer than the installed Xcode supports,
+// for example an Xcode 16 project (objectVersion 77, with file system synchronized groups) opened with Xcode 15.
+func isFutureProjectFormatError(err error) bool {
+	return strings.Contains(err.Error(), "future Xcode project file format")
+}
+
+// projectFileBuildSettings returns the target's build settings of the configuration as set in the project file:
+// the project level settings overridden by the target level ones, then by the overrides (the settings of the xcconfig passed to xcodebuild),
+// with the build setting references expanded where possible.
+// Settings of the project's xcconfig files and the defaults of Xcode are not included, apart from TARGET_NAME, CONFIGURATION and PRODUCT_NAME.
+func projectFileBuildSettings(proj xcodeproj.Proj, targetName, conf string, overrides serialized.Object) (serialized.Object, error) {
+	var target *xcodeproj.Target
+	for i := range proj.Targets {
+		if proj.Targets[i].Name == targetName {
+			target = &proj.Targets[i]
+			break
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("target (%s) not found in the project file", targetName)
+	}
+
+	settings := serialized.Object{
+		"TARGET_NAME":   targetName,
+		"CONFIGURATION": conf,
+		"PRODUCT_NAME":  "$(TARGET_NAME)",
+	}
+	// The project level settings, then the target level settings and the overrides, which take precedence
+	var levels []serialized.Object
+	for _, buildConfiguration := range proj.BuildConfigurationList.BuildConfigurations {
+		if buildConfiguration.Name == conf {
+			levels = append(levels, buildConfiguration.BuildSettings)
+		}
+	}
+
+	found := false
+	for _, buildConfiguration := range target.BuildConfigurationList.BuildConfigurations {
+		if buildConfiguration.Name == conf {
+			found = true
+			levels = append(levels, buildConfiguration.BuildSettings)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("build configuration (%s) not found for target (%s) in the project file", conf, targetName)
+	}
+	if len(overrides) > 0 {
+		levels = append(levels, overrides)
+	}
+
+	condition := buildSettingCondition{sdk: deviceSDK(levels), arch: deviceArch, config: conf}
+	for _, level := range levels {
+		addBuildSettingsLevel(settings, level, condition)
+	}
+
+	expanded := serialized.Object{}
+	for key, value := range settings {
+		expanded[key] = value
+		if s, ok := value.(string); ok {
+			// References to settings not in the project file, like $(inherited) or $(SRCROOT), are kept as is
+			if e, err := expandBuildSettingVariables(s, settings); err == nil {
+				expanded[key] = e
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// deviceArch is the architecture the conditional build settings are evaluated for
+const deviceArch = "arm64"
+
+// buildSettingCondition is the sdk, architecture and build configuration the conditional build settings
+// (like DEVELOPMENT_TEAM[sdk=iphoneos*]) are evaluated for
+type buildSettingCondition struct {
+	sdk    string
+	arch   string
+	config string
+}
+
+// matches reports whether the conditions of the build setting key, for example: [sdk=iphoneos*][arch=arm64], all match.
+// The condition values are patterns, an unknown condition does not match.
+func (c buildSettingCondition) matches(key string) bool {
+	conditions := key[len(settingKeyWithoutConditions(key)):]
+	for conditions != "" {
+		end := strings.Index(conditions, "]")
+		if !strings.HasPrefix(conditions, "[") || end < 0 {
+			return false
+		}
+		split := strings.SplitN(conditions[1:end], "=", 2)
+		conditions = conditions[end+1:]
+		if len(split) != 2 {
+			return false
+		}
+
+		var value string
+		switch strings.TrimSpace(split[0]) {
+		case "sdk":
+			value = c.sdk
+		case "arch":
+			value = c.arch
+		case "config":
+			value = c.config
+		default:
+			return false
+		}
+		if ok, err := path.Match(strings.TrimSpace(split[1]), value); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// addBuildSettingsLevel adds the build settings of a level (project or target) to the settings, the way xcodebuild resolves them:
+// the settings matching the condition override the unconditional settings of the level, the more specific ones applied last.
+// The conditional settings are not added by their conditional keys.
+func addBuildSettingsLevel(settings, level serialized.Object, condition buildSettingCondition) {
+	var conditionalKeys []string
+	for key, value := range level {
+		if settingKeyWithoutConditions(key) == key {
+			settings[key] = value
+		} else if condition.matches(key) {
+			conditionalKeys = append(conditionalKeys, key)
+		}
+	}
+
+	sort.Slice(conditionalKeys, func(i, j int) bool {
+		ci, cj := strings.Count(conditionalKeys[i], "["), strings.Count(conditionalKeys[j], "[")
+		if ci != cj {
+			return ci < cj
+		}
+		return conditionalKeys[i] < conditionalKeys[j]
+	})
+	for _, key := range conditionalKeys {
+		settings[settingKeyWithoutConditions(key)] = level[key]
+	}
+}
+
+// deviceSDK returns the device SDK the target is built with by its SDKROOT (or SUPPORTED_PLATFORMS for the multiplatform targets)
+// build setting: iphoneos, appletvos, watchos, xros or macosx. It defaults to iphoneos.
+func deviceSDK(levels []serialized.Object) string {
+	sdkRoot, supportedPlatforms := "", ""
+	for _, level := range levels {
+		if s, err := level.String("SDKROOT"); err == nil && s != "" {
+			sdkRoot = s
+		}
+		if s, err := level.String("SUPPORTED_PLATFORMS"); err == nil && s != "" {
+			supportedPlatforms = s
+		}
+	}
+
+	if sdkRoot != "" && sdkRoot != "auto" {
+		return strings.Replace(sdkRoot, "simulator", "os", 1)
+	}
+	for _, platform := range strings.Fields(supportedPlatforms) {
+		if !strings.HasSuffix(platform, "simulator") {
+			return platform
+		}
+	}
+	return "iphoneos"
+}
+
+// addMissingBuildSettings adds the project file's build settings, which are missing (or empty) in the xcodebuild reported settings,
+// like the DEVELOPMENT_TEAM or PRODUCT_BUNDLE_IDENTIFIER inherited from the project level build configuration in some legacy projects.
+// The settings with unresolved build setting references are not added. It returns the sorted keys of the added settings.
+func addMissingBuildSettings(settings, projectFileSettings serialized.Object) []string {
+	var added []string
+	for _, key := range sortedKeys(projectFileSettings) {
+		if existing, ok := settings[key]; ok && existing != "" {
+			continue
+		}
+
+		value := projectFileSettings[key]
+		if s, ok := value.(string); ok && buildSettingVariablePattern.MatchString(s) {
+			continue
+		}
+		settings[key] = value
+		added = append(added, key)
+	}
+	return added
+}
+
 // TargetBundleID returns the target bundle ID
 // First it tries to fetch the bundle ID from the `PRODUCT_BUNDLE_IDENTIFIER` build settings
 // If it's no available it will fetch the target's Info.plist and search for the `CFBundleIdentifier` key.
 // The CFBundleIdentifier's value is not resolved in the Info.plist, so it will try to resolve it by the resolveBundleID()
+// If the Info.plist is preprocessed (INFOPLIST_PREPROCESS) or can not be parsed as is, its preprocessor directives
+// and macros (INFOPLIST_PREFIX_HEADER, INFOPLIST_PREPROCESSOR_DEFINITIONS) are resolved before parsing it.
 // It returns  the target bundle ID
 func (p *ProjectHelper) TargetBundleID(name, conf string) (string, error) {
 	settings, err := p.targetBuildSettings(name, conf)
@@ -231,7 +819,13 @@ func (p *ProjectHelper) TargetBundleID(name, conf string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to find Info.plist file: %s", err)
 	}
-	infoPlistPath = path.Join(path.Dir(p.XcProj.Path), infoPlistPath)
+	if generatedPath, ok, err := p.generatedFilePath(name, "INFOPLIST_FILE", infoPlistPath, settings); err != nil {
+		return "", err
+	} else if ok {
+		infoPlistPath = generatedPath
+	} else {
+		infoPlistPath = path.Join(path.Dir(p.XcProj.Path), infoPlistPath)
+	}
 
 	if infoPlistPath == "" {
 		return "", fmt.Errorf("failed to to determine bundle id: xcodebuild -showBuildSettings does not contains PRODUCT_BUNDLE_IDENTIFIER nor INFOPLIST_FILE' unless info_plist_path")
@@ -242,9 +836,29 @@ func (p *ProjectHelper) TargetBundleID(name, conf string) (string, error) {
 		return "", fmt.Errorf("failed to read Info.plist: %s", err)
 	}
 
+	if infoPlistPreprocessingEnabled(settings) {
+		log.Debugf("Info.plist preprocessing enabled, resolving preprocessor directives and macros...")
+
+		if b, err = p.preprocessInfoPlist(b, settings); err != nil {
+			return "", err
+		}
+	}
+
 	var options map[string]interface{}
 	if _, err := plist.Unmarshal(b, &options); err != nil {
-		return "", fmt.Errorf("failed to unmarshal Info.plist: %s ", err)
+		if infoPlistPreprocessingEnabled(settings) {
+			return "", fmt.Errorf("failed to unmarshal Info.plist: %s ", err)
+		}
+
+		log.Debugf("Failed to unmarshal Info.plist (%s), retrying with resolving preprocessor directives and macros...", err)
+
+		preprocessed, ppErr := p.preprocessInfoPlist(b, settings)
+		if ppErr != nil {
+			return "", ppErr
+		}
+		if _, ppErr := plist.Unmarshal(preprocessed, &options); ppErr != nil {
+			return "", fmt.Errorf("failed to unmarshal Info.plist: %s ", err)
+		}
 	}
 
 	bundleID, ok := options["CFBundleIdentifier"].(string)
@@ -269,14 +883,115 @@ func (p *ProjectHelper) TargetBundleID(name, conf string) (string, error) {
 }
 
 func (p *ProjectHelper) targetEntitlements(name, config, bundleID string) (serialized.Object, error) {
-	entitlements, err := p.XcProj.TargetCodeSignEntitlements(name, config)
-	if err != nil && !serialized.IsKeyNotFoundError(err) {
+	entitlementsPath, err := p.targetEntitlementsPath(name, config)
+	if err != nil {
 		return nil, err
 	}
 
+	var entitlements serialized.Object
+	if entitlementsPath != "" {
+		if entitlements, err = readPlist(entitlementsPath); err != nil {
+			return nil, fmt.Errorf("failed to read target (%s) entitlements: %s", name, err)
+		}
+	}
+
 	return resolveEntitlementVariables(Entitlement(entitlements), bundleID)
 }
 
+// targetEntitlementsPath returns the path of the target's entitlements file, or an empty string if the target has none.
+func (p *ProjectHelper) targetEntitlementsPath(name, config string) (string, error) {
+	config = p.TargetConfiguration(name, config)
+	settings, err := p.targetBuildSettings(name, config)
+	if err != nil {
+		return "", err
+	}
+
+	entitlementsPath, err := settings.String("CODE_SIGN_ENTITLEMENTS")
+	if err != nil {
+		if serialized.IsKeyNotFoundError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if generatedPath, ok, err := p.generatedFilePath(name, "CODE_SIGN_ENTITLEMENTS", entitlementsPath, settings); err != nil || ok {
+		return generatedPath, err
+	}
+
+	resolvedPath, err := resolveEntitlementsPath(entitlementsPath, p.XcProj.Path, name, config, settings)
+	if err != nil {
+		return "", err
+	}
+
+	if generatedPath, ok, err := p.generatedFilePath(name, "CODE_SIGN_ENTITLEMENTS", resolvedPath, settings); err != nil || ok {
+		return generatedPath, err
+	}
+	return resolvedPath, nil
+}
+
+// resolveEntitlementsPath expands the variables of the CODE_SIGN_ENTITLEMENTS path,
+// for example a per configuration file: `Config/$(CONFIGURATION).entitlements`.
+// Relative paths are resolved against the project directory.
+func resolveEntitlementsPath(entitlementsPath, projectPath, targetName, config string, settings serialized.Object) (string, error) {
+	projectDir := filepath.Dir(projectPath)
+	variables := serialized.Object{
+		"CONFIGURATION": config,
+		"TARGET_NAME":   targetName,
+		"PROJECT_DIR":   projectDir,
+		"SRCROOT":       projectDir,
+		"SOURCE_ROOT":   projectDir,
+		"PROJECT_NAME":  strings.TrimSuffix(filepath.Base(projectPath), filepath.Ext(projectPath)),
+	}
+	for key, value := range settings {
+		variables[key] = value
+	}
+
+	expanded, err := expandBuildSettingVariables(entitlementsPath, variables)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand entitlements path (%s): %s", entitlementsPath, err)
+	}
+	if expanded != entitlementsPath {
+		log.Debugf("Expanded entitlements path (%s) of target (%s) for configuration (%s): %s", entitlementsPath, targetName, config, expanded)
+	}
+
+	if pathutil.IsRelativePath(expanded) {
+		expanded = filepath.Join(projectDir, expanded)
+	}
+	return expanded, nil
+}
+
+// buildSettingVariablePattern matches the $(VAR), ${VAR} and $(VAR:modifier) build setting references
+var buildSettingVariablePattern = regexp.MustCompile(`\$[({](\w+)(?::[^)}]*)?[)}]`)
+
+// expandBuildSettingVariables expands every build setting reference of the value,
+// including the references in the values of the referenced settings.
+// Modifiers of the references are not supported, the plain value is used.
+func expandBuildSettingVariables(value string, settings serialized.Object) (string, error) {
+	const maxDepth = 10
+	for depth := 0; depth < maxDepth && buildSettingVariablePattern.MatchString(value); depth++ {
+		var expandErr error
+		value = buildSettingVariablePattern.ReplaceAllStringFunc(value, func(match string) string {
+			key := buildSettingVariablePattern.FindStringSubmatch(match)[1]
+			settingValue, err := settings.String(key)
+			if err != nil {
+				if expandErr == nil {
+					expandErr = fmt.Errorf("failed to find build setting (%s): %s", key, err)
+				}
+				return match
+			}
+			return settingValue
+		})
+		if expandErr != nil {
+			return "", expandErr
+		}
+	}
+
+	if buildSettingVariablePattern.MatchString(value) {
+		return "", fmt.Errorf("build setting references are nested too deep in: %s", value)
+	}
+	return value, nil
+}
+
 // resolveEntitlementVariables expands variables in the project entitlements.
 // Entitlement values can contain variables, for example: `iCloud.$(CFBundleIdentifier)`.
 // Expanding iCloud Container values only, as they are compared to the profile values later.
@@ -348,26 +1063,70 @@ func expandTargetSetting(value string, buildSettings serialized.Object) (string,
 	return prefix + envValue + suffix, nil
 }
 
-func configuration(configurationName string, scheme xcscheme.Scheme, xcproj xcodeproj.XcodeProj) (string, error) {
+// configuration returns the build configuration to use and, with the configuration fallback enabled,
+// the scheme's archive configuration for the targets not defining the user defined configuration.
+func configuration(configurationName string, scheme xcscheme.Scheme, xcproj xcodeproj.XcodeProj, configurationFallback bool) (string, map[string]string, error) {
 	defaultConfiguration := scheme.ArchiveAction.BuildConfiguration
-	var configuration string
 	if configurationName == "" || configurationName == defaultConfiguration {
-		configuration = defaultConfiguration
-	} else if configurationName != defaultConfiguration {
-		for _, target := range xcproj.Proj.Targets {
-			var configNames []string
-			for _, conf := range target.BuildConfigurationList.BuildConfigurations {
-				configNames = append(configNames, conf.Name)
-			}
-			if !sliceutil.IsStringInSlice(configurationName, configNames) {
-				return "", fmt.Errorf("build configuration (%s) not defined for target: (%s)", configurationName, target.Name)
-			}
+		return defaultConfiguration, nil, nil
+	}
+
+	fallbackByTarget := map[string]string{}
+	for _, target := range xcproj.Proj.Targets {
+		var configNames []string
+		for _, conf := range target.BuildConfigurationList.BuildConfigurations {
+			configNames = append(configNames, conf.Name)
+		}
+		if sliceutil.IsStringInSlice(configurationName, configNames) {
+			continue
+		}
+		if !configurationFallback || !sliceutil.IsStringInSlice(defaultConfiguration, configNames) {
+			return "", nil, fmt.Errorf("build configuration (%s) not defined for target: (%s)", configurationName, target.Name)
+		}
+		fallbackByTarget[target.Name] = defaultConfiguration
+	}
+	log.Warnf("Using user defined build configuration: %s instead of the scheme's default one: %s.\nMake sure you use the same configuration in further steps.", configurationName, defaultConfiguration)
+
+	if len(fallbackByTarget) == 0 {
+		return configurationName, nil, nil
+	}
+
+	var targetNames []string
+	for name := range fallbackByTarget {
+		targetNames = append(targetNames, name)
+	}
+	sort.Strings(targetNames)
+	log.Warnf("Build configuration (%s) not defined for %d target(s), using the scheme's archive configuration instead:", configurationName, len(targetNames))
+	for _, line := range strings.Split(strings.TrimSuffix(configurationFallbackTable(targetNames, fallbackByTarget), "\n"), "\n") {
+		log.Warnf("%s", line)
+	}
+	return configurationName, fallbackByTarget, nil
+}
+
+// configurationFallbackTable returns the table of the targets and the configurations used instead of the user defined one
+func configurationFallbackTable(targetNames []string, fallbackByTarget map[string]string) string {
+	width := len("target")
+	for _, name := range targetNames {
+		if len(name) > width {
+			width = len(name)
 		}
-		log.Warnf("Using user defined build configuration: %s instead of the scheme's default one: %s.\nMake sure you use the same configuration in further steps.", configurationName, defaultConfiguration)
-		configuration = configurationName
 	}
 
-	return configuration, nil
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %-*s  %s\n", width, "target", "configuration")
+	for _, name := range targetNames {
+		fmt.Fprintf(&b, "  %-*s  %s\n", width, name, fallbackByTarget[name])
+	}
+	return b.String()
+}
+
+// TargetConfiguration returns the build configuration of the target: the scheme's archive configuration
+// if the target does not define the project helper's configuration and the configuration fallback is enabled.
+func (p *ProjectHelper) TargetConfiguration(name, conf string) string {
+	if fallback, ok := p.FallbackConfigurationByTarget[name]; ok && conf == p.Configuration {
+		return fallback
+	}
+	return conf
 }
 
 // mainTargetOfScheme return the main target
@@ -381,7 +1140,6 @@ func mainTargetOfScheme(proj xcodeproj.XcodeProj, scheme string) (xcodeproj.Targ
 		log.Printf("Got scheme '%s' with path '%s'", scheme.Name, scheme.Path)
 	}
 
-
 	sch, _, err := proj.Scheme(scheme)
 	if err != nil {
 		return xcodeproj.Target{}, fmt.Errorf("failed to find scheme (%s) in project: %s", scheme, err)
@@ -405,6 +1163,41 @@ func mainTargetOfScheme(proj xcodeproj.XcodeProj, scheme string) (xcodeproj.Targ
 	return xcodeproj.Target{}, fmt.Errorf("failed to find the project's main target for scheme (%s)", scheme)
 }
 
+// ListSchemes returns the names of the shared schemes of the project or workspace (including the schemes of the workspace's projects).
+func ListSchemes(projOrWSPath string) ([]string, error) {
+	var schemes []xcscheme.Scheme
+	if xcworkspace.IsWorkspace(projOrWSPath) {
+		workspace, err := xcworkspace.Open(projOrWSPath)
+		if err != nil {
+			return nil, err
+		}
+		schemesByContainer, err := workspace.Schemes()
+		if err != nil {
+			return nil, err
+		}
+		for _, containerSchemes := range schemesByContainer {
+			schemes = append(schemes, containerSchemes...)
+		}
+	} else {
+		xcodeProj, err := xcodeproj.Open(projOrWSPath)
+		if err != nil {
+			return nil, err
+		}
+		if schemes, err = xcodeProj.Schemes(); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for _, scheme := range schemes {
+		if !sliceutil.IsStringInSlice(scheme.Name, names) {
+			names = append(names, scheme.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 // findBuiltProject returns the Xcode project which will be built for the provided scheme
 func findBuiltProject(pth, schemeName, configurationName string) (xcodeproj.XcodeProj, error) {
 	scheme, schemeContainerDir, err := project.Scheme(pth, schemeName)
@@ -437,3 +1230,84 @@ func findBuiltProject(pth, schemeName, configurationName string) (xcodeproj.Xcod
 
 	return xcodeProj, nil
 }
+
+// PinnedProfileSetting is an explicit provisioning profile build setting (PROVISIONING_PROFILE or PROVISIONING_PROFILE_SPECIFIER) of a target.
+// These settings are leftovers of manual code signing and conflict with the profiles managed by the step.
+type PinnedProfileSetting struct {
+	Target        string
+	Configuration string
+	Key           string
+	Value         string
+}
+
+func (s PinnedProfileSetting) String() string {
+	return fmt.Sprintf("target (%s), configuration (%s): %s = %s", s.Target, s.Configuration, s.Key, s.Value)
+}
+
+// settingKeyWithoutConditions returns the build setting key without its conditions,
+// for example: PROVISIONING_PROFILE_SPECIFIER[sdk=iphoneos*] -> PROVISIONING_PROFILE_SPECIFIER
+func settingKeyWithoutConditions(key string) string {
+	if i := strings.Index(key, "["); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// isPinnedProfileSettingKey reports whether the build setting key pins a provisioning profile,
+// including the sdk specific settings, for example: PROVISIONING_PROFILE_SPECIFIER[sdk=iphoneos*]
+func isPinnedProfileSettingKey(key string) bool {
+	key = settingKeyWithoutConditions(key)
+	return key == "PROVISIONING_PROFILE" || key == "PROVISIONING_PROFILE_SPECIFIER"
+}
+
+// PinnedProfileSettings returns the non empty PROVISIONING_PROFILE and PROVISIONING_PROFILE_SPECIFIER build settings
+// set in the project file for the given targets, in any build configuration.
+func PinnedProfileSettings(targets []xcodeproj.Target) []PinnedProfileSetting {
+	var settings []PinnedProfileSetting
+	for _, target := range targets {
+		for _, buildConfiguration := range target.BuildConfigurationList.BuildConfigurations {
+			for _, key := range buildConfiguration.BuildSettings.Keys() {
+				if !isPinnedProfileSettingKey(key) {
+					continue
+				}
+
+				value, err := buildConfiguration.BuildSettings.String(key)
+				if err != nil || value == "" {
+					continue
+				}
+
+				settings = append(settings, PinnedProfileSetting{
+					Target:        target.Name,
+					Configuration: buildConfiguration.Name,
+					Key:           key,
+					Value:         value,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(settings, func(i, j int) bool {
+		if settings[i].Target != settings[j].Target {
+			return settings[i].Target < settings[j].Target
+		}
+		if settings[i].Configuration != settings[j].Configuration {
+			return settings[i].Configuration < settings[j].Configuration
+		}
+		return settings[i].Key < settings[j].Key
+	})
+
+	return settings
+}
+
+// ClearPinnedProfileSettings removes the PROVISIONING_PROFILE and PROVISIONING_PROFILE_SPECIFIER build settings
+// of the given target in every build configuration.
+// The project needs to be saved to persist the changes.
+func ClearPinnedProfileSettings(target xcodeproj.Target) {
+	for _, buildConfiguration := range target.BuildConfigurationList.BuildConfigurations {
+		for _, key := range buildConfiguration.BuildSettings.Keys() {
+			if isPinnedProfileSettingKey(key) {
+				delete(buildConfiguration.BuildSettings, key)
+			}
+		}
+	}
+}
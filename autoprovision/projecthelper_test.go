@@ -2,7 +2,9 @@ package autoprovision
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -11,6 +13,8 @@ import (
 	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-io/xcode-project/serialized"
 	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/bitrise-io/xcode-project/xcscheme"
+	"github.com/stretchr/testify/require"
 )
 
 var schemeCases []string
@@ -86,7 +90,7 @@ func TestNew(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			projHelp, conf, err := NewProjectHelper(tt.projOrWSPath, tt.schemeName, tt.configurationName)
+			projHelp, conf, err := NewProjectHelper(tt.projOrWSPath, tt.schemeName, tt.configurationName, false, 0)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -170,6 +174,99 @@ func TestProjectHelper_ProjectTeamID(t *testing.T) {
 	}
 }
 
+func TestCommonTeamID(t *testing.T) {
+	tests := []struct {
+		name    string
+		teams   []TargetTeam
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Same team",
+			teams: []TargetTeam{{Target: "App", TeamID: "72SA8V3WYL"}, {Target: "Extension", TeamID: "72SA8V3WYL"}},
+			want:  "72SA8V3WYL",
+		},
+		{
+			name:  "Targets without team are ignored",
+			teams: []TargetTeam{{Target: "App"}, {Target: "Extension", TeamID: "72SA8V3WYL"}},
+			want:  "72SA8V3WYL",
+		},
+		{
+			name:  "No team",
+			teams: []TargetTeam{{Target: "App"}},
+			want:  "",
+		},
+		{
+			name:    "Different teams",
+			teams:   []TargetTeam{{Target: "App", TeamID: "72SA8V3WYL"}, {Target: "Extension", TeamID: "1MZX23ABCD4"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CommonTeamID(tt.teams)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CommonTeamID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CommonTeamID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetTeamsTable(t *testing.T) {
+	got := TargetTeamsTable([]TargetTeam{
+		{Target: "App", TeamID: "72SA8V3WYL", Source: "DEVELOPMENT_TEAM"},
+		{Target: "WatchExtension", TeamID: "1MZX23ABCD4", Source: "DevelopmentTeam"},
+		{Target: "Framework"},
+	})
+	want := `  target          team
+  App             72SA8V3WYL (DEVELOPMENT_TEAM)
+  WatchExtension  1MZX23ABCD4 (DevelopmentTeam)
+  Framework       -
+`
+	if got != want {
+		t.Errorf("TargetTeamsTable() = %v, want %v", got, want)
+	}
+}
+
+func TestProjectHelper_TargetTeams(t *testing.T) {
+	extension := xcodeproj.Target{ID: "EXTENSION", Name: "Extension", ProductType: "com.apple.product-type.app-extension"}
+	tests := xcodeproj.Target{ID: "TESTS", Name: "Tests", ProductType: "com.apple.product-type.bundle.unit-test"}
+	otherApp := xcodeproj.Target{ID: "OTHER", Name: "OtherApp", ProductType: "com.apple.product-type.application"}
+	app := xcodeproj.Target{
+		ID:           "APP",
+		Name:         "App",
+		ProductType:  "com.apple.product-type.application",
+		Dependencies: []xcodeproj.TargetDependency{{Target: extension}, {Target: tests}},
+	}
+
+	p := ProjectHelper{
+		MainTarget:    app,
+		Targets:       []xcodeproj.Target{app, extension, tests, otherApp},
+		Configuration: "Release",
+		buildSettingsCache: map[string]map[string]serialized.Object{
+			"App":       {"Release": {"DEVELOPMENT_TEAM": "72SA8V3WYL"}},
+			"Extension": {"Release": {"DEVELOPMENT_TEAM": "72SA8V3WYL"}},
+			"Tests":     {"Release": {"DEVELOPMENT_TEAM": "1MZX23ABCD4"}},
+			"OtherApp":  {"Release": {"DEVELOPMENT_TEAM": "1MZX23ABCD4"}},
+		},
+	}
+
+	teams, err := p.TargetTeams("Release")
+	require.NoError(t, err)
+	require.Equal(t, []TargetTeam{
+		{Target: "App", TeamID: "72SA8V3WYL", Source: "DEVELOPMENT_TEAM"},
+		{Target: "Extension", TeamID: "72SA8V3WYL", Source: "DEVELOPMENT_TEAM"},
+	}, teams, "the targets outside the archive are not listed")
+
+	teamID, err := p.ProjectTeamID("Release")
+	require.NoError(t, err)
+	require.Equal(t, "72SA8V3WYL", teamID)
+}
+
 func Test_codesignIdentitesMatch(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -349,6 +446,8 @@ func TestProjectHelper_TargetBundleID(t *testing.T) {
 			projectCases[i],
 			schemeCase,
 			configCases[i],
+			false,
+			0,
 		)
 		if err != nil {
 			t.Fatalf("Failed to generate projectHelper for test case: %s", err)
@@ -490,6 +589,8 @@ func initTestCases() ([]string, []string, []xcodeproj.XcodeProj, []ProjectHelper
 			projectCases[i],
 			schemeCase,
 			configCases[i],
+			false,
+			0,
 		)
 		if err != nil {
 			return nil, nil, nil, nil, nil, fmt.Errorf("Failed to generate projectHelper for test case: %s", err)
@@ -661,3 +762,584 @@ func Test_resolveEntitlementVariables(t *testing.T) {
 		})
 	}
 }
+
+func TestPinnedProfileSettings(t *testing.T) {
+	newTarget := func(name string, buildSettingsByConfiguration map[string]serialized.Object) xcodeproj.Target {
+		var buildConfigurations []xcodeproj.BuildConfiguration
+		for configuration, buildSettings := range buildSettingsByConfiguration {
+			buildConfigurations = append(buildConfigurations, xcodeproj.BuildConfiguration{
+				Name:          configuration,
+				BuildSettings: buildSettings,
+			})
+		}
+		return xcodeproj.Target{
+			Name:                   name,
+			BuildConfigurationList: xcodeproj.ConfigurationList{BuildConfigurations: buildConfigurations},
+		}
+	}
+
+	app := newTarget("App", map[string]serialized.Object{
+		"Debug": {
+			"PROVISIONING_PROFILE_SPECIFIER": "",
+			"PRODUCT_BUNDLE_IDENTIFIER":      "io.bitrise.app",
+		},
+		"Release": {
+			"PROVISIONING_PROFILE":                          "c5be4123-1234-4f9d-9843-0d9be985a068",
+			"PROVISIONING_PROFILE_SPECIFIER[sdk=iphoneos*]": "App Store Profile",
+		},
+	})
+	extension := newTarget("Extension", map[string]serialized.Object{
+		"Release": {
+			"PROVISIONING_PROFILE_SPECIFIER": "Extension Profile",
+		},
+	})
+
+	got := PinnedProfileSettings([]xcodeproj.Target{extension, app})
+	want := []PinnedProfileSetting{
+		{Target: "App", Configuration: "Release", Key: "PROVISIONING_PROFILE", Value: "c5be4123-1234-4f9d-9843-0d9be985a068"},
+		{Target: "App", Configuration: "Release", Key: "PROVISIONING_PROFILE_SPECIFIER[sdk=iphoneos*]", Value: "App Store Profile"},
+		{Target: "Extension", Configuration: "Release", Key: "PROVISIONING_PROFILE_SPECIFIER", Value: "Extension Profile"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("PinnedProfileSettings() = %v, want %v", got, want)
+	}
+
+	ClearPinnedProfileSettings(app)
+
+	if got := PinnedProfileSettings([]xcodeproj.Target{app}); len(got) != 0 {
+		t.Errorf("PinnedProfileSettings() after ClearPinnedProfileSettings() = %v, want none", got)
+	}
+	for _, buildConfiguration := range app.BuildConfigurationList.BuildConfigurations {
+		for key := range buildConfiguration.BuildSettings {
+			if isPinnedProfileSettingKey(key) {
+				t.Errorf("ClearPinnedProfileSettings() did not remove %s from configuration %s", key, buildConfiguration.Name)
+			}
+		}
+	}
+}
+
+func Test_skipCodeSigningReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings serialized.Object
+		want     string
+	}{
+		{
+			name:     "device signed",
+			settings: serialized.Object{"SDKROOT": "iphoneos", "CODE_SIGNING_ALLOWED": "YES"},
+			want:     "",
+		},
+		{
+			name:     "no settings",
+			settings: serialized.Object{},
+			want:     "",
+		},
+		{
+			name:     "code signing not allowed",
+			settings: serialized.Object{"SDKROOT": "iphoneos", "CODE_SIGNING_ALLOWED": "NO"},
+			want:     "CODE_SIGNING_ALLOWED = NO",
+		},
+		{
+			name:     "simulator SDK",
+			settings: serialized.Object{"SDKROOT": "iphonesimulator"},
+			want:     "simulator only SDKROOT = iphonesimulator",
+		},
+		{
+			name:     "simulator SDK path",
+			settings: serialized.Object{"SDKROOT": "/Applications/Xcode.app/Contents/Developer/Platforms/AppleTVSimulator.platform/Developer/SDKs/AppleTVSimulator14.2.sdk"},
+			want:     "simulator only SDKROOT = /Applications/Xcode.app/Contents/Developer/Platforms/AppleTVSimulator.platform/Developer/SDKs/AppleTVSimulator14.2.sdk",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := skipCodeSigningReason(tt.settings); got != tt.want {
+				t.Errorf("skipCodeSigningReason() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_simulatorOnlyPlatformsReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings serialized.Object
+		want     string
+	}{
+		{
+			name:     "device and simulator",
+			settings: serialized.Object{"SUPPORTED_PLATFORMS": "iphoneos iphonesimulator"},
+			want:     "",
+		},
+		{
+			name:     "Mac Catalyst",
+			settings: serialized.Object{"SUPPORTED_PLATFORMS": "iphonesimulator iphoneos macosx"},
+			want:     "",
+		},
+		{
+			name:     "no settings",
+			settings: serialized.Object{},
+			want:     "",
+		},
+		{
+			name:     "simulators only",
+			settings: serialized.Object{"SUPPORTED_PLATFORMS": "iphonesimulator appletvsimulator"},
+			want:     "simulator only SUPPORTED_PLATFORMS = iphonesimulator appletvsimulator",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := simulatorOnlyPlatformsReason(tt.settings); got != tt.want {
+				t.Errorf("simulatorOnlyPlatformsReason() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_notArchivedTargetIDs(t *testing.T) {
+	entry := func(id, buildForArchiving string) xcscheme.BuildActionEntry {
+		return xcscheme.BuildActionEntry{
+			BuildForArchiving:  buildForArchiving,
+			BuildableReference: xcscheme.BuildableReference{BlueprintIdentifier: id},
+		}
+	}
+	scheme := xcscheme.Scheme{BuildAction: xcscheme.BuildAction{BuildActionEntries: []xcscheme.BuildActionEntry{
+		entry("APP", "YES"),
+		entry("EXTENSION", "NO"),
+		entry("TESTS", ""),
+	}}}
+
+	want := map[string]bool{"EXTENSION": true}
+	if got := notArchivedTargetIDs(scheme); !reflect.DeepEqual(got, want) {
+		t.Errorf("notArchivedTargetIDs() = %v, want %v", got, want)
+	}
+}
+
+func TestArchivableTargets_notArchived(t *testing.T) {
+	settings := serialized.Object{"SDKROOT": "iphoneos"}
+	extension := xcodeproj.Target{ID: "EXTENSION", Name: "Extension", ProductReference: xcodeproj.ProductReference{Path: "Extension.appex"}}
+	watchApp := xcodeproj.Target{ID: "WATCH", Name: "WatchApp", ProductReference: xcodeproj.ProductReference{Path: "WatchApp.app"}}
+	app := xcodeproj.Target{
+		ID:               "APP",
+		Name:             "App",
+		ProductReference: xcodeproj.ProductReference{Path: "App.app"},
+		Dependencies:     []xcodeproj.TargetDependency{{Target: extension}, {Target: watchApp}},
+	}
+
+	p := ProjectHelper{
+		MainTarget:           app,
+		Configuration:        "Release",
+		NotArchivedTargetIDs: map[string]bool{"EXTENSION": true},
+		buildSettingsCache: map[string]map[string]serialized.Object{
+			"App":       {"Release": settings},
+			"Extension": {"Release": settings},
+			"WatchApp":  {"Release": settings},
+		},
+	}
+
+	targets, err := p.ArchivableTargets()
+	if err != nil {
+		t.Fatalf("ArchivableTargets() unexpected error = %v", err)
+	}
+
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	if want := []string{"App", "WatchApp"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ArchivableTargets() = %v, want %v", names, want)
+	}
+}
+
+func TestWatchBundleIDs(t *testing.T) {
+	watchExtension := xcodeproj.Target{ID: "WATCHEXT", Name: "WatchExtension", ProductReference: xcodeproj.ProductReference{Path: "WatchExtension.appex"}}
+	watchApp := xcodeproj.Target{
+		ID:               "WATCH",
+		Name:             "WatchApp",
+		ProductReference: xcodeproj.ProductReference{Path: "WatchApp.app"},
+		Dependencies:     []xcodeproj.TargetDependency{{Target: watchExtension}},
+	}
+	extension := xcodeproj.Target{ID: "EXTENSION", Name: "Extension", ProductReference: xcodeproj.ProductReference{Path: "Extension.appex"}}
+	app := xcodeproj.Target{
+		ID:               "APP",
+		Name:             "App",
+		ProductReference: xcodeproj.ProductReference{Path: "App.app"},
+		Dependencies:     []xcodeproj.TargetDependency{{Target: extension}, {Target: watchApp}},
+	}
+
+	p := ProjectHelper{
+		MainTarget:    app,
+		Configuration: "Release",
+		buildSettingsCache: map[string]map[string]serialized.Object{
+			"App":            {"Release": {"PLATFORM_DISPLAY_NAME": "iOS", "PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app"}},
+			"Extension":      {"Release": {"PLATFORM_DISPLAY_NAME": "iOS", "PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app.extension"}},
+			"WatchApp":       {"Release": {"PLATFORM_DISPLAY_NAME": "watchOS", "PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app.watchkitapp"}},
+			"WatchExtension": {"Release": {"SDKROOT": "watchos", "PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app.watchkitapp.watchkitextension"}},
+		},
+	}
+
+	bundleIDs, err := p.WatchBundleIDs()
+	require.NoError(t, err)
+	require.Equal(t, []string{"io.bitrise.app.watchkitapp", "io.bitrise.app.watchkitapp.watchkitextension"}, bundleIDs)
+
+	p.NotArchivedTargetIDs = map[string]bool{"WATCH": true, "WATCHEXT": true}
+	bundleIDs, err = p.WatchBundleIDs()
+	require.NoError(t, err)
+	require.Empty(t, bundleIDs)
+}
+
+func Test_mergeEntitlements(t *testing.T) {
+	first := serialized.Object{
+		"aps-environment":                       "development",
+		"com.apple.security.application-groups": []interface{}{"group.io.bitrise.app"},
+	}
+	second := serialized.Object{
+		"aps-environment":                       "production",
+		"com.apple.security.application-groups": []interface{}{"group.io.bitrise.app", "group.io.bitrise.beta"},
+		"com.apple.developer.siri":              true,
+	}
+
+	merged, conflicts := mergeEntitlements(first, second)
+
+	want := serialized.Object{
+		"aps-environment":                       "development",
+		"com.apple.security.application-groups": []interface{}{"group.io.bitrise.app", "group.io.bitrise.beta"},
+		"com.apple.developer.siri":              true,
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("mergeEntitlements() merged = %v, want %v", merged, want)
+	}
+
+	wantConflicts := []string{"conflicting values for entitlement aps-environment: development and production"}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("mergeEntitlements() conflicts = %v, want %v", conflicts, wantConflicts)
+	}
+
+	if len(first["com.apple.security.application-groups"].([]interface{})) != 1 {
+		t.Errorf("mergeEntitlements() modified its input")
+	}
+}
+
+func Test_expandBuildSettingVariables(t *testing.T) {
+	settings := serialized.Object{
+		"CONFIGURATION": "Release",
+		"TARGET_NAME":   "MyApp",
+		"PRODUCT_NAME":  "$(TARGET_NAME)",
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "no variable", value: "MyApp/MyApp.entitlements", want: "MyApp/MyApp.entitlements"},
+		{name: "configuration", value: "Config/$(CONFIGURATION).entitlements", want: "Config/Release.entitlements"},
+		{name: "multiple variables", value: "${TARGET_NAME}/$(TARGET_NAME)-$(CONFIGURATION).entitlements", want: "MyApp/MyApp-Release.entitlements"},
+		{name: "nested variable", value: "$(PRODUCT_NAME).entitlements", want: "MyApp.entitlements"},
+		{name: "modifier", value: "$(PRODUCT_NAME:rfc1034identifier).entitlements", want: "MyApp.entitlements"},
+		{name: "unknown variable", value: "$(UNKNOWN).entitlements", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandBuildSettingVariables(tt.value, settings)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_resolveEntitlementsPath(t *testing.T) {
+	projectDir := t.TempDir()
+	configDir := filepath.Join(projectDir, "Config")
+	require.NoError(t, os.MkdirAll(configDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(configDir, "Debug.entitlements"), []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>aps-environment</key>
+	<string>development</string>
+</dict>
+</plist>`), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(configDir, "Release.entitlements"), []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>aps-environment</key>
+	<string>production</string>
+	<key>com.apple.developer.associated-domains</key>
+	<array>
+		<string>applinks:bitrise.io</string>
+	</array>
+</dict>
+</plist>`), 0600))
+
+	projectPath := filepath.Join(projectDir, "MyApp.xcodeproj")
+	settings := serialized.Object{"CODE_SIGN_ENTITLEMENTS": "Config/$(CONFIGURATION).entitlements"}
+
+	tests := []struct {
+		config           string
+		wantPath         string
+		wantCapabilities []string
+	}{
+		{config: "Debug", wantPath: filepath.Join(configDir, "Debug.entitlements"), wantCapabilities: []string{"aps-environment"}},
+		{config: "Release", wantPath: filepath.Join(configDir, "Release.entitlements"), wantCapabilities: []string{"aps-environment", "com.apple.developer.associated-domains"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.config, func(t *testing.T) {
+			got, err := resolveEntitlementsPath("Config/$(CONFIGURATION).entitlements", projectPath, "MyApp", tt.config, settings)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPath, got)
+
+			entitlements, err := readPlist(got)
+			require.NoError(t, err)
+			require.ElementsMatch(t, tt.wantCapabilities, entitlements.Keys())
+		})
+	}
+
+	got, err := resolveEntitlementsPath("$(SRCROOT)/Config/$(CONFIGURATION).entitlements", projectPath, "MyApp", "Debug", settings)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(configDir, "Debug.entitlements"), got)
+}
+
+func Test_projectFileBuildSettings(t *testing.T) {
+	// Xcode 16 project format: objectVersion 77 with file system synchronized groups
+	projHelp, config, err := NewProjectHelper(filepath.Join("testdata", "xcode16", "App.xcodeproj"), "App", "", false, 0)
+	require.NoError(t, err)
+	require.Equal(t, "Release", config)
+	require.Equal(t, "App", projHelp.MainTarget.Name)
+
+	settings, err := projectFileBuildSettings(projHelp.XcProj.Proj, "App", config, nil)
+	require.NoError(t, err)
+
+	for key, want := range map[string]string{
+		"PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.App",
+		"PRODUCT_NAME":              "App",
+		"SDKROOT":                   "iphoneos",
+		"DEVELOPMENT_TEAM":          "TEAM123",
+		"CODE_SIGN_ENTITLEMENTS":    "App/App.entitlements",
+	} {
+		got, err := settings.String(key)
+		require.NoError(t, err)
+		require.Equal(t, want, got, key)
+	}
+
+	_, err = projectFileBuildSettings(projHelp.XcProj.Proj, "App", "Staging", nil)
+	require.Error(t, err)
+	_, err = projectFileBuildSettings(projHelp.XcProj.Proj, "Widget", config, nil)
+	require.Error(t, err)
+}
+
+func Test_projectFileBuildSettings_conditional(t *testing.T) {
+	configurationList := func(settings serialized.Object) xcodeproj.ConfigurationList {
+		return xcodeproj.ConfigurationList{BuildConfigurations: []xcodeproj.BuildConfiguration{{Name: "Release", BuildSettings: settings}}}
+	}
+	proj := xcodeproj.Proj{
+		BuildConfigurationList: configurationList(serialized.Object{
+			"SDKROOT":                           "iphoneos",
+			"DEVELOPMENT_TEAM":                  "SIMTEAM",
+			"DEVELOPMENT_TEAM[sdk=iphoneos*]":   "DEVICETEAM",
+			"CODE_SIGN_STYLE[sdk=macosx*]":      "Manual",
+			"PRODUCT_BUNDLE_IDENTIFIER":         "io.bitrise.app",
+			"CODE_SIGN_IDENTITY[sdk=iphoneos*]": "iPhone Developer",
+			"ENABLE_BITCODE[config=Release]":    "NO",
+		}),
+		Targets: []xcodeproj.Target{
+			{
+				Name: "App",
+				BuildConfigurationList: configurationList(serialized.Object{
+					"PRODUCT_BUNDLE_IDENTIFIER[sdk=iphoneos*][arch=arm64]": "io.bitrise.app.device",
+					"PRODUCT_BUNDLE_IDENTIFIER[sdk=iphonesimulator*]":      "io.bitrise.app.simulator",
+					"CODE_SIGN_IDENTITY[sdk=iphoneos*][config=Debug]":      "Apple Development",
+				}),
+			},
+			{
+				Name: "Widget",
+				BuildConfigurationList: configurationList(serialized.Object{
+					"DEVELOPMENT_TEAM": "WIDGETTEAM",
+				}),
+			},
+			{
+				Name: "Mac",
+				BuildConfigurationList: configurationList(serialized.Object{
+					"SDKROOT": "macosx",
+				}),
+			},
+		},
+	}
+
+	tests := []struct {
+		target string
+		want   map[string]string
+	}{
+		{
+			target: "App",
+			want: map[string]string{
+				"DEVELOPMENT_TEAM":          "DEVICETEAM",
+				"PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app.device",
+				"CODE_SIGN_IDENTITY":        "iPhone Developer",
+				"ENABLE_BITCODE":            "NO",
+			},
+		},
+		{
+			target: "Widget",
+			want: map[string]string{
+				"DEVELOPMENT_TEAM":          "WIDGETTEAM",
+				"PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app",
+			},
+		},
+		{
+			target: "Mac",
+			want: map[string]string{
+				"DEVELOPMENT_TEAM": "SIMTEAM",
+				"CODE_SIGN_STYLE":  "Manual",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			settings, err := projectFileBuildSettings(proj, tt.target, "Release", nil)
+			require.NoError(t, err)
+			for key, want := range tt.want {
+				got, err := settings.String(key)
+				require.NoError(t, err)
+				require.Equal(t, want, got, key)
+			}
+			for _, key := range settings.Keys() {
+				require.Equal(t, settingKeyWithoutConditions(key), key, "the conditional keys are resolved")
+			}
+		})
+	}
+}
+
+func Test_deviceSDK(t *testing.T) {
+	require.Equal(t, "iphoneos", deviceSDK(nil))
+	require.Equal(t, "appletvos", deviceSDK([]serialized.Object{{"SDKROOT": "iphoneos"}, {"SDKROOT": "appletvsimulator"}}))
+	require.Equal(t, "xros", deviceSDK([]serialized.Object{{"SDKROOT": "auto", "SUPPORTED_PLATFORMS": "xrsimulator xros"}}))
+}
+
+func Test_isFutureProjectFormatError(t *testing.T) {
+	require.True(t, isFutureProjectFormatError(fmt.Errorf(`xcodebuild "-project" "App.xcodeproj" "-showBuildSettings" command failed: output: xcodebuild: error: Unable to read project 'App.xcodeproj'.
+	Reason: The project 'App' cannot be opened because it is in a future Xcode project file format (77). Adjust the project format using a compatible version of Xcode to allow it to be opened by this version of Xcode.`)))
+	require.False(t, isFutureProjectFormatError(fmt.Errorf("xcodebuild: error: The project does not contain a target named 'Widget'.")))
+}
+
+func TestArchivableTargetBundleIDToEntitlements_sharedBundleID(t *testing.T) {
+	writeEntitlements := func(t *testing.T, dir, name, content string) string {
+		pth := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(pth, []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>`+content+`</dict>
+</plist>`), 0600))
+		return pth
+	}
+	newProjectHelper := func(dir, appEntitlements, clipEntitlements string) ProjectHelper {
+		clip := xcodeproj.Target{ID: "CLIP", Name: "Clip", ProductReference: xcodeproj.ProductReference{Path: "Clip.app"}}
+		settings := func(entitlementsPath string) serialized.Object {
+			return serialized.Object{"SDKROOT": "iphoneos", "PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app", "CODE_SIGN_ENTITLEMENTS": entitlementsPath}
+		}
+		return ProjectHelper{
+			MainTarget: xcodeproj.Target{
+				ID:               "APP",
+				Name:             "App",
+				ProductReference: xcodeproj.ProductReference{Path: "App.app"},
+				Dependencies:     []xcodeproj.TargetDependency{{Target: clip}},
+			},
+			XcProj:        xcodeproj.XcodeProj{Path: filepath.Join(dir, "App.xcodeproj")},
+			Configuration: "Release",
+			buildSettingsCache: map[string]map[string]serialized.Object{
+				"App":  {"Release": settings(appEntitlements)},
+				"Clip": {"Release": settings(clipEntitlements)},
+			},
+		}
+	}
+
+	t.Run("compatible entitlements are merged", func(t *testing.T) {
+		dir := t.TempDir()
+		p := newProjectHelper(dir,
+			writeEntitlements(t, dir, "App.entitlements", `<key>com.apple.security.application-groups</key><array><string>group.io.bitrise.app</string></array>`),
+			writeEntitlements(t, dir, "Clip.entitlements", `<key>com.apple.security.application-groups</key><array><string>group.io.bitrise.clip</string></array>`),
+		)
+
+		entitlementsByBundleID, err := p.ArchivableTargetBundleIDToEntitlements()
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"group.io.bitrise.app", "group.io.bitrise.clip"}, entitlementsByBundleID["io.bitrise.app"]["com.apple.security.application-groups"])
+	})
+
+	t.Run("conflicting entitlements fail with both targets", func(t *testing.T) {
+		dir := t.TempDir()
+		appEntitlements := writeEntitlements(t, dir, "App.entitlements", `<key>aps-environment</key><string>production</string>`)
+		clipEntitlements := writeEntitlements(t, dir, "Clip.entitlements", `<key>aps-environment</key><string>development</string>`)
+		p := newProjectHelper(dir, appEntitlements, clipEntitlements)
+
+		_, err := p.ArchivableTargetBundleIDToEntitlements()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "target (App) of "+p.XcProj.Path+" (entitlements: "+appEntitlements+")")
+		require.Contains(t, err.Error(), "target (Clip) of "+p.XcProj.Path+" (entitlements: "+clipEntitlements+")")
+		require.Contains(t, err.Error(), "conflicting values for entitlement aps-environment: production and development")
+	})
+}
+
+func Test_addMissingBuildSettings(t *testing.T) {
+	settings := serialized.Object{
+		"PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app",
+		"DEVELOPMENT_TEAM":          "",
+		"SDKROOT":                   "iphoneos",
+	}
+	projectFileSettings := serialized.Object{
+		"PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.other",
+		"DEVELOPMENT_TEAM":          "TEAM123",
+		"CODE_SIGN_STYLE":           "Automatic",
+		"INFOPLIST_FILE":            "$(SRCROOT)/App/Info.plist",
+	}
+
+	added := addMissingBuildSettings(settings, projectFileSettings)
+
+	require.Equal(t, []string{"CODE_SIGN_STYLE", "DEVELOPMENT_TEAM"}, added)
+	require.Equal(t, serialized.Object{
+		"PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app",
+		"DEVELOPMENT_TEAM":          "TEAM123",
+		"SDKROOT":                   "iphoneos",
+		"CODE_SIGN_STYLE":           "Automatic",
+	}, settings)
+}
+
+func Test_configuration_fallback(t *testing.T) {
+	target := func(name string, configurations ...string) xcodeproj.Target {
+		var buildConfigurations []xcodeproj.BuildConfiguration
+		for _, configuration := range configurations {
+			buildConfigurations = append(buildConfigurations, xcodeproj.BuildConfiguration{Name: configuration})
+		}
+		return xcodeproj.Target{Name: name, BuildConfigurationList: xcodeproj.ConfigurationList{BuildConfigurations: buildConfigurations}}
+	}
+	var scheme xcscheme.Scheme
+	scheme.ArchiveAction.BuildConfiguration = "Release"
+	xcproj := xcodeproj.XcodeProj{Proj: xcodeproj.Proj{Targets: []xcodeproj.Target{
+		target("App", "Debug", "Release", "Staging"),
+		target("Widget", "Debug", "Release"),
+		target("Legacy", "Debug", "Production"),
+	}}}
+
+	_, _, err := configuration("Staging", scheme, xcproj, false)
+	require.EqualError(t, err, "build configuration (Staging) not defined for target: (Widget)")
+
+	_, _, err = configuration("Staging", scheme, xcproj, true)
+	require.EqualError(t, err, "build configuration (Staging) not defined for target: (Legacy)")
+
+	xcproj.Proj.Targets = xcproj.Proj.Targets[:2]
+	conf, fallbackByTarget, err := configuration("Staging", scheme, xcproj, true)
+	require.NoError(t, err)
+	require.Equal(t, "Staging", conf)
+	require.Equal(t, map[string]string{"Widget": "Release"}, fallbackByTarget)
+
+	p := ProjectHelper{Configuration: conf, FallbackConfigurationByTarget: fallbackByTarget}
+	require.Equal(t, "Staging", p.TargetConfiguration("App", "Staging"))
+	require.Equal(t, "Release", p.TargetConfiguration("Widget", "Staging"))
+	require.Equal(t, "Debug", p.TargetConfiguration("Widget", "Debug"))
+
+	require.Equal(t, "  target  configuration\n  Widget  Release\n", configurationFallbackTable([]string{"Widget"}, fallbackByTarget))
+}
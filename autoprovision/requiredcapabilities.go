@@ -0,0 +1,66 @@
+package autoprovision
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// Capability is an app ID capability, required by an entitlement
+type Capability struct {
+	EntitlementKey string
+	Type           appstoreconnect.CapabilityType
+	Settings       []appstoreconnect.CapabilitySetting
+}
+
+// UnsupportedReason tells why the capability of an entitlement can not be enabled by the App Store Connect API
+type UnsupportedReason string
+
+// UnsupportedReasons ...
+const (
+	// UnknownEntitlement: the entitlement has no known capability mapping, but it may need a capability
+	UnknownEntitlement UnsupportedReason = "unknown-entitlement"
+	// ProfileAttached: the entitlement needs a manually generated profile or Apple's approval for the app ID
+	ProfileAttached UnsupportedReason = "profile-attached"
+)
+
+// UnsupportedKey is an entitlement key, whose capability can not be enabled by the App Store Connect API
+type UnsupportedKey struct {
+	Key    string
+	Reason UnsupportedReason
+}
+
+// CapabilitiesFromEntitlements returns the app ID capabilities required by the entitlements and the entitlement keys
+// whose capability can not be enabled by the App Store Connect API, both in the order of the entitlement keys.
+// The entitlements not appearing on the Developer Portal (like keychain-access-groups or the iCloud container identifiers) are in neither.
+// It has no side effects: it neither logs nor calls the API, so it can be used to validate entitlements without provisioning.
+// It returns an error if an entitlement value is invalid, like an unknown data protection level.
+func CapabilitiesFromEntitlements(entitlements serialized.Object) ([]Capability, []UnsupportedKey, error) {
+	var capabilities []Capability
+	var unsupported []UnsupportedKey
+	for _, key := range sortedKeys(entitlements) {
+		capabilityType, ok := appstoreconnect.ServiceTypeByKey[key]
+		if !ok {
+			if isUnmappedEntitlementKey(key) {
+				unsupported = append(unsupported, UnsupportedKey{Key: key, Reason: UnknownEntitlement})
+			}
+			continue
+		}
+
+		switch capabilityType {
+		case appstoreconnect.Ignored, appstoreconnect.ParentApplicationIdentifiers:
+			continue
+		case appstoreconnect.ProfileAttachedEntitlement:
+			unsupported = append(unsupported, UnsupportedKey{Key: key, Reason: ProfileAttached})
+			continue
+		}
+
+		settings, err := capabilitySettings(capabilityType, key, entitlements)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s entitlement: %s", key, err)
+		}
+		capabilities = append(capabilities, Capability{EntitlementKey: key, Type: capabilityType, Settings: settings})
+	}
+	return capabilities, unsupported, nil
+}
@@ -0,0 +1,132 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilitiesFromEntitlements(t *testing.T) {
+	tests := []struct {
+		name              string
+		entitlements      serialized.Object
+		wantCapabilities  []Capability
+		wantUnsupported   []UnsupportedKey
+		wantErrorContains string
+	}{
+		{
+			name:         "no entitlements",
+			entitlements: serialized.Object{},
+		},
+		{
+			name: "entitlements without capability",
+			entitlements: serialized.Object{
+				"application-identifier":                             "TEAM123.io.bitrise.app",
+				"keychain-access-groups":                             []interface{}{"$(AppIdentifierPrefix)io.bitrise.app"},
+				"get-task-allow":                                     true,
+				"com.apple.security.app-sandbox":                     true,
+				"com.apple.developer.icloud-container-identifiers":   []interface{}{"iCloud.io.bitrise.app"},
+				"com.apple.developer.parent-application-identifiers": []interface{}{"$(AppIdentifierPrefix)io.bitrise.app"},
+			},
+		},
+		{
+			name: "capabilities with settings",
+			entitlements: serialized.Object{
+				"com.apple.developer.icloud-services":         []interface{}{"CloudKit"},
+				"com.apple.developer.default-data-protection": "NSFileProtectionComplete",
+				"com.apple.developer.applesignin":             []interface{}{"Default"},
+				"aps-environment":                             "production",
+			},
+			wantCapabilities: []Capability{
+				{EntitlementKey: "aps-environment", Type: appstoreconnect.PushNotifications, Settings: []appstoreconnect.CapabilitySetting{}},
+				{
+					EntitlementKey: "com.apple.developer.applesignin",
+					Type:           appstoreconnect.SignInWithApple,
+					Settings:       []appstoreconnect.CapabilitySetting{{Key: appstoreconnect.AppleIDAuthAppConsent, Options: []appstoreconnect.CapabilityOption{{Key: "PRIMARY_APP_CONSENT"}}}},
+				},
+				{
+					EntitlementKey: "com.apple.developer.default-data-protection",
+					Type:           appstoreconnect.DataProtection,
+					Settings:       []appstoreconnect.CapabilitySetting{{Key: appstoreconnect.DataProtectionPermissionLevel, Options: []appstoreconnect.CapabilityOption{{Key: appstoreconnect.CompleteProtection}}}},
+				},
+				{
+					EntitlementKey: "com.apple.developer.icloud-services",
+					Type:           appstoreconnect.ICloud,
+					Settings:       []appstoreconnect.CapabilitySetting{{Key: appstoreconnect.IcloudVersion, Options: []appstoreconnect.CapabilityOption{{Key: appstoreconnect.Xcode6}}}},
+				},
+			},
+		},
+		{
+			name: "unsupported keys",
+			entitlements: serialized.Object{
+				"com.apple.developer.carplay-maps":                      true,
+				"com.apple.developer.usernotifications.critical-alerts": true,
+				"com.apple.developer.weatherkit":                        true,
+				"com.apple.developer.associated-domains":                []interface{}{"applinks:bitrise.io"},
+			},
+			wantCapabilities: []Capability{
+				{EntitlementKey: "com.apple.developer.associated-domains", Type: appstoreconnect.AssociatedDomains, Settings: []appstoreconnect.CapabilitySetting{}},
+			},
+			wantUnsupported: []UnsupportedKey{
+				{Key: "com.apple.developer.carplay-maps", Reason: ProfileAttached},
+				{Key: "com.apple.developer.usernotifications.critical-alerts", Reason: ProfileAttached},
+				{Key: "com.apple.developer.weatherkit", Reason: UnknownEntitlement},
+			},
+		},
+		{
+			name:              "invalid data protection level",
+			entitlements:      serialized.Object{"com.apple.developer.default-data-protection": "NSFileProtectionNone"},
+			wantErrorContains: "invalid com.apple.developer.default-data-protection entitlement: no data protection level found for entitlement value: NSFileProtectionNone",
+		},
+		{
+			name:              "invalid data protection value type",
+			entitlements:      serialized.Object{"com.apple.developer.default-data-protection": true},
+			wantErrorContains: "no entitlements value for key: com.apple.developer.default-data-protection",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capabilities, unsupported, err := CapabilitiesFromEntitlements(tt.entitlements)
+			if tt.wantErrorContains != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErrorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCapabilities, capabilities)
+			require.Equal(t, tt.wantUnsupported, unsupported)
+		})
+	}
+}
+
+func TestCapabilitiesFromEntitlements_everyKnownKey(t *testing.T) {
+	for key, capabilityType := range appstoreconnect.ServiceTypeByKey {
+		value := interface{}(true)
+		if capabilityType == appstoreconnect.DataProtection {
+			value = "NSFileProtectionCompleteUnlessOpen"
+		}
+
+		capabilities, unsupported, err := CapabilitiesFromEntitlements(serialized.Object{key: value})
+		require.NoError(t, err, key)
+
+		switch capabilityType {
+		case appstoreconnect.Ignored, appstoreconnect.ParentApplicationIdentifiers:
+			require.Empty(t, capabilities, key)
+			require.Empty(t, unsupported, key)
+		case appstoreconnect.ProfileAttachedEntitlement:
+			require.Empty(t, capabilities, key)
+			require.Equal(t, []UnsupportedKey{{Key: key, Reason: ProfileAttached}}, unsupported, key)
+		default:
+			require.Len(t, capabilities, 1, key)
+			require.Equal(t, capabilityType, capabilities[0].Type, key)
+			require.Empty(t, unsupported, key)
+
+			// The step enables the same capability for the entitlement
+			capability, err := Entitlement{key: value}.Capability()
+			require.NoError(t, err, key)
+			require.Equal(t, capability.Attributes.Settings, capabilities[0].Settings, key)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxSchemeSuggestions is the maximum number of similar scheme names suggested for a missing scheme
+const maxSchemeSuggestions = 3
+
+// diacriticsReplacer replaces the lowercase latin letters with diacritics with their base letters
+var diacriticsReplacer = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "ā", "a", "ă", "a", "ą", "a",
+	"ç", "c", "ć", "c", "ĉ", "c", "ċ", "c", "č", "c",
+	"ď", "d", "đ", "d",
+	"è", "e", "é", "e", "ê", "e", "ë", "e", "ē", "e", "ĕ", "e", "ė", "e", "ę", "e", "ě", "e",
+	"ĝ", "g", "ğ", "g", "ġ", "g", "ģ", "g",
+	"ĥ", "h", "ħ", "h",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ĩ", "i", "ī", "i", "ĭ", "i", "į", "i", "ı", "i",
+	"ĵ", "j", "ķ", "k",
+	"ĺ", "l", "ļ", "l", "ľ", "l", "ŀ", "l", "ł", "l",
+	"ñ", "n", "ń", "n", "ņ", "n", "ň", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "ō", "o", "ŏ", "o", "ő", "o",
+	"ŕ", "r", "ŗ", "r", "ř", "r",
+	"ś", "s", "ŝ", "s", "ş", "s", "š", "s", "ß", "ss",
+	"ţ", "t", "ť", "t", "ŧ", "t",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ũ", "u", "ū", "u", "ŭ", "u", "ů", "u", "ű", "u", "ų", "u",
+	"ŵ", "w", "ý", "y", "ÿ", "y", "ŷ", "y",
+	"ź", "z", "ż", "z", "ž", "z",
+	"æ", "ae", "œ", "oe",
+)
+
+// normalizeSchemeName returns the lowercased scheme name, without diacritics, leading and trailing whitespace,
+// and with the inner whitespace collapsed into a single space.
+func normalizeSchemeName(name string) string {
+	return diacriticsReplacer.Replace(strings.ToLower(strings.Join(strings.Fields(name), " ")))
+}
+
+// matchSchemeName returns the scheme of the schemes with the provided name.
+// If the name matches none of them exactly, but a single scheme differs only in case, diacritics or whitespace, that scheme is returned.
+// Otherwise the returned error suggests the most similar scheme names.
+func matchSchemeName(name string, schemes []string) (string, error) {
+	for _, scheme := range schemes {
+		if scheme == name {
+			return scheme, nil
+		}
+	}
+
+	normalizedName := normalizeSchemeName(name)
+	var matching []string
+	for _, scheme := range schemes {
+		if normalizeSchemeName(scheme) == normalizedName {
+			matching = append(matching, scheme)
+		}
+	}
+	if len(matching) == 1 {
+		return matching[0], nil
+	}
+	if len(matching) > 1 {
+		return "", fmt.Errorf("scheme (%s) not found, did you mean %s?", name, quoteSchemeNames(matching))
+	}
+
+	if suggestions := similarSchemeNames(name, schemes); len(suggestions) > 0 {
+		return "", fmt.Errorf("scheme (%s) not found, did you mean %s?", name, quoteSchemeNames(suggestions))
+	}
+	return "", fmt.Errorf("scheme (%s) not found, available schemes: %s", name, quoteSchemeNames(schemes))
+}
+
+// similarSchemeNames returns the scheme names closest to the name by edit distance of their normalized forms,
+// the ones differing in more than a third of the name are not considered similar.
+func similarSchemeNames(name string, schemes []string) []string {
+	normalizedName := normalizeSchemeName(name)
+	maxDistance := len([]rune(normalizedName)) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	distanceByScheme := map[string]int{}
+	var similar []string
+	for _, scheme := range schemes {
+		distance := levenshteinDistance(normalizedName, normalizeSchemeName(scheme))
+		if distance <= maxDistance {
+			distanceByScheme[scheme] = distance
+			similar = append(similar, scheme)
+		}
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		return distanceByScheme[similar[i]] < distanceByScheme[similar[j]]
+	})
+	if len(similar) > maxSchemeSuggestions {
+		similar = similar[:maxSchemeSuggestions]
+	}
+	return similar
+}
+
+// levenshteinDistance returns the number of single character insertions, deletions and substitutions turning a into b
+func levenshteinDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+	return previous[len(br)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func quoteSchemeNames(schemes []string) string {
+	quoted := make([]string, len(schemes))
+	for i, scheme := range schemes {
+		quoted[i] = "'" + scheme + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
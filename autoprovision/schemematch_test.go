@@ -0,0 +1,73 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_normalizeSchemeName(t *testing.T) {
+	require.Equal(t, "my app", normalizeSchemeName("  My\tApp "))
+	require.Equal(t, "cafe widget", normalizeSchemeName("Café  Widget"))
+	require.Equal(t, "strasse", normalizeSchemeName("Straße"))
+}
+
+func Test_matchSchemeName(t *testing.T) {
+	schemes := []string{"Café", "MyApp", "MyApp Staging", "MyApp-tvOS", "Widget"}
+
+	tests := []struct {
+		name       string
+		schemeName string
+		want       string
+		wantErr    string
+	}{
+		{
+			name:       "exact match",
+			schemeName: "MyApp",
+			want:       "MyApp",
+		},
+		{
+			name:       "case differs",
+			schemeName: "myapp",
+			want:       "MyApp",
+		},
+		{
+			name:       "diacritics and whitespace differ",
+			schemeName: " cafe ",
+			want:       "Café",
+		},
+		{
+			name:       "typo suggests similar schemes",
+			schemeName: "MyAp Staging",
+			wantErr:    "scheme (MyAp Staging) not found, did you mean 'MyApp Staging'?",
+		},
+		{
+			name:       "unrelated name lists the schemes",
+			schemeName: "Backend",
+			wantErr:    "scheme (Backend) not found, available schemes: 'Café', 'MyApp', 'MyApp Staging', 'MyApp-tvOS', 'Widget'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchSchemeName(tt.schemeName, schemes)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_matchSchemeName_ambiguous(t *testing.T) {
+	_, err := matchSchemeName("myapp", []string{"MyApp", "MYAPP"})
+	require.EqualError(t, err, "scheme (myapp) not found, did you mean 'MyApp', 'MYAPP'?")
+}
+
+func Test_levenshteinDistance(t *testing.T) {
+	require.Equal(t, 0, levenshteinDistance("myapp", "myapp"))
+	require.Equal(t, 1, levenshteinDistance("myap", "myapp"))
+	require.Equal(t, 3, levenshteinDistance("kitten", "sitting"))
+	require.Equal(t, 5, levenshteinDistance("", "myapp"))
+}
@@ -0,0 +1,241 @@
+package autoprovision
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+const sessionVersion = 1
+
+// SessionMaxAge is the maximum age of a reused session, older sessions are considered stale
+const SessionMaxAge = time.Hour
+
+// Session holds the Developer Portal state (certificates, devices and app IDs) fetched by a Step run,
+// which is reused by the next runs of the Step in the same workflow (for example a development, then an app-store run),
+// to spare the App Store Connect API calls.
+// Calling the methods on a nil Session queries the API without reusing anything.
+// The app ID methods are safe for concurrent use, the profiles of the bundle IDs are ensured in parallel.
+type Session struct {
+	Version   int       `json:"version"`
+	Account   string    `json:"account"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// CertificatesBySerial is keyed by the hexadecimal serial number
+	CertificatesBySerial  map[string]appstoreconnect.Certificate                      `json:"certificates_by_serial"`
+	DevicesByPlatform     map[appstoreconnect.DevicePlatform][]appstoreconnect.Device `json:"devices_by_platform"`
+	BundleIDsByIdentifier map[string]appstoreconnect.BundleID                         `json:"bundle_ids_by_identifier"`
+
+	// mu guards BundleIDsByIdentifier
+	mu sync.Mutex
+}
+
+// NewSession creates an empty session for the account (the API key or provisioning server the state belongs to).
+func NewSession(account string) *Session {
+	return &Session{
+		Version:               sessionVersion,
+		Account:               account,
+		CertificatesBySerial:  map[string]appstoreconnect.Certificate{},
+		DevicesByPlatform:     map[appstoreconnect.DevicePlatform][]appstoreconnect.Device{},
+		BundleIDsByIdentifier: map[string]appstoreconnect.BundleID{},
+	}
+}
+
+// ReadSession reads the session file written by a previous run.
+// A new, empty session is returned if the file does not exist, or the session can not be reused:
+// it belongs to another account, it was written by another Step version or it is older than SessionMaxAge.
+func ReadSession(pth, account string, now time.Time) (*Session, error) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return NewSession(account), nil
+		}
+		return nil, fmt.Errorf("failed to read session (%s): %s", pth, err)
+	}
+
+	session := NewSession(account)
+	if err := json.Unmarshal(content, session); err != nil {
+		log.Warnf("Ignoring invalid session (%s): %s", pth, err)
+		return NewSession(account), nil
+	}
+
+	if reason := session.staleReason(account, now); reason != "" {
+		log.Warnf("Ignoring session (%s): %s", pth, reason)
+		return NewSession(account), nil
+	}
+
+	if session.CertificatesBySerial == nil {
+		session.CertificatesBySerial = map[string]appstoreconnect.Certificate{}
+	}
+	if session.DevicesByPlatform == nil {
+		session.DevicesByPlatform = map[appstoreconnect.DevicePlatform][]appstoreconnect.Device{}
+	}
+	if session.BundleIDsByIdentifier == nil {
+		session.BundleIDsByIdentifier = map[string]appstoreconnect.BundleID{}
+	}
+
+	return session, nil
+}
+
+func (s *Session) staleReason(account string, now time.Time) string {
+	if s.Version != sessionVersion {
+		return fmt.Sprintf("unsupported version (%d)", s.Version)
+	}
+	if s.Account != account {
+		return "it belongs to another account"
+	}
+	if now.Sub(s.UpdatedAt) > SessionMaxAge {
+		return fmt.Sprintf("it is older than %s", SessionMaxAge)
+	}
+	return ""
+}
+
+// Write saves the session to the given path, readable by the current user only.
+func (s *Session) Write(pth string, now time.Time) error {
+	s.UpdatedAt = now
+
+	content, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to serialize session: %s", err)
+	}
+
+	if err := writeFileAtomic(pth, content); err != nil {
+		return fmt.Errorf("failed to write session (%s): %s", pth, err)
+	}
+	return nil
+}
+
+// APIClientWithSession returns a CertificateSource, which reuses the certificates of the session.
+func APIClientWithSession(client *appstoreconnect.Client, session *Session) CertificateSource {
+	source := APIClient(client)
+	if session == nil {
+		return source
+	}
+
+	source.queryCertificateBySerialFunc = func(client *appstoreconnect.Client, serial *big.Int) (APICertificate, error) {
+		key := serial.Text(16)
+
+		certificate, ok := session.CertificatesBySerial[key]
+		if !ok {
+			var err error
+			if certificate, err = client.Provisioning.FetchCertificate(key); err != nil {
+				return APICertificate{}, err
+			}
+			session.CertificatesBySerial[key] = certificate
+		} else {
+			log.Debugf("Reusing certificate (%s) of the session", key)
+		}
+
+		certs, err := parseCertificatesResponse([]appstoreconnect.Certificate{certificate})
+		if err != nil {
+			return APICertificate{}, err
+		}
+		if len(certs) == 0 {
+			return APICertificate{}, fmt.Errorf("failed to parse certificate (%s)", key)
+		}
+		return certs[0], nil
+	}
+	return source
+}
+
+// ListDevices returns the enabled devices of the platform, see ListDevices.
+func (s *Session) ListDevices(client *appstoreconnect.Client, platform appstoreconnect.DevicePlatform) ([]appstoreconnect.Device, error) {
+	if s == nil {
+		return ListDevices(client, "", platform)
+	}
+
+	if devices, ok := s.DevicesByPlatform[platform]; ok {
+		log.Debugf("Reusing %d %s device(s) of the session", len(devices), platform)
+		return devices, nil
+	}
+
+	devices, err := ListDevices(client, "", platform)
+	if err != nil {
+		return nil, err
+	}
+	s.DevicesByPlatform[platform] = devices
+	return devices, nil
+}
+
+// AddDevice records a device registered in the run.
+func (s *Session) AddDevice(platform appstoreconnect.DevicePlatform, device appstoreconnect.Device) {
+	if s == nil {
+		return
+	}
+	if devices, ok := s.DevicesByPlatform[platform]; ok {
+		s.DevicesByPlatform[platform] = append(devices, device)
+	}
+}
+
+// FindBundleID returns the app ID with the identifier, see FindBundleID.
+func (s *Session) FindBundleID(client *appstoreconnect.Client, bundleIDIdentifier string) (*appstoreconnect.BundleID, error) {
+	if s == nil {
+		return FindBundleID(client, bundleIDIdentifier)
+	}
+
+	s.mu.Lock()
+	bundleID, ok := s.BundleIDsByIdentifier[bundleIDIdentifier]
+	s.mu.Unlock()
+	if ok {
+		log.Debugf("Reusing app ID (%s) of the session", bundleIDIdentifier)
+		return &bundleID, nil
+	}
+
+	found, err := FindBundleID(client, bundleIDIdentifier)
+	if err != nil || found == nil {
+		return found, err
+	}
+	s.AddBundleID(*found)
+	return found, nil
+}
+
+// FindBundleIDs returns the app IDs of the bundle IDs, see FindBundleIDs.
+// Only the bundle IDs missing from the session are looked up.
+func (s *Session) FindBundleIDs(client *appstoreconnect.Client, bundleIDIdentifiers []string) (map[string]appstoreconnect.BundleID, error) {
+	if s == nil {
+		return FindBundleIDs(client, bundleIDIdentifiers)
+	}
+
+	bundleIDByIdentifier := map[string]appstoreconnect.BundleID{}
+	var missing []string
+	s.mu.Lock()
+	for _, identifier := range bundleIDIdentifiers {
+		if bundleID, ok := s.BundleIDsByIdentifier[identifier]; ok {
+			log.Debugf("Reusing app ID (%s) of the session", identifier)
+			bundleIDByIdentifier[identifier] = bundleID
+		} else {
+			missing = append(missing, identifier)
+		}
+	}
+	s.mu.Unlock()
+	if len(missing) == 0 {
+		return bundleIDByIdentifier, nil
+	}
+
+	found, err := FindBundleIDs(client, missing)
+	if err != nil {
+		return nil, err
+	}
+	for identifier, bundleID := range found {
+		s.AddBundleID(bundleID)
+		bundleIDByIdentifier[identifier] = bundleID
+	}
+	return bundleIDByIdentifier, nil
+}
+
+// AddBundleID records an app ID created in the run.
+func (s *Session) AddBundleID(bundleID appstoreconnect.BundleID) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.BundleIDsByIdentifier[bundleID.Attributes.Identifier] = bundleID
+}
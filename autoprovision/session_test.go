@@ -0,0 +1,84 @@
+package autoprovision
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSession(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	device := appstoreconnect.Device{ID: "1", Attributes: appstoreconnect.DeviceAttributes{UDID: "udid"}}
+
+	dir, err := ioutil.TempDir("", "session")
+	require.NoError(t, err)
+
+	written := NewSession("key-id")
+	written.DevicesByPlatform[appstoreconnect.IOSDevice] = []appstoreconnect.Device{device}
+	pth := filepath.Join(dir, "session.json")
+	require.NoError(t, written.Write(pth, now))
+
+	invalidPth := filepath.Join(dir, "invalid.json")
+	require.NoError(t, ioutil.WriteFile(invalidPth, []byte("{"), 0600))
+
+	tests := []struct {
+		name        string
+		pth         string
+		account     string
+		now         time.Time
+		wantDevices bool
+	}{
+		{name: "missing session", pth: filepath.Join(dir, "missing.json"), account: "key-id", now: now},
+		{name: "invalid session", pth: invalidPth, account: "key-id", now: now},
+		{name: "session of another account", pth: pth, account: "other-key-id", now: now},
+		{name: "stale session", pth: pth, account: "key-id", now: now.Add(SessionMaxAge + time.Minute)},
+		{name: "reused session", pth: pth, account: "key-id", now: now.Add(time.Minute), wantDevices: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadSession(tt.pth, tt.account, tt.now)
+			require.NoError(t, err)
+			require.Equal(t, tt.account, got.Account)
+
+			if tt.wantDevices {
+				require.Equal(t, now, got.UpdatedAt)
+				require.Equal(t, []appstoreconnect.Device{device}, got.DevicesByPlatform[appstoreconnect.IOSDevice])
+			} else {
+				require.True(t, got.UpdatedAt.IsZero())
+				require.Equal(t, 0, len(got.DevicesByPlatform))
+			}
+			require.NotNil(t, got.CertificatesBySerial)
+			require.NotNil(t, got.BundleIDsByIdentifier)
+		})
+	}
+}
+
+func TestSession_AddDevice(t *testing.T) {
+	var nilSession *Session
+	nilSession.AddDevice(appstoreconnect.IOSDevice, appstoreconnect.Device{ID: "1"})
+	nilSession.AddBundleID(appstoreconnect.BundleID{ID: "1"})
+
+	session := NewSession("key-id")
+	session.AddDevice(appstoreconnect.IOSDevice, appstoreconnect.Device{ID: "1"})
+	_, ok := session.DevicesByPlatform[appstoreconnect.IOSDevice]
+	require.False(t, ok, "devices of a platform not listed yet are not recorded")
+
+	session.DevicesByPlatform[appstoreconnect.IOSDevice] = []appstoreconnect.Device{}
+	session.AddDevice(appstoreconnect.IOSDevice, appstoreconnect.Device{ID: "1"})
+	require.Equal(t, []appstoreconnect.Device{{ID: "1"}}, session.DevicesByPlatform[appstoreconnect.IOSDevice])
+}
+
+func TestSession_FindBundleID(t *testing.T) {
+	bundleID := appstoreconnect.BundleID{ID: "1", Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.app"}}
+
+	session := NewSession("key-id")
+	session.AddBundleID(bundleID)
+
+	got, err := session.FindBundleID(nil, "io.bitrise.app")
+	require.NoError(t, err)
+	require.Equal(t, &bundleID, got)
+}
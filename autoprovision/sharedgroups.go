@@ -0,0 +1,117 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+// sharedGroupEntitlementKeys are the entitlements, the host app and its extensions communicate through:
+// the shared app group containers and the shared keychain items.
+var sharedGroupEntitlementKeys = []string{
+	"com.apple.security.application-groups",
+	"keychain-access-groups",
+}
+
+// ExtensionBundleIDs returns the sorted bundle IDs of the main target's app extension targets built for archiving.
+func (p *ProjectHelper) ExtensionBundleIDs() ([]string, error) {
+	var bundleIDs []string
+	for _, target := range p.dependentTargets() {
+		if p.targetKind(target) != AppExtensionTarget || p.NotArchivedTargetIDs[target.ID] || p.failedTargetIDs[target.ID] {
+			continue
+		}
+		bundleID, err := p.TargetBundleID(target.Name, p.Configuration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get target (%s) bundle id: %s", target.Name, err)
+		}
+		bundleIDs = append(bundleIDs, bundleID)
+	}
+	sort.Strings(bundleIDs)
+	return bundleIDs, nil
+}
+
+// SharedGroupMismatch is a group entitlement of an extension, without a group in common with the host app,
+// so the extension can not share data (or keychain items) with the app.
+type SharedGroupMismatch struct {
+	Key             string
+	HostBundleID    string
+	HostGroups      []string
+	BundleID        string
+	ExtensionGroups []string
+}
+
+func (m SharedGroupMismatch) String() string {
+	hostGroups := "none"
+	if len(m.HostGroups) > 0 {
+		hostGroups = strings.Join(m.HostGroups, ", ")
+	}
+	return fmt.Sprintf("%s: %s (%s), host app %s: %s", m.Key, m.BundleID, strings.Join(m.ExtensionGroups, ", "), m.HostBundleID, hostGroups)
+}
+
+// SharedGroupMismatches returns the group entitlements (app groups and keychain access groups) of the extensions,
+// which have no group in common with the host app. Extensions not declaring the entitlement are not checked,
+// as they do not communicate with the host app through it.
+func SharedGroupMismatches(hostBundleID string, extensionBundleIDs []string, entitlementsByBundleID map[string]serialized.Object) []SharedGroupMismatch {
+	hostEntitlements := entitlementsByBundleID[hostBundleID]
+
+	var mismatches []SharedGroupMismatch
+	for _, bundleID := range extensionBundleIDs {
+		if bundleID == hostBundleID {
+			continue
+		}
+
+		for _, key := range sharedGroupEntitlementKeys {
+			extensionGroups := entitlementGroups(entitlementsByBundleID[bundleID], key)
+			if len(extensionGroups) == 0 {
+				continue
+			}
+			hostGroups := entitlementGroups(hostEntitlements, key)
+			if sharesGroup(hostGroups, extensionGroups) {
+				continue
+			}
+
+			mismatches = append(mismatches, SharedGroupMismatch{
+				Key:             key,
+				HostBundleID:    hostBundleID,
+				HostGroups:      hostGroups,
+				BundleID:        bundleID,
+				ExtensionGroups: extensionGroups,
+			})
+		}
+	}
+	return mismatches
+}
+
+// entitlementGroups returns the groups of the entitlement, an invalid value is treated as no group
+func entitlementGroups(entitlements serialized.Object, key string) []string {
+	if entitlements == nil {
+		return nil
+	}
+	groups, err := entitlements.StringSlice(key)
+	if err != nil {
+		return nil
+	}
+	return groups
+}
+
+func sharesGroup(a, b []string) bool {
+	for _, groupA := range a {
+		for _, groupB := range b {
+			if sharedGroupID(groupA) == sharedGroupID(groupB) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sharedGroupID returns the group without the team prefix build setting reference of the keychain access groups,
+// as the same group is declared as $(AppIdentifierPrefix)io.bitrise.shared or $(TeamIdentifierPrefix)io.bitrise.shared
+func sharedGroupID(group string) string {
+	for _, prefix := range []string{"$(AppIdentifierPrefix)", "$(TeamIdentifierPrefix)"} {
+		group = strings.TrimPrefix(group, prefix)
+	}
+	return group
+}
@@ -0,0 +1,41 @@
+package autoprovision
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedGroupMismatches(t *testing.T) {
+	entitlementsByBundleID := map[string]serialized.Object{
+		"io.bitrise.app": {
+			"com.apple.security.application-groups": []interface{}{"group.io.bitrise.shared"},
+			"keychain-access-groups":                []interface{}{"$(AppIdentifierPrefix)io.bitrise.shared"},
+		},
+		"io.bitrise.app.widget": {
+			"com.apple.security.application-groups": []interface{}{"group.io.bitrise.shared", "group.io.bitrise.widget"},
+			"keychain-access-groups":                []interface{}{"$(TeamIdentifierPrefix)io.bitrise.shared"},
+		},
+		"io.bitrise.app.share": {
+			"com.apple.security.application-groups": []interface{}{"group.io.bitrise.share"},
+		},
+		"io.bitrise.app.stickers": {},
+	}
+	extensions := []string{"io.bitrise.app.share", "io.bitrise.app.stickers", "io.bitrise.app.widget"}
+
+	mismatches := SharedGroupMismatches("io.bitrise.app", extensions, entitlementsByBundleID)
+	require.Equal(t, []SharedGroupMismatch{{
+		Key:             "com.apple.security.application-groups",
+		HostBundleID:    "io.bitrise.app",
+		HostGroups:      []string{"group.io.bitrise.shared"},
+		BundleID:        "io.bitrise.app.share",
+		ExtensionGroups: []string{"group.io.bitrise.share"},
+	}}, mismatches)
+	require.Equal(t, "com.apple.security.application-groups: io.bitrise.app.share (group.io.bitrise.share), host app io.bitrise.app: group.io.bitrise.shared", mismatches[0].String())
+
+	delete(entitlementsByBundleID["io.bitrise.app"], "keychain-access-groups")
+	mismatches = SharedGroupMismatches("io.bitrise.app", extensions, entitlementsByBundleID)
+	require.Len(t, mismatches, 2)
+	require.Equal(t, "keychain-access-groups: io.bitrise.app.widget ($(TeamIdentifierPrefix)io.bitrise.shared), host app io.bitrise.app: none", mismatches[1].String())
+}
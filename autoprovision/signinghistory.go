@@ -0,0 +1,113 @@
+package autoprovision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// SigningHistoryProfile is a provisioning profile of the signing history record
+type SigningHistoryProfile struct {
+	DistributionType DistributionType            `json:"distribution_type"`
+	BundleID         string                      `json:"bundle_id"`
+	Name             string                      `json:"name"`
+	UUID             string                      `json:"uuid"`
+	ProfileType      appstoreconnect.ProfileType `json:"profile_type"`
+	ExpirationDate   time.Time                   `json:"expiration_date"`
+}
+
+// NewSigningHistoryProfile ...
+func NewSigningHistoryProfile(distributionType DistributionType, bundleID string, profile appstoreconnect.Profile) SigningHistoryProfile {
+	return SigningHistoryProfile{
+		DistributionType: distributionType,
+		BundleID:         bundleID,
+		Name:             profile.Attributes.Name,
+		UUID:             profile.Attributes.UUID,
+		ProfileType:      profile.Attributes.ProfileType,
+		ExpirationDate:   time.Time(profile.Attributes.ExpirationDate),
+	}
+}
+
+// SigningHistoryRecord is the provisioning report of a build, keyed by the app slug and the build number,
+// the records of the builds make up a searchable signing history.
+type SigningHistoryRecord struct {
+	AppSlug      string                  `json:"app_slug"`
+	BuildNumber  string                  `json:"build_number"`
+	TeamID       string                  `json:"team_id"`
+	CreatedAt    time.Time               `json:"created_at"`
+	BundleIDs    []string                `json:"bundle_ids"`
+	Certificates []SigningIdentity       `json:"certificates"`
+	Profiles     []SigningHistoryProfile `json:"profiles"`
+}
+
+// sorted returns the record with the unique bundle IDs, the certificates and the profiles in a stable order,
+// the lists are empty instead of null in the JSON.
+func (r SigningHistoryRecord) sorted() SigningHistoryRecord {
+	unique := map[string]bool{}
+	bundleIDs := []string{}
+	for _, bundleID := range r.BundleIDs {
+		if !unique[bundleID] {
+			unique[bundleID] = true
+			bundleIDs = append(bundleIDs, bundleID)
+		}
+	}
+	sort.Strings(bundleIDs)
+	r.BundleIDs = bundleIDs
+
+	r.Certificates = append([]SigningIdentity{}, r.Certificates...)
+	sort.SliceStable(r.Certificates, func(i, j int) bool {
+		return r.Certificates[i].DistributionType < r.Certificates[j].DistributionType
+	})
+
+	r.Profiles = append([]SigningHistoryProfile{}, r.Profiles...)
+	sort.SliceStable(r.Profiles, func(i, j int) bool {
+		if r.Profiles[i].DistributionType != r.Profiles[j].DistributionType {
+			return r.Profiles[i].DistributionType < r.Profiles[j].DistributionType
+		}
+		return r.Profiles[i].BundleID < r.Profiles[j].BundleID
+	})
+	return r
+}
+
+// SendSigningHistoryRecord POSTs the record as JSON to the URL (a Bitrise Release Management or an own endpoint),
+// with an `Authorization: Bearer <token>` header if the token is set.
+func SendSigningHistoryRecord(url, token string, record SigningHistoryRecord) error {
+	body, err := json.Marshal(record.sorted())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create signing history request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("signing history request failed: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close signing history response body: %s", err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("signing history endpoint responded with status code %d: %s", resp.StatusCode, strings.TrimSpace(string(content)))
+	}
+	return nil
+}
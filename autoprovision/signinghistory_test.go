@@ -0,0 +1,64 @@
+package autoprovision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendSigningHistoryRecord(t *testing.T) {
+	expiration := time.Date(2027, 1, 2, 3, 4, 5, 0, time.UTC)
+	profile := func(name string) appstoreconnect.Profile {
+		return appstoreconnect.Profile{Attributes: appstoreconnect.ProfileAttributes{
+			Name:           name,
+			UUID:           name + "-uuid",
+			ProfileType:    appstoreconnect.IOSAppStore,
+			ExpirationDate: appstoreconnect.Time(expiration),
+		}}
+	}
+
+	var record SigningHistoryRecord
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		authorization = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&record))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	require.NoError(t, SendSigningHistoryRecord(server.URL, "token", SigningHistoryRecord{
+		AppSlug:     "app-slug",
+		BuildNumber: "42",
+		TeamID:      "TEAM123",
+		BundleIDs:   []string{"io.bitrise.app.widget", "io.bitrise.app", "io.bitrise.app"},
+		Profiles: []SigningHistoryProfile{
+			NewSigningHistoryProfile(AppStore, "io.bitrise.app.widget", profile("widget")),
+			NewSigningHistoryProfile(AppStore, "io.bitrise.app", profile("app")),
+		},
+	}))
+	require.Equal(t, "Bearer token", authorization)
+	require.Equal(t, "app-slug", record.AppSlug)
+	require.Equal(t, "42", record.BuildNumber)
+	require.Equal(t, []string{"io.bitrise.app", "io.bitrise.app.widget"}, record.BundleIDs)
+	require.Equal(t, []SigningIdentity{}, record.Certificates)
+	require.Equal(t, SigningHistoryProfile{
+		DistributionType: AppStore,
+		BundleID:         "io.bitrise.app",
+		Name:             "app",
+		UUID:             "app-uuid",
+		ProfileType:      appstoreconnect.IOSAppStore,
+		ExpirationDate:   expiration,
+	}, record.Profiles[0])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer failing.Close()
+	require.EqualError(t, SendSigningHistoryRecord(failing.URL, "", SigningHistoryRecord{}), "signing history endpoint responded with status code 401: unauthorized")
+}
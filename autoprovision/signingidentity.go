@@ -0,0 +1,59 @@
+package autoprovision
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/bitrise-io/go-xcode/certificateutil"
+)
+
+// SigningIdentity describes a signing certificate, the downstream steps embed in their SBOM or attestation documents.
+type SigningIdentity struct {
+	DistributionType  DistributionType `json:"distribution_type"`
+	CommonName        string           `json:"common_name"`
+	TeamID            string           `json:"team_id"`
+	Serial            string           `json:"serial"`
+	SHA1Fingerprint   string           `json:"sha1_fingerprint"`
+	SHA256Fingerprint string           `json:"sha256_fingerprint"`
+}
+
+// NewSigningIdentity returns the signing identity of the certificate,
+// the fingerprints are the uppercase hex encoded digests of the DER certificate, as shown by the Keychain Access app.
+func NewSigningIdentity(distributionType DistributionType, certificate certificateutil.CertificateInfoModel) SigningIdentity {
+	return SigningIdentity{
+		DistributionType:  distributionType,
+		CommonName:        certificate.CommonName,
+		TeamID:            certificate.TeamID,
+		Serial:            certificate.Serial,
+		SHA1Fingerprint:   fmt.Sprintf("%X", sha1.Sum(certificate.Certificate.Raw)),
+		SHA256Fingerprint: fmt.Sprintf("%X", sha256.Sum256(certificate.Certificate.Raw)),
+	}
+}
+
+// SigningIdentityReport lists the signing certificates the Step installed, for supply-chain compliance.
+type SigningIdentityReport struct {
+	TeamID     string            `json:"team_id"`
+	Identities []SigningIdentity `json:"signing_identities"`
+}
+
+// WriteSigningIdentityReport writes the report as JSON to the path, the identities are sorted by distribution type.
+func WriteSigningIdentityReport(pth string, report SigningIdentityReport) error {
+	sort.SliceStable(report.Identities, func(i, j int) bool {
+		return report.Identities[i].DistributionType < report.Identities[j].DistributionType
+	})
+	if report.Identities == nil {
+		report.Identities = []SigningIdentity{}
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize signing identity report: %s", err)
+	}
+	if err := writeFileAtomic(pth, content); err != nil {
+		return fmt.Errorf("failed to write signing identity report (%s): %s", pth, err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package autoprovision
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCertificateInfo(t *testing.T, commonName string) certificateutil.CertificateInfoModel {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization:       []string{"Bitrise"},
+			OrganizationalUnit: []string{"TEAM123"},
+			CommonName:         commonName,
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().AddDate(1, 0, 0),
+	}
+	certData, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certData)
+	require.NoError(t, err)
+	return certificateutil.NewCertificateInfo(*cert, key)
+}
+
+func TestNewSigningIdentity(t *testing.T) {
+	certificate := newTestCertificateInfo(t, "Apple Distribution: Bitrise")
+
+	identity := NewSigningIdentity(AppStore, certificate)
+	require.Equal(t, AppStore, identity.DistributionType)
+	require.Equal(t, "Apple Distribution: Bitrise", identity.CommonName)
+	require.Equal(t, "TEAM123", identity.TeamID)
+	require.Equal(t, strings.ToUpper(certificate.SHA1Fingerprint), identity.SHA1Fingerprint)
+	require.Regexp(t, "^[0-9A-F]{64}$", identity.SHA256Fingerprint)
+}
+
+func TestWriteSigningIdentityReport(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "signing_identities.json")
+	report := SigningIdentityReport{
+		TeamID: "TEAM123",
+		Identities: []SigningIdentity{
+			NewSigningIdentity(Development, newTestCertificateInfo(t, "Apple Development: Bitrise")),
+			NewSigningIdentity(AdHoc, newTestCertificateInfo(t, "Apple Distribution: Bitrise")),
+		},
+	}
+	require.NoError(t, WriteSigningIdentityReport(pth, report))
+
+	content, err := ioutil.ReadFile(pth)
+	require.NoError(t, err)
+	var got SigningIdentityReport
+	require.NoError(t, json.Unmarshal(content, &got))
+	require.Equal(t, "TEAM123", got.TeamID)
+	require.Equal(t, []DistributionType{AdHoc, Development}, []DistributionType{got.Identities[0].DistributionType, got.Identities[1].DistributionType})
+
+	require.NoError(t, WriteSigningIdentityReport(pth, SigningIdentityReport{TeamID: "TEAM123"}))
+	content, err = ioutil.ReadFile(pth)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"signing_identities": []`)
+}
@@ -0,0 +1,230 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+// TargetSigningReport describes the code signing setup of a target in a build configuration
+// and what the Step would do with it.
+type TargetSigningReport struct {
+	Target        string
+	Configuration string
+
+	BundleID         string
+	TeamID           string
+	CodeSignStyle    string
+	CodeSignIdentity string
+	ProfileSpecifier string
+	Entitlements     []string
+
+	Constraints []string
+	Actions     []string
+}
+
+// String renders the report in a human-readable form.
+func (r TargetSigningReport) String() string {
+	valueOrUnset := func(value string) string {
+		if value == "" {
+			return "(not set)"
+		}
+		return value
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Target: %s, configuration: %s\n", r.Target, r.Configuration)
+	fmt.Fprintf(&b, "  bundle ID: %s\n", valueOrUnset(r.BundleID))
+	fmt.Fprintf(&b, "  team: %s\n", valueOrUnset(r.TeamID))
+	fmt.Fprintf(&b, "  signing style: %s\n", valueOrUnset(r.CodeSignStyle))
+	if r.automaticSigning() {
+		fmt.Fprintf(&b, "  identity: (picked by Xcode)\n")
+		fmt.Fprintf(&b, "  profile: (picked by Xcode)\n")
+	} else {
+		fmt.Fprintf(&b, "  identity: %s\n", valueOrUnset(r.CodeSignIdentity))
+		fmt.Fprintf(&b, "  profile: %s\n", valueOrUnset(r.ProfileSpecifier))
+	}
+
+	sections := []struct {
+		title string
+		items []string
+	}{
+		{"entitlements", r.Entitlements},
+		{"constraints", r.Constraints},
+		{"the Step would", r.Actions},
+	}
+	for _, section := range sections {
+		if len(section.items) == 0 {
+			fmt.Fprintf(&b, "  %s: none\n", section.title)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s:\n", section.title)
+		for _, item := range section.items {
+			fmt.Fprintf(&b, "  - %s\n", item)
+		}
+	}
+
+	return b.String()
+}
+
+func (r TargetSigningReport) automaticSigning() bool {
+	return strings.EqualFold(r.CodeSignStyle, "Automatic")
+}
+
+// productTypeRequirement describes the code signing requirement of the product type
+func productTypeRequirement(productType string) string {
+	if productType == "" {
+		return ""
+	}
+	if ProductTypeKind(productType).NeedsProfile() {
+		return fmt.Sprintf("product type %s: needs an app ID and a provisioning profile", productType)
+	}
+	return fmt.Sprintf("product type %s: signed without a provisioning profile", productType)
+}
+
+// SigningReport analyzes the code signing setup of the main target and its dependent executable product targets
+// in every build configuration. It does not require App Store Connect credentials,
+// the actions describe what the Step would do for the given distribution type with the project helper's configuration.
+func (p *ProjectHelper) SigningReport(distribution DistributionType) ([]TargetSigningReport, error) {
+	platform, err := p.Platform(p.Configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []TargetSigningReport
+	for _, target := range p.mainAndDependentTargets() {
+		for _, buildConfiguration := range target.BuildConfigurationList.BuildConfigurations {
+			settings, err := p.targetBuildSettings(target.Name, buildConfiguration.Name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch target (%s) settings: %s", target.Name, err)
+			}
+
+			bundleID, err := p.TargetBundleID(target.Name, buildConfiguration.Name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get target (%s) bundle id: %s", target.Name, err)
+			}
+
+			entitlements, err := p.targetEntitlements(target.Name, buildConfiguration.Name, bundleID)
+			if err != nil && !serialized.IsKeyNotFoundError(err) {
+				return nil, fmt.Errorf("failed to get target (%s) entitlements: %s", target.Name, err)
+			}
+
+			report := newTargetSigningReport(target.Name, buildConfiguration.Name, bundleID, settings, entitlements)
+			if buildConfiguration.Name == p.Configuration {
+				report.Actions = signingActions(report, settings, entitlements, platform, distribution)
+			} else {
+				report.Actions = []string{fmt.Sprintf("nothing, the Step provisions the %s configuration only", p.Configuration)}
+			}
+
+			reports = append(reports, report)
+		}
+	}
+
+	return reports, nil
+}
+
+func newTargetSigningReport(target, configuration, bundleID string, settings, entitlements serialized.Object) TargetSigningReport {
+	setting := func(key string) string {
+		value, err := settings.String(key)
+		if err != nil {
+			return ""
+		}
+		return value
+	}
+
+	report := TargetSigningReport{
+		Target:        target,
+		Configuration: configuration,
+		BundleID:      bundleID,
+		TeamID:        setting("DEVELOPMENT_TEAM"),
+		CodeSignStyle: setting("CODE_SIGN_STYLE"),
+		Entitlements:  entitlements.Keys(),
+	}
+	sort.Strings(report.Entitlements)
+
+	// With automatic signing, Xcode fills the identity and profile build settings with placeholder values
+	// and picks the actual ones at build time, so the requirements are derived from the entitlements and the product type.
+	if !report.automaticSigning() {
+		report.CodeSignIdentity = setting("CODE_SIGN_IDENTITY")
+		report.ProfileSpecifier = setting("PROVISIONING_PROFILE_SPECIFIER")
+		if report.ProfileSpecifier == "" {
+			report.ProfileSpecifier = setting("PROVISIONING_PROFILE")
+		}
+	}
+
+	if reason := simulatorOnlyPlatformsReason(settings); reason != "" {
+		report.Constraints = append(report.Constraints, fmt.Sprintf("no device SDK is supported: %s", reason))
+	} else if reason := skipCodeSigningReason(settings); reason != "" {
+		report.Constraints = append(report.Constraints, fmt.Sprintf("not code signed for device: %s", reason))
+	}
+
+	if report.automaticSigning() {
+		if requirement := productTypeRequirement(setting("PRODUCT_TYPE")); requirement != "" {
+			report.Constraints = append(report.Constraints, requirement)
+		}
+	}
+
+	identity := strings.ToLower(report.CodeSignIdentity)
+	if strings.Contains(identity, "distribution") {
+		report.Constraints = append(report.Constraints, "signed with a distribution identity: can not be run on devices from Xcode")
+	} else if strings.Contains(identity, "develop") {
+		report.Constraints = append(report.Constraints, "signed with a development identity: the archive needs to be re-signed for App Store, Ad Hoc or Enterprise distribution")
+	}
+
+	if getTaskAllow, ok := entitlements["get-task-allow"].(bool); ok && getTaskAllow {
+		report.Constraints = append(report.Constraints, "get-task-allow entitlement is enabled: development distribution only")
+	}
+
+	for _, key := range approvalRequiredEntitlementKeys(Entitlement(entitlements)) {
+		report.Constraints = append(report.Constraints, fmt.Sprintf("entitlement %s requires Apple's approval before a profile can be generated with it", key))
+	}
+
+	return report
+}
+
+func signingActions(report TargetSigningReport, settings, entitlements serialized.Object, platform Platform, distribution DistributionType) []string {
+	if reason := simulatorOnlyPlatformsReason(settings); reason != "" {
+		return []string{"skip the target, it is built for simulators only"}
+	}
+	if reason := skipCodeSigningReason(settings); reason != "" {
+		return []string{"skip the target, it is not code signed for device"}
+	}
+
+	var actions []string
+
+	var capabilities int
+	for key, value := range entitlements {
+		if (Entitlement{key: value}).AppearsOnDeveloperPortal() {
+			capabilities++
+		}
+	}
+	actions = append(actions, fmt.Sprintf("ensure the app ID %s exists on the Developer Portal with %d capabilities", report.BundleID, capabilities))
+
+	distributionTypes := []DistributionType{distribution}
+	if distribution != Development {
+		distributionTypes = append(distributionTypes, Development)
+	}
+	for _, distributionType := range distributionTypes {
+		profileType, ok := PlatformToProfileTypeByDistribution[platform][distributionType]
+		if !ok {
+			actions = append(actions, fmt.Sprintf("fail, no %s profiles for platform %s", distributionType, platform))
+			continue
+		}
+
+		name, err := ProfileName(profileType, report.BundleID)
+		if err != nil {
+			actions = append(actions, fmt.Sprintf("fail to name the %s profile: %s", distributionType, err))
+			continue
+		}
+		actions = append(actions, fmt.Sprintf("ensure the %s profile: %s", distributionType, KeyedProfileName(name, "<profile key>")))
+	}
+
+	if report.CodeSignStyle != "Manual" {
+		actions = append(actions, "switch the target to manual code signing")
+	}
+	actions = append(actions, "set the team, the code sign identity and the Bitrise managed profile in the build settings")
+
+	return actions
+}
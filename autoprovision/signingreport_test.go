@@ -0,0 +1,151 @@
+package autoprovision
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+func Test_newTargetSigningReport(t *testing.T) {
+	entitlements := serialized.Object{
+		"aps-environment":                  "development",
+		"com.apple.developer.carplay-maps": true,
+		"get-task-allow":                   true,
+	}
+
+	tests := []struct {
+		name     string
+		settings serialized.Object
+		want     TargetSigningReport
+	}{
+		{
+			name: "automatic signing ignores the placeholder identity and profile",
+			settings: serialized.Object{
+				"DEVELOPMENT_TEAM":               "72SA8V3WYL",
+				"CODE_SIGN_STYLE":                "Automatic",
+				"CODE_SIGN_IDENTITY":             "iPhone Developer",
+				"PROVISIONING_PROFILE_SPECIFIER": "",
+				"PRODUCT_TYPE":                   "com.apple.product-type.application",
+			},
+			want: TargetSigningReport{
+				Target:        "App",
+				Configuration: "Debug",
+				BundleID:      "io.bitrise.app",
+				TeamID:        "72SA8V3WYL",
+				CodeSignStyle: "Automatic",
+				Entitlements:  []string{"aps-environment", "com.apple.developer.carplay-maps", "get-task-allow"},
+				Constraints: []string{
+					"product type com.apple.product-type.application: needs an app ID and a provisioning profile",
+					"get-task-allow entitlement is enabled: development distribution only",
+					"entitlement com.apple.developer.carplay-maps requires Apple's approval before a profile can be generated with it",
+				},
+			},
+		},
+		{
+			name: "manual signing",
+			settings: serialized.Object{
+				"DEVELOPMENT_TEAM":               "72SA8V3WYL",
+				"CODE_SIGN_STYLE":                "Manual",
+				"CODE_SIGN_IDENTITY":             "iPhone Developer",
+				"PROVISIONING_PROFILE_SPECIFIER": "App Development",
+				"PRODUCT_TYPE":                   "com.apple.product-type.application",
+			},
+			want: TargetSigningReport{
+				Target:           "App",
+				Configuration:    "Debug",
+				BundleID:         "io.bitrise.app",
+				TeamID:           "72SA8V3WYL",
+				CodeSignStyle:    "Manual",
+				CodeSignIdentity: "iPhone Developer",
+				ProfileSpecifier: "App Development",
+				Entitlements:     []string{"aps-environment", "com.apple.developer.carplay-maps", "get-task-allow"},
+				Constraints: []string{
+					"signed with a development identity: the archive needs to be re-signed for App Store, Ad Hoc or Enterprise distribution",
+					"get-task-allow entitlement is enabled: development distribution only",
+					"entitlement com.apple.developer.carplay-maps requires Apple's approval before a profile can be generated with it",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newTargetSigningReport("App", "Debug", "io.bitrise.app", tt.settings, entitlements)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newTargetSigningReport() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_productTypeRequirement(t *testing.T) {
+	tests := []struct {
+		productType string
+		want        string
+	}{
+		{productType: "", want: ""},
+		{productType: "com.apple.product-type.app-extension", want: "product type com.apple.product-type.app-extension: needs an app ID and a provisioning profile"},
+		{productType: "com.apple.product-type.framework", want: "product type com.apple.product-type.framework: signed without a provisioning profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.productType, func(t *testing.T) {
+			if got := productTypeRequirement(tt.productType); got != tt.want {
+				t.Errorf("productTypeRequirement() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_signingActions(t *testing.T) {
+	tests := []struct {
+		name         string
+		report       TargetSigningReport
+		settings     serialized.Object
+		entitlements serialized.Object
+		platform     Platform
+		distribution DistributionType
+		want         []string
+	}{
+		{
+			name:     "simulator only target",
+			report:   TargetSigningReport{BundleID: "io.bitrise.app"},
+			settings: serialized.Object{"SDKROOT": "iphonesimulator"},
+			platform: IOS,
+			want:     []string{"skip the target, it is not code signed for device"},
+		},
+		{
+			name:         "automatic signing app store",
+			report:       TargetSigningReport{BundleID: "io.bitrise.app", CodeSignStyle: "Automatic"},
+			settings:     serialized.Object{},
+			entitlements: serialized.Object{"aps-environment": "production", "com.apple.developer.carplay-maps": true},
+			platform:     IOS,
+			distribution: AppStore,
+			want: []string{
+				"ensure the app ID io.bitrise.app exists on the Developer Portal with 1 capabilities",
+				"ensure the app-store profile: Bitrise iOS app-store - (io.bitrise.app) [<profile key>]",
+				"ensure the development profile: Bitrise iOS development - (io.bitrise.app) [<profile key>]",
+				"switch the target to manual code signing",
+				"set the team, the code sign identity and the Bitrise managed profile in the build settings",
+			},
+		},
+		{
+			name:         "manual signing development",
+			report:       TargetSigningReport{BundleID: "io.bitrise.tv", CodeSignStyle: "Manual"},
+			settings:     serialized.Object{},
+			platform:     TVOS,
+			distribution: Development,
+			want: []string{
+				"ensure the app ID io.bitrise.tv exists on the Developer Portal with 0 capabilities",
+				"ensure the development profile: Bitrise tvOS development - (io.bitrise.tv) [<profile key>]",
+				"set the team, the code sign identity and the Bitrise managed profile in the build settings",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signingActions(tt.report, tt.settings, tt.entitlements, tt.platform, tt.distribution); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("signingActions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package autoprovision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+)
+
+// TargetFailure is a dependent target of the main target, whose build settings, bundle ID or entitlements can not be resolved
+// (for example because of a broken file reference), skipped with SkipUnresolvableTargets.
+type TargetFailure struct {
+	Target        string
+	Configuration string
+	// Location describes where the target is declared
+	Location string
+	Err      error
+}
+
+func (f TargetFailure) String() string {
+	return fmt.Sprintf("target (%s) in configuration (%s): %s", f.Target, f.Configuration, f.Err)
+}
+
+// skipUnresolvableTarget records the failure of the target and returns true, if the target can be skipped:
+// SkipUnresolvableTargets is set and the target is not the main target, which is always required.
+func (p *ProjectHelper) skipUnresolvableTarget(target xcodeproj.Target, err error) bool {
+	if !p.SkipUnresolvableTargets || target.ID == p.MainTarget.ID {
+		return false
+	}
+
+	log.Warnf("Skipping target (%s), it can not be resolved: %s", target.Name, err)
+	if p.failedTargetIDs == nil {
+		p.failedTargetIDs = map[string]bool{}
+	}
+	p.failedTargetIDs[target.ID] = true
+	p.targetFailures = append(p.targetFailures, TargetFailure{
+		Target:        target.Name,
+		Configuration: p.TargetConfiguration(target.Name, p.Configuration),
+		Location:      p.XcProj.Path,
+		Err:           err,
+	})
+	return true
+}
+
+// TargetFailures returns the targets skipped, because they can not be resolved, in the order they were found
+func (p *ProjectHelper) TargetFailures() []TargetFailure {
+	return p.targetFailures
+}
+
+// TargetFailuresReport returns the detailed failure section of the skipped targets, empty if no target was skipped
+func TargetFailuresReport(failures []TargetFailure) string {
+	if len(failures) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d target(s) could not be resolved, they were skipped and no profiles were generated for them:\n", len(failures))
+	for _, failure := range failures {
+		fmt.Fprintf(&b, "- target: %s\n", failure.Target)
+		fmt.Fprintf(&b, "  project: %s\n", failure.Location)
+		fmt.Fprintf(&b, "  configuration: %s\n", failure.Configuration)
+		fmt.Fprintf(&b, "  error: %s\n", strings.Replace(strings.TrimSpace(failure.Err.Error()), "\n", "\n    ", -1))
+	}
+	b.WriteString("The archive fails if it builds these targets: fix their build settings, or exclude them from the Scheme's archive action.")
+	return b.String()
+}
@@ -0,0 +1,88 @@
+package autoprovision
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectHelper_SkipUnresolvableTargets(t *testing.T) {
+	newProjectHelper := func(dir string, appSettings serialized.Object) ProjectHelper {
+		extension := xcodeproj.Target{ID: "EXTENSION", Name: "Extension", ProductType: "com.apple.product-type.app-extension", ProductReference: xcodeproj.ProductReference{Path: "Extension.appex"}}
+		widget := xcodeproj.Target{ID: "WIDGET", Name: "Widget", ProductType: "com.apple.product-type.app-extension", ProductReference: xcodeproj.ProductReference{Path: "Widget.appex"}}
+		return ProjectHelper{
+			MainTarget: xcodeproj.Target{
+				ID:               "APP",
+				Name:             "App",
+				ProductReference: xcodeproj.ProductReference{Path: "App.app"},
+				Dependencies:     []xcodeproj.TargetDependency{{Target: extension}, {Target: widget}},
+			},
+			XcProj:        xcodeproj.XcodeProj{Path: filepath.Join(dir, "App.xcodeproj")},
+			Configuration: "Release",
+			buildSettingsCache: map[string]map[string]serialized.Object{
+				"App":       {"Release": appSettings},
+				"Extension": {"Release": {"SDKROOT": "iphoneos", "PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app.extension"}},
+				// the entitlements file of the widget is a broken reference
+				"Widget": {"Release": {"SDKROOT": "iphoneos", "PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app.widget", "CODE_SIGN_ENTITLEMENTS": filepath.Join(dir, "Missing.entitlements")}},
+			},
+		}
+	}
+	appSettings := serialized.Object{"SDKROOT": "iphoneos", "PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app"}
+
+	t.Run("unresolvable target fails by default", func(t *testing.T) {
+		p := newProjectHelper(t.TempDir(), appSettings)
+		_, err := p.ArchivableTargetBundleIDToEntitlements()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "Widget")
+	})
+
+	t.Run("unresolvable target is skipped", func(t *testing.T) {
+		p := newProjectHelper(t.TempDir(), appSettings)
+		p.SkipUnresolvableTargets = true
+
+		entitlementsByBundleID, err := p.ArchivableTargetBundleIDToEntitlements()
+		require.NoError(t, err)
+		require.Len(t, entitlementsByBundleID, 2)
+		require.Contains(t, entitlementsByBundleID, "io.bitrise.app.extension")
+
+		failures := p.TargetFailures()
+		require.Len(t, failures, 1)
+		require.Equal(t, "Widget", failures[0].Target)
+		require.Equal(t, "Release", failures[0].Configuration)
+
+		targets, err := p.ArchivableTargets()
+		require.NoError(t, err)
+		require.Len(t, targets, 2, "the skipped target is not code signed")
+		bundleIDs, err := p.ExtensionBundleIDs()
+		require.NoError(t, err)
+		require.Equal(t, []string{"io.bitrise.app.extension"}, bundleIDs)
+		require.Len(t, p.TargetFailures(), 1)
+	})
+
+	t.Run("unresolvable main target fails", func(t *testing.T) {
+		dir := t.TempDir()
+		p := newProjectHelper(dir, serialized.Object{"SDKROOT": "iphoneos", "PRODUCT_BUNDLE_IDENTIFIER": "io.bitrise.app", "CODE_SIGN_ENTITLEMENTS": filepath.Join(dir, "Missing.entitlements")})
+		p.SkipUnresolvableTargets = true
+
+		_, err := p.ArchivableTargetBundleIDToEntitlements()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "App")
+	})
+}
+
+func TestTargetFailuresReport(t *testing.T) {
+	require.Equal(t, "", TargetFailuresReport(nil))
+
+	report := TargetFailuresReport([]TargetFailure{{Target: "Widget", Configuration: "Release", Location: "App.xcodeproj", Err: errors.New("failed to get entitlements:\nfile not found")}})
+	require.Equal(t, `1 target(s) could not be resolved, they were skipped and no profiles were generated for them:
+- target: Widget
+  project: App.xcodeproj
+  configuration: Release
+  error: failed to get entitlements:
+    file not found
+The archive fails if it builds these targets: fix their build settings, or exclude them from the Scheme's archive action.`, report)
+}
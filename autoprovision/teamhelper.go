@@ -0,0 +1,123 @@
+package autoprovision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// Team is a development team, the API key or the provided certificates give access to
+type Team struct {
+	ID   string
+	Name string
+}
+
+// String ...
+func (t Team) String() string {
+	if t.Name == "" {
+		return t.ID
+	}
+	return fmt.Sprintf("%s (%s)", t.ID, t.Name)
+}
+
+// CertificateTeams returns the teams of the certificates, sorted by team ID.
+func CertificateTeams(certificates []certificateutil.CertificateInfoModel) []Team {
+	teamByID := map[string]Team{}
+	for _, cert := range certificates {
+		if cert.TeamID == "" {
+			continue
+		}
+		if team, ok := teamByID[cert.TeamID]; !ok || team.Name == "" {
+			teamByID[cert.TeamID] = Team{ID: cert.TeamID, Name: cert.TeamName}
+		}
+	}
+
+	var teams []Team
+	for _, team := range teamByID {
+		teams = append(teams, team)
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i].ID < teams[j].ID })
+	return teams
+}
+
+// APITeams returns the teams the API key has access to, sorted by team ID.
+// The API has no team endpoint, the teams are read from the certificates the key can list.
+// An individual API key of an account, which is a member of multiple teams, lists the certificates of each team.
+func APITeams(client *appstoreconnect.Client) ([]Team, error) {
+	certificates, err := client.Provisioning.ListAllCertificates(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	apiCertificates, err := parseCertificatesResponse(certificates)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []certificateutil.CertificateInfoModel
+	for _, cert := range apiCertificates {
+		infos = append(infos, cert.Certificate)
+	}
+	return CertificateTeams(infos), nil
+}
+
+// intersectTeams returns the teams of the API key, which the provided certificates belong to as well.
+func intersectTeams(apiTeams, certificateTeams []Team) []Team {
+	certificateTeamIDs := map[string]bool{}
+	for _, team := range certificateTeams {
+		certificateTeamIDs[team.ID] = true
+	}
+
+	var teams []Team
+	for _, team := range apiTeams {
+		if certificateTeamIDs[team.ID] {
+			teams = append(teams, team)
+		}
+	}
+	return teams
+}
+
+// SelectTeam returns the ID of the development team to provision for.
+// The candidate teams are the teams of the API key (apiTeams), which the certificates belong to as well.
+// apiTeams is nil if the API is not available (offline mode), then the candidates are the teams of the certificates.
+// The explicitly selected team takes precedence over the project's team.
+// If neither is set and there are multiple candidate teams, the choice is ambiguous
+// and the returned error lists the available teams.
+func SelectTeam(selectedTeamID, projectTeamID string, apiTeams []Team, certificates []certificateutil.CertificateInfoModel) (string, error) {
+	teams := CertificateTeams(certificates)
+	if apiTeams != nil {
+		teams = intersectTeams(apiTeams, teams)
+	}
+
+	if selectedTeamID != "" {
+		for _, team := range teams {
+			if team.ID == selectedTeamID {
+				return selectedTeamID, nil
+			}
+		}
+		return "", fmt.Errorf("the API key and the provided certificates do not both belong to the selected team (%s), available teams:\n%s", selectedTeamID, teamList(teams))
+	}
+
+	if projectTeamID != "" {
+		return projectTeamID, nil
+	}
+
+	if len(teams) > 1 {
+		return "", fmt.Errorf("the API key and the provided certificates belong to multiple teams and the project does not specify one, set the team ID input to one of:\n%s", teamList(teams))
+	}
+	if len(teams) == 1 {
+		return teams[0].ID, nil
+	}
+	return "", nil
+}
+
+func teamList(teams []Team) string {
+	var lines []string
+	for _, team := range teams {
+		lines = append(lines, "- "+team.String())
+	}
+	return strings.Join(lines, "\n")
+}
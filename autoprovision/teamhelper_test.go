@@ -0,0 +1,132 @@
+package autoprovision
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/testutil/ascmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectTeam(t *testing.T) {
+	certs := []certificateutil.CertificateInfoModel{
+		{CommonName: "iPhone Developer: Bot (1)", TeamID: "TEAM2", TeamName: "Second Team"},
+		{CommonName: "iPhone Distribution: Bot (2)", TeamID: "TEAM1", TeamName: "First Team"},
+		{CommonName: "iPhone Developer: Bot (3)", TeamID: "TEAM1", TeamName: "First Team"},
+	}
+
+	tests := []struct {
+		name           string
+		selectedTeamID string
+		projectTeamID  string
+		apiTeams       []Team
+		certs          []certificateutil.CertificateInfoModel
+		want           string
+		wantErr        string
+	}{
+		{
+			name:           "selected team",
+			selectedTeamID: "TEAM2",
+			projectTeamID:  "TEAM1",
+			certs:          certs,
+			want:           "TEAM2",
+		},
+		{
+			name:           "selected team without certificates",
+			selectedTeamID: "TEAM3",
+			certs:          certs,
+			wantErr:        "the API key and the provided certificates do not both belong to the selected team (TEAM3), available teams:\n- TEAM1 (First Team)\n- TEAM2 (Second Team)",
+		},
+		{
+			name:           "selected team without API access",
+			selectedTeamID: "TEAM2",
+			apiTeams:       []Team{{ID: "TEAM1", Name: "First Team"}, {ID: "TEAM3", Name: "Third Team"}},
+			certs:          certs,
+			wantErr:        "the API key and the provided certificates do not both belong to the selected team (TEAM2), available teams:\n- TEAM1 (First Team)",
+		},
+		{
+			name:          "project team",
+			projectTeamID: "TEAM1",
+			certs:         certs,
+			want:          "TEAM1",
+		},
+		{
+			name:    "ambiguous team",
+			certs:   certs,
+			wantErr: "the API key and the provided certificates belong to multiple teams and the project does not specify one, set the team ID input to one of:\n- TEAM1 (First Team)\n- TEAM2 (Second Team)",
+		},
+		{
+			name:     "ambiguous API key teams",
+			apiTeams: []Team{{ID: "TEAM1", Name: "First Team"}, {ID: "TEAM2", Name: "Second Team"}, {ID: "TEAM3", Name: "Third Team"}},
+			certs:    certs,
+			wantErr:  "the API key and the provided certificates belong to multiple teams and the project does not specify one, set the team ID input to one of:\n- TEAM1 (First Team)\n- TEAM2 (Second Team)",
+		},
+		{
+			name:     "single team of the API key and the certificates",
+			apiTeams: []Team{{ID: "TEAM2", Name: "Second Team"}, {ID: "TEAM3", Name: "Third Team"}},
+			certs:    certs,
+			want:     "TEAM2",
+		},
+		{
+			name:  "single certificate team",
+			certs: certs[1:],
+			want:  "TEAM1",
+		},
+		{
+			name: "no team",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectTeam(tt.selectedTeamID, tt.projectTeamID, tt.apiTeams, tt.certs)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAPITeams(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var certificates []appstoreconnect.Certificate
+	for i, team := range []Team{{ID: "TEAM2", Name: "Second Team"}, {ID: "TEAM1", Name: "First Team"}, {ID: "TEAM2", Name: "Second Team"}} {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 1)),
+			Subject:      pkix.Name{CommonName: "Apple Development: Bot", OrganizationalUnit: []string{team.ID}, Organization: []string{team.Name}},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().AddDate(1, 0, 0),
+		}
+		certData, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		require.NoError(t, err)
+		certificates = append(certificates, appstoreconnect.Certificate{
+			Attributes: appstoreconnect.CertificateAttributes{CertificateContent: certData, CertificateType: appstoreconnect.IOSDevelopment},
+			ID:         template.SerialNumber.String(),
+			Type:       "certificates",
+		})
+	}
+
+	server := ascmock.New(ascmock.Fixtures{Certificates: certificates})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+
+	teams, err := APITeams(client)
+	require.NoError(t, err)
+	require.Equal(t, []Team{{ID: "TEAM1", Name: "First Team"}, {ID: "TEAM2", Name: "Second Team"}}, teams)
+}
@@ -0,0 +1,94 @@
+package autoprovision
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+// WriteXcconfig returns the path of the xcconfig overriding the project's build settings, like the one passed to xcodebuild
+// with the -xcconfig flag at build time. The xcconfig can be either the path of an existing xcconfig file
+// or its content, which is written to a new file in the dir (or in the system temp dir if the dir is empty).
+// It returns an empty path for an empty xcconfig.
+func WriteXcconfig(xcconfig, dir string) (string, error) {
+	xcconfig = strings.TrimSpace(xcconfig)
+	if xcconfig == "" {
+		return "", nil
+	}
+	if !strings.Contains(xcconfig, "\n") && filepath.Ext(xcconfig) == ".xcconfig" {
+		if exists, err := pathutil.IsPathExists(xcconfig); err != nil {
+			return "", err
+		} else if !exists {
+			return "", fmt.Errorf("xcconfig file does not exist: %s", xcconfig)
+		}
+		return xcconfig, nil
+	}
+
+	f, err := ioutil.TempFile(dir, "auto-provision-*.xcconfig")
+	if err != nil {
+		return "", fmt.Errorf("failed to create xcconfig: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to create xcconfig: %s", err)
+	}
+	if err := writeFileAtomic(f.Name(), []byte(xcconfig+"\n")); err != nil {
+		return "", fmt.Errorf("failed to write xcconfig (%s): %s", f.Name(), err)
+	}
+	return f.Name(), nil
+}
+
+// parseXcconfig returns the build settings of the xcconfig content: the KEY = VALUE lines, including the conditional ones
+// like KEY[sdk=iphoneos*] = VALUE. The comments are dropped, the #include directives are not followed.
+func parseXcconfig(content string) serialized.Object {
+	settings := serialized.Object{}
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// The = of the conditions, like [sdk=iphoneos*], does not separate the key and the value
+		separator, depth := -1, 0
+		for i, c := range line {
+			if c == '[' {
+				depth++
+			} else if c == ']' {
+				depth--
+			} else if c == '=' && depth == 0 {
+				separator = i
+				break
+			}
+		}
+		if separator < 0 {
+			continue
+		}
+		key := strings.Join(strings.Fields(line[:separator]), "")
+		if key == "" {
+			continue
+		}
+		settings[key] = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line[separator+1:]), ";"))
+	}
+	return settings
+}
+
+// xcconfigSettings returns the build settings of the XcconfigPath, read once
+func (p *ProjectHelper) xcconfigSettings() (serialized.Object, error) {
+	if p.XcconfigPath == "" {
+		return nil, nil
+	}
+	if p.xcconfigSettingsCache == nil {
+		content, err := ioutil.ReadFile(p.XcconfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read xcconfig (%s): %s", p.XcconfigPath, err)
+		}
+		p.xcconfigSettingsCache = parseXcconfig(string(content))
+	}
+	return p.xcconfigSettingsCache, nil
+}
@@ -0,0 +1,104 @@
+package autoprovision
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteXcconfig(t *testing.T) {
+	dir := t.TempDir()
+
+	pth, err := WriteXcconfig("  \n", dir)
+	require.NoError(t, err)
+	require.Empty(t, pth)
+
+	pth, err = WriteXcconfig("PRODUCT_BUNDLE_IDENTIFIER = io.bitrise.app.beta\nDEVELOPMENT_TEAM = TEAM123", dir)
+	require.NoError(t, err)
+	require.Equal(t, dir, filepath.Dir(pth))
+	require.Equal(t, ".xcconfig", filepath.Ext(pth))
+	content, err := ioutil.ReadFile(pth)
+	require.NoError(t, err)
+	require.Equal(t, "PRODUCT_BUNDLE_IDENTIFIER = io.bitrise.app.beta\nDEVELOPMENT_TEAM = TEAM123\n", string(content))
+
+	existing, err := WriteXcconfig(pth, dir)
+	require.NoError(t, err)
+	require.Equal(t, pth, existing, "the existing xcconfig file is used as is")
+
+	_, err = WriteXcconfig(filepath.Join(dir, "missing.xcconfig"), dir)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "xcconfig file does not exist")
+}
+
+func Test_parseXcconfig(t *testing.T) {
+	content := `// Beta build
+#include "Base.xcconfig"
+PRODUCT_BUNDLE_IDENTIFIER = io.bitrise.app.beta // the beta app ID
+DEVELOPMENT_TEAM[sdk=iphoneos*] = TEAM123;
+  CODE_SIGN_STYLE=Manual
+OTHER_SWIFT_FLAGS = -D BETA=1
+OTHER_LDFLAGS = -Wl,-map=[path]
+not a setting
+`
+	require.Equal(t, serialized.Object{
+		"PRODUCT_BUNDLE_IDENTIFIER":       "io.bitrise.app.beta",
+		"DEVELOPMENT_TEAM[sdk=iphoneos*]": "TEAM123",
+		"CODE_SIGN_STYLE":                 "Manual",
+		"OTHER_SWIFT_FLAGS":               "-D BETA=1",
+		"OTHER_LDFLAGS":                   "-Wl,-map=[path]",
+	}, parseXcconfig(content))
+}
+
+func TestProjectHelper_TargetBundleID_xcconfig(t *testing.T) {
+	dir := t.TempDir()
+	xcconfigPath, err := WriteXcconfig("PRODUCT_BUNDLE_IDENTIFIER = $(BASE_BUNDLE_ID).beta", dir)
+	require.NoError(t, err)
+
+	t.Run("xcodebuild", func(t *testing.T) {
+		fakeXcodebuild(t, `echo "Build settings for action build and target App:"
+case "$*" in
+  *"-xcconfig `+xcconfigPath+` -showBuildSettings"*) echo "    PRODUCT_BUNDLE_IDENTIFIER = io.bitrise.app.beta" ;;
+  *) echo "    PRODUCT_BUNDLE_IDENTIFIER = io.bitrise.app" ;;
+esac
+`)
+
+		p := ProjectHelper{XcProj: xcodeproj.XcodeProj{Path: filepath.Join(dir, "App.xcodeproj")}}
+		bundleID, err := p.TargetBundleID("App", "Release")
+		require.NoError(t, err)
+		require.Equal(t, "io.bitrise.app", bundleID)
+
+		p = ProjectHelper{XcProj: xcodeproj.XcodeProj{Path: filepath.Join(dir, "App.xcodeproj")}, XcconfigPath: xcconfigPath}
+		bundleID, err = p.TargetBundleID("App", "Release")
+		require.NoError(t, err)
+		require.Equal(t, "io.bitrise.app.beta", bundleID)
+	})
+
+	t.Run("project file", func(t *testing.T) {
+		fakeXcodebuild(t, `echo "xcodebuild: error: Unable to read project 'App.xcodeproj'. The project 'App' cannot be opened because it is in a future Xcode project file format (77)." >&2
+exit 74
+`)
+
+		configurationList := xcodeproj.ConfigurationList{BuildConfigurations: []xcodeproj.BuildConfiguration{
+			{Name: "Release", BuildSettings: serialized.Object{"BASE_BUNDLE_ID": "io.bitrise.app", "PRODUCT_BUNDLE_IDENTIFIER": "$(BASE_BUNDLE_ID)"}},
+		}}
+		p := ProjectHelper{
+			XcProj: xcodeproj.XcodeProj{
+				Path: filepath.Join(dir, "App.xcodeproj"),
+				Proj: xcodeproj.Proj{Targets: []xcodeproj.Target{{Name: "App", BuildConfigurationList: configurationList}}},
+			},
+			XcconfigPath: xcconfigPath,
+		}
+		bundleID, err := p.TargetBundleID("App", "Release")
+		require.NoError(t, err)
+		require.Equal(t, "io.bitrise.app.beta", bundleID)
+	})
+
+	p := ProjectHelper{XcconfigPath: filepath.Join(dir, "missing.xcconfig")}
+	_, err = p.TargetBundleID("App", "Release")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to read xcconfig")
+}
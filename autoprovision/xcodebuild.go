@@ -0,0 +1,162 @@
+package autoprovision
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/xcode-project/serialized"
+)
+
+// xcodebuildPath is the xcodebuild executable, replaced in the tests
+var xcodebuildPath = "xcodebuild"
+
+// XcodebuildError is returned when an xcodebuild command fails or times out,
+// it holds the full output of the command.
+type XcodebuildError struct {
+	Args     []string
+	Stdout   string
+	Stderr   string
+	TimedOut bool
+	Timeout  time.Duration
+	Err      error
+}
+
+// Error ...
+func (e XcodebuildError) Error() string {
+	cmd := strings.Join(append([]string{xcodebuildPath}, e.Args...), " ")
+	msg := fmt.Sprintf("%s command failed: %s", cmd, e.Err)
+	if e.TimedOut {
+		msg = fmt.Sprintf("%s command timed out after %s", cmd, e.Timeout)
+	}
+	if stdout := strings.TrimSpace(e.Stdout); stdout != "" {
+		msg += "\nstdout:\n" + stdout
+	}
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += "\nstderr:\n" + stderr
+	}
+	return msg
+}
+
+// isXcodebuildAssertionCrash reports whether xcodebuild crashed with the known flaky
+// DVTAssertions failure of IDEBuildSettings, which usually succeeds when run again.
+func isXcodebuildAssertionCrash(err error) bool {
+	xcodebuildErr, ok := err.(XcodebuildError)
+	if !ok || xcodebuildErr.TimedOut {
+		return false
+	}
+	output := xcodebuildErr.Stdout + xcodebuildErr.Stderr
+	return strings.Contains(output, "DVTAssertions") && strings.Contains(output, "IDEBuildSettings")
+}
+
+// runXcodebuild runs xcodebuild with the arguments and returns its stdout,
+// the command is killed after the timeout (0 means no timeout) and retried once if it crashed with the flaky assertion failure.
+func runXcodebuild(timeout time.Duration, args ...string) (string, error) {
+	out, err := runXcodebuildOnce(timeout, args...)
+	if err != nil && isXcodebuildAssertionCrash(err) {
+		log.Warnf("xcodebuild crashed with an internal assertion failure, retrying...")
+		out, err = runXcodebuildOnce(timeout, args...)
+	}
+	return out, err
+}
+
+func runXcodebuildOnce(timeout time.Duration, args ...string) (string, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, xcodebuildPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	log.Debugf("$ %s %s", xcodebuildPath, strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return "", XcodebuildError{
+			Args:     args,
+			Stdout:   stdout.String(),
+			Stderr:   stderr.String(),
+			TimedOut: ctx.Err() == context.DeadlineExceeded,
+			Timeout:  timeout,
+			Err:      err,
+		}
+	}
+	return stdout.String(), nil
+}
+
+// XcodeVersion is the version of the installed Xcode
+type XcodeVersion struct {
+	Version      string
+	BuildVersion string
+	MajorVersion int64
+}
+
+// GetXcodeVersion returns the version of the installed Xcode, reported by `xcodebuild -version`,
+// the command is killed after the timeout (0 means no timeout).
+func GetXcodeVersion(timeout time.Duration) (XcodeVersion, error) {
+	out, err := runXcodebuild(timeout, "-version")
+	if err != nil {
+		return XcodeVersion{}, err
+	}
+	return parseXcodeVersionOutput(out)
+}
+
+func parseXcodeVersionOutput(out string) (XcodeVersion, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	split := strings.Fields(lines[0])
+	if len(split) != 2 || split[0] != "Xcode" {
+		return XcodeVersion{}, fmt.Errorf("failed to parse xcodebuild version output (%s)", out)
+	}
+
+	majorVersion, err := strconv.ParseInt(strings.Split(split[1], ".")[0], 10, 32)
+	if err != nil {
+		return XcodeVersion{}, fmt.Errorf("failed to parse xcodebuild version output (%s): %s", out, err)
+	}
+
+	version := XcodeVersion{
+		Version:      strings.TrimSpace(lines[0]),
+		MajorVersion: majorVersion,
+	}
+	if len(lines) > 1 {
+		version.BuildVersion = strings.TrimSpace(lines[1])
+	}
+	return version, nil
+}
+
+// showProjectBuildSettings returns the build settings of the project's target in the configuration,
+// reported by `xcodebuild -showBuildSettings`, with the settings of the xcconfig (if not empty) overriding the project's build settings.
+func showProjectBuildSettings(timeout time.Duration, project, target, configuration, xcconfigPath string) (serialized.Object, error) {
+	args := []string{"-project", project, "-target", target, "-configuration", configuration}
+	if xcconfigPath != "" {
+		args = append(args, "-xcconfig", xcconfigPath)
+	}
+	out, err := runXcodebuild(timeout, append(args, "-showBuildSettings")...)
+	if err != nil {
+		return nil, err
+	}
+	return parseShowBuildSettingsOutput(out), nil
+}
+
+func parseShowBuildSettingsOutput(out string) serialized.Object {
+	settings := serialized.Object{}
+	for _, line := range strings.Split(out, "\n") {
+		split := strings.Split(line, " = ")
+		if len(split) < 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(split[0])
+		if key == "" {
+			continue
+		}
+		settings[key] = strings.TrimSpace(strings.Join(split[1:], " = "))
+	}
+	return settings
+}
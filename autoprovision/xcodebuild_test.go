@@ -0,0 +1,110 @@
+package autoprovision
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeXcodebuild replaces the xcodebuild executable with the shell script for the test
+func fakeXcodebuild(t *testing.T, script string) string {
+	dir := t.TempDir()
+	pth := filepath.Join(dir, "xcodebuild")
+	require.NoError(t, ioutil.WriteFile(pth, []byte("#!/bin/sh\n"+script), 0755))
+
+	original := xcodebuildPath
+	xcodebuildPath = pth
+	t.Cleanup(func() {
+		xcodebuildPath = original
+	})
+	return dir
+}
+
+func Test_showProjectBuildSettings(t *testing.T) {
+	fakeXcodebuild(t, `echo "Build settings for action build and target App:"
+echo "    PRODUCT_BUNDLE_IDENTIFIER = io.bitrise.app"
+echo "    OTHER_SWIFT_FLAGS = -D A = B"
+`)
+
+	settings, err := showProjectBuildSettings(time.Minute, "App.xcodeproj", "App", "Release", "")
+	require.NoError(t, err)
+	require.Equal(t, "io.bitrise.app", settings["PRODUCT_BUNDLE_IDENTIFIER"])
+	require.Equal(t, "-D A = B", settings["OTHER_SWIFT_FLAGS"])
+}
+
+func Test_runXcodebuild_capturesOutput(t *testing.T) {
+	fakeXcodebuild(t, `echo "some output"
+echo "xcodebuild: error: 'App.xcodeproj' does not exist." >&2
+exit 66
+`)
+
+	_, err := runXcodebuild(time.Minute, "-list")
+	require.Error(t, err)
+	xcodebuildErr, ok := err.(XcodebuildError)
+	require.True(t, ok)
+	require.False(t, xcodebuildErr.TimedOut)
+	require.Contains(t, err.Error(), "-list command failed")
+	require.Contains(t, err.Error(), "stdout:\nsome output")
+	require.Contains(t, err.Error(), "stderr:\nxcodebuild: error: 'App.xcodeproj' does not exist.")
+}
+
+func Test_runXcodebuild_timeout(t *testing.T) {
+	fakeXcodebuild(t, "exec sleep 10\n")
+
+	_, err := runXcodebuild(100*time.Millisecond, "-list")
+	require.Error(t, err)
+	require.True(t, err.(XcodebuildError).TimedOut)
+	require.Contains(t, err.Error(), "timed out after 100ms")
+}
+
+func Test_runXcodebuild_retriesAssertionCrash(t *testing.T) {
+	dir := fakeXcodebuild(t, `if [ ! -f "$(dirname "$0")/crashed" ]; then
+  touch "$(dirname "$0")/crashed"
+  echo "DVTAssertions: ASSERTION FAILURE in IDEBuildSettings/IDEBuildSettingsTable.m" >&2
+  exit 134
+fi
+echo "    SDKROOT = iphoneos"
+`)
+
+	out, err := runXcodebuild(time.Minute, "-showBuildSettings")
+	require.NoError(t, err)
+	require.Contains(t, out, "SDKROOT = iphoneos")
+	require.FileExists(t, filepath.Join(dir, "crashed"))
+}
+
+func Test_runXcodebuild_retriesOnce(t *testing.T) {
+	dir := fakeXcodebuild(t, `echo run >> "$(dirname "$0")/runs"
+echo "DVTAssertions: ASSERTION FAILURE in IDEBuildSettings" >&2
+exit 134
+`)
+
+	_, err := runXcodebuild(time.Minute, "-showBuildSettings")
+	require.Error(t, err)
+	runs, readErr := ioutil.ReadFile(filepath.Join(dir, "runs"))
+	require.NoError(t, readErr)
+	require.Equal(t, "run\nrun\n", string(runs))
+}
+
+func TestGetXcodeVersion(t *testing.T) {
+	fakeXcodebuild(t, `echo "Xcode 15.2"
+echo "Build version 15C500b"
+`)
+
+	version, err := GetXcodeVersion(time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, XcodeVersion{Version: "Xcode 15.2", BuildVersion: "Build version 15C500b", MajorVersion: 15}, version)
+
+	_, err = parseXcodeVersionOutput("xcode-select: error: tool 'xcodebuild' requires Xcode")
+	require.Error(t, err)
+}
+
+func TestGetXcodeVersion_timeout(t *testing.T) {
+	fakeXcodebuild(t, "exec sleep 10\n")
+
+	_, err := GetXcodeVersion(100 * time.Millisecond)
+	require.Error(t, err)
+	require.True(t, err.(XcodebuildError).TimedOut)
+}
@@ -16,8 +16,11 @@ type CertificateFileURL struct {
 
 // Config holds the step inputs
 type Config struct {
-	BuildAPIToken string `env:"build_api_token,required"`
-	BuildURL      string `env:"build_url,required"`
+	// BuildAPIToken, BuildURL and CertificateURLList are required, unless the offline assets are used, see ValidateOnlineInputs
+	BuildAPIToken string `env:"build_api_token"`
+	BuildURL      string `env:"build_url"`
+	AppSlug       string `env:"app_slug"`
+	BuildNumber   string `env:"build_number"`
 
 	ProjectPath   string `env:"project_path,dir"`
 	Scheme        string `env:"scheme,required"`
@@ -25,20 +28,333 @@ type Config struct {
 
 	Distribution        string `env:"distribution_type,opt[development,app-store,ad-hoc,enterprise]"`
 	MinProfileDaysValid int    `env:"min_profile_days_valid"`
+	TeamID              string `env:"team_id"`
+	XcodebuildTimeout   int    `env:"xcodebuild_timeout"`
+	ProfileConcurrency  int    `env:"profile_concurrency"`
 
-	CertificateURLList        string          `env:"certificate_urls,required"`
-	CertificatePassphraseList stepconf.Secret `env:"passphrases"`
-	KeychainPath              string          `env:"keychain_path,required"`
-	KeychainPassword          stepconf.Secret `env:"keychain_password,required"`
+	CertificateSelection string          `env:"certificate_selection"`
+	MaxPortalChanges     int             `env:"max_portal_changes"`
+	PolicyWebhookURL     string          `env:"policy_webhook_url"`
+	PolicyWebhookToken   stepconf.Secret `env:"policy_webhook_token"`
+	PolicyFile           string          `env:"policy_file"`
+	ClearPinnedProfiles  bool            `env:"clear_pinned_profiles,opt[no,yes]"`
+	ProfileQuotaLimit    int             `env:"profile_quota_limit"`
+	ProfileCleanup       bool            `env:"profile_cleanup,opt[no,yes]"`
+	ProfileNameCollision string          `env:"profile_name_collision"`
+	Strictness           string          `env:"strictness,opt[strict,lenient]"`
+	Manage               string          `env:"manage"`
+	SigningMode          string          `env:"signing_mode,opt[profiles,adhoc]"`
+	AdhocXcconfigPath    string          `env:"adhoc_xcconfig_path"`
+	SigningHistoryURL    string          `env:"signing_history_url"`
+	SigningHistoryToken  stepconf.Secret `env:"signing_history_token"`
+
+	ConfigurationFallback        bool   `env:"configuration_fallback,opt[no,yes]"`
+	SkipUnresolvableTargets      bool   `env:"skip_unresolvable_targets,opt[no,yes]"`
+	ExcludeTargetTypes           string `env:"exclude_target_types"`
+	ReconcileCapabilities        bool   `env:"reconcile_capabilities,opt[no,yes]"`
+	RotationDrill                bool   `env:"rotation_drill,opt[no,yes]"`
+	RotationDrillP12Path         string `env:"rotation_drill_p12_path"`
+	RotationDrillKeyType         string `env:"rotation_drill_key_type,opt[rsa-2048,ec-p256]"`
+	RotationDrillCSRCommonName   string `env:"rotation_drill_csr_common_name"`
+	RotationDrillCSREmail        string `env:"rotation_drill_csr_email"`
+	RotationDrillCSROrganization string `env:"rotation_drill_csr_organization"`
+	RotationDrillCSRPath         string `env:"rotation_drill_csr_path"`
+	DryRun                       bool   `env:"dry_run,opt[no,yes]"`
+	ExportOptionsPlistPath       string `env:"export_options_plist_path"`
+	SessionPath                  string `env:"session_path"`
+	DeviceSnapshotDir            string `env:"device_snapshot_dir"`
+	DeviceSnapshotTTLHours       int    `env:"device_snapshot_ttl_hours"`
+	CacheDir                     string `env:"cache_dir"`
+	CacheTTLHours                int    `env:"cache_ttl_hours"`
+	OfflineAssetsDir             string `env:"offline_assets_dir"`
+	DerivedSourcesDir            string `env:"derived_sources_dir"`
+	XcconfigContent              string `env:"xcconfig_content"`
+	AuditLogPath                 string `env:"audit_log_path"`
+	IdentityReportPath           string `env:"signing_identity_report_path"`
+	CapabilityMatrixPath         string `env:"capability_matrix_path"`
+	OutputFormat                 string `env:"output_format,opt[envman,github-actions,dotenv]"`
+	DotenvPath                   string `env:"dotenv_path"`
+	CapabilityGapReportURL       string `env:"capability_gap_report_url"`
+	PostRunHooks                 string `env:"post_run_hooks"`
+
+	CertificateURLList         string          `env:"certificate_urls"`
+	CertificatePassphraseList  stepconf.Secret `env:"passphrases"`
+	RotationDrillP12Passphrase stepconf.Secret `env:"rotation_drill_p12_passphrase"`
+	KeychainPath               string          `env:"keychain_path,required"`
+	KeychainPassword           stepconf.Secret `env:"keychain_password,required"`
+	UseExistingKeychain        bool            `env:"use_existing_keychain,opt[no,yes]"`
+
+	ProvisioningServerURL   string          `env:"provisioning_server_url"`
+	ProvisioningServerToken stepconf.Secret `env:"provisioning_server_token"`
+
+	APIKeyID                  string `env:"api_key_id"`
+	APIIssuerID               string `env:"api_issuer_id"`
+	APIPrivateKeyKeychainItem string `env:"api_private_key_keychain_item"`
+	APIKeySignerCommand       string `env:"api_key_signer_command"`
+	EnterpriseAccount         bool   `env:"enterprise_account,opt[no,yes]"`
+
+	SecondaryAPIKeyID      string          `env:"secondary_api_key_id"`
+	SecondaryAPIIssuerID   string          `env:"secondary_api_issuer_id"`
+	SecondaryAPIPrivateKey stepconf.Secret `env:"secondary_api_private_key"`
+
+	APIRetryMaxAttempts int `env:"api_retry_max_attempts"`
+	APIRetryWaitSeconds int `env:"api_retry_wait_seconds"`
+
+	VerboseLog  bool `env:"verbose_log,opt[no,yes]"`
+	KeepTempDir bool `env:"keep_temp_dir,opt[no,yes]"`
+	Interactive bool `env:"interactive,opt[no,yes]"`
+}
+
+// ServerConfig holds the inputs of the provisioning server mode
+type ServerConfig struct {
+	Address    string          `env:"PROVISIONING_SERVER_ADDRESS,required"`
+	Token      stepconf.Secret `env:"PROVISIONING_SERVER_TOKEN,required"`
+	KeyID      string          `env:"APPSTORECONNECT_API_KEY_ID,required"`
+	IssuerID   string          `env:"APPSTORECONNECT_API_ISSUER_ID,required"`
+	PrivateKey stepconf.Secret `env:"APPSTORECONNECT_API_PRIVATE_KEY,required"`
+
+	TLSCertFile string `env:"PROVISIONING_SERVER_TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"PROVISIONING_SERVER_TLS_KEY_FILE"`
+}
+
+// ExplainConfig holds the inputs of the signing analysis mode
+type ExplainConfig struct {
+	ProjectPath   string `env:"project_path,dir"`
+	Scheme        string `env:"scheme,required"`
+	Configuration string `env:"configuration"`
+	Distribution  string `env:"distribution_type,opt[development,app-store,ad-hoc,enterprise]"`
+
+	ConfigurationFallback bool   `env:"configuration_fallback,opt[no,yes]"`
+	ExcludeTargetTypes    string `env:"exclude_target_types"`
+	XcodebuildTimeout     int    `env:"xcodebuild_timeout"`
+	VerboseLog            bool   `env:"verbose_log,opt[no,yes]"`
+
+	DerivedSourcesDir string `env:"derived_sources_dir"`
+	XcconfigContent   string `env:"xcconfig_content"`
+}
+
+// MigrateConfig holds the inputs of the bundle ID migration mode
+type MigrateConfig struct {
+	// Migrations are newline separated, with layout: [<target>:] <old bundle ID>=<new bundle ID>
+	Migrations    string `env:"BUNDLE_ID_MIGRATIONS,required"`
+	Distributions string `env:"MIGRATION_DISTRIBUTION_TYPES"`
+
+	KeyID      string          `env:"APPSTORECONNECT_API_KEY_ID"`
+	IssuerID   string          `env:"APPSTORECONNECT_API_ISSUER_ID"`
+	PrivateKey stepconf.Secret `env:"APPSTORECONNECT_API_PRIVATE_KEY"`
+
+	ProvisioningServerURL   string          `env:"provisioning_server_url"`
+	ProvisioningServerToken stepconf.Secret `env:"provisioning_server_token"`
 
 	VerboseLog bool `env:"verbose_log,opt[no,yes]"`
 }
 
+// DistributionTypes returns the distribution types to generate profiles for, development and app-store by default
+func (c MigrateConfig) DistributionTypes() ([]autoprovision.DistributionType, error) {
+	if c.Distributions == "" {
+		return []autoprovision.DistributionType{autoprovision.Development, autoprovision.AppStore}, nil
+	}
+
+	var distributionTypes []autoprovision.DistributionType
+	for _, distribution := range strings.Split(c.Distributions, ",") {
+		distributionType := autoprovision.DistributionType(strings.TrimSpace(distribution))
+		if err := autoprovision.ValidateDistributionType(distributionType); err != nil {
+			return nil, err
+		}
+		distributionTypes = append(distributionTypes, distributionType)
+	}
+	return distributionTypes, nil
+}
+
 // DistributionType ...
 func (c Config) DistributionType() autoprovision.DistributionType {
 	return autoprovision.DistributionType(c.Distribution)
 }
 
+// DistributionType ...
+func (c ExplainConfig) DistributionType() autoprovision.DistributionType {
+	if c.Distribution == "" {
+		return autoprovision.Development
+	}
+	return autoprovision.DistributionType(c.Distribution)
+}
+
+// CertificateSelectionStrategy ...
+func (c Config) CertificateSelectionStrategy() autoprovision.CertificateSelection {
+	if c.CertificateSelection == "" {
+		return autoprovision.NewestCertificate
+	}
+	return autoprovision.CertificateSelection(c.CertificateSelection)
+}
+
+// ProfileNameCollisionPolicy ...
+func (c Config) ProfileNameCollisionPolicy() autoprovision.ProfileNameCollisionPolicy {
+	if c.ProfileNameCollision == "" {
+		return autoprovision.DeleteCollidingProfile
+	}
+	return autoprovision.ProfileNameCollisionPolicy(c.ProfileNameCollision)
+}
+
+// PortalChangePolicy returns the policies, the Developer Portal changes are checked against, nil if none is configured
+func (c Config) PortalChangePolicy() autoprovision.PortalChangePolicy {
+	var policies autoprovision.Policies
+	if c.PolicyWebhookURL != "" {
+		policies = append(policies, autoprovision.NewWebhookPolicy(c.PolicyWebhookURL, string(c.PolicyWebhookToken)))
+	}
+	if c.PolicyFile != "" {
+		policies = append(policies, autoprovision.NewOPAPolicy(c.PolicyFile))
+	}
+
+	if len(policies) == 0 {
+		return nil
+	}
+	return policies
+}
+
+// ManagedResources returns the resource kinds the Step manages, set by the pipe (|) separated manage input
+func (c Config) ManagedResources() (autoprovision.ManagedResources, error) {
+	managed, err := autoprovision.ParseManagedResources(splitAndClean(c.Manage, "|", true))
+	if err != nil {
+		return nil, fmt.Errorf("invalid manage input: %s", err)
+	}
+	if c.RotationDrill && !managed[autoprovision.ManageCertificates] {
+		return nil, fmt.Errorf("rotation_drill input requires the Step to manage the certificates (manage input: %s)", managed)
+	}
+	return managed, nil
+}
+
+// ExternalAPIKey returns true if the App Store Connect API private key is not provided by the connected account,
+// but stored in the keychain or held by an external signer.
+func (c Config) ExternalAPIKey() bool {
+	return c.APIPrivateKeyKeychainItem != "" || c.APIKeySignerCommand != ""
+}
+
+// ValidateAPIKey validates that a single external App Store Connect API private key source is set
+func (c Config) ValidateAPIKey() error {
+	if c.APIPrivateKeyKeychainItem != "" && c.APIKeySignerCommand != "" {
+		return fmt.Errorf("both API private key keychain item and signer command are provided, set only one of them")
+	}
+	return nil
+}
+
+// EnterpriseAPI reports whether the API key belongs to an Apple Developer Enterprise Program account,
+// these keys only work with the Enterprise Program API. The enterprise distribution type is only available for these accounts.
+func (c Config) EnterpriseAPI() bool {
+	return c.EnterpriseAccount || c.DistributionType() == autoprovision.Enterprise
+}
+
+// ValidateEnterpriseAccount validates that the distribution type is available for the Enterprise Program accounts
+func (c Config) ValidateEnterpriseAccount() error {
+	if c.EnterpriseAccount && c.DistributionType() == autoprovision.AppStore {
+		return fmt.Errorf("app-store distribution type is not available for Apple Developer Enterprise Program accounts (enterprise_account input), use the enterprise distribution type")
+	}
+	return nil
+}
+
+// ValidateSecondaryAPIKey validates that the secondary App Store Connect API key is either fully set or not set at all
+func (c Config) ValidateSecondaryAPIKey() error {
+	if (c.SecondaryAPIKeyID == "") != (c.SecondaryAPIPrivateKey == "") {
+		return fmt.Errorf("both secondary API key ID and secondary API private key have to be provided")
+	}
+	if c.SecondaryAPIKeyID != "" && c.ProvisioningServerURL != "" {
+		return fmt.Errorf("secondary API key can not be used with a provisioning server, the server holds the API key")
+	}
+	return nil
+}
+
+// ValidateAPIRetry validates the retry policy of the App Store Connect API requests
+func (c Config) ValidateAPIRetry() error {
+	if c.APIRetryMaxAttempts < 0 {
+		return fmt.Errorf("invalid API retry max attempts (%d), set a positive number", c.APIRetryMaxAttempts)
+	}
+	if c.APIRetryWaitSeconds < 0 {
+		return fmt.Errorf("invalid API retry wait (%d seconds), set zero or a positive number", c.APIRetryWaitSeconds)
+	}
+	return nil
+}
+
+// ValidateCacheTTL validates the reuse period of the build cache entries
+func (c Config) ValidateCacheTTL() error {
+	if c.CacheTTLHours < 0 {
+		return fmt.Errorf("invalid build cache TTL (%d hours), set zero or a positive number", c.CacheTTLHours)
+	}
+	return nil
+}
+
+// ValidateProfileConcurrency validates the number of bundle IDs, the profiles of are ensured in parallel
+func (c Config) ValidateProfileConcurrency() error {
+	if c.ProfileConcurrency < 0 {
+		return fmt.Errorf("invalid profile concurrency (%d), set zero or a positive number", c.ProfileConcurrency)
+	}
+	return nil
+}
+
+// ValidateMaxPortalChanges validates the maximum number of Developer Portal changes per run, 0 means no limit
+func (c Config) ValidateMaxPortalChanges() error {
+	if c.MaxPortalChanges < 0 {
+		return fmt.Errorf("invalid max portal changes (%d), set zero (no limit) or a positive number", c.MaxPortalChanges)
+	}
+	return nil
+}
+
+// Offline reports whether the pre-downloaded profiles and certificates of the offline assets directory are used,
+// instead of the Developer Portal.
+func (c Config) Offline() bool {
+	return c.OfflineAssetsDir != ""
+}
+
+// AdhocSigning reports whether the builds are ad-hoc signed without provisioning profiles (signing_mode: adhoc),
+// for the simulator and unit test builds.
+func (c Config) AdhocSigning() bool {
+	return c.SigningMode == "adhoc"
+}
+
+// ValidateOnlineInputs validates that the inputs required to reach the Developer Portal are set, unless the Step runs offline
+// or signs ad-hoc.
+func (c Config) ValidateOnlineInputs() error {
+	if c.AdhocSigning() {
+		return nil
+	}
+	if c.Offline() {
+		if c.RotationDrill {
+			return fmt.Errorf("rotation_drill input can not be used with offline_assets_dir, the drill creates a certificate on the Developer Portal")
+		}
+		if c.DryRun {
+			return fmt.Errorf("dry_run input can not be used with offline_assets_dir, the offline mode makes no Developer Portal changes to plan")
+		}
+		return nil
+	}
+	inputs := []struct{ key, value string }{
+		{"build_api_token", c.BuildAPIToken},
+		{"build_url", c.BuildURL},
+		{"certificate_urls", c.CertificateURLList},
+	}
+	for _, input := range inputs {
+		if input.value == "" {
+			return fmt.Errorf("%s input is required, unless offline_assets_dir is set", input.key)
+		}
+	}
+	if c.RotationDrill && (c.RotationDrillP12Path == "" || c.RotationDrillP12Passphrase == "") {
+		return fmt.Errorf("rotation_drill input requires the rotation_drill_p12_path and rotation_drill_p12_passphrase inputs, the new certificate's private key is exported with them")
+	}
+	return nil
+}
+
+// RotationDrillCSROptions returns the subject fields and the key type of the rotation drill certificate's signing request
+func (c Config) RotationDrillCSROptions() autoprovision.CertificateRequestOptions {
+	return autoprovision.CertificateRequestOptions{
+		CommonName:   c.RotationDrillCSRCommonName,
+		EmailAddress: c.RotationDrillCSREmail,
+		Organization: c.RotationDrillCSROrganization,
+		KeyType:      autoprovision.CertificateKeyType(c.RotationDrillKeyType),
+	}
+}
+
+// OfflinePassphrases returns the passphrases, the certificates of the offline assets directory are opened with
+func (c Config) OfflinePassphrases() []string {
+	return append(splitAndClean(string(c.CertificatePassphraseList), "|", true), "")
+}
+
 // ValidateCertificates validates if the number of certificate URLs matches those of passphrases
 func (c Config) ValidateCertificates() ([]string, []string, error) {
 	pfxURLs := splitAndClean(c.CertificateURLList, "|", true)
@@ -49,3 +49,100 @@ func TestConfig_ValidateCertificates(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_ValidateOnlineInputs(t *testing.T) {
+	online := Config{BuildAPIToken: "token", BuildURL: "https://app.bitrise.io/build/1", CertificateURLList: "file://cert.p12"}
+	if err := online.ValidateOnlineInputs(); err != nil {
+		t.Errorf("ValidateOnlineInputs() error = %v", err)
+	}
+
+	if err := (Config{BuildURL: "https://app.bitrise.io/build/1"}).ValidateOnlineInputs(); err == nil {
+		t.Errorf("ValidateOnlineInputs() expected error for missing build API token and certificate URLs")
+	}
+
+	if err := (Config{OfflineAssetsDir: "./assets"}).ValidateOnlineInputs(); err != nil {
+		t.Errorf("ValidateOnlineInputs() error = %v, the online inputs are not required offline", err)
+	}
+
+	if err := (Config{SigningMode: "adhoc"}).ValidateOnlineInputs(); err != nil {
+		t.Errorf("ValidateOnlineInputs() error = %v, the online inputs are not required for ad-hoc signing", err)
+	}
+
+	if err := (Config{OfflineAssetsDir: "./assets", RotationDrill: true}).ValidateOnlineInputs(); err == nil {
+		t.Errorf("ValidateOnlineInputs() expected error for rotation drill in offline mode")
+	}
+	if err := (Config{OfflineAssetsDir: "./assets", DryRun: true}).ValidateOnlineInputs(); err == nil {
+		t.Errorf("ValidateOnlineInputs() expected error for dry run in offline mode")
+	}
+
+	drill := Config{BuildAPIToken: "token", BuildURL: "https://app.bitrise.io/build/1", CertificateURLList: "file://cert.p12", RotationDrill: true}
+	if err := drill.ValidateOnlineInputs(); err == nil {
+		t.Errorf("ValidateOnlineInputs() expected error for rotation drill without p12 export passphrase")
+	}
+	drill.RotationDrillP12Path, drill.RotationDrillP12Passphrase = "./drill.p12", "passphrase"
+	if err := drill.ValidateOnlineInputs(); err != nil {
+		t.Errorf("ValidateOnlineInputs() error = %v", err)
+	}
+}
+
+func TestConfig_ValidateMaxPortalChanges(t *testing.T) {
+	for _, max := range []int{0, 1, 20} {
+		if err := (Config{MaxPortalChanges: max}).ValidateMaxPortalChanges(); err != nil {
+			t.Errorf("ValidateMaxPortalChanges() error = %v for %d", err, max)
+		}
+	}
+	if err := (Config{MaxPortalChanges: -1}).ValidateMaxPortalChanges(); err == nil {
+		t.Errorf("ValidateMaxPortalChanges() expected error for -1, it would disable the limit")
+	}
+}
+
+func TestConfig_ManagedResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    string
+		wantErr bool
+	}{
+		{name: "default", config: Config{}, want: "certificates, devices, profiles"},
+		{name: "all", config: Config{Manage: "all"}, want: "certificates, devices, profiles"},
+		{name: "profiles only", config: Config{Manage: "profiles"}, want: "profiles"},
+		{name: "profiles and certificates", config: Config{Manage: " profiles | certificates "}, want: "certificates, profiles"},
+		{name: "unknown resource", config: Config{Manage: "profiles|app-ids"}, wantErr: true},
+		{name: "rotation drill without certificates", config: Config{Manage: "profiles", RotationDrill: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.ManagedResources()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ManagedResources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("ManagedResources() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_EnterpriseAPI(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    bool
+		wantErr bool
+	}{
+		{name: "app store account", config: Config{Distribution: "development"}},
+		{name: "enterprise distribution", config: Config{Distribution: "enterprise"}, want: true},
+		{name: "development with enterprise account", config: Config{Distribution: "development", EnterpriseAccount: true}, want: true},
+		{name: "app-store with enterprise account", config: Config{Distribution: "app-store", EnterpriseAccount: true}, want: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.EnterpriseAPI(); got != tt.want {
+				t.Errorf("EnterpriseAPI() = %v, want %v", got, tt.want)
+			}
+			if err := tt.config.ValidateEnterpriseAccount(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateEnterpriseAccount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -21,6 +21,10 @@ type Downloader struct {
 	BuildAPIToken string
 	BuildURL      string
 
+	// SkipAPIKeyValidation allows missing App Store Connect API key data,
+	// for example when the API requests are authorized by a provisioning server.
+	SkipAPIKeyValidation bool
+
 	ReadBytesFromFile func(pth string) ([]byte, error)
 	DownloadContent   func(url string, buildAPIToken string) ([]byte, error)
 }
@@ -38,9 +42,21 @@ func NewDownloader(buildURL string, buildAPIToken string) *Downloader {
 func (c Downloader) parseDevPortalData(data []byte) (*DevPortalData, error) {
 	var devPortalData DevPortalData
 	if err := json.Unmarshal(data, &devPortalData); err != nil {
-		return nil, err
+		if description := describePayload(data); description != "" {
+			return nil, ConnectionError{Reason: description}
+		}
+		return nil, ConnectionError{Reason: fmt.Sprintf("unexpected payload format: %s", err)}
 	}
 
+	if c.SkipAPIKeyValidation {
+		return &devPortalData, nil
+	}
+
+	if devPortalData.IssuerID == "" && devPortalData.KeyID == "" && devPortalData.PrivateKey == "" {
+		if description := describePayload(data); description != "" {
+			return nil, ConnectionError{Reason: description}
+		}
+	}
 	if devPortalData.IssuerID == "" {
 		return nil, errors.New("invalid App Store Connect API authentication data: missing issuer_id")
 	}
@@ -0,0 +1,85 @@
+package devportaldata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// connectionExpiryWarningPeriod is the period before the expiry of the connection, the Step warns about the upcoming expiry in
+const connectionExpiryWarningPeriod = 7 * 24 * time.Hour
+
+// regenerateConnectionGuidance explains how to fix a malformed Apple service connection
+const regenerateConnectionGuidance = "Regenerate the Apple service connection: remove and add the App Store Connect API key again " +
+	"in the Bitrise Workspace settings (Integrations), then select it for the app in the App settings (Team > Apple service connection)."
+
+// ConnectionError is returned when the Apple service connection payload of the build is malformed, expired or belongs to another team
+type ConnectionError struct {
+	Reason string
+}
+
+// Error ...
+func (e ConnectionError) Error() string {
+	return fmt.Sprintf("invalid Apple service connection: %s. %s", e.Reason, regenerateConnectionGuidance)
+}
+
+// describePayload returns a short description of a payload, which is not a Developer Portal data JSON object
+func describePayload(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return "the payload is empty"
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return "the payload is a HTML page instead of JSON, check the build URL and the build API token"
+	case !bytes.HasPrefix(trimmed, []byte("{")):
+		return "the payload is not a JSON object"
+	}
+
+	var errorResponse struct {
+		Message  string `json:"message"`
+		ErrorMsg string `json:"error_msg"`
+	}
+	if err := json.Unmarshal(trimmed, &errorResponse); err == nil {
+		if errorResponse.ErrorMsg != "" {
+			return fmt.Sprintf("the payload is an error response: %s", errorResponse.ErrorMsg)
+		}
+		if errorResponse.Message != "" {
+			return fmt.Sprintf("the payload is an error response: %s", errorResponse.Message)
+		}
+	}
+	return ""
+}
+
+// Validate checks the App Store Connect API key of the connection without calling the API:
+// the format of the key ID and issuer ID, the private key, the expiry and the team of the connection.
+// teamID is the team the Step signs for, it is not checked if empty.
+// It returns the warnings about the upcoming expiry of the connection.
+func (d DevPortalData) Validate(teamID string, now time.Time) ([]string, error) {
+	if err := appstoreconnect.ValidateAPIKeyIdentifiers(d.KeyID, d.IssuerID); err != nil {
+		return nil, ConnectionError{Reason: err.Error()}
+	}
+
+	if _, err := appstoreconnect.ParsePrivateKey([]byte(d.PrivateKeyWithHeader())); err != nil {
+		return nil, ConnectionError{Reason: fmt.Sprintf("the private key of the API key (%s) is malformed: %s", d.KeyID, err)}
+	}
+
+	var warnings []string
+	if d.ExpiresAt != nil {
+		if !d.ExpiresAt.After(now) {
+			return nil, ConnectionError{Reason: fmt.Sprintf("the connection expired at %s", d.ExpiresAt.Format(time.RFC3339))}
+		}
+		if d.ExpiresAt.Sub(now) < connectionExpiryWarningPeriod {
+			warnings = append(warnings, fmt.Sprintf("The Apple service connection expires at %s. %s", d.ExpiresAt.Format(time.RFC3339), regenerateConnectionGuidance))
+		}
+	}
+
+	if teamID != "" && d.TeamID != "" && !strings.EqualFold(teamID, d.TeamID) {
+		return nil, ConnectionError{Reason: fmt.Sprintf("the connection belongs to the team (%s), but the Step signs for the team (%s)", d.TeamID, teamID)}
+	}
+
+	return warnings, nil
+}
@@ -168,3 +168,27 @@ func TestGetDevPortalDataEmptyResponseFields(t *testing.T) {
 	assert.Error(t, err, "error should not be nil")
 	assert.Nil(t, data)
 }
+
+func TestGetDevPortalDataSkipAPIKeyValidation(t *testing.T) {
+	// Arrange
+	testToken := "testToken"
+	testURL := "https:///test"
+	mockIOUtils := new(MockIOUtils)
+	mockIOUtils.On("DownloadContent", mock.Anything, testToken).Return([]byte(`{"test_devices":[{"device_identifier":"udid"}]}`), nil)
+
+	testSubject := devportaldata.Downloader{
+		BuildAPIToken:        testToken,
+		BuildURL:             testURL,
+		SkipAPIKeyValidation: true,
+		DownloadContent:      mockIOUtils.DownloadContent,
+		ReadBytesFromFile:    mockIOUtils.ReadBytesFromFile,
+	}
+
+	// Act
+	data, err := testSubject.GetDevPortalData()
+
+	// Assert
+	assert.NoError(t, err, "error should be nil")
+	assert.NotNil(t, data)
+	assert.Equal(t, 1, len(data.TestDevices))
+}
@@ -0,0 +1,92 @@
+package devportaldata_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/devportaldata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevPortalDataValidate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	privateKey := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	expired, expiring, valid := now.Add(-time.Hour), now.Add(24*time.Hour), now.AddDate(1, 0, 0)
+	connection := func(modify func(*devportaldata.DevPortalData)) devportaldata.DevPortalData {
+		data := devportaldata.DevPortalData{KeyID: "4RUVJ4SC38", IssuerID: "69a6de7b-7325-47e3-e053-5b8c7c11a4d1", PrivateKey: privateKey, TeamID: "72SA8V3WYL"}
+		if modify != nil {
+			modify(&data)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name         string
+		data         devportaldata.DevPortalData
+		teamID       string
+		wantWarnings int
+		wantErr      string
+	}{
+		{name: "valid", data: connection(nil), teamID: "72SA8V3WYL"},
+		{name: "valid without team", data: connection(func(d *devportaldata.DevPortalData) { d.ExpiresAt = &valid }), teamID: ""},
+		{name: "malformed issuer ID", data: connection(func(d *devportaldata.DevPortalData) { d.IssuerID = "72SA8V3WYL" }), wantErr: "issuer ID (72SA8V3WYL)"},
+		{name: "malformed private key", data: connection(func(d *devportaldata.DevPortalData) { d.PrivateKey = "key" }), wantErr: "private key of the API key (4RUVJ4SC38) is malformed"},
+		{name: "expired", data: connection(func(d *devportaldata.DevPortalData) { d.ExpiresAt = &expired }), wantErr: "connection expired at 2020-12-31T23:00:00Z"},
+		{name: "expiring", data: connection(func(d *devportaldata.DevPortalData) { d.ExpiresAt = &expiring }), wantWarnings: 1},
+		{name: "other team", data: connection(nil), teamID: "1MZX23ABCD", wantErr: "belongs to the team (72SA8V3WYL)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := tt.data.Validate(tt.teamID, now)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				assert.Contains(t, err.Error(), "Regenerate the Apple service connection")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantWarnings, len(warnings))
+		})
+	}
+}
+
+func TestGetDevPortalDataMalformedPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{name: "HTML page", payload: "<html><title>Sign in</title></html>", wantErr: "HTML page"},
+		{name: "error response", payload: `{"error_msg":"Not Found"}`, wantErr: "error response: Not Found"},
+		{name: "unexpected types", payload: `{"key_id":4,"test_devices":{}}`, wantErr: "unexpected payload format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockIOUtils := new(MockIOUtils)
+			mockIOUtils.On("DownloadContent", mock.Anything, mock.Anything).Return([]byte(tt.payload), nil)
+
+			testSubject := devportaldata.Downloader{
+				BuildAPIToken:     "testToken",
+				BuildURL:          "https://test.com",
+				DownloadContent:   mockIOUtils.DownloadContent,
+				ReadBytesFromFile: mockIOUtils.ReadBytesFromFile,
+			}
+
+			_, err := testSubject.GetDevPortalData()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
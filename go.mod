@@ -9,7 +9,7 @@ require (
 	github.com/bitrise-io/go-xcode v0.0.0-20201002120723-7d05f87f6e9c
 	github.com/bitrise-io/xcode-project v0.0.0-20201201152656-317aa3ad821e
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/fullsailor/pkcs7 v0.0.0-20190404230743-d7302db945fa // indirect
+	github.com/fullsailor/pkcs7 v0.0.0-20190404230743-d7302db945fa
 	github.com/google/go-cmp v0.5.4 // indirect
 	github.com/google/go-querystring v1.0.0
 	github.com/hashicorp/go-version v1.2.1
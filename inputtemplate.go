@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// envReferencePattern matches the {{env "VAR"}}, ${VAR} and $VAR references of an input value
+var envReferencePattern = regexp.MustCompile(`\{\{\s*env\s+"([^"]+)"\s*\}\}|\$\{(\w+)\}|\$(\w+)`)
+
+// envFunctionPattern matches the {{env "VAR"}} references of an input value
+var envFunctionPattern = regexp.MustCompile(`\{\{\s*env\s+"([^"]+)"\s*\}\}`)
+
+// templateInputs are the inputs, whose values may reference environment variables.
+// Secret inputs, URLs the Step sends credentials to and command inputs are not expanded,
+// so that an environment variable can not leak a secret into a log or inject a command.
+var templateInputs = map[string]bool{
+	"project_path":                 true,
+	"scheme":                       true,
+	"configuration":                true,
+	"configuration_fallback":       true,
+	"distribution_type":            true,
+	"team_id":                      true,
+	"min_profile_days_valid":       true,
+	"certificate_selection":        true,
+	"strictness":                   true,
+	"manage":                       true,
+	"signing_mode":                 true,
+	"exclude_target_types":         true,
+	"export_options_plist_path":    true,
+	"derived_sources_dir":          true,
+	"audit_log_path":               true,
+	"signing_identity_report_path": true,
+	"capability_matrix_path":       true,
+	"verbose_log":                  true,
+	"BUNDLE_ID_MIGRATIONS":         true,
+	"MIGRATION_DISTRIBUTION_TYPES": true,
+}
+
+// envFunctionTemplateInputs are the inputs, whose values may reference environment variables only in the {{env "VAR"}} form,
+// as their own syntax uses ${VAR} and $(VAR) references (xcconfig build settings), which are resolved by xcodebuild.
+var envFunctionTemplateInputs = map[string]bool{
+	"xcconfig_content": true,
+}
+
+// expandInputTemplates expands the $VAR, ${VAR} and {{env "VAR"}} references in the values of the config's template inputs
+// (see templateInputs) and the {{env "VAR"}} references of the envFunctionTemplateInputs, before the config is parsed,
+// so that pipeline matrices can drive the inputs on any CI.
+// The resolved inputs are logged.
+func expandInputTemplates(conf interface{}) {
+	t := reflect.TypeOf(conf)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("env"), ",")[0]
+		var pattern *regexp.Regexp
+		switch {
+		case templateInputs[key]:
+			pattern = envReferencePattern
+		case envFunctionTemplateInputs[key]:
+			pattern = envFunctionPattern
+		default:
+			continue
+		}
+
+		value := os.Getenv(key)
+		expanded := expandReferences(value, pattern)
+		if expanded == value {
+			continue
+		}
+		if err := os.Setenv(key, expanded); err != nil {
+			log.Warnf("Failed to set the resolved value of %s: %s", key, err)
+			continue
+		}
+
+		log.Printf("Input %s resolved to: %s", key, expanded)
+	}
+}
+
+// expandTemplate expands the environment variable references of the value.
+// References to undefined variables are left untouched, so that values like shell commands keep their own variables.
+func expandTemplate(value string) string {
+	return expandReferences(value, envReferencePattern)
+}
+
+// expandReferences expands the environment variable references of the value matched by the pattern,
+// the first non-empty submatch of a reference is the name of the variable.
+func expandReferences(value string, pattern *regexp.Regexp) string {
+	return pattern.ReplaceAllStringFunc(value, func(match string) string {
+		var key string
+		for _, submatch := range pattern.FindStringSubmatch(match)[1:] {
+			if submatch != "" {
+				key = submatch
+				break
+			}
+		}
+		if env, ok := os.LookupEnv(key); ok {
+			return env
+		}
+		return match
+	})
+}
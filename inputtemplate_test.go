@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/stepconf"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_expandTemplate(t *testing.T) {
+	for key, value := range map[string]string{"TEMPLATE_FLAVOR": "staging", "TEMPLATE_TEAM": "TEAM123"} {
+		original, isSet := os.LookupEnv(key)
+		require.NoError(t, os.Setenv(key, value))
+		defer func(key, original string, isSet bool) {
+			if isSet {
+				_ = os.Setenv(key, original)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		}(key, original, isSet)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "no reference", value: "io.bitrise.app", want: "io.bitrise.app"},
+		{name: "dollar reference", value: "io.bitrise.app.$TEMPLATE_FLAVOR", want: "io.bitrise.app.staging"},
+		{name: "braced reference", value: "${TEMPLATE_TEAM}_${TEMPLATE_FLAVOR}", want: "TEAM123_staging"},
+		{name: "env template", value: `Profile {{env "TEMPLATE_FLAVOR"}} {{ env "TEMPLATE_TEAM" }}`, want: "Profile staging TEAM123"},
+		{name: "undefined references are kept", value: `sign.sh "$1" ${TEMPLATE_UNDEFINED} {{env "TEMPLATE_UNDEFINED"}}`, want: `sign.sh "$1" ${TEMPLATE_UNDEFINED} {{env "TEMPLATE_UNDEFINED"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, expandTemplate(tt.value))
+		})
+	}
+}
+
+func Test_expandInputTemplates(t *testing.T) {
+	type config struct {
+		Distribution string          `env:"distribution_type,opt[development,app-store]"`
+		Passphrases  stepconf.Secret `env:"passphrases"`
+		PostRunHooks string          `env:"post_run_hooks"`
+		Xcconfig     string          `env:"xcconfig_content"`
+	}
+
+	for key, value := range map[string]string{
+		"TEMPLATE_DISTRIBUTION": "app-store",
+		"distribution_type":     "$TEMPLATE_DISTRIBUTION",
+		"passphrases":           "secret-$TEMPLATE_DISTRIBUTION",
+		"post_run_hooks":        "echo $TEMPLATE_DISTRIBUTION",
+		"xcconfig_content":      "DEVELOPMENT_TEAM = {{env \"TEMPLATE_DISTRIBUTION\"}}\nOTHER = ${TEMPLATE_DISTRIBUTION} $(TEMPLATE_DISTRIBUTION) $TEMPLATE_DISTRIBUTION",
+	} {
+		original, isSet := os.LookupEnv(key)
+		require.NoError(t, os.Setenv(key, value))
+		defer func(key, original string, isSet bool) {
+			if isSet {
+				_ = os.Setenv(key, original)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		}(key, original, isSet)
+	}
+
+	var conf config
+	expandInputTemplates(&conf)
+	require.NoError(t, stepconf.Parse(&conf))
+	require.Equal(t, "app-store", conf.Distribution)
+	require.Equal(t, stepconf.Secret("secret-$TEMPLATE_DISTRIBUTION"), conf.Passphrases, "secret inputs are not expanded")
+	require.Equal(t, "echo $TEMPLATE_DISTRIBUTION", conf.PostRunHooks, "command inputs are not expanded")
+	require.Equal(t, "DEVELOPMENT_TEAM = app-store\nOTHER = ${TEMPLATE_DISTRIBUTION} $(TEMPLATE_DISTRIBUTION) $TEMPLATE_DISTRIBUTION", conf.Xcconfig, "xcconfig build setting references are left to xcodebuild")
+}
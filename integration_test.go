@@ -0,0 +1,235 @@
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/autoprovision"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+// The integration tests provision against a real, dedicated Apple Developer team:
+//
+//	INTEGRATION_API_KEY_ID=... INTEGRATION_API_ISSUER_ID=... INTEGRATION_API_PRIVATE_KEY="$(cat AuthKey.p8)" \
+//		go test -tags integration -run TestIntegration -v .
+//
+// The team needs a valid iOS development certificate and at least one enabled iOS device.
+// The full flow test needs the development certificate with its private key as well, like the certificate_urls input:
+//
+//	INTEGRATION_CERTIFICATE_URL=file://./Development.p12 INTEGRATION_CERTIFICATE_PASSPHRASE=...
+//
+// The tests register disposable bundle IDs (with the INTEGRATION_BUNDLE_ID_PREFIX prefix, io.bitrise.integration by default)
+// and delete them with their profiles afterwards. Never run them against a production team.
+
+// integrationClient returns the client of the sandbox team, the test is skipped if the API key is not set
+func integrationClient(t *testing.T) *appstoreconnect.Client {
+	keyID := os.Getenv("INTEGRATION_API_KEY_ID")
+	issuerID := os.Getenv("INTEGRATION_API_ISSUER_ID")
+	privateKey := os.Getenv("INTEGRATION_API_PRIVATE_KEY")
+	if keyID == "" || issuerID == "" || privateKey == "" {
+		t.Skip("INTEGRATION_API_KEY_ID, INTEGRATION_API_ISSUER_ID and INTEGRATION_API_PRIVATE_KEY are required to run the integration tests")
+	}
+
+	client := appstoreconnect.NewClient(http.DefaultClient, keyID, issuerID, []byte(privateKey))
+	require.NoError(t, client.CheckAuthentication())
+	return client
+}
+
+// disposableBundleIDIdentifier returns a bundle ID, unique to the test run
+func disposableBundleIDIdentifier(name string) string {
+	prefix := os.Getenv("INTEGRATION_BUNDLE_ID_PREFIX")
+	if prefix == "" {
+		prefix = "io.bitrise.integration"
+	}
+	return fmt.Sprintf("%s.%s%d", prefix, name, time.Now().UnixNano())
+}
+
+// cleanupBundleID deletes the profiles of the bundle ID, then the bundle ID itself
+func cleanupBundleID(t *testing.T, client *appstoreconnect.Client, bundleID appstoreconnect.BundleID) {
+	t.Cleanup(func() {
+		profiles, err := client.Provisioning.AllProfiles(bundleID.Relationships.Profiles.Links.Related)
+		if err != nil {
+			t.Errorf("failed to list the profiles of bundle ID (%s): %s", bundleID.Attributes.Identifier, err)
+		} else {
+			for _, profile := range profiles {
+				if err := client.Provisioning.DeleteProfile(profile.ID); err != nil {
+					t.Errorf("failed to delete profile (%s): %s", profile.Attributes.Name, err)
+				}
+			}
+		}
+
+		if err := client.Provisioning.DeleteBundleID(bundleID.ID); err != nil {
+			t.Errorf("failed to delete bundle ID (%s): %s", bundleID.Attributes.Identifier, err)
+		}
+	})
+}
+
+func TestIntegration_developmentProfile(t *testing.T) {
+	client := integrationClient(t)
+
+	certificates, err := client.Provisioning.ListCertificates(&appstoreconnect.ListCertificatesOptions{
+		FilterCertificateType: appstoreconnect.IOSDevelopment,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, certificates.Data, "the sandbox team has no iOS development certificate")
+
+	devices, err := client.Provisioning.ListDevices(&appstoreconnect.ListDevicesOptions{
+		FilterPlatform: appstoreconnect.IOSDevice,
+		FilterStatus:   appstoreconnect.Enabled,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, devices.Data, "the sandbox team has no enabled iOS device")
+
+	portalChanges := autoprovision.NewPortalChanges(0)
+	profileManager := autoprovision.NewProfileManager(client, autoprovision.NewSession("integration"), portalChanges, nil)
+	profileManager.ProfileNameCollision = autoprovision.FailOnProfileNameCollision
+
+	identifier := disposableBundleIDIdentifier("development")
+	entitlements := serialized.Object{"aps-environment": "development"}
+
+	bundleID, err := profileManager.EnsureBundleID(identifier, entitlements, autoprovision.IOS)
+	require.NoError(t, err)
+	cleanupBundleID(t, client, *bundleID)
+	require.Equal(t, identifier, bundleID.Attributes.Identifier)
+
+	certIDs := []string{certificates.Data[0].ID}
+	deviceIDs := []string{devices.Data[0].ID}
+	profile, err := profileManager.EnsureProfile(appstoreconnect.IOSAppDevelopment, identifier, entitlements, certIDs, deviceIDs, 0)
+	require.NoError(t, err)
+	require.NotEmpty(t, profile.Attributes.ProfileContent)
+
+	// a second run reuses the profile
+	reused, err := profileManager.EnsureProfile(appstoreconnect.IOSAppDevelopment, identifier, entitlements, certIDs, deviceIDs, 0)
+	require.NoError(t, err)
+	require.Equal(t, profile.ID, reused.ID)
+
+	var actions []autoprovision.PortalChangeAction
+	for _, change := range portalChanges.Changes {
+		actions = append(actions, change.Action)
+	}
+	require.Contains(t, actions, autoprovision.CreateBundleIDChange)
+	require.Equal(t, 1, countActions(actions, autoprovision.CreateProfileChange), "the profile is created once")
+}
+
+func countActions(actions []autoprovision.PortalChangeAction, action autoprovision.PortalChangeAction) int {
+	count := 0
+	for _, a := range actions {
+		if a == action {
+			count++
+		}
+	}
+	return count
+}
+
+// TestIntegration_fullFlow runs the Step's flow: certificates -> devices -> profiles -> install.
+// The devices are not registered, the already registered device is passed as the test device,
+// as the registered devices can only be disabled on the Developer Portal, not deleted.
+func TestIntegration_fullFlow(t *testing.T) {
+	client := integrationClient(t)
+
+	certificateURL := os.Getenv("INTEGRATION_CERTIFICATE_URL")
+	if certificateURL == "" {
+		t.Skip("INTEGRATION_CERTIFICATE_URL is required to run the full flow integration test")
+	}
+
+	// certificates
+	certs, err := downloadCertificates([]CertificateFileURL{{URL: certificateURL, Passphrase: os.Getenv("INTEGRATION_CERTIFICATE_PASSPHRASE")}})
+	require.NoError(t, err)
+
+	portalChanges := autoprovision.NewPortalChanges(0)
+	provisioner := autoprovision.NewAutoProvisioner(client, autoprovision.NewSession("integration"), portalChanges)
+	provisioner.Profiles.ProfileNameCollision = autoprovision.FailOnProfileNameCollision
+
+	certsByType, distrTypes, err := provisioner.EnsureCertificates(certs, autoprovision.IOS, autoprovision.Development, "")
+	require.NoError(t, err)
+	require.Equal(t, []autoprovision.DistributionType{autoprovision.Development}, distrTypes)
+	developmentCerts := certsByType[appstoreconnect.IOSDevelopment]
+	require.NotEmpty(t, developmentCerts, "the certificate is not an iOS development certificate of the sandbox team")
+	certificate := developmentCerts[0]
+
+	// devices
+	devices, err := client.Provisioning.ListDevices(&appstoreconnect.ListDevicesOptions{
+		FilterPlatform: appstoreconnect.IOSDevice,
+		FilterStatus:   appstoreconnect.Enabled,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, devices.Data, "the sandbox team has no enabled iOS device")
+	testDevice := autoprovision.TestDevice{UDID: devices.Data[0].Attributes.UDID, Title: devices.Data[0].Attributes.Name, DeviceType: "ios"}
+
+	registration, err := provisioner.EnsureDevices(appstoreconnect.IOSDevice, []autoprovision.TestDevice{testDevice}, distrTypes)
+	require.NoError(t, err)
+	require.Empty(t, registration.Registered, "the registered device is not registered again")
+	require.NotEmpty(t, registration.Devices)
+
+	// profiles
+	identifier := disposableBundleIDIdentifier("flow")
+	entitlements := serialized.Object{"aps-environment": "development"}
+	profiles, err := provisioner.EnsureProfiles(autoprovision.ProfileRequest{
+		Platform:               autoprovision.IOS,
+		Distribution:           autoprovision.Development,
+		EntitlementsByBundleID: map[string]serialized.Object{identifier: entitlements},
+		CertIDs:                []string{certificate.ID},
+		Devices:                registration.Devices,
+	})
+	bundleID, findErr := autoprovision.FindBundleID(client, identifier)
+	require.NoError(t, findErr)
+	if bundleID != nil {
+		cleanupBundleID(t, client, *bundleID)
+	}
+	require.NoError(t, err)
+	profile, ok := profiles.ProfilesByBundleID[identifier]
+	require.True(t, ok)
+	require.Equal(t, appstoreconnect.IOSAppDevelopment, profile.Attributes.ProfileType)
+
+	// install: the profile into a temporary home directory, the certificate into a temporary keychain
+	homeDir, err := ioutil.TempDir("", "integration-home-")
+	require.NoError(t, err)
+	originalHome := os.Getenv("HOME")
+	require.NoError(t, os.Setenv("HOME", homeDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Setenv("HOME", originalHome))
+		require.NoError(t, os.RemoveAll(homeDir))
+	})
+
+	require.NoError(t, autoprovision.WriteProfile(profile))
+	installed, err := ioutil.ReadFile(filepath.Join(homeDir, "Library/MobileDevice/Provisioning Profiles", profile.Attributes.UUID+".mobileprovision"))
+	require.NoError(t, err)
+	require.Equal(t, profile.Attributes.ProfileContent, installed)
+
+	if runtime.GOOS != "darwin" {
+		t.Log("Skipping the keychain installation, the security tool is only available on macOS")
+		return
+	}
+
+	keychainPath := filepath.Join(homeDir, "integration.keychain")
+	kc, err := keychain.New(keychainPath, "integration")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		// removes the keychain from the search list too
+		if out, err := command.New("security", "delete-keychain", kc.Path).RunAndReturnTrimmedCombinedOutput(); err != nil {
+			t.Errorf("failed to delete keychain (%s): %s, %s", kc.Path, out, err)
+		}
+	})
+	// the lock settings and the default keychain of the machine are not changed
+	kc.KeepSettings = true
+	kc.TempDir = homeDir
+	require.NoError(t, kc.InstallCertificate(certificate.Certificate, ""))
+
+	out, err := command.New("security", "find-identity", "-v", "-p", "codesigning", kc.Path).RunAndReturnTrimmedCombinedOutput()
+	require.NoError(t, err, out)
+	require.Contains(t, out, strings.ToUpper(certificate.Certificate.SHA1Fingerprint))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/autoprovision"
+)
+
+// distributionTypeOptions are the distribution types offered in the interactive mode
+var distributionTypeOptions = []string{
+	string(autoprovision.Development),
+	string(autoprovision.AppStore),
+	string(autoprovision.AdHoc),
+	string(autoprovision.Enterprise),
+}
+
+// interactiveModeEnabled returns true if the interactive input is set and the Step runs in a terminal,
+// for example under `bitrise run` for local debugging.
+func interactiveModeEnabled() bool {
+	if os.Getenv("interactive") != "yes" {
+		return false
+	}
+	if !isTerminal(os.Stdin) {
+		log.Warnf("Interactive mode is enabled, but the standard input is not a terminal, not prompting for the missing inputs")
+		return false
+	}
+	return true
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// promptMissingInputs prompts for the missing scheme and distribution type inputs,
+// and sets them as environment variables, so that the config parsing picks them up.
+func promptMissingInputs(in io.Reader, out io.Writer) error {
+	reader := bufio.NewReader(in)
+
+	if os.Getenv("scheme") == "" {
+		projectPath := os.Getenv("project_path")
+		if projectPath == "" {
+			value, err := prompt(reader, out, "Xcode Project (or Workspace) path")
+			if err != nil {
+				return err
+			}
+			projectPath = value
+			if err := os.Setenv("project_path", projectPath); err != nil {
+				return err
+			}
+		}
+
+		schemes, err := autoprovision.ListSchemes(projectPath)
+		if err != nil {
+			return fmt.Errorf("failed to list the schemes of %s: %s", projectPath, err)
+		}
+		if len(schemes) == 0 {
+			return fmt.Errorf("no shared scheme found in %s", projectPath)
+		}
+
+		scheme, err := selectOption(reader, out, "Scheme", schemes)
+		if err != nil {
+			return err
+		}
+		if err := os.Setenv("scheme", scheme); err != nil {
+			return err
+		}
+	}
+
+	if os.Getenv("distribution_type") == "" {
+		distributionType, err := selectOption(reader, out, "Distribution type", distributionTypeOptions)
+		if err != nil {
+			return err
+		}
+		if err := os.Setenv("distribution_type", distributionType); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prompt asks for a non empty value
+func prompt(reader *bufio.Reader, out io.Writer, title string) (string, error) {
+	for {
+		if _, err := fmt.Fprintf(out, "%s: ", title); err != nil {
+			return "", err
+		}
+		line, err := reader.ReadString('\n')
+		if value := strings.TrimSpace(line); value != "" {
+			return value, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %s", title, err)
+		}
+	}
+}
+
+// selectOption lists the numbered options and asks to select one of them, by its number or its value.
+// A single option is selected without asking.
+func selectOption(reader *bufio.Reader, out io.Writer, title string, options []string) (string, error) {
+	if len(options) == 1 {
+		if _, err := fmt.Fprintf(out, "%s: %s\n", title, options[0]); err != nil {
+			return "", err
+		}
+		return options[0], nil
+	}
+
+	if _, err := fmt.Fprintf(out, "%s:\n", title); err != nil {
+		return "", err
+	}
+	for i, option := range options {
+		if _, err := fmt.Fprintf(out, "%d) %s\n", i+1, option); err != nil {
+			return "", err
+		}
+	}
+
+	for {
+		value, err := prompt(reader, out, fmt.Sprintf("Select (1-%d)", len(options)))
+		if err != nil {
+			return "", err
+		}
+
+		if i, err := strconv.Atoi(value); err == nil && i >= 1 && i <= len(options) {
+			return options[i-1], nil
+		}
+		for _, option := range options {
+			if option == value {
+				return option, nil
+			}
+		}
+		if _, err := fmt.Fprintf(out, "invalid selection: %s\n", value); err != nil {
+			return "", err
+		}
+	}
+}
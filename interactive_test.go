@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_selectOption(t *testing.T) {
+	options := []string{"MyApp", "MyApp-Staging", "MyFramework"}
+
+	tests := []struct {
+		name    string
+		options []string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "by number", options: options, input: "2\n", want: "MyApp-Staging"},
+		{name: "by value", options: options, input: "MyFramework\n", want: "MyFramework"},
+		{name: "retries invalid selection", options: options, input: "4\n\nMyApp\n", want: "MyApp"},
+		{name: "single option", options: []string{"MyApp"}, input: "", want: "MyApp"},
+		{name: "no input", options: options, input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			got, err := selectOption(bufio.NewReader(strings.NewReader(tt.input)), &out, "Scheme", tt.options)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_promptMissingInputs(t *testing.T) {
+	for key, value := range map[string]string{"scheme": "MyApp", "distribution_type": ""} {
+		original, ok := os.LookupEnv(key)
+		require.NoError(t, os.Setenv(key, value))
+		defer func(key, original string, ok bool) {
+			if ok {
+				_ = os.Setenv(key, original)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		}(key, original, ok)
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, promptMissingInputs(strings.NewReader("app-store\n"), &out))
+	require.Contains(t, out.String(), "1) development")
+	require.Equal(t, "app-store", os.Getenv("distribution_type"))
+}
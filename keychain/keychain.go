@@ -1,47 +1,80 @@
 package keychain
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"path/filepath"
-	"strings"
+	"io/ioutil"
+	"os"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-utils/command"
 	"github.com/bitrise-io/go-utils/errorutil"
-	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-io/go-xcode/certificateutil"
 	"github.com/hashicorp/go-version"
+	"howett.net/plist"
 )
 
 // Keychain descritbes a macOS Keychain
 type Keychain struct {
 	Path     string
 	Password stepconf.Secret
+	// TempDir is the directory of the certificates exported for the import, the system temp dir is used if empty
+	TempDir string
+	// KeepTempFiles keeps the certificates exported for the import, for debugging (keep_temp_dir input)
+	KeepTempFiles bool
+	// KeepSettings leaves the lock settings and the default keychain of the system as they are,
+	// for keychains set up by an earlier step (like a certificate installer step)
+	KeepSettings bool
 }
 
+// errCertificateExists is returned by importCertificate, if the keychain already contains the certificate
+var errCertificateExists = errors.New("the certificate already exists in the keychain")
+
 // New ...
 func New(pth string, pass stepconf.Secret) (*Keychain, error) {
-	if exist, err := pathutil.IsPathExists(pth); err != nil {
+	if kc, err := existing(pth, pass); err != nil {
 		return nil, err
-	} else if exist {
-		return &Keychain{
-			Path:     pth,
-			Password: stepconf.Secret(pass),
-		}, nil
+	} else if kc != nil {
+		return kc, nil
 	}
 
-	p := pth + "-db"
-	if exist, err := pathutil.IsPathExists(p); err != nil {
+	return createKeychain(pth, pass)
+}
+
+// Open returns the existing keychain set up by an earlier step, without creating it, and unlocks it to verify the password.
+// The lock settings and the default keychain of the system are kept (see KeepSettings).
+func Open(pth string, pass stepconf.Secret) (*Keychain, error) {
+	kc, err := existing(pth, pass)
+	if err != nil {
 		return nil, err
-	} else if exist {
-		return &Keychain{
-			Path:     p,
-			Password: pass,
-		}, nil
+	}
+	if kc == nil {
+		return nil, fmt.Errorf("keychain (%s) does not exist", pth)
 	}
 
-	return createKeychain(pth, pass)
+	if err := kc.unlock(); err != nil {
+		return nil, fmt.Errorf("failed to unlock keychain (%s), check its password: %s", kc.Path, err)
+	}
+	kc.KeepSettings = true
+	return kc, nil
+}
+
+// existing returns the keychain at the path (or the path with the -db suffix of the newer keychain files), nil if it does not exist
+func existing(pth string, pass stepconf.Secret) (*Keychain, error) {
+	for _, p := range []string{pth, pth + "-db"} {
+		if exist, err := pathutil.IsPathExists(p); err != nil {
+			return nil, err
+		} else if exist {
+			return &Keychain{
+				Path:     p,
+				Password: pass,
+			}, nil
+		}
+	}
+	return nil, nil
 }
 
 // InstallCertificate ...
@@ -51,16 +84,24 @@ func (k Keychain) InstallCertificate(cert certificateutil.CertificateInfoModel,
 		return err
 	}
 
-	tmpDir, err := pathutil.NormalizedOSTempDirPath("keychain")
+	pth, err := writeTempFile(k.TempDir, "Certificate-*.p12", b)
 	if err != nil {
 		return err
 	}
-	pth := filepath.Join(tmpDir, "Certificate.p12")
-	if err := fileutil.WriteBytesToFile(pth, b); err != nil {
-		return err
-	}
+	defer func() {
+		if k.KeepTempFiles {
+			log.Warnf("Keeping exported certificate, it contains key material: %s", pth)
+			return
+		}
+		if err := os.Remove(pth); err != nil {
+			log.Warnf("Failed to remove exported certificate (%s): %s", pth, err)
+		}
+	}()
 
-	if err := k.importCertificate(pth, "bitrise"); err != nil {
+	// A certificate installer step may have installed the same certificate already
+	if err := k.importCertificate(pth, "bitrise"); err == errCertificateExists {
+		log.Printf("The certificate (%s) is already installed in the keychain", cert.CommonName)
+	} else if err != nil {
 		return err
 	}
 
@@ -72,125 +113,58 @@ func (k Keychain) InstallCertificate(cert certificateutil.CertificateInfoModel,
 		}
 	}
 
-	if err := k.setLockSettings(); err != nil {
-		return err
+	if !k.KeepSettings {
+		if err := k.setLockSettings(); err != nil {
+			return err
+		}
 	}
 
 	if err := k.addToSearchPath(); err != nil {
 		return err
 	}
 
-	if err := k.setAsDefault(); err != nil {
-		return err
+	if !k.KeepSettings {
+		if err := k.setAsDefault(); err != nil {
+			return err
+		}
 	}
 
 	return k.unlock()
 }
 
-func runSecurityCmd(args ...interface{}) error {
-	var printableArgs []string
-	var cmdArgs []string
-	for _, arg := range args {
-		v, ok := arg.(stepconf.Secret)
-		if ok {
-			printableArgs = append(printableArgs, v.String())
-			cmdArgs = append(cmdArgs, string(v))
-		} else if v, ok := arg.(string); ok {
-			printableArgs = append(printableArgs, v)
-			cmdArgs = append(cmdArgs, v)
-		} else if v, ok := arg.([]string); ok {
-			printableArgs = append(printableArgs, v...)
-			cmdArgs = append(cmdArgs, v...)
-		} else {
-			return fmt.Errorf("unknown arg provided: %T, string, []string, and stepconf.Secret are acceptable", arg)
-		}
+// FindGenericPassword returns the data of a generic password item (like an App Store Connect API private key),
+// stored in the keychains of the search list, optionally filtered by the account.
+// The security tool prints non printable data (like multi-line contents) hex encoded.
+func FindGenericPassword(service, account string) (stepconf.Secret, error) {
+	args := []string{"find-generic-password", "-s", service}
+	if account != "" {
+		args = append(args, "-a", account)
 	}
+	args = append(args, "-w")
 
-	out, err := command.New("security", cmdArgs...).RunAndReturnTrimmedCombinedOutput()
+	cmd := command.New("security", args...)
+	out, err := cmd.RunAndReturnTrimmedOutput()
 	if err != nil {
 		if errorutil.IsExitStatusError(err) {
-			return fmt.Errorf("%s failed: %s", command.PrintableCommandArgs(false, append([]string{"security"}, printableArgs...)), out)
+			return "", fmt.Errorf("%s failed: generic password item (%s) not found", cmd.PrintableCommandArgs(), service)
 		}
-		return fmt.Errorf("%s failed: %s", command.PrintableCommandArgs(false, append([]string{"security"}, printableArgs...)), err)
+		return "", fmt.Errorf("%s failed: %s", cmd.PrintableCommandArgs(), err)
 	}
-	return nil
+	return stepconf.Secret(out), nil
 }
 
-// listKeychains returns the paths of available keychains
-func listKeychains() ([]string, error) {
-	cmd := command.New("security", "list-keychain")
-	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
-	if err != nil {
-		if errorutil.IsExitStatusError(err) {
-			return nil, fmt.Errorf("%s failed: %s", cmd.PrintableCommandArgs(), out)
-		}
-		return nil, fmt.Errorf("%s failed: %s", cmd.PrintableCommandArgs(), err)
-	}
-
-	var keychains []string
-	for _, path := range strings.Split(out, "\n") {
-		trimmed := strings.TrimSpace(path)
-		trimmed = strings.Trim(trimmed, `"`)
-		keychains = append(keychains, trimmed)
-	}
+// keyPartitions are the partitions, the imported private keys are available for without a prompt,
+// apple-tool: is required by codesign and apple: by the Xcode tools.
+var keyPartitions = []string{"apple-tool:", "apple:"}
 
-	return keychains, nil
-}
-
-// createKeychain creates a new keychain file at
-// path, protected by password. Returns an error
-// if the keychain could not be created, otherwise
-// a Keychain object representing the created
-// keychain is returned.
-func createKeychain(path string, password stepconf.Secret) (*Keychain, error) {
-	err := runSecurityCmd("-v", "create-keychain", "-p", password, path)
+// keyPartitionListDescription returns the description of a key's partition ID ACL:
+// the hex encoded XML property list of the partitions.
+func keyPartitionListDescription(partitions []string) (string, error) {
+	content, err := plist.Marshal(map[string][]string{"Partitions": partitions}, plist.XMLFormat)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("failed to serialize key partitions: %s", err)
 	}
-
-	return &Keychain{
-		Path:     path,
-		Password: password,
-	}, nil
-}
-
-// importCertificate adds the certificate at path, protected by
-// passphrase to the k keychain.
-func (k Keychain) importCertificate(path string, passphrase stepconf.Secret) error {
-	return runSecurityCmd("import", path, "-k", k.Path, "-P", passphrase, "-A")
-}
-
-// setKeyPartitionList sets the partition list
-// for the keychain to allow access for tools.
-func (k Keychain) setKeyPartitionList() error {
-	return runSecurityCmd("set-key-partition-list", "-S", "apple-tool:,apple:", "-k", k.Password, k.Path)
-}
-
-// setLockSettings sets keychain autolocking.
-func (k Keychain) setLockSettings() error {
-	return runSecurityCmd("-v", "set-keychain-settings", "-lut", "72000", k.Path)
-}
-
-// addToSearchPath registers the keychain
-// in the systemwide search path
-func (k Keychain) addToSearchPath() error {
-	keychains, err := listKeychains()
-	if err != nil {
-		return fmt.Errorf("get keychain list: %s", err)
-	}
-
-	return runSecurityCmd("-v", "list-keychains", "-s", keychains)
-}
-
-// setAsDefault sets the keychain as the
-// default keychain for the system.
-func (k Keychain) setAsDefault() error {
-	return runSecurityCmd("-v", "default-keychain", "-s", k.Path)
-}
-
-// unlock unlocks the keychain
-func (k Keychain) unlock() error {
-	return runSecurityCmd("-v", "unlock-keychain", "-p", k.Password, k.Path)
+	return hex.EncodeToString(content), nil
 }
 
 // isKeyPartitionListNeeded determines whether
@@ -221,3 +195,26 @@ func isKeyPartitionListNeeded() (bool, error) {
 
 	return false, nil
 }
+
+// writeTempFile writes the content into a new, owner-only readable file in dir, and returns its path.
+func writeTempFile(dir, pattern string, content []byte) (string, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %s", err)
+	}
+
+	if _, err := f.Write(content); err != nil {
+		if cerr := f.Close(); cerr != nil {
+			log.Warnf("Failed to close temporary file (%s): %s", f.Name(), cerr)
+		}
+		if rerr := os.Remove(f.Name()); rerr != nil {
+			log.Warnf("Failed to remove temporary file (%s): %s", f.Name(), rerr)
+		}
+		return "", fmt.Errorf("failed to write temporary file (%s): %s", f.Name(), err)
+	}
+
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to close temporary file (%s): %s", f.Name(), err)
+	}
+	return f.Name(), nil
+}
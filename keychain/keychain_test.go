@@ -1,14 +1,17 @@
 package keychain
 
 import (
+	"encoding/hex"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-xcode/certificateutil"
+	"howett.net/plist"
 )
 
 func TestCreateKeychain(t *testing.T) {
@@ -139,3 +142,92 @@ func TestKeychain_importCertificate(t *testing.T) {
 		})
 	}
 }
+
+func Test_writeTempFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-write-temp-file")
+	if err != nil {
+		t.Fatalf("setup: create temp dir: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("remove temp dir: %s", err)
+		}
+	}()
+
+	pth, err := writeTempFile(dir, "Certificate-*.p12", []byte("content"))
+	if err != nil {
+		t.Fatalf("writeTempFile() error = %v", err)
+	}
+
+	if filepath.Dir(pth) != dir {
+		t.Errorf("writeTempFile() = %s, want a file in %s", pth, dir)
+	}
+
+	info, err := os.Stat(pth)
+	if err != nil {
+		t.Fatalf("stat: %s", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("writeTempFile() permissions = %s, want %s", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if string(content) != "content" {
+		t.Errorf("writeTempFile() content = %s, want content", content)
+	}
+}
+
+func Test_keyPartitionListDescription(t *testing.T) {
+	description, err := keyPartitionListDescription([]string{"apple-tool:", "apple:"})
+	if err != nil {
+		t.Fatalf("keyPartitionListDescription() error = %v", err)
+	}
+
+	content, err := hex.DecodeString(description)
+	if err != nil {
+		t.Fatalf("keyPartitionListDescription() = %s, not hex encoded: %s", description, err)
+	}
+
+	var partitions map[string][]string
+	if _, err := plist.Unmarshal(content, &partitions); err != nil {
+		t.Fatalf("keyPartitionListDescription() = %s, not a property list: %s", content, err)
+	}
+	if want := []string{"apple-tool:", "apple:"}; !reflect.DeepEqual(partitions["Partitions"], want) {
+		t.Errorf("keyPartitionListDescription() partitions = %v, want %v", partitions["Partitions"], want)
+	}
+}
+
+func TestOpen_missingKeychain(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "build.keychain")
+	if _, err := Open(pth, "password"); err == nil || err.Error() != "keychain ("+pth+") does not exist" {
+		t.Errorf("Open() error = %v, want keychain does not exist error", err)
+	}
+	if _, err := os.Stat(pth); !os.IsNotExist(err) {
+		t.Errorf("Open() created the keychain")
+	}
+}
+
+func Test_existing(t *testing.T) {
+	dir := t.TempDir()
+	pth := filepath.Join(dir, "build.keychain")
+
+	kc, err := existing(pth, "password")
+	if err != nil || kc != nil {
+		t.Fatalf("existing() = %v, %v, want nil keychain", kc, err)
+	}
+
+	if err := ioutil.WriteFile(pth+"-db", []byte("keychain"), 0600); err != nil {
+		t.Fatalf("setup: %s", err)
+	}
+	kc, err = existing(pth, "password")
+	if err != nil {
+		t.Fatalf("existing() error = %s", err)
+	}
+	want := &Keychain{Path: pth + "-db", Password: "password"}
+	if !reflect.DeepEqual(kc, want) {
+		t.Errorf("existing() = %v, want %v", kc, want)
+	}
+}
@@ -0,0 +1,403 @@
+//go:build darwin && cgo && security_framework
+// +build darwin,cgo,security_framework
+
+package keychain
+
+/*
+#cgo CFLAGS: -Wno-deprecated-declarations
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+
+#include <stdlib.h>
+#include <string.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+
+// releaseRef releases the Core Foundation object, CFRelease crashes on NULL.
+static void releaseRef(CFTypeRef ref) {
+	if (ref != NULL) {
+		CFRelease(ref);
+	}
+}
+
+static CFStringRef createString(const char *bytes, CFIndex length) {
+	return CFStringCreateWithBytes(kCFAllocatorDefault, (const UInt8 *)bytes, length, kCFStringEncodingUTF8, false);
+}
+
+static OSStatus createKeychain(const char *path, const char *password, UInt32 passwordLength) {
+	SecKeychainRef keychain = NULL;
+	OSStatus status = SecKeychainCreate(path, passwordLength, password, false, NULL, &keychain);
+	releaseRef(keychain);
+	return status;
+}
+
+static OSStatus unlockKeychain(const char *path, const char *password, UInt32 passwordLength) {
+	SecKeychainRef keychain = NULL;
+	OSStatus status = SecKeychainOpen(path, &keychain);
+	if (status != errSecSuccess) {
+		return status;
+	}
+	status = SecKeychainUnlock(keychain, passwordLength, password, true);
+	releaseRef(keychain);
+	return status;
+}
+
+static OSStatus setKeychainLockSettings(const char *path, UInt32 lockInterval) {
+	SecKeychainRef keychain = NULL;
+	OSStatus status = SecKeychainOpen(path, &keychain);
+	if (status != errSecSuccess) {
+		return status;
+	}
+
+	SecKeychainSettings settings;
+	settings.version = SEC_KEYCHAIN_SETTINGS_VERS1;
+	settings.lockOnSleep = true;
+	settings.useLockInterval = true;
+	settings.lockInterval = lockInterval;
+
+	status = SecKeychainSetSettings(keychain, &settings);
+	releaseRef(keychain);
+	return status;
+}
+
+static OSStatus setDefaultKeychain(const char *path) {
+	SecKeychainRef keychain = NULL;
+	OSStatus status = SecKeychainOpen(path, &keychain);
+	if (status != errSecSuccess) {
+		return status;
+	}
+	status = SecKeychainSetDefault(keychain);
+	releaseRef(keychain);
+	return status;
+}
+
+static OSStatus addKeychainToSearchList(const char *path) {
+	SecKeychainRef keychain = NULL;
+	OSStatus status = SecKeychainOpen(path, &keychain);
+	if (status != errSecSuccess) {
+		return status;
+	}
+
+	CFArrayRef searchList = NULL;
+	status = SecKeychainCopySearchList(&searchList);
+	if (status != errSecSuccess) {
+		releaseRef(keychain);
+		return status;
+	}
+
+	if (searchList == NULL || !CFArrayContainsValue(searchList, CFRangeMake(0, CFArrayGetCount(searchList)), keychain)) {
+		CFMutableArrayRef newSearchList = searchList != NULL
+			? CFArrayCreateMutableCopy(kCFAllocatorDefault, 0, searchList)
+			: CFArrayCreateMutable(kCFAllocatorDefault, 0, &kCFTypeArrayCallBacks);
+		if (newSearchList == NULL) {
+			status = errSecAllocate;
+		} else {
+			CFArrayAppendValue(newSearchList, keychain);
+			status = SecKeychainSetSearchList(newSearchList);
+			releaseRef(newSearchList);
+		}
+	}
+
+	releaseRef(searchList);
+	releaseRef(keychain);
+	return status;
+}
+
+// allowAnyApplication lets any application decrypt the keys, imported with the access (security import -A).
+static OSStatus allowAnyApplication(SecAccessRef access) {
+	CFArrayRef acls = SecAccessCopyMatchingACLList(access, kSecACLAuthorizationDecrypt);
+	if (acls == NULL) {
+		return errSecSuccess;
+	}
+
+	OSStatus status = errSecSuccess;
+	for (CFIndex i = 0; i < CFArrayGetCount(acls) && status == errSecSuccess; i++) {
+		SecACLRef acl = (SecACLRef)CFArrayGetValueAtIndex(acls, i);
+		CFArrayRef applications = NULL;
+		CFStringRef description = NULL;
+		SecKeychainPromptSelector promptSelector = 0;
+
+		status = SecACLCopyContents(acl, &applications, &description, &promptSelector);
+		if (status == errSecSuccess) {
+			status = SecACLSetContents(acl, NULL, description, promptSelector);
+		}
+
+		releaseRef(applications);
+		releaseRef(description);
+	}
+
+	releaseRef(acls);
+	return status;
+}
+
+static OSStatus importPKCS12(const char *path, const void *data, CFIndex dataLength, const char *passphrase, CFIndex passphraseLength) {
+	SecKeychainRef keychain = NULL;
+	OSStatus status = SecKeychainOpen(path, &keychain);
+	if (status != errSecSuccess) {
+		return status;
+	}
+
+	SecAccessRef access = NULL;
+	status = SecAccessCreate(CFSTR("Imported Private Key"), NULL, &access);
+	if (status == errSecSuccess) {
+		status = allowAnyApplication(access);
+	}
+
+	if (status == errSecSuccess) {
+		CFDataRef content = CFDataCreate(kCFAllocatorDefault, (const UInt8 *)data, dataLength);
+		CFStringRef passphraseString = createString(passphrase, passphraseLength);
+
+		SecItemImportExportKeyParameters keyParams;
+		memset(&keyParams, 0, sizeof(keyParams));
+		keyParams.version = SEC_KEY_IMPORT_EXPORT_PARAMS_VERSION;
+		keyParams.passphrase = passphraseString;
+		keyParams.accessRef = access;
+
+		SecExternalFormat format = kSecFormatPKCS12;
+		SecExternalItemType itemType = kSecItemTypeAggregate;
+		status = SecItemImport(content, NULL, &format, &itemType, 0, &keyParams, keychain, NULL);
+
+		releaseRef(passphraseString);
+		releaseRef(content);
+	}
+
+	releaseRef(access);
+	releaseRef(keychain);
+	return status;
+}
+
+static OSStatus setItemPartitionList(SecKeychainItemRef item, CFStringRef description, const char *password, UInt32 passwordLength) {
+	SecAccessRef access = NULL;
+	OSStatus status = SecKeychainItemCopyAccess(item, &access);
+	if (status != errSecSuccess) {
+		return status;
+	}
+
+	CFArrayRef acls = SecAccessCopyMatchingACLList(access, kSecACLAuthorizationPartitionID);
+	if (acls != NULL && CFArrayGetCount(acls) > 0) {
+		for (CFIndex i = 0; i < CFArrayGetCount(acls) && status == errSecSuccess; i++) {
+			SecACLRef acl = (SecACLRef)CFArrayGetValueAtIndex(acls, i);
+			CFArrayRef applications = NULL;
+			CFStringRef oldDescription = NULL;
+			SecKeychainPromptSelector promptSelector = 0;
+
+			status = SecACLCopyContents(acl, &applications, &oldDescription, &promptSelector);
+			if (status == errSecSuccess) {
+				status = SecACLSetContents(acl, applications, description, promptSelector);
+			}
+
+			releaseRef(applications);
+			releaseRef(oldDescription);
+		}
+	} else {
+		SecACLRef acl = NULL;
+		status = SecACLCreateWithSimpleContents(access, NULL, description, 0, &acl);
+		if (status == errSecSuccess) {
+			CFTypeRef authorizations[] = { kSecACLAuthorizationPartitionID };
+			CFArrayRef authorizationList = CFArrayCreate(kCFAllocatorDefault, authorizations, 1, &kCFTypeArrayCallBacks);
+			status = SecACLUpdateAuthorizations(acl, authorizationList);
+			releaseRef(authorizationList);
+			releaseRef(acl);
+		}
+	}
+	releaseRef(acls);
+
+	if (status == errSecSuccess) {
+		status = SecKeychainItemSetAccessWithPassword(item, access, passwordLength, password);
+	}
+	releaseRef(access);
+	return status;
+}
+
+// setKeyPartitionList sets the partition list of every private key of the keychain (security set-key-partition-list).
+static OSStatus setKeyPartitionList(const char *path, const char *password, UInt32 passwordLength, const char *description, CFIndex descriptionLength) {
+	SecKeychainRef keychain = NULL;
+	OSStatus status = SecKeychainOpen(path, &keychain);
+	if (status != errSecSuccess) {
+		return status;
+	}
+
+	CFArrayRef searchList = CFArrayCreate(kCFAllocatorDefault, (const void **)&keychain, 1, &kCFTypeArrayCallBacks);
+	const void *keys[] = { kSecClass, kSecMatchSearchList, kSecMatchLimit, kSecReturnRef };
+	const void *values[] = { kSecClassKey, searchList, kSecMatchLimitAll, kCFBooleanTrue };
+	CFDictionaryRef query = CFDictionaryCreate(kCFAllocatorDefault, keys, values, 4, &kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+
+	CFTypeRef result = NULL;
+	status = SecItemCopyMatching(query, &result);
+	releaseRef(query);
+	releaseRef(searchList);
+	if (status == errSecItemNotFound) {
+		releaseRef(keychain);
+		return errSecSuccess;
+	}
+	if (status != errSecSuccess) {
+		releaseRef(keychain);
+		return status;
+	}
+
+	CFStringRef descriptionString = createString(description, descriptionLength);
+	CFArrayRef items = (CFArrayRef)result;
+	for (CFIndex i = 0; items != NULL && i < CFArrayGetCount(items) && status == errSecSuccess; i++) {
+		SecKeychainItemRef item = (SecKeychainItemRef)CFArrayGetValueAtIndex(items, i);
+		status = setItemPartitionList(item, descriptionString, password, passwordLength);
+	}
+
+	releaseRef(descriptionString);
+	releaseRef(result);
+	releaseRef(keychain);
+	return status;
+}
+
+// copyErrorMessage returns the message of the status as a C string, which must be freed by the caller.
+static char *copyErrorMessage(OSStatus status) {
+	CFStringRef message = SecCopyErrorMessageString(status, NULL);
+	if (message == NULL) {
+		return NULL;
+	}
+
+	CFIndex size = CFStringGetMaximumSizeForEncoding(CFStringGetLength(message), kCFStringEncodingUTF8) + 1;
+	char *buffer = malloc(size);
+	if (buffer != NULL && !CFStringGetCString(message, buffer, size, kCFStringEncodingUTF8)) {
+		free(buffer);
+		buffer = NULL;
+	}
+	releaseRef(message);
+	return buffer;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"unsafe"
+
+	"github.com/bitrise-io/go-steputils/stepconf"
+)
+
+// The keychain operations are performed by the Security framework, instead of the security tool,
+// so that the errors are reported by their OSStatus, and no tool output is parsed.
+// The bindings are opt-in, built with the security_framework build tag: go build -tags security_framework
+
+// keychainLockInterval is the number of seconds, the keychain locks after
+const keychainLockInterval = 72000
+
+// securityError returns the error of the operation's status, nil if it succeeded.
+func securityError(operation string, status C.OSStatus) error {
+	if status == 0 { // errSecSuccess
+		return nil
+	}
+
+	message := "unknown error"
+	if cMessage := C.copyErrorMessage(status); cMessage != nil {
+		message = C.GoString(cMessage)
+		C.free(unsafe.Pointer(cMessage))
+	}
+	return fmt.Errorf("%s failed: %s (OSStatus %d)", operation, message, int(status))
+}
+
+// cString returns a C copy of s and its length, the copy must be freed by the caller.
+func cString(s string) (*C.char, C.UInt32) {
+	return C.CString(s), C.UInt32(len(s))
+}
+
+// createKeychain creates a new keychain file at
+// path, protected by password. Returns an error
+// if the keychain could not be created, otherwise
+// a Keychain object representing the created
+// keychain is returned.
+func createKeychain(path string, password stepconf.Secret) (*Keychain, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	cPassword, passwordLength := cString(string(password))
+	defer C.free(unsafe.Pointer(cPassword))
+
+	if err := securityError(fmt.Sprintf("creating keychain (%s)", path), C.createKeychain(cPath, cPassword, passwordLength)); err != nil {
+		return nil, err
+	}
+
+	return &Keychain{
+		Path:     path,
+		Password: password,
+	}, nil
+}
+
+// importCertificate adds the certificate at path, protected by
+// passphrase to the k keychain.
+func (k Keychain) importCertificate(path string, passphrase stepconf.Secret) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate (%s): %s", path, err)
+	}
+	if len(content) == 0 {
+		return fmt.Errorf("certificate (%s) is empty", path)
+	}
+
+	cPath := C.CString(k.Path)
+	defer C.free(unsafe.Pointer(cPath))
+	cContent := C.CBytes(content)
+	defer C.free(cContent)
+	cPassphrase := C.CString(string(passphrase))
+	defer C.free(unsafe.Pointer(cPassphrase))
+
+	status := C.importPKCS12(cPath, cContent, C.CFIndex(len(content)), cPassphrase, C.CFIndex(len(passphrase)))
+	if status == -25299 { // errSecDuplicateItem
+		return errCertificateExists
+	}
+	return securityError(fmt.Sprintf("importing certificate (%s) into keychain (%s)", path, k.Path), status)
+}
+
+// setKeyPartitionList sets the partition list
+// for the keychain to allow access for tools.
+func (k Keychain) setKeyPartitionList() error {
+	description, err := keyPartitionListDescription(keyPartitions)
+	if err != nil {
+		return err
+	}
+
+	cPath := C.CString(k.Path)
+	defer C.free(unsafe.Pointer(cPath))
+	cPassword, passwordLength := cString(string(k.Password))
+	defer C.free(unsafe.Pointer(cPassword))
+	cDescription := C.CString(description)
+	defer C.free(unsafe.Pointer(cDescription))
+
+	status := C.setKeyPartitionList(cPath, cPassword, passwordLength, cDescription, C.CFIndex(len(description)))
+	return securityError(fmt.Sprintf("setting key partition list of keychain (%s)", k.Path), status)
+}
+
+// setLockSettings sets keychain autolocking.
+func (k Keychain) setLockSettings() error {
+	cPath := C.CString(k.Path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	status := C.setKeychainLockSettings(cPath, C.UInt32(keychainLockInterval))
+	return securityError(fmt.Sprintf("setting lock settings of keychain (%s)", k.Path), status)
+}
+
+// addToSearchPath registers the keychain
+// in the systemwide search path
+func (k Keychain) addToSearchPath() error {
+	cPath := C.CString(k.Path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	return securityError(fmt.Sprintf("adding keychain (%s) to the search list", k.Path), C.addKeychainToSearchList(cPath))
+}
+
+// setAsDefault sets the keychain as the
+// default keychain for the system.
+func (k Keychain) setAsDefault() error {
+	cPath := C.CString(k.Path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	return securityError(fmt.Sprintf("setting keychain (%s) as default", k.Path), C.setDefaultKeychain(cPath))
+}
+
+// unlock unlocks the keychain
+func (k Keychain) unlock() error {
+	cPath := C.CString(k.Path)
+	defer C.free(unsafe.Pointer(cPath))
+	cPassword, passwordLength := cString(string(k.Password))
+	defer C.free(unsafe.Pointer(cPassword))
+
+	return securityError(fmt.Sprintf("unlocking keychain (%s)", k.Path), C.unlockKeychain(cPath, cPassword, passwordLength))
+}
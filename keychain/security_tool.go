@@ -0,0 +1,126 @@
+//go:build !darwin || !cgo || !security_framework
+// +build !darwin !cgo !security_framework
+
+package keychain
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/stepconf"
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/errorutil"
+)
+
+// The keychain operations are performed by the security tool, unless the Security framework bindings are built
+// (on darwin, with cgo and the security_framework build tag).
+
+func runSecurityCmd(args ...interface{}) error {
+	var printableArgs []string
+	var cmdArgs []string
+	for _, arg := range args {
+		v, ok := arg.(stepconf.Secret)
+		if ok {
+			printableArgs = append(printableArgs, v.String())
+			cmdArgs = append(cmdArgs, string(v))
+		} else if v, ok := arg.(string); ok {
+			printableArgs = append(printableArgs, v)
+			cmdArgs = append(cmdArgs, v)
+		} else if v, ok := arg.([]string); ok {
+			printableArgs = append(printableArgs, v...)
+			cmdArgs = append(cmdArgs, v...)
+		} else {
+			return fmt.Errorf("unknown arg provided: %T, string, []string, and stepconf.Secret are acceptable", arg)
+		}
+	}
+
+	out, err := command.New("security", cmdArgs...).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		if errorutil.IsExitStatusError(err) {
+			return fmt.Errorf("%s failed: %s", command.PrintableCommandArgs(false, append([]string{"security"}, printableArgs...)), out)
+		}
+		return fmt.Errorf("%s failed: %s", command.PrintableCommandArgs(false, append([]string{"security"}, printableArgs...)), err)
+	}
+	return nil
+}
+
+// listKeychains returns the paths of available keychains
+func listKeychains() ([]string, error) {
+	cmd := command.New("security", "list-keychain")
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		if errorutil.IsExitStatusError(err) {
+			return nil, fmt.Errorf("%s failed: %s", cmd.PrintableCommandArgs(), out)
+		}
+		return nil, fmt.Errorf("%s failed: %s", cmd.PrintableCommandArgs(), err)
+	}
+
+	var keychains []string
+	for _, path := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(path)
+		trimmed = strings.Trim(trimmed, `"`)
+		keychains = append(keychains, trimmed)
+	}
+
+	return keychains, nil
+}
+
+// createKeychain creates a new keychain file at
+// path, protected by password. Returns an error
+// if the keychain could not be created, otherwise
+// a Keychain object representing the created
+// keychain is returned.
+func createKeychain(path string, password stepconf.Secret) (*Keychain, error) {
+	err := runSecurityCmd("-v", "create-keychain", "-p", password, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keychain{
+		Path:     path,
+		Password: password,
+	}, nil
+}
+
+// importCertificate adds the certificate at path, protected by
+// passphrase to the k keychain.
+func (k Keychain) importCertificate(path string, passphrase stepconf.Secret) error {
+	err := runSecurityCmd("import", path, "-k", k.Path, "-P", passphrase, "-A")
+	if err != nil && strings.Contains(err.Error(), "already exists in the keychain") {
+		return errCertificateExists
+	}
+	return err
+}
+
+// setKeyPartitionList sets the partition list
+// for the keychain to allow access for tools.
+func (k Keychain) setKeyPartitionList() error {
+	return runSecurityCmd("set-key-partition-list", "-S", strings.Join(keyPartitions, ","), "-k", k.Password, k.Path)
+}
+
+// setLockSettings sets keychain autolocking.
+func (k Keychain) setLockSettings() error {
+	return runSecurityCmd("-v", "set-keychain-settings", "-lut", "72000", k.Path)
+}
+
+// addToSearchPath registers the keychain
+// in the systemwide search path
+func (k Keychain) addToSearchPath() error {
+	keychains, err := listKeychains()
+	if err != nil {
+		return fmt.Errorf("get keychain list: %s", err)
+	}
+
+	return runSecurityCmd("-v", "list-keychains", "-s", keychains)
+}
+
+// setAsDefault sets the keychain as the
+// default keychain for the system.
+func (k Keychain) setAsDefault() error {
+	return runSecurityCmd("-v", "default-keychain", "-s", k.Path)
+}
+
+// unlock unlocks the keychain
+func (k Keychain) unlock() error {
+	return runSecurityCmd("-v", "unlock-keychain", "-p", k.Password, k.Path)
+}
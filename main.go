@@ -7,22 +7,27 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
-	"github.com/bitrise-io/go-steputils/tools"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/retry"
 	"github.com/bitrise-io/go-xcode/certificateutil"
 	"github.com/bitrise-io/xcode-project/serialized"
-	"github.com/bitrise-io/xcode-project/xcodeproj"
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/autoprovision"
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/devportaldata"
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/keychain"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/output"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/provisioningserver"
 )
 
+// maxProfileInstallConcurrency limits the number of profiles decoded and installed at the same time
+const maxProfileInstallConcurrency = 4
+
 // downloadCertificates downloads and parses a list of p12 files
 func downloadCertificates(URLs []CertificateFileURL) ([]certificateutil.CertificateInfoModel, error) {
 	httpClient := &http.Client{
@@ -126,6 +131,15 @@ func needToRegisterDevices(distrTypes []autoprovision.DistributionType) bool {
 	return false
 }
 
+func containsDistributionType(distrTypes []autoprovision.DistributionType, distrType autoprovision.DistributionType) bool {
+	for _, t := range distrTypes {
+		if t == distrType {
+			return true
+		}
+	}
+	return false
+}
+
 func keys(obj map[string]serialized.Object) (s []string) {
 	for key := range obj {
 		s = append(s, key)
@@ -133,212 +147,493 @@ func keys(obj map[string]serialized.Object) (s []string) {
 	return
 }
 
+// runTempDir is the temporary directory of the current Step run, it is removed on failure too
+var runTempDir *RunTempDir
+
+// portalChanges tracks the Developer Portal changes of the current Step run, their audit log is written on failure too
+var portalChanges *autoprovision.PortalChanges
+var auditLogPath string
+
+// lenient is set by the strictness input, in lenient mode the non-critical failures do not fail the Step
+var lenient bool
+
+// ignoredFailures are the non-critical failures ignored in lenient mode, listed at the end of the run
+var ignoredFailures []string
+var ignoredFailuresMu sync.Mutex
+
+// failOrWarn fails the Step in strict mode, in lenient mode it logs a warning and the run continues.
+func failOrWarn(format string, args ...interface{}) {
+	if !lenient {
+		failf(format, args...)
+	}
+	msg := fmt.Sprintf(format, args...)
+	log.Warnf("%s (ignored, strictness: lenient)", msg)
+	ignoredFailuresMu.Lock()
+	ignoredFailures = append(ignoredFailures, msg)
+	ignoredFailuresMu.Unlock()
+}
+
 func failf(format string, args ...interface{}) {
 	log.Errorf(format, args...)
+	writeAuditLog()
+	runTempDir.Cleanup()
 	os.Exit(1)
 }
 
-// ProfileManager ...
-type ProfileManager struct {
-	client                      *appstoreconnect.Client
-	bundleIDByBundleIDIdentifer map[string]*appstoreconnect.BundleID
-	containersByBundleID        map[string][]string
+// writeAuditLog writes the audit log of the Developer Portal changes made in the run, if the path is set
+func writeAuditLog() bool {
+	if portalChanges == nil || auditLogPath == "" {
+		return false
+	}
+	if err := portalChanges.WriteAuditLog(auditLogPath); err != nil {
+		log.Warnf("Failed to write the audit log of the Developer Portal changes: %s", err)
+		return false
+	}
+	return true
 }
 
-// EnsureBundleID ...
-func (m ProfileManager) EnsureBundleID(bundleIDIdentifier string, entitlements serialized.Object) (*appstoreconnect.BundleID, error) {
+// rotateCertificate creates a new certificate of the type for the certificate rotation drill (rotation_drill input).
+// The new certificate is added to the valid certificates, so that the profiles are ensured with both the new and the previous certificates.
+// The certificate signing request is saved to csrPath (if set) before it is submitted, for a review of what was sent to Apple.
+// The new certificate is exported with its private key to the passphrase protected p12Path.
+func rotateCertificate(client *appstoreconnect.Client, portalChanges *autoprovision.PortalChanges, certType appstoreconnect.CertificateType, certsByType map[appstoreconnect.CertificateType][]autoprovision.APICertificate, csrOpts autoprovision.CertificateRequestOptions, csrPath, p12Path, p12Passphrase string) (*autoprovision.RotationRollbackPlan, error) {
 	fmt.Println()
-	log.Infof("  Searching for app ID for bundle ID: %s", bundleIDIdentifier)
+	log.Infof("Certificate rotation drill: creating a new %s certificate", certType)
 
-	bundleID, ok := m.bundleIDByBundleIDIdentifer[bundleIDIdentifier]
-	if !ok {
-		var err error
-		bundleID, err = autoprovision.FindBundleID(m.client, bundleIDIdentifier)
-		if err != nil {
-			return nil, fmt.Errorf("failed to find bundle ID: %s", err)
+	change := autoprovision.PortalChange{Action: autoprovision.CreateCertificateChange, Subject: string(certType), Reason: "certificate rotation drill (rotation_drill input)"}
+	if err := portalChanges.Register(change); err != nil {
+		return nil, err
+	}
+	if portalChanges.IsDryRun() {
+		log.Printf("dry run: the profiles are checked with the current certificates only")
+		return nil, nil
+	}
+
+	csr, privateKey, err := autoprovision.NewCertificateRequest(csrOpts)
+	if err != nil {
+		return nil, err
+	}
+	if csrPath != "" {
+		if err := os.MkdirAll(filepath.Dir(csrPath), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create directory of the certificate signing request: %s", err)
 		}
+		if err := ioutil.WriteFile(csrPath, csr, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write certificate signing request: %s", err)
+		}
+		log.Printf("certificate signing request saved: %s", csrPath)
 	}
 
-	if bundleID != nil {
-		log.Printf("  app ID found: %s", bundleID.Attributes.Name)
+	certificate, err := autoprovision.CreateCertificate(client, certType, csr, privateKey)
+	portalChanges.RecordOutcome(change, err)
+	if err != nil {
+		return nil, err
+	}
+	log.Donef("certificate created: %s, serial: %s, expiry: %s", certificate.Certificate.CommonName, certificate.Certificate.Serial, certificate.Certificate.EndDate)
 
-		m.bundleIDByBundleIDIdentifer[bundleIDIdentifier] = bundleID
+	// the private key only exists in memory, it is exported before anything else could fail
+	if err := autoprovision.ExportCertificate(certificate, p12Path, p12Passphrase); err != nil {
+		return nil, fmt.Errorf("failed to export the new certificate (ID: %s), revoke it on the Developer Portal: %s", certificate.ID, err)
+	}
+	log.Donef("certificate exported: %s", p12Path)
+
+	plan := &autoprovision.RotationRollbackPlan{
+		CertificateType:      certType,
+		NewCertificate:       certificate,
+		PreviousCertificates: certsByType[certType],
+		P12Path:              p12Path,
+		CSRPath:              csrPath,
+	}
+	certsByType[certType] = append(certsByType[certType], certificate)
+	return plan, nil
+}
 
-		// Check if BundleID is sync with the project
-		err := autoprovision.CheckBundleIDEntitlements(m.client, *bundleID, autoprovision.Entitlement(entitlements))
-		if err != nil {
-			if mErr, ok := err.(autoprovision.NonmatchingProfileError); ok {
-				log.Warnf("  app ID capabilities invalid: %s", mErr.Reason)
-				log.Warnf("  app ID capabilities are not in sync with the project capabilities, synchronizing...")
-				if err := autoprovision.SyncBundleID(m.client, bundleID.ID, autoprovision.Entitlement(entitlements)); err != nil {
-					return nil, fmt.Errorf("failed to update bundle ID capabilities: %s", err)
-				}
+// requireDEREntitlements returns true if the installed Xcode requires profiles with DER encoded entitlements.
+// If the Xcode version can not be detected, the profiles are not checked.
+func requireDEREntitlements(xcodebuildTimeout time.Duration) bool {
+	xcodeVersion, err := autoprovision.GetXcodeVersion(xcodebuildTimeout)
+	if err != nil {
+		log.Debugf("Failed to detect Xcode version, skipping the DER encoded entitlements check of the profiles: %s", err)
+		return false
+	}
+	log.Debugf("Xcode version: %s", xcodeVersion.Version)
+	return autoprovision.RequiresDEREntitlements(xcodeVersion.MajorVersion)
+}
 
-				return bundleID, nil
-			}
+// serve runs the step in provisioning server mode: `autoprovision serve`
+func serve() {
+	var serverConf ServerConfig
+	if err := stepconf.Parse(&serverConf); err != nil {
+		failf("Config: %s", err)
+	}
+	stepconf.Print(serverConf)
 
-			return nil, fmt.Errorf("failed to validate bundle ID: %s", err)
-		}
+	privateKey := devportaldata.DevPortalData{PrivateKey: string(serverConf.PrivateKey)}.PrivateKeyWithHeader()
+	server, err := provisioningserver.New(serverConf.KeyID, serverConf.IssuerID, []byte(privateKey), string(serverConf.Token))
+	if err != nil {
+		failf("Failed to create provisioning server: %s", err)
+	}
+
+	if (serverConf.TLSCertFile == "") != (serverConf.TLSKeyFile == "") {
+		failf("Both PROVISIONING_SERVER_TLS_CERT_FILE and PROVISIONING_SERVER_TLS_KEY_FILE have to be set to serve over TLS")
+	}
 
-		log.Printf("  app ID capabilities are in sync with the project capabilities")
+	if serverConf.TLSCertFile != "" {
+		err = server.ListenAndServeTLS(serverConf.Address, serverConf.TLSCertFile, serverConf.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe(serverConf.Address)
+	}
+	if err != nil {
+		failf("Provisioning server failed: %s", err)
+	}
+}
 
-		return bundleID, nil
+// explain prints a human-readable report of the project's code signing setup: `autoprovision explain`
+// It does not require App Store Connect credentials nor certificates.
+func explain() {
+	var explainConf ExplainConfig
+	expandInputTemplates(&explainConf)
+	if err := stepconf.Parse(&explainConf); err != nil {
+		failf("Config: %s", err)
 	}
+	stepconf.Print(explainConf)
 
-	// Create BundleID
-	log.Warnf("  app ID not found, generating...")
+	log.SetEnableDebugLog(explainConf.VerboseLog)
 
-	capabilities := autoprovision.Entitlement(entitlements)
+	fmt.Println()
+	log.Infof("Analyzing project")
 
-	bundleID, err := autoprovision.CreateBundleID(m.client, bundleIDIdentifier)
+	projHelper, config, err := autoprovision.NewProjectHelper(explainConf.ProjectPath, explainConf.Scheme, explainConf.Configuration, explainConf.ConfigurationFallback, time.Duration(explainConf.XcodebuildTimeout)*time.Second)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create bundle ID: %s", err)
+		failf("Failed to analyze project: %s", err)
+	}
+	projHelper.DerivedSourcesDir = explainConf.DerivedSourcesDir
+	if projHelper.ExcludedTargetKinds, err = autoprovision.ParseTargetKinds(explainConf.ExcludeTargetTypes); err != nil {
+		failf("Config: %s", err)
+	}
+	// the xcconfig overrides are written to the temporary directory of the run, removed on exit
+	if runTempDir, err = NewRunTempDir("", false); err != nil {
+		failf("%s", err)
+	}
+	defer runTempDir.Cleanup()
+	runTempDir.CleanupOnSignal()
+	if projHelper.XcconfigPath, err = autoprovision.WriteXcconfig(explainConf.XcconfigContent, runTempDir.Path); err != nil {
+		failf("Failed to write xcconfig: %s", err)
 	}
 
-	containers, err := capabilities.ICloudContainers()
+	reports, err := projHelper.SigningReport(explainConf.DistributionType())
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get list of iCloud containers: %s", err)
+		failf("Failed to analyze code signing: %s", err)
 	}
 
-	if len(containers) > 0 {
-		m.containersByBundleID[bundleIDIdentifier] = containers
-		log.Errorf("  app ID created but couldn't add iCloud containers: %v", containers)
+	log.Printf("scheme: %s, configuration: %s, distribution type: %s", explainConf.Scheme, config, explainConf.DistributionType())
+	for _, report := range reports {
+		fmt.Println()
+		log.Printf("%s", strings.TrimSuffix(report.String(), "\n"))
 	}
+}
 
-	if err := autoprovision.SyncBundleID(m.client, bundleID.ID, capabilities); err != nil {
-		return nil, fmt.Errorf("failed to update bundle ID capabilities: %s", err)
+// migrate runs the bundle ID migration mode: `autoprovision migrate`
+// It carries the capabilities of the old app IDs over to the new ones, generates Bitrise managed profiles for the new app IDs
+// and prints a checklist of the steps the App Store Connect API can not do.
+func migrate() {
+	var migrateConf MigrateConfig
+	expandInputTemplates(&migrateConf)
+	if err := stepconf.Parse(&migrateConf); err != nil {
+		failf("Config: %s", err)
 	}
+	stepconf.Print(migrateConf)
 
-	m.bundleIDByBundleIDIdentifer[bundleIDIdentifier] = bundleID
-
-	return bundleID, nil
-}
-
-// EnsureProfile ...
-func (m ProfileManager) EnsureProfile(profileType appstoreconnect.ProfileType, bundleIDIdentifier string, entitlements serialized.Object, certIDs, deviceIDs []string, minProfileDaysValid int) (*appstoreconnect.Profile, error) {
-	fmt.Println()
-	log.Infof("  Checking bundle id: %s", bundleIDIdentifier)
-	log.Printf("  capabilities: %s", entitlements)
+	log.SetEnableDebugLog(migrateConf.VerboseLog)
 
-	// Search for Bitrise managed Profile
-	name, err := autoprovision.ProfileName(profileType, bundleIDIdentifier)
+	migrations, err := autoprovision.ParseBundleIDMigrations(migrateConf.Migrations)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create profile name: %s", err)
+		failf("Config: %s", err)
 	}
-
-	profile, err := autoprovision.FindProfile(m.client, name, profileType, bundleIDIdentifier)
+	distributionTypes, err := migrateConf.DistributionTypes()
 	if err != nil {
-		return nil, fmt.Errorf("failed to find profile: %s", err)
+		failf("Config: %s", err)
 	}
 
-	if profile == nil {
-		log.Warnf("  profile does not exist, generating...")
+	var client *appstoreconnect.Client
+	if migrateConf.ProvisioningServerURL != "" {
+		client, err = appstoreconnect.NewRemoteClient(http.DefaultClient, migrateConf.ProvisioningServerURL, string(migrateConf.ProvisioningServerToken))
+		if err != nil {
+			failf("Failed to create provisioning server client: %s", err)
+		}
 	} else {
-		log.Printf("  Bitrise managed profile found: %s", profile.Attributes.Name)
+		if migrateConf.KeyID == "" || migrateConf.IssuerID == "" || migrateConf.PrivateKey == "" {
+			failf("Config: APPSTORECONNECT_API_KEY_ID, APPSTORECONNECT_API_ISSUER_ID and APPSTORECONNECT_API_PRIVATE_KEY are required, if provisioning_server_url is not set")
+		}
+		privateKey := devportaldata.DevPortalData{PrivateKey: string(migrateConf.PrivateKey)}.PrivateKeyWithHeader()
+		client = appstoreconnect.NewClient(http.DefaultClient, migrateConf.KeyID, migrateConf.IssuerID, []byte(privateKey))
+	}
+	client.EnableDebugLogs = false
 
-		if profile.Attributes.ProfileState == appstoreconnect.Active {
-			// Check if Bitrise managed Profile is sync with the project
-			err := autoprovision.CheckProfile(m.client, *profile, autoprovision.Entitlement(entitlements), deviceIDs, certIDs, minProfileDaysValid)
-			if err != nil {
-				if mErr, ok := err.(autoprovision.NonmatchingProfileError); ok {
-					log.Warnf("  the profile is not in sync with the project requirements (%s), regenerating ...", mErr.Reason)
-				} else {
-					return nil, fmt.Errorf("failed to check if profile is valid: %s", err)
-				}
-			} else { // Profile matches
-				log.Donef("  profile is in sync with the project requirements")
-				return profile, nil
+	portalChanges := autoprovision.NewPortalChanges(0)
+	portalChanges.Actor = client.KeyID
+
+	var devices []appstoreconnect.Device
+	if needToRegisterDevices(distributionTypes) {
+		allDevices, err := autoprovision.ListDevices(client, "", appstoreconnect.IOSDevice)
+		if err != nil {
+			failf("Failed to list devices: %s", err)
+		}
+		for _, device := range allDevices {
+			if autoprovision.DeviceMatchesPlatform(device, autoprovision.IOS) {
+				devices = append(devices, device)
 			}
 		}
+	}
 
-		if profile.Attributes.ProfileState == appstoreconnect.Invalid {
-			// If the profile's bundle id gets modified, the profile turns in Invalid state.
-			log.Warnf("  the profile state is invalid, regenerating ...")
-		}
+	var results []autoprovision.BundleIDMigrationResult
+	for _, migration := range migrations {
+		fmt.Println()
+		log.Infof("Migrating %s", migration)
 
-		if err := autoprovision.DeleteProfile(m.client, profile.ID); err != nil {
-			return nil, fmt.Errorf("failed to delete profile: %s", err)
+		result, err := autoprovision.MigrateBundleID(client, migration, portalChanges)
+		if err != nil {
+			failf("Failed to migrate app ID: %s", err)
 		}
-	}
+		log.Printf("app ID: %s, capabilities: %v", result.BundleID.Attributes.Identifier, result.Capabilities)
 
-	// Search for BundleID
-	bundleID, err := m.EnsureBundleID(bundleIDIdentifier, entitlements)
-	if err != nil {
-		return nil, err
-	}
+		for _, distributionType := range distributionTypes {
+			profileType := autoprovision.PlatformToProfileTypeByDistribution[autoprovision.IOS][distributionType]
+			certificateType, _ := autoprovision.CertificateType(autoprovision.IOS, distributionType)
 
-	// Create Bitrise managed Profile
-	fmt.Println()
-	log.Infof("  Creating profile for bundle id: %s", bundleID.Attributes.Name)
+			name, err := autoprovision.ProfileName(profileType, migration.New)
+			if err != nil {
+				failf("Failed to create profile name: %s", err)
+			}
 
-	profile, err = autoprovision.CreateProfile(m.client, name, profileType, *bundleID, certIDs, deviceIDs)
-	if err != nil {
-		// Expired profiles are not listed via profiles endpoint,
-		// so we can not catch if the profile already exist but expired, before we attempt to create one with the managed profile name.
-		// As a workaround we use the BundleID profiles relationship url to find and delete the expired profile.
-		if isMultipleProfileErr(err) {
-			log.Warnf("  Profile already exists, but expired, cleaning up...")
-			if err := m.deleteExpiredProfile(bundleID, name); err != nil {
-				return nil, fmt.Errorf("expired profile cleanup failed: %s", err)
+			profile, err := autoprovision.FindProfile(client, name, profileType, migration.New)
+			if err != nil {
+				failf("Failed to find profile: %s", err)
+			}
+			if profile != nil {
+				log.Printf("%s profile already exists: %s", distributionType, name)
+				continue
 			}
 
-			profile, err = autoprovision.CreateProfile(m.client, name, profileType, *bundleID, certIDs, deviceIDs)
+			certificateIDs, err := autoprovision.CertificateIDs(client, certificateType)
 			if err != nil {
-				return nil, fmt.Errorf("failed to create profile: %s", err)
+				failf("Failed to list %s certificates: %s", certificateType, err)
+			}
+			if len(certificateIDs) == 0 {
+				log.Warnf("No %s certificate found, skipping the %s profile", certificateType, distributionType)
+				continue
 			}
 
-			log.Donef("  profile created: %s", profile.Attributes.Name)
+			var deviceIDs []string
+			if needToRegisterDevices([]autoprovision.DistributionType{distributionType}) {
+				for _, device := range devices {
+					deviceIDs = append(deviceIDs, device.ID)
+				}
+			}
 
-			return profile, nil
+			change := autoprovision.PortalChange{Action: autoprovision.CreateProfileChange, Subject: name, BundleID: migration.New, Reason: "bundle ID migration: " + migration.String()}
+			if err := portalChanges.Register(change); err != nil {
+				failf("%s", err)
+			}
+			_, err = autoprovision.CreateProfile(client, name, profileType, result.BundleID, certificateIDs, deviceIDs)
+			portalChanges.RecordOutcome(change, err)
+			if err != nil {
+				failf("Failed to create profile: %s", err)
+			}
+			log.Donef("%s profile created: %s", distributionType, name)
 		}
 
-		return nil, fmt.Errorf("failed to create profile: %s", err)
+		results = append(results, result)
 	}
 
-	log.Donef("  profile created: %s", profile.Attributes.Name)
-
-	return profile, nil
+	fmt.Println()
+	log.Infof("Migration checklist")
+	for _, result := range results {
+		log.Printf("%s:", result.Migration)
+		for _, item := range result.Checklist {
+			log.Printf("- [ ] %s", item)
+		}
+	}
 }
 
-func (m ProfileManager) deleteExpiredProfile(bundleID *appstoreconnect.BundleID, profileName string) error {
-	var nextPageURL string
-	var profile *appstoreconnect.Profile
+// connectDeveloperPortal creates the App Store Connect API client and reads the Developer Portal state of the previous run
+func connectDeveloperPortal(stepConf Config) (*appstoreconnect.Client, *devportaldata.DevPortalData, *autoprovision.Session) {
+	// Creating AppstoreConnectAPI client
+	fmt.Println()
+	log.Infof("Creating AppstoreConnectAPI client")
 
-	for {
-		response, err := m.client.Provisioning.Profiles(bundleID.Relationships.Profiles.Links.Related, &appstoreconnect.PagingOptions{
-			Limit: 20,
-			Next:  nextPageURL,
-		})
+	devPortalDataDownloader := devportaldata.NewDownloader(stepConf.BuildURL, stepConf.BuildAPIToken)
+	devPortalDataDownloader.SkipAPIKeyValidation = stepConf.ProvisioningServerURL != "" || stepConf.ExternalAPIKey()
+	devPortalData, err := devPortalDataDownloader.GetDevPortalData()
+	if err != nil {
+		failf("Failed get developer portal data: %s", err)
+	}
+	if !devPortalDataDownloader.SkipAPIKeyValidation {
+		warnings, err := devPortalData.Validate(stepConf.TeamID, time.Now())
+		if err != nil {
+			failf("%s", err)
+		}
+		for _, warning := range warnings {
+			log.Warnf("%s", warning)
+		}
+	}
+
+	var client *appstoreconnect.Client
+	if stepConf.ProvisioningServerURL != "" {
+		log.Printf("Using provisioning server: %s", stepConf.ProvisioningServerURL)
+
+		client, err = appstoreconnect.NewRemoteClient(http.DefaultClient, stepConf.ProvisioningServerURL, string(stepConf.ProvisioningServerToken))
+		if err != nil {
+			failf("Failed to create provisioning server client: %s", err)
+		}
+	} else if stepConf.ExternalAPIKey() {
+		keyID, issuerID, err := apiKeyIdentifiers(stepConf, *devPortalData)
+		if err != nil {
+			failf("%s", err)
+		}
+
+		signer, err := apiKeySigner(stepConf)
 		if err != nil {
-			return err
+			failf("Failed to load API private key: %s", err)
 		}
 
-		for _, d := range response.Data {
-			if d.Attributes.Name == profileName {
-				profile = &d
-				break
+		client = appstoreconnect.NewClientWithSigner(http.DefaultClient, keyID, issuerID, signer)
+	} else {
+		client = appstoreconnect.NewClient(http.DefaultClient, devPortalData.KeyID, devPortalData.IssuerID, []byte(devPortalData.PrivateKeyWithHeader()))
+	}
+
+	if stepConf.SecondaryAPIKeyID != "" {
+		issuerID := stepConf.SecondaryAPIIssuerID
+		if issuerID == "" {
+			issuerID = devPortalData.IssuerID
+			if stepConf.APIIssuerID != "" {
+				issuerID = stepConf.APIIssuerID
 			}
 		}
+		privateKey := devportaldata.DevPortalData{PrivateKey: string(stepConf.SecondaryAPIPrivateKey)}.PrivateKeyWithHeader()
+		client.SetFailoverKey(stepConf.SecondaryAPIKeyID, issuerID, []byte(privateKey))
+		log.Printf("Secondary API key (%s) is set, it is used if the API key (%s) gets unauthorized", stepConf.SecondaryAPIKeyID, client.KeyID())
+	}
+
+	if stepConf.EnterpriseAPI() {
+		if stepConf.ProvisioningServerURL != "" {
+			log.Printf("Apple Developer Enterprise Program account, the provisioning server needs to use the Enterprise Program API")
+		} else {
+			client.UseEnterpriseAPI()
+			log.Printf("Apple Developer Enterprise Program account, using the Enterprise Program API: %s", client.BaseURL)
+		}
+	}
+
+	if stepConf.APIRetryMaxAttempts > 0 {
+		client.SetRetryPolicy(stepConf.APIRetryMaxAttempts, time.Duration(stepConf.APIRetryWaitSeconds)*time.Second)
+	}
+
+	if stepConf.DryRun {
+		client.SetReadOnly()
+		log.Warnf("Dry run: the Developer Portal changes are only planned, the Step does not make them")
+	}
+
+	// Turn off client debug logs includeing HTTP call debug logs
+	client.EnableDebugLogs = false
+
+	log.Donef("the client created for %s", client.BaseURL)
 
-		nextPageURL = response.Links.Next
-		if nextPageURL == "" {
-			break
+	if err := client.CheckAuthentication(); err != nil {
+		failf("App Store Connect API authentication failed: %s", err)
+	}
+
+	session := autoprovision.NewSession(sessionAccount(stepConf, *devPortalData))
+	if stepConf.SessionPath != "" {
+		session, err = autoprovision.ReadSession(stepConf.SessionPath, session.Account, time.Now())
+		if err != nil {
+			failf("%s", err)
+		}
+		if !session.UpdatedAt.IsZero() {
+			log.Printf("Reusing the Developer Portal state of the previous run: %s", stepConf.SessionPath)
 		}
 	}
 
-	if profile == nil {
-		return fmt.Errorf("failed to find profile: %s", profileName)
+	return client, devPortalData, session
+}
+
+// adhocSigning writes the ad-hoc signing xcconfig for the simulator and unit test builds (signing_mode: adhoc),
+// the Developer Portal is not reached and no certificate or profile is installed.
+func adhocSigning(stepConf Config, outputExporter output.Exporter) {
+	fmt.Println()
+	log.Infof("Configuring ad-hoc code signing")
+	log.Printf("No provisioning profile is used, the builds run on the simulator and in unit tests only")
+
+	xcconfigPath, err := autoprovision.WriteAdhocSigningXcconfig(stepConf.AdhocXcconfigPath)
+	if err != nil {
+		failf("%s", err)
+	}
+	log.Donef("ad-hoc signing xcconfig written: %s", xcconfigPath)
+	log.Printf("Pass it to xcodebuild with the -xcconfig flag")
+
+	outputs := map[string]string{
+		"BITRISE_ADHOC_SIGNING_XCCONFIG_PATH": xcconfigPath,
 	}
+	for k, v := range outputs {
+		log.Donef("%s=%s", k, v)
+	}
+	if err := outputExporter.Export(outputs); err != nil {
+		failf("Failed to export outputs: %s", err)
+	}
+}
 
-	return m.client.Provisioning.DeleteProfile(profile.ID)
+// sessionAccount identifies the account, the Developer Portal state of the session belongs to
+func sessionAccount(conf Config, devPortalData devportaldata.DevPortalData) string {
+	if conf.ProvisioningServerURL != "" {
+		return conf.ProvisioningServerURL
+	}
+	if conf.APIKeyID != "" {
+		return conf.APIKeyID
+	}
+	return devPortalData.KeyID
 }
 
-func isMultipleProfileErr(err error) bool {
-	return strings.Contains(strings.ToLower(err.Error()), "multiple profiles found with the name")
+// writeSession saves the session to the given path, or to a new file in the system temp dir if the path is empty.
+// It returns the path of the session file.
+func writeSession(session *autoprovision.Session, pth string) (string, error) {
+	if pth == "" {
+		f, err := ioutil.TempFile("", "auto-provision-session-*.json")
+		if err != nil {
+			return "", err
+		}
+		if err := f.Close(); err != nil {
+			return "", err
+		}
+		pth = f.Name()
+	}
+
+	return pth, session.Write(pth, time.Now())
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		explain()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--list-distribution-types" {
+		fmt.Print(autoprovision.DistributionTypesTable())
+		return
+	}
+
+	if interactiveModeEnabled() {
+		if err := promptMissingInputs(os.Stdin, os.Stdout); err != nil {
+			failf("Interactive mode: %s", err)
+		}
+	}
+
 	var stepConf Config
+	expandInputTemplates(&stepConf)
 	if err := stepconf.Parse(&stepConf); err != nil {
 		failf("Config: %s", err)
 	}
@@ -346,39 +641,100 @@ func main() {
 
 	log.SetEnableDebugLog(stepConf.VerboseLog)
 
-	// Creating AppstoreConnectAPI client
-	fmt.Println()
-	log.Infof("Creating AppstoreConnectAPI client")
-
-	devPortalDataDownloader := devportaldata.NewDownloader(stepConf.BuildURL, stepConf.BuildAPIToken)
-	devPortalData, err := devPortalDataDownloader.GetDevPortalData()
+	if err := stepConf.ValidateAPIKey(); err != nil {
+		failf("Config: %s", err)
+	}
+	if err := stepConf.ValidateSecondaryAPIKey(); err != nil {
+		failf("Config: %s", err)
+	}
+	if err := stepConf.ValidateCacheTTL(); err != nil {
+		failf("Config: %s", err)
+	}
+	if err := stepConf.ValidateProfileConcurrency(); err != nil {
+		failf("Config: %s", err)
+	}
+	if err := stepConf.ValidateMaxPortalChanges(); err != nil {
+		failf("Config: %s", err)
+	}
+	if err := stepConf.ValidateAPIRetry(); err != nil {
+		failf("Config: %s", err)
+	}
+	if err := stepConf.ValidateEnterpriseAccount(); err != nil {
+		failf("Config: %s", err)
+	}
+	if err := stepConf.ValidateOnlineInputs(); err != nil {
+		failf("Config: %s", err)
+	}
+	managedResources, err := stepConf.ManagedResources()
 	if err != nil {
-		failf("Failed get developer portal data: %s", err)
+		failf("Config: %s", err)
+	}
+	lenient = stepConf.Strictness == "lenient"
+	outputExporter, err := output.NewExporter(output.Format(stepConf.OutputFormat), stepConf.DotenvPath)
+	if err != nil {
+		failf("Config: %s", err)
 	}
 
-	client := appstoreconnect.NewClient(http.DefaultClient, devPortalData.KeyID, devPortalData.IssuerID, []byte(devPortalData.PrivateKeyWithHeader()))
+	runTempDir, err = NewRunTempDir("", stepConf.KeepTempDir)
+	if err != nil {
+		failf("%s", err)
+	}
+	defer runTempDir.Cleanup()
+	runTempDir.CleanupOnSignal()
+	log.Debugf("Temporary directory: %s", runTempDir.Path)
 
-	// Turn off client debug logs includeing HTTP call debug logs
-	client.EnableDebugLogs = false
+	if stepConf.AdhocSigning() {
+		adhocSigning(stepConf, outputExporter)
+		return
+	}
 
-	log.Donef("the client created for %s", client.BaseURL)
+	var client *appstoreconnect.Client
+	var devPortalData *devportaldata.DevPortalData
+	var session *autoprovision.Session
+	if stepConf.Offline() {
+		fmt.Println()
+		log.Infof("Using the offline assets: %s", stepConf.OfflineAssetsDir)
+		log.Printf("The Developer Portal is not reached, the profiles and certificates of the directory are installed as they are")
+	} else {
+		client, devPortalData, session = connectDeveloperPortal(stepConf)
+	}
 
 	// Analyzing project
 	fmt.Println()
 	log.Infof("Analyzing project")
 
-	projHelper, config, err := autoprovision.NewProjectHelper(stepConf.ProjectPath, stepConf.Scheme, stepConf.Configuration)
+	projHelper, config, err := autoprovision.NewProjectHelper(stepConf.ProjectPath, stepConf.Scheme, stepConf.Configuration, stepConf.ConfigurationFallback, time.Duration(stepConf.XcodebuildTimeout)*time.Second)
 	if err != nil {
 		failf("Failed to analyze project: %s", err)
 	}
+	projHelper.DerivedSourcesDir = stepConf.DerivedSourcesDir
+	projHelper.SkipUnresolvableTargets = stepConf.SkipUnresolvableTargets
+	if projHelper.ExcludedTargetKinds, err = autoprovision.ParseTargetKinds(stepConf.ExcludeTargetTypes); err != nil {
+		failf("Config: %s", err)
+	}
+	if projHelper.XcconfigPath, err = autoprovision.WriteXcconfig(stepConf.XcconfigContent, runTempDir.Path); err != nil {
+		failf("Failed to write xcconfig: %s", err)
+	}
 
 	log.Printf("configuration: %s", config)
 
-	teamID, err := projHelper.ProjectTeamID(config)
+	targetTeams, err := projHelper.TargetTeams(config)
 	if err != nil {
 		failf("Failed to read project team ID: %s", err)
 	}
 
+	log.Printf("target development teams:")
+	log.Printf("%s", strings.TrimSuffix(autoprovision.TargetTeamsTable(targetTeams), "\n"))
+
+	teamID, err := autoprovision.CommonTeamID(targetTeams)
+	if err != nil {
+		if stepConf.TeamID == "" {
+			failf("%s\nSet the Developer Portal team ID (team_id) input to provision for the given team anyway.", err)
+		}
+		log.Warnf("%s", err)
+		log.Warnf("Proceeding with the Developer Portal team ID (team_id) input: %s", stepConf.TeamID)
+	}
+
 	log.Printf("project team ID: %s", teamID)
 
 	entitlementsByBundleID, err := projHelper.ArchivableTargetBundleIDToEntitlements()
@@ -386,77 +742,380 @@ func main() {
 		failf("Failed to read bundle ID entitlements: %s", err)
 	}
 
+	appClipBundleIDs := map[string]bool{}
+	if bundleIDs, err := projHelper.AppClipBundleIDs(); err != nil {
+		failf("Failed to read the App Clip targets: %s", err)
+	} else if len(bundleIDs) > 0 {
+		parentBundleID, err := projHelper.TargetBundleID(projHelper.MainTarget.Name, config)
+		if err != nil {
+			failf("Failed to read bundle ID for the main target: %s", err)
+		}
+		for _, bundleID := range bundleIDs {
+			entitlements, err := autoprovision.AppClipEntitlements(entitlementsByBundleID[bundleID], bundleID, parentBundleID)
+			if err != nil {
+				failf("%s", err)
+			}
+			log.Printf("App Clip target bundle ID: %s, parent app: %s", bundleID, parentBundleID)
+			entitlementsByBundleID[bundleID] = entitlements
+			appClipBundleIDs[bundleID] = true
+		}
+	}
+
 	log.Printf("bundle IDs:")
 	for _, id := range keys(entitlementsByBundleID) {
 		log.Printf("- %s", id)
 	}
-
-	if ok, entitlement, bundleID := autoprovision.CanGenerateProfileWithEntitlements(entitlementsByBundleID); !ok {
-		log.Errorf("Can not create profile with unsupported entitlement (%s) for the bundle ID %s, due to App Store Connect API limitations.", entitlement, bundleID)
-		failf("Please generate provisioning profile manually on Apple Developer Portal and use the Certificate and profile installer Step instead.")
+	unmappedEntitlements := autoprovision.UnmappedEntitlementKeys(entitlementsByBundleID)
+	capabilityMatrix := autoprovision.NewCapabilityMatrix(entitlementsByBundleID)
+
+	// the app ID capabilities used by any configuration are kept
+	var reconciledEntitlementsByBundleID map[string]serialized.Object
+	if stepConf.ReconcileCapabilities && !stepConf.Offline() {
+		if reconciledEntitlementsByBundleID, err = projHelper.BundleIDEntitlementsOfAllConfigurations(entitlementsByBundleID); err != nil {
+			failf("Failed to read the entitlements of the configurations for reconciling the app ID capabilities: %s", err)
+		}
 	}
 
-	platform, err := projHelper.Platform(config)
-	if err != nil {
-		failf("Failed to read project platform: %s", err)
+	if extensionBundleIDs, err := projHelper.ExtensionBundleIDs(); err != nil {
+		log.Warnf("Failed to list the app extensions: %s", err)
+	} else if hostBundleID, err := projHelper.TargetBundleID(projHelper.MainTarget.Name, config); err != nil {
+		log.Warnf("Failed to read bundle ID for the main target: %s", err)
+	} else if mismatches := autoprovision.SharedGroupMismatches(hostBundleID, extensionBundleIDs, entitlementsByBundleID); len(mismatches) > 0 {
+		fmt.Println()
+		log.Warnf("App extensions without an app group or keychain access group in common with the host app:")
+		for _, mismatch := range mismatches {
+			log.Warnf("- %s", mismatch)
+		}
+		log.Warnf("The extensions can not share data with the host app through these groups, add a common group to both entitlements files if they need to communicate")
 	}
 
-	log.Printf("platform: %s", platform)
+	// the profiles of the offline assets are generated with the entitlements requiring Apple's approval already
+	if ok, entitlement, bundleID := autoprovision.CanGenerateProfileWithEntitlements(entitlementsByBundleID); !ok && !stepConf.Offline() {
+		approvals, err := autoprovision.CheckEntitlementApprovals(client, entitlementsByBundleID)
+		if err != nil {
+			log.Warnf("Failed to check the approval status of the entitlements: %s", err)
+		}
 
-	// Downloading certificates
-	fmt.Println()
-	log.Infof("Downloading certificates")
+		log.Printf("Approval status of the entitlements requiring Apple's approval:")
+		for _, approval := range approvals {
+			log.Printf("- %s", approval)
+		}
 
-	certURLs, err := stepConf.CertificateFileURLs()
-	if err != nil {
-		failf("Failed to convert certificate URLs: %s", err)
+		if err != nil || !autoprovision.AllApprovalsGranted(approvals) {
+			log.Errorf("Can not create profile with unsupported entitlement (%s) for the bundle ID %s, due to App Store Connect API limitations.", entitlement, bundleID)
+			failf("Please generate provisioning profile manually on Apple Developer Portal and use the Certificate and profile installer Step instead.")
+		}
+
+		log.Donef("Every entitlement requiring Apple's approval is granted")
 	}
 
-	certs, err := downloadCertificates(certURLs)
+	platform, err := projHelper.Platform(config)
 	if err != nil {
-		failf("Failed to download certificates: %s", err)
+		failf("Failed to read project platform: %s", err)
 	}
 
-	log.Printf("%d certificates downloaded:", len(certs))
+	log.Printf("platform: %s", platform)
 
-	for _, cert := range certs {
-		log.Printf("- %s", cert.CommonName)
+	var macCatalyst bool
+	if platform == autoprovision.IOS {
+		macCatalyst, err = projHelper.SupportsMacCatalyst(config)
+		if err != nil {
+			failf("Failed to read Mac Catalyst support: %s", err)
+		}
+		if macCatalyst {
+			log.Printf("Mac Catalyst supported, ensuring Mac Catalyst profiles too")
+		}
 	}
 
-	certType, ok := autoprovision.CertificateTypeByDistribution[stepConf.DistributionType()]
-	if !ok {
-		failf("No valid certificate provided for distribution type: %s", stepConf.DistributionType())
+	watchBundleIDs := map[string]bool{}
+	if platform == autoprovision.IOS {
+		bundleIDs, err := projHelper.WatchBundleIDs()
+		if err != nil {
+			failf("Failed to read the watchOS targets: %s", err)
+		}
+		for _, bundleID := range bundleIDs {
+			log.Printf("watchOS target bundle ID: %s, its profiles include the registered Apple Watches", bundleID)
+			watchBundleIDs[bundleID] = true
+		}
 	}
 
-	distrTypes := []autoprovision.DistributionType{stepConf.DistributionType()}
-	requiredCertTypes := map[appstoreconnect.CertificateType]bool{certType: true}
-	if stepConf.DistributionType() != autoprovision.Development {
-		distrTypes = append(distrTypes, autoprovision.Development)
-		requiredCertTypes[appstoreconnect.IOSDevelopment] = false
-	}
+	var certs []certificateutil.CertificateInfoModel
+	if stepConf.Offline() {
+		// Reading certificates
+		fmt.Println()
+		log.Infof("Reading offline certificates")
 
-	certClient := autoprovision.APIClient(client)
-	certsByType, err := autoprovision.GetValidCertificates(certs, certClient, requiredCertTypes, teamID, stepConf.VerboseLog)
-	if err != nil {
-		if missingCertErr, ok := err.(autoprovision.MissingCertificateError); ok {
+		certs, err = autoprovision.ReadOfflineCertificates(stepConf.OfflineAssetsDir, stepConf.OfflinePassphrases())
+		if err != nil {
+			failf("Failed to read offline certificates: %s", err)
+		}
+
+		log.Printf("%d certificates read:", len(certs))
+	} else {
+		// Downloading certificates
+		fmt.Println()
+		log.Infof("Downloading certificates")
+
+		certURLs, err := stepConf.CertificateFileURLs()
+		if err != nil {
+			failf("Failed to convert certificate URLs: %s", err)
+		}
+
+		certs, err = downloadCertificates(certURLs)
+		if err != nil {
+			failf("Failed to download certificates: %s", err)
+		}
+
+		log.Printf("%d certificates downloaded:", len(certs))
+	}
+
+	for _, cert := range certs {
+		log.Printf("- %s", cert.CommonName)
+	}
+
+	// nil in offline mode, the team is selected by the certificates only
+	var apiTeams []autoprovision.Team
+	if !stepConf.Offline() {
+		if apiTeams, err = autoprovision.APITeams(client); err != nil {
+			failf("Failed to list the teams of the API key: %s", err)
+		}
+	}
+
+	if teamID, err = autoprovision.SelectTeam(stepConf.TeamID, teamID, apiTeams, certs); err != nil {
+		failf("Failed to select development team: %s", err)
+	}
+	if teamID != "" {
+		log.Printf("development team ID: %s", teamID)
+	}
+
+	var buildCache *autoprovision.BuildCache
+	if stepConf.CacheDir != "" && !stepConf.Offline() {
+		buildCache = autoprovision.NewBuildCache(stepConf.CacheDir, teamID, time.Duration(stepConf.CacheTTLHours)*time.Hour)
+		if seeded := buildCache.SeedSession(session, time.Now()); seeded > 0 {
+			log.Printf("%d app ID(s) and certificate(s) reused from the build cache (%s)", seeded, stepConf.CacheDir)
+		}
+	}
+
+	requiredCertTypes, distrTypes, err := autoprovision.RequiredCertificateTypes(platform, stepConf.DistributionType())
+	if err != nil {
+		failf("No valid certificate provided for distribution type: %s", stepConf.DistributionType())
+	}
+	certType, _ := autoprovision.CertificateType(platform, stepConf.DistributionType())
+	installerCertType, needsInstallerCert := autoprovision.InstallerCertificateType(platform, stepConf.DistributionType())
+
+	if !stepConf.Offline() {
+		portalChanges = autoprovision.NewPortalChanges(stepConf.MaxPortalChanges)
+		portalChanges.Policy = stepConf.PortalChangePolicy()
+		portalChanges.Actor = func() string {
+			// the key of the client changes after a failover to the secondary key, a provisioning server client has no key
+			if keyID := client.KeyID(); keyID != "" {
+				return keyID
+			}
+			return sessionAccount(stepConf, *devPortalData)
+		}
+		portalChanges.DryRun = stepConf.DryRun
+		auditLogPath = stepConf.AuditLogPath
+	}
+
+	provisioner := autoprovision.NewAutoProvisioner(client, session, portalChanges)
+	provisioner.VerboseLog = stepConf.VerboseLog
+	provisioner.ProfileConcurrency = stepConf.ProfileConcurrency
+	if lenient {
+		provisioner.IgnoreFailure = failOrWarn
+	}
+
+	// the certificates are only selected to sign the profiles or to install them
+	selectCertificates := managedResources[autoprovision.ManageProfiles] || managedResources[autoprovision.ManageCertificates]
+
+	certsByType := map[appstoreconnect.CertificateType][]autoprovision.APICertificate{}
+	if !selectCertificates {
+		log.Printf("Skipping the certificate selection, the Step manages neither the profiles nor the certificates (manage input: %s)", managedResources)
+	} else if stepConf.Offline() {
+		certsByType, err = autoprovision.GetValidOfflineCertificates(certs, requiredCertTypes, teamID)
+	} else {
+		certsByType, distrTypes, err = provisioner.EnsureCertificates(certs, platform, stepConf.DistributionType(), teamID)
+	}
+	if err != nil {
+		if missingCertErr, ok := err.(autoprovision.MissingCertificateError); ok {
 			log.Errorf(err.Error())
 			log.Warnf("Maybe you forgot to provide a(n) %s type certificate.", missingCertErr.Type)
 			log.Warnf("Upload a %s type certificate (.p12) on the Code Signing tab of the Workflow Editor.", missingCertErr.Type)
+			if stepConf.DistributionType() != autoprovision.Development {
+				log.Warnf("Personal (free) Apple developer accounts can not create distribution certificates, use the development distribution type with them.")
+			}
+			runTempDir.Cleanup()
 			os.Exit(1)
 		}
 		failf("Failed to get valid certificates: %s", err)
 	}
 
-	if len(certsByType) == 1 && stepConf.DistributionType() != autoprovision.Development {
+	if stepConf.Offline() && selectCertificates {
 		// remove development distribution if there is no development certificate uploaded
-		distrTypes = []autoprovision.DistributionType{stepConf.DistributionType()}
+		distrTypes = autoprovision.DistributionTypesWithCertificates(platform, distrTypes, certsByType)
 	}
 	log.Printf("ensuring codesigning files for distribution types: %s", distrTypes)
 
+	bundleIDByBundleIDIdentifer := map[string]*appstoreconnect.BundleID{}
+	var offlineProfiles []autoprovision.OfflineProfile
+	if stepConf.Offline() {
+		offlineProfiles, err = autoprovision.ReadOfflineProfiles(stepConf.OfflineAssetsDir)
+		if err != nil {
+			failf("Failed to read offline profiles: %s", err)
+		}
+		log.Printf("%d offline profiles read", len(offlineProfiles))
+	} else if managedResources[autoprovision.ManageProfiles] {
+		foundBundleIDs, err := session.FindBundleIDs(client, keys(entitlementsByBundleID))
+		if err != nil {
+			failf("Failed to find bundle IDs: %s", err)
+		}
+		for identifier := range foundBundleIDs {
+			bundleID := foundBundleIDs[identifier]
+			bundleIDByBundleIDIdentifer[identifier] = &bundleID
+		}
+	}
+
+	profileManager := autoprovision.NewProfileManager(client, session, portalChanges, bundleIDByBundleIDIdentifer)
+	provisioner.Profiles = profileManager
+	profileManager.ProfileQuotaLimit = stepConf.ProfileQuotaLimit
+	profileManager.ProfileCleanup = stepConf.ProfileCleanup
+	profileManager.ProfileNameCollision = stepConf.ProfileNameCollisionPolicy()
+	profileManager.RequireDEREntitlements = requireDEREntitlements(time.Duration(stepConf.XcodebuildTimeout) * time.Second)
+	profileManager.CapabilityMatrix = capabilityMatrix
+	profileManager.BuildCache = buildCache
+	if lenient {
+		profileManager.IgnoreFailure = failOrWarn
+	}
+
+	// the Bitrise test devices to register
+	devicePlatform := autoprovision.DevicePlatform(platform)
+	var bitriseTestDevices []autoprovision.TestDevice
+	var skippedTestDevices []string
+	if needToRegisterDevices(distrTypes) && !stepConf.Offline() {
+		testDevices := devPortalData.TestDevices
+		if stepConf.DistributionType() == autoprovision.Enterprise && len(testDevices) > 0 {
+			log.Printf("Skipping the registration of the Bitrise test devices, the enterprise (in-house) apps run on any device of the organization")
+			testDevices = nil
+		}
+		if !managedResources[autoprovision.ManageDevices] && len(testDevices) > 0 {
+			log.Printf("Skipping the registration of the Bitrise test devices, the Step does not manage the devices (manage input: %s)", managedResources)
+			testDevices = nil
+		}
+
+		for _, testDevice := range testDevices {
+			bitriseTestDevices = append(bitriseTestDevices, autoprovision.TestDevice{UDID: testDevice.DeviceID, Title: testDevice.Title, DeviceType: testDevice.DeviceType})
+		}
+		bitriseTestDevices, skippedTestDevices = autoprovision.FilterTestDevices(bitriseTestDevices, devicePlatform)
+	}
+
+	// profileRequest returns the profiles of the distribution type to ensure with the certificates and the devices of the run
+	profileRequest := func(distrType autoprovision.DistributionType, devices []appstoreconnect.Device) autoprovision.ProfileRequest {
+		certType, _ := autoprovision.CertificateType(platform, distrType)
+		var certIDs []string
+		for _, cert := range certsByType[certType] {
+			certIDs = append(certIDs, cert.ID)
+		}
+		return autoprovision.ProfileRequest{
+			Platform:               platform,
+			Distribution:           distrType,
+			EntitlementsByBundleID: entitlementsByBundleID,
+			CertIDs:                certIDs,
+			Devices:                devices,
+			WatchBundleIDs:         watchBundleIDs,
+			AppClipBundleIDs:       appClipBundleIDs,
+			MacCatalyst:            macCatalyst,
+			MinProfileDaysValid:    stepConf.MinProfileDaysValid,
+		}
+	}
+
+	// the device snapshot is read once, so that the planning pass and the changes use the same devices
+	var testDevicesHash, snapshotPath, snapshotTeamID string
+	var snapshot *autoprovision.DeviceSnapshot
+	if needToRegisterDevices(distrTypes) && !stepConf.Offline() {
+		var testDeviceUDIDs []string
+		for _, testDevice := range bitriseTestDevices {
+			testDeviceUDIDs = append(testDeviceUDIDs, testDevice.UDID)
+		}
+		testDevicesHash = autoprovision.TestDevicesHash(testDeviceUDIDs)
+
+		snapshotTeamID = stepConf.TeamID
+		if snapshotTeamID == "" {
+			snapshotTeamID = teamID
+		}
+		if stepConf.DeviceSnapshotDir != "" {
+			snapshotPath = autoprovision.DeviceSnapshotPath(stepConf.DeviceSnapshotDir, snapshotTeamID, devicePlatform)
+			if snapshot, err = autoprovision.ReadDeviceSnapshot(snapshotPath, snapshotTeamID, devicePlatform, testDevicesHash, time.Duration(stepConf.DeviceSnapshotTTLHours)*time.Hour, time.Now()); err != nil {
+				log.Warnf("%s", err)
+			}
+		}
+	}
+
+	// the changes are planned first (without making them), so that a run exceeding the limit
+	// or rejected by the policy fails before the first change
+	if !stepConf.Offline() && portalChanges.NeedsPlan() {
+		fmt.Println()
+		log.Infof("Planning the Developer Portal changes")
+
+		planned, err := provisioner.PlanChanges(func(planner autoprovision.AutoProvisioner) error {
+			if stepConf.RotationDrill {
+				if err := planner.PortalChanges.Register(autoprovision.PortalChange{Action: autoprovision.CreateCertificateChange, Subject: string(certType), Reason: "certificate rotation drill (rotation_drill input)"}); err != nil {
+					return err
+				}
+			}
+
+			var devices []appstoreconnect.Device
+			if needToRegisterDevices(distrTypes) {
+				if snapshot != nil {
+					devices = append(devices, snapshot.Devices...)
+				} else {
+					registration, err := planner.EnsureDevices(devicePlatform, bitriseTestDevices, distrTypes)
+					if err != nil {
+						return err
+					}
+					devices = registration.Devices
+				}
+
+				if macCatalyst && containsDistributionType(distrTypes, autoprovision.Development) {
+					macDevices, err := session.ListDevices(client, appstoreconnect.MacOSDevice)
+					if err != nil {
+						return fmt.Errorf("failed to list Mac devices: %s", err)
+					}
+					devices = append(devices, macDevices...)
+				}
+			}
+
+			if !managedResources[autoprovision.ManageProfiles] {
+				return nil
+			}
+			for _, distrType := range distrTypes {
+				if _, err := planner.EnsureProfiles(profileRequest(distrType, devices)); err != nil {
+					return err
+				}
+			}
+			if reconciledEntitlementsByBundleID != nil {
+				return planner.Profiles.DisableStaleCapabilities(reconciledEntitlementsByBundleID)
+			}
+			return nil
+		})
+		if err != nil {
+			failf("Failed to plan the Developer Portal changes: %s", err)
+		}
+		if err := portalChanges.CheckPlan(planned); err != nil {
+			failf("%s", err)
+		}
+		log.Donef("%d Developer Portal change(s) planned", len(planned))
+	}
+
+	var rotationPlan *autoprovision.RotationRollbackPlan
+	if stepConf.RotationDrill {
+		if rotationPlan, err = rotateCertificate(client, portalChanges, certType, certsByType, stepConf.RotationDrillCSROptions(), stepConf.RotationDrillCSRPath, stepConf.RotationDrillP12Path, string(stepConf.RotationDrillP12Passphrase)); err != nil {
+			failf("Certificate rotation drill: %s", err)
+		}
+	}
+
 	// Ensure devices
 	var devices []appstoreconnect.Device
 
-	if needToRegisterDevices(distrTypes) {
+	if needToRegisterDevices(distrTypes) && !stepConf.Offline() {
 		fmt.Println()
 		log.Infof("Checking if %d Bitrise test device(s) are registered on Developer Portal", len(devPortalData.TestDevices))
 
@@ -464,46 +1123,71 @@ func main() {
 			log.Debugf("- %s", d)
 		}
 
-		var err error
-		devices, err = autoprovision.ListDevices(client, "", appstoreconnect.IOSDevice)
-		if err != nil {
-			failf("Failed to list devices: %s", err)
-		}
+		deviceSummary := autoprovision.DeviceRegistrationSummary{Skipped: skippedTestDevices}
+
+		deviceSources := map[string]autoprovision.DeviceSource{}
+		if snapshot != nil {
+			devices = snapshot.Devices
+			for _, d := range devices {
+				deviceSources[d.ID] = autoprovision.DeveloperPortalDevice
+			}
+			log.Printf("Reusing the %d device(s) of the device snapshot taken at %s, the test devices did not change", len(devices), snapshot.CreatedAt.Format(time.RFC3339))
+		} else {
+			registration, err := provisioner.EnsureDevices(devicePlatform, bitriseTestDevices, distrTypes)
+			if err != nil {
+				failf("%s", err)
+			}
+			for _, d := range registration.Duplicates {
+				log.Warnf("Device (%s) is registered multiple times with equivalent UDIDs, it is included in the profiles only once", autoprovision.DeviceDescription(d, autoprovision.DeveloperPortalDevice))
+			}
 
-		log.Printf("%d devices are registered on Developer Portal", len(devices))
-		for _, d := range devices {
-			log.Debugf("- %s, %s UDID (%s), ID (%s)", d.Attributes.Name, d.Attributes.DeviceClass, d.Attributes.UDID, d.ID)
+			devices = registration.Devices
+			for _, d := range devices {
+				deviceSources[d.ID] = autoprovision.DeveloperPortalDevice
+			}
+			for _, d := range registration.Registered {
+				deviceSources[d.ID] = autoprovision.BitriseTestDevice
+			}
+			deviceSummary.Registered = registration.Summary.Registered
+			deviceSummary.AlreadyRegistered = registration.Summary.AlreadyRegistered
+			deviceSummary.Rejected = registration.Summary.Rejected
+			deviceRegistrationFailed := len(registration.Summary.Rejected) > 0
+
+			// the snapshot is not saved without the failed (or only planned) devices, so that the next run retries their registration
+			if snapshotPath != "" && !deviceRegistrationFailed && !portalChanges.IsDryRun() {
+				if err := autoprovision.WriteDeviceSnapshot(snapshotPath, snapshotTeamID, devicePlatform, testDevicesHash, devices, time.Now()); err != nil {
+					log.Warnf("Failed to save the device snapshot: %s", err)
+				}
+			}
 		}
 
-		for _, testDevice := range devPortalData.TestDevices {
-			log.Printf("checking if the device (%s) is registered", testDevice.DeviceID)
+		if lines := deviceSummary.Lines(); len(lines) > 0 {
+			fmt.Println()
+			log.Printf("Bitrise test device registration:")
+			for _, line := range lines {
+				log.Printf("- %s", line)
+			}
+		}
 
-			found := false
-			for _, device := range devices {
-				if device.Attributes.UDID == testDevice.DeviceID {
-					found = true
-					break
-				}
+		if macCatalyst && containsDistributionType(distrTypes, autoprovision.Development) {
+			macDevices, err := session.ListDevices(client, appstoreconnect.MacOSDevice)
+			if err != nil {
+				failf("Failed to list Mac devices: %s", err)
 			}
 
-			if found {
-				log.Printf("device already registered")
-			} else {
-				log.Printf("registering device")
-				req := appstoreconnect.DeviceCreateRequest{
-					Data: appstoreconnect.DeviceCreateRequestData{
-						Attributes: appstoreconnect.DeviceCreateRequestDataAttributes{
-							Name:     "Bitrise test device",
-							Platform: appstoreconnect.IOS,
-							UDID:     testDevice.DeviceID,
-						},
-						Type: "devices",
-					},
-				}
+			log.Printf("%d Mac devices are registered on Developer Portal, for the Mac Catalyst development profiles", len(macDevices))
+			for _, d := range macDevices {
+				deviceSources[d.ID] = autoprovision.DeveloperPortalDevice
+			}
+			devices = append(devices, macDevices...)
+		}
 
-				if _, err := client.Provisioning.RegisterNewDevice(req); err != nil {
-					failf("Failed to register device: %s", err)
-				}
+		fmt.Println()
+		log.Printf("Devices by platform:")
+		for platform, platformDevices := range autoprovision.DevicesByPlatform(devices) {
+			log.Printf("%s (%d):", platform, len(platformDevices))
+			for _, d := range platformDevices {
+				log.Printf("- %s", autoprovision.DeviceDescription(d, deviceSources[d.ID]))
 			}
 		}
 	}
@@ -512,80 +1196,114 @@ func main() {
 	type CodesignSettings struct {
 		ProfilesByBundleID map[string]appstoreconnect.Profile
 		Certificate        certificateutil.CertificateInfoModel
+		// InstallerCertificate signs the installer package (productbuild) of Mac App Store builds
+		InstallerCertificate *certificateutil.CertificateInfoModel
+		// MacCatalystProfilesByBundleID sign the Mac Catalyst variant of the iOS targets
+		MacCatalystProfilesByBundleID map[string]appstoreconnect.Profile
 	}
 
 	codesignSettingsByDistributionType := map[autoprovision.DistributionType]CodesignSettings{}
 
-	bundleIDByBundleIDIdentifer := map[string]*appstoreconnect.BundleID{}
-
-	containersByBundleID := map[string][]string{}
-
-	profileManager := ProfileManager{
-		client:                      client,
-		bundleIDByBundleIDIdentifer: bundleIDByBundleIDIdentifer,
-		containersByBundleID:        containersByBundleID,
+	// without the certificates there are no code signing settings, the devices are registered for every distribution type though
+	codesignDistrTypes := distrTypes
+	if !selectCertificates {
+		codesignDistrTypes = nil
 	}
 
-	for _, distrType := range distrTypes {
+	for _, distrType := range codesignDistrTypes {
 		fmt.Println()
 		log.Infof("Checking %s provisioning profiles for %d bundle id(s)", distrType, len(entitlementsByBundleID))
-		certType := autoprovision.CertificateTypeByDistribution[distrType]
+		certType, _ := autoprovision.CertificateType(platform, distrType)
 		certs := certsByType[certType]
 
 		if len(certs) == 0 {
 			failf("No valid certificate provided for distribution type: %s", distrType)
-		} else if len(certs) > 1 {
-			log.Warnf("Multiple certificates provided for distribution type: %s", distrType)
+		}
+
+		cert, reason, err := autoprovision.SelectCertificate(certs, stepConf.CertificateSelectionStrategy())
+		if err != nil {
+			failf("Failed to select certificate for distribution type %s: %s", distrType, err)
+		}
+		if rotationPlan != nil && certType == rotationPlan.CertificateType {
+			cert, reason = rotationPlan.NewCertificate, "certificate rotation drill"
+		}
+
+		if len(certs) > 1 {
+			log.Warnf("Multiple valid certificates provided for distribution type: %s", distrType)
 			for _, c := range certs {
-				log.Warnf("- %s", c.Certificate.CommonName)
+				log.Warnf("- %s, serial: %s, expiry: %s", c.Certificate.CommonName, c.Certificate.Serial, c.Certificate.EndDate)
 			}
-			log.Warnf("Using: %s", certs[0].Certificate.CommonName)
+			log.Warnf("Using: %s (selection: %s, reason: %s)", cert.Certificate.CommonName, stepConf.CertificateSelectionStrategy(), reason)
 		}
-		log.Debugf("Using certificate for distribution type %s (certificate type %s): %s", distrType, certType, certs[0])
+		log.Debugf("Using certificate for distribution type %s (certificate type %s): %s", distrType, certType, cert)
 
 		codesignSettings := CodesignSettings{
-			ProfilesByBundleID: map[string]appstoreconnect.Profile{},
-			Certificate:        certs[0].Certificate,
+			ProfilesByBundleID:            map[string]appstoreconnect.Profile{},
+			Certificate:                   cert.Certificate,
+			MacCatalystProfilesByBundleID: map[string]appstoreconnect.Profile{},
 		}
 
-		var certIDs []string
-		for _, cert := range certs {
-			certIDs = append(certIDs, cert.ID)
+		if needsInstallerCert && distrType == stepConf.DistributionType() {
+			installerCert, reason, err := autoprovision.SelectCertificate(certsByType[installerCertType], stepConf.CertificateSelectionStrategy())
+			if err != nil {
+				failf("Failed to select installer certificate: %s", err)
+			}
+			log.Printf("Installer certificate: %s (selection: %s, reason: %s)", installerCert.Certificate.CommonName, stepConf.CertificateSelectionStrategy(), reason)
+			log.Printf("Identity pair: application: %s, installer: %s", cert.Certificate.CommonName, installerCert.Certificate.CommonName)
+
+			codesignSettings.InstallerCertificate = &installerCert.Certificate
 		}
 
-		platformProfileTypes, ok := autoprovision.PlatformToProfileTypeByDistribution[platform]
-		if !ok {
-			failf("No profiles for platform: %s", platform)
+		if !managedResources[autoprovision.ManageProfiles] {
+			log.Printf("Skipping the %s profiles, the Step does not manage the profiles (manage input: %s)", distrType, managedResources)
+			codesignSettingsByDistributionType[distrType] = codesignSettings
+			continue
 		}
 
-		profileType := platformProfileTypes[distrType]
+		request := profileRequest(distrType, devices)
+		var ensured autoprovision.EnsuredProfiles
+		if stepConf.Offline() {
+			ensured, err = autoprovision.FindOfflineProfiles(offlineProfiles, request, cert.Certificate, time.Now())
+		} else {
+			ensured, err = provisioner.EnsureProfiles(request)
+		}
+		if err != nil {
+			failf(err.Error())
+		}
 
-		var deviceIDs []string
-		if needToRegisterDevices([]autoprovision.DistributionType{distrType}) {
-			for _, d := range devices {
-				if strings.HasPrefix(string(profileType), "TVOS") && d.Attributes.DeviceClass != "APPLE_TV" {
-					log.Debugf("dropping device %s, since device type: %s, required device type: APPLE_TV", d.ID, d.Attributes.DeviceClass)
-					continue
-				} else if strings.HasPrefix(string(profileType), "IOS") &&
-					string(d.Attributes.DeviceClass) != "IPHONE" && string(d.Attributes.DeviceClass) != "IPAD" && string(d.Attributes.DeviceClass) != "IPOD" {
-					log.Debugf("dropping device %s, since device type: %s, required device type: IPHONE, IPAD or IPOD", d.ID, d.Attributes.DeviceClass)
-					continue
+		for _, bundleIDIdentifier := range request.SortedBundleIDs() {
+			profile := ensured.ProfilesByBundleID[bundleIDIdentifier]
+			codesignSettings.ProfilesByBundleID[bundleIDIdentifier] = profile
+			cloudKitWarnings, err := autoprovision.CloudKitEnvironmentWarnings(distrType, bundleIDIdentifier, autoprovision.Entitlement(entitlementsByBundleID[bundleIDIdentifier]), profile)
+			if err != nil {
+				log.Warnf("Failed to check the CloudKit environment of %s: %s", bundleIDIdentifier, err)
+			}
+			for _, warning := range cloudKitWarnings {
+				log.Warnf("%s", warning)
+			}
+			if rotationPlan != nil && certType == rotationPlan.CertificateType {
+				rotationPlan.Profiles = append(rotationPlan.Profiles, profile.Attributes.Name)
+			}
+
+			if macCatalystProfile, ok := ensured.MacCatalystProfilesByBundleID[bundleIDIdentifier]; ok {
+				codesignSettings.MacCatalystProfilesByBundleID[bundleIDIdentifier] = macCatalystProfile
+				if rotationPlan != nil && certType == rotationPlan.CertificateType {
+					rotationPlan.Profiles = append(rotationPlan.Profiles, macCatalystProfile.Attributes.Name)
 				}
-				deviceIDs = append(deviceIDs, d.ID)
 			}
 		}
+		codesignSettingsByDistributionType[distrType] = codesignSettings
+	}
 
-		for bundleIDIdentifier, entitlements := range entitlementsByBundleID {
-			profile, err := profileManager.EnsureProfile(profileType, bundleIDIdentifier, entitlements, certIDs, deviceIDs, stepConf.MinProfileDaysValid)
-			if err != nil {
-				failf(err.Error())
-			}
-			codesignSettings.ProfilesByBundleID[bundleIDIdentifier] = *profile
-			codesignSettingsByDistributionType[distrType] = codesignSettings
+	if reconciledEntitlementsByBundleID != nil && managedResources[autoprovision.ManageProfiles] {
+		fmt.Println()
+		log.Infof("Reconciling the app ID capabilities")
+		if err := profileManager.DisableStaleCapabilities(reconciledEntitlementsByBundleID); err != nil {
+			failf("Failed to reconcile the app ID capabilities: %s", err)
 		}
 	}
 
-	if len(containersByBundleID) > 0 {
+	if containersByBundleID := profileManager.UnassignedContainers(); len(containersByBundleID) > 0 {
 		fmt.Println()
 		log.Errorf("Unable to automatically assign iCloud containers to the following app IDs:")
 		fmt.Println()
@@ -599,14 +1317,50 @@ func main() {
 		failf("You have to manually add the listed containers to your app ID at: https://developer.apple.com/account/resources/identifiers/list")
 	}
 
-	// Force Codesign Settings
-	fmt.Println()
-	log.Infof("Apply Bitrise managed codesigning on the project")
+	if stepConf.DryRun {
+		// the planned profiles do not exist, the project and the keychain are left untouched
+		fmt.Println()
+		log.Infof("Dry run: planned Developer Portal changes")
+		plan := portalChanges.Plan()
+		log.Printf("%s", plan)
+		if err := outputExporter.Export(map[string]string{"BITRISE_AUTO_PROVISION_PLAN": plan}); err != nil {
+			failf("Failed to export outputs: %s", err)
+		}
+		// the policy is checked on the full plan, as in a run making the changes
+		if err := portalChanges.CheckPlan(portalChanges.Changes); err != nil {
+			failf("%s", err)
+		}
+		return
+	}
 
-	targets := append([]xcodeproj.Target{projHelper.MainTarget}, projHelper.MainTarget.DependentExecutableProductTargets(false)...)
-	for _, target := range targets {
+	if managedResources[autoprovision.ManageProfiles] {
+		// Force Codesign Settings
 		fmt.Println()
-		log.Infof("  Target: %s", target.Name)
+		log.Infof("Apply Bitrise managed codesigning on the project")
+
+		targets, err := projHelper.ArchivableTargets()
+		if err != nil {
+			failf("Failed to list the code signed targets: %s", err)
+		}
+
+		if pinnedSettings := autoprovision.PinnedProfileSettings(targets); len(pinnedSettings) > 0 {
+			fmt.Println()
+			log.Warnf("The following targets pin a provisioning profile in their build settings:")
+			for _, setting := range pinnedSettings {
+				log.Warnf("- %s", setting)
+			}
+
+			if stepConf.ClearPinnedProfiles {
+				log.Warnf("Clearing the pinned provisioning profile build settings in every configuration.")
+				for _, target := range targets {
+					autoprovision.ClearPinnedProfileSettings(target)
+				}
+			} else {
+				log.Warnf("The Step overrides these settings for the %s configuration only,", config)
+				log.Warnf("building any other configuration uses the pinned profile instead of the Bitrise managed one.")
+				log.Warnf("Set the Clear pinned provisioning profiles input to yes to remove these settings from the project.")
+			}
+		}
 
 		forceCodesignDistribution := stepConf.DistributionType()
 		if _, isDevelopmentAvailable := codesignSettingsByDistributionType[autoprovision.Development]; isDevelopmentAvailable {
@@ -619,53 +1373,114 @@ func main() {
 		}
 		teamID = codesignSettings.Certificate.TeamID
 
-		targetBundleID, err := projHelper.TargetBundleID(target.Name, config)
-		if err != nil {
-			failf(err.Error())
-		}
-		profile, ok := codesignSettings.ProfilesByBundleID[targetBundleID]
-		if !ok {
-			failf("No profile ensured for the bundleID %s", targetBundleID)
-		}
+		for _, target := range targets {
+			fmt.Println()
+			log.Infof("  Target: %s", target.Name)
+
+			targetBundleID, err := projHelper.TargetBundleID(target.Name, config)
+			if err != nil {
+				failf(err.Error())
+			}
+			profile, ok := codesignSettings.ProfilesByBundleID[targetBundleID]
+			if !ok {
+				failf("No profile ensured for the bundleID %s", targetBundleID)
+			}
 
-		log.Printf("  development Team: %s(%s)", codesignSettings.Certificate.TeamName, teamID)
-		log.Printf("  provisioning Profile: %s", profile.Attributes.Name)
-		log.Printf("  certificate: %s", codesignSettings.Certificate.CommonName)
+			log.Printf("  development Team: %s(%s)", codesignSettings.Certificate.TeamName, teamID)
+			log.Printf("  provisioning Profile: %s", profile.Attributes.Name)
+			log.Printf("  certificate: %s", codesignSettings.Certificate.CommonName)
+
+			if err := projHelper.XcProj.ForceCodeSign(projHelper.TargetConfiguration(target.Name, config), target.Name, teamID, codesignSettings.Certificate.CommonName, profile.Attributes.UUID); err != nil {
+				failf("Failed to apply code sign settings for target (%s): %s", target.Name, err)
+			}
+
+			if macCatalystProfile, ok := codesignSettings.MacCatalystProfilesByBundleID[targetBundleID]; ok {
+				log.Printf("  Mac Catalyst provisioning Profile: %s", macCatalystProfile.Attributes.Name)
+
+				autoprovision.ForceMacCatalystProfile(target, projHelper.TargetConfiguration(target.Name, config), macCatalystProfile.Attributes.UUID)
+			}
+
+			if err := projHelper.XcProj.Save(); err != nil {
+				failf("Failed to save project: %s", err)
+			}
 
-		if err := projHelper.XcProj.ForceCodeSign(config, target.Name, teamID, codesignSettings.Certificate.CommonName, profile.Attributes.UUID); err != nil {
-			failf("Failed to apply code sign settings for target (%s): %s", target.Name, err)
 		}
 
-		if err := projHelper.XcProj.Save(); err != nil {
-			failf("Failed to save project: %s", err)
+		var frameworkAdjustments []autoprovision.FrameworkSigningAdjustment
+		for _, framework := range projHelper.FrameworkTargets() {
+			frameworkAdjustments = append(frameworkAdjustments, autoprovision.AdjustFrameworkSigning(framework, projHelper.TargetConfiguration(framework.Name, config), codesignSettings.Certificate.CommonName)...)
 		}
+		if len(frameworkAdjustments) > 0 {
+			fmt.Println()
+			log.Warnf("The following framework targets have explicit code signing settings, which would fail the archive:")
+			for _, adjustment := range frameworkAdjustments {
+				log.Warnf("- %s", adjustment)
+			}
+			log.Warnf("Frameworks do not support provisioning profiles and are signed with the Bitrise managed certificate instead.")
 
+			if err := projHelper.XcProj.Save(); err != nil {
+				failf("Failed to save project: %s", err)
+			}
+		}
+	} else {
+		fmt.Println()
+		log.Printf("Skipping the code signing settings of the project, the Step does not manage the profiles (manage input: %s)", managedResources)
 	}
 
 	// Install certificates and profiles
 	fmt.Println()
 	log.Infof("Install certificates and profiles")
 
-	kc, err := keychain.New(stepConf.KeychainPath, stepConf.KeychainPassword)
-	if err != nil {
-		failf("Failed to initialize keychain: %s", err)
+	var kc *keychain.Keychain
+	if stepConf.UseExistingKeychain {
+		kc, err = keychain.Open(stepConf.KeychainPath, stepConf.KeychainPassword)
+		if err != nil {
+			failf("Failed to open the existing keychain: %s", err)
+		}
+		log.Printf("Installing the certificates into the existing keychain: %s", kc.Path)
+	} else {
+		kc, err = keychain.New(stepConf.KeychainPath, stepConf.KeychainPassword)
+		if err != nil {
+			failf("Failed to initialize keychain: %s", err)
+		}
 	}
+	kc.TempDir = runTempDir.Path
+	kc.KeepTempFiles = stepConf.KeepTempDir
 
+	installCertificates := managedResources[autoprovision.ManageCertificates]
+	if !installCertificates {
+		log.Printf("Skipping the installation of the certificates, the Step does not manage the certificates (manage input: %s)", managedResources)
+	}
+
+	var profiles []appstoreconnect.Profile
 	i := 0
 	for _, codesignSettings := range codesignSettingsByDistributionType {
 		log.Printf("certificate: %s", codesignSettings.Certificate.CommonName)
 
-		if err := kc.InstallCertificate(codesignSettings.Certificate, ""); err != nil {
-			failf("Failed to install certificate: %s", err)
+		if installCertificates {
+			if err := kc.InstallCertificate(codesignSettings.Certificate, ""); err != nil {
+				failf("Failed to install certificate: %s", err)
+			}
+		}
+
+		if codesignSettings.InstallerCertificate != nil && installCertificates {
+			log.Printf("installer certificate: %s", codesignSettings.InstallerCertificate.CommonName)
+
+			if err := kc.InstallCertificate(*codesignSettings.InstallerCertificate, ""); err != nil {
+				failf("Failed to install installer certificate: %s", err)
+			}
 		}
 
 		log.Printf("profiles:")
 		for _, profile := range codesignSettings.ProfilesByBundleID {
 			log.Printf("- %s", profile.Attributes.Name)
 
-			if err := autoprovision.WriteProfile(profile); err != nil {
-				failf("Failed to write profile to file: %s", err)
-			}
+			profiles = append(profiles, profile)
+		}
+		for _, profile := range codesignSettings.MacCatalystProfilesByBundleID {
+			log.Printf("- %s", profile.Attributes.Name)
+
+			profiles = append(profiles, profile)
 		}
 
 		if i < len(codesignSettingsByDistributionType)-1 {
@@ -674,6 +1489,52 @@ func main() {
 		i++
 	}
 
+	if rotationPlan != nil {
+		log.Printf("previous certificates (certificate rotation drill):")
+		for _, certificate := range rotationPlan.PreviousCertificates {
+			log.Printf("- %s", certificate.Certificate.CommonName)
+			if err := kc.InstallCertificate(certificate.Certificate, ""); err != nil {
+				failf("Failed to install previous certificate: %s", err)
+			}
+		}
+	}
+
+	if err := autoprovision.InstallProfiles(profiles, maxProfileInstallConcurrency); err != nil {
+		failf("Failed to install profiles: %s", err)
+	}
+
+	if client != nil && client.KeyID() != "" {
+		log.Printf("App Store Connect API key used: %s", client.KeyID())
+	}
+	log.Printf("Provisioning profiles: %s", autoprovision.GetProfileCacheStats())
+
+	if len(unmappedEntitlements) > 0 {
+		fmt.Println()
+		log.Warnf("%d entitlement(s) have no known capability mapping, they were not synced to the app IDs nor checked in the profiles:", len(unmappedEntitlements))
+		for _, key := range unmappedEntitlements {
+			log.Warnf("- %s", key)
+		}
+
+		if stepConf.CapabilityGapReportURL != "" {
+			if err := autoprovision.SendCapabilityGapReport(stepConf.CapabilityGapReportURL, unmappedEntitlements); err != nil {
+				log.Warnf("Failed to send the capability gap report: %s", err)
+			}
+		}
+	}
+
+	if report := autoprovision.TargetFailuresReport(projHelper.TargetFailures()); report != "" {
+		fmt.Println()
+		log.Errorf("%s", report)
+	}
+
+	if len(ignoredFailures) > 0 {
+		fmt.Println()
+		log.Warnf("%d non-critical failure(s) were ignored (strictness: lenient):", len(ignoredFailures))
+		for _, failure := range ignoredFailures {
+			log.Warnf("- %s", strings.TrimSpace(failure))
+		}
+	}
+
 	// Export output
 	fmt.Println()
 	log.Infof("Exporting outputs")
@@ -687,16 +1548,69 @@ func main() {
 	if ok {
 		outputs["BITRISE_DEVELOPMENT_CODESIGN_IDENTITY"] = settings.Certificate.CommonName
 
-		bundleID, err := projHelper.TargetBundleID(projHelper.MainTarget.Name, config)
-		if err != nil {
-			failf("Failed to read bundle ID for the main target: %s", err)
+		if managedResources[autoprovision.ManageProfiles] {
+			bundleID, err := projHelper.TargetBundleID(projHelper.MainTarget.Name, config)
+			if err != nil {
+				failf("Failed to read bundle ID for the main target: %s", err)
+			}
+			profile, ok := settings.ProfilesByBundleID[bundleID]
+			if !ok {
+				failf("No provisioning profile ensured for the main target")
+			}
+
+			outputs["BITRISE_DEVELOPMENT_PROFILE"] = profile.Attributes.UUID
+			if macCatalystProfile, ok := settings.MacCatalystProfilesByBundleID[bundleID]; ok {
+				outputs["BITRISE_DEVELOPMENT_MAC_CATALYST_PROFILE"] = macCatalystProfile.Attributes.UUID
+			}
 		}
-		profile, ok := settings.ProfilesByBundleID[bundleID]
-		if !ok {
-			failf("No provisioning profile ensured for the main target")
+	}
+
+	if settings, ok := codesignSettingsByDistributionType[stepConf.DistributionType()]; ok {
+		identity := autoprovision.NewSigningIdentity(stepConf.DistributionType(), settings.Certificate)
+		outputs["BITRISE_SIGNING_CERTIFICATE_SHA1"] = identity.SHA1Fingerprint
+		outputs["BITRISE_SIGNING_CERTIFICATE_SHA256"] = identity.SHA256Fingerprint
+		outputs["BITRISE_SIGNING_TEAM_ID"] = identity.TeamID
+	}
+
+	if stepConf.IdentityReportPath != "" {
+		report := autoprovision.SigningIdentityReport{TeamID: teamID}
+		for distrType, settings := range codesignSettingsByDistributionType {
+			report.Identities = append(report.Identities, autoprovision.NewSigningIdentity(distrType, settings.Certificate))
+		}
+		if err := autoprovision.WriteSigningIdentityReport(stepConf.IdentityReportPath, report); err != nil {
+			log.Warnf("Failed to write the signing identity report: %s", err)
+		} else {
+			outputs["BITRISE_SIGNING_IDENTITY_REPORT_PATH"] = stepConf.IdentityReportPath
+		}
+	}
+
+	// the signing metadata is recorded in the signing history and reported to the post-run hooks
+	record := autoprovision.SigningHistoryRecord{
+		AppSlug:     stepConf.AppSlug,
+		BuildNumber: stepConf.BuildNumber,
+		TeamID:      teamID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	for distrType, settings := range codesignSettingsByDistributionType {
+		record.Certificates = append(record.Certificates, autoprovision.NewSigningIdentity(distrType, settings.Certificate))
+		for bundleID, profile := range settings.ProfilesByBundleID {
+			record.BundleIDs = append(record.BundleIDs, bundleID)
+			record.Profiles = append(record.Profiles, autoprovision.NewSigningHistoryProfile(distrType, bundleID, profile))
+		}
+	}
+
+	if stepConf.SigningHistoryURL != "" {
+		if err := autoprovision.SendSigningHistoryRecord(stepConf.SigningHistoryURL, string(stepConf.SigningHistoryToken), record); err != nil {
+			log.Warnf("Failed to record the signing metadata: %s", err)
+		} else {
+			log.Donef("Signing metadata recorded for build %s of app %s", stepConf.BuildNumber, stepConf.AppSlug)
 		}
+	}
 
-		outputs["BITRISE_DEVELOPMENT_PROFILE"] = profile.Attributes.UUID
+	if settings, ok := codesignSettingsByDistributionType[stepConf.DistributionType()]; ok && managedResources[autoprovision.ManageProfiles] {
+		for key, expiry := range autoprovision.ProfileExpiryOutputs(settings.ProfilesByBundleID) {
+			outputs[key] = expiry
+		}
 	}
 
 	if stepConf.DistributionType() != autoprovision.Development {
@@ -706,24 +1620,132 @@ func main() {
 		}
 
 		outputs["BITRISE_PRODUCTION_CODESIGN_IDENTITY"] = settings.Certificate.CommonName
+		if settings.InstallerCertificate != nil {
+			outputs["BITRISE_INSTALLER_CODESIGN_IDENTITY"] = settings.InstallerCertificate.CommonName
+		}
 
-		bundleID, err := projHelper.TargetBundleID(projHelper.MainTarget.Name, config)
-		if err != nil {
-			failf(err.Error())
+		if managedResources[autoprovision.ManageProfiles] {
+			bundleID, err := projHelper.TargetBundleID(projHelper.MainTarget.Name, config)
+			if err != nil {
+				failf(err.Error())
+			}
+			profile, ok := settings.ProfilesByBundleID[bundleID]
+			if !ok {
+				failf("No provisioning profile ensured for the main target")
+			}
+
+			outputs["BITRISE_PRODUCTION_PROFILE"] = profile.Attributes.UUID
+			if macCatalystProfile, ok := settings.MacCatalystProfilesByBundleID[bundleID]; ok {
+				outputs["BITRISE_PRODUCTION_MAC_CATALYST_PROFILE"] = macCatalystProfile.Attributes.UUID
+			}
 		}
-		profile, ok := settings.ProfilesByBundleID[bundleID]
+	}
+
+	if stepConf.ExportOptionsPlistPath != "" {
+		settings, ok := codesignSettingsByDistributionType[stepConf.DistributionType()]
 		if !ok {
-			failf("No provisioning profile ensured for the main target")
+			failf("No codesign settings ensured for the selected distribution type: %s", stepConf.DistributionType())
+		}
+
+		exportSettings := autoprovision.ExportCodeSignSettings{
+			Distribution:       stepConf.DistributionType(),
+			TeamID:             settings.Certificate.TeamID,
+			SigningCertificate: settings.Certificate.CommonName,
+			ProfilesByBundleID: map[string]string{},
+		}
+		for bundleID, profile := range settings.ProfilesByBundleID {
+			exportSettings.ProfilesByBundleID[bundleID] = profile.Attributes.Name
+		}
+		if rotationPlan != nil && settings.Certificate.Serial == rotationPlan.NewCertificate.Certificate.Serial {
+			// the previous certificates are installed with the same name, the fingerprint selects the new one
+			exportSettings.SigningCertificate = strings.ToUpper(settings.Certificate.SHA1Fingerprint)
 		}
 
-		outputs["BITRISE_PRODUCTION_PROFILE"] = profile.Attributes.UUID
+		buildSettings, err := projHelper.ExportBuildSettings(config)
+		if err != nil {
+			failf("Failed to read export build settings: %s", err)
+		}
+		exportSettings.EnableBitcode = buildSettings.EnableBitcode
+		if buildSettings.CodeSignKeychain != "" {
+			log.Warnf("OTHER_CODE_SIGN_FLAGS sets the codesign keychain (%s), the export will not find the certificates installed into %s unless it is the same keychain", buildSettings.CodeSignKeychain, stepConf.KeychainPath)
+		}
+
+		if err := autoprovision.MergeExportOptions(stepConf.ExportOptionsPlistPath, exportSettings); err != nil {
+			failf("Failed to update export options: %s", err)
+		}
+		log.Donef("export options updated: %s", stepConf.ExportOptionsPlistPath)
+	}
+
+	if err := buildCache.StoreSession(session, time.Now()); err != nil {
+		log.Warnf("Failed to save the app IDs and certificates to the build cache: %s", err)
+	}
+
+	if session != nil {
+		sessionPath, err := writeSession(session, stepConf.SessionPath)
+		if err != nil {
+			log.Warnf("Failed to save the Developer Portal state for the next runs: %s", err)
+		} else {
+			outputs["BITRISE_AUTO_PROVISION_SESSION_PATH"] = sessionPath
+		}
+	}
+
+	if writeAuditLog() {
+		outputs["BITRISE_AUTO_PROVISION_AUDIT_LOG_PATH"] = auditLogPath
+	}
+
+	if stepConf.CapabilityMatrixPath != "" {
+		if err := autoprovision.WriteCapabilityMatrix(stepConf.CapabilityMatrixPath, capabilityMatrix); err != nil {
+			log.Warnf("Failed to write the capability matrix: %s", err)
+		} else {
+			outputs["BITRISE_CAPABILITY_MATRIX_PATH"] = stepConf.CapabilityMatrixPath
+		}
+	}
+
+	if rotationPlan != nil {
+		fmt.Println()
+		log.Warnf("%s", rotationPlan.String())
+		outputs["BITRISE_CERTIFICATE_ROTATION_ROLLBACK_PLAN"] = rotationPlan.String()
+		outputs["BITRISE_CERTIFICATE_ROTATION_P12_PATH"] = rotationPlan.P12Path
+		if rotationPlan.CSRPath != "" {
+			outputs["BITRISE_CERTIFICATE_ROTATION_CSR_PATH"] = rotationPlan.CSRPath
+		}
 	}
 
 	for k, v := range outputs {
 		log.Donef("%s=%s", k, v)
-		if err := tools.ExportEnvironmentWithEnvman(k, v); err != nil {
-			failf("Failed to export %s=%s: %s", k, v, err)
-		}
 	}
+	if err := outputExporter.Export(outputs); err != nil {
+		failf("Failed to export outputs: %s", err)
+	}
+
+	if hooks := autoprovision.ParsePostRunHooks(stepConf.PostRunHooks); len(hooks) > 0 {
+		runPostRunHooks(hooks, autoprovision.RunReport{
+			SigningHistoryRecord: record,
+			DistributionType:     stepConf.DistributionType(),
+			PortalChanges:        portalChanges.AuditEntries(),
+			Outputs:              outputs,
+		})
+	}
+}
+
+// runPostRunHooks writes the run report and runs the post-run hooks with the report path and the outputs in their environment
+func runPostRunHooks(hooks []string, report autoprovision.RunReport) {
+	fmt.Println()
+	log.Infof("Running %d post-run hook(s)", len(hooks))
 
+	reportPath := filepath.Join(runTempDir.Path, "auto-provision-report.json")
+	if err := autoprovision.WriteRunReport(reportPath, report); err != nil {
+		failOrWarn("Post-run hooks: %s", err)
+		return
+	}
+
+	envs := map[string]string{"BITRISE_AUTO_PROVISION_REPORT_PATH": reportPath}
+	for key, value := range report.Outputs {
+		envs[key] = value
+	}
+	if err := autoprovision.RunPostRunHooks(hooks, envs); err != nil {
+		failOrWarn("%s", err)
+		return
+	}
+	log.Donef("%d post-run hook(s) succeeded", len(hooks))
 }
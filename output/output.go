@@ -0,0 +1,160 @@
+package output
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/tools"
+)
+
+// Format is the format, the outputs are exported in
+type Format string
+
+// Formats ...
+const (
+	// Envman exports the outputs with envman, for Bitrise runners
+	Envman Format = "envman"
+	// GitHubActions writes the outputs into the GitHub Actions output and environment files
+	GitHubActions Format = "github-actions"
+	// Dotenv writes the outputs into a dotenv file
+	Dotenv Format = "dotenv"
+)
+
+// Exporter exports the outputs of the Step
+type Exporter interface {
+	Export(outputs map[string]string) error
+}
+
+// NewExporter returns the Exporter of the format,
+// dotenvPath is the file the Dotenv exporter appends the outputs to.
+func NewExporter(format Format, dotenvPath string) (Exporter, error) {
+	switch format {
+	case Envman, "":
+		return EnvmanExporter{}, nil
+	case GitHubActions:
+		return GitHubActionsExporter{
+			OutputPath: os.Getenv("GITHUB_OUTPUT"),
+			EnvPath:    os.Getenv("GITHUB_ENV"),
+			Stdout:     os.Stdout,
+		}, nil
+	case Dotenv:
+		if dotenvPath == "" {
+			return nil, fmt.Errorf("dotenv path is required for the %s output format", Dotenv)
+		}
+		return DotenvExporter{Path: dotenvPath}, nil
+	}
+	return nil, fmt.Errorf("unknown output format (%s), available: %s, %s, %s", format, Envman, GitHubActions, Dotenv)
+}
+
+// EnvmanExporter exports the outputs with envman
+type EnvmanExporter struct{}
+
+// Export ...
+func (e EnvmanExporter) Export(outputs map[string]string) error {
+	for _, key := range sortedKeys(outputs) {
+		if err := tools.ExportEnvironmentWithEnvman(key, outputs[key]); err != nil {
+			return fmt.Errorf("failed to export %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// GitHubActionsExporter writes the outputs as step outputs and environment variables of the job.
+// If the GITHUB_OUTPUT file is not available (older runners), the deprecated ::set-output workflow command is printed.
+type GitHubActionsExporter struct {
+	OutputPath string
+	EnvPath    string
+	Stdout     io.Writer
+}
+
+// Export ...
+func (e GitHubActionsExporter) Export(outputs map[string]string) error {
+	if e.OutputPath != "" {
+		if err := appendFile(e.OutputPath, gitHubEnvFileContent(outputs)); err != nil {
+			return fmt.Errorf("failed to write GitHub Actions outputs: %s", err)
+		}
+	} else {
+		for _, key := range sortedKeys(outputs) {
+			if _, err := fmt.Fprintf(e.Stdout, "::set-output name=%s::%s\n", key, escapeWorkflowCommand(outputs[key])); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.EnvPath != "" {
+		if err := appendFile(e.EnvPath, gitHubEnvFileContent(outputs)); err != nil {
+			return fmt.Errorf("failed to write GitHub Actions environment: %s", err)
+		}
+	}
+	return nil
+}
+
+// DotenvExporter appends the outputs to a dotenv file
+type DotenvExporter struct {
+	Path string
+}
+
+// Export ...
+func (e DotenvExporter) Export(outputs map[string]string) error {
+	var content strings.Builder
+	for _, key := range sortedKeys(outputs) {
+		content.WriteString(fmt.Sprintf("%s=%s\n", key, strconv.Quote(outputs[key])))
+	}
+	if err := appendFile(e.Path, content.String()); err != nil {
+		return fmt.Errorf("failed to write dotenv file: %s", err)
+	}
+	return nil
+}
+
+// gitHubEnvFileContent returns the outputs in the GitHub Actions env file format,
+// using the heredoc syntax with a random delimiter, so that multiline values are supported.
+func gitHubEnvFileContent(outputs map[string]string) string {
+	var content strings.Builder
+	for _, key := range sortedKeys(outputs) {
+		delimiter := randomDelimiter()
+		content.WriteString(fmt.Sprintf("%s<<%s\n%s\n%s\n", key, delimiter, outputs[key], delimiter))
+	}
+	return content.String()
+}
+
+// escapeWorkflowCommand escapes the value of a GitHub Actions workflow command
+func escapeWorkflowCommand(s string) string {
+	s = strings.ReplaceAll(s, "%", "%25")
+	s = strings.ReplaceAll(s, "\r", "%0D")
+	return strings.ReplaceAll(s, "\n", "%0A")
+}
+
+func randomDelimiter() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "ghadelimiter_EOF"
+	}
+	return "ghadelimiter_" + hex.EncodeToString(b)
+}
+
+func appendFile(pth, content string) error {
+	f, err := os.OpenFile(pth, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func sortedKeys(outputs map[string]string) []string {
+	keys := make([]string, 0, len(outputs))
+	for key := range outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,74 @@
+package output
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var outputs = map[string]string{
+	"BITRISE_EXPORT_METHOD":  "app-store",
+	"BITRISE_DEVELOPER_TEAM": "TEAM123",
+	"MULTILINE":              "first\nsecond",
+}
+
+func TestDotenvExporter_Export(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "outputs.env")
+
+	require.NoError(t, DotenvExporter{Path: pth}.Export(outputs))
+	require.NoError(t, DotenvExporter{Path: pth}.Export(map[string]string{"NEXT_RUN": "value"}))
+
+	content, err := ioutil.ReadFile(pth)
+	require.NoError(t, err)
+	require.Equal(t, `BITRISE_DEVELOPER_TEAM="TEAM123"
+BITRISE_EXPORT_METHOD="app-store"
+MULTILINE="first\nsecond"
+NEXT_RUN="value"
+`, string(content))
+}
+
+func TestGitHubActionsExporter_Export(t *testing.T) {
+	t.Run("env files", func(t *testing.T) {
+		dir := t.TempDir()
+		exporter := GitHubActionsExporter{OutputPath: filepath.Join(dir, "output"), EnvPath: filepath.Join(dir, "env")}
+		require.NoError(t, exporter.Export(outputs))
+
+		for _, pth := range []string{exporter.OutputPath, exporter.EnvPath} {
+			content, err := ioutil.ReadFile(pth)
+			require.NoError(t, err)
+
+			delimited := regexp.MustCompile(`(?m)^(\w+)<<(ghadelimiter_\w+)\n((?s:.*?))\n(ghadelimiter_\w+)$`)
+			matches := delimited.FindAllStringSubmatch(string(content), -1)
+			require.Equal(t, 3, len(matches))
+			for _, match := range matches {
+				require.Equal(t, match[2], match[4])
+				require.Equal(t, outputs[match[1]], match[3])
+			}
+		}
+	})
+
+	t.Run("workflow commands without output file", func(t *testing.T) {
+		var stdout bytes.Buffer
+		require.NoError(t, GitHubActionsExporter{Stdout: &stdout}.Export(outputs))
+		require.Equal(t, `::set-output name=BITRISE_DEVELOPER_TEAM::TEAM123
+::set-output name=BITRISE_EXPORT_METHOD::app-store
+::set-output name=MULTILINE::first%0Asecond
+`, stdout.String())
+	})
+}
+
+func TestNewExporter(t *testing.T) {
+	_, err := NewExporter(Dotenv, "")
+	require.Error(t, err)
+
+	_, err = NewExporter("unknown", "")
+	require.Error(t, err)
+
+	exporter, err := NewExporter("", "")
+	require.NoError(t, err)
+	require.Equal(t, EnvmanExporter{}, exporter)
+}
@@ -0,0 +1,176 @@
+// Package provisioningserver implements the remote provisioning service mode of the step.
+//
+// The server holds the App Store Connect API key and forwards the App Store Connect API requests of the step,
+// authorizing them with a signed JWT token. This way the API key never needs to be present on the build machines,
+// the step authenticates on the server with a token.
+//
+// Only the App Store Connect API endpoints and methods used by the step are forwarded.
+// The client token is sent in plain text, so the server has to be served over TLS:
+// either with a certificate (ListenAndServeTLS) or behind a TLS terminating reverse proxy.
+package provisioningserver
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// allowedEndpoint is an App Store Connect API endpoint the step uses, a * segment matches any resource ID.
+type allowedEndpoint struct {
+	method string
+	path   string
+}
+
+var allowedEndpoints = []allowedEndpoint{
+	{http.MethodGet, appstoreconnect.BundleIDsEndpoint},
+	{http.MethodPost, appstoreconnect.BundleIDsEndpoint},
+	{http.MethodGet, appstoreconnect.BundleIDsEndpoint + "/*"},
+	{http.MethodGet, appstoreconnect.BundleIDsEndpoint + "/*/" + appstoreconnect.BundleIDCapabilitiesEndpoint},
+	{http.MethodGet, appstoreconnect.BundleIDsEndpoint + "/*/" + appstoreconnect.ProfilesEndpoint},
+	{http.MethodPost, appstoreconnect.BundleIDCapabilitiesEndpoint},
+	{http.MethodPatch, appstoreconnect.BundleIDCapabilitiesEndpoint + "/*"},
+	{http.MethodDelete, appstoreconnect.BundleIDCapabilitiesEndpoint + "/*"},
+	{http.MethodGet, appstoreconnect.CertificatesEndpoint},
+	{http.MethodPost, appstoreconnect.CertificatesEndpoint},
+	{http.MethodGet, appstoreconnect.DevicesEndpoint},
+	{http.MethodPost, appstoreconnect.DevicesEndpoint},
+	{http.MethodGet, appstoreconnect.ProfilesEndpoint},
+	{http.MethodPost, appstoreconnect.ProfilesEndpoint},
+	{http.MethodDelete, appstoreconnect.ProfilesEndpoint + "/*"},
+	{http.MethodGet, appstoreconnect.ProfilesEndpoint + "/*/bundleId"},
+	{http.MethodGet, appstoreconnect.ProfilesEndpoint + "/*/" + appstoreconnect.CertificatesEndpoint},
+	{http.MethodGet, appstoreconnect.ProfilesEndpoint + "/*/" + appstoreconnect.DevicesEndpoint},
+}
+
+// allowed returns true if the request targets an App Store Connect API endpoint the step uses.
+func allowed(method, pth string) bool {
+	if !strings.HasPrefix(pth, "/v1/") {
+		return false
+	}
+	segments := strings.Split(strings.TrimPrefix(pth, "/v1/"), "/")
+
+	for _, endpoint := range allowedEndpoints {
+		if endpoint.method != method {
+			continue
+		}
+
+		patternSegments := strings.Split(endpoint.path, "/")
+		if len(patternSegments) != len(segments) {
+			continue
+		}
+
+		match := true
+		for i, pattern := range patternSegments {
+			if segments[i] == "" || (pattern != "*" && pattern != segments[i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// Server forwards the authenticated requests to the App Store Connect API
+type Server struct {
+	token string
+
+	client   *appstoreconnect.Client
+	upstream *url.URL
+	proxy    *httputil.ReverseProxy
+}
+
+// New creates a new Server, which authorizes the App Store Connect API requests with the given API key,
+// clients have to authenticate with the given token.
+func New(keyID, issuerID string, privateKey []byte, token string) (*Server, error) {
+	if token == "" {
+		return nil, fmt.Errorf("no client token provided")
+	}
+
+	client := appstoreconnect.NewClient(http.DefaultClient, keyID, issuerID, privateKey)
+
+	s := &Server{
+		token:    token,
+		client:   client,
+		upstream: client.BaseURL,
+	}
+	s.proxy = &httputil.ReverseProxy{
+		Director: s.direct,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Warnf("Failed to forward request (%s %s): %s", r.Method, r.URL.Path, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return s, nil
+}
+
+// SetUpstream overrides the App Store Connect API url, requests are forwarded to.
+func (s *Server) SetUpstream(u *url.URL) {
+	s.upstream = u
+}
+
+func (s *Server) direct(req *http.Request) {
+	req.URL.Scheme = s.upstream.Scheme
+	req.URL.Host = s.upstream.Host
+	req.Host = s.upstream.Host
+}
+
+// authorize replaces the client token of the request with a signed App Store Connect API JWT token.
+func (s *Server) authorize(req *http.Request) error {
+	req.Header.Del("Authorization")
+
+	// The JWT token is cached and renewed by the client, guarded by its lock, the concurrent requests share the token.
+	return s.client.Authorize(req)
+}
+
+func (s *Server) authenticated(req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// ServeHTTP implements the http.Handler interface
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !s.authenticated(req) {
+		log.Warnf("Unauthenticated request: %s %s", req.Method, req.URL.Path)
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+
+	if !allowed(req.Method, req.URL.Path) {
+		log.Warnf("Forbidden request: %s %s", req.Method, req.URL.Path)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	// The request is not forwarded without the API key's token, the upstream would reject it anyway.
+	if err := s.authorize(req); err != nil {
+		log.Errorf("Failed to authorize request (%s %s): %s", req.Method, req.URL.Path, err)
+		http.Error(w, "failed to authorize request", http.StatusBadGateway)
+		return
+	}
+
+	log.Printf("%s %s", req.Method, req.URL.Path)
+	s.proxy.ServeHTTP(w, req)
+}
+
+// ListenAndServe starts serving plain HTTP on the given address,
+// the server has to sit behind a TLS terminating reverse proxy.
+func (s *Server) ListenAndServe(addr string) error {
+	log.Warnf("Provisioning server listening on %s without TLS, it has to sit behind a TLS terminating reverse proxy", addr)
+	return http.ListenAndServe(addr, s)
+}
+
+// ListenAndServeTLS starts serving HTTPS on the given address, with the given certificate and private key files.
+func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
+	log.Infof("Provisioning server listening on %s", addr)
+	return http.ListenAndServeTLS(addr, certFile, keyFile, s)
+}
@@ -0,0 +1,134 @@
+package provisioningserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/stretchr/testify/require"
+)
+
+func testPrivateKey(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})
+}
+
+func TestServer(t *testing.T) {
+	var upstreamAuthorization, upstreamPath string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuthorization = r.Header.Get("Authorization")
+		upstreamPath = r.URL.Path
+		_, err := w.Write([]byte(`{"data":[{"id":"device-id","type":"devices"}]}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	server, err := New("key-id", "issuer-id", testPrivateKey(t), "client-token")
+	require.NoError(t, err)
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	server.SetUpstream(upstreamURL)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	t.Run("authenticated client", func(t *testing.T) {
+		client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, ts.URL, "client-token")
+		require.NoError(t, err)
+
+		resp, err := client.Provisioning.ListDevices(&appstoreconnect.ListDevicesOptions{})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(resp.Data))
+		require.Equal(t, "device-id", resp.Data[0].ID)
+
+		require.Equal(t, "/v1/devices", upstreamPath)
+		require.NotEqual(t, "Bearer client-token", upstreamAuthorization)
+		require.Regexp(t, `^Bearer .+\..+\..+$`, upstreamAuthorization)
+	})
+
+	t.Run("unauthenticated client", func(t *testing.T) {
+		client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, ts.URL, "wrong-token")
+		require.NoError(t, err)
+
+		_, err = client.Provisioning.ListDevices(&appstoreconnect.ListDevicesOptions{})
+		require.Error(t, err)
+
+		respErr, ok := err.(*appstoreconnect.ErrorResponse)
+		require.True(t, ok)
+		require.Equal(t, http.StatusUnauthorized, respErr.Response.StatusCode)
+	})
+}
+
+func TestServer_forbiddenEndpoints(t *testing.T) {
+	forwarded := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = true
+	}))
+	defer upstream.Close()
+
+	server, err := New("key-id", "issuer-id", testPrivateKey(t), "client-token")
+	require.NoError(t, err)
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	server.SetUpstream(upstreamURL)
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/v1/users"},
+		{http.MethodDelete, "/v1/bundleIds/bundle-id"},
+		{http.MethodPatch, "/v1/devices/device-id"},
+		{http.MethodGet, "/v1/profiles/profile-id/bundleId/extra"},
+		{http.MethodGet, "/v1/bundleIds//profiles"},
+		{http.MethodGet, "/v2/devices"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer client-token")
+		rec := httptest.NewRecorder()
+
+		server.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusForbidden, rec.Code, tt.method+" "+tt.path)
+	}
+	require.False(t, forwarded)
+}
+
+func TestServer_authorizationFailure(t *testing.T) {
+	forwarded := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = true
+	}))
+	defer upstream.Close()
+
+	server, err := New("key-id", "issuer-id", []byte("invalid private key"), "client-token")
+	require.NoError(t, err)
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	server.SetUpstream(upstreamURL)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/devices", nil)
+	req.Header.Set("Authorization", "Bearer client-token")
+	rec := httptest.NewRecorder()
+
+	server.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	require.False(t, forwarded, "the request is not forwarded unauthenticated")
+}
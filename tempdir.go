@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// RunTempDir is the per-run directory of the temporary files (like the certificates exported for the keychain import).
+// It is accessible by the current user only and removed when the Step exits, unless it is kept for debugging.
+type RunTempDir struct {
+	Path string
+	Keep bool
+
+	once sync.Once
+}
+
+// NewRunTempDir creates a new per-run temporary directory under the given parent dir (the system temp dir if empty).
+func NewRunTempDir(parent string, keep bool) (*RunTempDir, error) {
+	pth, err := ioutil.TempDir(parent, "auto-provision-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %s", err)
+	}
+	// ioutil.TempDir already creates the dir with 0700, but umask independent permissions are ensured
+	if err := os.Chmod(pth, 0700); err != nil {
+		return nil, fmt.Errorf("failed to set temporary directory (%s) permissions: %s", pth, err)
+	}
+
+	return &RunTempDir{Path: pth, Keep: keep}, nil
+}
+
+// Cleanup removes the directory with its content, it is safe to call multiple times.
+func (d *RunTempDir) Cleanup() {
+	if d == nil {
+		return
+	}
+
+	d.once.Do(func() {
+		if d.Keep {
+			log.Warnf("Keeping temporary directory, it might contain key material: %s", d.Path)
+			return
+		}
+
+		if err := os.RemoveAll(d.Path); err != nil {
+			log.Warnf("Failed to remove temporary directory (%s): %s", d.Path, err)
+		}
+	})
+}
+
+// CleanupOnSignal cleans up the directory and exits if the Step is interrupted or terminated.
+func (d *RunTempDir) CleanupOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Warnf("Received %s, cleaning up", sig)
+		d.Cleanup()
+		os.Exit(1)
+	}()
+}
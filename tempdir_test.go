@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunTempDir(t *testing.T) {
+	parent, err := ioutil.TempDir("", "run-temp-dir")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(parent))
+	}()
+
+	tests := []struct {
+		name       string
+		keep       bool
+		wantExists bool
+	}{
+		{
+			name:       "removed on cleanup",
+			keep:       false,
+			wantExists: false,
+		},
+		{
+			name:       "kept for debugging",
+			keep:       true,
+			wantExists: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := NewRunTempDir(parent, tt.keep)
+			require.NoError(t, err)
+
+			info, err := os.Stat(dir.Path)
+			require.NoError(t, err)
+			require.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir.Path, "Certificate.p12"), []byte("key"), 0600))
+
+			dir.Cleanup()
+			dir.Cleanup()
+
+			_, err = os.Stat(dir.Path)
+			require.Equal(t, tt.wantExists, err == nil)
+		})
+	}
+}
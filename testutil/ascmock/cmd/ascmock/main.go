@@ -0,0 +1,45 @@
+// Command ascmock serves an in-memory App Store Connect API for integration testing.
+//
+// Usage:
+//
+//	ascmock -address :8080 -fixtures fixtures.json
+//
+// Set the step's provisioning server URL input to the server's URL (for example http://localhost:8080),
+// the fixtures file holds the JSON encoded ascmock.Fixtures, including the injected faults.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/testutil/ascmock"
+)
+
+func main() {
+	address := flag.String("address", ":8080", "the address to listen on")
+	fixturesPath := flag.String("fixtures", "", "path of the JSON encoded fixtures")
+	maintenance := flag.Int("maintenance", 0, "number of requests answered with a HTML maintenance page before serving")
+	flag.Parse()
+
+	var fixtures ascmock.Fixtures
+	if *fixturesPath != "" {
+		var err error
+		if fixtures, err = ascmock.ReadFixtures(*fixturesPath); err != nil {
+			log.Errorf("Failed to read fixtures: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	server := ascmock.New(fixtures)
+	if *maintenance > 0 {
+		server.AddFault(ascmock.MaintenanceFault(*maintenance))
+	}
+
+	log.Infof("Serving mock App Store Connect API on %s", *address)
+	if err := http.ListenAndServe(*address, server); err != nil {
+		log.Errorf("Mock App Store Connect API failed: %s", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,79 @@
+package ascmock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// Profile is a provisioning profile fixture with its relationships
+type Profile struct {
+	appstoreconnect.Profile
+
+	BundleIDID     string   `json:"bundleIdId"`
+	CertificateIDs []string `json:"certificateIds"`
+	DeviceIDs      []string `json:"deviceIds"`
+}
+
+// Fixtures is the Developer Portal state the server starts with
+type Fixtures struct {
+	BundleIDs    []appstoreconnect.BundleID    `json:"bundleIds"`
+	Certificates []appstoreconnect.Certificate `json:"certificates"`
+	Devices      []appstoreconnect.Device      `json:"devices"`
+	Profiles     []Profile                     `json:"profiles"`
+
+	// Capabilities holds the enabled capabilities by bundle ID ID
+	Capabilities map[string][]appstoreconnect.BundleIDCapability `json:"capabilities"`
+
+	// ProfileContent is returned as the content of the created profiles,
+	// set it to a signed profile if the tested tool parses the profile content.
+	ProfileContent []byte `json:"profileContent"`
+	// ProfileValidity is the validity of the created profiles, defaults to a year.
+	ProfileValidity time.Duration `json:"profileValidity"`
+
+	Faults []Fault `json:"faults"`
+}
+
+// ReadFixtures reads JSON encoded fixtures from a file
+func ReadFixtures(pth string) (Fixtures, error) {
+	b, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return Fixtures{}, err
+	}
+
+	var fixtures Fixtures
+	if err := json.Unmarshal(b, &fixtures); err != nil {
+		return Fixtures{}, fmt.Errorf("failed to parse fixtures (%s): %s", pth, err)
+	}
+	return fixtures, nil
+}
+
+// Fault makes the server fail the matching requests, simulating Apple outages and errors
+type Fault struct {
+	// Method and Path (a prefix of the request path, for example /v1/profiles) select the failing requests,
+	// empty values match every request.
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	StatusCode  int    `json:"statusCode"`
+	ContentType string `json:"contentType"`
+	Body        string `json:"body"`
+
+	// Delay is waited before responding, it can be used to simulate timeouts.
+	Delay time.Duration `json:"delay"`
+	// Times is the number of requests to fail, 0 fails every matching request.
+	Times int `json:"times"`
+}
+
+// MaintenanceFault returns a fault responding with an HTML maintenance page, like Apple does during outages
+func MaintenanceFault(times int) Fault {
+	return Fault{
+		StatusCode:  503,
+		ContentType: "text/html",
+		Body:        "<html><head><title>Service Unavailable</title></head><body>Scheduled maintenance</body></html>",
+		Times:       times,
+	}
+}
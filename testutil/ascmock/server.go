@@ -0,0 +1,770 @@
+// Package ascmock implements an in-memory App Store Connect API server for integration testing.
+//
+// The server implements the provisioning endpoints used by the step (bundle IDs, capabilities,
+// certificates, devices and profiles), starts from configurable fixtures and supports fault injection,
+// so pipelines can be tested end-to-end without touching Apple's servers.
+// Point the step's provisioning server URL input (or an appstoreconnect.NewRemoteClient) to the server.
+package ascmock
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// linkBase is the prefix of the relationship links, the client strips it before following a link.
+const linkBase = "https://api.appstoreconnect.apple.com/v1"
+
+// Server is an in-memory App Store Connect API
+type Server struct {
+	mu sync.Mutex
+
+	bundleIDs    []appstoreconnect.BundleID
+	capabilities map[string][]appstoreconnect.BundleIDCapability
+	certificates []appstoreconnect.Certificate
+	devices      []appstoreconnect.Device
+	profiles     []Profile
+
+	profileContent  []byte
+	profileValidity time.Duration
+
+	faults   []Fault
+	requests []string
+	nextID   int
+}
+
+// New creates a new Server with the given fixtures
+func New(fixtures Fixtures) *Server {
+	s := &Server{
+		bundleIDs:       append([]appstoreconnect.BundleID{}, fixtures.BundleIDs...),
+		capabilities:    map[string][]appstoreconnect.BundleIDCapability{},
+		certificates:    append([]appstoreconnect.Certificate{}, fixtures.Certificates...),
+		devices:         append([]appstoreconnect.Device{}, fixtures.Devices...),
+		profiles:        append([]Profile{}, fixtures.Profiles...),
+		profileContent:  fixtures.ProfileContent,
+		profileValidity: fixtures.ProfileValidity,
+		faults:          append([]Fault{}, fixtures.Faults...),
+	}
+	if s.profileValidity == 0 {
+		s.profileValidity = 365 * 24 * time.Hour
+	}
+	for id, capabilities := range fixtures.Capabilities {
+		s.capabilities[id] = append([]appstoreconnect.BundleIDCapability{}, capabilities...)
+	}
+	for i := range s.bundleIDs {
+		s.bundleIDs[i].Relationships = bundleIDRelationships(s.bundleIDs[i].ID)
+	}
+	for i := range s.profiles {
+		s.profiles[i].Profile = profileWithRelationships(s.profiles[i].Profile)
+	}
+
+	return s
+}
+
+// AddFault registers a new fault
+func (s *Server) AddFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, fault)
+}
+
+// Requests returns the served requests in `METHOD /path` format
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+// State returns the current Developer Portal state of the server
+func (s *Server) State() Fixtures {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	capabilities := map[string][]appstoreconnect.BundleIDCapability{}
+	for id, caps := range s.capabilities {
+		capabilities[id] = append([]appstoreconnect.BundleIDCapability{}, caps...)
+	}
+
+	return Fixtures{
+		BundleIDs:    append([]appstoreconnect.BundleID{}, s.bundleIDs...),
+		Certificates: append([]appstoreconnect.Certificate{}, s.certificates...),
+		Devices:      append([]appstoreconnect.Device{}, s.devices...),
+		Profiles:     append([]Profile{}, s.profiles...),
+		Capabilities: capabilities,
+	}
+}
+
+// ServeHTTP ...
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Relationship links are followed as `v1//bundleIds/...` by the client
+	pth := path.Clean("/" + r.URL.Path)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+pth)
+	fault, faulty := s.matchFault(r.Method, pth)
+	s.mu.Unlock()
+
+	if faulty {
+		time.Sleep(fault.Delay)
+		if fault.ContentType != "" {
+			w.Header().Set("Content-Type", fault.ContentType)
+		}
+		w.WriteHeader(fault.StatusCode)
+		if _, err := w.Write([]byte(fault.Body)); err != nil {
+			log.Warnf("Failed to write response: %s", err)
+		}
+		return
+	}
+
+	if !strings.HasPrefix(pth, "/v1/") {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("unknown path: %s", pth))
+		return
+	}
+	segments := strings.Split(strings.TrimPrefix(pth, "/v1/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && len(segments) == 1 && segments[0] == appstoreconnect.BundleIDsEndpoint:
+		s.listBundleIDs(w, r)
+	case r.Method == http.MethodPost && len(segments) == 1 && segments[0] == appstoreconnect.BundleIDsEndpoint:
+		s.createBundleID(w, r)
+	case r.Method == http.MethodGet && len(segments) == 2 && segments[0] == appstoreconnect.BundleIDsEndpoint:
+		s.getBundleID(w, segments[1])
+	case r.Method == http.MethodGet && len(segments) == 3 && segments[0] == appstoreconnect.BundleIDsEndpoint && segments[2] == appstoreconnect.BundleIDCapabilitiesEndpoint:
+		s.listBundleIDCapabilities(w, segments[1])
+	case r.Method == http.MethodGet && len(segments) == 3 && segments[0] == appstoreconnect.BundleIDsEndpoint && segments[2] == appstoreconnect.ProfilesEndpoint:
+		s.listBundleIDProfiles(w, r, segments[1])
+	case r.Method == http.MethodPost && len(segments) == 1 && segments[0] == appstoreconnect.BundleIDCapabilitiesEndpoint:
+		s.enableCapability(w, r)
+	case r.Method == http.MethodPatch && len(segments) == 2 && segments[0] == appstoreconnect.BundleIDCapabilitiesEndpoint:
+		s.updateCapability(w, r, segments[1])
+	case r.Method == http.MethodDelete && len(segments) == 2 && segments[0] == appstoreconnect.BundleIDCapabilitiesEndpoint:
+		s.disableCapability(w, segments[1])
+	case r.Method == http.MethodGet && len(segments) == 1 && segments[0] == appstoreconnect.CertificatesEndpoint:
+		s.listCertificates(w, r)
+	case r.Method == http.MethodPost && len(segments) == 1 && segments[0] == appstoreconnect.CertificatesEndpoint:
+		s.createCertificate(w, r)
+	case r.Method == http.MethodGet && len(segments) == 1 && segments[0] == appstoreconnect.DevicesEndpoint:
+		s.listDevices(w, r)
+	case r.Method == http.MethodPost && len(segments) == 1 && segments[0] == appstoreconnect.DevicesEndpoint:
+		s.registerDevice(w, r)
+	case r.Method == http.MethodGet && len(segments) == 1 && segments[0] == appstoreconnect.ProfilesEndpoint:
+		s.listProfiles(w, r)
+	case r.Method == http.MethodPost && len(segments) == 1 && segments[0] == appstoreconnect.ProfilesEndpoint:
+		s.createProfile(w, r)
+	case r.Method == http.MethodDelete && len(segments) == 2 && segments[0] == appstoreconnect.ProfilesEndpoint:
+		s.deleteProfile(w, segments[1])
+	case r.Method == http.MethodGet && len(segments) == 3 && segments[0] == appstoreconnect.ProfilesEndpoint:
+		s.getProfileRelationship(w, r, segments[1], segments[2])
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("unsupported endpoint: %s %s", r.Method, pth))
+	}
+}
+
+// matchFault returns the first active fault matching the request and consumes it.
+func (s *Server) matchFault(method, pth string) (Fault, bool) {
+	for i, fault := range s.faults {
+		if fault.Method != "" && !strings.EqualFold(fault.Method, method) {
+			continue
+		}
+		if fault.Path != "" && !strings.HasPrefix(pth, fault.Path) {
+			continue
+		}
+
+		if fault.Times > 0 {
+			s.faults[i].Times--
+			if s.faults[i].Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return fault, true
+	}
+	return Fault{}, false
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s%d", prefix, s.nextID)
+}
+
+//
+// Bundle IDs
+
+func bundleIDRelationships(id string) appstoreconnect.BundleIDRelationships {
+	return appstoreconnect.BundleIDRelationships{
+		Profiles:     appstoreconnect.RelationshipsLinks{Links: appstoreconnect.Links{Related: fmt.Sprintf("%s/bundleIds/%s/profiles", linkBase, id)}},
+		Capabilities: appstoreconnect.RelationshipsLinks{Links: appstoreconnect.Links{Related: fmt.Sprintf("%s/bundleIds/%s/bundleIdCapabilities", linkBase, id)}},
+	}
+}
+
+func (s *Server) findBundleID(id string) (appstoreconnect.BundleID, bool) {
+	for _, bundleID := range s.bundleIDs {
+		if bundleID.ID == id {
+			return bundleID, true
+		}
+	}
+	return appstoreconnect.BundleID{}, false
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) listBundleIDs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var bundleIDs []appstoreconnect.BundleID
+	for _, bundleID := range s.bundleIDs {
+		// filter[identifier] works as a like filter on the real API, comma separated values match any of them
+		if identifiers := query.Get("filter[identifier]"); identifiers != "" && !containsAny(bundleID.Attributes.Identifier, strings.Split(identifiers, ",")) {
+			continue
+		}
+		if name := query.Get("filter[name]"); name != "" && bundleID.Attributes.Name != name {
+			continue
+		}
+		if platform := query.Get("filter[platform]"); platform != "" && bundleID.Attributes.Platform != platform {
+			continue
+		}
+		bundleIDs = append(bundleIDs, bundleID)
+	}
+
+	start, end, links := page(r, len(bundleIDs))
+	writeJSON(w, http.StatusOK, appstoreconnect.BundleIdsResponse{Data: bundleIDs[start:end], Links: links})
+}
+
+func (s *Server) createBundleID(w http.ResponseWriter, r *http.Request) {
+	var req appstoreconnect.BundleIDCreateRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	for _, bundleID := range s.bundleIDs {
+		if bundleID.Attributes.Identifier == req.Data.Attributes.Identifier {
+			writeError(w, http.StatusConflict, "ENTITY_ERROR.ATTRIBUTE.INVALID", fmt.Sprintf("An App ID with Identifier '%s' is not available.", req.Data.Attributes.Identifier))
+			return
+		}
+	}
+
+	id := s.newID("BUNDLEID")
+	bundleID := appstoreconnect.BundleID{
+		Attributes: appstoreconnect.BundleIDAttributes{
+			Identifier: req.Data.Attributes.Identifier,
+			Name:       req.Data.Attributes.Name,
+			Platform:   string(req.Data.Attributes.Platform),
+		},
+		Relationships: bundleIDRelationships(id),
+		ID:            id,
+		Type:          "bundleIds",
+	}
+	s.bundleIDs = append(s.bundleIDs, bundleID)
+
+	writeJSON(w, http.StatusCreated, appstoreconnect.BundleIDResponse{Data: bundleID})
+}
+
+func (s *Server) getBundleID(w http.ResponseWriter, id string) {
+	bundleID, ok := s.findBundleID(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("There is no resource of type 'bundleIds' with id '%s'", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, appstoreconnect.BundleIDResponse{Data: bundleID})
+}
+
+func (s *Server) listBundleIDCapabilities(w http.ResponseWriter, id string) {
+	if _, ok := s.findBundleID(id); !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("There is no resource of type 'bundleIds' with id '%s'", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, appstoreconnect.BundleIDCapabilitiesResponse{Data: s.capabilities[id]})
+}
+
+func (s *Server) listBundleIDProfiles(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := s.findBundleID(id); !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("There is no resource of type 'bundleIds' with id '%s'", id))
+		return
+	}
+
+	var profiles []appstoreconnect.Profile
+	for _, profile := range s.profiles {
+		if profile.BundleIDID == id {
+			profiles = append(profiles, profile.Profile)
+		}
+	}
+
+	start, end, links := page(r, len(profiles))
+	writeJSON(w, http.StatusOK, appstoreconnect.ProfilesResponse{Data: profiles[start:end], Links: links})
+}
+
+//
+// Capabilities
+
+func (s *Server) enableCapability(w http.ResponseWriter, r *http.Request) {
+	var req appstoreconnect.BundleIDCapabilityCreateRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	bundleIDID := req.Data.Relationships.BundleID.Data.ID
+	if _, ok := s.findBundleID(bundleIDID); !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("There is no resource of type 'bundleIds' with id '%s'", bundleIDID))
+		return
+	}
+
+	capability := appstoreconnect.BundleIDCapability{
+		Attributes: appstoreconnect.BundleIDCapabilityAttributes{
+			CapabilityType: req.Data.Attributes.CapabilityType,
+			Settings:       req.Data.Attributes.Settings,
+		},
+		ID:   bundleIDID + "_" + string(req.Data.Attributes.CapabilityType),
+		Type: appstoreconnect.BundleIDCapabilitiesEndpoint,
+	}
+
+	capabilities := s.capabilities[bundleIDID]
+	for i, c := range capabilities {
+		if c.Attributes.CapabilityType == capability.Attributes.CapabilityType {
+			capabilities = append(capabilities[:i], capabilities[i+1:]...)
+			break
+		}
+	}
+	s.capabilities[bundleIDID] = append(capabilities, capability)
+
+	writeJSON(w, http.StatusCreated, appstoreconnect.BundleIDCapabilityResponse{Data: capability})
+}
+
+func (s *Server) updateCapability(w http.ResponseWriter, r *http.Request, id string) {
+	var req appstoreconnect.BundleIDCapabilityUpdateRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	for bundleIDID, capabilities := range s.capabilities {
+		for i, capability := range capabilities {
+			if capability.ID != id {
+				continue
+			}
+
+			capability.Attributes.CapabilityType = req.Data.Attributes.CapabilityType
+			capability.Attributes.Settings = req.Data.Attributes.Settings
+			s.capabilities[bundleIDID][i] = capability
+
+			writeJSON(w, http.StatusOK, appstoreconnect.BundleIDCapabilityResponse{Data: capability})
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("There is no resource of type 'bundleIdCapabilities' with id '%s'", id))
+}
+
+func (s *Server) disableCapability(w http.ResponseWriter, id string) {
+	for bundleIDID, capabilities := range s.capabilities {
+		for i, capability := range capabilities {
+			if capability.ID == id {
+				s.capabilities[bundleIDID] = append(capabilities[:i], capabilities[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("There is no resource of type 'bundleIdCapabilities' with id '%s'", id))
+}
+
+//
+// Certificates
+
+func (s *Server) listCertificates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var certificates []appstoreconnect.Certificate
+	for _, certificate := range s.certificates {
+		if serial := query.Get("filter[serialNumber]"); serial != "" && !strings.EqualFold(certificate.Attributes.SerialNumber, serial) {
+			continue
+		}
+		if certificateType := query.Get("filter[certificateType]"); certificateType != "" && string(certificate.Attributes.CertificateType) != certificateType {
+			continue
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	start, end, links := page(r, len(certificates))
+	writeJSON(w, http.StatusOK, appstoreconnect.CertificatesResponse{Data: certificates[start:end], Links: links})
+}
+
+// createCertificate issues a certificate for the public key of the certificate signing request,
+// signed by a throwaway issuer: the certificate is not trusted, but can be installed with its private key.
+func (s *Server) createCertificate(w http.ResponseWriter, r *http.Request) {
+	var req appstoreconnect.CertificateCreateRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	csrDER := []byte(req.Data.Attributes.CsrContent)
+	if block, _ := pem.Decode(csrDER); block != nil {
+		csrDER = block.Bytes
+	} else if decoded, err := base64.StdEncoding.DecodeString(req.Data.Attributes.CsrContent); err == nil {
+		csrDER = decoded
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		writeError(w, http.StatusConflict, "ENTITY_ERROR.ATTRIBUTE.INVALID", fmt.Sprintf("Invalid certificate signing request: %s", err))
+		return
+	}
+
+	issuerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		return
+	}
+	issuer := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Mock Apple Worldwide Developer Relations Certification Authority"}}
+
+	id := s.newID("CERT")
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(1000 + s.nextID)),
+		Subject:      csr.Subject,
+		NotBefore:    now,
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	content, err := x509.CreateCertificate(rand.Reader, template, issuer, csr.PublicKey, issuerKey)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		return
+	}
+
+	certificate := appstoreconnect.Certificate{
+		ID:   id,
+		Type: appstoreconnect.CertificatesEndpoint,
+		Attributes: appstoreconnect.CertificateAttributes{
+			CertificateContent: content,
+			DisplayName:        csr.Subject.CommonName,
+			ExpirationDate:     template.NotAfter.Format(time.RFC3339),
+			Name:               csr.Subject.CommonName,
+			SerialNumber:       strings.ToUpper(template.SerialNumber.Text(16)),
+			CertificateType:    req.Data.Attributes.CertificateType,
+		},
+	}
+	s.certificates = append(s.certificates, certificate)
+
+	writeJSON(w, http.StatusCreated, appstoreconnect.CertificateResponse{Data: certificate})
+}
+
+//
+// Devices
+
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var devices []appstoreconnect.Device
+	for _, device := range s.devices {
+		if udid := query.Get("filter[udid]"); udid != "" && device.Attributes.UDID != udid {
+			continue
+		}
+		if platform := query.Get("filter[platform]"); platform != "" && string(device.Attributes.Platform) != platform {
+			continue
+		}
+		if status := query.Get("filter[status]"); status != "" && string(device.Attributes.Status) != status {
+			continue
+		}
+		devices = append(devices, device)
+	}
+
+	start, end, links := page(r, len(devices))
+	writeJSON(w, http.StatusOK, appstoreconnect.DevicesResponse{Data: devices[start:end], Links: links})
+}
+
+// normalizeUDID mirrors the Developer Portal, which rejects the registration of a UDID in a different case or hyphenation as a duplicate
+func normalizeUDID(udid string) string {
+	return strings.ToLower(strings.Replace(udid, "-", "", -1))
+}
+
+func (s *Server) registerDevice(w http.ResponseWriter, r *http.Request) {
+	var req appstoreconnect.DeviceCreateRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	for _, device := range s.devices {
+		if normalizeUDID(device.Attributes.UDID) == normalizeUDID(req.Data.Attributes.UDID) {
+			writeError(w, http.StatusConflict, "ENTITY_ERROR.ATTRIBUTE.INVALID", fmt.Sprintf("A device with number '%s' already exists on this team.", req.Data.Attributes.UDID))
+			return
+		}
+	}
+
+	device := appstoreconnect.Device{
+		Type: appstoreconnect.DevicesEndpoint,
+		ID:   s.newID("DEVICE"),
+		Attributes: appstoreconnect.DeviceAttributes{
+			DeviceClass: appstoreconnect.Iphone,
+			Name:        req.Data.Attributes.Name,
+			Platform:    req.Data.Attributes.Platform,
+			Status:      appstoreconnect.Enabled,
+			UDID:        req.Data.Attributes.UDID,
+			AddedDate:   time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	s.devices = append(s.devices, device)
+
+	writeJSON(w, http.StatusCreated, appstoreconnect.DeviceResponse{Data: device})
+}
+
+//
+// Profiles
+
+func profileWithRelationships(profile appstoreconnect.Profile) appstoreconnect.Profile {
+	profile.Relationships.BundleID.Links.Related = fmt.Sprintf("%s/profiles/%s/bundleId", linkBase, profile.ID)
+	profile.Relationships.Certificates.Links.Related = fmt.Sprintf("%s/profiles/%s/certificates", linkBase, profile.ID)
+	profile.Relationships.Devices.Links.Related = fmt.Sprintf("%s/profiles/%s/devices", linkBase, profile.ID)
+	return profile
+}
+
+func (s *Server) findProfile(id string) (Profile, bool) {
+	for _, profile := range s.profiles {
+		if profile.ID == id {
+			return profile, true
+		}
+	}
+	return Profile{}, false
+}
+
+func (s *Server) listProfiles(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var profiles []appstoreconnect.Profile
+	for _, profile := range s.profiles {
+		if name := query.Get("filter[name]"); name != "" && profile.Attributes.Name != name {
+			continue
+		}
+		if profileType := query.Get("filter[profileType]"); profileType != "" && string(profile.Attributes.ProfileType) != profileType {
+			continue
+		}
+		if state := query.Get("filter[profileState]"); state != "" && string(profile.Attributes.ProfileState) != state {
+			continue
+		}
+		profiles = append(profiles, profile.Profile)
+	}
+
+	start, end, links := page(r, len(profiles))
+	writeJSON(w, http.StatusOK, appstoreconnect.ProfilesResponse{Data: profiles[start:end], Links: links})
+}
+
+func (s *Server) createProfile(w http.ResponseWriter, r *http.Request) {
+	var req appstoreconnect.ProfileCreateRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	bundleIDID := req.Data.Relationships.BundleID.Data.ID
+	bundleID, ok := s.findBundleID(bundleIDID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("There is no resource of type 'bundleIds' with id '%s'", bundleIDID))
+		return
+	}
+
+	// iOS app IDs are shared by the iOS and tvOS profiles, macOS profiles need a macOS or universal app ID
+	macProfile := strings.HasPrefix(string(req.Data.Attributes.ProfileType), "MAC_")
+	switch appstoreconnect.BundleIDPlatform(bundleID.Attributes.Platform) {
+	case appstoreconnect.IOS, appstoreconnect.MacOS:
+		if macProfile != (appstoreconnect.BundleIDPlatform(bundleID.Attributes.Platform) == appstoreconnect.MacOS) {
+			writeError(w, http.StatusConflict, "ENTITY_ERROR.RELATIONSHIP.INVALID", fmt.Sprintf("The bundle ID '%s' does not support the profile type '%s'.", bundleID.Attributes.Identifier, req.Data.Attributes.ProfileType))
+			return
+		}
+	}
+
+	for _, profile := range s.profiles {
+		if profile.Attributes.Name == req.Data.Attributes.Name {
+			writeError(w, http.StatusConflict, "ENTITY_ERROR.ATTRIBUTE.INVALID", "Multiple profiles found with the name '"+req.Data.Attributes.Name+"'.  Please remove the duplicate profiles and try again.")
+			return
+		}
+	}
+
+	var certificateIDs []string
+	for _, certificate := range req.Data.Relationships.Certificates.Data {
+		certificateIDs = append(certificateIDs, certificate.ID)
+	}
+	var deviceIDs []string
+	for _, device := range req.Data.Relationships.Devices.Data {
+		deviceIDs = append(deviceIDs, device.ID)
+	}
+
+	id := s.newID("PROFILE")
+	profile := Profile{
+		Profile: profileWithRelationships(appstoreconnect.Profile{
+			Attributes: appstoreconnect.ProfileAttributes{
+				Name:           req.Data.Attributes.Name,
+				Platform:       appstoreconnect.BundleIDPlatform(bundleID.Attributes.Platform),
+				ProfileContent: s.profileContent,
+				UUID:           fmt.Sprintf("00000000-0000-0000-0000-%012d", s.nextID),
+				CreatedDate:    time.Now().UTC().Format(time.RFC3339),
+				ProfileState:   appstoreconnect.Active,
+				ProfileType:    req.Data.Attributes.ProfileType,
+				ExpirationDate: appstoreconnect.Time(time.Now().Add(s.profileValidity)),
+			},
+			ID: id,
+		}),
+		BundleIDID:     bundleIDID,
+		CertificateIDs: certificateIDs,
+		DeviceIDs:      deviceIDs,
+	}
+	s.profiles = append(s.profiles, profile)
+
+	writeJSON(w, http.StatusCreated, appstoreconnect.ProfileResponse{Data: profile.Profile})
+}
+
+func (s *Server) deleteProfile(w http.ResponseWriter, id string) {
+	for i, profile := range s.profiles {
+		if profile.ID == id {
+			s.profiles = append(s.profiles[:i], s.profiles[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("There is no resource of type 'profiles' with id '%s'", id))
+}
+
+func (s *Server) getProfileRelationship(w http.ResponseWriter, r *http.Request, id, relationship string) {
+	profile, ok := s.findProfile(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("There is no resource of type 'profiles' with id '%s'", id))
+		return
+	}
+
+	switch relationship {
+	case "bundleId":
+		s.getBundleID(w, profile.BundleIDID)
+	case appstoreconnect.CertificatesEndpoint:
+		var certificates []appstoreconnect.Certificate
+		for _, certificate := range s.certificates {
+			if contains(profile.CertificateIDs, certificate.ID) {
+				certificates = append(certificates, certificate)
+			}
+		}
+		start, end, links := page(r, len(certificates))
+		writeJSON(w, http.StatusOK, appstoreconnect.CertificatesResponse{Data: certificates[start:end], Links: links})
+	case appstoreconnect.DevicesEndpoint:
+		var devices []appstoreconnect.Device
+		for _, device := range s.devices {
+			if contains(profile.DeviceIDs, device.ID) {
+				devices = append(devices, device)
+			}
+		}
+		start, end, links := page(r, len(devices))
+		writeJSON(w, http.StatusOK, appstoreconnect.DevicesResponse{Data: devices[start:end], Links: links})
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("unsupported profile relationship: %s", relationship))
+	}
+}
+
+//
+// Helpers
+
+// page returns the bounds of the requested page, the cursor is the offset of the page.
+func page(r *http.Request, count int) (start, end int, links appstoreconnect.PagedDocumentLinks) {
+	query := r.URL.Query()
+
+	start, err := strconv.Atoi(query.Get("cursor"))
+	if err != nil || start < 0 || start > count {
+		start = 0
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		return start, count, links
+	}
+
+	end = start + limit
+	if end >= count {
+		return start, count, links
+	}
+
+	next := url.URL{Scheme: "https", Host: "api.appstoreconnect.apple.com", Path: r.URL.Path}
+	query.Set("cursor", strconv.Itoa(end))
+	next.RawQuery = query.Encode()
+	links.Next = next.String()
+
+	return start, end, links
+}
+
+func contains(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	b, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "PARAMETER_ERROR.INVALID", fmt.Sprintf("invalid request body: %s", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNEXPECTED_ERROR", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		log.Warnf("Failed to write response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, detail string) {
+	errorResponse := appstoreconnect.ErrorResponse{
+		Errors: []appstoreconnect.ErrorResponseError{{
+			Code:   code,
+			Status: strconv.Itoa(status),
+			Title:  http.StatusText(status),
+			Detail: detail,
+		}},
+	}
+	b, err := json.Marshal(errorResponse)
+	if err != nil {
+		b = []byte(`{"errors":[]}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		log.Warnf("Failed to write response: %s", err)
+	}
+}
@@ -0,0 +1,332 @@
+package ascmock
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/autoprovision"
+	"github.com/stretchr/testify/require"
+)
+
+func newClient(t *testing.T, server *Server) *appstoreconnect.Client {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	client, err := appstoreconnect.NewRemoteClient(http.DefaultClient, httpServer.URL, "token")
+	require.NoError(t, err)
+	return client
+}
+
+func TestServer_provisioning(t *testing.T) {
+	server := New(Fixtures{
+		Certificates: []appstoreconnect.Certificate{{
+			ID:         "CERT1",
+			Type:       "certificates",
+			Attributes: appstoreconnect.CertificateAttributes{SerialNumber: "1A2B", CertificateType: appstoreconnect.IOSDevelopment},
+		}},
+	})
+	client := newClient(t, server)
+
+	bundleID, err := autoprovision.FindBundleID(client, "io.bitrise.app")
+	require.NoError(t, err)
+	require.Nil(t, bundleID)
+
+	bundleID, err = autoprovision.CreateBundleID(client, "io.bitrise.app", autoprovision.IOS)
+	require.NoError(t, err)
+
+	found, err := autoprovision.FindBundleID(client, "io.bitrise.app")
+	require.NoError(t, err)
+	require.Equal(t, bundleID.ID, found.ID)
+
+	require.NoError(t, autoprovision.SyncBundleID(client, bundleID.ID, autoprovision.Entitlement{"aps-environment": "development"}))
+	require.NoError(t, autoprovision.CheckBundleIDEntitlements(client, *bundleID, autoprovision.Entitlement{"aps-environment": "development"}))
+
+	for i := 0; i < 25; i++ {
+		_, err := client.Provisioning.RegisterNewDevice(appstoreconnect.DeviceCreateRequest{
+			Data: appstoreconnect.DeviceCreateRequestData{
+				Attributes: appstoreconnect.DeviceCreateRequestDataAttributes{Name: "device", Platform: appstoreconnect.IOS, UDID: fmt.Sprintf("udid-%d", i)},
+				Type:       "devices",
+			},
+		})
+		require.NoError(t, err)
+	}
+	devices, err := autoprovision.ListDevices(client, "", appstoreconnect.IOSDevice)
+	require.NoError(t, err)
+	require.Equal(t, 25, len(devices), "devices are listed across pages")
+
+	name := "Bitrise iOS development - (io.bitrise.app)"
+	profile, err := autoprovision.CreateProfile(client, name, appstoreconnect.IOSAppDevelopment, *bundleID, []string{"CERT1"}, []string{devices[0].ID})
+	require.NoError(t, err)
+
+	found2, err := autoprovision.FindProfile(client, name, appstoreconnect.IOSAppDevelopment, "io.bitrise.app")
+	require.NoError(t, err)
+	require.Equal(t, profile.ID, found2.ID)
+
+	certificates, err := client.Provisioning.Certificates(profile.Relationships.Certificates.Links.Related, &appstoreconnect.PagingOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(certificates.Data))
+
+	profileBundleID, err := client.Provisioning.BundleID(profile.Relationships.BundleID.Links.Related)
+	require.NoError(t, err)
+	require.Equal(t, bundleID.ID, profileBundleID.Data.ID)
+
+	quota, err := autoprovision.FetchProfileQuota(client, *bundleID, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(quota.Profiles))
+	require.False(t, quota.NearLimit())
+
+	require.NoError(t, autoprovision.DeleteProfile(client, profile.ID))
+	require.Equal(t, 0, len(server.State().Profiles))
+}
+
+func TestServer_faults(t *testing.T) {
+	server := New(Fixtures{})
+	server.AddFault(Fault{Method: http.MethodGet, Path: "/v1/devices", StatusCode: http.StatusForbidden, Body: `{"errors":[{"code":"FORBIDDEN_ERROR"}]}`, Times: 1})
+	client := newClient(t, server)
+
+	_, err := client.Provisioning.ListDevices(&appstoreconnect.ListDevicesOptions{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "FORBIDDEN_ERROR")
+
+	_, err = client.Provisioning.ListDevices(&appstoreconnect.ListDevicesOptions{})
+	require.NoError(t, err, "the fault is consumed")
+
+	server.AddFault(MaintenanceFault(1))
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	resp, err := http.Get(httpServer.URL + "/v1/profiles")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "text/html", resp.Header.Get("Content-Type"))
+
+	require.Equal(t, []string{"GET /v1/devices", "GET /v1/devices", "GET /v1/profiles"}, server.Requests())
+}
+
+func TestServer_bundleIDMigration(t *testing.T) {
+	client := newClient(t, New(Fixtures{}))
+
+	oldBundleID, err := autoprovision.CreateBundleID(client, "io.bitrise.old", autoprovision.IOS)
+	require.NoError(t, err)
+	require.NoError(t, autoprovision.SyncBundleID(client, oldBundleID.ID, autoprovision.Entitlement{"aps-environment": "development"}))
+
+	migration := autoprovision.BundleIDMigration{Old: "io.bitrise.old", New: "io.bitrise.new"}
+	result, err := autoprovision.MigrateBundleID(client, migration, autoprovision.NewPortalChanges(0))
+	require.NoError(t, err)
+	require.Equal(t, "io.bitrise.new", result.BundleID.Attributes.Identifier)
+	require.NoError(t, autoprovision.CheckBundleIDEntitlements(client, result.BundleID, autoprovision.Entitlement{"aps-environment": "development"}))
+
+	_, err = autoprovision.MigrateBundleID(client, migration, autoprovision.NewPortalChanges(0))
+	require.NoError(t, err, "migration is idempotent")
+}
+
+func TestServer_sharedBundleIDProfiles(t *testing.T) {
+	server := New(Fixtures{
+		Certificates: []appstoreconnect.Certificate{{
+			ID:         "CERT1",
+			Type:       "certificates",
+			Attributes: appstoreconnect.CertificateAttributes{SerialNumber: "1A2B", CertificateType: appstoreconnect.IOSDistribution},
+		}},
+	})
+	client := newClient(t, server)
+
+	// the same bundle ID ships on iOS and tvOS
+	bundleID, err := autoprovision.CreateBundleID(client, "io.bitrise.shared", autoprovision.TVOS)
+	require.NoError(t, err)
+	require.Equal(t, string(appstoreconnect.IOS), bundleID.Attributes.Platform)
+
+	for _, platform := range []autoprovision.Platform{autoprovision.IOS, autoprovision.TVOS} {
+		require.True(t, autoprovision.BundleIDSupportsPlatform(*bundleID, platform))
+
+		profileType := autoprovision.PlatformToProfileTypeByDistribution[platform][autoprovision.AppStore]
+		name, err := autoprovision.ProfileName(profileType, "io.bitrise.shared")
+		require.NoError(t, err)
+		_, err = autoprovision.CreateProfile(client, name, profileType, *bundleID, []string{"CERT1"}, nil)
+		require.NoError(t, err)
+
+		found, err := autoprovision.FindProfile(client, name, profileType, "io.bitrise.shared")
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		require.Equal(t, profileType, found.Attributes.ProfileType)
+	}
+	require.Equal(t, 2, len(server.State().Profiles), "a profile per platform")
+	require.Equal(t, 1, len(server.State().BundleIDs), "the app ID is not recreated")
+
+	require.False(t, autoprovision.BundleIDSupportsPlatform(*bundleID, autoprovision.MacOS))
+	_, err = autoprovision.CreateProfile(client, "Bitrise macOS app-store - (io.bitrise.shared)", appstoreconnect.MacAppStore, *bundleID, []string{"CERT1"}, nil)
+	require.Error(t, err)
+}
+
+func TestServer_findBundleIDsInBatches(t *testing.T) {
+	var bundleIDs []appstoreconnect.BundleID
+	var identifiers []string
+	for i := 0; i < 12; i++ {
+		identifier := fmt.Sprintf("io.bitrise.app%d", i)
+		identifiers = append(identifiers, identifier)
+		if i%2 == 0 {
+			bundleIDs = append(bundleIDs, appstoreconnect.BundleID{
+				ID:         fmt.Sprintf("BUNDLEID%d", i),
+				Type:       "bundleIds",
+				Attributes: appstoreconnect.BundleIDAttributes{Identifier: identifier, Platform: string(appstoreconnect.IOS)},
+			})
+		}
+	}
+	// like match of io.bitrise.app1, which must not be returned for it
+	bundleIDs = append(bundleIDs, appstoreconnect.BundleID{
+		ID:         "BUNDLEID_LIKE",
+		Type:       "bundleIds",
+		Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.app1.widget", Platform: string(appstoreconnect.IOS)},
+	})
+
+	server := New(Fixtures{BundleIDs: bundleIDs})
+	client := newClient(t, server)
+
+	found, err := autoprovision.FindBundleIDs(client, identifiers)
+	require.NoError(t, err)
+	require.Equal(t, 6, len(found))
+	for i, identifier := range identifiers {
+		bundleID, ok := found[identifier]
+		require.Equal(t, i%2 == 0, ok, identifier)
+		if ok {
+			require.Equal(t, identifier, bundleID.Attributes.Identifier)
+		}
+	}
+	require.Equal(t, []string{"GET /v1/bundleIds", "GET /v1/bundleIds"}, server.Requests(), "a request per batch")
+}
+
+func TestServer_reconcileCapabilities(t *testing.T) {
+	capability := func(capabilityType appstoreconnect.CapabilityType) appstoreconnect.BundleIDCapability {
+		return appstoreconnect.BundleIDCapability{
+			ID:         "BUNDLEID_" + string(capabilityType),
+			Type:       appstoreconnect.BundleIDCapabilitiesEndpoint,
+			Attributes: appstoreconnect.BundleIDCapabilityAttributes{CapabilityType: capabilityType},
+		}
+	}
+	server := New(Fixtures{
+		BundleIDs: []appstoreconnect.BundleID{{
+			ID:         "BUNDLEID",
+			Type:       "bundleIds",
+			Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.app", Platform: string(appstoreconnect.IOS)},
+		}},
+		Capabilities: map[string][]appstoreconnect.BundleIDCapability{"BUNDLEID": {
+			capability(appstoreconnect.InAppPurchase),
+			capability(appstoreconnect.PushNotifications),
+			capability(appstoreconnect.AppGroups),
+			capability(appstoreconnect.Healthkit),
+			capability("WEATHERKIT"),
+		}},
+	})
+	client := newClient(t, server)
+
+	bundleID, err := autoprovision.FindBundleID(client, "io.bitrise.app")
+	require.NoError(t, err)
+
+	entitlements := autoprovision.Entitlement{"aps-environment": "production", "keychain-access-groups": []interface{}{"io.bitrise.app"}}
+	stale, err := autoprovision.FindStaleCapabilities(client, *bundleID, entitlements)
+	require.NoError(t, err)
+
+	var staleTypes []appstoreconnect.CapabilityType
+	for _, capability := range stale {
+		staleTypes = append(staleTypes, capability.Attributes.CapabilityType)
+		require.NoError(t, client.Provisioning.DisableCapability(capability.ID))
+	}
+	require.Equal(t, []appstoreconnect.CapabilityType{appstoreconnect.AppGroups, appstoreconnect.Healthkit}, staleTypes)
+
+	stale, err = autoprovision.FindStaleCapabilities(client, *bundleID, entitlements)
+	require.NoError(t, err)
+	require.Empty(t, stale)
+	require.Equal(t, 3, len(server.State().Capabilities["BUNDLEID"]))
+	require.Error(t, client.Provisioning.DisableCapability("BUNDLEID_"+string(appstoreconnect.Healthkit)))
+}
+
+func TestServer_certificateRotation(t *testing.T) {
+	server := New(Fixtures{})
+	client := newClient(t, server)
+
+	csr, privateKey, err := autoprovision.NewCertificateRequest(autoprovision.CertificateRequestOptions{})
+	require.NoError(t, err)
+	certificate, err := autoprovision.CreateCertificate(client, appstoreconnect.IOSDistribution, csr, privateKey)
+	require.NoError(t, err)
+	require.Equal(t, "Bitrise certificate rotation drill", certificate.Certificate.Certificate.Subject.CommonName)
+	require.NotNil(t, certificate.Certificate.PrivateKey)
+	require.Equal(t, certificate.Certificate.Certificate.PublicKey, certificate.Certificate.PrivateKey.(*rsa.PrivateKey).Public())
+
+	state := server.State()
+	require.Equal(t, 1, len(state.Certificates))
+	require.Equal(t, certificate.ID, state.Certificates[0].ID)
+	require.Equal(t, appstoreconnect.IOSDistribution, state.Certificates[0].Attributes.CertificateType)
+	require.Equal(t, certificate.Certificate.Certificate.Raw, state.Certificates[0].Attributes.CertificateContent)
+
+	p12Path := filepath.Join(t.TempDir(), "drill", "certificate.p12")
+	require.Error(t, autoprovision.ExportCertificate(certificate, p12Path, ""), "the private key is not exported unprotected")
+	require.NoError(t, autoprovision.ExportCertificate(certificate, p12Path, "passphrase"))
+	exported, err := certificateutil.CertificatesFromPKCS12File(p12Path, "passphrase")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(exported))
+	require.Equal(t, certificate.Certificate.Serial, exported[0].Serial)
+	require.Equal(t, certificate.Certificate.PrivateKey, exported[0].PrivateKey)
+
+	plan := autoprovision.RotationRollbackPlan{
+		CertificateType: appstoreconnect.IOSDistribution,
+		NewCertificate:  certificate,
+		Profiles:        []string{"Bitrise iOS app-store - (io.bitrise.app)"},
+		P12Path:         p12Path,
+	}
+	require.Contains(t, plan.String(), fmt.Sprintf("Revoke the new certificate (ID: %s)", certificate.ID))
+	require.Contains(t, plan.String(), "Bitrise iOS app-store - (io.bitrise.app)")
+	require.Contains(t, plan.String(), p12Path)
+}
+
+func TestServer_certificateRotationMissingRole(t *testing.T) {
+	server := New(Fixtures{})
+	server.AddFault(Fault{Method: http.MethodPost, Path: "/v1/certificates", StatusCode: http.StatusForbidden, Body: `{"errors":[{"code":"FORBIDDEN_ERROR"}]}`, Times: 1})
+	client := newClient(t, server)
+
+	csr, privateKey, err := autoprovision.NewCertificateRequest(autoprovision.CertificateRequestOptions{})
+	require.NoError(t, err)
+	_, err = autoprovision.CreateCertificate(client, appstoreconnect.IOSDistribution, csr, privateKey)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "creating certificates requires an API key with the Admin role")
+	require.Empty(t, server.State().Certificates)
+}
+
+func TestServer_certificateRotationCSROptions(t *testing.T) {
+	server := New(Fixtures{})
+	client := newClient(t, server)
+
+	csr, privateKey, err := autoprovision.NewCertificateRequest(autoprovision.CertificateRequestOptions{
+		CommonName:   "Security reviewed rotation",
+		EmailAddress: "security@example.com",
+		Organization: "Example Inc.",
+		KeyType:      autoprovision.ECP256Key,
+	})
+	require.NoError(t, err)
+	block, _ := pem.Decode(csr)
+	require.NotNil(t, block)
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(t, err)
+	require.Equal(t, "Security reviewed rotation", request.Subject.CommonName)
+	require.Equal(t, []string{"Example Inc."}, request.Subject.Organization)
+	require.Contains(t, request.Subject.String(), "security@example.com")
+	require.Equal(t, x509.ECDSA, request.PublicKeyAlgorithm)
+
+	certificate, err := autoprovision.CreateCertificate(client, appstoreconnect.IOSDevelopment, csr, privateKey)
+	require.NoError(t, err)
+	require.Equal(t, certificate.Certificate.Certificate.PublicKey, privateKey.(*ecdsa.PrivateKey).Public())
+
+	p12Path := filepath.Join(t.TempDir(), "certificate.p12")
+	require.NoError(t, autoprovision.ExportCertificate(certificate, p12Path, "passphrase"))
+
+	_, _, err = autoprovision.NewCertificateRequest(autoprovision.CertificateRequestOptions{KeyType: "dsa-1024"})
+	require.EqualError(t, err, "unsupported key type: dsa-1024")
+}